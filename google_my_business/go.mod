@@ -14,9 +14,9 @@ require (
 	github.com/jonboulle/clockwork v0.1.0 // indirect
 	github.com/pelletier/go-toml/v2 v2.0.6 // indirect
 	github.com/prometheus/tsdb v0.7.1 // indirect
-	github.com/sashabaranov/go-openai v1.37.0 // indirect
+	github.com/sashabaranov/go-openai v1.37.0
 	github.com/sendgrid/rest v2.6.9+incompatible // indirect
-	github.com/sendgrid/sendgrid-go v3.16.0+incompatible // indirect
+	github.com/sendgrid/sendgrid-go v3.16.0+incompatible
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/soheilhy/cmux v0.1.4 // indirect
 	github.com/spf13/afero v1.9.3 // indirect
@@ -27,7 +27,7 @@ require (
 	golang.org/x/oauth2 v0.2.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/resty.v1 v1.12.0 // indirect
-	gopkg.in/testfixtures.v2 v2.6.0 // indirect
+	gopkg.in/testfixtures.v2 v2.6.0
 	shared_templates v0.0.0
 )
 
@@ -2,17 +2,29 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 )
 
 // Clients and Transports are safe for concurrent use by multiple goroutines and for efficiency
 // should only be created once and re-used.
-var httpClient *http.Client
+// The TLS certificates are verified other than for the configs opting out (see insecureHTTPClient).
+var (
+	httpClient         *http.Client
+	insecureHTTPClient *http.Client
+	createClients      sync.Once
+)
 
 const (
 	maxIdleConnections    int           = 20
@@ -21,22 +33,267 @@ const (
 	timeout               time.Duration = time.Duration(10) * time.Second
 )
 
+// ErrCircuitOpen - the request was not sent as the circuit breaker of the provider URL is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Resilience - how long a request can take, how transient errors (transport errors, timeouts and 5xx responses) are
+// retried (see retryable) and when the circuit breaker of a provider URL opens
+type Resilience struct {
+	// Timeout - timeout of a request, ProviderTimeouts by provider (alternate message service e.g. Twilio)
+	Timeout          time.Duration
+	ProviderTimeouts map[string]time.Duration
+	// MaxRetries - retries of a transient error, the backoff before the first retry is doubled for each retry with
+	// jitter
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// BreakerFailures - consecutive failed requests to a provider URL opening its circuit breaker (0 disables), the
+	// requests fail straight away whilst open, a trial request is sent after the open period
+	BreakerFailures   int
+	BreakerOpenPeriod time.Duration
+}
+
+var resilience = Resilience{
+	Timeout:           timeout,
+	MaxRetries:        2,
+	RetryBackoff:      time.Duration(250) * time.Millisecond,
+	BreakerFailures:   5,
+	BreakerOpenPeriod: time.Duration(30) * time.Second,
+}
+
+// SetResilience - set the timeouts, retries and circuit breaker of the requests (see Resilience)
+func SetResilience(r Resilience) {
+	resilience = r
+}
+
+// breaker - circuit breaker of a provider URL
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = make(map[string]*breaker)
+)
+
+// breakerKey - the circuit breakers are by scheme and host of the provider URL
+func breakerKey(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// allowRequest - whether a request can be sent to the provider URL, once the open period of an open circuit breaker
+// has passed a single trial request is allowed
+func allowRequest(key string) bool {
+	if resilience.BreakerFailures <= 0 {
+		return true
+	}
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[key]
+	if !ok || b.failures < resilience.BreakerFailures {
+		return true
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// recordRequest - record whether the request to the provider URL failed, the circuit breaker opens after the
+// consecutive failures (or a failed trial request) and closes when a request succeeds
+func recordRequest(key string, failed bool) {
+	if resilience.BreakerFailures <= 0 {
+		return
+	}
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[key]
+	if !ok {
+		if !failed {
+			return
+		}
+		b = &breaker{}
+		breakers[key] = b
+	}
+	b.trial = false
+	if !failed {
+		delete(breakers, key)
+		return
+	}
+	b.failures++
+	if b.failures >= resilience.BreakerFailures {
+		if b.failures == resilience.BreakerFailures {
+			log.Printf("circuit breaker opened for %s after %d failures\n", key, b.failures)
+		}
+		b.openUntil = time.Now().Add(resilience.BreakerOpenPeriod)
+	}
+}
+
 // Reuse the connection
-func createHTTPClient() *http.Client {
+func createHTTPClient(insecureSkipVerify bool) *http.Client {
 	tr := &http.Transport{
 		MaxIdleConnsPerHost:   maxIdleConnections,
 		IdleConnTimeout:       idleConnTimeout,
 		ExpectContinueTimeout: expectContinueTimeout,
-		// Added for sending to servers with invalid certificate
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
-	client := &http.Client{Transport: tr, Timeout: timeout}
+	if insecureSkipVerify {
+		// for sending to servers with an invalid certificate (configs opting out of TLS verification)
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	// the timeout is set for each request (see requestTimeout)
+	return &http.Client{Transport: tr}
+}
+
+// getHTTPClient - the client verifying the TLS certificates unless skipped
+func getHTTPClient(insecureSkipVerify bool) *http.Client {
+	createClients.Do(func() {
+		httpClient = createHTTPClient(false)
+		insecureHTTPClient = createHTTPClient(true)
+	})
+	if insecureSkipVerify {
+		return insecureHTTPClient
+	}
+	return httpClient
+}
+
+// requestTimeout - timeout of a request to the provider
+func requestTimeout(provider string) time.Duration {
+	if t, ok := resilience.ProviderTimeouts[provider]; ok && t > 0 {
+		return t
+	}
+	if resilience.Timeout > 0 {
+		return resilience.Timeout
+	}
+	return timeout
+}
+
+// retryBackoff - backoff before the retry (1 for the first), doubled for each retry with jitter (half to the full
+// backoff) so retries to a provider are spread out
+func retryBackoff(retry int) time.Duration {
+	d := resilience.RetryBackoff << uint(retry-1)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// transientStatus - whether the HTTP status code is a transient error worth retrying
+func transientStatus(statusCode int) bool {
+	return statusCode >= 500 || statusCode == http.StatusTooManyRequests
+}
+
+// idempotent - whether the request can be sent again without side effects when its outcome is not known
+func idempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// retryable - whether the failed request can be retried, a request that is not idempotent (e.g. a POST sending an
+// SMS) is only retried when it was not received by the server (connecting failed) or the server rejected it without
+// processing it (429 and 503 responses), a timeout may have sent the message so is not retried
+func retryable(method string, statusCode int, err error) bool {
+	if idempotent(method) {
+		return err != nil || transientStatus(statusCode)
+	}
+	if err != nil {
+		return connectError(err)
+	}
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// connectError - whether the error is connecting to the server (e.g. connection refused or the host not found), the
+// request was not sent
+func connectError(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return (errors.As(err, &opErr) && opErr.Op == "dial") || errors.As(err, &dnsErr)
+}
+
+// do - send the request created by newRequest, retrying transient errors (transport errors, timeouts and 5xx
+// responses, see retryable for requests that are not idempotent). Returns the status code and body of the response,
+// an error is returned when the request still failed with a transient error (or the circuit breaker of the provider
+// URL is open) so it can be tried again later.
+func do(newRequest func() (*http.Request, error), provider string, insecureSkipVerify bool) (int, string, error) {
+	req, err := newRequest()
+	if err != nil {
+		log.Println(err)
+		return 0, "", nil
+	}
+	key := breakerKey(req.URL)
+	if !allowRequest(key) {
+		return 0, "", fmt.Errorf("%s: %w", key, ErrCircuitOpen)
+	}
 
-	return client
+	var statusCode int
+	var body string
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			time.Sleep(retryBackoff(retry))
+			if req, err = newRequest(); err != nil {
+				log.Println(err)
+				recordRequest(key, true)
+				return 0, "", err
+			}
+		}
+		statusCode, body, err = doOnce(req, provider, insecureSkipVerify)
+		if certificateError(err) {
+			// not transient, the server is reachable but its certificate is not valid
+			log.Printf("Error verifying the TLS certificate of %s (the config can opt out of TLS verification), error: %v\n", key, err)
+			recordRequest(key, true)
+			return 0, "", nil
+		}
+		if err == nil && !transientStatus(statusCode) {
+			recordRequest(key, false)
+			return statusCode, body, nil
+		}
+		if !retryable(req.Method, statusCode, err) {
+			// the request may have been processed (e.g. the SMS sent) so it is not sent again
+			log.Printf("Error sending to %s (attempt %d, not retried), error: %v, status: %d\n", key, retry+1, err, statusCode)
+			recordRequest(key, true)
+			return statusCode, body, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%s responded %d", key, statusCode)
+		}
+		log.Printf("Error sending to %s (attempt %d), error: %v\n", key, retry+1, err)
+		if retry >= resilience.MaxRetries {
+			recordRequest(key, true)
+			return statusCode, body, err
+		}
+	}
+}
+
+// certificateError - whether the error is the TLS certificate of the server not being valid
+func certificateError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateInvalidErr x509.CertificateInvalidError
+	return errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certificateInvalidErr)
+}
+
+// doOnce - send the request within the timeout of the provider returning the status code and body of the response
+func doOnce(req *http.Request, provider string, insecureSkipVerify bool) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout(provider))
+	defer cancel()
+	resp, err := getHTTPClient(insecureSkipVerify).Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, "", err
+	}
+	// close the connection to reuse it
+	defer resp.Body.Close()
+	txt, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Println(err)
+	}
+	return resp.StatusCode, string(txt), nil
 }
 
-// Send - send HTTP request
-func Send(sendURL string, method string, appKey string, secretKey string, apiToken string, params url.Values, body []byte, json bool, alternateMessageService string, alternateMessageSecret1 string) string {
+// Send - send HTTP request, the TLS certificate is not verified when insecureSkipVerify is set
+func Send(sendURL string, method string, appKey string, secretKey string, apiToken string, params url.Values, body []byte, json bool, alternateMessageService string, alternateMessageSecret1 string, insecureSkipVerify bool) string {
 	baseURL, err := url.Parse(sendURL)
 	if err != nil {
 		log.Println(err)
@@ -48,21 +305,30 @@ func Send(sendURL string, method string, appKey string, secretKey string, apiTok
 	}
 	// log.Println(baseURL)
 
-	var req *http.Request
-	if apiToken != "" {
-		// for Review Master SMS Gateway
-		req, _ = http.NewRequest(method, baseURL.String(), bytes.NewReader(body))
-		// set header
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Accept", "application/json")
-		req.Header.Set("api-token", apiToken)
-	} else {
+	newRequest := func() (*http.Request, error) {
+		var req *http.Request
+		var err error
+		if apiToken != "" {
+			// for Review Master SMS Gateway
+			req, err = http.NewRequest(method, baseURL.String(), bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			// set header
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("api-token", apiToken)
+			return req, nil
+		}
 		if json {
-			req, _ = http.NewRequest(method, baseURL.String(), bytes.NewReader(body))
+			req, err = http.NewRequest(method, baseURL.String(), bytes.NewReader(body))
 		} else if method == "GET" {
-			req, _ = http.NewRequest(method, baseURL.String(), nil)
+			req, err = http.NewRequest(method, baseURL.String(), nil)
 		} else {
-			req, _ = http.NewRequest(method, baseURL.String(), bytes.NewBufferString(params.Encode()))
+			req, err = http.NewRequest(method, baseURL.String(), bytes.NewBufferString(params.Encode()))
+		}
+		if err != nil {
+			return nil, err
 		}
 		if appKey != "" && secretKey != "" {
 			req.SetBasicAuth(appKey, secretKey)
@@ -83,32 +349,76 @@ func Send(sendURL string, method string, appKey string, secretKey string, apiTok
 		} else {
 			req.Header.Set("Content-Type", "text/plain")
 		}
+		return req, nil
 	}
 
-	if httpClient == nil {
-		httpClient = createHTTPClient()
+	statusCode, txt, err := do(newRequest, alternateMessageService, insecureSkipVerify)
+	if err != nil {
+		return ""
 	}
 
-	resp, err := httpClient.Do(req)
-	if resp != nil {
-		// close the connection to reuse it
-		defer resp.Body.Close()
+	// Veezu alternate message service should rely on HTTP 200 only for success, ignore the response message
+	if alternateMessageService == "Veezu" && statusCode == 200 {
+		return `{"success":"1"}`
 	}
+	return txt
+}
+
+// SendWithHeaders - send HTTP request with the headers given (used for send laters where the headers are stored), the
+// TLS certificate is not verified when insecureSkipVerify is set. An error is returned when the request still failed
+// with a transient error after the retries (e.g. a timeout or 5xx response) or the circuit breaker of the URL is open,
+// the request should then be sent again later.
+func SendWithHeaders(sendURL string, method string, appKey string, secretKey string, headers map[string]string, params url.Values, body []byte, alternateMessageService string, insecureSkipVerify bool) (string, error) {
+	newRequest := func() (*http.Request, error) {
+		return NewRequestWithHeaders(sendURL, method, appKey, secretKey, headers, params, body)
+	}
+	statusCode, txt, err := do(newRequest, alternateMessageService, insecureSkipVerify)
 	if err != nil {
-		// get here if HTTP response code is not 2xx
-		// fmt.Println(resp.StatusCode)
-		log.Println(err)
-		return ""
+		return txt, err
 	}
 
 	// Veezu alternate message service should rely on HTTP 200 only for success, ignore the response message
-	if alternateMessageService == "Veezu" && resp.StatusCode == 200 {
-		return `{"success":"1"}`
+	if alternateMessageService == "Veezu" && statusCode == 200 {
+		return `{"success":"1"}`, nil
 	}
+	return txt, nil
+}
 
-	txt, err := ioutil.ReadAll(resp.Body)
+// NewRequestWithHeaders - create the HTTP request sent by SendWithHeaders (also used to show the request that
+// would be sent when simulating)
+func NewRequestWithHeaders(sendURL string, method string, appKey string, secretKey string, headers map[string]string, params url.Values, body []byte) (*http.Request, error) {
+	baseURL, err := url.Parse(sendURL)
 	if err != nil {
-		log.Println(err)
+		return nil, err
+	}
+
+	if params != nil && method == "GET" {
+		baseURL.RawQuery = params.Encode()
+	}
+
+	var req *http.Request
+	if len(body) > 0 {
+		req, err = http.NewRequest(method, baseURL.String(), bytes.NewReader(body))
+	} else if method == "GET" {
+		req, err = http.NewRequest(method, baseURL.String(), nil)
+	} else {
+		req, err = http.NewRequest(method, baseURL.String(), bytes.NewBufferString(params.Encode()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if appKey != "" && secretKey != "" {
+		req.SetBasicAuth(appKey, secretKey)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if req.Header.Get("Content-Type") == "" {
+		if method == "POST" && len(body) == 0 {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req.Header.Set("Content-Type", "text/plain")
+		}
 	}
-	return string(txt)
+	return req, nil
 }
@@ -2,11 +2,14 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func testServer(success bool) *httptest.Server {
@@ -50,7 +53,7 @@ func TestSend(t *testing.T) {
 	// https://appmessages.veezu.co.uk/api/v1/sendmessage
 	// "https://test1.veezu.co.uk:8893/api/v1/sendmessage"
 	testURL := testServer.URL
-	resp := Send(testURL, "POST", "", "", "", params, nil, false, "", "")
+	resp := Send(testURL, "POST", "", "", "", params, nil, false, "", "", false)
 	fmt.Println("resp: ", resp)
 }
 
@@ -74,7 +77,7 @@ func TestSendLive(t *testing.T) {
 	// https://appmessages.veezu.co.uk/api/v1/sendmessage
 	// "https://test1.veezu.co.uk:8893/api/v1/sendmessage"
 	// resp := Send("https://appmessages.veezu.co.uk/api/v1/sendmessage", params)
-	resp := Send(testURL, "POST", "", "", "", params, nil, false, "", "")
+	resp := Send(testURL, "POST", "", "", "", params, nil, false, "", "", false)
 	fmt.Println("resp: ", resp)
 }
 
@@ -86,7 +89,7 @@ func TestSendLive2(t *testing.T) {
 	params.Add("t", testTelephone)
 	params.Add("m", "Your TEST Car is on its way!")
 
-	resp := Send(testURL, "POST", "", "", "", params, nil, false, "", "")
+	resp := Send(testURL, "POST", "", "", "", params, nil, false, "", "", false)
 	fmt.Println("resp: ", resp)
 }
 
@@ -97,30 +100,30 @@ func TestSendLive3(t *testing.T) {
 	params.Add("number", testTelephone)
 	params.Add("msg", "Your TEST Car is on its way!")
 
-	resp := Send(testAlphaURL, "GET", "", "", "", params, nil, false, "", "")
+	resp := Send(testAlphaURL, "GET", "", "", "", params, nil, false, "", "", false)
 	fmt.Println("resp: ", resp)
 }
 
 func TestSendRetrieveBooking1(t *testing.T) {
-	resp := Send(testIcabbiURL+"/bookings/index/"+testIcabbiTripID, "GET", testIcabbiAppKey, testIcabbiSecretKey, "", nil, nil, false, "", "")
+	resp := Send(testIcabbiURL+"/bookings/index/"+testIcabbiTripID, "GET", testIcabbiAppKey, testIcabbiSecretKey, "", nil, nil, false, "", "", false)
 	fmt.Println("resp: ", resp)
 }
 
 // Booking does not exist
 func TestSendRetrieveBooking2(t *testing.T) {
-	resp := Send(testIcabbiURL+"/bookings/index/12374975395936756", "GET", testIcabbiAppKey, testIcabbiSecretKey, "", nil, nil, false, "", "")
+	resp := Send(testIcabbiURL+"/bookings/index/12374975395936756", "GET", testIcabbiAppKey, testIcabbiSecretKey, "", nil, nil, false, "", "", false)
 	fmt.Println("resp: ", resp)
 }
 
 // Has a rating
 func TestSendCustomerRating1(t *testing.T) {
-	resp := Send(testIcabbiURL+"/customerrating/check?phone_number="+testIcabbiPhone, "GET", testIcabbiAppKey, testIcabbiSecretKey, "", nil, nil, false, "", "")
+	resp := Send(testIcabbiURL+"/customerrating/check?phone_number="+testIcabbiPhone, "GET", testIcabbiAppKey, testIcabbiSecretKey, "", nil, nil, false, "", "", false)
 	fmt.Println("resp: ", resp)
 }
 
 // User does not exist
 func TestSendCustomerRating2(t *testing.T) {
-	resp := Send(testIcabbiURL+"/customerrating/check?phone_number="+testIcabbiPhoneDoesNotExist, "GET", testIcabbiAppKey, testIcabbiSecretKey, "", nil, nil, false, "", "")
+	resp := Send(testIcabbiURL+"/customerrating/check?phone_number="+testIcabbiPhoneDoesNotExist, "GET", testIcabbiAppKey, testIcabbiSecretKey, "", nil, nil, false, "", "", false)
 	fmt.Println("resp: ", resp)
 }
 
@@ -133,7 +136,7 @@ func TestSendReviewMasterSMSGateway(t *testing.T) {
 	})
 	fmt.Println("body:", string(body))
 
-	resp := Send(testReviewMasterSMSGatewayURL, "POST", "", "", testReviewMasterSMSGatewayApiToken, nil, body, false, "", "")
+	resp := Send(testReviewMasterSMSGatewayURL, "POST", "", "", testReviewMasterSMSGatewayApiToken, nil, body, false, "", "", false)
 	fmt.Println("resp: ", resp)
 }
 
@@ -164,7 +167,7 @@ func TestSendMessageMediaSMSRestAPI(t *testing.T) {
 	// example successful response:
 	// {"messages":[{"callback_url":null,"delivery_report":true,"destination_number":"+447889525579","format":"SMS","message_expiry_timestamp":null,"message_flags":[],"message_id":"13d1a0ba-be11-401d-b4e8-7f27d8ccce99","metadata":null,"scheduled":null,"status":"queued","content":"testing","source_number":null,"rich_link":null,"media":null,"subject":null}]}
 	// uses basic authenticaion (therefore set appKey and secretKey) and set json to true
-	resp := Send("https://api.messagemedia.com/v1/messages", "POST", testMessageMediaApiKey, testMessageMediaApiSecret, "", nil, body, true, "Message Media", "")
+	resp := Send("https://api.messagemedia.com/v1/messages", "POST", testMessageMediaApiKey, testMessageMediaApiSecret, "", nil, body, true, "Message Media", "", false)
 	fmt.Println("resp: ", resp)
 }
 
@@ -184,7 +187,7 @@ func TestSendVeezuMessageAPI(t *testing.T) {
 	// example successful response:
 	// {"success":"1"}
 	// uses auth_token in header for authenticaion (therefore set alternate_message_service_secret1) and set json to true
-	resp := Send("https://messages.veezu.com/api/messages", "POST", "", "", "", nil, body, true, "Veezu", testAuthToken)
+	resp := Send("https://messages.veezu.com/api/messages", "POST", "", "", "", nil, body, true, "Veezu", testAuthToken, false)
 	fmt.Println("resp: ", resp)
 
 	// decode the response
@@ -197,3 +200,227 @@ func TestSendVeezuMessageAPI(t *testing.T) {
 		t.Fatalf("Error sending message for Veezu alternate message service response from send server: %+v", resp)
 	}
 }
+
+func TestSendWithHeaders(t *testing.T) {
+	// start a local HTTP server checking the headers sent
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Api-Token") != "abc123" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized"}`))
+			return
+		}
+		if req.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":{"detail":"Bad Request"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":76}`))
+	}))
+	defer testServer.Close()
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Accept":       "application/json",
+		"Api-Token":    "abc123",
+	}
+	body := []byte(`{"queue_id":"1","telephone":"+447123456789","message":"testing"}`)
+	resp, err := SendWithHeaders(testServer.URL, "POST", "", "", headers, nil, body, "", false)
+	if err != nil || resp != `{"id":76}` {
+		t.Fatalf("expected response {\"id\":76} got %s", resp)
+	}
+}
+
+func TestSendWithHeadersVeezu(t *testing.T) {
+	// start a local HTTP server which returns an empty response with HTTP 200
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	headers := map[string]string{
+		"auth_token":   "abc123",
+		"Content-Type": "application/json; charset=utf-8",
+		"Accept":       "application/json",
+	}
+	body := []byte(`{"message":"testing","telephone":"447123456789"}`)
+	resp, err := SendWithHeaders(testServer.URL, "POST", "", "", headers, nil, body, "Veezu", false)
+	if err != nil || resp != `{"success":"1"}` {
+		t.Fatalf("expected response {\"success\":\"1\"} got %s", resp)
+	}
+}
+
+// testResilience - quick retries and a circuit breaker opening after 2 failures for the tests
+func testResilience(t *testing.T) {
+	SetResilience(Resilience{
+		Timeout:           time.Duration(200) * time.Millisecond,
+		MaxRetries:        2,
+		RetryBackoff:      time.Millisecond,
+		BreakerFailures:   2,
+		BreakerOpenPeriod: time.Duration(50) * time.Millisecond,
+	})
+	t.Cleanup(func() {
+		SetResilience(Resilience{Timeout: timeout, MaxRetries: 2, RetryBackoff: time.Duration(250) * time.Millisecond,
+			BreakerFailures: 5, BreakerOpenPeriod: time.Duration(30) * time.Second})
+	})
+}
+
+// statusServer - test server responding with the status codes in turn (the last is repeated), returns the server and
+// the number of requests received
+func statusServer(statusCodes ...int) (*httptest.Server, *int32) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		if n > len(statusCodes) {
+			n = len(statusCodes)
+		}
+		w.WriteHeader(statusCodes[n-1])
+		fmt.Fprintf(w, `{"status":%d}`, statusCodes[n-1])
+	}))
+	return ts, &requests
+}
+
+func TestSendWithHeadersRetries(t *testing.T) {
+	testResilience(t)
+	ts, requests := statusServer(http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK)
+	defer ts.Close()
+
+	resp, err := SendWithHeaders(ts.URL, "GET", "", "", nil, url.Values{"m": {"testing"}}, nil, "", false)
+	if err != nil || resp != `{"status":200}` || atomic.LoadInt32(requests) != 3 {
+		t.Fatalf("expected sent after 2 retries got resp: %s, err: %v, requests: %d", resp, err, atomic.LoadInt32(requests))
+	}
+}
+
+func TestSendWithHeadersPostRetries(t *testing.T) {
+	testResilience(t)
+	ts, requests := statusServer(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	defer ts.Close()
+
+	// a POST rejected without being processed (503 and 429) is retried
+	resp, err := SendWithHeaders(ts.URL, "POST", "", "", nil, url.Values{"m": {"testing"}}, nil, "", false)
+	if err != nil || resp != `{"status":200}` || atomic.LoadInt32(requests) != 3 {
+		t.Fatalf("expected sent after 2 retries got resp: %s, err: %v, requests: %d", resp, err, atomic.LoadInt32(requests))
+	}
+
+	// a POST that may have been processed is not retried or queued
+	ts, requests = statusServer(http.StatusBadGateway, http.StatusOK)
+	defer ts.Close()
+	resp, err = SendWithHeaders(ts.URL, "POST", "", "", nil, url.Values{"m": {"testing"}}, nil, "", false)
+	if err != nil || resp != `{"status":502}` || atomic.LoadInt32(requests) != 1 {
+		t.Fatalf("expected a single request got resp: %s, err: %v, requests: %d", resp, err, atomic.LoadInt32(requests))
+	}
+}
+
+func TestSendWithHeadersConnectError(t *testing.T) {
+	testResilience(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	ts.Close()
+
+	// the POST was not sent (connection refused) so it is retried and queued
+	if _, err := SendWithHeaders(ts.URL, "POST", "", "", nil, nil, []byte(`{}`), "", false); err == nil {
+		t.Fatal("expected connection error")
+	}
+}
+
+func TestSendWithHeadersNotRetried(t *testing.T) {
+	testResilience(t)
+	ts, requests := statusServer(http.StatusBadRequest)
+	defer ts.Close()
+
+	// a 4xx response is returned to be interpreted by the sender, it is not transient
+	resp, err := SendWithHeaders(ts.URL, "POST", "", "", nil, nil, []byte(`{}`), "", false)
+	if err != nil || resp != `{"status":400}` || atomic.LoadInt32(requests) != 1 {
+		t.Fatalf("expected a single request got resp: %s, err: %v, requests: %d", resp, err, atomic.LoadInt32(requests))
+	}
+}
+
+func TestSendWithHeadersCircuitBreaker(t *testing.T) {
+	testResilience(t)
+	ts, requests := statusServer(http.StatusInternalServerError, http.StatusInternalServerError,
+		http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError,
+		http.StatusInternalServerError, http.StatusOK)
+	defer ts.Close()
+
+	// each send is tried 3 times, the circuit breaker opens after the 2nd failed send
+	for i := 0; i < 2; i++ {
+		resp, err := SendWithHeaders(ts.URL, "GET", "", "", nil, nil, nil, "", false)
+		if err == nil || resp != `{"status":500}` {
+			t.Fatalf("send %d: expected transient error got resp: %s, err: %v", i+1, resp, err)
+		}
+	}
+	if _, err := SendWithHeaders(ts.URL, "GET", "", "", nil, nil, nil, "", false); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected circuit breaker open got err: %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 6 {
+		t.Fatalf("expected no request whilst the circuit breaker is open got %d requests", n)
+	}
+
+	// the trial request after the open period closes the circuit breaker
+	time.Sleep(time.Duration(60) * time.Millisecond)
+	if resp, err := SendWithHeaders(ts.URL, "GET", "", "", nil, nil, nil, "", false); err != nil || resp != `{"status":200}` {
+		t.Fatalf("expected trial request sent got resp: %s, err: %v", resp, err)
+	}
+	if _, err := SendWithHeaders(ts.URL, "GET", "", "", nil, nil, nil, "", false); err != nil {
+		t.Fatalf("expected circuit breaker closed got err: %v", err)
+	}
+}
+
+func TestSendWithHeadersTimeout(t *testing.T) {
+	testResilience(t)
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(time.Duration(100) * time.Millisecond)
+		w.Write([]byte(`{"success":"1"}`))
+	}))
+	defer ts.Close()
+
+	// the provider timeout is used rather than the default timeout
+	SetResilience(Resilience{Timeout: time.Duration(200) * time.Millisecond,
+		ProviderTimeouts: map[string]time.Duration{"Veezu": time.Duration(20) * time.Millisecond}, MaxRetries: 1})
+	if _, err := SendWithHeaders(ts.URL, "GET", "", "", nil, nil, nil, "Veezu", false); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected the timeout to be retried once got %d requests", n)
+	}
+	// a POST that timed out may have been sent so it is not retried or queued
+	if resp, err := SendWithHeaders(ts.URL, "POST", "", "", nil, nil, []byte(`{}`), "Veezu", false); err != nil || resp != "" {
+		t.Fatalf("expected the timeout not retried got resp: %s, err: %v", resp, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("expected the POST timeout not to be retried got %d requests", n)
+	}
+	if resp, err := SendWithHeaders(ts.URL, "POST", "", "", nil, nil, []byte(`{}`), "", false); err != nil || resp != `{"success":"1"}` {
+		t.Fatalf("expected sent within the default timeout got resp: %s, err: %v", resp, err)
+	}
+}
+
+func TestSendWithHeadersTLSVerification(t *testing.T) {
+	testResilience(t)
+	var requests int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"success":"1"}`))
+	}))
+	defer ts.Close()
+
+	// the test server certificate is not trusted, it is not retried or queued
+	if resp, err := SendWithHeaders(ts.URL, "POST", "", "", nil, nil, []byte(`{}`), "", false); err != nil || resp != "" {
+		t.Fatalf("expected certificate error not sent got resp: %s, err: %v", resp, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("expected no request handled got %d", n)
+	}
+	if resp, err := SendWithHeaders(ts.URL, "POST", "", "", nil, nil, []byte(`{}`), "", true); err != nil || resp != `{"success":"1"}` {
+		t.Fatalf("expected sent skipping TLS verification got resp: %s, err: %v", resp, err)
+	}
+	// a certificate error is a failure of the circuit breaker
+	for i := 0; i < 2; i++ {
+		SendWithHeaders(ts.URL, "POST", "", "", nil, nil, []byte(`{}`), "", false)
+	}
+	if _, err := SendWithHeaders(ts.URL, "POST", "", "", nil, nil, []byte(`{}`), "", false); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected circuit breaker open got err: %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"log"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -29,6 +31,65 @@ type Config struct {
 	ReviewMasterSMSGatewayApiToken     string
 
 	BarredTelephonePrefixFile string
+	BarredReloadPeriod        int
+	BarredToken               string
+
+	SendLaterEnabled     bool
+	SendLaterPollPeriod  int
+	SendLaterBatchSize   int
+	SendLaterMaxAttempts int
+
+	ShortLinkBaseURL string
+
+	ConfigCacheTTL                int
+	DailySentCountReconcilePeriod int
+
+	ProcessedBookingTTL   int
+	ProcessedBookingLease int
+
+	SignatureReplayWindow   int
+	VerificationAlertPeriod int
+
+	MetricsToken      string
+	MetricsAllowedIPs []string
+
+	TwilioStatusCallbackURL string
+
+	MessageMediaDLRURL   string
+	MessageMediaDLRToken string
+
+	WhatsAppGraphURL       string
+	WhatsAppVerifyToken    string
+	WhatsAppAppSecret      string
+	WhatsAppFallbackExpiry int
+
+	EmailService            string
+	SMTPHost                string
+	SMTPPort                int
+	SMTPUsername            string
+	SMTPPassword            string
+	SendGridAPIKey          string
+	SendGridURL             string
+	EmailFromAddress        string
+	EmailFromName           string
+	EmailUnsubscribeBaseURL string
+	EmailUnsubscribeSecret  string
+
+	TelephoneHashKey    string
+	DataRetentionDays   int
+	DataRetentionPeriod int
+
+	ProviderTimeout          int
+	ProviderTimeouts         map[string]int
+	ProviderMaxRetries       int
+	ProviderRetryBackoff     int
+	CircuitBreakerFailures   int
+	CircuitBreakerOpenPeriod int
+
+	GatewaySendInterval int
+	GatewayMaxWait      int
+
+	SecretsKeyFile string
 }
 
 // ReadProperties - read the properties file
@@ -62,4 +123,157 @@ func ReadProperties() {
 	Conf.ReviewMasterSMSGatewayApiToken = viper.GetString("review_master_sms_gateway_api_token")
 
 	Conf.BarredTelephonePrefixFile = viper.Get("barred_telephone_prefix_file").(string)
+	// barred telephones (managed from google_reviews_ui) are reloaded from the database every period and when
+	// google_reviews_ui notifies a change, the barred token is used by send_sms to fetch the global barred telephones
+	viper.SetDefault("barred_reload_period", 60) // seconds
+	Conf.BarredReloadPeriod = viper.GetInt("barred_reload_period")
+	Conf.BarredToken = viper.GetString("barred_token")
+
+	// send later worker (sends the messages stored in the send laters table when a send delay is configured)
+	viper.SetDefault("send_later_enabled", false)
+	viper.SetDefault("send_later_poll_period", 30) // seconds
+	viper.SetDefault("send_later_batch_size", 50)
+	viper.SetDefault("send_later_max_attempts", 3)
+	Conf.SendLaterEnabled = viper.GetBool("send_later_enabled")
+	Conf.SendLaterPollPeriod = viper.GetInt("send_later_poll_period")
+	Conf.SendLaterBatchSize = viper.GetInt("send_later_batch_size")
+	Conf.SendLaterMaxAttempts = viper.GetInt("send_later_max_attempts")
+
+	// tracked short review links e.g. https://reviews.example.com (empty to send the review link as is)
+	Conf.ShortLinkBaseURL = viper.GetString("short_link_base_url")
+
+	// config cache, the configs from the token are cached (0 disables the cache) and invalidated when updated
+	// from google_reviews_ui, the daily sent counts are kept in memory and reconciled with the database
+	viper.SetDefault("config_cache_ttl", 60)                  // seconds
+	viper.SetDefault("daily_sent_count_reconcile_period", 60) // seconds
+	Conf.ConfigCacheTTL = viper.GetInt("config_cache_ttl")
+	Conf.DailySentCountReconcilePeriod = viper.GetInt("daily_sent_count_reconcile_period")
+
+	// processed bookings, a repeated delivery of a booking (same client and booking ID) within the ttl returns the
+	// original response rather than being processed again (0 disables), a booking is claimed whilst being processed for
+	// the lease so a booking not completed is processed again once the lease expires
+	viper.SetDefault("processed_booking_ttl", 48)    // hours
+	viper.SetDefault("processed_booking_lease", 120) // seconds
+	Conf.ProcessedBookingTTL = viper.GetInt("processed_booking_ttl")
+	Conf.ProcessedBookingLease = viper.GetInt("processed_booking_lease")
+
+	// request verification, signed requests (configs with a signing secret) are rejected when the timestamp is outside
+	// the replay window, failed verifications are alerted (logged) at most once per client and reason every alert period
+	viper.SetDefault("signature_replay_window", 300)   // seconds
+	viper.SetDefault("verification_alert_period", 900) // seconds
+	Conf.SignatureReplayWindow = viper.GetInt("signature_replay_window")
+	Conf.VerificationAlertPeriod = viper.GetInt("verification_alert_period")
+
+	// metrics (/metrics) are available with the metrics token or from the allowed IPs (comma separated IPs or CIDRs)
+	Conf.MetricsToken = viper.GetString("metrics_token")
+	Conf.MetricsAllowedIPs = splitList(viper.GetString("metrics_allowed_ips"))
+
+	// Twilio delivery status callback (the public URL of /twilio/status e.g. https://reviews.example.com/twilio/status,
+	// empty for no callbacks), it has to be the URL Twilio requests as it is used to verify the callback signature
+	Conf.TwilioStatusCallbackURL = viper.GetString("twilio_status_callback_url")
+
+	// Message Media delivery reports, the callback URL sent with each message (the public URL of /dlr/messagemedia
+	// e.g. https://reviews.example.com/dlr/messagemedia, empty for no delivery reports) has the DLR token added as the
+	// dlr_token parameter which is checked when the delivery report is received. The Review Master SMS Gateway
+	// delivery reports (/dlr/rmsg) are checked with the Review Master SMS Gateway api token.
+	Conf.MessageMediaDLRURL = viper.GetString("message_media_dlr_url")
+	Conf.MessageMediaDLRToken = viper.GetString("message_media_dlr_token")
+
+	// WhatsApp Cloud API, the messages are sent to <graph url>/<phone number ID>/messages. The webhook
+	// (/whatsapp/webhook) is verified with the verify token when subscribed and each notification is signed with
+	// the app secret. A held SMS fallback not released within the expiry is deleted (the WhatsApp message is taken
+	// as delivered).
+	viper.SetDefault("whatsapp_graph_url", "https://graph.facebook.com/v19.0")
+	Conf.WhatsAppGraphURL = viper.GetString("whatsapp_graph_url")
+	Conf.WhatsAppVerifyToken = viper.GetString("whatsapp_verify_token")
+	Conf.WhatsAppAppSecret = viper.GetString("whatsapp_app_secret")
+	viper.SetDefault("whatsapp_fallback_expiry", 24) // hours
+	Conf.WhatsAppFallbackExpiry = viper.GetInt("whatsapp_fallback_expiry")
+
+	// email channel (configs with email enabled email bookings without a mobile telephone), the email service is
+	// smtp or sendgrid (empty for no emails). The unsubscribe link is <unsubscribe base url>/email/unsubscribe signed
+	// with the unsubscribe secret e.g. https://reviews.example.com (empty for no unsubscribe link).
+	Conf.EmailService = strings.ToLower(viper.GetString("email_service"))
+	Conf.SMTPHost = viper.GetString("smtp_host")
+	viper.SetDefault("smtp_port", 587)
+	Conf.SMTPPort = viper.GetInt("smtp_port")
+	Conf.SMTPUsername = viper.GetString("smtp_username")
+	Conf.SMTPPassword = viper.GetString("smtp_password")
+	Conf.SendGridAPIKey = viper.GetString("sendgrid_api_key")
+	viper.SetDefault("sendgrid_url", "https://api.sendgrid.com/v3/mail/send")
+	Conf.SendGridURL = viper.GetString("sendgrid_url")
+	Conf.EmailFromAddress = viper.GetString("email_from_address")
+	Conf.EmailFromName = viper.GetString("email_from_name")
+	Conf.EmailUnsubscribeBaseURL = viper.GetString("email_unsubscribe_base_url")
+	Conf.EmailUnsubscribeSecret = viper.GetString("email_unsubscribe_secret")
+
+	// data protection, the telephones are stored and looked up as a keyed hash (HMAC-SHA-256) with the telephone hash
+	// key (the same key has to be used by google_reviews_autocab and google_reviews_ui and must not be changed). The
+	// last sents, send laters and message events older than the data retention days of the client (or the default
+	// data retention days, 0 to keep them) are deleted or pseudonymised every data retention period.
+	Conf.TelephoneHashKey = viper.GetString("telephone_hash_key")
+	viper.SetDefault("data_retention_days", 0)
+	viper.SetDefault("data_retention_period", 24) // hours
+	Conf.DataRetentionDays = viper.GetInt("data_retention_days")
+	Conf.DataRetentionPeriod = viper.GetInt("data_retention_period")
+
+	// message services, the requests time out after the provider timeout (or the provider timeouts by alternate message
+	// service e.g. Twilio=15,WhatsApp=20) and transient errors (e.g. timeouts and 5xx responses) are retried with a
+	// jittered backoff. The circuit breaker of a message service URL opens after the consecutive failures (0 disables)
+	// for the open period. The messages still not sent are deferred to the send later worker.
+	viper.SetDefault("provider_timeout", 10) // seconds
+	viper.SetDefault("provider_max_retries", 2)
+	viper.SetDefault("provider_retry_backoff", 250) // milliseconds
+	viper.SetDefault("circuit_breaker_failures", 5)
+	viper.SetDefault("circuit_breaker_open_period", 30) // seconds
+	Conf.ProviderTimeout = viper.GetInt("provider_timeout")
+	Conf.ProviderTimeouts = splitIntMap(viper.GetString("provider_timeouts"))
+	Conf.ProviderMaxRetries = viper.GetInt("provider_max_retries")
+	Conf.ProviderRetryBackoff = viper.GetInt("provider_retry_backoff")
+	Conf.CircuitBreakerFailures = viper.GetInt("circuit_breaker_failures")
+	Conf.CircuitBreakerOpenPeriod = viper.GetInt("circuit_breaker_open_period")
+
+	// SMS gateways (the send URL of the config e.g. own SMS gateway) are sent a request at most once each gateway send
+	// interval so the SIMs are not sent a burst of messages (0 disables). A request that would wait longer than the
+	// gateway max wait is deferred to the send later worker rather than holding up the request.
+	viper.SetDefault("gateway_send_interval", 0) // milliseconds
+	viper.SetDefault("gateway_max_wait", 2000)   // milliseconds
+	Conf.GatewaySendInterval = viper.GetInt("gateway_send_interval")
+	Conf.GatewayMaxWait = viper.GetInt("gateway_max_wait")
+
+	// secrets of the configs and send laters (e.g. dispatcher app key and secret key) are stored encrypted with a data
+	// key wrapped by the key in the secrets key file (the same key file has to be used by google_reviews_autocab and
+	// google_reviews_ui), the secrets are stored unencrypted when not set
+	Conf.SecretsKeyFile = viper.GetString("secrets_key_file")
+}
+
+// splitList - split a comma separated list removing empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// splitIntMap - split a comma separated list of name=value (e.g. Twilio=15,WhatsApp=20), the invalid entries are logged
+// and ignored
+func splitIntMap(s string) map[string]int {
+	m := make(map[string]int)
+	for _, v := range splitList(s) {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			log.Printf("Error, invalid entry: %s expected name=value\n", v)
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			log.Printf("Error, invalid value of entry: %s, error: %v\n", v, err)
+			continue
+		}
+		m[strings.TrimSpace(kv[0])] = n
+	}
+	return m
 }
@@ -13,3 +13,10 @@ func TestReadProperties(t *testing.T) {
 
 	fmt.Printf("%+v\n", Conf)
 }
+
+func TestSplitIntMap(t *testing.T) {
+	m := splitIntMap(" Twilio=15, WhatsApp = 20,invalid,Veezu=x,")
+	if len(m) != 2 || m["Twilio"] != 15 || m["WhatsApp"] != 20 {
+		t.Fatalf("unexpected map: %v", m)
+	}
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenConfig - a cached config token, the configs (one for each enabled config time) and the client are
+// cached without any checks so the time, weekday and daily sent count checks are still made on each request
+type tokenConfig struct {
+	configs []GoogleReviewsConfigFromTokenWithChecks
+	client  tokenClient
+	expires time.Time
+}
+
+// dailySentCount - in memory daily sent count for a client
+type dailySentCount struct {
+	date       string
+	count      uint
+	reconciled time.Time
+}
+
+// configCache - config cache keyed by token and the in memory daily sent counts keyed by client ID,
+// the generation is incremented on invalidation so a config loaded during an invalidation is not cached
+var configCache = struct {
+	sync.Mutex
+	ttl             time.Duration
+	reconcilePeriod time.Duration
+	generation      uint64
+	tokens          map[string]tokenConfig
+	dailySentCounts map[uint64]*dailySentCount
+}{
+	tokens:          make(map[string]tokenConfig),
+	dailySentCounts: make(map[uint64]*dailySentCount),
+}
+
+// EnableConfigCache - cache the configs from the token for the ttl and keep the daily sent counts in memory,
+// reconciling them with the database after the reconcile period (a ttl of 0 disables the cache)
+// NOTE: the daily sent counts include messages sent by other servers only once reconciled
+func EnableConfigCache(ttl time.Duration, reconcilePeriod time.Duration) {
+	configCache.Lock()
+	defer configCache.Unlock()
+	configCache.ttl = ttl
+	configCache.reconcilePeriod = reconcilePeriod
+	configCache.generation++
+	configCache.tokens = make(map[string]tokenConfig)
+	configCache.dailySentCounts = make(map[uint64]*dailySentCount)
+}
+
+// InvalidateConfig - remove the config token from the cache (all tokens if empty), used when a config is updated
+func InvalidateConfig(token string) {
+	configCache.Lock()
+	defer configCache.Unlock()
+	configCache.generation++
+	if token == "" {
+		configCache.tokens = make(map[string]tokenConfig)
+		return
+	}
+	delete(configCache.tokens, token)
+}
+
+// cachedTokenConfig - get the config token from the cache, loading it from the database when not cached or expired
+// (a token not found is also cached), returns false when the config cache is disabled
+func cachedTokenConfig(token string) (tokenConfig, bool) {
+	configCache.Lock()
+	ttl := configCache.ttl
+	generation := configCache.generation
+	tc, found := configCache.tokens[token]
+	configCache.Unlock()
+	if ttl <= 0 {
+		return tokenConfig{}, false
+	}
+	if found && time.Now().Before(tc.expires) {
+		return tc, true
+	}
+
+	configs, err := queryConfigsFromToken(token)
+	if err != nil {
+		return tokenConfig{configs: configs}, true
+	}
+	client, err := queryClientFromToken(token)
+	if err != nil {
+		return tokenConfig{configs: configs, client: client}, true
+	}
+	tc = tokenConfig{configs: configs, client: client, expires: time.Now().Add(ttl)}
+
+	configCache.Lock()
+	if configCache.generation == generation {
+		configCache.tokens[token] = tc
+	}
+	configCache.Unlock()
+	return tc, true
+}
+
+// configsFromToken - get the configs from the token without any checks (cached when the config cache is enabled)
+func configsFromToken(token string) []GoogleReviewsConfigFromTokenWithChecks {
+	if tc, ok := cachedTokenConfig(token); ok {
+		return tc.configs
+	}
+	configs, _ := queryConfigsFromToken(token)
+	return configs
+}
+
+// clientFromToken - get the client from the config token (cached when the config cache is enabled)
+func clientFromToken(token string) tokenClient {
+	if tc, ok := cachedTokenConfig(token); ok {
+		return tc.client
+	}
+	tc, _ := queryClientFromToken(token)
+	return tc
+}
+
+// cachedDailySentCount - get the in memory daily sent count for the client, reconciling it with the database
+// on a new day or after the reconcile period, returns false when the config cache is disabled
+func cachedDailySentCount(clientID uint64) (uint, bool) {
+	configCache.Lock()
+	enabled := configCache.ttl > 0
+	reconcilePeriod := configCache.reconcilePeriod
+	dsc, found := configCache.dailySentCounts[clientID]
+	today := time.Now().Format("2006-01-02")
+	if found && dsc.date == today && time.Since(dsc.reconciled) < reconcilePeriod {
+		count := dsc.count
+		configCache.Unlock()
+		return count, true
+	}
+	configCache.Unlock()
+	if !enabled {
+		return 0, false
+	}
+
+	count := queryDailySentCount(clientID)
+	configCache.Lock()
+	configCache.dailySentCounts[clientID] = &dailySentCount{date: today, count: count, reconciled: time.Now()}
+	configCache.Unlock()
+	return count, true
+}
+
+// incrementDailySentCount - increment the in memory daily sent count for the client after a message is sent
+// NOTE: this may over count when the same telephone is sent more than once a day until reconciled
+func incrementDailySentCount(clientID uint64) {
+	configCache.Lock()
+	defer configCache.Unlock()
+	if dsc, found := configCache.dailySentCounts[clientID]; found && dsc.date == time.Now().Format("2006-01-02") {
+		dsc.count++
+	}
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"log"
+
+	"google_reviews/utils"
+)
+
+// hashLastSents - hash the telephone of the last sents stored before the telephone hash for the client (0 for all
+// clients), the telephone is removed. A last sent already added with the telephone hash (see UpdateLastSent) replaces
+// the one stored before, keeping its stop. Returns the number hashed.
+func hashLastSents(telephone string, clientID uint64) int64 {
+	telephoneHash := utils.HashTelephone(telephone)
+	clientQry := ""
+	args := []interface{}{telephoneHash, telephone}
+	if clientID != 0 {
+		clientQry = " AND legacy.client_id = ?"
+		args = append(args, clientID)
+	}
+	qry := "UPDATE google_reviews_last_sents AS hashed" +
+		" JOIN google_reviews_last_sents AS legacy ON legacy.client_id = hashed.client_id" +
+		" SET hashed.stop = TRUE" +
+		" WHERE hashed.telephone_hash = ? AND legacy.telephone = ? AND legacy.telephone_hash IS NULL AND legacy.stop = TRUE" +
+		clientQry
+	if _, err := Db.Exec(qry, args...); err != nil {
+		log.Printf("Error hashing the last sents of telephone: %s, err: %v\n", utils.MaskTelephone(telephone), err)
+		return 0
+	}
+	qry = "DELETE legacy FROM google_reviews_last_sents AS legacy" +
+		" JOIN google_reviews_last_sents AS hashed ON hashed.client_id = legacy.client_id" +
+		" WHERE hashed.telephone_hash = ? AND legacy.telephone = ? AND legacy.telephone_hash IS NULL" +
+		clientQry
+	res, err := Db.Exec(qry, args...)
+	if err != nil {
+		log.Printf("Error hashing the last sents of telephone: %s, err: %v\n", utils.MaskTelephone(telephone), err)
+		return 0
+	}
+	replaced, _ := res.RowsAffected()
+	qry = "UPDATE google_reviews_last_sents AS legacy" +
+		" SET telephone_hash = ?, telephone = NULL" +
+		" WHERE telephone = ? AND telephone_hash IS NULL" +
+		clientQry
+	res, err = Db.Exec(qry, args...)
+	if err != nil {
+		log.Printf("Error hashing the last sents of telephone: %s, err: %v\n", utils.MaskTelephone(telephone), err)
+		return replaced
+	}
+	n, _ := res.RowsAffected()
+	return replaced + n
+}
+
+// hashGlobalStop - hash the telephone of the global stop stored before the telephone hash, the telephone is removed.
+// Returns the number hashed.
+func hashGlobalStop(telephone string) int64 {
+	qry := "UPDATE google_reviews_global_stops" +
+		" SET telephone_hash = ?, telephone = NULL" +
+		" WHERE telephone = ? AND telephone_hash IS NULL"
+	res, err := Db.Exec(qry, utils.HashTelephone(telephone), telephone)
+	if err != nil {
+		log.Printf("Error hashing the global stop of telephone: %s, err: %v\n", utils.MaskTelephone(telephone), err)
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
+
+// rehashMessageEvents - rehash the message events of the telephone recorded before the telephone hash key was set
+func rehashMessageEvents(telephone string) {
+	unkeyedHash := utils.UnkeyedHashTelephone(telephone)
+	qry := "UPDATE google_reviews_message_events SET telephone_hash = ? WHERE telephone_hash = ?"
+	if _, err := Db.Exec(qry, utils.HashTelephone(telephone), unkeyedHash); err != nil {
+		log.Printf("Error rehashing the message events of telephone: %s, err: %v\n", utils.MaskTelephone(telephone), err)
+	}
+}
+
+// HashStoredTelephones - hash the telephones of up to limit last sents and global stops stored before the telephone
+// hash (the telephone is removed) and rehash their message events, returns the number of telephones hashed
+func HashStoredTelephones(limit int) int {
+	qry := "(SELECT DISTINCT telephone FROM google_reviews_last_sents" +
+		" WHERE telephone_hash IS NULL AND telephone IS NOT NULL LIMIT ?)" +
+		" UNION" +
+		" (SELECT telephone FROM google_reviews_global_stops" +
+		" WHERE telephone_hash IS NULL AND telephone IS NOT NULL LIMIT ?)"
+	rows, err := Db.Query(qry, limit, limit)
+	if err != nil {
+		log.Printf("Error getting the telephones to hash, err: %v\n", err)
+		return 0
+	}
+	var telephones []string
+	for rows.Next() {
+		var telephone string
+		if err := rows.Scan(&telephone); err != nil {
+			log.Printf("Error getting the telephones to hash, err: %v\n", err)
+			continue
+		}
+		telephones = append(telephones, telephone)
+	}
+	rows.Close()
+
+	hashed := 0
+	for _, telephone := range telephones {
+		rehashMessageEvents(telephone)
+		if hashLastSents(telephone, 0)+hashGlobalStop(telephone) > 0 {
+			hashed++
+		}
+	}
+	return hashed
+}
+
+// Retention - the number of rows deleted or pseudonymised by the data retention (see ApplyRetention)
+type Retention struct {
+	LastSents      int64
+	EmailLastSents int64
+	SendLaters     int64
+	MessageEvents  int64
+}
+
+// Total - total number of rows deleted or pseudonymised
+func (r Retention) Total() int64 {
+	return r.LastSents + r.EmailLastSents + r.SendLaters + r.MessageEvents
+}
+
+// ApplyRetention - delete the last sents (other than stops so the opt out is kept), email last sents and send laters
+// older than the data retention days of each client (the default data retention days when the client has none) and
+// pseudonymise the message events (the telephone hash and provider response are removed so the counts are kept).
+// Clients without data retention days when the default is 0 are not changed.
+func ApplyRetention(defaultDays int) Retention {
+	var r Retention
+	qry := "SELECT id, IF(retention_days > 0, retention_days, ?) FROM clients" +
+		" WHERE retention_days > 0 OR ? > 0"
+	rows, err := Db.Query(qry, defaultDays, defaultDays)
+	if err != nil {
+		log.Printf("Error getting the data retention of the clients, err: %v\n", err)
+		return r
+	}
+	type clientRetention struct {
+		clientID uint64
+		days     int
+	}
+	var clients []clientRetention
+	for rows.Next() {
+		var c clientRetention
+		if err := rows.Scan(&c.clientID, &c.days); err != nil {
+			log.Printf("Error getting the data retention of the clients, err: %v\n", err)
+			continue
+		}
+		clients = append(clients, c)
+	}
+	rows.Close()
+
+	for _, c := range clients {
+		r.LastSents += retentionExec("DELETE FROM google_reviews_last_sents"+
+			" WHERE client_id = ? AND stop = 0 AND last_sent < DATE_SUB(NOW(), INTERVAL ? DAY)", c.clientID, c.days)
+		r.EmailLastSents += retentionExec("DELETE FROM google_reviews_email_last_sents"+
+			" WHERE client_id = ? AND stop = 0 AND last_sent < DATE_SUB(NOW(), INTERVAL ? DAY)", c.clientID, c.days)
+		r.SendLaters += retentionExec("DELETE FROM google_reviews_send_laters"+
+			" WHERE client_id = ? AND send_after < DATE_SUB(NOW(), INTERVAL ? DAY)", c.clientID, c.days)
+		r.MessageEvents += retentionExec("UPDATE google_reviews_message_events"+
+			" SET telephone_hash = '', provider_response = ''"+
+			" WHERE client_id = ? AND created < DATE_SUB(NOW(), INTERVAL ? DAY) AND telephone_hash <> ''", c.clientID, c.days)
+	}
+	return r
+}
+
+// retentionExec - execute the data retention query for the client, returns the number of rows affected
+func retentionExec(qry string, clientID uint64, days int) int64 {
+	res, err := Db.Exec(qry, clientID, days)
+	if err != nil {
+		log.Printf("Error applying the data retention of clientID: %d, err: %v\n", clientID, err)
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
@@ -6,8 +6,10 @@ import (
 	"encoding/gob"
 	"log"
 	"net/url"
+	"strings"
 	"time"
 
+	"google_reviews/barred"
 	"google_reviews/utils"
 	// mysql driver
 	_ "github.com/go-sql-driver/mysql"
@@ -18,17 +20,50 @@ var (
 	ReviewMasterSMSMasterQueue = 0
 )
 
+// message event reasons, recorded in the message events table for why a message was or wasn't sent
+const (
+	ReasonSent                  = "sent"
+	ReasonDeferred              = "deferred"
+	ReasonBarred                = "barred"
+	ReasonOutsideHours          = "outside_hours"
+	ReasonTooRecent             = "too_recent"
+	ReasonMaxCount              = "max_count"
+	ReasonMaxDailyCount         = "max_daily_count"
+	ReasonStopped               = "stopped"
+	ReasonNoTelephone           = "no_telephone"
+	ReasonNoMessage             = "no_message"
+	ReasonDispatcherCheckFailed = "dispatcher_check_failed"
+	ReasonProviderError         = "provider_error"
+	ReasonFatigueCap            = "fatigue_cap"
+	ReasonOptedOut              = "opted_out"
+	ReasonIPNotAllowed          = "ip_not_allowed"
+	ReasonInvalidSignature      = "invalid_signature"
+	ReasonExpiredSignature      = "expired_signature"
+)
+
+// delivery statuses of the messages sent, recorded against the message event from the message service callbacks
+const (
+	DeliveryStatusDelivered   = "delivered"
+	DeliveryStatusUndelivered = "undelivered"
+	DeliveryStatusFailed      = "failed"
+)
+
+// maxProviderResponseLength - maximum length of the provider response stored in a message event
+const maxProviderResponseLength = 1024
+
 // GoogleReviewsConfig - represents a google reviews config from the token with some checks
 type GoogleReviewsConfigFromTokenWithChecks struct {
 	MinSendFrequency                     uint
 	MaxSendCount                         uint
 	MaxDailySendCount                    uint
+	PacingEnabled                        bool
 	TelephoneParameter                   string
 	SendFromIcabbiApp                    bool
 	AppKey                               string
 	SecretKey                            string
 	SendURL                              string
 	HttpGet                              bool
+	TLSSkipVerify                        bool
 	SendSuccessResponse                  string
 	Start                                string
 	End                                  string
@@ -41,6 +76,7 @@ type GoogleReviewsConfigFromTokenWithChecks struct {
 	Saturday                             bool
 	TimeZone                             string
 	ClientID                             uint64
+	ConfigID                             uint64
 	Country                              string
 	MultiMessageEnabled                  uint
 	MessageParameter                     string
@@ -64,8 +100,30 @@ type GoogleReviewsConfigFromTokenWithChecks struct {
 	AlternateMessageServiceEnabled       bool
 	AlternateMessageService              string
 	AlternateMessageServiceSecret1       string
+	AlternateMessageServiceSender        string
+	MessageChannel                       string
+	WhatsAppPhoneNumberID                string
+	WhatsAppAccessToken                  string
+	WhatsAppTemplateName                 string
+	WhatsAppTemplateLanguage             string
+	WhatsAppTemplateParameters           string
+	WhatsAppSMSFallback                  bool
+	EmailEnabled                         bool
+	EmailParameter                       string
+	EmailSubject                         string
+	EmailTemplate                        string
 	Companies                            string
 	BookingSourceMobileAppState          int
+	ReviewLink                           string
+	OptOutLink                           string
+	Messages                             map[string]string // messages by language (see ConfigMessages)
+	MessageVariants                      []MessageVariant
+	FatiguePolicies                      []FatiguePolicy
+}
+
+// Days - the enabled weekdays from Sunday (see utils.CheckWindow)
+func (grcftwc GoogleReviewsConfigFromTokenWithChecks) Days() [7]bool {
+	return [7]bool{grcftwc.Sunday, grcftwc.Monday, grcftwc.Tuesday, grcftwc.Wednesday, grcftwc.Thursday, grcftwc.Friday, grcftwc.Saturday}
 }
 
 // OpenDB - open database connection
@@ -99,62 +157,14 @@ func OpenDB(database string, host string, port string, username string, password
 
 // ConfigFromTokenWithChecks - get the config from the token with some checks
 // ignoreTimeAndSentCountCheck - ignores the time and daily sent count checks (used for testing on front end)
+// The config is cached when the config cache is enabled (see EnableConfigCache), the checks are made on each call.
 func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) GoogleReviewsConfigFromTokenWithChecks {
-	qry := "SELECT config.min_send_frequency, config.max_send_count, config.max_daily_send_count, config.telephone_parameter," +
-		" config.send_from_icabbi_app, config.app_key, config.secret_key," +
-		" config.send_url, config.http_get, config.send_success_response, times.start, times.end," +
-		" times.sunday, times.monday, times.tuesday, times.wednesday, times.thursday, times.friday, times.saturday," +
-		" config.time_zone, client.id, client.country," +
-		" config.multi_message_enabled, config.message_parameter, config.multi_message_separator," +
-		" config.use_database_message, config.message," +
-		" config.send_delay_enabled, config.send_delay," +
-		" config.dispatcher_checks_enabled, config.dispatcher_url, config.dispatcher_type, config.booking_id_parameter, config.is_booking_for_now_diff_minutes," +
-		" config.booking_now_pickup_to_contact_minutes, config.pre_booking_pickup_to_contact_minutes," +
-		" config.replace_telephone_country_code, config.replace_telephone_country_code_with," +
-		" config.review_master_sms_gateway_enabled, config.review_master_sms_gateway_use_master_queue, config.review_master_sms_gateway_pair_code," +
-		" config.alternate_message_service_enabled, config.alternate_message_service, config.alternate_message_service_secret1," +
-		" config.companies, config.booking_source_mobile_app_state" +
-		" FROM google_reviews_config_times AS times" +
-		" JOIN google_reviews_configs AS config ON config.id = times.google_reviews_config_id" +
-		" JOIN clients AS client ON client.id = config.client_id" +
-		" WHERE config.token = ?" +
-		" AND times.enabled = 1" +
-		" AND config.enabled = 1" +
-		" AND client.enabled = 1"
-	rows, err := Db.Query(qry, token)
 	var grcftwc GoogleReviewsConfigFromTokenWithChecks
-	if err != nil {
-		log.Println("Error retrieving token", token, "from database. Error: ", err)
-		return grcftwc
-	}
-	defer rows.Close()
-	for rows.Next() {
-		if err1 := rows.Scan(&grcftwc.MinSendFrequency, &grcftwc.MaxSendCount, &grcftwc.MaxDailySendCount,
-			&grcftwc.TelephoneParameter, &grcftwc.SendFromIcabbiApp, &grcftwc.AppKey, &grcftwc.SecretKey,
-			&grcftwc.SendURL, &grcftwc.HttpGet, &grcftwc.SendSuccessResponse, &grcftwc.Start, &grcftwc.End,
-			&grcftwc.Sunday, &grcftwc.Monday, &grcftwc.Tuesday, &grcftwc.Wednesday, &grcftwc.Thursday, &grcftwc.Friday,
-			&grcftwc.Saturday, &grcftwc.TimeZone, &grcftwc.ClientID, &grcftwc.Country,
-			&grcftwc.MultiMessageEnabled, &grcftwc.MessageParameter, &grcftwc.MultiMessageSeparator,
-			&grcftwc.UseDatabaseMessage, &grcftwc.Message,
-			&grcftwc.SendDelayEnabled, &grcftwc.SendDelay,
-			&grcftwc.DispatcherChecksEnabled,
-			&grcftwc.DispatcherURL, &grcftwc.DispatcherType, &grcftwc.BookingIdParameter, &grcftwc.IsBookingForNowDiffMinutes,
-			&grcftwc.BookingNowPickupToContactMinutes, &grcftwc.PreBookingPickupToContactMinutes,
-			&grcftwc.ReplaceTelephoneCountryCode, &grcftwc.ReplaceTelephoneCountryCodeWith,
-			&grcftwc.ReviewMasterSMSGatewayEnabled, &grcftwc.ReviewMasterSMSGatewayUseMasterQueue, &grcftwc.ReviewMasterSMSGatewayPairCode,
-			&grcftwc.AlternateMessageServiceEnabled, &grcftwc.AlternateMessageService, &grcftwc.AlternateMessageServiceSecret1,
-			&grcftwc.Companies, &grcftwc.BookingSourceMobileAppState); err1 != nil {
-			log.Println("Error retrieving token", token, "from database whilst reading returned results. Error: ", err1)
-			return grcftwc
-		}
-		if grcftwc.ClientID == 0 {
-			log.Printf("token %s not found", token)
-			continue
-		}
+	for _, c := range configsFromToken(token) {
+		grcftwc = c
 		if !ignoreTimeAndSentCountCheck {
 			// check within start and end time and weekday
-			if !(utils.CheckTime(grcftwc.Start, grcftwc.End, grcftwc.TimeZone) &&
-				utils.CheckWeekday(grcftwc.Sunday, grcftwc.Monday, grcftwc.Tuesday, grcftwc.Wednesday, grcftwc.Thursday, grcftwc.Friday, grcftwc.Saturday, grcftwc.TimeZone)) {
+			if !utils.CheckWindow(grcftwc.Start, grcftwc.End, grcftwc.Days(), grcftwc.TimeZone) {
 				// log.Printf("token %s not found between times %s and %s for time zone %s for clientID %d", token, start, end, timeZone, clientID)
 				grcftwc.MinSendFrequency = 0
 				grcftwc.MaxSendCount = 0
@@ -164,8 +174,11 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 				grcftwc.SecretKey = ""
 				grcftwc.SendURL = ""
 				grcftwc.HttpGet = false
+				grcftwc.TLSSkipVerify = false
+				grcftwc.PacingEnabled = false
 				grcftwc.SendSuccessResponse = ""
 				grcftwc.ClientID = 0
+				grcftwc.ConfigID = 0
 				grcftwc.Country = ""
 				grcftwc.MultiMessageEnabled = 0
 				grcftwc.MessageParameter = ""
@@ -189,8 +202,22 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 				grcftwc.AlternateMessageServiceEnabled = false
 				grcftwc.AlternateMessageService = ""
 				grcftwc.AlternateMessageServiceSecret1 = ""
+				grcftwc.AlternateMessageServiceSender = ""
+				grcftwc.MessageChannel = ""
+				grcftwc.WhatsAppPhoneNumberID = ""
+				grcftwc.WhatsAppAccessToken = ""
+				grcftwc.WhatsAppTemplateName = ""
+				grcftwc.WhatsAppTemplateLanguage = ""
+				grcftwc.WhatsAppTemplateParameters = ""
+				grcftwc.WhatsAppSMSFallback = false
+				grcftwc.EmailEnabled = false
+				grcftwc.EmailParameter = ""
+				grcftwc.EmailSubject = ""
+				grcftwc.EmailTemplate = ""
 				grcftwc.Companies = ""
 				grcftwc.BookingSourceMobileAppState = -1
+				grcftwc.ReviewLink = ""
+				grcftwc.OptOutLink = ""
 				continue
 			}
 			// check whether sent daily allowance
@@ -205,8 +232,11 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 				grcftwc.SecretKey = ""
 				grcftwc.SendURL = ""
 				grcftwc.HttpGet = false
+				grcftwc.TLSSkipVerify = false
+				grcftwc.PacingEnabled = false
 				grcftwc.SendSuccessResponse = ""
 				grcftwc.ClientID = 0
+				grcftwc.ConfigID = 0
 				grcftwc.Country = ""
 				grcftwc.MultiMessageEnabled = 0
 				grcftwc.MessageParameter = ""
@@ -230,8 +260,22 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 				grcftwc.AlternateMessageServiceEnabled = false
 				grcftwc.AlternateMessageService = ""
 				grcftwc.AlternateMessageServiceSecret1 = ""
+				grcftwc.AlternateMessageServiceSender = ""
+				grcftwc.MessageChannel = ""
+				grcftwc.WhatsAppPhoneNumberID = ""
+				grcftwc.WhatsAppAccessToken = ""
+				grcftwc.WhatsAppTemplateName = ""
+				grcftwc.WhatsAppTemplateLanguage = ""
+				grcftwc.WhatsAppTemplateParameters = ""
+				grcftwc.WhatsAppSMSFallback = false
+				grcftwc.EmailEnabled = false
+				grcftwc.EmailParameter = ""
+				grcftwc.EmailSubject = ""
+				grcftwc.EmailTemplate = ""
 				grcftwc.Companies = ""
 				grcftwc.BookingSourceMobileAppState = -1
+				grcftwc.ReviewLink = ""
+				grcftwc.OptOutLink = ""
 				break
 			}
 		}
@@ -240,14 +284,98 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 	return grcftwc
 }
 
+// queryConfigsFromToken - get the configs from the token without any checks, one for each enabled config time
+func queryConfigsFromToken(token string) ([]GoogleReviewsConfigFromTokenWithChecks, error) {
+	qry := "SELECT config.min_send_frequency, config.max_send_count, config.max_daily_send_count, config.pacing_enabled, config.telephone_parameter," +
+		" config.send_from_icabbi_app, config.app_key, config.secret_key," +
+		" config.send_url, config.http_get, config.tls_skip_verify, config.send_success_response, times.start, times.end," +
+		" times.sunday, times.monday, times.tuesday, times.wednesday, times.thursday, times.friday, times.saturday," +
+		" config.time_zone, client.id, config.id, client.country," +
+		" config.multi_message_enabled, config.message_parameter, config.multi_message_separator," +
+		" config.use_database_message, config.message," +
+		" config.send_delay_enabled, config.send_delay," +
+		" config.dispatcher_checks_enabled, config.dispatcher_url, config.dispatcher_type, config.booking_id_parameter, config.is_booking_for_now_diff_minutes," +
+		" config.booking_now_pickup_to_contact_minutes, config.pre_booking_pickup_to_contact_minutes," +
+		" config.replace_telephone_country_code, config.replace_telephone_country_code_with," +
+		" config.review_master_sms_gateway_enabled, config.review_master_sms_gateway_use_master_queue, config.review_master_sms_gateway_pair_code," +
+		" config.alternate_message_service_enabled, config.alternate_message_service, config.alternate_message_service_secret1, config.alternate_message_service_sender," +
+		" config.message_channel, config.whatsapp_phone_number_id, config.whatsapp_access_token, config.whatsapp_template_name," +
+		" config.whatsapp_template_language, config.whatsapp_template_parameters, config.whatsapp_sms_fallback," +
+		" config.email_enabled, config.email_parameter, config.email_subject, IFNULL(config.email_template, '')," +
+		" config.companies, config.booking_source_mobile_app_state, config.review_link, config.opt_out_link" +
+		" FROM google_reviews_config_times AS times" +
+		" JOIN google_reviews_configs AS config ON config.id = times.google_reviews_config_id" +
+		" JOIN clients AS client ON client.id = config.client_id" +
+		" WHERE config.token = ?" +
+		" AND times.enabled = 1" +
+		" AND config.enabled = 1" +
+		" AND client.enabled = 1"
+	rows, err := Db.Query(qry, token)
+	if err != nil {
+		log.Println("Error retrieving token", token, "from database. Error: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var grcftwcs []GoogleReviewsConfigFromTokenWithChecks
+	for rows.Next() {
+		var grcftwc GoogleReviewsConfigFromTokenWithChecks
+		if err1 := rows.Scan(&grcftwc.MinSendFrequency, &grcftwc.MaxSendCount, &grcftwc.MaxDailySendCount, &grcftwc.PacingEnabled,
+			&grcftwc.TelephoneParameter, &grcftwc.SendFromIcabbiApp, &grcftwc.AppKey, &grcftwc.SecretKey,
+			&grcftwc.SendURL, &grcftwc.HttpGet, &grcftwc.TLSSkipVerify, &grcftwc.SendSuccessResponse, &grcftwc.Start, &grcftwc.End,
+			&grcftwc.Sunday, &grcftwc.Monday, &grcftwc.Tuesday, &grcftwc.Wednesday, &grcftwc.Thursday, &grcftwc.Friday,
+			&grcftwc.Saturday, &grcftwc.TimeZone, &grcftwc.ClientID, &grcftwc.ConfigID, &grcftwc.Country,
+			&grcftwc.MultiMessageEnabled, &grcftwc.MessageParameter, &grcftwc.MultiMessageSeparator,
+			&grcftwc.UseDatabaseMessage, &grcftwc.Message,
+			&grcftwc.SendDelayEnabled, &grcftwc.SendDelay,
+			&grcftwc.DispatcherChecksEnabled,
+			&grcftwc.DispatcherURL, &grcftwc.DispatcherType, &grcftwc.BookingIdParameter, &grcftwc.IsBookingForNowDiffMinutes,
+			&grcftwc.BookingNowPickupToContactMinutes, &grcftwc.PreBookingPickupToContactMinutes,
+			&grcftwc.ReplaceTelephoneCountryCode, &grcftwc.ReplaceTelephoneCountryCodeWith,
+			&grcftwc.ReviewMasterSMSGatewayEnabled, &grcftwc.ReviewMasterSMSGatewayUseMasterQueue, &grcftwc.ReviewMasterSMSGatewayPairCode,
+			&grcftwc.AlternateMessageServiceEnabled, &grcftwc.AlternateMessageService, &grcftwc.AlternateMessageServiceSecret1, &grcftwc.AlternateMessageServiceSender,
+			&grcftwc.MessageChannel, &grcftwc.WhatsAppPhoneNumberID, &grcftwc.WhatsAppAccessToken, &grcftwc.WhatsAppTemplateName,
+			&grcftwc.WhatsAppTemplateLanguage, &grcftwc.WhatsAppTemplateParameters, &grcftwc.WhatsAppSMSFallback,
+			&grcftwc.EmailEnabled, &grcftwc.EmailParameter, &grcftwc.EmailSubject, &grcftwc.EmailTemplate,
+			&grcftwc.Companies, &grcftwc.BookingSourceMobileAppState, &grcftwc.ReviewLink, &grcftwc.OptOutLink); err1 != nil {
+			log.Println("Error retrieving token", token, "from database whilst reading returned results. Error: ", err1)
+			return grcftwcs, err1
+		}
+		if grcftwc.ClientID == 0 {
+			log.Printf("token %s not found", token)
+			continue
+		}
+		decryptConfigSecrets(&grcftwc)
+		grcftwcs = append(grcftwcs, grcftwc)
+	}
+	loadConfigMessages(grcftwcs)
+	return grcftwcs, nil
+}
+
+// loadConfigMessages - load the messages by language, message variants and fatigue policies of the configs so they
+// are cached with the config rather than queried on each request
+func loadConfigMessages(grcftwcs []GoogleReviewsConfigFromTokenWithChecks) {
+	for i := range grcftwcs {
+		if i > 0 && grcftwcs[i].ConfigID == grcftwcs[i-1].ConfigID {
+			// another time of the same config
+			grcftwcs[i].Messages = grcftwcs[i-1].Messages
+			grcftwcs[i].MessageVariants = grcftwcs[i-1].MessageVariants
+			grcftwcs[i].FatiguePolicies = grcftwcs[i-1].FatiguePolicies
+			continue
+		}
+		grcftwcs[i].Messages = ConfigMessages(grcftwcs[i].ConfigID)
+		grcftwcs[i].MessageVariants = MessageVariants(grcftwcs[i].ConfigID)
+		grcftwcs[i].FatiguePolicies = FatiguePolicies(grcftwcs[i].ClientID)
+	}
+}
+
 // GetAutocabConfigsWithChecks - get list of Autocab configs with some checks, these are then used to make request to dispatchers (polling)
 // ignoreTimeAndSentCountCheck - ignores the time and daily sent count checks (used for testing on front end)
 func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleReviewsConfigFromTokenWithChecks {
-	qry := "SELECT config.min_send_frequency, config.max_send_count, config.max_daily_send_count, config.telephone_parameter," +
+	qry := "SELECT config.min_send_frequency, config.max_send_count, config.max_daily_send_count, config.pacing_enabled, config.telephone_parameter," +
 		" config.send_from_icabbi_app, config.app_key, config.secret_key," +
-		" config.send_url, config.http_get, config.send_success_response, times.start, times.end," +
+		" config.send_url, config.http_get, config.tls_skip_verify, config.send_success_response, times.start, times.end," +
 		" times.sunday, times.monday, times.tuesday, times.wednesday, times.thursday, times.friday, times.saturday," +
-		" config.time_zone, client.id, client.country," +
+		" config.time_zone, client.id, config.id, client.country," +
 		" config.multi_message_enabled, config.message_parameter, config.multi_message_separator," +
 		" config.use_database_message, config.message," +
 		" config.send_delay_enabled, config.send_delay," +
@@ -255,8 +383,11 @@ func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleRevie
 		" config.booking_now_pickup_to_contact_minutes, config.pre_booking_pickup_to_contact_minutes," +
 		" config.replace_telephone_country_code, config.replace_telephone_country_code_with," +
 		" config.review_master_sms_gateway_enabled, config.review_master_sms_gateway_use_master_queue, config.review_master_sms_gateway_pair_code," +
-		" config.alternate_message_service_enabled, config.alternate_message_service, config.alternate_message_service_secret1," +
-		" config.companies, config.booking_source_mobile_app_state" +
+		" config.alternate_message_service_enabled, config.alternate_message_service, config.alternate_message_service_secret1, config.alternate_message_service_sender," +
+		" config.message_channel, config.whatsapp_phone_number_id, config.whatsapp_access_token, config.whatsapp_template_name," +
+		" config.whatsapp_template_language, config.whatsapp_template_parameters, config.whatsapp_sms_fallback," +
+		" config.email_enabled, config.email_parameter, config.email_subject, IFNULL(config.email_template, '')," +
+		" config.companies, config.booking_source_mobile_app_state, config.review_link, config.opt_out_link" +
 		" FROM google_reviews_config_times AS times" +
 		" JOIN google_reviews_configs AS config ON config.id = times.google_reviews_config_id" +
 		" JOIN clients AS client ON client.id = config.client_id" +
@@ -273,11 +404,11 @@ func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleRevie
 	defer rows.Close()
 	for rows.Next() {
 		var grcftwc GoogleReviewsConfigFromTokenWithChecks
-		if err1 := rows.Scan(&grcftwc.MinSendFrequency, &grcftwc.MaxSendCount, &grcftwc.MaxDailySendCount,
+		if err1 := rows.Scan(&grcftwc.MinSendFrequency, &grcftwc.MaxSendCount, &grcftwc.MaxDailySendCount, &grcftwc.PacingEnabled,
 			&grcftwc.TelephoneParameter, &grcftwc.SendFromIcabbiApp, &grcftwc.AppKey, &grcftwc.SecretKey,
-			&grcftwc.SendURL, &grcftwc.HttpGet, &grcftwc.SendSuccessResponse, &grcftwc.Start, &grcftwc.End,
+			&grcftwc.SendURL, &grcftwc.HttpGet, &grcftwc.TLSSkipVerify, &grcftwc.SendSuccessResponse, &grcftwc.Start, &grcftwc.End,
 			&grcftwc.Sunday, &grcftwc.Monday, &grcftwc.Tuesday, &grcftwc.Wednesday, &grcftwc.Thursday, &grcftwc.Friday,
-			&grcftwc.Saturday, &grcftwc.TimeZone, &grcftwc.ClientID, &grcftwc.Country,
+			&grcftwc.Saturday, &grcftwc.TimeZone, &grcftwc.ClientID, &grcftwc.ConfigID, &grcftwc.Country,
 			&grcftwc.MultiMessageEnabled, &grcftwc.MessageParameter, &grcftwc.MultiMessageSeparator,
 			&grcftwc.UseDatabaseMessage, &grcftwc.Message,
 			&grcftwc.SendDelayEnabled, &grcftwc.SendDelay,
@@ -286,8 +417,11 @@ func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleRevie
 			&grcftwc.BookingNowPickupToContactMinutes, &grcftwc.PreBookingPickupToContactMinutes,
 			&grcftwc.ReplaceTelephoneCountryCode, &grcftwc.ReplaceTelephoneCountryCodeWith,
 			&grcftwc.ReviewMasterSMSGatewayEnabled, &grcftwc.ReviewMasterSMSGatewayUseMasterQueue, &grcftwc.ReviewMasterSMSGatewayPairCode,
-			&grcftwc.AlternateMessageServiceEnabled, &grcftwc.AlternateMessageService, &grcftwc.AlternateMessageServiceSecret1,
-			&grcftwc.Companies, &grcftwc.BookingSourceMobileAppState); err1 != nil {
+			&grcftwc.AlternateMessageServiceEnabled, &grcftwc.AlternateMessageService, &grcftwc.AlternateMessageServiceSecret1, &grcftwc.AlternateMessageServiceSender,
+			&grcftwc.MessageChannel, &grcftwc.WhatsAppPhoneNumberID, &grcftwc.WhatsAppAccessToken, &grcftwc.WhatsAppTemplateName,
+			&grcftwc.WhatsAppTemplateLanguage, &grcftwc.WhatsAppTemplateParameters, &grcftwc.WhatsAppSMSFallback,
+			&grcftwc.EmailEnabled, &grcftwc.EmailParameter, &grcftwc.EmailSubject, &grcftwc.EmailTemplate,
+			&grcftwc.Companies, &grcftwc.BookingSourceMobileAppState, &grcftwc.ReviewLink, &grcftwc.OptOutLink); err1 != nil {
 			log.Println("Error retrieving configs for Autocab from database whilst reading returned results. Error: ", err1)
 			return grcftwcs
 		}
@@ -296,8 +430,7 @@ func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleRevie
 		}
 		if !ignoreTimeAndSentCountCheck {
 			// check within start and end time and weekday
-			if !(utils.CheckTime(grcftwc.Start, grcftwc.End, grcftwc.TimeZone) &&
-				utils.CheckWeekday(grcftwc.Sunday, grcftwc.Monday, grcftwc.Tuesday, grcftwc.Wednesday, grcftwc.Thursday, grcftwc.Friday, grcftwc.Saturday, grcftwc.TimeZone)) {
+			if !utils.CheckWindow(grcftwc.Start, grcftwc.End, grcftwc.Days(), grcftwc.TimeZone) {
 				// log.Printf("Autocab config not found between times %s and %s for time zone %s for clientID %d", start, end, timeZone, clientID)
 				continue
 			}
@@ -308,18 +441,24 @@ func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleRevie
 				continue
 			}
 		}
+		decryptConfigSecrets(&grcftwc)
 		grcftwcs = append(grcftwcs, grcftwc)
 	}
+	loadConfigMessages(grcftwcs)
 	return grcftwcs
 }
 
 // LastSentFromTelephoneAndClient - get the last sent from telephone and client
+// The last sent is looked up by the telephone hash, or the telephone itself when not yet hashed (see hashLastSents).
+// When both are stored (sent to again before hashed) the hashed last sent is used, stopped if either is stopped.
 func LastSentFromTelephoneAndClient(telephone string, clientID uint64) (time.Time, uint, bool, bool) {
-	qry := "SELECT last_sent, sent_count, stop FROM google_reviews_last_sents WHERE telephone = ? AND client_id = ?"
-	rows, err := Db.Query(qry, telephone, clientID)
+	qry := "SELECT last_sent, sent_count, stop FROM google_reviews_last_sents" +
+		" WHERE client_id = ? AND (telephone_hash = ? OR (telephone_hash IS NULL AND telephone = ?))" +
+		" ORDER BY telephone_hash IS NULL"
+	rows, err := Db.Query(qry, clientID, utils.HashTelephone(telephone), telephone)
 	if err != nil {
 		log.Println(err)
-		return time.Now().AddDate(-3, 0, 0), 0, false, false
+		return time.Now().AddDate(-3, 0, 0), 0, GlobalStop(telephone), false
 	}
 	defer rows.Close()
 	rows.Next()
@@ -331,45 +470,213 @@ func LastSentFromTelephoneAndClient(telephone string, clientID uint64) (time.Tim
 	if err1 := rows.Scan(&lastSent, &sentCount, &stop); err1 != nil {
 		// fmt.Println("err1: ", err1)
 		// time 3 years earlier which will be before the minimum send frequency
-		return time.Now().AddDate(-3, 0, 0), 0, false, false
+		return time.Now().AddDate(-3, 0, 0), 0, GlobalStop(telephone), false
+	}
+	for rows.Next() {
+		var (
+			l time.Time
+			c uint
+			s bool
+		)
+		if err1 := rows.Scan(&l, &c, &s); err1 == nil && s {
+			stop = true
+		}
+	}
+	// telephone opted out for all clients
+	if !stop {
+		stop = GlobalStop(telephone)
 	}
 	return lastSent, sentCount, stop, true
 }
 
 // UpdateLastSent - update the last sent
 // If this has been requested then the stop (sending) will always be false
+// Only the telephone hash is stored (see utils.HashTelephone), a last sent stored before the telephone hash is
+// replaced when hashed by HashStoredTelephones
 func UpdateLastSent(telephone string, clientID uint64, sentCount uint) {
 	qry := "INSERT INTO google_reviews_last_sents" +
-		" (telephone, client_id, last_sent, last_sent_date, sent_count, stop)" +
+		" (telephone_hash, client_id, last_sent, last_sent_date, sent_count, stop)" +
 		" VALUES (?, ?, NOW(), CURDATE(), ?, FALSE)" +
 		" ON DUPLICATE KEY UPDATE" +
 		" last_sent = NOW()," +
 		" last_sent_date = CURDATE()," +
 		" sent_count = ?"
-	_, err := Db.Exec(qry, telephone, clientID, sentCount, sentCount)
+	_, err := Db.Exec(qry, utils.HashTelephone(telephone), clientID, sentCount, sentCount)
 	if err != nil {
 		log.Println(err)
+		return
 	}
+	incrementDailySentCount(clientID)
 }
 
 // StopSending - stop sending messages
 func StopSending(telephone string, clientID uint64) {
+	hashLastSents(telephone, clientID)
+	// a stop before any message is sent by the client (e.g. sent by another service) is kept, the last sent date is
+	// left at its default so the stop is not counted as sent today (see DailySentCount)
+	qry := "INSERT INTO google_reviews_last_sents" +
+		" (telephone_hash, client_id, last_sent, sent_count, stop)" +
+		" VALUES (?, ?, NOW(), 0, TRUE)" +
+		" ON DUPLICATE KEY UPDATE stop = TRUE"
+	_, err := Db.Exec(qry, utils.HashTelephone(telephone), clientID)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// GlobalStop - check whether telephone has opted out for all clients (global stop)
+func GlobalStop(telephone string) bool {
+	qry := "SELECT COUNT(id) FROM google_reviews_global_stops" +
+		" WHERE telephone_hash = ? OR (telephone_hash IS NULL AND telephone = ?)"
+	var count int
+	if err := Db.QueryRow(qry, utils.HashTelephone(telephone), telephone).Scan(&count); err != nil {
+		log.Println(err)
+		return false
+	}
+	return count > 0
+}
+
+// StopSendingAllClients - stop sending messages for all clients and add a global stop
+// so clients that have not yet sent to the telephone also do not send
+func StopSendingAllClients(telephone string) {
+	hashLastSents(telephone, 0)
+	hashGlobalStop(telephone)
+	telephoneHash := utils.HashTelephone(telephone)
 	qry := "UPDATE google_reviews_last_sents" +
 		" SET stop = TRUE" +
-		" WHERE telephone = ? and client_id = ?"
-	_, err := Db.Exec(qry, telephone, clientID)
+		" WHERE telephone_hash = ?"
+	_, err := Db.Exec(qry, telephoneHash)
 	if err != nil {
 		log.Println(err)
 	}
+	qry = "INSERT IGNORE INTO google_reviews_global_stops" +
+		" (telephone_hash, created)" +
+		" VALUES (?, NOW())"
+	_, err = Db.Exec(qry, telephoneHash)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// ClientIDAndCountryFromToken - get the client ID and country from the config token (client ID 0 if not found)
+func ClientIDAndCountryFromToken(token string) (uint64, string) {
+	tc := clientFromToken(token)
+	return tc.clientID, tc.country
+}
+
+// RejectedConfigFromToken - when the config checks fail (see ConfigFromTokenWithChecks) get the client ID, country
+// and telephone parameter from the token and the reason, either the maximum daily send count has been reached or
+// it is outside the configured hours (client ID 0 if the token is not found)
+func RejectedConfigFromToken(token string) (uint64, string, string, string) {
+	tc := clientFromToken(token)
+	if tc.clientID == 0 {
+		return 0, "", "", ""
+	}
+	if DailySentCount(tc.clientID)+1 > tc.maxDailySendCount {
+		return tc.clientID, tc.country, tc.telephoneParameter, ReasonMaxDailyCount
+	}
+	return tc.clientID, tc.country, tc.telephoneParameter, ReasonOutsideHours
+}
+
+// BookingClientFromToken - get the client ID and booking ID parameter from the token regardless of the config times
+// and daily sent count (client ID 0 if the token is not found), used to find repeated deliveries of a booking
+// before the config checks are made
+func BookingClientFromToken(token string) (uint64, string) {
+	tc := clientFromToken(token)
+	return tc.clientID, tc.bookingIdParameter
+}
+
+// RequestVerificationFromToken - get the client ID, signing secret and allowed IPs (IPs or CIDRs) from the token
+// regardless of the config times and daily sent count (client ID 0 if the token is not found), used to verify the
+// dispatcher requests before they are processed (an empty signing secret or allowed IPs is not checked)
+func RequestVerificationFromToken(token string) (uint64, string, []string) {
+	tc := clientFromToken(token)
+	var allowedIPs []string
+	for _, ip := range strings.Split(tc.allowedIPs, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			allowedIPs = append(allowedIPs, ip)
+		}
+	}
+	return tc.clientID, tc.signingSecret, allowedIPs
+}
+
+// HookMappingFromToken - get the client ID and the mapping of the generic dispatcher webhook (see the hook package)
+// from the token regardless of the config times and daily sent count (client ID 0 if the token is not found, the
+// mapping is empty when the webhook is not set up for the config)
+func HookMappingFromToken(token string) (uint64, string) {
+	tc := clientFromToken(token)
+	return tc.clientID, tc.hookMapping
+}
+
+// tokenClient - the client of a config token, regardless of the config times
+type tokenClient struct {
+	clientID           uint64
+	country            string
+	telephoneParameter string
+	maxDailySendCount  uint
+	bookingIdParameter string
+	signingSecret      string
+	allowedIPs         string
+	hookMapping        string
+}
+
+// queryClientFromToken - get the client from the config token (client ID 0 if not found)
+func queryClientFromToken(token string) (tokenClient, error) {
+	qry := "SELECT client.id, client.country, config.telephone_parameter, config.max_daily_send_count, config.booking_id_parameter," +
+		" config.signing_secret, config.allowed_ips, COALESCE(config.hook_mapping, '')" +
+		" FROM google_reviews_configs AS config" +
+		" JOIN clients AS client ON client.id = config.client_id" +
+		" WHERE config.token = ?" +
+		" AND config.enabled = 1" +
+		" AND client.enabled = 1"
+	var tc tokenClient
+	err := Db.QueryRow(qry, token).Scan(&tc.clientID, &tc.country, &tc.telephoneParameter, &tc.maxDailySendCount, &tc.bookingIdParameter,
+		&tc.signingSecret, &tc.allowedIPs, &tc.hookMapping)
+	switch {
+	case err == sql.ErrNoRows:
+		return tokenClient{}, nil
+	case err != nil:
+		log.Println("Error retrieving token", token, "from database. Error: ", err)
+		return tokenClient{}, err
+	}
+	tc.signingSecret = decryptSecret(tc.signingSecret)
+	return tc, nil
+}
+
+// ClientCountry - get the country for the client (empty string if not found)
+func ClientCountry(clientID uint64) string {
+	qry := "SELECT country FROM clients WHERE id = ?"
+	var country string
+	err := Db.QueryRow(qry, clientID).Scan(&country)
+	switch {
+	case err == sql.ErrNoRows:
+		return ""
+	case err != nil:
+		log.Println("Error retrieving country for clientID", clientID, "from database. Error: ", err)
+		return ""
+	default:
+		return country
+	}
 }
 
 // DailySentCount - daily sent count - used for throttling, prevent too many SMS being sent
+// When the config cache is enabled the count is kept in memory and reconciled with the database periodically.
 func DailySentCount(clientID uint64) uint {
-	qry := "SELECT COUNT(id) FROM google_reviews_last_sents WHERE last_sent_date = CURDATE() AND client_id = ?"
+	if count, ok := cachedDailySentCount(clientID); ok {
+		return count
+	}
+	return queryDailySentCount(clientID)
+}
+
+// queryDailySentCount - get the daily sent count from the database
+func queryDailySentCount(clientID uint64) uint {
+	// emails are counted with the SMS (an unsubscribed email address not yet emailed has a sent count of 0)
+	qry := "SELECT (SELECT COUNT(id) FROM google_reviews_last_sents WHERE last_sent_date = CURDATE() AND client_id = ?)" +
+		" + (SELECT COUNT(id) FROM google_reviews_email_last_sents WHERE last_sent_date = CURDATE() AND client_id = ? AND sent_count > 0)"
 	// row := db.QueryRow(qry, clientID)
 	var count uint
 	// err := row.Scan(&count)
-	err := Db.QueryRow(qry, clientID).Scan(&count)
+	err := Db.QueryRow(qry, clientID, clientID).Scan(&count)
 	switch {
 	case err == sql.ErrNoRows:
 		log.Println(err)
@@ -382,6 +689,57 @@ func DailySentCount(clientID uint64) uint {
 	}
 }
 
+// DailySendLaterCount - get the count of the send laters for the client due to be sent today, the messages deferred
+// (e.g. by pacing or the send delay) that will be counted by the daily sent count when sent. Held fallbacks and
+// resends are not counted as the message has already been counted.
+func DailySendLaterCount(clientID uint64) uint {
+	qry := "SELECT COUNT(id) FROM google_reviews_send_laters" +
+		" WHERE client_id = ? AND held = 0 AND resend = 0 AND send_after < CURDATE() + INTERVAL 1 DAY"
+	var count uint
+	if err := Db.QueryRow(qry, clientID).Scan(&count); err != nil {
+		log.Println(err)
+		return 0
+	}
+	return count
+}
+
+// ConfigTime - an enabled time of a config, the window (in the time zone) messages are sent in on the enabled days
+type ConfigTime struct {
+	Start    string
+	End      string
+	TimeZone string
+	Days     [7]bool // from Sunday
+}
+
+// ConfigTimes - get the enabled times of the enabled configs of the client
+func ConfigTimes(clientID uint64) []ConfigTime {
+	qry := "SELECT times.start, times.end, config.time_zone," +
+		" times.sunday, times.monday, times.tuesday, times.wednesday, times.thursday, times.friday, times.saturday" +
+		" FROM google_reviews_config_times AS times" +
+		" JOIN google_reviews_configs AS config ON config.id = times.google_reviews_config_id" +
+		" WHERE config.client_id = ?" +
+		" AND times.enabled = 1" +
+		" AND config.enabled = 1" +
+		" ORDER BY times.id"
+	var configTimes []ConfigTime
+	rows, err := Db.Query(qry, clientID)
+	if err != nil {
+		log.Println("Error retrieving config times for client", clientID, "from database. Error: ", err)
+		return configTimes
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ct ConfigTime
+		if err := rows.Scan(&ct.Start, &ct.End, &ct.TimeZone, &ct.Days[0], &ct.Days[1], &ct.Days[2], &ct.Days[3],
+			&ct.Days[4], &ct.Days[5], &ct.Days[6]); err != nil {
+			log.Println("Error retrieving config times for client", clientID, "from database whilst reading returned results. Error: ", err)
+			return configTimes
+		}
+		configTimes = append(configTimes, ct)
+	}
+	return configTimes
+}
+
 // QueueIDFromReviewMasterPairCode - get the queue ID (which normally is the client ID) from the
 // Review Master SMS Pairing Code with some checks.
 // When use master queue is enabled then the queue ID is set to the master queue.
@@ -429,11 +787,14 @@ func SetReviewMasterSMSGatewayMasterQueueID() {
 }
 
 // AddSendLater - add send later for messages that are delayed (send later)
+// fallbackRef - set for a fallback (e.g. SMS when sent via WhatsApp) which is held until released (see ReleaseFallback)
+// tlsSkipVerify - the TLS certificate of the send URL is not verified (the config opts out)
 func AddSendLater(telephone string, clientID uint64, sendAfterMinutes int,
 	sendURL string, method string, appKey string, secretKey string, headers map[string]string,
 	params url.Values, body []byte, sendFromIcabbiApp bool, reviewMasterSMSGatewayEnabled bool,
 	alternateMessageServiceEnabled bool, alternateMessageService string,
-	sendFromOwnSMSGatewayEnabled bool, sendSuccessResponse string, maxDailySendCount uint) {
+	sendFromOwnSMSGatewayEnabled bool, sendSuccessResponse string, maxDailySendCount uint, fallbackRef string,
+	tlsSkipVerify bool) {
 
 	// serialize headers
 	h := new(bytes.Buffer)
@@ -442,18 +803,20 @@ func AddSendLater(telephone string, clientID uint64, sendAfterMinutes int,
 	if err != nil {
 		h = nil
 	}
-	httpHeaders := h.Bytes()
-
-	// serialize params
-	httpParams := params.Encode()
+	// secrets are stored encrypted, the headers and params are encrypted as they carry secrets too (e.g. the
+	// iCabbi app and secret keys in the params, the Veezu auth token or WhatsApp access token in the headers)
+	httpHeaders := []byte(encryptSecret(string(h.Bytes())))
+	httpParams := encryptSecret(params.Encode())
+	appKey = encryptSecret(appKey)
+	secretKey = encryptSecret(secretKey)
 
 	qry := "INSERT INTO google_reviews_send_laters" +
 		" (telephone, send_after, send_url, http_method, app_key, secret_key," +
 		" http_headers, http_params, http_body, send_from_icabbi_app," +
 		" review_master_sms_gateway_enabled, alternate_message_service_enabled," +
 		" alternate_message_service, send_from_own_sms_gateway_enabled," +
-		" send_success_response, max_daily_send_count, client_id)" +
-		" VALUES (?, DATE_ADD(NOW(), INTERVAL ? MINUTE), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)" +
+		" send_success_response, max_daily_send_count, client_id, fallback_ref, held, tls_skip_verify)" +
+		" VALUES (?, DATE_ADD(NOW(), INTERVAL ? MINUTE), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)" +
 		" ON DUPLICATE KEY UPDATE" +
 		" send_after = DATE_ADD(NOW(), INTERVAL ? MINUTE)," +
 		" send_url = ?," +
@@ -469,15 +832,164 @@ func AddSendLater(telephone string, clientID uint64, sendAfterMinutes int,
 		" alternate_message_service = ?," +
 		" send_from_own_sms_gateway_enabled = ?," +
 		" send_success_response	= ?," +
-		" max_daily_send_count = ?"
+		" max_daily_send_count = ?," +
+		" tls_skip_verify = ?," +
+		" claimed_by = ''," +
+		" claimed_until = NULL," +
+		" attempts = 0"
 	_, err = Db.Exec(qry, telephone, sendAfterMinutes, sendURL, method, appKey, secretKey,
 		httpHeaders, httpParams, body, sendFromIcabbiApp, reviewMasterSMSGatewayEnabled,
 		alternateMessageServiceEnabled, alternateMessageService,
-		sendFromOwnSMSGatewayEnabled, sendSuccessResponse, maxDailySendCount, clientID,
+		sendFromOwnSMSGatewayEnabled, sendSuccessResponse, maxDailySendCount, clientID, fallbackRef, fallbackRef != "", tlsSkipVerify,
 		sendAfterMinutes, sendURL, method, appKey, secretKey, httpHeaders, httpParams,
 		body, sendFromIcabbiApp, reviewMasterSMSGatewayEnabled,
 		alternateMessageServiceEnabled, alternateMessageService,
-		sendFromOwnSMSGatewayEnabled, sendSuccessResponse, maxDailySendCount)
+		sendFromOwnSMSGatewayEnabled, sendSuccessResponse, maxDailySendCount, tlsSkipVerify)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// SendLater - a message stored to be sent later (see AddSendLater)
+type SendLater struct {
+	ID                             uint64
+	Telephone                      string
+	SendURL                        string
+	HttpMethod                     string
+	AppKey                         string
+	SecretKey                      string
+	Headers                        map[string]string
+	Params                         url.Values
+	Body                           []byte
+	SendFromIcabbiApp              bool
+	ReviewMasterSMSGatewayEnabled  bool
+	AlternateMessageServiceEnabled bool
+	AlternateMessageService        string
+	SendFromOwnSMSGatewayEnabled   bool
+	SendSuccessResponse            string
+	MaxDailySendCount              uint
+	ClientID                       uint64
+	Attempts                       uint
+	// FallbackRef - set for a fallback released after the message sent via the preferred channel (e.g. WhatsApp)
+	FallbackRef string
+	// Resend - the fallback resends a message already counted as sent (see ReleaseFallback)
+	Resend bool
+	// TLSSkipVerify - the TLS certificate of the send URL is not verified (the config opts out)
+	TLSSkipVerify bool
+}
+
+// ClaimSendLaters - claim send laters that are due to be sent, returning the claimed send laters.
+// The claim is held for claimSeconds, after which another worker can claim the send later again
+// (i.e. if this worker dies whilst processing). claimedBy must be unique for each worker so
+// more than one worker can run at the same time without sending the same message twice.
+func ClaimSendLaters(claimedBy string, claimSeconds int, limit int) []SendLater {
+	sendLaters := []SendLater{}
+	qry := "UPDATE google_reviews_send_laters" +
+		" SET claimed_by = ?, claimed_until = DATE_ADD(NOW(), INTERVAL ? SECOND)" +
+		" WHERE send_after <= NOW() AND held = 0" +
+		" AND (claimed_until IS NULL OR claimed_until < NOW())" +
+		" ORDER BY send_after" +
+		" LIMIT ?"
+	_, err := Db.Exec(qry, claimedBy, claimSeconds, limit)
+	if err != nil {
+		log.Println("Error claiming send laters, error: ", err)
+		return sendLaters
+	}
+
+	qry = "SELECT id, telephone, send_url, http_method, app_key, secret_key," +
+		" http_headers, http_params, http_body, send_from_icabbi_app," +
+		" review_master_sms_gateway_enabled, alternate_message_service_enabled," +
+		" alternate_message_service, send_from_own_sms_gateway_enabled," +
+		" send_success_response, max_daily_send_count, client_id, attempts, fallback_ref, resend, tls_skip_verify" +
+		" FROM google_reviews_send_laters" +
+		" WHERE claimed_by = ? AND claimed_until >= NOW()" +
+		" ORDER BY send_after"
+	rows, err := Db.Query(qry, claimedBy)
+	if err != nil {
+		log.Println("Error retrieving claimed send laters, error: ", err)
+		return sendLaters
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			sl          SendLater
+			httpHeaders []byte
+			httpParams  sql.NullString
+			clientID    sql.NullInt64
+		)
+		if err1 := rows.Scan(&sl.ID, &sl.Telephone, &sl.SendURL, &sl.HttpMethod, &sl.AppKey, &sl.SecretKey,
+			&httpHeaders, &httpParams, &sl.Body, &sl.SendFromIcabbiApp,
+			&sl.ReviewMasterSMSGatewayEnabled, &sl.AlternateMessageServiceEnabled,
+			&sl.AlternateMessageService, &sl.SendFromOwnSMSGatewayEnabled,
+			&sl.SendSuccessResponse, &sl.MaxDailySendCount, &clientID, &sl.Attempts, &sl.FallbackRef, &sl.Resend, &sl.TLSSkipVerify); err1 != nil {
+			log.Println("Error retrieving claimed send laters whilst reading returned results, error: ", err1)
+			continue
+		}
+		sl.ClientID = uint64(clientID.Int64)
+		sl.AppKey = decryptSecret(sl.AppKey)
+		sl.SecretKey = decryptSecret(sl.SecretKey)
+		httpHeaders = []byte(decryptSecret(string(httpHeaders)))
+		httpParams.String = decryptSecret(httpParams.String)
+		// deserialize headers
+		if len(httpHeaders) > 0 {
+			hd := gob.NewDecoder(bytes.NewReader(httpHeaders))
+			if err1 := hd.Decode(&sl.Headers); err1 != nil {
+				log.Printf("Error decoding headers for send later id: %d, error: %+v\n", sl.ID, err1)
+			}
+		}
+		// deserialize params
+		if httpParams.Valid && httpParams.String != "" {
+			params, err1 := url.ParseQuery(httpParams.String)
+			if err1 != nil {
+				log.Printf("Error decoding params for send later id: %d, error: %+v\n", sl.ID, err1)
+			}
+			sl.Params = params
+		}
+		sendLaters = append(sendLaters, sl)
+	}
+	return sendLaters
+}
+
+// DeleteSendLater - delete a send later once it has been processed.
+// Only deletes if still claimed by claimedBy, so a send later that has been replaced
+// by a new request (see AddSendLater) whilst being processed is not lost.
+func DeleteSendLater(id uint64, claimedBy string) {
+	qry := "DELETE FROM google_reviews_send_laters WHERE id = ? AND claimed_by = ?"
+	_, err := Db.Exec(qry, id, claimedBy)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// RetrySendLater - release the claim on a send later and try again after retryAfterMinutes, attempted when the send
+// was attempted (counted towards the max attempts) rather than deferred without sending (e.g. the daily send count
+// reached)
+func RetrySendLater(id uint64, claimedBy string, retryAfterMinutes int, attempted bool) {
+	qry := "UPDATE google_reviews_send_laters" +
+		" SET send_after = DATE_ADD(NOW(), INTERVAL ? MINUTE)," +
+		" claimed_by = ''," +
+		" claimed_until = NULL," +
+		" attempts = attempts + ?" +
+		" WHERE id = ? AND claimed_by = ?"
+	attempts := 0
+	if attempted {
+		attempts = 1
+	}
+	_, err := Db.Exec(qry, retryAfterMinutes, attempts, id, claimedBy)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// UpdateStatsSent - update the stats sent count only.
+// Used for send laters where the request has already been counted when the send later was added.
+func UpdateStatsSent(clientID uint64) {
+	qry := "INSERT INTO google_reviews_stats" +
+		" (client_id, stats_date, sent_count, requested_count)" +
+		" VALUES (?, CURDATE(), 1, 0)" +
+		" ON DUPLICATE KEY UPDATE" +
+		" sent_count = sent_count + 1"
+	_, err := Db.Exec(qry, clientID)
 	if err != nil {
 		log.Println(err)
 	}
@@ -489,29 +1001,7 @@ func UpdateStatsCanUseToken(clientID uint64, token string, sent bool) {
 	// if clientID is not set then try and retrieve it from the token (this will be the case when the token checks fail)
 	cID := clientID
 	if cID == 0 {
-		qry := "SELECT client.id" +
-			" FROM google_reviews_configs AS config" +
-			" JOIN clients AS client ON client.id = config.client_id" +
-			" WHERE config.token = ?" +
-			" AND config.enabled = 1" +
-			" AND client.enabled = 1"
-		rows, err := Db.Query(qry, token)
-		if err != nil {
-			log.Println("Error retrieving token", token, "from database, for stats. Error: ", err)
-			return
-		}
-		defer rows.Close()
-		for rows.Next() {
-			if err1 := rows.Scan(&cID); err1 != nil {
-				log.Println("Error retrieving token", token, "from database whilst reading returned results, for stats. Error: ", err1)
-				return
-			}
-			if cID == 0 {
-				log.Printf("token %s not found, for stats", token)
-				continue
-			}
-			break
-		}
+		cID = clientFromToken(token).clientID
 	}
 	if cID == 0 {
 		return
@@ -537,3 +1027,232 @@ func UpdateStatsCanUseToken(clientID uint64, token string, sent bool) {
 		log.Println(err)
 	}
 }
+
+// AddMessageEvent - record why a message was or wasn't sent for a telephone (stored hashed)
+// The channel is the message service used (if known), the provider response and latency are from sending the message.
+// Returns the message event id (0 if not recorded).
+func AddMessageEvent(clientID uint64, telephone string, channel string, reason string, providerResponse string, latency time.Duration) uint64 {
+	return AddMessageEventWithVariant(clientID, telephone, channel, reason, "", providerResponse, latency)
+}
+
+// AddMessageEventWithVariant - record a message event with the message variant sent (see AddMessageEvent)
+func AddMessageEventWithVariant(clientID uint64, telephone string, channel string, reason string, variant string, providerResponse string, latency time.Duration) uint64 {
+	if r := []rune(providerResponse); len(r) > maxProviderResponseLength {
+		providerResponse = string(r[:maxProviderResponseLength])
+	}
+	recordMessageEventMetrics(channel, reason, latency)
+	qry := "INSERT INTO google_reviews_message_events" +
+		" (client_id, telephone_hash, channel, variant, reason, provider_response, latency_ms, created)" +
+		" VALUES (?, ?, ?, ?, ?, ?, ?, NOW())"
+	res, err := Db.Exec(qry, clientID, utils.HashTelephone(telephone), channel, variant, reason, providerResponse, latency.Milliseconds())
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return uint64(id)
+}
+
+// LastSentClientID - the client that last sent a message to the telephone via the channel, a message sent by the
+// preferred client (e.g. of the reply queue) is used first. Returns 0 when no message was sent via the channel.
+func LastSentClientID(telephone string, channel string, preferredClientID uint64) uint64 {
+	qry := "SELECT client_id FROM google_reviews_message_events" +
+		" WHERE telephone_hash = ?" +
+		" AND channel = ?" +
+		" AND reason = ?" +
+		" ORDER BY client_id = ? DESC, created DESC, id DESC LIMIT 1"
+	var clientID uint64
+	err := Db.QueryRow(qry, utils.HashTelephone(telephone), channel, ReasonSent, preferredClientID).Scan(&clientID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+	}
+	return clientID
+}
+
+// AddOptOutMessageEvent - record an opt out reply against the last message sent to the telephone so the opt out is
+// attributed to the message variant sent, a client ID of 0 uses the last message sent by any client
+func AddOptOutMessageEvent(clientID uint64, telephone string, channel string) {
+	qry := "SELECT client_id, variant FROM google_reviews_message_events" +
+		" WHERE telephone_hash = ?" +
+		" AND reason IN (?, ?)"
+	args := []interface{}{utils.HashTelephone(telephone), ReasonSent, ReasonDeferred}
+	if clientID != 0 {
+		qry += " AND client_id = ?"
+		args = append(args, clientID)
+	}
+	qry += " ORDER BY created DESC, id DESC LIMIT 1"
+	var sentClientID uint64
+	var variant string
+	err := Db.QueryRow(qry, args...).Scan(&sentClientID, &variant)
+	switch {
+	case err == sql.ErrNoRows:
+		if clientID == 0 {
+			return
+		}
+		sentClientID = clientID
+	case err != nil:
+		log.Println(err)
+		return
+	}
+	AddMessageEventWithVariant(sentClientID, telephone, channel, ReasonOptedOut, variant, "", 0)
+}
+
+// MessageVariant - represents a named message variant of a config used for A/B testing messages
+type MessageVariant struct {
+	ID       uint64
+	Name     string
+	Language string // language of the variant, empty for a variant of the config message (see ConfigMessages)
+	Message  string
+	Weight   uint
+}
+
+// MessageVariants - get the enabled message variants for a config
+func MessageVariants(configID uint64) []MessageVariant {
+	qry := "SELECT id, name, language, message, weight" +
+		" FROM google_reviews_message_variants" +
+		" WHERE google_reviews_config_id = ?" +
+		" AND enabled = 1" +
+		" ORDER BY id"
+	var messageVariants []MessageVariant
+	rows, err := Db.Query(qry, configID)
+	if err != nil {
+		log.Println("Error retrieving message variants for config", configID, "from database. Error: ", err)
+		return messageVariants
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mv MessageVariant
+		if err := rows.Scan(&mv.ID, &mv.Name, &mv.Language, &mv.Message, &mv.Weight); err != nil {
+			log.Println("Error retrieving message variants for config", configID, "from database whilst reading returned results. Error: ", err)
+			return messageVariants
+		}
+		messageVariants = append(messageVariants, mv)
+	}
+	return messageVariants
+}
+
+// ConfigMessages - get the messages by language of a config, the message of the booking language replaces the config
+// message
+func ConfigMessages(configID uint64) map[string]string {
+	qry := "SELECT language, message" +
+		" FROM google_reviews_config_messages" +
+		" WHERE google_reviews_config_id = ?"
+	messages := make(map[string]string)
+	rows, err := Db.Query(qry, configID)
+	if err != nil {
+		log.Println("Error retrieving messages by language for config", configID, "from database. Error: ", err)
+		return messages
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var language, message string
+		if err := rows.Scan(&language, &message); err != nil {
+			log.Println("Error retrieving messages by language for config", configID, "from database whilst reading returned results. Error: ", err)
+			return messages
+		}
+		messages[language] = message
+	}
+	return messages
+}
+
+// BarredTelephones - get the barred telephone prefixes and full numbers (a client ID of 0 is barred for all clients)
+func BarredTelephones() ([]barred.Entry, error) {
+	qry := "SELECT client_id, telephone, full_number" +
+		" FROM google_reviews_barred_telephones" +
+		" ORDER BY id"
+	var entries []barred.Entry
+	rows, err := Db.Query(qry)
+	if err != nil {
+		log.Println("Error retrieving barred telephones from database. Error: ", err)
+		return entries, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e barred.Entry
+		if err := rows.Scan(&e.ClientID, &e.Telephone, &e.FullNumber); err != nil {
+			log.Println("Error retrieving barred telephones from database whilst reading returned results. Error: ", err)
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// ShortLink - represents a tracked short review link
+type ShortLink struct {
+	ID             uint64
+	Code           string
+	ClientID       uint64
+	MessageEventID uint64
+	Variant        string
+	URL            string
+}
+
+// maxUserAgentLength - maximum length of the user agent stored for a short link click
+const maxUserAgentLength = 255
+
+// AddShortLink - add a short link code for the client that redirects to the url, the variant is the message variant sent
+// Returns the short link id (0 if not added e.g. the code already exists).
+func AddShortLink(code string, clientID uint64, variant string, url string) uint64 {
+	qry := "INSERT INTO google_reviews_short_links" +
+		" (code, client_id, variant, url, created)" +
+		" VALUES (?, ?, ?, ?, NOW())"
+	res, err := Db.Exec(qry, code, clientID, variant, url)
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return uint64(id)
+}
+
+// SetShortLinkMessageEvent - set the message event for a short link once the message has been sent (or deferred)
+func SetShortLinkMessageEvent(shortLinkID uint64, messageEventID uint64) {
+	if shortLinkID == 0 || messageEventID == 0 {
+		return
+	}
+	qry := "UPDATE google_reviews_short_links SET message_event_id = ? WHERE id = ?"
+	_, err := Db.Exec(qry, messageEventID, shortLinkID)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// ShortLinkFromCode - get the short link for the code, returns whether found
+func ShortLinkFromCode(code string) (ShortLink, bool) {
+	qry := "SELECT id, code, client_id, message_event_id, variant, url" +
+		" FROM google_reviews_short_links" +
+		" WHERE code = ?"
+	var sl ShortLink
+	err := Db.QueryRow(qry, code).Scan(&sl.ID, &sl.Code, &sl.ClientID, &sl.MessageEventID, &sl.Variant, &sl.URL)
+	switch {
+	case err == sql.ErrNoRows:
+		return sl, false
+	case err != nil:
+		log.Println("Error retrieving short link", code, "from database. Error: ", err)
+		return sl, false
+	}
+	return sl, true
+}
+
+// AddShortLinkClick - record a click on a short link
+func AddShortLinkClick(sl ShortLink, userAgent string) {
+	if r := []rune(userAgent); len(r) > maxUserAgentLength {
+		userAgent = string(r[:maxUserAgentLength])
+	}
+	qry := "INSERT INTO google_reviews_short_link_clicks" +
+		" (short_link_id, message_event_id, user_agent, created)" +
+		" VALUES (?, ?, ?, NOW())"
+	_, err := Db.Exec(qry, sl.ID, sl.MessageEventID, userAgent)
+	if err != nil {
+		log.Println(err)
+	}
+}
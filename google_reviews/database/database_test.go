@@ -1,16 +1,22 @@
 package database
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	testfixtures "gopkg.in/testfixtures.v2"
+
+	"google_reviews/secrets"
+	"google_reviews/utils"
 )
 
 var fixtures *testfixtures.Context
@@ -22,6 +28,9 @@ func TestMain(m *testing.M) {
 	// Do NOT import fixtures in a production database!
 	// Existing data would be deleted
 	OpenDB(TestDbName, TestDbAddress, TestDbPort, TestDbUsername, TestDbPassword)
+	// the telephones are always hashed with the telephone hash key (the message events of the fixtures are hashed
+	// without it, recorded before the key was set)
+	utils.SetTelephoneHashKey("test-telephone-hash-key")
 
 	// creating the context that hold the fixtures
 	// see about all compatible databases in this page below
@@ -282,10 +291,13 @@ func TestStopSendingNoRecord(t *testing.T) {
 	StopSending(telephone, clientID)
 	lastSent, sentCount, stop, found := LastSentFromTelephoneAndClient(telephone, clientID)
 	fmt.Println("lastSent: ", lastSent, " sentCount: ", sentCount, " stop: ", stop, " found: ", found)
-	// check found is false
-	if found {
+	// a stop is kept before any message is sent
+	if !found || !stop || sentCount != 0 {
 		t.Fatal("stop should be set to true")
 	}
+	if count := DailySentCount(clientID); count != 1 {
+		t.Fatal("a stop should not be counted as sent today, daily sent count: ", count)
+	}
 }
 
 func TestDailySentCount(t *testing.T) {
@@ -297,6 +309,34 @@ func TestDailySentCount(t *testing.T) {
 	}
 }
 
+func TestDailySendLaterCount(t *testing.T) {
+	prepareTestDatabase()
+	var clientID uint64 = 2
+	if _, err := Db.Exec("DELETE FROM google_reviews_send_laters WHERE client_id = ?", clientID); err != nil {
+		t.Fatal(err)
+	}
+	AddSendLater("447000000002", clientID, 5, "https://example.com/send", "POST", "", "",
+		nil, url.Values{}, nil, false, false, false, "", false, "", 20, "", false)
+	// a held fallback is not counted
+	AddSendLater("447000000003", clientID, 5, "https://example.com/send", "POST", "", "",
+		nil, url.Values{}, nil, false, false, false, "", false, "", 20, "fallback-ref", false)
+	if count := DailySendLaterCount(clientID); count != 1 {
+		t.Fatalf("daily send later count should be 1 got: %d", count)
+	}
+}
+
+func TestConfigTimes(t *testing.T) {
+	prepareTestDatabase()
+	cts := ConfigTimes(1)
+	if len(cts) != 2 || cts[0].Start != "08:00" || cts[0].End != "14:00" || cts[0].TimeZone != "Europe/London" || !cts[0].Days[0] ||
+		cts[1].Start != "17:00" {
+		t.Fatalf("unexpected config times: %+v", cts)
+	}
+	if cts := ConfigTimes(0); len(cts) != 0 {
+		t.Fatalf("expected no config times got: %+v", cts)
+	}
+}
+
 func TestClientIDFromReviewMasterPairCode(t *testing.T) {
 	prepareTestDatabase()
 	reviewMasterSmsGatewayPairCode := "tpyh17azv43y"
@@ -363,7 +403,67 @@ func TestAddSendLater(t *testing.T) {
 
 	AddSendLater(telephone, clientID, 5,
 		"https://api.messagemedia.com/v1/messages", "POST", "12348GYxCGv5abcES7GF", "2HGFUd9i987Gv6018D1234cNhHDRONH",
-		headers, params1, body, true, false, false, "", false, "success=1", 20)
+		headers, params1, body, true, false, false, "", false, "success=1", 20, "", false)
+}
+
+func TestClaimSendLaters(t *testing.T) {
+	prepareTestDatabase()
+	var clientID uint64 = 1
+	telephone := "447123456786"
+
+	headers := map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	}
+
+	params1 := url.Values{}
+	params1.Add("t", telephone)
+	params1.Add("m", "some message")
+
+	// send after 0 minutes so it is due now
+	AddSendLater(telephone, clientID, 0,
+		"https://localhost/send", "POST", "", "",
+		headers, params1, nil, false, false, false, "", true, "OK", 20, "", true)
+
+	sendLaters := ClaimSendLaters("worker1", 60, 10)
+	var sl SendLater
+	for _, s := range sendLaters {
+		if s.Telephone == telephone && s.ClientID == clientID {
+			sl = s
+		}
+	}
+	if sl.ID == 0 {
+		t.Fatal("send later for telephone ", telephone, " not claimed")
+	}
+	if sl.Headers["Content-Type"] != "application/x-www-form-urlencoded" {
+		t.Fatal("send later headers not decoded, got: ", sl.Headers)
+	}
+	if sl.Params.Get("m") != "some message" {
+		t.Fatal("send later params not decoded, got: ", sl.Params)
+	}
+	if !sl.TLSSkipVerify {
+		t.Fatal("send later TLS skip verify not set")
+	}
+
+	// already claimed so another worker should not be able to claim it
+	for _, s := range ClaimSendLaters("worker2", 60, 10) {
+		if s.ID == sl.ID {
+			t.Fatal("send later claimed by worker1 was also claimed by worker2")
+		}
+	}
+
+	// cannot be deleted by a worker that has not claimed it
+	DeleteSendLater(sl.ID, "worker2")
+	var count int
+	Db.QueryRow("SELECT COUNT(id) FROM google_reviews_send_laters WHERE id = ?", sl.ID).Scan(&count)
+	if count != 1 {
+		t.Fatal("send later claimed by worker1 was deleted by worker2")
+	}
+
+	DeleteSendLater(sl.ID, "worker1")
+	Db.QueryRow("SELECT COUNT(id) FROM google_reviews_send_laters WHERE id = ?", sl.ID).Scan(&count)
+	if count != 0 {
+		t.Fatal("send later was not deleted by worker1")
+	}
 }
 
 func TestConfigFromTokenWithChecksDisabledClientAndConfig(t *testing.T) {
@@ -544,3 +644,699 @@ func TestUpdateStatsCanUseTokenNotSentWithoutClientIDWrongToken(t *testing.T) {
 		t.Fatal("Error there should be no results for stats for clientID", clientID, "from database. Error: ", err)
 	}
 }
+
+func TestGlobalStop(t *testing.T) {
+	prepareTestDatabase()
+	if !GlobalStop("447123456700") {
+		t.Fatal("global stop should be found for telephone 447123456700")
+	}
+	if GlobalStop("447123456789") {
+		t.Fatal("global stop should not be found for telephone 447123456789")
+	}
+	// global stop should set stop even though there is no last sent
+	_, _, stop, found := LastSentFromTelephoneAndClient("447123456700", 1)
+	if !stop || found {
+		t.Fatal("stop should be set and last sent not found for telephone 447123456700")
+	}
+}
+
+func TestStopSendingAllClients(t *testing.T) {
+	prepareTestDatabase()
+	telephone := "447123456789"
+	StopSendingAllClients(telephone)
+	if !GlobalStop(telephone) {
+		t.Fatal("global stop should be found for telephone ", telephone)
+	}
+	_, _, stop, _ := LastSentFromTelephoneAndClient(telephone, 1)
+	if !stop {
+		t.Fatal("stop should be set for telephone ", telephone)
+	}
+}
+
+func TestAddMessageEvent(t *testing.T) {
+	prepareTestDatabase()
+	AddMessageEvent(1, "447123456789", "HTTP", ReasonProviderError, strings.Repeat("x", 2000), 150*time.Millisecond)
+	var reason, providerResponse string
+	var latencyMs int
+	qry := "SELECT reason, provider_response, latency_ms FROM google_reviews_message_events" +
+		" WHERE client_id = ? AND telephone_hash = ? ORDER BY id DESC LIMIT 1"
+	if err := Db.QueryRow(qry, 1, utils.HashTelephone("447123456789")).Scan(&reason, &providerResponse, &latencyMs); err != nil {
+		t.Fatal("error getting message event, err: ", err)
+	}
+	if reason != ReasonProviderError || len(providerResponse) != maxProviderResponseLength || latencyMs != 150 {
+		t.Fatalf("unexpected message event reason: %s, provider response length: %d, latency: %d", reason, len(providerResponse), latencyMs)
+	}
+}
+
+func TestRejectedConfigFromToken(t *testing.T) {
+	prepareTestDatabase()
+	clientID, country, telephoneParameter, reason := RejectedConfigFromToken("QxrH0iJc3wv/lj/YKVppNYRad7tN0Z3x")
+	if clientID != 1 || country != "GB" || telephoneParameter != "t" {
+		t.Fatalf("unexpected rejected config clientID: %d, country: %s, telephone parameter: %s", clientID, country, telephoneParameter)
+	}
+	if reason != ReasonOutsideHours && reason != ReasonMaxDailyCount {
+		t.Fatalf("unexpected rejected config reason: %s", reason)
+	}
+	if clientID, _, _, _ := RejectedConfigFromToken("unknown"); clientID != 0 {
+		t.Fatalf("unknown token should not be found got clientID: %d", clientID)
+	}
+}
+
+func TestShortLink(t *testing.T) {
+	prepareTestDatabase()
+	id := AddShortLink("xY7zW2qR", 1, "2", "https://g.page/r/test/review")
+	if id == 0 {
+		t.Fatal("short link should have been added")
+	}
+	if AddShortLink("xY7zW2qR", 1, "2", "https://g.page/r/test/review") != 0 {
+		t.Fatal("short link with an existing code should not have been added")
+	}
+	messageEventID := AddMessageEvent(1, "447123456789", "HTTP", ReasonSent, "OK", 0)
+	SetShortLinkMessageEvent(id, messageEventID)
+	sl, found := ShortLinkFromCode("xY7zW2qR")
+	if !found {
+		t.Fatal("short link should have been found")
+	}
+	if sl.ID != id || sl.ClientID != 1 || sl.MessageEventID != messageEventID || sl.Variant != "2" || sl.URL != "https://g.page/r/test/review" {
+		t.Fatalf("unexpected short link: %+v", sl)
+	}
+	AddShortLinkClick(sl, strings.Repeat("x", 300))
+	var clicks int
+	if err := Db.QueryRow("SELECT COUNT(id) FROM google_reviews_short_link_clicks WHERE short_link_id = ? AND message_event_id = ?", id, messageEventID).Scan(&clicks); err != nil {
+		t.Fatal("error getting short link clicks, err: ", err)
+	}
+	if clicks != 1 {
+		t.Fatalf("expected 1 short link click got %d", clicks)
+	}
+	if _, found := ShortLinkFromCode("unknown1"); found {
+		t.Fatal("unknown short link should not have been found")
+	}
+}
+
+func TestMessageVariants(t *testing.T) {
+	prepareTestDatabase()
+	mvs := MessageVariants(12)
+	// disabled variants should not be returned
+	if len(mvs) != 3 || mvs[0].Name != "short" || mvs[0].Weight != 1 || mvs[1].Name != "friendly" || mvs[1].Weight != 3 ||
+		mvs[0].Language != "" || mvs[2].Name != "nl_friendly" || mvs[2].Language != "nl" {
+		t.Fatalf("unexpected message variants: %+v", mvs)
+	}
+	if mvs := MessageVariants(1); len(mvs) != 0 {
+		t.Fatalf("expected no message variants for config 1 got: %+v", mvs)
+	}
+}
+
+func TestConfigMessages(t *testing.T) {
+	prepareTestDatabase()
+	messages := ConfigMessages(12)
+	if len(messages) != 2 || messages["nl"] != "Beoordeel ons alstublieft {review_link}" || messages["fr"] == "" {
+		t.Fatalf("unexpected messages by language: %+v", messages)
+	}
+	if messages := ConfigMessages(1); len(messages) != 0 {
+		t.Fatalf("expected no messages by language for config 1 got: %+v", messages)
+	}
+}
+
+func TestAddOptOutMessageEvent(t *testing.T) {
+	prepareTestDatabase()
+	AddMessageEventWithVariant(1, "447123456789", "HTTP", ReasonSent, "friendly", "OK", 0)
+	AddOptOutMessageEvent(1, "447123456789", "own SMS gateway")
+	var clientID uint64
+	var reason, variant string
+	qry := "SELECT client_id, reason, variant FROM google_reviews_message_events" +
+		" WHERE telephone_hash = ? ORDER BY id DESC LIMIT 1"
+	if err := Db.QueryRow(qry, utils.HashTelephone("447123456789")).Scan(&clientID, &reason, &variant); err != nil {
+		t.Fatal("error getting message event, err: ", err)
+	}
+	if clientID != 1 || reason != ReasonOptedOut || variant != "friendly" {
+		t.Fatalf("unexpected opt out message event clientID: %d, reason: %s, variant: %s", clientID, reason, variant)
+	}
+	// global opt out (unknown client) uses the client of the last message sent
+	AddMessageEventWithVariant(3, "447123456789", "HTTP", ReasonSent, "short", "OK", 0)
+	AddOptOutMessageEvent(0, "447123456789", "own SMS gateway")
+	if err := Db.QueryRow(qry, utils.HashTelephone("447123456789")).Scan(&clientID, &reason, &variant); err != nil {
+		t.Fatal("error getting message event, err: ", err)
+	}
+	if clientID != 3 || reason != ReasonOptedOut || variant != "short" {
+		t.Fatalf("unexpected global opt out message event clientID: %d, reason: %s, variant: %s", clientID, reason, variant)
+	}
+}
+
+func TestLastSentClientID(t *testing.T) {
+	prepareTestDatabase()
+	telephone := "447700900321"
+	if clientID := LastSentClientID(telephone, "REVIEW_MASTER_SMS_GATEWAY", 0); clientID != 0 {
+		t.Fatal("no client should be found before a message is sent, got: ", clientID)
+	}
+	AddMessageEvent(2, telephone, "REVIEW_MASTER_SMS_GATEWAY", ReasonSent, "", 0)
+	AddMessageEvent(3, telephone, "REVIEW_MASTER_SMS_GATEWAY", ReasonSent, "", 0)
+	AddMessageEvent(4, telephone, "HTTP", ReasonSent, "", 0)
+	// the last message sent via the channel unless the preferred client sent one
+	if clientID := LastSentClientID(telephone, "REVIEW_MASTER_SMS_GATEWAY", 0); clientID != 3 {
+		t.Fatal("expected clientID 3 got: ", clientID)
+	}
+	if clientID := LastSentClientID(telephone, "REVIEW_MASTER_SMS_GATEWAY", 2); clientID != 2 {
+		t.Fatal("expected the preferred clientID 2 got: ", clientID)
+	}
+	if clientID := LastSentClientID(telephone, "REVIEW_MASTER_SMS_GATEWAY", 4); clientID != 3 {
+		t.Fatal("expected clientID 3 when the preferred client did not send via the channel got: ", clientID)
+	}
+}
+
+func TestConfigCache(t *testing.T) {
+	prepareTestDatabase()
+	EnableConfigCache(time.Minute, time.Minute)
+	defer EnableConfigCache(0, 0)
+
+	token := "QxrH0iJc3wv/lj/YKVppNYRad7tN0Z3x"
+	grcftwc := ConfigFromTokenWithChecks(token, true)
+	if grcftwc.ClientID == 0 {
+		t.Fatal("token ", token, " not found")
+	}
+	if _, err := Db.Exec("UPDATE google_reviews_configs SET enabled = 0 WHERE token = ?", token); err != nil {
+		t.Fatal(err)
+	}
+	// cached until invalidated
+	if c := ConfigFromTokenWithChecks(token, true); c.ClientID != grcftwc.ClientID {
+		t.Fatal("config should be cached")
+	}
+	if clientID, _ := ClientIDAndCountryFromToken(token); clientID != grcftwc.ClientID {
+		t.Fatal("client should be cached")
+	}
+	InvalidateConfig(token)
+	if c := ConfigFromTokenWithChecks(token, true); c.ClientID != 0 {
+		t.Fatal("config should have been invalidated")
+	}
+	if clientID, _ := ClientIDAndCountryFromToken(token); clientID != 0 {
+		t.Fatal("client should have been invalidated")
+	}
+}
+
+func TestConfigMessagesCached(t *testing.T) {
+	prepareTestDatabase()
+	EnableConfigCache(time.Minute, time.Minute)
+	defer EnableConfigCache(0, 0)
+
+	token := "QxrH0iJc3wv/lj/YKVppNYRad7tN0Z3x123"
+	grcftwc := ConfigFromTokenWithChecks(token, true)
+	if grcftwc.ConfigID != 12 || len(grcftwc.Messages) != 2 || len(grcftwc.MessageVariants) != 3 {
+		t.Fatalf("the messages and message variants should be loaded with the config got: %+v %+v", grcftwc.Messages, grcftwc.MessageVariants)
+	}
+	if _, err := Db.Exec("DELETE FROM google_reviews_config_messages WHERE google_reviews_config_id = 12"); err != nil {
+		t.Fatal(err)
+	}
+	// cached until invalidated
+	if c := ConfigFromTokenWithChecks(token, true); len(c.Messages) != 2 {
+		t.Fatal("the messages should be cached with the config")
+	}
+	InvalidateConfig(token)
+	if c := ConfigFromTokenWithChecks(token, true); len(c.Messages) != 0 {
+		t.Fatal("the messages should have been invalidated with the config")
+	}
+}
+
+func TestDailySentCountCached(t *testing.T) {
+	prepareTestDatabase()
+	EnableConfigCache(time.Minute, time.Minute)
+	defer EnableConfigCache(0, 0)
+
+	count := DailySentCount(1)
+	if count != 1 {
+		t.Fatal("daily sent count incorrect should be 1")
+	}
+	// the in memory count is incremented without reading the database
+	UpdateLastSent("+447000000001", 1, 1)
+	if _, err := Db.Exec("DELETE FROM google_reviews_last_sents WHERE client_id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if count := DailySentCount(1); count != 2 {
+		t.Fatalf("in memory daily sent count should be 2 got: %d", count)
+	}
+	// reconciled with the database
+	EnableConfigCache(time.Minute, 0)
+	if count := DailySentCount(1); count != 0 {
+		t.Fatalf("reconciled daily sent count should be 0 got: %d", count)
+	}
+}
+
+func TestBarredTelephones(t *testing.T) {
+	prepareTestDatabase()
+	entries, err := BarredTelephones()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ClientID != 0 || entries[0].Telephone != "447418" || entries[0].FullNumber ||
+		entries[1].ClientID != 12 || entries[1].Telephone != "447123456701" || !entries[1].FullNumber {
+		t.Fatalf("unexpected barred telephones: %+v", entries)
+	}
+}
+
+func TestClaimBooking(t *testing.T) {
+	prepareTestDatabase()
+	// disabled
+	if claimed, _ := ClaimBooking(12, "1001"); !claimed {
+		t.Fatal("booking should be processed when the processed bookings are disabled")
+	}
+	EnableProcessedBookings(time.Hour, time.Minute)
+	defer EnableProcessedBookings(0, 0)
+
+	// already processed
+	if claimed, response := ClaimBooking(12, "1001"); claimed || response != `{"success":"1"}` {
+		t.Fatalf("booking should have already been processed, claimed: %v, response: %s", claimed, response)
+	}
+	// expired
+	if claimed, _ := ClaimBooking(12, "1002"); !claimed {
+		t.Fatal("expired booking should be processed again")
+	}
+	// new booking, a repeated delivery whilst being processed has no response
+	if claimed, _ := ClaimBooking(12, "1003"); !claimed {
+		t.Fatal("new booking should be processed")
+	}
+	if claimed, response := ClaimBooking(12, "1003"); claimed || response != "" {
+		t.Fatalf("booking being processed should not be processed again, claimed: %v, response: %s", claimed, response)
+	}
+	CompleteBooking(12, "1003", "OK")
+	if response, found := ProcessedBooking(12, "1003"); !found || response != "OK" {
+		t.Fatalf("unexpected processed booking response: %s, found: %v", response, found)
+	}
+	// a released booking (not processed) is processed again
+	if claimed, _ := ClaimBooking(12, "1004"); !claimed {
+		t.Fatal("new booking should be processed")
+	}
+	ReleaseBooking(12, "1004")
+	if claimed, _ := ClaimBooking(12, "1004"); !claimed {
+		t.Fatal("released booking should be processed again")
+	}
+	// a completed booking is not released
+	ReleaseBooking(12, "1003")
+	if _, found := ProcessedBooking(12, "1003"); !found {
+		t.Fatal("completed booking should not be released")
+	}
+	// the claim of a booking not completed expires after the lease, the response is kept for the ttl
+	EnableProcessedBookings(time.Hour, time.Second)
+	if claimed, _ := ClaimBooking(12, "1005"); !claimed {
+		t.Fatal("new booking should be processed")
+	}
+	time.Sleep(2 * time.Second)
+	if claimed, _ := ClaimBooking(12, "1005"); !claimed {
+		t.Fatal("booking should be processed again once the claim has expired")
+	}
+	CompleteBooking(12, "1005", "OK")
+	time.Sleep(2 * time.Second)
+	if response, found := ProcessedBooking(12, "1005"); !found || response != "OK" {
+		t.Fatalf("completed booking should be kept for the ttl, response: %s, found: %v", response, found)
+	}
+	// same booking ID for another client
+	if claimed, _ := ClaimBooking(1, "1003"); !claimed {
+		t.Fatal("booking of another client should be processed")
+	}
+	// no booking ID
+	if claimed, _ := ClaimBooking(12, ""); !claimed {
+		t.Fatal("booking without a booking ID should be processed")
+	}
+}
+
+func TestPurgeProcessedBookings(t *testing.T) {
+	prepareTestDatabase()
+	if n := PurgeProcessedBookings(); n != 1 {
+		t.Fatalf("expected 1 expired processed booking to be deleted got %d", n)
+	}
+	EnableProcessedBookings(time.Hour, time.Minute)
+	defer EnableProcessedBookings(0, 0)
+	if _, found := ProcessedBooking(12, "1001"); !found {
+		t.Fatal("processed booking that has not expired should not be deleted")
+	}
+}
+
+func TestBookingClientFromToken(t *testing.T) {
+	prepareTestDatabase()
+	if clientID, bookingIdParameter := BookingClientFromToken("OYBpBsZ9OhR-nbsupMQU_hCTJuaabtZ1gsfsfp"); clientID != 12 || bookingIdParameter != "b" {
+		t.Fatalf("unexpected client ID: %d, booking ID parameter: %s", clientID, bookingIdParameter)
+	}
+	if clientID, _ := BookingClientFromToken("incorrect"); clientID != 0 {
+		t.Fatalf("unexpected client ID: %d for an incorrect token", clientID)
+	}
+}
+
+func TestRequestVerificationFromToken(t *testing.T) {
+	prepareTestDatabase()
+	clientID, signingSecret, allowedIPs := RequestVerificationFromToken("sgnd7Kq2Wv9Xc4Lm8Np3Rt6Yb1Hd5Jf0")
+	if clientID != 12 || signingSecret != "test-signing-secret" || len(allowedIPs) != 2 || allowedIPs[0] != "10.0.0.0/8" || allowedIPs[1] != "192.0.2.1" {
+		t.Fatalf("unexpected request verification, client ID: %d, signing secret: %s, allowed IPs: %v", clientID, signingSecret, allowedIPs)
+	}
+	if clientID, signingSecret, allowedIPs := RequestVerificationFromToken("OYBpBsZ9OhR-nbsupMQU_hCTJuaabtZ1gsfsfp"); clientID != 12 || signingSecret != "" || len(allowedIPs) != 0 {
+		t.Fatalf("unexpected request verification, client ID: %d, signing secret: %s, allowed IPs: %v", clientID, signingSecret, allowedIPs)
+	}
+}
+
+func TestHookMappingFromToken(t *testing.T) {
+	prepareTestDatabase()
+	if clientID, mapping := HookMappingFromToken("OYBpBsZ9OhR-nbsupMQU_hCTJuaabtZ1gsfsfp"); clientID != 12 || !strings.Contains(mapping, `"telephone":"$.passenger.phone"`) {
+		t.Fatalf("unexpected client ID: %d, hook mapping: %s", clientID, mapping)
+	}
+	if clientID, mapping := HookMappingFromToken("sgnd7Kq2Wv9Xc4Lm8Np3Rt6Yb1Hd5Jf0"); clientID != 12 || mapping != "" {
+		t.Fatalf("unexpected client ID: %d, hook mapping: %s", clientID, mapping)
+	}
+}
+
+func TestUpdateDeliveryStatus(t *testing.T) {
+	prepareTestDatabase()
+	// the stats are not fixtures so only the change is checked
+	deliveredCount := func() int {
+		var count int
+		if err := Db.QueryRow("SELECT IFNULL(SUM(delivered_count), 0) FROM google_reviews_stats WHERE client_id = 12").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	before := deliveredCount()
+	if clientID := UpdateDeliveryStatus("Twilio", "SM0123456789abcdef0123456789abcdef", DeliveryStatusDelivered); clientID != 12 {
+		t.Fatalf("unexpected client ID: %d", clientID)
+	}
+	var status string
+	if err := Db.QueryRow("SELECT delivery_status FROM google_reviews_message_events WHERE id = 4").Scan(&status); err != nil || status != DeliveryStatusDelivered {
+		t.Fatalf("unexpected delivery status: %s, err: %v", status, err)
+	}
+	// a repeated callback is still found
+	if clientID := UpdateDeliveryStatus("Twilio", "SM0123456789abcdef0123456789abcdef", DeliveryStatusDelivered); clientID != 12 {
+		t.Fatalf("unexpected client ID: %d for a repeated callback", clientID)
+	}
+	// only the first delivery status is counted
+	if after := deliveredCount(); after != before+1 {
+		t.Fatalf("expected the delivered count to increase by 1 got: %d before: %d", after, before)
+	}
+	if clientID := UpdateDeliveryStatus("Message Media", "SM0123456789abcdef0123456789abcdef", DeliveryStatusFailed); clientID != 0 {
+		t.Fatalf("unexpected client ID: %d for another channel", clientID)
+	}
+
+	// the provider message ID of a message sent
+	id := AddMessageEvent(12, "447123456789", "Twilio", ReasonSent, `{"sid":"SM1"}`, 0)
+	SetMessageEventProviderMessageID(id, "SM1")
+	if clientID := UpdateDeliveryStatus("Twilio", "SM1", DeliveryStatusUndelivered); clientID != 12 {
+		t.Fatalf("unexpected client ID: %d", clientID)
+	}
+}
+
+func TestAlternateMessageServiceSecrets(t *testing.T) {
+	prepareTestDatabase()
+	secrets := AlternateMessageServiceSecrets("Twilio", "/Accounts/AC0123456789abcdef0123456789abcdef/")
+	if len(secrets) != 1 || secrets[0] != "test-twilio-auth-token" {
+		t.Fatalf("unexpected secrets: %v", secrets)
+	}
+	if secrets := AlternateMessageServiceSecrets("Twilio", "/Accounts/AC00000000000000000000000000000000/"); len(secrets) != 0 {
+		t.Fatalf("unexpected secrets: %v for another account", secrets)
+	}
+}
+
+func TestEncryptSecrets(t *testing.T) {
+	prepareTestDatabase()
+	if _, err := Db.Exec("DELETE FROM google_reviews_data_keys"); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "secrets.key")
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyProvider, err := secrets.NewFileKeyProvider(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.SetKeyProvider(keyProvider)
+	defer secrets.SetKeyProvider(nil)
+
+	storedSecret := func() string {
+		var secret string
+		if err := Db.QueryRow("SELECT alternate_message_service_secret1 FROM google_reviews_configs" +
+			" WHERE alternate_message_service_secret1 != '' ORDER BY id LIMIT 1").Scan(&secret); err != nil {
+			t.Fatal(err)
+		}
+		return secret
+	}
+	twilioSecret := func() {
+		if s := AlternateMessageServiceSecrets("Twilio", "/Accounts/AC0123456789abcdef0123456789abcdef/"); len(s) != 1 || s[0] != "test-twilio-auth-token" {
+			t.Fatalf("unexpected secrets: %v", s)
+		}
+	}
+
+	if n, err := EncryptSecrets(); err != nil || n == 0 {
+		t.Fatalf("expected the secrets encrypted, got: %d, err: %v", n, err)
+	}
+	encrypted := storedSecret()
+	if !secrets.IsEncrypted(encrypted) {
+		t.Fatalf("expected the stored secret encrypted got: %s", encrypted)
+	}
+	twilioSecret()
+	if n, _ := EncryptSecrets(); n != 0 {
+		t.Fatalf("expected no secrets encrypted again, got: %d", n)
+	}
+
+	// send laters are stored encrypted and claimed decrypted
+	AddSendLater("447123456780", 12, 0, "https://localhost/send", "GET", "app-key", "secret-key",
+		map[string]string{"Authorization": "Bearer access-token"}, url.Values{"app_key": {"app-key"}}, nil, true, false,
+		false, "", false, "OK", 20, "", false)
+	var storedAppKey, storedHeaders, storedParams string
+	if err := Db.QueryRow("SELECT app_key, http_headers, http_params FROM google_reviews_send_laters WHERE client_id = 12 AND telephone = ?",
+		"447123456780").Scan(&storedAppKey, &storedHeaders, &storedParams); err != nil {
+		t.Fatal(err)
+	}
+	if !secrets.IsEncrypted(storedAppKey) || !secrets.IsEncrypted(storedHeaders) || !secrets.IsEncrypted(storedParams) {
+		t.Fatalf("expected the stored app key, headers and params encrypted got: %s %s %s", storedAppKey, storedHeaders, storedParams)
+	}
+	for _, sl := range ClaimSendLaters("test-encrypt-secrets", 60, 100) {
+		if sl.Telephone == "447123456780" && (sl.AppKey != "app-key" || sl.SecretKey != "secret-key" ||
+			sl.Headers["Authorization"] != "Bearer access-token" || sl.Params.Get("app_key") != "app-key") {
+			t.Fatalf("expected the send later secrets decrypted, got: %+v", sl)
+		}
+	}
+
+	// rotate the data key and the key file
+	newKeyFile := filepath.Join(t.TempDir(), "new_secrets.key")
+	if err := ioutil.WriteFile(newKeyFile, []byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("n", 32)))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	newKeyProvider, err := secrets.NewFileKeyProvider(newKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := RotateDataKey(newKeyProvider); err != nil || n == 0 {
+		t.Fatalf("expected the secrets re-encrypted, got: %d, err: %v", n, err)
+	}
+	if rotated := storedSecret(); rotated == encrypted || !secrets.EncryptedWithActiveKey(rotated) {
+		t.Fatalf("expected the stored secret re-encrypted with the new data key got: %s", rotated)
+	}
+	twilioSecret()
+}
+
+func TestFallback(t *testing.T) {
+	prepareTestDatabase()
+	var clientID uint64 = 12
+	telephone := "447123456785"
+	params := url.Values{"To": {"+" + telephone}, "Body": {"testing"}}
+
+	// a held fallback is not claimed until released
+	AddSendLater(telephone, clientID, 0,
+		"https://localhost/send", "POST", "", "",
+		nil, params, nil, false, false, true, "Twilio", false, "OK", 20, "ref1", false)
+	claimed := func() (SendLater, bool) {
+		for _, s := range ClaimSendLaters("worker1", 60, 10) {
+			if s.Telephone == telephone && s.ClientID == clientID {
+				return s, true
+			}
+		}
+		return SendLater{}, false
+	}
+	if _, ok := claimed(); ok {
+		t.Fatal("held fallback was claimed")
+	}
+	if ReleaseFallback("ref2", true) {
+		t.Fatal("released an unknown fallback")
+	}
+	if !ReleaseFallback("ref1", true) {
+		t.Fatal("held fallback not released")
+	}
+	sl, ok := claimed()
+	if !ok || sl.FallbackRef != "ref1" || !sl.Resend {
+		t.Fatalf("released fallback not claimed for resending, got: %+v", sl)
+	}
+	// already released
+	if ReleaseFallback("ref1", true) {
+		t.Fatal("fallback released twice")
+	}
+	DeleteSendLater(sl.ID, "worker1")
+
+	// deleted once delivered
+	AddSendLater(telephone, clientID, 0,
+		"https://localhost/send", "POST", "", "",
+		nil, params, nil, false, false, true, "Twilio", false, "OK", 20, "ref3", false)
+	DeleteFallback("ref3")
+	if ReleaseFallback("ref3", false) {
+		t.Fatal("deleted fallback was released")
+	}
+
+	// expired
+	AddSendLater(telephone, clientID, 0,
+		"https://localhost/send", "POST", "", "",
+		nil, params, nil, false, false, true, "Twilio", false, "OK", 20, "ref4", false)
+	Db.Exec("UPDATE google_reviews_send_laters SET send_after = DATE_SUB(NOW(), INTERVAL 25 HOUR) WHERE fallback_ref = ?", "ref4")
+	if n := DeleteExpiredFallbacks(24); n != 1 {
+		t.Fatalf("expected 1 expired fallback deleted got: %d", n)
+	}
+}
+
+func TestClientIDAndCountryFromWhatsAppPhoneNumberID(t *testing.T) {
+	prepareTestDatabase()
+	if clientID, country := ClientIDAndCountryFromWhatsAppPhoneNumberID("109876543210987"); clientID != 12 || country != "GB" {
+		t.Fatalf("unexpected client ID: %d, country: %s", clientID, country)
+	}
+	if clientID, _ := ClientIDAndCountryFromWhatsAppPhoneNumberID("000000000000000"); clientID != 0 {
+		t.Fatalf("unexpected client ID: %d for an unknown phone number ID", clientID)
+	}
+}
+
+func TestEmailLastSent(t *testing.T) {
+	prepareTestDatabase()
+	var clientID uint64 = 12
+	if _, sentCount, stop, found := LastSentFromEmailAndClient("jane@example.com", clientID); !found || sentCount != 1 || stop {
+		t.Fatalf("unexpected last sent, sent count: %d, stop: %t, found: %t", sentCount, stop, found)
+	}
+	if _, _, stop, found := LastSentFromEmailAndClient("john@example.com", clientID); !found || !stop {
+		t.Fatalf("expected stop, stop: %t, found: %t", stop, found)
+	}
+	if _, _, _, found := LastSentFromEmailAndClient("jane@example.com", 1); found {
+		t.Fatal("found the last sent of another client")
+	}
+
+	// the emails are counted in the daily sent count
+	count := queryDailySentCount(clientID)
+	UpdateEmailLastSent("jane@example.com", clientID, 2)
+	lastSent, sentCount, _, _ := LastSentFromEmailAndClient("jane@example.com", clientID)
+	if sentCount != 2 || time.Since(lastSent) > time.Minute {
+		t.Fatalf("unexpected last sent: %v, sent count: %d", lastSent, sentCount)
+	}
+	if c := queryDailySentCount(clientID); c != count+1 {
+		t.Fatalf("expected daily sent count: %d got: %d", count+1, c)
+	}
+
+	// unsubscribed before being emailed is not counted
+	StopSendingEmail("new@example.com", clientID)
+	if _, _, stop, found := LastSentFromEmailAndClient("new@example.com", clientID); !found || !stop {
+		t.Fatalf("expected stop, stop: %t, found: %t", stop, found)
+	}
+	if c := queryDailySentCount(clientID); c != count+1 {
+		t.Fatalf("expected daily sent count: %d got: %d", count+1, c)
+	}
+	StopSendingEmail("jane@example.com", clientID)
+	if _, sentCount, stop, _ := LastSentFromEmailAndClient("jane@example.com", clientID); !stop || sentCount != 2 {
+		t.Fatalf("expected stop keeping the sent count, stop: %t, sent count: %d", stop, sentCount)
+	}
+}
+
+func TestUpdateLastSentBeforeHashed(t *testing.T) {
+	prepareTestDatabase()
+	var clientID uint64 = 1
+
+	// sent to again before the last sent stored before the telephone hash (stopped) is hashed
+	UpdateLastSent("447123456788", clientID, 4)
+	var count int
+	if err := Db.QueryRow("SELECT COUNT(id) FROM google_reviews_last_sents WHERE telephone = ?", "447123456788").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("expected the last sent not hashed when sent, got: %d, err: %v", count, err)
+	}
+	if _, sentCount, stop, found := LastSentFromTelephoneAndClient("447123456788", clientID); !found || !stop || sentCount != 4 {
+		t.Fatalf("unexpected last sent, sent count: %d, stop: %t, found: %t", sentCount, stop, found)
+	}
+
+	// replaced by the hashed last sent keeping the stop
+	HashStoredTelephones(100)
+	if err := Db.QueryRow("SELECT COUNT(id) FROM google_reviews_last_sents WHERE client_id = ? AND (telephone = ? OR telephone_hash = ?)",
+		clientID, "447123456788", utils.HashTelephone("447123456788")).Scan(&count); err != nil || count != 1 {
+		t.Fatalf("expected one last sent once hashed, got: %d, err: %v", count, err)
+	}
+	if _, sentCount, stop, found := LastSentFromTelephoneAndClient("447123456788", clientID); !found || !stop || sentCount != 4 {
+		t.Fatalf("unexpected last sent once hashed, sent count: %d, stop: %t, found: %t", sentCount, stop, found)
+	}
+}
+
+func TestHashStoredTelephones(t *testing.T) {
+	prepareTestDatabase()
+	var clientID uint64 = 1
+	// stored hashed
+	if _, sentCount, stop, found := LastSentFromTelephoneAndClient("447123456781", clientID); !found || !stop || sentCount != 1 {
+		t.Fatalf("unexpected hashed last sent, sent count: %d, stop: %t, found: %t", sentCount, stop, found)
+	}
+	// stored before the telephone hash
+	lastSent, sentCount, stop, found := LastSentFromTelephoneAndClient("447123456788", clientID)
+	if !found || !stop || sentCount != 3 {
+		t.Fatalf("unexpected last sent, sent count: %d, stop: %t, found: %t", sentCount, stop, found)
+	}
+	if !GlobalStop("447123456700") {
+		t.Fatal("expected the global stop")
+	}
+	if n := HashStoredTelephones(100); n != 4 {
+		t.Fatalf("expected 4 telephones hashed got: %d", n)
+	}
+	if n := HashStoredTelephones(100); n != 0 {
+		t.Fatalf("expected no more telephones hashed got: %d", n)
+	}
+	var count int
+	if err := Db.QueryRow("SELECT (SELECT COUNT(id) FROM google_reviews_last_sents WHERE telephone IS NOT NULL)" +
+		" + (SELECT COUNT(id) FROM google_reviews_global_stops WHERE telephone IS NOT NULL)").Scan(&count); err != nil || count != 0 {
+		t.Fatalf("expected no telephones stored got: %d, err: %v", count, err)
+	}
+	if l, sc, s, f := LastSentFromTelephoneAndClient("447123456788", clientID); !f || !s || sc != sentCount || !l.Equal(lastSent) {
+		t.Fatalf("unexpected last sent once hashed, sent count: %d, stop: %t, found: %t", sc, s, f)
+	}
+	if !GlobalStop("447123456700") {
+		t.Fatal("expected the global stop once hashed")
+	}
+	// the message events are rehashed with the key
+	if err := Db.QueryRow("SELECT COUNT(id) FROM google_reviews_message_events WHERE telephone_hash = ?",
+		utils.UnkeyedHashTelephone("447123456789")).Scan(&count); err != nil || count != 0 {
+		t.Fatalf("expected no message events with the unkeyed hash got: %d, err: %v", count, err)
+	}
+	if err := Db.QueryRow("SELECT COUNT(id) FROM google_reviews_message_events WHERE telephone_hash = ?",
+		utils.HashTelephone("447123456789")).Scan(&count); err != nil || count == 0 {
+		t.Fatalf("expected the message events with the keyed hash, err: %v", err)
+	}
+
+	// updated rather than added again
+	UpdateLastSent("447123456785", clientID, 4)
+	StopSendingAllClients("447123456785")
+	if _, sc, s, f := LastSentFromTelephoneAndClient("447123456785", clientID); !f || !s || sc != 4 {
+		t.Fatalf("unexpected last sent once updated, sent count: %d, stop: %t, found: %t", sc, s, f)
+	}
+	if err := Db.QueryRow("SELECT COUNT(id) FROM google_reviews_last_sents WHERE client_id = ?", clientID).Scan(&count); err != nil || count != 4 {
+		t.Fatalf("expected 4 last sents got: %d, err: %v", count, err)
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	prepareTestDatabase()
+	if r := ApplyRetention(0); r.Total() != 0 {
+		t.Fatalf("expected nothing changed without data retention got: %+v", r)
+	}
+	if _, err := Db.Exec("UPDATE clients SET retention_days = 30 WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Db.Exec("UPDATE google_reviews_message_events SET created = DATE_ADD(NOW(), INTERVAL -60 DAY) WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	r := ApplyRetention(0)
+	// the last sent 40 days ago is deleted, the stop 50 days ago is kept
+	if r.LastSents != 1 || r.MessageEvents != 1 {
+		t.Fatalf("unexpected data retention: %+v", r)
+	}
+	if _, _, _, found := LastSentFromTelephoneAndClient("447123456789", 1); found {
+		t.Fatal("expected the last sent to be deleted")
+	}
+	if _, _, stop, found := LastSentFromTelephoneAndClient("447123456781", 1); !found || !stop {
+		t.Fatal("expected the stop to be kept")
+	}
+	var telephoneHash, providerResponse string
+	if err := Db.QueryRow("SELECT telephone_hash, provider_response FROM google_reviews_message_events WHERE id = 2").Scan(&telephoneHash, &providerResponse); err != nil ||
+		telephoneHash != "" || providerResponse != "" {
+		t.Fatalf("expected the message event to be pseudonymised, telephone hash: %s, provider response: %s, err: %v", telephoneHash, providerResponse, err)
+	}
+	if r := ApplyRetention(0); r.Total() != 0 {
+		t.Fatalf("expected nothing more changed got: %+v", r)
+	}
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+)
+
+// SetMessageEventProviderMessageID - record the message ID returned by the message service against the message
+// event of the message sent so the delivery status callbacks can be matched (see UpdateDeliveryStatus)
+func SetMessageEventProviderMessageID(messageEventID uint64, providerMessageID string) {
+	if messageEventID == 0 || providerMessageID == "" {
+		return
+	}
+	qry := "UPDATE google_reviews_message_events SET provider_message_id = ? WHERE id = ?"
+	if _, err := Db.Exec(qry, providerMessageID, messageEventID); err != nil {
+		log.Printf("Error setting provider message ID: %s of message event ID: %d, err: %v\n", providerMessageID, messageEventID, err)
+	}
+}
+
+// UpdateDeliveryStatus - record the delivery status (see DeliveryStatusDelivered etc.) of the message sent via the
+// channel with the provider message ID, returns the client ID of the message (0 if the message is not found).
+// The first delivery status of the message is counted in the stats (see updateStatsDelivery), a later change of the
+// delivery status (e.g. a repeated callback) is only recorded against the message event.
+func UpdateDeliveryStatus(channel string, providerMessageID string, status string) uint64 {
+	if providerMessageID == "" {
+		return 0
+	}
+	qry := "SELECT id, client_id FROM google_reviews_message_events" +
+		" WHERE channel = ? AND provider_message_id = ?" +
+		" ORDER BY id DESC LIMIT 1"
+	var id, clientID uint64
+	err := Db.QueryRow(qry, channel, providerMessageID).Scan(&id, &clientID)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0
+	case err != nil:
+		log.Printf("Error finding message event of channel: %s, provider message ID: %s, err: %v\n", channel, providerMessageID, err)
+		return 0
+	}
+	firstQry := "UPDATE google_reviews_message_events SET delivery_status = ?, delivery_status_updated = NOW()" +
+		" WHERE id = ? AND delivery_status = ''"
+	res, err := Db.Exec(firstQry, status, id)
+	if err != nil {
+		log.Printf("Error updating delivery status of message event ID: %d, err: %v\n", id, err)
+		return 0
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		updateStatsDelivery(id, status)
+		return clientID
+	}
+	updateQry := "UPDATE google_reviews_message_events SET delivery_status = ?, delivery_status_updated = NOW() WHERE id = ?"
+	if _, err := Db.Exec(updateQry, status, id); err != nil {
+		log.Printf("Error updating delivery status of message event ID: %d, err: %v\n", id, err)
+		return 0
+	}
+	return clientID
+}
+
+// updateStatsDelivery - count the delivery status of the message event in the stats of the client on the day the
+// message was sent, undelivered is counted as failed
+func updateStatsDelivery(messageEventID uint64, status string) {
+	delivered, failed := 0, 1
+	if status == DeliveryStatusDelivered {
+		delivered, failed = 1, 0
+	}
+	qry := "INSERT INTO google_reviews_stats" +
+		" (client_id, stats_date, sent_count, requested_count, delivered_count, failed_count)" +
+		" SELECT client_id, DATE(created), 0, 0, ?, ? FROM google_reviews_message_events WHERE id = ?" +
+		" ON DUPLICATE KEY UPDATE" +
+		" delivered_count = delivered_count + ?," +
+		" failed_count = failed_count + ?"
+	if _, err := Db.Exec(qry, delivered, failed, messageEventID, delivered, failed); err != nil {
+		log.Printf("Error updating delivery stats of message event ID: %d, err: %v\n", messageEventID, err)
+	}
+}
+
+// AlternateMessageServiceSecrets - get the distinct secret1s (e.g. Twilio auth tokens) of the configs using the
+// alternate message service with a send URL containing the account (e.g. /Accounts/<account SID>/), used to verify
+// the signature of the message service callbacks
+func AlternateMessageServiceSecrets(alternateMessageService string, sendURLContains string) []string {
+	qry := "SELECT DISTINCT alternate_message_service_secret1 FROM google_reviews_configs" +
+		" WHERE alternate_message_service = ? AND alternate_message_service_secret1 != ''" +
+		" AND LOCATE(?, send_url) > 0"
+	rows, err := Db.Query(qry, alternateMessageService, sendURLContains)
+	if err != nil {
+		log.Printf("Error getting secrets of alternate message service: %s, err: %v\n", alternateMessageService, err)
+		return nil
+	}
+	defer rows.Close()
+	var secrets []string
+	for rows.Next() {
+		var secret string
+		if err := rows.Scan(&secret); err != nil {
+			log.Printf("Error reading secrets of alternate message service: %s, err: %v\n", alternateMessageService, err)
+			return secrets
+		}
+		if secret = decryptSecret(secret); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"log"
+	"time"
+)
+
+// LastSentFromEmailAndClient - get the last sent, sent count and stop for the email address and client, the same as
+// LastSentFromTelephoneAndClient for the email channel (found is false when not emailed before)
+func LastSentFromEmailAndClient(email string, clientID uint64) (time.Time, uint, bool, bool) {
+	qry := "SELECT last_sent, sent_count, stop FROM google_reviews_email_last_sents WHERE email = ? AND client_id = ?"
+	var (
+		lastSent  time.Time
+		sentCount uint
+		stop      bool
+	)
+	if err := Db.QueryRow(qry, email, clientID).Scan(&lastSent, &sentCount, &stop); err != nil {
+		// time 3 years earlier which will be before the minimum send frequency
+		return time.Now().AddDate(-3, 0, 0), 0, false, false
+	}
+	return lastSent, sentCount, stop, true
+}
+
+// UpdateEmailLastSent - update the last sent of the email address, counted in the client's daily sent count
+// If this has been requested then the stop (sending) will always be false
+func UpdateEmailLastSent(email string, clientID uint64, sentCount uint) {
+	qry := "INSERT INTO google_reviews_email_last_sents" +
+		" (email, client_id, last_sent, last_sent_date, sent_count, stop)" +
+		" VALUES (?, ?, NOW(), CURDATE(), ?, FALSE)" +
+		" ON DUPLICATE KEY UPDATE" +
+		" last_sent = NOW()," +
+		" last_sent_date = CURDATE()," +
+		" sent_count = ?"
+	_, err := Db.Exec(qry, email, clientID, sentCount, sentCount)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	incrementDailySentCount(clientID)
+}
+
+// StopSendingEmail - stop emailing the email address (unsubscribed), added when not emailed before
+func StopSendingEmail(email string, clientID uint64) {
+	qry := "INSERT INTO google_reviews_email_last_sents" +
+		" (email, client_id, last_sent, last_sent_date, sent_count, stop)" +
+		" VALUES (?, ?, NOW(), CURDATE(), 0, TRUE)" +
+		" ON DUPLICATE KEY UPDATE" +
+		" stop = TRUE"
+	_, err := Db.Exec(qry, email, clientID)
+	if err != nil {
+		log.Println(err)
+	}
+}
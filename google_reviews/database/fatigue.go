@@ -0,0 +1,98 @@
+package database
+
+import (
+	"log"
+
+	"google_reviews/utils"
+)
+
+// FatiguePolicy - limit of the review requests to a passenger across clients, at most MaxRequests review requests are
+// sent (or deferred) to the telephone in Days days. The policy of partner ID 0 counts the review requests of all
+// clients, the policy of a partner counts the review requests of the partner's clients.
+type FatiguePolicy struct {
+	PartnerID   uint64
+	MaxRequests uint
+	Days        uint
+}
+
+// FatiguePolicies - get the fatigue policies for all clients and for the partner of the client
+func FatiguePolicies(clientID uint64) []FatiguePolicy {
+	qry := "SELECT partner_id, max_requests, days" +
+		" FROM google_reviews_fatigue_policies" +
+		" WHERE partner_id = 0" +
+		" OR partner_id = (SELECT partner_id FROM clients WHERE id = ?)" +
+		" ORDER BY partner_id"
+	var policies []FatiguePolicy
+	rows, err := Db.Query(qry, clientID)
+	if err != nil {
+		log.Println("Error retrieving fatigue policies for client", clientID, "from database. Error: ", err)
+		return policies
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p FatiguePolicy
+		if err := rows.Scan(&p.PartnerID, &p.MaxRequests, &p.Days); err != nil {
+			log.Println("Error retrieving fatigue policies for client", clientID, "from database whilst reading returned results. Error: ", err)
+			return policies
+		}
+		policies = append(policies, p)
+	}
+	return policies
+}
+
+// FatigueRequests - the number of review requests sent or deferred to the telephone by the clients (of the partner, 0
+// for all clients) in the last days. A deferred review request is counted once, it is not counted again when sent.
+func FatigueRequests(telephone string, partnerID uint64, days uint) (uint, error) {
+	qry := "SELECT COUNT(*) FROM google_reviews_message_events AS event" +
+		" WHERE event.telephone_hash = ?" +
+		" AND event.created > NOW() - INTERVAL ? DAY" +
+		" AND (event.reason = ? OR (event.reason = ? AND NOT EXISTS (" +
+		" SELECT 1 FROM google_reviews_message_events AS sent" +
+		" WHERE sent.telephone_hash = event.telephone_hash AND sent.client_id = event.client_id" +
+		" AND sent.reason = ? AND sent.created >= event.created)))"
+	args := []interface{}{utils.HashTelephone(telephone), days, ReasonSent, ReasonDeferred, ReasonSent}
+	if partnerID != 0 {
+		qry += " AND event.client_id IN (SELECT id FROM clients WHERE partner_id = ?)"
+		args = append(args, partnerID)
+	}
+	var requests uint
+	if err := Db.QueryRow(qry, args...).Scan(&requests); err != nil {
+		log.Println("Error counting review requests for fatigue policy of partner", partnerID, "from database. Error: ", err)
+		return 0, err
+	}
+	return requests, nil
+}
+
+// FatigueCapped - check whether a review request to the telephone would exceed a fatigue policy (of the client, see
+// FatiguePolicies), returns the policy exceeded. A policy without max requests or days is not checked and the review
+// request is allowed when the review requests cannot be counted.
+func FatigueCapped(telephone string, policies []FatiguePolicy) (FatiguePolicy, bool) {
+	return fatigueCapped(telephone, policies, 0)
+}
+
+// FatigueCappedDeferred - check whether sending a deferred review request (e.g. a send later) to the telephone by the
+// client exceeds a fatigue policy, the deferred review request is already counted (see FatigueRequests)
+func FatigueCappedDeferred(telephone string, clientID uint64) (FatiguePolicy, bool) {
+	return fatigueCapped(telephone, FatiguePolicies(clientID), 1)
+}
+
+// fatigueCapped - check whether the review requests to the telephone, less those already counted, reach a fatigue
+// policy
+func fatigueCapped(telephone string, policies []FatiguePolicy, counted uint) (FatiguePolicy, bool) {
+	if telephone == "" {
+		return FatiguePolicy{}, false
+	}
+	for _, p := range policies {
+		if p.MaxRequests == 0 || p.Days == 0 {
+			continue
+		}
+		requests, err := FatigueRequests(telephone, p.PartnerID, p.Days)
+		if err != nil {
+			continue
+		}
+		if requests >= p.MaxRequests+counted {
+			return p, true
+		}
+	}
+	return FatiguePolicy{}, false
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"log"
+	"time"
+
+	"google_reviews/metrics"
+)
+
+var (
+	// messageEventsTotal - message events by channel and reason (why a message was or wasn't sent)
+	messageEventsTotal = metrics.NewCounterVec("google_reviews_message_events_total",
+		"Message events by message service and reason.", "channel", "reason")
+	// providerSendDuration - time taken by the message service to send a message
+	providerSendDuration = metrics.NewHistogramVec("google_reviews_provider_send_duration_seconds",
+		"Time taken by the message service to send a message.", metrics.DefaultBuckets, "channel", "reason")
+)
+
+// RegisterMetrics - register the database connection pool stats and the send later backlog metrics,
+// call once the database is open
+func RegisterMetrics() {
+	metrics.RegisterDBStats("google_reviews", Db)
+	metrics.NewGaugeFunc("google_reviews_send_later_backlog", "Number of messages waiting to be sent later.",
+		func() float64 { return float64(SendLaterBacklog()) })
+}
+
+// recordMessageEventMetrics - count the message event and observe the send latency when the message was sent
+func recordMessageEventMetrics(channel string, reason string, latency time.Duration) {
+	messageEventsTotal.Inc(channel, reason)
+	if reason == ReasonSent || reason == ReasonProviderError {
+		providerSendDuration.ObserveDuration(latency, channel, reason)
+	}
+}
+
+// SendLaterBacklog - number of send laters waiting to be sent
+func SendLaterBacklog() uint64 {
+	qry := "SELECT COUNT(id) FROM google_reviews_send_laters"
+	var count uint64
+	if err := Db.QueryRow(qry).Scan(&count); err != nil {
+		log.Println(err)
+		return 0
+	}
+	return count
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// processedBookings - how long a processed booking is kept so a repeated delivery of the booking (dispatcher retries,
+// overlapping polls) is not processed again (0 disables), see EnableProcessedBookings
+var processedBookings = struct {
+	sync.Mutex
+	ttl   time.Duration
+	lease time.Duration
+}{}
+
+// EnableProcessedBookings - keep the processed bookings (keyed by client and booking ID) for the ttl, a repeated
+// delivery of a booking within the ttl returns the original response rather than being processed again
+// (a ttl of 0 disables). A booking being processed is claimed for the lease so a booking not completed (e.g. the
+// server stopped whilst processing it) is processed again once the lease expires.
+func EnableProcessedBookings(ttl time.Duration, lease time.Duration) {
+	processedBookings.Lock()
+	defer processedBookings.Unlock()
+	processedBookings.ttl = ttl
+	processedBookings.lease = lease
+}
+
+// processedBookingsTTL - the ttl and the claim lease of the processed bookings (a ttl of 0 if disabled)
+func processedBookingsTTL() (time.Duration, time.Duration) {
+	processedBookings.Lock()
+	defer processedBookings.Unlock()
+	return processedBookings.ttl, processedBookings.lease
+}
+
+// ClaimBooking - claim the booking of the client for processing, returns false with the response of the original
+// delivery (empty if it is still being processed) when the booking has already been processed within the ttl.
+// The booking is always processed when the processed bookings are disabled, there is no booking ID or the database
+// cannot be checked.
+func ClaimBooking(clientID uint64, bookingID string) (bool, string) {
+	ttl, lease := processedBookingsTTL()
+	if ttl <= 0 || clientID == 0 || bookingID == "" {
+		return true, ""
+	}
+	if lease <= 0 || lease > ttl {
+		lease = ttl
+	}
+	deleteQry := "DELETE FROM google_reviews_processed_bookings" +
+		" WHERE client_id = ? AND booking_id = ? AND expires < NOW()"
+	insertQry := "INSERT IGNORE INTO google_reviews_processed_bookings (client_id, booking_id, created, expires)" +
+		" VALUES (?, ?, NOW(), DATE_ADD(NOW(), INTERVAL ? SECOND))"
+	if _, err := Db.Exec(deleteQry, clientID, bookingID); err != nil {
+		log.Println("Error deleting expired processed booking", bookingID, "for client", clientID, "from database. Error: ", err)
+		return true, ""
+	}
+	res, err := Db.Exec(insertQry, clientID, bookingID, int64(lease/time.Second))
+	if err != nil {
+		log.Println("Error claiming booking", bookingID, "for client", clientID, "in database. Error: ", err)
+		return true, ""
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return true, ""
+	}
+	response, _ := ProcessedBooking(clientID, bookingID)
+	return false, response
+}
+
+// CompleteBooking - store the response of the claimed booking, returned for repeated deliveries of the booking
+// within the ttl
+func CompleteBooking(clientID uint64, bookingID string, response string) {
+	ttl, _ := processedBookingsTTL()
+	if ttl <= 0 || clientID == 0 || bookingID == "" {
+		return
+	}
+	qry := "UPDATE google_reviews_processed_bookings SET response = ?, expires = DATE_ADD(NOW(), INTERVAL ? SECOND)" +
+		" WHERE client_id = ? AND booking_id = ?"
+	if _, err := Db.Exec(qry, response, int64(ttl/time.Second), clientID, bookingID); err != nil {
+		log.Println("Error storing the response of booking", bookingID, "for client", clientID, "in database. Error: ", err)
+	}
+}
+
+// ReleaseBooking - release the claim of the booking not processed (e.g. rejected outside the config times) so a
+// repeated delivery of the booking is processed again
+func ReleaseBooking(clientID uint64, bookingID string) {
+	ttl, _ := processedBookingsTTL()
+	if ttl <= 0 || clientID == 0 || bookingID == "" {
+		return
+	}
+	qry := "DELETE FROM google_reviews_processed_bookings" +
+		" WHERE client_id = ? AND booking_id = ? AND response IS NULL"
+	if _, err := Db.Exec(qry, clientID, bookingID); err != nil {
+		log.Println("Error releasing booking", bookingID, "for client", clientID, "in database. Error: ", err)
+	}
+}
+
+// ProcessedBooking - the response of the booking when it has been processed within the ttl (without claiming it),
+// the response is empty if the booking is still being processed
+func ProcessedBooking(clientID uint64, bookingID string) (string, bool) {
+	if ttl, _ := processedBookingsTTL(); ttl <= 0 || clientID == 0 || bookingID == "" {
+		return "", false
+	}
+	qry := "SELECT response FROM google_reviews_processed_bookings" +
+		" WHERE client_id = ? AND booking_id = ? AND expires >= NOW()"
+	var response sql.NullString
+	err := Db.QueryRow(qry, clientID, bookingID).Scan(&response)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", false
+	case err != nil:
+		log.Println("Error retrieving processed booking", bookingID, "for client", clientID, "from database. Error: ", err)
+		return "", false
+	}
+	return response.String, true
+}
+
+// PurgeProcessedBookings - delete the expired processed bookings, returns the number deleted
+func PurgeProcessedBookings() int64 {
+	qry := "DELETE FROM google_reviews_processed_bookings WHERE expires < NOW()"
+	res, err := Db.Exec(qry)
+	if err != nil {
+		log.Println("Error deleting expired processed bookings from database. Error: ", err)
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
@@ -0,0 +1,265 @@
+package database
+
+import (
+	"errors"
+	"log"
+
+	"google_reviews/secrets"
+)
+
+// LoadDataKeys - load the data keys (wrapped) from the database into the keyring used to encrypt and decrypt the
+// secrets, creating the first data key when there is no active data key. Does nothing when the secrets are not
+// encrypted (no key provider).
+func LoadDataKeys() error {
+	if !secrets.Enabled() {
+		return nil
+	}
+	wrapped, activeID, err := queryDataKeys()
+	if err != nil {
+		return err
+	}
+	if activeID == 0 {
+		if err := addDataKey(); err != nil {
+			return err
+		}
+		if wrapped, activeID, err = queryDataKeys(); err != nil {
+			return err
+		}
+	}
+	return secrets.SetDataKeys(wrapped, activeID)
+}
+
+// queryDataKeys - get the wrapped data keys by ID and the ID of the active data key (the latest when there is more
+// than one e.g. added at the same time by two servers, 0 when there is none)
+func queryDataKeys() (map[uint64][]byte, uint64, error) {
+	rows, err := Db.Query("SELECT id, wrapped_key, active FROM google_reviews_data_keys ORDER BY id")
+	if err != nil {
+		log.Printf("Error getting the data keys, err: %v\n", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+	wrapped := make(map[uint64][]byte)
+	var activeID uint64
+	for rows.Next() {
+		var (
+			id         uint64
+			wrappedKey []byte
+			active     bool
+		)
+		if err := rows.Scan(&id, &wrappedKey, &active); err != nil {
+			log.Printf("Error reading the data keys, err: %v\n", err)
+			return nil, 0, err
+		}
+		wrapped[id] = wrappedKey
+		if active {
+			activeID = id
+		}
+	}
+	return wrapped, activeID, rows.Err()
+}
+
+// addDataKey - add a data key wrapped by the key provider as the active data key
+func addDataKey() error {
+	wrappedKey, err := secrets.NewDataKey()
+	if err != nil {
+		log.Printf("Error creating a data key, err: %v\n", err)
+		return err
+	}
+	tx, err := Db.Begin()
+	if err != nil {
+		log.Printf("Error adding a data key, err: %v\n", err)
+		return err
+	}
+	if _, err := tx.Exec("UPDATE google_reviews_data_keys SET active = 0 WHERE active = 1"); err != nil {
+		tx.Rollback()
+		log.Printf("Error deactivating the data keys, err: %v\n", err)
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO google_reviews_data_keys (wrapped_key, active) VALUES (?, 1)", wrappedKey); err != nil {
+		tx.Rollback()
+		log.Printf("Error adding a data key, err: %v\n", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// decryptSecret - decrypt the stored secret (plaintext secrets are returned as is), the data keys are reloaded when
+// encrypted with a data key added since they were loaded (e.g. rotated by another server). Returns an empty secret
+// when it cannot be decrypted.
+func decryptSecret(value string) string {
+	secret, err := secrets.Decrypt(value)
+	if errors.Is(err, secrets.ErrUnknownDataKey) && LoadDataKeys() == nil {
+		secret, err = secrets.Decrypt(value)
+	}
+	if err != nil {
+		log.Printf("Error decrypting a secret, err: %v\n", err)
+		return ""
+	}
+	return secret
+}
+
+// encryptSecret - encrypt the secret to store it (returned as is when the secrets are not encrypted). When it cannot
+// be encrypted the secret is stored as plaintext, it is encrypted by the next encryptsecrets.
+func encryptSecret(secret string) string {
+	value, err := secrets.Encrypt(secret)
+	if errors.Is(err, secrets.ErrNoDataKey) && LoadDataKeys() == nil {
+		value, err = secrets.Encrypt(secret)
+	}
+	if err != nil {
+		log.Printf("Error encrypting a secret, it is stored unencrypted, err: %v\n", err)
+		return secret
+	}
+	return value
+}
+
+// EncryptSecrets - encrypt the secrets of the configs and send laters which are stored as plaintext or encrypted with
+// a data key other than the active data key, used to encrypt the existing rows and after rotating the data key.
+// Returns the number of rows updated.
+func EncryptSecrets() (int, error) {
+	if !secrets.Enabled() {
+		return 0, secrets.ErrNoKeyProvider
+	}
+	if err := LoadDataKeys(); err != nil {
+		return 0, err
+	}
+	configs, err := encryptTableSecrets("google_reviews_configs", "app_key", "secret_key", "alternate_message_service_secret1",
+		"whatsapp_access_token", "signing_secret")
+	if err != nil {
+		return configs, err
+	}
+	sendLaters, err := encryptTableSecrets("google_reviews_send_laters", "app_key", "secret_key", "http_headers", "http_params")
+	return configs + sendLaters, err
+}
+
+// encryptTableSecrets - encrypt the secret columns of the rows of the table not encrypted with the active data key,
+// the row is only updated if its secrets are unchanged since read. Returns the number of rows updated.
+func encryptTableSecrets(table string, columns ...string) (int, error) {
+	qry := "SELECT id"
+	for _, column := range columns {
+		qry += ", IFNULL(" + column + ", '')"
+	}
+	qry += " FROM " + table
+	rows, err := Db.Query(qry)
+	if err != nil {
+		log.Printf("Error getting the secrets of %s, err: %v\n", table, err)
+		return 0, err
+	}
+	type row struct {
+		id     uint64
+		values []string
+	}
+	var toEncrypt []row
+	for rows.Next() {
+		r := row{values: make([]string, len(columns))}
+		dest := []interface{}{&r.id}
+		for i := range r.values {
+			dest = append(dest, &r.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			log.Printf("Error reading the secrets of %s, err: %v\n", table, err)
+			return 0, err
+		}
+		for _, value := range r.values {
+			if !secrets.EncryptedWithActiveKey(value) {
+				toEncrypt = append(toEncrypt, r)
+				break
+			}
+		}
+	}
+	rows.Close()
+
+	updated := 0
+	for _, r := range toEncrypt {
+		qry := "UPDATE " + table + " SET "
+		var args, where []interface{}
+		for i, column := range columns {
+			secret, err := secrets.Decrypt(r.values[i])
+			if err != nil {
+				log.Printf("Error decrypting %s of %s id: %d, err: %v\n", column, table, r.id, err)
+				return updated, err
+			}
+			value, err := secrets.Encrypt(secret)
+			if err != nil {
+				log.Printf("Error encrypting %s of %s id: %d, err: %v\n", column, table, r.id, err)
+				return updated, err
+			}
+			if i > 0 {
+				qry += ", "
+			}
+			qry += column + " = ?"
+			args = append(args, value)
+			where = append(where, r.values[i])
+		}
+		qry += " WHERE id = ?"
+		args = append(args, r.id)
+		for i, column := range columns {
+			qry += " AND IFNULL(" + column + ", '') = ?"
+			args = append(args, where[i])
+		}
+		res, err := Db.Exec(qry, args...)
+		if err != nil {
+			log.Printf("Error updating the secrets of %s id: %d, err: %v\n", table, r.id, err)
+			return updated, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+// RotateDataKey - add a new active data key and re-encrypt the secrets with it. When newProvider is not nil (e.g. the
+// new key file) the data keys are first rewrapped with it, the other servers must then be restarted with it as they
+// can no longer unwrap the data keys. The old data keys are kept to decrypt the secrets encrypted by the servers
+// before they reload the data keys. Returns the number of rows re-encrypted.
+func RotateDataKey(newProvider secrets.KeyProvider) (int, error) {
+	if !secrets.Enabled() {
+		return 0, secrets.ErrNoKeyProvider
+	}
+	if newProvider != nil {
+		if err := rewrapDataKeys(newProvider); err != nil {
+			return 0, err
+		}
+		secrets.SetKeyProvider(newProvider)
+	}
+	if err := addDataKey(); err != nil {
+		return 0, err
+	}
+	return EncryptSecrets()
+}
+
+// rewrapDataKeys - rewrap the data keys with the new key provider
+func rewrapDataKeys(newProvider secrets.KeyProvider) error {
+	wrapped, _, err := queryDataKeys()
+	if err != nil {
+		return err
+	}
+	tx, err := Db.Begin()
+	if err != nil {
+		log.Printf("Error rewrapping the data keys, err: %v\n", err)
+		return err
+	}
+	for id, wrappedKey := range wrapped {
+		rewrapped, err := secrets.Rewrap(wrappedKey, newProvider)
+		if err != nil {
+			tx.Rollback()
+			log.Printf("Error rewrapping data key id: %d, err: %v\n", id, err)
+			return err
+		}
+		if _, err := tx.Exec("UPDATE google_reviews_data_keys SET wrapped_key = ? WHERE id = ?", rewrapped, id); err != nil {
+			tx.Rollback()
+			log.Printf("Error updating data key id: %d, err: %v\n", id, err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// decryptConfigSecrets - decrypt the secrets of the config
+func decryptConfigSecrets(grcftwc *GoogleReviewsConfigFromTokenWithChecks) {
+	grcftwc.AppKey = decryptSecret(grcftwc.AppKey)
+	grcftwc.SecretKey = decryptSecret(grcftwc.SecretKey)
+	grcftwc.AlternateMessageServiceSecret1 = decryptSecret(grcftwc.AlternateMessageServiceSecret1)
+	grcftwc.WhatsAppAccessToken = decryptSecret(grcftwc.WhatsAppAccessToken)
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"log"
+)
+
+// ReleaseFallback - release the fallback held for the message sent via the preferred channel (see AddSendLater) so it
+// is sent by the send later worker, resend is set when the message has already been counted as sent (i.e. the message
+// was accepted but not delivered) so the fallback is not counted again. Returns whether a held fallback was found.
+func ReleaseFallback(fallbackRef string, resend bool) bool {
+	if fallbackRef == "" {
+		return false
+	}
+	qry := "UPDATE google_reviews_send_laters" +
+		" SET held = 0, resend = ?, send_after = NOW()" +
+		" WHERE fallback_ref = ? AND held = 1"
+	res, err := Db.Exec(qry, resend, fallbackRef)
+	if err != nil {
+		log.Printf("Error releasing fallback ref: %s, err: %v\n", fallbackRef, err)
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n > 0
+}
+
+// DeleteFallback - delete the fallback held for the message sent via the preferred channel once it is delivered
+func DeleteFallback(fallbackRef string) {
+	if fallbackRef == "" {
+		return
+	}
+	qry := "DELETE FROM google_reviews_send_laters WHERE fallback_ref = ? AND held = 1"
+	if _, err := Db.Exec(qry, fallbackRef); err != nil {
+		log.Printf("Error deleting fallback ref: %s, err: %v\n", fallbackRef, err)
+	}
+}
+
+// DeleteExpiredFallbacks - delete the fallbacks held for longer than expiryHours (no status was received for the
+// message sent via the preferred channel so it is taken as delivered), returns the number deleted
+func DeleteExpiredFallbacks(expiryHours int) int64 {
+	qry := "DELETE FROM google_reviews_send_laters" +
+		" WHERE held = 1 AND send_after < DATE_SUB(NOW(), INTERVAL ? HOUR)"
+	res, err := Db.Exec(qry, expiryHours)
+	if err != nil {
+		log.Printf("Error deleting expired fallbacks, err: %v\n", err)
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
+
+// ClientIDAndCountryFromWhatsAppPhoneNumberID - get the client ID and country of the client using the WhatsApp
+// phone number ID, client ID 0 if not found or the phone number is shared by more than one client
+func ClientIDAndCountryFromWhatsAppPhoneNumberID(phoneNumberID string) (uint64, string) {
+	if phoneNumberID == "" {
+		return 0, ""
+	}
+	qry := "SELECT DISTINCT client_id FROM google_reviews_configs WHERE whatsapp_phone_number_id = ? LIMIT 2"
+	rows, err := Db.Query(qry, phoneNumberID)
+	if err != nil {
+		log.Printf("Error getting client of WhatsApp phone number ID: %s, err: %v\n", phoneNumberID, err)
+		return 0, ""
+	}
+	defer rows.Close()
+	var clientIDs []uint64
+	for rows.Next() {
+		var clientID uint64
+		if err := rows.Scan(&clientID); err != nil {
+			log.Printf("Error reading client of WhatsApp phone number ID: %s, err: %v\n", phoneNumberID, err)
+			return 0, ""
+		}
+		clientIDs = append(clientIDs, clientID)
+	}
+	if len(clientIDs) != 1 {
+		return 0, ""
+	}
+	return clientIDs[0], ClientCountry(clientIDs[0])
+}
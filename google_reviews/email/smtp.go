@@ -0,0 +1,99 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPService - send the emails via an SMTP server (STARTTLS is used when offered by the server)
+type SMTPService struct {
+	host     string
+	port     int
+	username string
+	password string
+	// sendMail - sends the message (smtp.SendMail, replaced in tests)
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTPService - SMTP email service, no authentication when the username is empty
+func NewSMTPService(host string, port int, username string, password string) *SMTPService {
+	return &SMTPService{host: host, port: port, username: username, password: password, sendMail: smtp.SendMail}
+}
+
+// Name - name of the email service
+func (s *SMTPService) Name() string {
+	return "smtp"
+}
+
+// Send - send the email returning the message ID
+func (s *SMTPService) Send(e Email) (string, error) {
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+	messageID := newMessageID(e.FromAddress)
+	msg, err := mimeMessage(e, messageID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.sendMail(s.host+":"+strconv.Itoa(s.port), auth, e.FromAddress, []string{e.To}, msg); err != nil {
+		return "", fmt.Errorf("error sending email via smtp: %w", err)
+	}
+	return messageID, nil
+}
+
+// newMessageID - unique message ID at the domain of the from address
+func newMessageID(from string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	domain := "localhost"
+	if a, err := mail.ParseAddress(from); err == nil {
+		domain = a.Address[strings.LastIndex(a.Address, "@")+1:]
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// mimeMessage - the email as a multipart/alternative MIME message (text and HTML)
+func mimeMessage(e Email, messageID string) ([]byte, error) {
+	boundary := "gr-" + messageID[1:17]
+	var buf bytes.Buffer
+	from := mail.Address{Name: e.FromName, Address: e.FromAddress}
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", e.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", e.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: %s\r\n", messageID)
+	if e.UnsubscribeLink != "" {
+		fmt.Fprintf(&buf, "List-Unsubscribe: <%s>\r\n", e.UnsubscribeLink)
+		fmt.Fprintf(&buf, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	}
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{{"text/plain", e.Text}, {"text/html", e.HTML}} {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=utf-8\r\n", part.contentType)
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(&buf)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, fmt.Errorf("error encoding email: %w", err)
+		}
+		if err := qp.Close(); err != nil {
+			return nil, fmt.Errorf("error encoding email: %w", err)
+		}
+		fmt.Fprintf(&buf, "\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,59 @@
+package email
+
+import (
+	"html"
+	"strings"
+
+	"google_reviews/utils"
+)
+
+// template placeholders only available in email templates (the message template placeholders e.g. {first_name} are
+// also available)
+const (
+	PlaceholderMessage         = "{message}"
+	PlaceholderUnsubscribeLink = "{unsubscribe_link}"
+)
+
+// DefaultSubject - subject used when the config has no email subject
+const DefaultSubject = "How was your journey with {company|us}?"
+
+// DefaultTemplate - HTML template used when the config has no email template
+const DefaultTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, Helvetica, sans-serif; font-size: 15px; color: #333333;">
+<p>{message}</p>
+<p><a href="{review_link}" style="display: inline-block; padding: 10px 20px; background-color: #1a73e8; color: #ffffff; text-decoration: none; border-radius: 4px;">Leave a review</a></p>
+<p style="font-size: 12px; color: #888888;">Don't want these emails? <a href="{unsubscribe_link}" style="color: #888888;">Unsubscribe</a></p>
+</body>
+</html>
+`
+
+// Render - the HTML and text of the email from the template (the default template when empty), the values are
+// the message template placeholder values which are HTML escaped in the HTML
+func Render(template string, message string, values map[string]string, unsubscribeLink string) (string, string) {
+	if strings.TrimSpace(template) == "" {
+		template = DefaultTemplate
+	}
+	escaped := make(map[string]string, len(values))
+	for k, v := range values {
+		escaped[k] = html.EscapeString(v)
+	}
+	htmlMessage := strings.ReplaceAll(html.EscapeString(message), "\n", "<br>")
+	body := utils.FillMessageTemplate(template, escaped)
+	body = strings.ReplaceAll(body, PlaceholderUnsubscribeLink, html.EscapeString(unsubscribeLink))
+	body = strings.ReplaceAll(body, PlaceholderMessage, htmlMessage)
+
+	text := message
+	if unsubscribeLink != "" {
+		text += "\n\nUnsubscribe: " + unsubscribeLink
+	}
+	return body, text
+}
+
+// Subject - the subject filled in from the values (the default subject when empty)
+func Subject(subject string, values map[string]string) string {
+	if strings.TrimSpace(subject) == "" {
+		subject = DefaultSubject
+	}
+	return utils.FillMessageTemplate(subject, values)
+}
@@ -11,8 +11,26 @@
 // 		certs/server.rsa.crt - or whatever is configured in the config file
 // 		certs/server.rsa.key - or whatever is configured in the config file
 //
+// Messages delayed using the send delay are stored in the send laters table and are sent by the
+// send later worker, enable with send_later_enabled=true in config.properties. The worker can be
+// enabled on more than one server. To only run the worker (no http server) use:
+// $ ./google_reviews sendlater
+// Messages not sent because the message service is unavailable (e.g. timed out or responded 5xx after the retries,
+// or its circuit breaker is open) are also stored in the send laters table to be sent by the send later worker.
+// Configs with pacing enabled spread the max daily send count across the config times, the messages over the
+// rate are also stored in the send laters table to be sent later in the day.
+//
+// Secrets of the configs and send laters (dispatcher app key and secret key, alternate message service secret1) are
+// stored encrypted when secrets_key_file is set (see secrets), to encrypt the secrets stored unencrypted use:
+// $ ./google_reviews encryptsecrets
+// To rotate the data key (re-encrypting the secrets), optionally rewrapping the data keys with a new key file which
+// google_reviews, google_reviews_autocab and google_reviews_ui then have to be restarted with, use:
+// $ ./google_reviews rotatesecretskey [new key file]
+//
 // The barred telephone prefixes file is read on program startup so any changes to this file
-// will require a restart.
+// will require a restart. Barred telephone prefixes and full numbers, for all clients or a client,
+// are managed from google_reviews_ui (stored in the database) and are reloaded every
+// barred_reload_period seconds and when google_reviews_ui notifies a change, without a restart.
 // The barred telephone prefixes must include the country prefix.
 //
 // Useful for database token generation, use Elixir iex:
@@ -29,20 +47,68 @@
 // pairing code:
 // curl -k -X GET -H "api-token: FjyFuBCyM11199VvPqjArxYYv1fCPG8XX2rzO4ycMkcHh6dl5oe-Ea7c11sIHfkr" 'https://localhost/rmsgpair?pairing_code=1234'
 //
+// opt out reply (STOP) from Review Master SMS Gateway:
+// curl -k -X POST -H "api-token: GGK8dkags0EYe0r0UuPowQBV79DeUJE/Lu6190iyyG5PZ+3v8c8Bs8g" -d '{"queue_id":"12","telephone":"+447123456789","message":"STOP"}' 'https://localhost/reply/rmsg'
+//
+// simulate a request (dry run, returns a trace of each step, nothing is sent or written to the database):
+// curl -k -X POST -d 'gr_token=<token>&t=07123456789&ignore_dispatcher_checks=1' 'https://localhost/googlereviews/simulate'
+//
+// reload the barred telephones (called by google_reviews_ui when changed):
+// curl -k -X GET 'https://localhost/reloadbarred?log_token=<log token>'
+//
+// barred telephones for all clients (polled by send_sms):
+// curl -k -X GET 'https://localhost/barred?barred_token=<barred token>'
+//
+// signed request (config with a signing secret, the signature is the hex HMAC-SHA256 of the timestamp, a full stop
+// and the body keyed with the signing secret, rejected when outside signature_replay_window seconds):
+// BODY='gr_token=<token>&t=07123456789'; TS=$(date +%s)
+// SIG=$(printf '%s.%s' "$TS" "$BODY" | openssl dgst -sha256 -hmac '<signing secret>' | sed 's/^.* //')
+// curl -k -X POST -H "X-GR-Timestamp: $TS" -H "X-GR-Signature: $SIG" -d "$BODY" 'https://localhost/googlereviews'
+//
+// generic dispatcher webhook (the booking fields are extracted from the JSON or form payload using the hook mapping
+// of the config, add /simulate to the path to simulate the request):
+// curl -k -X POST -H 'Content-Type: application/json' -d '{"id":"B1","passenger":{"id":"P1","phone":"07123456789","first_name":"Jane"}}' 'https://localhost/hook/<token>'
+//
+// Twilio delivery status callback (signed by Twilio with the auth token, the base64 HMAC-SHA1 of the
+// twilio_status_callback_url followed by the parameters sorted by name):
+// curl -k -X POST -H "X-Twilio-Signature: <signature>" -d 'AccountSid=<account SID>&MessageSid=<message SID>&MessageStatus=delivered' 'https://localhost/twilio/status'
+//
+// WhatsApp webhook verification (when subscribing, the verify token is whatsapp_verify_token) and notifications (signed
+// by WhatsApp with the app secret, sha256= followed by the hex HMAC-SHA256 of the body):
+// curl -k 'https://localhost/whatsapp/webhook?hub.mode=subscribe&hub.verify_token=<verify token>&hub.challenge=1158201444'
+// curl -k -X POST -H 'Content-Type: application/json' -H "X-Hub-Signature-256: sha256=<signature>" -d '{"object":"whatsapp_business_account","entry":[{"changes":[{"field":"messages","value":{"metadata":{"phone_number_id":"<phone number ID>"},"statuses":[{"id":"<message ID>","status":"delivered"}]}}]}]}' 'https://localhost/whatsapp/webhook'
+//
+// email a booking without a mobile telephone (config with email enabled, the email address is the email parameter):
+// curl -k -X POST -d 'gr_token=<token>&t=&email=jane@example.com&first_name=Jane' 'https://localhost/googlereviews'
+//
+// booking locale (the message of the language replaces the config message when the config has one, without a locale
+// the language is from the country of the telephone, the hook mapping can map the locale field):
+// curl -k -X POST -d 'gr_token=<token>&t=0612345678&locale=nl-NL' 'https://localhost/googlereviews'
+//
+// email unsubscribe link (signed with email_unsubscribe_secret, POST to unsubscribe, GET shows a page to confirm):
+// curl -k -X POST 'https://localhost/email/unsubscribe?c=<client ID>&e=jane%40example.com&s=<signature>'
+//
 
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/natefinch/lumberjack.v2"
 
 	"google_reviews/barred"
+	"google_reviews/client"
 	"google_reviews/config"
 	"google_reviews/database"
+	"google_reviews/logging"
+	"google_reviews/secrets"
+	"google_reviews/sendlater"
 	"google_reviews/server"
+	"google_reviews/utils"
 )
 
 func main() {
@@ -52,6 +118,11 @@ func main() {
 	if len(os.Args) > 1 && os.Args[1] == "test" {
 		test = true
 	}
+	// sendlater first argument is used to only run the send later worker
+	sendLaterOnly := false
+	if len(os.Args) > 1 && os.Args[1] == "sendlater" {
+		sendLaterOnly = true
+	}
 
 	logFilename := ""
 	if !test {
@@ -69,16 +140,38 @@ func main() {
 		}
 		defer f.Close()
 		// log.SetOutput(f)
-		log.SetOutput(&lumberjack.Logger{
+		// structured (JSON) log records, checked by /checklogs including the rotated backups
+		logging.Setup(&lumberjack.Logger{
 			Filename:   logFilename,
 			MaxSize:    10, // megabytes
 			MaxBackups: 3,
 			MaxAge:     7, //days
 		})
+	} else {
+		logging.Setup(os.Stderr)
 	}
 
 	// read config file
 	config.ReadProperties()
+	// telephones are stored and looked up hashed with the telephone hash key
+	if config.Conf.TelephoneHashKey == "" {
+		log.Fatal("Error, no telephone hash key is set (telephone_hash_key), telephones can not be hashed without a key")
+	}
+	utils.SetTelephoneHashKey(config.Conf.TelephoneHashKey)
+
+	// timeouts, retries and circuit breaker of the requests to the message services
+	providerTimeouts := make(map[string]time.Duration)
+	for provider, seconds := range config.Conf.ProviderTimeouts {
+		providerTimeouts[provider] = time.Duration(seconds) * time.Second
+	}
+	client.SetResilience(client.Resilience{
+		Timeout:           time.Duration(config.Conf.ProviderTimeout) * time.Second,
+		ProviderTimeouts:  providerTimeouts,
+		MaxRetries:        config.Conf.ProviderMaxRetries,
+		RetryBackoff:      time.Duration(config.Conf.ProviderRetryBackoff) * time.Millisecond,
+		BreakerFailures:   config.Conf.CircuitBreakerFailures,
+		BreakerOpenPeriod: time.Duration(config.Conf.CircuitBreakerOpenPeriod) * time.Second,
+	})
 
 	// read barred telephone numbers file
 	bars, err := barred.ReadBarredFile(config.Conf.BarredTelephonePrefixFile)
@@ -88,12 +181,87 @@ func main() {
 		server.Bars = bars
 	}
 
+	// secrets are stored encrypted with the data keys wrapped by the key in the secrets key file
+	if config.Conf.SecretsKeyFile != "" {
+		keyProvider, err := secrets.NewFileKeyProvider(config.Conf.SecretsKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		secrets.SetKeyProvider(keyProvider)
+	} else {
+		log.Println("Warning, no secrets key file is set, secrets are stored unencrypted")
+	}
+
 	// database
 	database.OpenDB(config.Conf.DbName, config.Conf.DbAddress, config.Conf.DbPort, config.Conf.DbUsername, config.Conf.DbPassword)
+	if err := database.LoadDataKeys(); err != nil {
+		log.Fatal(err)
+	}
+
+	// encryptsecrets and rotatesecretskey first arguments are used to encrypt the stored secrets and rotate the data key
+	if len(os.Args) > 1 && (os.Args[1] == "encryptsecrets" || os.Args[1] == "rotatesecretskey") {
+		secretsCommand(os.Args[1], os.Args[2:])
+		return
+	}
+	database.RegisterMetrics()
 
 	// set the Review Master SMS Gateway master queue ID
 	database.SetReviewMasterSMSGatewayMasterQueueID()
 
+	// config cache
+	database.EnableConfigCache(time.Duration(config.Conf.ConfigCacheTTL)*time.Second,
+		time.Duration(config.Conf.DailySentCountReconcilePeriod)*time.Second)
+
+	// processed bookings, repeated deliveries of a booking return the original response
+	database.EnableProcessedBookings(time.Duration(config.Conf.ProcessedBookingTTL)*time.Hour,
+		time.Duration(config.Conf.ProcessedBookingLease)*time.Second)
+
+	// barred telephones from the barred file and the database, reloaded every period (checked by the send later worker)
+	server.WatchBarred(time.Duration(config.Conf.BarredReloadPeriod) * time.Second)
+
+	// send later worker
+	pollPeriod := time.Duration(config.Conf.SendLaterPollPeriod) * time.Second
+	if sendLaterOnly {
+		sendlater.Run(pollPeriod, config.Conf.SendLaterBatchSize, config.Conf.SendLaterMaxAttempts)
+		return
+	}
+	if config.Conf.SendLaterEnabled {
+		go sendlater.Run(pollPeriod, config.Conf.SendLaterBatchSize, config.Conf.SendLaterMaxAttempts)
+	}
+
+	// delete the expired processed bookings
+	go server.PurgeProcessedBookings(time.Hour)
+
+	// hash the stored telephones and delete or pseudonymise the data older than the data retention of the clients
+	go server.DataRetention(time.Duration(config.Conf.DataRetentionPeriod) * time.Hour)
+
 	// run http server
 	server.Server(logFilename)
 }
+
+// secretsCommand - encrypt the stored secrets (encryptsecrets) or rotate the data key re-encrypting the stored secrets
+// with it (rotatesecretskey), rotatesecretskey with a new key file also rewraps the data keys with the new key
+func secretsCommand(command string, args []string) {
+	var (
+		n   int
+		err error
+	)
+	switch {
+	case command == "encryptsecrets":
+		n, err = database.EncryptSecrets()
+	case len(args) > 0:
+		var keyProvider *secrets.FileKeyProvider
+		if keyProvider, err = secrets.NewFileKeyProvider(args[0]); err == nil {
+			n, err = database.RotateDataKey(keyProvider)
+		}
+	default:
+		n, err = database.RotateDataKey(nil)
+	}
+	if err != nil {
+		log.Printf("Error, %s failed after updating %d rows, err: %v\n", command, n, err)
+		fmt.Fprintf(os.Stderr, "%s failed after updating %d rows: %v\n", command, n, err)
+		os.Exit(1)
+	}
+	log.Printf("%s updated %d rows\n", command, n)
+	fmt.Printf("%s updated %d rows\n", command, n)
+}
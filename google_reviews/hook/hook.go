@@ -0,0 +1,232 @@
+package hook
+
+// hook - extract the booking fields from a dispatcher webhook payload (JSON or form) using the mapping of the config,
+// each field is mapped to a JSONPath like expression e.g. {"telephone":"$.passenger.phone","booking_id":"$.id"}
+// so a new dispatcher can be set up from google_reviews_ui without a dispatcher specific handler.
+// NOTE: keep in line with the hook package in google_reviews and google_reviews_ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// fields that can be mapped
+const (
+	FieldTelephone      = "telephone"       // passenger telephone
+	FieldPassengerID    = "passenger_id"    // passenger identifier, used instead of the telephone (the message is returned)
+	FieldEmail          = "email"           // passenger email address, emailed instead when there is no telephone
+	FieldBookingID      = "booking_id"      // booking ID, used to find repeated deliveries of a booking
+	FieldBookingCreated = "booking_created" // booking creation time (RFC3339)
+	FieldBookedFor      = "booked_for"      // booked for time (RFC3339)
+	FieldPickedUp       = "picked_up"       // picked up time (RFC3339)
+	FieldCompany        = "company"         // company ID, checked against the config companies
+	FieldBookingSource  = "booking_source"  // booking source, checked against the config booking source mobile app state
+	FieldFirstName      = "first_name"      // message template placeholder
+	FieldDriverName     = "driver_name"     // message template placeholder
+	FieldPickupTime     = "pickup_time"     // message template placeholder
+	FieldMessage        = "message"         // message (when the config does not use the database message)
+	FieldLocale         = "locale"          // booking locale e.g. nl-BE, the language of the message (see config messages)
+)
+
+// Fields - the fields that can be mapped
+var Fields = []string{FieldTelephone, FieldPassengerID, FieldEmail, FieldBookingID, FieldBookingCreated, FieldBookedFor,
+	FieldPickedUp, FieldCompany, FieldBookingSource, FieldFirstName, FieldDriverName, FieldPickupTime, FieldMessage, FieldLocale}
+
+// maxExpressionLength - maximum length of the expression of a field
+const maxExpressionLength = 255
+
+// segment - a segment of a path, either an object member name or an array index
+type segment struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// path - a parsed path e.g. $.passenger.phones[0]
+type path []segment
+
+// expression - alternative paths (separated by |), the first with a value is used
+type expression []path
+
+// Mapping - the parsed expression of each mapped field
+type Mapping map[string]expression
+
+// isNameChar - check the character can be used in a member name without brackets
+func isNameChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parsePath - parse a path e.g. $.booking.passengers[0].phone or $['booking']['id'], the $. prefix is optional
+func parsePath(s string) (path, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "" || s == "$":
+		return nil, errors.New("path is empty")
+	case strings.HasPrefix(s, "$"):
+		s = s[1:]
+	default:
+		s = "." + s
+	}
+	var p path
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '.':
+			j := i + 1
+			for j < len(s) && isNameChar(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("expected a name at position %d", i+1)
+			}
+			p = append(p, segment{name: s[i+1 : j]})
+			i = j
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, errors.New("missing ]")
+			}
+			inner := s[i+1 : i+end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				p = append(p, segment{name: inner[1 : len(inner)-1]})
+			} else if n, err := strconv.Atoi(inner); err == nil && n >= 0 {
+				p = append(p, segment{index: n, isIndex: true})
+			} else {
+				return nil, fmt.Errorf("invalid index [%s]", inner)
+			}
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", s[i], i)
+		}
+	}
+	return p, nil
+}
+
+// parseExpression - parse the alternative paths of an expression e.g. $.mobile | $.telephone
+func parseExpression(s string) (expression, error) {
+	if len(s) > maxExpressionLength {
+		return nil, fmt.Errorf("expression is too long (maximum %d characters)", maxExpressionLength)
+	}
+	var e expression
+	for _, alt := range strings.Split(s, "|") {
+		p, err := parsePath(alt)
+		if err != nil {
+			return nil, err
+		}
+		e = append(e, p)
+	}
+	return e, nil
+}
+
+// ParseMapping - parse the mapping of the config, a JSON object of field to expression, either the telephone, email or
+// passenger ID has to be mapped
+func ParseMapping(s string) (Mapping, error) {
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, errors.New("mapping must be a JSON object of field to expression e.g. {\"telephone\":\"$.passenger.phone\"}")
+	}
+	known := make(map[string]bool, len(Fields))
+	for _, f := range Fields {
+		known[f] = true
+	}
+	m := make(Mapping, len(raw))
+	for field, expr := range raw {
+		if !known[field] {
+			return nil, fmt.Errorf("unknown field %s, the fields are: %s", field, strings.Join(Fields, ", "))
+		}
+		e, err := parseExpression(expr)
+		if err != nil {
+			return nil, fmt.Errorf("field %s expression %s: %v", field, expr, err)
+		}
+		m[field] = e
+	}
+	if m[FieldTelephone] == nil && m[FieldEmail] == nil && m[FieldPassengerID] == nil {
+		return nil, errors.New("either the telephone, email or passenger_id field has to be mapped")
+	}
+	return m, nil
+}
+
+// IsJSON - check whether the payload is JSON from the content type (or the body when there is no content type)
+func IsJSON(contentType string, body []byte) bool {
+	if strings.Contains(strings.ToLower(contentType), "json") {
+		return true
+	}
+	b := bytes.TrimSpace(body)
+	return contentType == "" && len(b) > 0 && (b[0] == '{' || b[0] == '[')
+}
+
+// value - the value at the path of the JSON document as a string (empty if not found or not a scalar)
+func (p path) value(doc interface{}) string {
+	v := doc
+	for _, s := range p {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			if s.isIndex {
+				return ""
+			}
+			v = t[s.name]
+		case []interface{}:
+			if !s.isIndex || s.index >= len(t) {
+				return ""
+			}
+			v = t[s.index]
+		default:
+			return ""
+		}
+	}
+	switch t := v.(type) {
+	case string:
+		return strings.TrimSpace(t)
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	return ""
+}
+
+// formKey - the form parameter of the path, the names joined by a full stop e.g. $.passenger.phone => passenger.phone
+func (p path) formKey() string {
+	names := make([]string, 0, len(p))
+	for _, s := range p {
+		if s.isIndex {
+			names = append(names, strconv.Itoa(s.index))
+		} else {
+			names = append(names, s.name)
+		}
+	}
+	return strings.Join(names, ".")
+}
+
+// Extract - extract the mapped fields from the JSON body, or the form when the payload is not JSON,
+// the fields not found are empty
+func Extract(m Mapping, body []byte, form url.Values, isJSON bool) (map[string]string, error) {
+	var doc interface{}
+	if isJSON {
+		d := json.NewDecoder(bytes.NewReader(body))
+		d.UseNumber()
+		if err := d.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("invalid JSON payload: %v", err)
+		}
+	}
+	values := make(map[string]string, len(m))
+	for field, e := range m {
+		for _, p := range e {
+			v := ""
+			if isJSON {
+				v = p.value(doc)
+			} else {
+				v = strings.TrimSpace(form.Get(p.formKey()))
+			}
+			if v != "" {
+				values[field] = v
+				break
+			}
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("test_counter_total", "Test counter.", "handler", "outcome")
+	c.Inc("/cordic", "success")
+	c.Inc("/cordic", "success")
+	c.Add(3, "/cab9", "failed")
+	c.Inc("/cab9") // wrong number of labels is ignored
+	if c.Value("/cordic", "success") != 2 || c.Value("/cab9", "failed") != 3 {
+		t.Fatalf("unexpected counter values: %v", c.values)
+	}
+	var buf bytes.Buffer
+	c.write(&buf)
+	want := "# HELP test_counter_total Test counter.\n" +
+		"# TYPE test_counter_total counter\n" +
+		"test_counter_total{handler=\"/cab9\",outcome=\"failed\"} 3\n" +
+		"test_counter_total{handler=\"/cordic\",outcome=\"success\"} 2\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected counter output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "Test duration.", []float64{1, 0.1}, "channel")
+	h.Observe(0.05, "HTTP")
+	h.Observe(0.5, "HTTP")
+	h.Observe(2, "HTTP")
+	if h.Count("HTTP") != 3 {
+		t.Fatalf("unexpected histogram count: %d", h.Count("HTTP"))
+	}
+	var buf bytes.Buffer
+	h.write(&buf)
+	want := "# HELP test_duration_seconds Test duration.\n" +
+		"# TYPE test_duration_seconds histogram\n" +
+		"test_duration_seconds_bucket{channel=\"HTTP\",le=\"0.1\"} 1\n" +
+		"test_duration_seconds_bucket{channel=\"HTTP\",le=\"1\"} 2\n" +
+		"test_duration_seconds_bucket{channel=\"HTTP\",le=\"+Inf\"} 3\n" +
+		"test_duration_seconds_sum{channel=\"HTTP\"} 2.55\n" +
+		"test_duration_seconds_count{channel=\"HTTP\"} 3\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected histogram output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	if v := escapeLabelValue("a\"b\\c\nd"); v != `a\"b\\c\nd` {
+		t.Fatalf("unexpected escaped label value: %s", v)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	NewGaugeFunc("test_gauge", "Test gauge.", func() float64 { return 7 })
+	tests := []struct {
+		name       string
+		target     string
+		remoteAddr string
+		status     int
+	}{
+		{"token", "/metrics?metrics_token=secret", "192.0.2.1:1234", http.StatusOK},
+		{"wrong token", "/metrics?metrics_token=wrong", "192.0.2.1:1234", http.StatusUnauthorized},
+		{"allowed IP", "/metrics", "10.0.0.5:1234", http.StatusOK},
+		{"allowed CIDR", "/metrics", "172.16.1.2:1234", http.StatusOK},
+		{"not allowed", "/metrics", "192.0.2.1:1234", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.RemoteAddr = tt.remoteAddr
+		rr := httptest.NewRecorder()
+		Handler("secret", []string{"10.0.0.5", "172.16.0.0/16"}).ServeHTTP(rr, req)
+		if rr.Code != tt.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && !strings.Contains(rr.Body.String(), "test_gauge 7\n") {
+			t.Errorf("%s: metrics missing the gauge: %s", tt.name, rr.Body.String())
+		}
+	}
+	// no token and no allowed IPs
+	req := httptest.NewRequest("GET", "/metrics?metrics_token=", nil)
+	rr := httptest.NewRecorder()
+	Handler("", nil).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("handler without a token or allowed IPs returned status code: %v", rr.Code)
+	}
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	requests := NewCounterVec("test_requests_total", "Test requests.", "handler", "outcome")
+	duration := NewHistogramVec("test_request_duration_seconds", "Test request duration.", DefaultBuckets, "handler")
+	failedResponse := []byte(`{"success":"0"}`)
+	for _, body := range []string{`{"success":"0"}`, "OK", ""} {
+		h := InstrumentHandler(requests, duration, "/test", failedResponse, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if body == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	}
+	if requests.Value("/test", "failed") != 1 || requests.Value("/test", "success") != 1 || requests.Value("/test", "error") != 1 {
+		t.Fatalf("unexpected request counts: %v", requests.values)
+	}
+	if duration.Count("/test") != 3 {
+		t.Fatalf("unexpected request duration count: %d", duration.Count("/test"))
+	}
+}
@@ -0,0 +1,175 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKeyProvider - key file provider with a new key file in the test temporary directory
+func testKeyProvider(t *testing.T, name string) *FileKeyProvider {
+	keyFile := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat(name[:1], keySize)))+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewFileKeyProvider(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// testKeyring - set the key provider and the data keys, restored when the test finishes
+func testKeyring(t *testing.T, p KeyProvider, wrapped map[uint64][]byte, activeID uint64) {
+	t.Cleanup(func() {
+		SetKeyProvider(nil)
+		keyring.keys = make(map[uint64][]byte)
+		keyring.activeID = 0
+	})
+	SetKeyProvider(p)
+	if err := SetDataKeys(wrapped, activeID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewFileKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		content string
+		valid   bool
+	}{
+		{base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", keySize))), true},
+		{strings.Repeat("0a", keySize) + "\n", true},
+		{base64.StdEncoding.EncodeToString([]byte("short")), false},
+		{"not a key", false},
+	}
+	for i, test := range tests {
+		keyFile := filepath.Join(dir, "key")
+		if err := ioutil.WriteFile(keyFile, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := NewFileKeyProvider(keyFile)
+		if (err == nil) != test.valid {
+			t.Errorf("test %d, expected valid: %v, err: %v", i, test.valid, err)
+		}
+	}
+	if _, err := NewFileKeyProvider(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing key file error, got: %v", err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	// not encrypted without a key provider
+	if value, err := Encrypt("secret"); err != nil || value != "secret" {
+		t.Fatalf("expected the secret unencrypted without a key provider, got: %s, err: %v", value, err)
+	}
+
+	p := testKeyProvider(t, "a")
+	testKeyring(t, p, nil, 0)
+	if _, err := Encrypt("secret"); !errors.Is(err, ErrNoDataKey) {
+		t.Fatalf("expected no data key error, got: %v", err)
+	}
+
+	wrapped, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDataKeys(map[uint64][]byte{7: wrapped}, 7); err != nil {
+		t.Fatal(err)
+	}
+	value, err := Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(value) || !strings.HasPrefix(value, "enc:v1:7:") || strings.Contains(value, "secret") {
+		t.Fatalf("expected the secret encrypted with data key 7, got: %s", value)
+	}
+	if again, _ := Encrypt("secret"); again == value {
+		t.Error("expected a different nonce each time the secret is encrypted")
+	}
+	if secret, err := Decrypt(value); err != nil || secret != "secret" {
+		t.Errorf("expected secret, got: %s, err: %v", secret, err)
+	}
+
+	// empty and plaintext secrets are returned as is
+	if value, err := Encrypt(""); err != nil || value != "" {
+		t.Errorf("expected empty secret, got: %s, err: %v", value, err)
+	}
+	if secret, err := Decrypt("plaintext"); err != nil || secret != "plaintext" {
+		t.Errorf("expected plaintext, got: %s, err: %v", secret, err)
+	}
+
+	// unknown data key, malformed and tampered secrets
+	if _, err := Decrypt(strings.Replace(value, "enc:v1:7:", "enc:v1:8:", 1)); !errors.Is(err, ErrUnknownDataKey) {
+		t.Errorf("expected unknown data key error, got: %v", err)
+	}
+	for _, malformed := range []string{"enc:v1:7", "enc:v1:x:" + value[9:], "enc:v1:7:!"} {
+		if _, err := Decrypt(malformed); err == nil {
+			t.Errorf("expected error decrypting: %s", malformed)
+		}
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(value[9:])
+	sealed[len(sealed)-1] ^= 1
+	if _, err := Decrypt("enc:v1:7:" + base64.StdEncoding.EncodeToString(sealed)); err == nil {
+		t.Error("expected error decrypting a tampered secret")
+	}
+
+	// encrypted secrets cannot be decrypted without the key provider
+	SetKeyProvider(nil)
+	if _, err := Decrypt(value); !errors.Is(err, ErrNoKeyProvider) {
+		t.Errorf("expected no key provider error, got: %v", err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	p := testKeyProvider(t, "a")
+	testKeyring(t, p, nil, 0)
+	wrapped1, _ := NewDataKey()
+	if err := SetDataKeys(map[uint64][]byte{1: wrapped1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	value1, _ := Encrypt("secret")
+	if !EncryptedWithActiveKey(value1) || !EncryptedWithActiveKey("") || EncryptedWithActiveKey("plaintext") {
+		t.Fatal("expected only the plaintext secret to need encrypting")
+	}
+
+	// new active data key, the secrets encrypted with the old data key can still be decrypted
+	wrapped2, _ := NewDataKey()
+	if err := SetDataKeys(map[uint64][]byte{1: wrapped1, 2: wrapped2}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if EncryptedWithActiveKey(value1) {
+		t.Error("expected the secret encrypted with the old data key to need re-encrypting")
+	}
+	if secret, err := Decrypt(value1); err != nil || secret != "secret" {
+		t.Errorf("expected secret, got: %s, err: %v", secret, err)
+	}
+	value2, _ := Encrypt("secret")
+	if !strings.HasPrefix(value2, "enc:v1:2:") || !EncryptedWithActiveKey(value2) {
+		t.Errorf("expected the secret encrypted with data key 2, got: %s", value2)
+	}
+
+	// rewrap the data keys with a new key provider
+	newProvider := testKeyProvider(t, "b")
+	rewrapped1, err := Rewrap(wrapped1, newProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrapped2, _ := Rewrap(wrapped2, newProvider)
+	if err := SetDataKeys(map[uint64][]byte{1: rewrapped1, 2: rewrapped2}, 2); err == nil {
+		t.Error("expected error unwrapping the rewrapped data keys with the old key provider")
+	}
+	SetKeyProvider(newProvider)
+	if err := SetDataKeys(map[uint64][]byte{1: rewrapped1, 2: rewrapped2}, 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{value1, value2} {
+		if secret, err := Decrypt(value); err != nil || secret != "secret" {
+			t.Errorf("expected secret after rewrapping, got: %s, err: %v", secret, err)
+		}
+	}
+}
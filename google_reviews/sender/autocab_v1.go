@@ -0,0 +1,78 @@
+package sender
+
+import (
+	"encoding/json"
+)
+
+const autocabV1 = "AUTOCAB_V1"
+
+func init() {
+	Register(autocabV1, autocabV1Sender{})
+}
+
+// autocabV1Sender - send the message via the Autocab V1 Send SMS API.
+// Messages are added by google_reviews_autocab, this is used to send the send laters.
+//
+// NOTE: The alternate message service secret1 is used in the header as the subscription key.
+type autocabV1Sender struct{}
+
+// Name - name of the message service (recorded as the channel in message events)
+func (autocabV1Sender) Name() string {
+	return autocabV1
+}
+
+type autocabV1SendSMSRequest struct {
+	SenderName      string   `json:"senderName"`
+	RecipientTelnos []string `json:"recipientTelnos"`
+	Message         string   `json:"message"`
+}
+
+type autocabV1SendSMSResponse struct {
+	SentRecipients   []string `json:"sentRecipients"`
+	UnsentRecipients []string `json:"unsentRecipients"`
+}
+
+// BuildRequest - build the HTTP request to send the message
+func (autocabV1Sender) BuildRequest(m Message) Request {
+	body, _ := json.Marshal(autocabV1SendSMSRequest{
+		RecipientTelnos: []string{m.SendTelephone},
+		Message:         m.Message,
+	})
+	return Request{
+		URL:    appendPath(m.SendURL, "sms/v1/send"),
+		Method: "POST",
+		Headers: map[string]string{
+			"Content-Type":              "application/json",
+			"Accept":                    "application/json",
+			"Ocp-Apim-Subscription-Key": m.Secret1,
+		},
+		Body:          body,
+		TLSSkipVerify: m.TLSSkipVerify,
+	}
+}
+
+// Send - send the request
+func (autocabV1Sender) Send(r Request) (string, error) {
+	return send(r, autocabV1)
+}
+
+// InterpretResponse - check the response to make sure it has been sent successfully
+// For a successfully sent SMS the response appears to be empty, otherwise the telephone should be in the sent recipients
+func (autocabV1Sender) InterpretResponse(m Message, resp string) (string, bool) {
+	if len(resp) == 0 {
+		return m.SuccessResponse, true
+	}
+	var r autocabV1SendSMSResponse
+	json.Unmarshal([]byte(resp), &r)
+	for _, t := range r.SentRecipients {
+		if t == m.Telephone {
+			return m.SuccessResponse, true
+		}
+	}
+	return resp, false
+}
+
+// SendLater - store the request to be sent later
+func (autocabV1Sender) SendLater(m Message, r Request, sendAfterMinutes int) {
+	addSendLater(m, r, sendAfterMinutes, false, false, true, autocabV1, false)
+}
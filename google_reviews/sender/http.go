@@ -0,0 +1,63 @@
+package sender
+
+import (
+	"strings"
+)
+
+func init() {
+	Register(HTTP, httpSender{})
+}
+
+// httpSender - send the message to the configured send URL passing through the parameters from the request
+// (with the message replaced), e.g. own SMS gateway or dispatcher app
+type httpSender struct{}
+
+// Name - name of the message service (recorded as the channel in message events)
+func (httpSender) Name() string {
+	return HTTP
+}
+
+// BuildRequest - build the HTTP request to send the message
+func (httpSender) BuildRequest(m Message) Request {
+	r := Request{
+		URL:    m.SendURL,
+		Method: method(m),
+		Params: m.Params,
+		// the send URL of the config, the TLS certificate is not verified when the config opts out
+		TLSSkipVerify: m.TLSSkipVerify,
+	}
+	if r.Method == "POST" {
+		r.Headers = map[string]string{
+			"Content-Type": "application/x-www-form-urlencoded",
+		}
+	} else {
+		r.Headers = map[string]string{
+			"Content-Type": "text/plain",
+		}
+	}
+	return r
+}
+
+// Send - send the request, paced so the gateway (e.g. own SMS gateway) is not sent a burst of requests, when the
+// gateway is busy the request is not sent and ErrGatewayBusy is returned (it is deferred)
+func (httpSender) Send(r Request) (string, error) {
+	if err := paceGateway(r.URL); err != nil {
+		return "", err
+	}
+	return send(r, "")
+}
+
+// InterpretResponse - the response is passed through to the caller
+// NOTE: the word EMPTY is put in the database for the send_success_response field when an empty string is returned
+func (httpSender) InterpretResponse(m Message, resp string) (string, bool) {
+	if (m.SuccessResponse == "EMPTY" && resp == "") ||
+		strings.HasPrefix(strings.Trim(resp, " "), m.SuccessResponse) {
+		return resp, true
+	}
+	return resp, false
+}
+
+// SendLater - store the request to be sent later
+func (httpSender) SendLater(m Message, r Request, sendAfterMinutes int) {
+	addSendLater(m, r, sendAfterMinutes, false, false, false, "", false)
+}
@@ -0,0 +1,66 @@
+package sender
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+)
+
+func init() {
+	Register(IcabbiApp, icabbiAppSender{})
+}
+
+// icabbiAppSender - send the message from the iCabbi App
+type icabbiAppSender struct{}
+
+// Name - name of the message service (recorded as the channel in message events)
+func (icabbiAppSender) Name() string {
+	return IcabbiApp
+}
+
+// BuildRequest - build the HTTP request to send the message, set the send params to:
+// app_key: from iCabbi
+// secret_key: from iCabbi
+// recipient: the telephone
+// body: the message
+// When there is no message the parameters from the request are passed through.
+func (icabbiAppSender) BuildRequest(m Message) Request {
+	r := httpSender{}.BuildRequest(m)
+	if m.Message == "" {
+		return r
+	}
+	params := url.Values{}
+	params.Set("app_key", m.AppKey)
+	params.Set("secret_key", m.SecretKey)
+	params.Set("recipient", m.SendTelephone)
+	params.Set("body", m.Message)
+	r.Params = params
+	// add correct API call to the send URL
+	r.URL = appendPath(m.SendURL, "sms/add")
+	return r
+}
+
+// Send - send the request
+func (icabbiAppSender) Send(r Request) (string, error) {
+	return send(r, "")
+}
+
+// InterpretResponse - check sent successfully from iCabbi App
+// type conversion is a string value "0" when successul else type int HTTP code (e.g. 404)
+func (icabbiAppSender) InterpretResponse(m Message, resp string) (string, bool) {
+	var rJson map[string]interface{}
+	if errJson := json.Unmarshal([]byte(resp), &rJson); errJson != nil {
+		log.Printf("Error unmarshalling JSON from sending message from iCabbi APP for clientID: %d, sending message to %s, response from send server: %+v, error: %+v", m.ClientID, m.SendURL, resp, errJson)
+	}
+	code, ok := rJson["code"].(string)
+	if !ok || code != "0" {
+		return resp, false
+	}
+	// set response to expected configured response which can be anything
+	return m.SuccessResponse, true
+}
+
+// SendLater - store the request to be sent later
+func (icabbiAppSender) SendLater(m Message, r Request, sendAfterMinutes int) {
+	addSendLater(m, r, sendAfterMinutes, true, false, false, "", false)
+}
@@ -0,0 +1,134 @@
+package sender
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+
+	"google_reviews/config"
+)
+
+// MessageMedia - registry key and channel of the Message Media message service
+const MessageMedia = "Message Media"
+
+func init() {
+	Register(MessageMedia, messageMediaSender{})
+}
+
+// messageMediaSender - send the message via Message Media
+// (see: https://support.messagemedia.com/hc/en-us/articles/4413635760527-Messaging-API)
+//
+// NOTE: Put the api_key and api_secret as parameters in the request.
+//
+//	These are used for basic authentication in the header.
+type messageMediaSender struct{}
+
+// Name - name of the message service (recorded as the channel in message events)
+func (messageMediaSender) Name() string {
+	return MessageMedia
+}
+
+type messageMediaMessage struct {
+	Content           string `json:"content"`
+	DestinationNumber string `json:"destination_number"`
+	Format            string `json:"format"`
+	DeliveryReport    string `json:"delivery_report"`
+	CallbackURL       string `json:"callback_url,omitempty"`
+}
+
+type messageMediaMessages struct {
+	Messages []messageMediaMessage `json:"messages"`
+}
+
+type messageMediaMessageResponse struct {
+	Content           string `json:"content"`
+	DestinationNumber string `json:"destination_number"`
+	Format            string `json:"format"`
+	MessageID         string `json:"message_id"`
+	Status            string `json:"status"`
+}
+
+type messageMediaMessagesResponse struct {
+	Messages []messageMediaMessageResponse `json:"messages"`
+}
+
+// MessageMediaDLRTokenParameter - parameter of the Message Media delivery report callback URL with the DLR token
+const MessageMediaDLRTokenParameter = "dlr_token"
+
+// messageMediaCallbackURL - the delivery report callback URL with the DLR token, empty when the delivery reports
+// are not configured (the delivery reports are then sent to the webhook of the account if any)
+func messageMediaCallbackURL() string {
+	if config.Conf.MessageMediaDLRURL == "" || config.Conf.MessageMediaDLRToken == "" {
+		return ""
+	}
+	u, err := url.Parse(config.Conf.MessageMediaDLRURL)
+	if err != nil {
+		log.Printf("Error parsing the Message Media DLR URL: %s, err: %v\n", config.Conf.MessageMediaDLRURL, err)
+		return ""
+	}
+	q := u.Query()
+	q.Set(MessageMediaDLRTokenParameter, config.Conf.MessageMediaDLRToken)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// BuildRequest - build the HTTP request to send the message
+func (messageMediaSender) BuildRequest(m Message) Request {
+	body, _ := json.Marshal(messageMediaMessages{
+		Messages: []messageMediaMessage{
+			{
+				Content:           m.Message,
+				DestinationNumber: "+" + m.SendTelephone,
+				Format:            "SMS",
+				DeliveryReport:    "true",
+				CallbackURL:       messageMediaCallbackURL(),
+			},
+		},
+	})
+	return Request{
+		URL:       m.SendURL,
+		Method:    method(m),
+		AppKey:    m.ApiKey,
+		SecretKey: m.ApiSecret,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Accept":       "application/json",
+		},
+		Body:          body,
+		TLSSkipVerify: m.TLSSkipVerify,
+	}
+}
+
+// Send - send the request
+func (messageMediaSender) Send(r Request) (string, error) {
+	return send(r, MessageMedia)
+}
+
+// InterpretResponse - check the response to make sure it has been sent successfully
+// example successful response:
+// {"messages":[{"callback_url":null,"delivery_report":true,"destination_number":"+447889525579","format":"SMS","message_expiry_timestamp":null,"message_flags":[],"message_id":"13d1a0ba-be11-401d-b4e8-7f27d8ccce99","metadata":null,"scheduled":null,"status":"queued","content":"testing","source_number":null,"rich_link":null,"media":null,"subject":null}]}
+// NOTE: Only one message is sent at a time so there should only be one.
+func (messageMediaSender) InterpretResponse(m Message, resp string) (string, bool) {
+	var mmmr messageMediaMessagesResponse
+	json.Unmarshal([]byte(resp), &mmmr)
+	if len(mmmr.Messages) < 1 || len(mmmr.Messages[0].MessageID) < 1 || mmmr.Messages[0].Status != "queued" {
+		return resp, false
+	}
+	// set response to expected configured response which can be anything
+	return m.SuccessResponse, true
+}
+
+// MessageID - the message ID from the response, used to match the delivery reports
+func (messageMediaSender) MessageID(resp string) string {
+	var mmmr messageMediaMessagesResponse
+	json.Unmarshal([]byte(resp), &mmmr)
+	if len(mmmr.Messages) < 1 {
+		return ""
+	}
+	return mmmr.Messages[0].MessageID
+}
+
+// SendLater - store the request to be sent later
+func (messageMediaSender) SendLater(m Message, r Request, sendAfterMinutes int) {
+	addSendLater(m, r, sendAfterMinutes, false, false, true, MessageMedia, false)
+}
@@ -0,0 +1,509 @@
+// Package sender - sends messages via the configured message service.
+// Each message service implements MessageSender and is registered by name, the name being the
+// alternate message service or dispatcher type as stored in the config. To add a new message service
+// implement MessageSender and register it in init.
+package sender
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google_reviews/client"
+	"google_reviews/config"
+	"google_reviews/database"
+)
+
+// registry keys for the message services that are not set by the alternate message service or dispatcher type
+const (
+	HTTP                   = "HTTP"
+	IcabbiApp              = "ICABBI_APP"
+	ReviewMasterSMSGateway = "REVIEW_MASTER_SMS_GATEWAY"
+)
+
+// Message - message to send and the details needed to send it
+type Message struct {
+	ClientID uint64
+	// Telephone - telephone in E.164 format without the + (used for last sent and send later)
+	Telephone string
+	// SendTelephone - telephone to send to, which may have the country code replaced
+	SendTelephone string
+	Message       string
+	// Params - parameters from the request, passed through when sending via HTTP
+	Params  url.Values
+	SendURL string
+	HttpGet bool
+	// AppKey, SecretKey - from the config (e.g. iCabbi App)
+	AppKey    string
+	SecretKey string
+	// ApiKey, ApiSecret - from the request (e.g. Message Media)
+	ApiKey    string
+	ApiSecret string
+	// Secret1 - alternate message service secret1 from the config (e.g. Veezu auth token)
+	Secret1 string
+	// Sender - alternate message service sender from the config (e.g. Twilio alphanumeric sender or messaging service SID)
+	Sender                               string
+	ReviewMasterSMSGatewayUseMasterQueue bool
+	// SuccessResponse - configured response returned to the caller when sent successfully
+	SuccessResponse   string
+	MaxDailySendCount uint
+	// TemplateValues - message template placeholder values, used to fill the WhatsApp template parameters
+	TemplateValues map[string]string
+	// WhatsApp Cloud API phone number, access token and template from the config (see whatsAppSender)
+	WhatsAppPhoneNumberID      string
+	WhatsAppAccessToken        string
+	WhatsAppTemplateName       string
+	WhatsAppTemplateLanguage   string
+	WhatsAppTemplateParameters string
+	// FallbackRef - reference of the fallback held whilst the message is delivered via WhatsApp (see HoldFallback)
+	FallbackRef string
+	// TLSSkipVerify - the TLS certificate of the send URL is not verified (the config opts out)
+	TLSSkipVerify bool
+	// holdFallback - the send later is stored as a held fallback (see HoldFallback)
+	holdFallback bool
+}
+
+// Request - HTTP request to send a message
+type Request struct {
+	URL    string
+	Method string
+	// AppKey, SecretKey - used for basic authentication when both set
+	AppKey    string
+	SecretKey string
+	Headers   map[string]string
+	Params    url.Values
+	Body      []byte
+	// TLSSkipVerify - the TLS certificate of the URL is not verified
+	TLSSkipVerify bool
+}
+
+// MessageSender - message service used to send messages
+type MessageSender interface {
+	// Name - name of the message service (recorded as the channel in message events)
+	Name() string
+	// BuildRequest - build the HTTP request to send the message
+	BuildRequest(m Message) Request
+	// Send - send the request returning the response from the message service, an error is returned when the message
+	// service could not be reached or still failed with a transient error (the request can be sent again later)
+	Send(r Request) (string, error)
+	// InterpretResponse - check the response from the message service returning the response
+	// to return to the caller and whether the message was sent successfully
+	InterpretResponse(m Message, resp string) (string, bool)
+	// SendLater - store the request to be sent later by the send later worker
+	SendLater(m Message, r Request, sendAfterMinutes int)
+}
+
+// MessageIDSender - message service returning a message ID when sent, used to match its delivery status callbacks
+type MessageIDSender interface {
+	// MessageID - the message ID from the response of the message service (empty if none)
+	MessageID(resp string) string
+}
+
+// FallbackSender - message service whose messages can have a fallback (e.g. WhatsApp with SMS fallback), the
+// fallback is held whilst the message is delivered (see HoldFallback)
+type FallbackSender interface {
+	// FallbackRef - the reference of the held fallback sent with the request (empty if none)
+	FallbackRef(r Request) string
+}
+
+var senders = map[string]MessageSender{}
+
+// Register - register a message sender by name (alternate message service or dispatcher type)
+func Register(name string, s MessageSender) {
+	senders[name] = s
+}
+
+// Get - get a registered message sender by name, returns nil if not found
+func Get(name string) MessageSender {
+	return senders[name]
+}
+
+// ForConfig - get the message sender for the config, WhatsApp when it is the preferred channel
+func ForConfig(grcftwc database.GoogleReviewsConfigFromTokenWithChecks) MessageSender {
+	if prefersWhatsApp(grcftwc) {
+		return senders[WhatsApp]
+	}
+	return find(grcftwc.ReviewMasterSMSGatewayEnabled, grcftwc.AlternateMessageServiceEnabled,
+		grcftwc.AlternateMessageService, grcftwc.SendFromIcabbiApp, grcftwc.DispatcherType)
+}
+
+// FallbackForConfig - get the message sender used when a message is not sent via WhatsApp (e.g. the telephone is not
+// on WhatsApp), the SMS message service of the config. Returns nil when WhatsApp is not the preferred channel or SMS
+// fallback is not enabled.
+func FallbackForConfig(grcftwc database.GoogleReviewsConfigFromTokenWithChecks) MessageSender {
+	if !prefersWhatsApp(grcftwc) || !grcftwc.WhatsAppSMSFallback {
+		return nil
+	}
+	return find(grcftwc.ReviewMasterSMSGatewayEnabled, grcftwc.AlternateMessageServiceEnabled,
+		grcftwc.AlternateMessageService, grcftwc.SendFromIcabbiApp, grcftwc.DispatcherType)
+}
+
+// prefersWhatsApp - whether WhatsApp is the preferred channel of the config (and the phone number is set up)
+func prefersWhatsApp(grcftwc database.GoogleReviewsConfigFromTokenWithChecks) bool {
+	return grcftwc.MessageChannel == WhatsApp && grcftwc.WhatsAppPhoneNumberID != "" && grcftwc.WhatsAppTemplateName != ""
+}
+
+// ForSendLater - get the message sender used to send a send later
+func ForSendLater(sl database.SendLater) MessageSender {
+	return find(sl.ReviewMasterSMSGatewayEnabled, sl.AlternateMessageServiceEnabled,
+		sl.AlternateMessageService, sl.SendFromIcabbiApp, "")
+}
+
+// find - find the message sender, in order of precedence:
+// Review Master SMS Gateway, alternate message service, iCabbi App, dispatcher type then HTTP
+func find(reviewMasterSMSGatewayEnabled bool, alternateMessageServiceEnabled bool, alternateMessageService string,
+	sendFromIcabbiApp bool, dispatcherType string) MessageSender {
+	if reviewMasterSMSGatewayEnabled {
+		return senders[ReviewMasterSMSGateway]
+	}
+	if alternateMessageServiceEnabled {
+		if s, ok := senders[alternateMessageService]; ok {
+			return s
+		}
+	}
+	if sendFromIcabbiApp {
+		return senders[IcabbiApp]
+	}
+	if s, ok := senders[dispatcherType]; ok && dispatcherType != "" {
+		return s
+	}
+	return senders[HTTP]
+}
+
+// MessageFromConfig - create the message to send from the config
+func MessageFromConfig(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, telephone string, sendTelephone string,
+	message string, params url.Values) Message {
+	return Message{
+		ClientID:                             grcftwc.ClientID,
+		Telephone:                            telephone,
+		SendTelephone:                        sendTelephone,
+		Message:                              message,
+		Params:                               params,
+		SendURL:                              grcftwc.SendURL,
+		HttpGet:                              grcftwc.HttpGet,
+		AppKey:                               grcftwc.AppKey,
+		SecretKey:                            grcftwc.SecretKey,
+		Secret1:                              strings.TrimSpace(grcftwc.AlternateMessageServiceSecret1),
+		Sender:                               strings.TrimSpace(grcftwc.AlternateMessageServiceSender),
+		ReviewMasterSMSGatewayUseMasterQueue: grcftwc.ReviewMasterSMSGatewayUseMasterQueue,
+		SuccessResponse:                      grcftwc.SendSuccessResponse,
+		MaxDailySendCount:                    grcftwc.MaxDailySendCount,
+		WhatsAppPhoneNumberID:                grcftwc.WhatsAppPhoneNumberID,
+		WhatsAppAccessToken:                  strings.TrimSpace(grcftwc.WhatsAppAccessToken),
+		WhatsAppTemplateName:                 grcftwc.WhatsAppTemplateName,
+		WhatsAppTemplateLanguage:             grcftwc.WhatsAppTemplateLanguage,
+		WhatsAppTemplateParameters:           grcftwc.WhatsAppTemplateParameters,
+		FallbackRef:                          fallbackRef(grcftwc),
+		TLSSkipVerify:                        grcftwc.TLSSkipVerify,
+	}
+}
+
+// fallbackRef - new reference for the fallback of the message when the config has one (see FallbackForConfig)
+func fallbackRef(grcftwc database.GoogleReviewsConfigFromTokenWithChecks) string {
+	if FallbackForConfig(grcftwc) == nil {
+		return ""
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error creating fallback ref for clientID: %d, err: %v\n", grcftwc.ClientID, err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// MessageFromSendLater - create the message from a send later (only what is needed to interpret the response)
+func MessageFromSendLater(sl database.SendLater) Message {
+	return Message{
+		ClientID:          sl.ClientID,
+		Telephone:         sl.Telephone,
+		SendTelephone:     sl.Telephone,
+		SendURL:           sl.SendURL,
+		SuccessResponse:   sl.SendSuccessResponse,
+		MaxDailySendCount: sl.MaxDailySendCount,
+	}
+}
+
+// RequestFromSendLater - create the request from a send later
+func RequestFromSendLater(sl database.SendLater) Request {
+	return Request{
+		URL:           sl.SendURL,
+		Method:        sl.HttpMethod,
+		AppKey:        sl.AppKey,
+		SecretKey:     sl.SecretKey,
+		Headers:       sl.Headers,
+		Params:        sl.Params,
+		Body:          sl.Body,
+		TLSSkipVerify: sl.TLSSkipVerify,
+	}
+}
+
+// RequestPreview - the HTTP request that would be sent (see Preview)
+type RequestPreview struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// secretHeaders - headers holding credentials, their values are not shown in a preview
+var secretHeaders = []string{"Authorization", "Api-Token", "Auth_token", "Ocp-Apim-Subscription-Key"}
+
+// secretNames - parts of parameter and body field names holding credentials (e.g. the iCabbi app_key and secret_key,
+// Veezu auth_token, secret1, api_token or access_token), their values are not shown in a preview
+var secretNames = []string{"key", "secret", "token", "password", "auth"}
+
+// redacted - shown in a preview in place of a credential
+const redacted = "[redacted]"
+
+// secretName - whether the parameter, header or body field name holds a credential
+func secretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Preview - the HTTP request that would be sent for the request (the same request as sent by Send) with the
+// credentials redacted from the headers, parameters and body, used when simulating
+func Preview(r Request) (RequestPreview, error) {
+	// the values of the credentials, redacted wherever they appear in the URL or body
+	secrets := []string{r.AppKey, r.SecretKey}
+	headers := make(map[string]string, len(r.Headers))
+	for k, v := range r.Headers {
+		if secretName(k) {
+			secrets = append(secrets, v, strings.TrimPrefix(strings.TrimPrefix(v, "Bearer "), "Basic "))
+			v = redacted
+		}
+		headers[k] = v
+	}
+	params := url.Values{}
+	for k, vs := range r.Params {
+		for _, v := range vs {
+			if secretName(k) {
+				secrets = append(secrets, v)
+				v = redacted
+			}
+			params.Add(k, v)
+		}
+	}
+	body := redactBody(r.Body)
+
+	req, err := client.NewRequestWithHeaders(r.URL, r.Method, r.AppKey, r.SecretKey, headers, params, body)
+	if err != nil {
+		return RequestPreview{}, err
+	}
+	p := RequestPreview{Method: req.Method, URL: redactSecrets(req.URL.String(), secrets), Headers: make(map[string]string)}
+	for k := range req.Header {
+		p.Headers[k] = req.Header.Get(k)
+	}
+	for _, k := range secretHeaders {
+		if _, ok := p.Headers[k]; ok {
+			p.Headers[k] = redacted
+		}
+	}
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return p, err
+		}
+		p.Body = redactSecrets(string(b), secrets)
+	}
+	return p, nil
+}
+
+// redactBody - the JSON body with the values of the fields holding credentials redacted, other bodies and bodies
+// without credentials are returned unchanged
+func redactBody(body []byte) []byte {
+	var v interface{}
+	if len(body) == 0 || json.Unmarshal(body, &v) != nil || !redactFields(v) {
+		return body
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+// redactFields - redact the values of the fields holding credentials in the decoded JSON value, returning whether
+// any were redacted
+func redactFields(v interface{}) bool {
+	found := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, f := range t {
+			if secretName(k) {
+				t[k] = redacted
+				found = true
+			} else if redactFields(f) {
+				found = true
+			}
+		}
+	case []interface{}:
+		for _, f := range t {
+			if redactFields(f) {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// redactSecrets - replace the credential values wherever they appear in s (including URL encoded)
+func redactSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		s = strings.ReplaceAll(s, secret, redacted)
+		s = strings.ReplaceAll(s, url.QueryEscape(secret), url.QueryEscape(redacted))
+	}
+	return s
+}
+
+// RecordMessageID - record the message ID from the response against the message event of the message sent when the
+// message service returns one (see MessageIDSender)
+func RecordMessageID(s MessageSender, messageEventID uint64, resp string) {
+	if ms, ok := s.(MessageIDSender); ok && messageEventID != 0 {
+		database.SetMessageEventProviderMessageID(messageEventID, ms.MessageID(resp))
+	}
+}
+
+// HoldFallback - store the message via the fallback as a held send later whilst the message sent via s is delivered,
+// it is released (see ReleaseFallback) when the message fails and deleted when delivered (see the WhatsApp webhook).
+// Nothing is held when there is no fallback or the message was sent via the fallback.
+func HoldFallback(s MessageSender, fallback MessageSender, m Message) {
+	if fallback == nil || s == fallback || m.FallbackRef == "" {
+		return
+	}
+	m.holdFallback = true
+	fallback.SendLater(m, fallback.BuildRequest(m), 0)
+}
+
+// ReleaseFallback - release the fallback held for the request (see HoldFallback) when it was not sent, the fallback is
+// then sent by the send later worker. Returns whether a held fallback was released.
+func ReleaseFallback(s MessageSender, r Request) bool {
+	fs, ok := s.(FallbackSender)
+	if !ok {
+		return false
+	}
+	return database.ReleaseFallback(fs.FallbackRef(r), false)
+}
+
+// SendWithFallback - send the request, when it is not sent (e.g. the telephone is not on WhatsApp) and there is a
+// fallback the failure is recorded and the message is sent via the fallback instead. Returns the message sender used,
+// the request sent, the response, the latency and the transient error (see MessageSender.Send) of the last send.
+func SendWithFallback(s MessageSender, fallback MessageSender, m Message, r Request) (MessageSender, Request, string, time.Duration, error) {
+	sendStart := time.Now()
+	providerResp, err := s.Send(r)
+	latency := time.Since(sendStart)
+	if fallback == nil || s == fallback || errors.Is(err, ErrGatewayBusy) {
+		return s, r, providerResp, latency, err
+	}
+	if _, sent := s.InterpretResponse(m, providerResp); sent {
+		return s, r, providerResp, latency, nil
+	}
+	log.Printf("not sent via %s for clientID: %d, sending via %s\n", s.Name(), m.ClientID, fallback.Name())
+	database.AddMessageEvent(m.ClientID, m.Telephone, s.Name(), database.ReasonProviderError, providerResp, latency)
+	r = fallback.BuildRequest(m)
+	sendStart = time.Now()
+	providerResp, err = fallback.Send(r)
+	return fallback, r, providerResp, time.Since(sendStart), err
+}
+
+// send - send the request
+func send(r Request, alternateMessageService string) (string, error) {
+	return client.SendWithHeaders(r.URL, r.Method, r.AppKey, r.SecretKey, r.Headers, r.Params, r.Body, alternateMessageService, r.TLSSkipVerify)
+}
+
+// the time the next request can be sent to each gateway (see paceGateway)
+var (
+	gatewaysMu  sync.Mutex
+	gatewayNext = make(map[string]time.Time)
+)
+
+// ErrGatewayBusy - the request to the gateway would wait longer than the gateway max wait (see paceGateway), it is
+// deferred to the send later worker the same as when the gateway is unavailable
+var ErrGatewayBusy = errors.New("gateway busy")
+
+// paceGateway - wait until the next request can be sent to the gateway (the host of the URL) so an SMS gateway
+// sending from SIMs is not sent a burst of requests, the requests to a gateway are spaced by the gateway send
+// interval (0 disables). The wait is capped by the gateway max wait so the request is not held up behind a queue of
+// requests, ErrGatewayBusy is returned (and no slot is taken) when the request would wait longer.
+func paceGateway(gatewayURL string) error {
+	interval := time.Duration(config.Conf.GatewaySendInterval) * time.Millisecond
+	if interval <= 0 {
+		return nil
+	}
+	gateway := gatewayURL
+	if u, err := url.Parse(gatewayURL); err == nil && u.Host != "" {
+		gateway = u.Host
+	}
+	gatewaysMu.Lock()
+	now := time.Now()
+	next := gatewayNext[gateway]
+	if next.Before(now) {
+		next = now
+	}
+	if next.Sub(now) > time.Duration(config.Conf.GatewayMaxWait)*time.Millisecond {
+		gatewaysMu.Unlock()
+		return ErrGatewayBusy
+	}
+	gatewayNext[gateway] = next.Add(interval)
+	gatewaysMu.Unlock()
+	time.Sleep(next.Sub(now))
+	return nil
+}
+
+// SendWaiting - send the request waiting for the gateway when it is busy (see paceGateway), used by the send later
+// worker which is not holding up a webhook
+func SendWaiting(s MessageSender, r Request) (string, error) {
+	for {
+		providerResp, err := s.Send(r)
+		if !errors.Is(err, ErrGatewayBusy) {
+			return providerResp, err
+		}
+		time.Sleep(time.Duration(config.Conf.GatewaySendInterval) * time.Millisecond)
+	}
+}
+
+// addSendLater - store the request to be sent later with the flags used to find the sender when sent
+func addSendLater(m Message, r Request, sendAfterMinutes int, sendFromIcabbiApp bool, reviewMasterSMSGatewayEnabled bool,
+	alternateMessageServiceEnabled bool, alternateMessageService string, sendFromOwnSMSGatewayEnabled bool) {
+	ref := ""
+	if m.holdFallback {
+		ref = m.FallbackRef
+	}
+	database.AddSendLater(m.Telephone, m.ClientID, sendAfterMinutes,
+		r.URL, r.Method, r.AppKey, r.SecretKey,
+		r.Headers, r.Params, r.Body, sendFromIcabbiApp,
+		reviewMasterSMSGatewayEnabled, alternateMessageServiceEnabled,
+		alternateMessageService, sendFromOwnSMSGatewayEnabled,
+		m.SuccessResponse, m.MaxDailySendCount, ref, r.TLSSkipVerify)
+}
+
+// method - HTTP method from the config
+func method(m Message) string {
+	if m.HttpGet {
+		return "GET"
+	}
+	return "POST"
+}
+
+// appendPath - append path to URL adding a / if needed
+func appendPath(u string, path string) string {
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	return u + path
+}
@@ -0,0 +1,536 @@
+package sender
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"google_reviews/client"
+	"google_reviews/config"
+	"google_reviews/database"
+)
+
+// testServer - local HTTP server standing in for a message service, records the last request received
+func testServer(status int, response string, last *http.Request, lastBody *[]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*lastBody, _ = ioutil.ReadAll(req.Body)
+		*last = *req
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+}
+
+func TestForConfig(t *testing.T) {
+	tests := []struct {
+		grcftwc  database.GoogleReviewsConfigFromTokenWithChecks
+		expected MessageSender
+	}{
+		{database.GoogleReviewsConfigFromTokenWithChecks{}, httpSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{SendFromIcabbiApp: true}, icabbiAppSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{ReviewMasterSMSGatewayEnabled: true, SendFromIcabbiApp: true}, reviewMasterSMSGatewaySender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{AlternateMessageServiceEnabled: true, AlternateMessageService: "Message Media"}, messageMediaSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{AlternateMessageServiceEnabled: true, AlternateMessageService: "Veezu", SendFromIcabbiApp: true}, veezuSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{AlternateMessageServiceEnabled: false, AlternateMessageService: "Veezu"}, httpSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{AlternateMessageServiceEnabled: true, AlternateMessageService: "Unknown"}, httpSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{DispatcherType: "CAB 9"}, reviewMasterSMSGatewaySender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{AlternateMessageServiceEnabled: true, AlternateMessageService: "Twilio"}, twilioSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{MessageChannel: WhatsApp, WhatsAppPhoneNumberID: "1098", WhatsAppTemplateName: "review_request"}, whatsAppSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{MessageChannel: WhatsApp, WhatsAppPhoneNumberID: "1098"}, httpSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{MessageChannel: "SMS", WhatsAppPhoneNumberID: "1098", WhatsAppTemplateName: "review_request"}, httpSender{}},
+	}
+	for i, tt := range tests {
+		if s := ForConfig(tt.grcftwc); s != tt.expected {
+			t.Errorf("test %d: expected sender %T got %T", i, tt.expected, s)
+		}
+	}
+}
+
+func TestFallbackForConfig(t *testing.T) {
+	grcftwc := database.GoogleReviewsConfigFromTokenWithChecks{MessageChannel: WhatsApp, WhatsAppPhoneNumberID: "1098",
+		WhatsAppTemplateName: "review_request", WhatsAppSMSFallback: true, AlternateMessageServiceEnabled: true, AlternateMessageService: "Twilio"}
+	if s := FallbackForConfig(grcftwc); s != (twilioSender{}) {
+		t.Errorf("expected Twilio fallback got %T", s)
+	}
+	if m := MessageFromConfig(grcftwc, "447123456789", "447123456789", "testing", nil); len(m.FallbackRef) != 32 {
+		t.Errorf("expected a fallback ref got %s", m.FallbackRef)
+	}
+	grcftwc.WhatsAppSMSFallback = false
+	if s := FallbackForConfig(grcftwc); s != nil {
+		t.Errorf("expected no fallback got %T", s)
+	}
+	if m := MessageFromConfig(grcftwc, "447123456789", "447123456789", "testing", nil); m.FallbackRef != "" {
+		t.Errorf("expected no fallback ref got %s", m.FallbackRef)
+	}
+	if s := FallbackForConfig(database.GoogleReviewsConfigFromTokenWithChecks{WhatsAppSMSFallback: true}); s != nil {
+		t.Errorf("expected no fallback when SMS is the channel got %T", s)
+	}
+}
+
+func TestForSendLater(t *testing.T) {
+	if s := ForSendLater(database.SendLater{AlternateMessageServiceEnabled: true, AlternateMessageService: "AUTOCAB_V1"}); s != (autocabV1Sender{}) {
+		t.Errorf("expected Autocab V1 sender got %T", s)
+	}
+	if s := ForSendLater(database.SendLater{SendFromOwnSMSGatewayEnabled: true}); s != (httpSender{}) {
+		t.Errorf("expected HTTP sender got %T", s)
+	}
+}
+
+func TestHTTPSender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusOK, "OK sent", &req, &body)
+	defer ts.Close()
+
+	params := url.Values{}
+	params.Set("t", "447123456789")
+	params.Set("m", "testing")
+	s := Get(HTTP)
+	m := Message{SendURL: ts.URL, Params: params, SuccessResponse: "OK"}
+	providerResp, err := s.Send(s.BuildRequest(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, sent := s.InterpretResponse(m, providerResp)
+	if !sent || resp != "OK sent" {
+		t.Fatalf("expected sent with response OK sent got %t %s", sent, resp)
+	}
+	form, _ := url.ParseQuery(string(body))
+	if form.Get("m") != "testing" {
+		t.Errorf("expected message parameter to be passed through got %v", form)
+	}
+
+	m.SuccessResponse = "EMPTY"
+	if _, sent := s.InterpretResponse(m, ""); !sent {
+		t.Error("empty response with EMPTY success response should be sent")
+	}
+	if _, sent := s.InterpretResponse(m, "FAILED"); sent {
+		t.Error("FAILED response with EMPTY success response should not be sent")
+	}
+}
+
+func TestHTTPSenderTLSSkipVerify(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("OK sent"))
+	}))
+	defer ts.Close()
+
+	// the certificate of the test server is not trusted so is only sent when the config opts out of TLS verification
+	s := Get(HTTP)
+	m := Message{SendURL: ts.URL, Params: url.Values{"m": {"testing"}}, SuccessResponse: "OK"}
+	providerResp, err := s.Send(s.BuildRequest(m))
+	if _, sent := s.InterpretResponse(m, providerResp); sent || err != nil {
+		t.Fatalf("expected not sent with an untrusted certificate got %s, err: %v", providerResp, err)
+	}
+	m.TLSSkipVerify = true
+	providerResp, err = s.Send(s.BuildRequest(m))
+	if _, sent := s.InterpretResponse(m, providerResp); !sent || err != nil {
+		t.Fatalf("expected sent skipping TLS verification got %s, err: %v", providerResp, err)
+	}
+	// the opt out is only for the send URL of the config
+	if r := Get(ReviewMasterSMSGateway).BuildRequest(m); r.TLSSkipVerify {
+		t.Error("Review Master SMS Gateway request should verify the TLS certificate")
+	}
+}
+
+func TestSendTransientError(t *testing.T) {
+	client.SetResilience(client.Resilience{Timeout: time.Second, MaxRetries: 1, RetryBackoff: time.Millisecond})
+	defer client.SetResilience(client.Resilience{Timeout: 10 * time.Second, MaxRetries: 2, RetryBackoff: 250 * time.Millisecond,
+		BreakerFailures: 5, BreakerOpenPeriod: 30 * time.Second})
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusServiceUnavailable, `{"error":"unavailable"}`, &req, &body)
+	defer ts.Close()
+
+	// the message service is unavailable so the error is returned for the message to be sent later
+	s := Get(Twilio)
+	m := Message{SendURL: ts.URL, SendTelephone: "447123456789", Message: "testing"}
+	providerResp, err := s.Send(s.BuildRequest(m))
+	if err == nil || providerResp != `{"error":"unavailable"}` {
+		t.Fatalf("expected transient error got %s, err: %v", providerResp, err)
+	}
+}
+
+func TestIcabbiAppSender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusOK, `{"code":"0"}`, &req, &body)
+	defer ts.Close()
+
+	s := Get(IcabbiApp)
+	m := Message{SendURL: ts.URL, AppKey: "key", SecretKey: "secret", SendTelephone: "07123456789", Message: "testing", SuccessResponse: "success=1"}
+	r := s.BuildRequest(m)
+	if r.URL != ts.URL+"/sms/add" {
+		t.Errorf("expected URL %s/sms/add got %s", ts.URL, r.URL)
+	}
+	providerResp, err := s.Send(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, sent := s.InterpretResponse(m, providerResp)
+	if !sent || resp != "success=1" {
+		t.Fatalf("expected sent with response success=1 got %t %s", sent, resp)
+	}
+	form, _ := url.ParseQuery(string(body))
+	if form.Get("recipient") != "07123456789" || form.Get("body") != "testing" || form.Get("app_key") != "key" {
+		t.Errorf("unexpected iCabbi App parameters %v", form)
+	}
+	if _, sent := s.InterpretResponse(m, `{"code":404}`); sent {
+		t.Error("iCabbi App code 404 should not be sent")
+	}
+}
+
+func TestReviewMasterSMSGatewaySender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusCreated, `{"id":76}`, &req, &body)
+	defer ts.Close()
+	config.Conf.ReviewMasterSMSGatewayURL = ts.URL
+	config.Conf.ReviewMasterSMSGatewayApiToken = "abc123"
+
+	s := Get(ReviewMasterSMSGateway)
+	m := Message{ClientID: 81, SendTelephone: "447123456789", Message: "testing", SuccessResponse: `{"success":"1"}`}
+	providerResp, err := s.Send(s.BuildRequest(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, sent := s.InterpretResponse(m, providerResp)
+	if !sent || resp != `{"success":"1"}` {
+		t.Fatalf("expected sent with response {\"success\":\"1\"} got %t %s", sent, resp)
+	}
+	if req.Header.Get("Api-Token") != "abc123" {
+		t.Errorf("expected api token header got %v", req.Header)
+	}
+	var rJson map[string]string
+	json.Unmarshal(body, &rJson)
+	if rJson["queue_id"] != "81" || rJson["telephone"] != "+447123456789" || rJson["message"] != "testing" {
+		t.Errorf("unexpected body %s", body)
+	}
+	if _, sent := s.InterpretResponse(m, `{"error":"unauthorized"}`); sent {
+		t.Error("unauthorized should not be sent")
+	}
+	if id := s.(MessageIDSender).MessageID(`{"id":76}`); id != "76" {
+		t.Errorf("unexpected message ID %s", id)
+	}
+	if id := s.(MessageIDSender).MessageID(`{"error":"unauthorized"}`); id != "" {
+		t.Errorf("unexpected message ID %s for an error", id)
+	}
+}
+
+func TestMessageMediaSender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusAccepted, `{"messages":[{"message_id":"13d1a0ba-be11-401d-b4e8-7f27d8ccce99","status":"queued"}]}`, &req, &body)
+	defer ts.Close()
+
+	config.Conf.MessageMediaDLRURL = "https://reviews.example.com/dlr/messagemedia"
+	config.Conf.MessageMediaDLRToken = "dlr123"
+	defer func() { config.Conf.MessageMediaDLRURL, config.Conf.MessageMediaDLRToken = "", "" }()
+
+	s := Get(MessageMedia)
+	m := Message{SendURL: ts.URL, ApiKey: "key", ApiSecret: "secret", SendTelephone: "447123456789", Message: "testing", SuccessResponse: "OK"}
+	providerResp, err := s.Send(s.BuildRequest(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, sent := s.InterpretResponse(m, providerResp)
+	if !sent || resp != "OK" {
+		t.Fatalf("expected sent with response OK got %t %s", sent, resp)
+	}
+	var mmm messageMediaMessages
+	json.Unmarshal(body, &mmm)
+	if len(mmm.Messages) != 1 || mmm.Messages[0].CallbackURL != "https://reviews.example.com/dlr/messagemedia?dlr_token=dlr123" {
+		t.Errorf("unexpected body %s", body)
+	}
+	if id := s.(MessageIDSender).MessageID(providerResp); id != "13d1a0ba-be11-401d-b4e8-7f27d8ccce99" {
+		t.Errorf("unexpected message ID %s", id)
+	}
+	if user, pass, ok := req.BasicAuth(); !ok || user != "key" || pass != "secret" {
+		t.Error("expected basic authentication with api key and secret")
+	}
+	if _, sent := s.InterpretResponse(m, `{"messages":[{"message_id":"13d1a0ba","status":"failed"}]}`); sent {
+		t.Error("failed status should not be sent")
+	}
+	if _, sent := s.InterpretResponse(m, ""); sent {
+		t.Error("empty response should not be sent")
+	}
+}
+
+func TestVeezuSender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusOK, "", &req, &body)
+	defer ts.Close()
+
+	s := Get("Veezu")
+	m := Message{SendURL: ts.URL, Secret1: "token", SendTelephone: "447123456789", Message: "testing", SuccessResponse: "OK"}
+	providerResp, err := s.Send(s.BuildRequest(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, sent := s.InterpretResponse(m, providerResp)
+	if !sent || resp != "OK" {
+		t.Fatalf("expected sent with response OK got %t %s", sent, resp)
+	}
+	if req.Header.Get("auth_token") != "token" {
+		t.Errorf("expected auth_token header got %v", req.Header)
+	}
+}
+
+func TestVeezuSenderFails(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusUnauthorized, "", &req, &body)
+	defer ts.Close()
+
+	s := Get("Veezu")
+	m := Message{SendURL: ts.URL, SendTelephone: "447123456789", Message: "testing", SuccessResponse: "OK"}
+	providerResp, err := s.Send(s.BuildRequest(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, sent := s.InterpretResponse(m, providerResp); sent {
+		t.Fatal("HTTP 401 should not be sent")
+	}
+}
+
+func TestTwilioSender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusCreated, `{"sid":"SM0123456789abcdef0123456789abcdef","status":"queued","error_code":null,"error_message":null}`, &req, &body)
+	defer ts.Close()
+	config.Conf.TwilioStatusCallbackURL = "https://reviews.example.com/twilio/status"
+	defer func() { config.Conf.TwilioStatusCallbackURL = "" }()
+
+	s := Get(Twilio)
+	m := Message{SendURL: ts.URL + "/2010-04-01/Accounts/AC0123456789abcdef0123456789abcdef/Messages.json", Secret1: "token",
+		Sender: "TaxiCo", SendTelephone: "447123456789", Message: "testing", SuccessResponse: "OK"}
+	providerResp, err := s.Send(s.BuildRequest(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, sent := s.InterpretResponse(m, providerResp)
+	if !sent || resp != "OK" {
+		t.Fatalf("expected sent with response OK got %t %s", sent, resp)
+	}
+	if user, pass, ok := req.BasicAuth(); !ok || user != "AC0123456789abcdef0123456789abcdef" || pass != "token" {
+		t.Error("expected basic authentication with the account SID and auth token")
+	}
+	if req.URL.Path != "/2010-04-01/Accounts/AC0123456789abcdef0123456789abcdef/Messages.json" {
+		t.Errorf("unexpected path %s", req.URL.Path)
+	}
+	form, _ := url.ParseQuery(string(body))
+	if form.Get("To") != "+447123456789" || form.Get("Body") != "testing" || form.Get("From") != "TaxiCo" ||
+		form.Get("MessagingServiceSid") != "" || form.Get("StatusCallback") != "https://reviews.example.com/twilio/status" {
+		t.Errorf("unexpected Twilio parameters %v", form)
+	}
+	if id := s.(MessageIDSender).MessageID(providerResp); id != "SM0123456789abcdef0123456789abcdef" {
+		t.Errorf("unexpected message ID %s", id)
+	}
+
+	// messaging service SID
+	m.Sender = "MG0123456789abcdef0123456789abcdef"
+	r := s.BuildRequest(m)
+	if r.Params.Get("MessagingServiceSid") != m.Sender || r.Params.Get("From") != "" {
+		t.Errorf("expected the messaging service SID got %v", r.Params)
+	}
+
+	if _, sent := s.InterpretResponse(m, `{"code":21211,"message":"The 'To' number is not a valid phone number.","status":400}`); sent {
+		t.Error("invalid number should not be sent")
+	}
+	if _, sent := s.InterpretResponse(m, `{"sid":"SM0123","status":"failed","error_code":30006}`); sent {
+		t.Error("failed status should not be sent")
+	}
+	if _, sent := s.InterpretResponse(m, ""); sent {
+		t.Error("empty response should not be sent")
+	}
+}
+
+func TestTwilioSenderUnauthorized(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusUnauthorized, `{"code":20003,"message":"Authenticate","status":401}`, &req, &body)
+	defer ts.Close()
+
+	s := Get(Twilio)
+	m := Message{SendURL: ts.URL + "/2010-04-01/Accounts/AC0123456789abcdef0123456789abcdef/Messages.json", Secret1: "wrong",
+		Sender: "TaxiCo", SendTelephone: "447123456789", Message: "testing", SuccessResponse: "OK"}
+	providerResp, err := s.Send(s.BuildRequest(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, sent := s.InterpretResponse(m, providerResp); sent {
+		t.Fatal("HTTP 401 should not be sent")
+	}
+}
+
+func TestWhatsAppSender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusOK, `{"messaging_product":"whatsapp","contacts":[{"input":"447123456789","wa_id":"447123456789"}],"messages":[{"id":"wamid.HBgM0123"}]}`, &req, &body)
+	defer ts.Close()
+	graphURL := config.Conf.WhatsAppGraphURL
+	config.Conf.WhatsAppGraphURL = ts.URL + "/v19.0"
+	defer func() { config.Conf.WhatsAppGraphURL = graphURL }()
+
+	s := Get(WhatsApp)
+	m := Message{SendTelephone: "447123456789", Message: "testing", SuccessResponse: "OK",
+		TemplateValues:        map[string]string{"first_name": "Jane", "review_link": "https://g.page/r/review"},
+		WhatsAppPhoneNumberID: "109876543210987", WhatsAppAccessToken: "token", WhatsAppTemplateName: "review_request",
+		WhatsAppTemplateLanguage: "en_GB", WhatsAppTemplateParameters: "{first_name}, {review_link}", FallbackRef: "ref1"}
+	r := s.BuildRequest(m)
+	providerResp, err := s.Send(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, sent := s.InterpretResponse(m, providerResp)
+	if !sent || resp != "OK" {
+		t.Fatalf("expected sent with response OK got %t %s", sent, resp)
+	}
+	if req.URL.Path != "/v19.0/109876543210987/messages" {
+		t.Errorf("unexpected path %s", req.URL.Path)
+	}
+	if req.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("unexpected authorization %s", req.Header.Get("Authorization"))
+	}
+	var wmr whatsAppMessageRequest
+	if err := json.Unmarshal(body, &wmr); err != nil {
+		t.Fatal(err)
+	}
+	if wmr.To != "447123456789" || wmr.Template.Name != "review_request" || wmr.Template.Language.Code != "en_GB" ||
+		wmr.BizOpaqueCallbackData != "ref1" || len(wmr.Template.Components) != 1 {
+		t.Fatalf("unexpected WhatsApp request %s", body)
+	}
+	if p := wmr.Template.Components[0].Parameters; len(p) != 2 || p[0].Text != "Jane" || p[1].Text != "https://g.page/r/review" {
+		t.Errorf("unexpected template parameters %v", p)
+	}
+	if id := s.(MessageIDSender).MessageID(providerResp); id != "wamid.HBgM0123" {
+		t.Errorf("unexpected message ID %s", id)
+	}
+	if ref := s.(FallbackSender).FallbackRef(r); ref != "ref1" {
+		t.Errorf("unexpected fallback ref %s", ref)
+	}
+
+	// the whole message by default and a parameter cannot be empty
+	m.WhatsAppTemplateParameters = ""
+	if p := whatsAppTemplateParameters(m); len(p) != 1 || p[0].Text != "testing" {
+		t.Errorf("unexpected default template parameters %v", p)
+	}
+	m.TemplateValues["first_name"] = ""
+	m.WhatsAppTemplateParameters = "{first_name}"
+	if p := whatsAppTemplateParameters(m); len(p) != 1 || p[0].Text != "-" {
+		t.Errorf("unexpected empty template parameter %v", p)
+	}
+
+	if _, sent := s.InterpretResponse(m, `{"error":{"message":"(#131030) Recipient phone number not in allowed list","type":"OAuthException","code":131030}}`); sent {
+		t.Error("error response should not be sent")
+	}
+	if _, sent := s.InterpretResponse(m, ""); sent {
+		t.Error("empty response should not be sent")
+	}
+}
+
+func TestAutocabV1Sender(t *testing.T) {
+	s := Get("AUTOCAB_V1")
+	m := Message{Telephone: "447123456789", SuccessResponse: "OK"}
+	if _, sent := s.InterpretResponse(m, ""); !sent {
+		t.Error("empty response should be sent")
+	}
+	if _, sent := s.InterpretResponse(m, `{"sentRecipients":["447123456789"],"unsentRecipients":[]}`); !sent {
+		t.Error("telephone in sent recipients should be sent")
+	}
+	if _, sent := s.InterpretResponse(m, `{ "statusCode": 401, "message": "Access denied" }`); sent {
+		t.Error("access denied should not be sent")
+	}
+}
+
+func TestPreview(t *testing.T) {
+	config.Conf.ReviewMasterSMSGatewayURL = "https://rmsg.example.com/messages"
+	config.Conf.ReviewMasterSMSGatewayApiToken = "abc123"
+	s := Get(ReviewMasterSMSGateway)
+	m := Message{ClientID: 81, SendTelephone: "447123456789", Message: "testing"}
+	p, err := Preview(s.BuildRequest(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Method != "POST" || p.URL != "https://rmsg.example.com/messages" || p.Headers["Content-Type"] != "application/json" {
+		t.Errorf("unexpected preview %+v", p)
+	}
+	if p.Headers["Api-Token"] != "[redacted]" {
+		t.Errorf("expected the api token to be redacted got %s", p.Headers["Api-Token"])
+	}
+	if p.Body != `{"message":"testing","queue_id":"81","telephone":"+447123456789"}` {
+		t.Errorf("unexpected body %s", p.Body)
+	}
+
+	// HTTP GET with the parameters in the URL and basic authentication
+	p, _ = Preview(Request{URL: "https://sms.example.com/send", Method: "GET", AppKey: "key", SecretKey: "secret",
+		Params: url.Values{"to": {"447123456789"}}})
+	if p.URL != "https://sms.example.com/send?to=447123456789" || p.Headers["Authorization"] != "[redacted]" || p.Body != "" {
+		t.Errorf("unexpected GET preview %+v", p)
+	}
+}
+
+func TestPreviewRedactsSecrets(t *testing.T) {
+	config.Conf.ReviewMasterSMSGatewayApiToken = "rmsg-api-token-secret"
+	secrets := []string{"app-key-secret", "secret-key-secret", "api-key-secret", "api-secret-secret", "secret1-secret",
+		"whatsapp-access-token-secret", "rmsg-api-token-secret", "password-secret"}
+	m := Message{ClientID: 81, Telephone: "447123456789", SendTelephone: "447123456789", Message: "testing",
+		SendURL: "https://sms.example.com/send", AppKey: "app-key-secret", SecretKey: "secret-key-secret",
+		ApiKey: "api-key-secret", ApiSecret: "api-secret-secret", Secret1: "secret1-secret",
+		WhatsAppAccessToken: "whatsapp-access-token-secret", WhatsAppPhoneNumberID: "123", WhatsAppTemplateName: "review",
+		Params: url.Values{"to": {"447123456789"}, "password": {"password-secret"}}}
+	for _, httpGet := range []bool{false, true} {
+		m.HttpGet = httpGet
+		for name, s := range senders {
+			p, err := Preview(s.BuildRequest(m))
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			b, _ := json.Marshal(p)
+			for _, secret := range secrets {
+				if strings.Contains(string(b), secret) {
+					t.Errorf("%s: secret %s in the preview %s", name, secret, b)
+				}
+			}
+		}
+	}
+}
+
+func TestPaceGateway(t *testing.T) {
+	config.Conf.GatewaySendInterval = 50
+	config.Conf.GatewayMaxWait = 1000
+	defer func() { config.Conf.GatewaySendInterval, config.Conf.GatewayMaxWait = 0, 0 }()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		paceGateway("https://gateway.example.com/send")
+	}
+	// the second and third requests wait for the interval
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("requests to the gateway should be spaced by the interval, took %v", elapsed)
+	}
+	// other gateways are not held up
+	start = time.Now()
+	paceGateway("https://other.example.com/send")
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Errorf("request to another gateway should not wait, took %v", elapsed)
+	}
+	// the request is not held up longer than the max wait, it is deferred instead
+	config.Conf.GatewayMaxWait = 10
+	if err := paceGateway("https://busy.example.com/send"); err != nil {
+		t.Errorf("first request to the gateway should be sent, got %v", err)
+	}
+	start = time.Now()
+	if err := paceGateway("https://busy.example.com/send"); !errors.Is(err, ErrGatewayBusy) {
+		t.Errorf("got %v, want %v", err, ErrGatewayBusy)
+	}
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Errorf("busy gateway should not wait, took %v", elapsed)
+	}
+}
@@ -0,0 +1,118 @@
+package sender
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+
+	"google_reviews/config"
+)
+
+// Twilio - registry key and channel of the Twilio message service
+const Twilio = "Twilio"
+
+func init() {
+	Register(Twilio, twilioSender{})
+}
+
+// twilioSender - send the message via Twilio Programmable Messaging
+// (see: https://www.twilio.com/docs/messaging/api/message-resource#create-a-message-resource)
+//
+// NOTE: The send URL is the Messages URL of the account e.g.
+// https://api.twilio.com/2010-04-01/Accounts/<Account SID>/Messages.json, the account SID (from the send URL)
+// and the alternate message service secret1 (the auth token) are used for basic authentication. The alternate
+// message service sender is the alphanumeric sender ID (or number) or the messaging service SID (starting MG).
+type twilioSender struct{}
+
+// Name - name of the message service (recorded as the channel in message events)
+func (twilioSender) Name() string {
+	return Twilio
+}
+
+type twilioMessageResponse struct {
+	Sid          string `json:"sid"`
+	Status       string `json:"status"`
+	ErrorCode    *int   `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// twilioAccountSID - the account SID from the Messages URL e.g. .../Accounts/AC123/Messages.json => AC123
+func twilioAccountSID(sendURL string) string {
+	u, err := url.Parse(sendURL)
+	if err != nil {
+		return ""
+	}
+	p := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i < len(p)-1; i++ {
+		if p[i] == "Accounts" {
+			return p[i+1]
+		}
+	}
+	return ""
+}
+
+// BuildRequest - build the HTTP request to send the message (the form parameters To, Body, From or
+// MessagingServiceSid and StatusCallback when the Twilio status callback URL is configured)
+func (twilioSender) BuildRequest(m Message) Request {
+	params := url.Values{}
+	params.Set("To", "+"+m.SendTelephone)
+	params.Set("Body", m.Message)
+	if strings.HasPrefix(m.Sender, "MG") {
+		params.Set("MessagingServiceSid", m.Sender)
+	} else {
+		params.Set("From", m.Sender)
+	}
+	if config.Conf.TwilioStatusCallbackURL != "" {
+		params.Set("StatusCallback", config.Conf.TwilioStatusCallbackURL)
+	}
+	// the basic authentication header is used rather than the app and secret keys so it is stored with a send later
+	auth := base64.StdEncoding.EncodeToString([]byte(twilioAccountSID(m.SendURL) + ":" + m.Secret1))
+	return Request{
+		URL:    m.SendURL,
+		Method: "POST",
+		Headers: map[string]string{
+			"Authorization": "Basic " + auth,
+			"Content-Type":  "application/x-www-form-urlencoded",
+			"Accept":        "application/json",
+		},
+		Params:        params,
+		TLSSkipVerify: m.TLSSkipVerify,
+	}
+}
+
+// Send - send the request
+func (twilioSender) Send(r Request) (string, error) {
+	return send(r, Twilio)
+}
+
+// InterpretResponse - check the response to make sure it has been accepted by Twilio
+// example successful response (HTTP 201):
+// {"sid":"SM0123456789abcdef0123456789abcdef","status":"queued","error_code":null,"error_message":null,...}
+// example failed response (HTTP 400):
+// {"code":21211,"message":"The 'To' number +44712 is not a valid phone number.","more_info":"...","status":400}
+func (twilioSender) InterpretResponse(m Message, resp string) (string, bool) {
+	var tmr twilioMessageResponse
+	if err := json.Unmarshal([]byte(resp), &tmr); err != nil {
+		log.Printf("Error unmarshalling Twilio response for clientID: %d, response: %s, err: %v\n", m.ClientID, resp, err)
+		return resp, false
+	}
+	if tmr.Sid == "" || tmr.ErrorCode != nil || tmr.Status == "failed" || tmr.Status == "undelivered" {
+		return resp, false
+	}
+	// set response to expected configured response which can be anything
+	return m.SuccessResponse, true
+}
+
+// MessageID - the message SID from the response, used to match the status callbacks
+func (twilioSender) MessageID(resp string) string {
+	var tmr twilioMessageResponse
+	json.Unmarshal([]byte(resp), &tmr)
+	return tmr.Sid
+}
+
+// SendLater - store the request to be sent later
+func (twilioSender) SendLater(m Message, r Request, sendAfterMinutes int) {
+	addSendLater(m, r, sendAfterMinutes, false, false, true, Twilio, false)
+}
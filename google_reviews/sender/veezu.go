@@ -0,0 +1,73 @@
+package sender
+
+import (
+	"encoding/json"
+)
+
+const veezu = "Veezu"
+
+func init() {
+	Register(veezu, veezuSender{})
+}
+
+// veezuSender - send the message via Veezu (see: https://messages.veezu.com/api/messages)
+//
+// NOTE: The alternate message service secret1 is used in the header as the auth_token.
+type veezuSender struct{}
+
+// Name - name of the message service (recorded as the channel in message events)
+func (veezuSender) Name() string {
+	return veezu
+}
+
+type veezuMessage struct {
+	Message   string `json:"message"`
+	Telephone string `json:"telephone"`
+}
+
+type veezuMessageResponse struct {
+	Success string `json:"success"`
+}
+
+// BuildRequest - build the HTTP request to send the message
+func (veezuSender) BuildRequest(m Message) Request {
+	body, _ := json.Marshal(veezuMessage{
+		Message:   m.Message,
+		Telephone: m.SendTelephone,
+	})
+	return Request{
+		URL:    m.SendURL,
+		Method: method(m),
+		Headers: map[string]string{
+			"auth_token":   m.Secret1,
+			"Content-Type": "application/json; charset=utf-8",
+			"Accept":       "application/json",
+		},
+		Body:          body,
+		TLSSkipVerify: m.TLSSkipVerify,
+	}
+}
+
+// Send - send the request, Veezu relies on HTTP 200 only for success
+// (the client returns {"success":"1"} for HTTP 200)
+func (veezuSender) Send(r Request) (string, error) {
+	return send(r, veezu)
+}
+
+// InterpretResponse - check the response to make sure it has been sent successfully
+// example successful response:
+// {"success":"1"}
+func (veezuSender) InterpretResponse(m Message, resp string) (string, bool) {
+	var vmr veezuMessageResponse
+	json.Unmarshal([]byte(resp), &vmr)
+	if vmr.Success != "1" {
+		return resp, false
+	}
+	// set response to expected configured response which can be anything
+	return m.SuccessResponse, true
+}
+
+// SendLater - store the request to be sent later
+func (veezuSender) SendLater(m Message, r Request, sendAfterMinutes int) {
+	addSendLater(m, r, sendAfterMinutes, false, false, true, veezu, false)
+}
@@ -0,0 +1,181 @@
+// Package sendlater - sends the messages stored in the send laters table (google_reviews_send_laters)
+// once they are due. The messages are stored by both google_reviews and google_reviews_autocab
+// when a send delay is configured.
+//
+// More than one worker can run at the same time (e.g. on different servers), each send later is
+// claimed by a worker before it is sent so it is only sent once.
+package sendlater
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	"google_reviews/barred"
+	"google_reviews/config"
+	"google_reviews/database"
+	"google_reviews/logging"
+	"google_reviews/sender"
+	"google_reviews/utils"
+)
+
+const (
+	// claimSeconds - how long a worker holds a claim on a send later before another worker can claim it
+	claimSeconds = 300
+	// retryAfterMinutes - how long to wait before trying to send again after a failed send
+	retryAfterMinutes = 5
+)
+
+// WorkerID - unique ID for this worker used when claiming send laters
+func WorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
+
+// Run - poll for send laters that are due and send them, runs forever
+func Run(pollPeriod time.Duration, batchSize int, maxAttempts int) {
+	workerID := WorkerID()
+	log.Printf("send later worker %s started, poll period: %v, batch size: %d, max attempts: %d\n", workerID, pollPeriod, batchSize, maxAttempts)
+	for {
+		ProcessDue(workerID, batchSize, maxAttempts)
+		time.Sleep(pollPeriod)
+	}
+}
+
+// ProcessDue - claim and send the send laters that are due
+func ProcessDue(workerID string, batchSize int, maxAttempts int) {
+	// held fallbacks not released within the expiry are no longer needed (see sender.HoldFallback)
+	if n := database.DeleteExpiredFallbacks(config.Conf.WhatsAppFallbackExpiry); n > 0 {
+		log.Printf("deleted %d expired fallbacks\n", n)
+	}
+	for {
+		sendLaters := database.ClaimSendLaters(workerID, claimSeconds, batchSize)
+		for _, sl := range sendLaters {
+			process(workerID, sl, maxAttempts)
+		}
+		if len(sendLaters) < batchSize {
+			return
+		}
+	}
+}
+
+// process - send a send later and remove it, or leave it to be retried if sending failed
+func process(workerID string, sl database.SendLater, maxAttempts int) {
+	s := sender.ForSendLater(sl)
+	// checks again here as things may have changed since the send later was added
+	_, sentCount, stop, _ := database.LastSentFromTelephoneAndClient(sl.Telephone, sl.ClientID)
+	if stop {
+		log.Printf("send later not sent, stop set for telephone: %s and clientID: %d\n", utils.MaskTelephone(sl.Telephone), sl.ClientID)
+		database.AddMessageEvent(sl.ClientID, sl.Telephone, s.Name(), database.ReasonStopped, "", 0)
+		database.DeleteSendLater(sl.ID, workerID)
+		return
+	}
+	if barred.Load().Barred(sl.Telephone, sl.ClientID) {
+		log.Printf("send later not sent, telephone: %s barred for clientID: %d\n", utils.MaskTelephone(sl.Telephone), sl.ClientID)
+		database.AddMessageEvent(sl.ClientID, sl.Telephone, s.Name(), database.ReasonBarred, "", 0)
+		database.DeleteSendLater(sl.ID, workerID)
+		return
+	}
+	// the send later was counted when deferred (see database.FatigueCappedDeferred), a resend has already been sent
+	if !sl.Resend {
+		if policy, capped := database.FatigueCappedDeferred(sl.Telephone, sl.ClientID); capped {
+			log.Printf("send later not sent, fatigue policy of partnerID: %d (%d review requests in %d days) reached for telephone: %s and clientID: %d\n",
+				policy.PartnerID, policy.MaxRequests, policy.Days, utils.MaskTelephone(sl.Telephone), sl.ClientID)
+			database.AddMessageEvent(sl.ClientID, sl.Telephone, s.Name(), database.ReasonFatigueCap, "", 0)
+			database.DeleteSendLater(sl.ID, workerID)
+			return
+		}
+	}
+	// a resend (fallback of a message that was not delivered) has already been counted so is not checked or counted again,
+	// when the daily send count is reached the send later is deferred to the next window of a later day
+	if !sl.Resend && sl.MaxDailySendCount > 0 && database.DailySentCount(sl.ClientID)+1 > sl.MaxDailySendCount {
+		deferMinutes := nextDayMinutes(sl.ClientID, time.Now())
+		log.Printf("send later deferred by %d minutes, reached maximum daily send count of %d for clientID: %d\n", deferMinutes, sl.MaxDailySendCount, sl.ClientID)
+		database.AddMessageEvent(sl.ClientID, sl.Telephone, s.Name(), database.ReasonMaxDailyCount, "", 0)
+		database.RetrySendLater(sl.ID, workerID, deferMinutes, false)
+		return
+	}
+
+	// send using the same message service, and its checks, as when sent immediately
+	sendStart := time.Now()
+	r := sender.RequestFromSendLater(sl)
+	// a transient error (e.g. the message service is unavailable) is retried the same as a failed send
+	providerResp, err := sender.SendWaiting(s, r)
+	latency := time.Since(sendStart)
+	resp, sent := s.InterpretResponse(sender.MessageFromSendLater(sl), providerResp)
+	if sent {
+		if !sl.Resend {
+			database.UpdateLastSent(sl.Telephone, sl.ClientID, sentCount+1)
+		}
+		// record the provider message ID to match the delivery status callbacks (e.g. Twilio)
+		sender.RecordMessageID(s, database.AddMessageEvent(sl.ClientID, sl.Telephone, s.Name(), database.ReasonSent, providerResp, latency), providerResp)
+		if !sl.Resend {
+			database.UpdateStatsSent(sl.ClientID)
+		}
+		database.DeleteSendLater(sl.ID, workerID)
+		return
+	}
+
+	database.AddMessageEvent(sl.ClientID, sl.Telephone, s.Name(), database.ReasonProviderError, providerResp, latency)
+	// not sent via WhatsApp (e.g. the telephone is not on WhatsApp) so the held SMS fallback is sent instead
+	if sender.ReleaseFallback(s, r) {
+		logSendError(sl, resp, err, "sending the fallback")
+		database.DeleteSendLater(sl.ID, workerID)
+		return
+	}
+	if int(sl.Attempts)+1 >= maxAttempts {
+		logSendError(sl, resp, err, "giving up")
+		database.DeleteSendLater(sl.ID, workerID)
+		return
+	}
+	logSendError(sl, resp, err, "will retry")
+	database.RetrySendLater(sl.ID, workerID, retryAfterMinutes, true)
+}
+
+// logSendError - log a send later message that failed to send (a structured send error record checked by CheckLog),
+// the telephone is masked and the params are not logged as they carry the telephone and secrets
+func logSendError(sl database.SendLater, resp string, err error, action string) {
+	attrs := []interface{}{logging.FieldEvent, logging.EventSendError,
+		logging.FieldClientID, sl.ClientID, logging.FieldReason, database.ReasonProviderError,
+		"url", sl.SendURL, "telephone", utils.MaskTelephone(sl.Telephone), "attempt", sl.Attempts + 1, "action", action, "response", resp}
+	if err != nil {
+		attrs = append(attrs, "error", err.Error())
+	}
+	slog.Error("Error sending message (send later)", attrs...)
+}
+
+// nextDayMinutes - minutes until the send later can be sent on a later day (the daily send count is reached today),
+// the start of the next window from tomorrow of the times of the client's configs or a day when there are none
+func nextDayMinutes(clientID uint64, now time.Time) int {
+	minutes := 0
+	for _, ct := range database.ConfigTimes(clientID) {
+		loc, err := time.LoadLocation(ct.TimeZone)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		local := now.In(loc)
+		tomorrow := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+		startTime, _, ok := utils.NextWindow(ct.Start, ct.End, ct.Days, tomorrow)
+		if !ok {
+			continue
+		}
+		// a window wrapping past midnight is already open
+		if startTime.Before(tomorrow) {
+			startTime = tomorrow
+		}
+		if m := int(math.Ceil(startTime.Sub(now).Minutes())); minutes == 0 || m < minutes {
+			minutes = m
+		}
+	}
+	if minutes == 0 {
+		minutes = 24 * 60
+	}
+	return minutes
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"google_reviews/barred"
+	"google_reviews/database"
+)
+
+// loadBarred - the barred telephones from the barred file (barred for all clients) and the database
+func loadBarred() ([]barred.Entry, error) {
+	entries, err := database.BarredTelephones()
+	if err != nil {
+		return nil, err
+	}
+	return append(barred.FileEntries(Bars), entries...), nil
+}
+
+// WatchBarred - load the barred telephones and reload them every period
+func WatchBarred(period time.Duration) {
+	// the barred file is used until the barred telephones are loaded from the database
+	barred.Store(barred.NewList(barred.FileEntries(Bars)))
+	barred.Watch(period, loadBarred)
+}
+
+// ReloadBarredHandler - reload the barred telephones, called by google_reviews_ui when a barred telephone is added
+// or removed so the change is used straight away rather than on the next reload.
+// For security require the log token (the same as used for checking the logs).
+// e.g. /reloadbarred?log_token=<log token>
+func ReloadBarredHandler(logTk string) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		logToken := strings.TrimSpace(req.URL.Query().Get("log_token"))
+		if logToken == "" || subtle.ConstantTimeCompare([]byte(logToken), []byte(logTk)) != 1 {
+			log.Printf("Error, log_token %s is incorrect for reloading the barred telephones\n", logToken)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := barred.Reload(loadBarred); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log.Printf("barred telephones reloaded, %d barred\n", barred.Load().Len())
+		w.Write([]byte("OK"))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// BarredHandler - the barred telephones from the database that are barred for all clients as JSON, polled by
+// send_sms (which has no database) e.g. /barred?barred_token=<barred token>
+func BarredHandler(barredTk string) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		barredToken := strings.TrimSpace(req.URL.Query().Get("barred_token"))
+		if barredToken == "" || subtle.ConstantTimeCompare([]byte(barredToken), []byte(barredTk)) != 1 {
+			log.Printf("Error, barred_token %s is incorrect for fetching the barred telephones\n", barredToken)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		entries, err := database.BarredTelephones()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		global := make([]barred.Entry, 0, len(entries))
+		for _, e := range entries {
+			if e.ClientID == 0 {
+				global = append(global, e)
+			}
+		}
+		resp, err := json.Marshal(global)
+		if err != nil {
+			log.Printf("Error marshalling barred telephones, err: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}
+
+	return http.HandlerFunc(fn)
+}
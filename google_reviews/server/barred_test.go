@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"google_reviews/barred"
+)
+
+func TestReloadBarredHandler(t *testing.T) {
+	prepareTestDatabase()
+	defer barred.Store(nil)
+	barred.Store(nil)
+
+	for _, tc := range []struct {
+		logToken string
+		status   int
+	}{
+		{"rubbishToken", http.StatusUnauthorized},
+		{testLogToken, http.StatusOK},
+	} {
+		req, err := http.NewRequest("GET", "/reloadbarred?log_token="+url.QueryEscape(tc.logToken), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		ReloadBarredHandler(testLogToken).ServeHTTP(rr, req)
+		if status := rr.Code; status != tc.status {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, tc.status)
+		}
+	}
+	// barred for all clients (prefix) and for client 12 (full number)
+	l := barred.Load()
+	if !l.Barred("447418456789", 1) || !l.Barred("447123456701", 12) || l.Barred("447123456701", 1) {
+		t.Errorf("unexpected barred telephones after reloading, %d barred", l.Len())
+	}
+}
+
+func TestBarredHandler(t *testing.T) {
+	prepareTestDatabase()
+	barredToken := "testBarredToken"
+	req, err := http.NewRequest("GET", "/barred?barred_token="+barredToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	BarredHandler(barredToken).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var entries []barred.Entry
+	if err := json.Unmarshal(rr.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	// only the telephones barred for all clients
+	if len(entries) != 1 || entries[0].Telephone != "447418" || entries[0].ClientID != 0 {
+		t.Errorf("unexpected barred telephones: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	BarredHandler("").ServeHTTP(rr, httptest.NewRequest("GET", "/barred?barred_token=", nil))
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler without a barred token returned status code: %v", status)
+	}
+}
@@ -1,8 +1,8 @@
 package server
 
 import (
+	"encoding/json"
 	"log"
-	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,30 +15,76 @@ import (
 // cordic request parameters
 const cordicGoogleReviewTokenParameter = "gr_token"
 const cordicPassengerIDParameter = "passenger_id"
+const cordicBookingIDParameter = "booking_id" // used to find repeated deliveries (else the config booking ID parameter)
 const cordicBookingCreationTimeParameter = "booking_creation_time"
 const cordicBookedForTimeParameter = "booked_for_time"
 const cordicPickedUpTimeParameter = "picked_up_time"
 const cordicIgnorePassengerIDChecksParameter = "ignore_passenger_id_checks"
 
+// cordic channel recorded in message events, the message is returned to Cordic which sends it
+const cordicChannel = "CORDIC"
+
 // cordic failed response
 var cordicFailedResponse = []byte(`{"message":""}`)
 
+// cordicMessageResponse - response returning the message to Cordic to send, the message is filled in from the request
+// (e.g. the first name) so is escaped
+func cordicMessageResponse(message string) []byte {
+	resp, err := json.Marshal(map[string]string{"message": message})
+	if err != nil {
+		log.Printf("Error marshalling cordic message response, err: %v\n", err)
+		return cordicFailedResponse
+	}
+	return resp
+}
+
 // CordicHandler - Cordic Google Reviews Handler
 func CordicHandler() http.Handler {
+	return cordicHandler(false)
+}
+
+// CordicSimulateHandler - simulate a request to the Cordic Google Reviews Handler returning a trace of each step (see simulation),
+// nothing is sent or written to the database
+func CordicSimulateHandler() http.Handler {
+	return cordicHandler(true)
+}
+
+// cordicHandler - Cordic Google Reviews Handler, simulating the request when simulate is set
+func cordicHandler(simulate bool) http.Handler {
 	fn := func(w http.ResponseWriter, req *http.Request) {
+		sim := newSimulation(simulate)
+		// the body is read first so the signature of a signed request can be verified
+		body := readBody(req)
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
 
 		if err := req.ParseForm(); err != nil {
 			// fmt.Printf("ParseForm() err: %v\n", err)
 			log.Printf("ParseForm() err: %v\n", err)
-			w.Write(cordicFailedResponse)
+			sim.write(w, cordicFailedResponse)
 			return
 		}
 		// log.Printf("r.PostForm = %v\n", req.PostForm)
 
 		// check google reviews token
 		grToken := strings.TrimSpace(req.FormValue(cordicGoogleReviewTokenParameter))
+		// verify the request (allowed IPs and signature) when set up for the config
+		if !sim.verifyRequest(req, body, "/cordic", grToken) {
+			sim.writeStatus(w, http.StatusUnauthorized, cordicFailedResponse)
+			return
+		}
+		// a repeated delivery of a booking (dispatcher retries) returns the original response without processing it again
+		clientID, bookingIdParameter := database.BookingClientFromToken(grToken)
+		bookingID := strings.TrimSpace(req.FormValue(cordicBookingIDParameter))
+		if bookingID == "" {
+			bookingID = strings.TrimSpace(req.FormValue(bookingIdParameter))
+		}
+		bw, claimed := sim.claimBooking(w, clientID, bookingID, cordicFailedResponse)
+		if !claimed {
+			return
+		}
+		defer bw.complete()
+		w = bw
 		// log.Printf("grToken: %s\n", grToken)
 		// check whether should ignore the time and daily sent count checks (used for testing on front end)
 		ignoreTimeAndSentCountCheck := false
@@ -49,28 +95,41 @@ func CordicHandler() http.Handler {
 		grcftwc := database.ConfigFromTokenWithChecks(grToken, ignoreTimeAndSentCountCheck)
 		if grcftwc.ClientID == 0 {
 			// log.Printf("token %s does not meet criteria", grToken)
+			sim.step("config", "rejected", nil)
+			// record why not sent (outside hours or maximum daily send count) if the token is found
+			if clientID, _, _, reason := database.RejectedConfigFromToken(grToken); clientID != 0 {
+				sim.addMessageEvent(clientID, strings.TrimSpace(req.FormValue(cordicPassengerIDParameter)), cordicChannel, reason, "", 0)
+			}
 			// update stats
-			database.UpdateStatsCanUseToken(0, grToken, false)
-			w.Write(cordicFailedResponse)
+			sim.updateStatsCanUseToken(0, grToken, false)
+			sim.write(w, cordicFailedResponse)
 			return
 		}
+		sim.step("config", "found", configDetail(grcftwc, ignoreTimeAndSentCountCheck))
 		// check database dispatcher_type is set to cordic
 		if grcftwc.DispatcherType != "CORDIC" {
 			log.Printf("Dispatcher type set to: %s should be CORDIC for clientID: %d", grcftwc.DispatcherType, grcftwc.ClientID)
-			w.Write(cordicFailedResponse)
+			sim.step("dispatcher_type", "failed", map[string]interface{}{"dispatcher_type": grcftwc.DispatcherType})
+			sim.write(w, cordicFailedResponse)
 			return
 		}
 
 		// The cordic passenger identifier is unique and is treated like a telephone number
 		passengerID := strings.TrimSpace(req.FormValue(cordicPassengerIDParameter))
+		// the message is returned to Cordic which sends it
+		sim.step("provider", cordicChannel, nil)
 		if passengerID == "" {
+			sim.step("passenger_id", "not_found", nil)
 			log.Printf("no passenger ID parameter sent in request for clientID: %d\n", grcftwc.ClientID)
+			sim.addMessageEvent(grcftwc.ClientID, "", cordicChannel, database.ReasonNoTelephone, "", 0)
 			// update stats
-			database.UpdateStatsCanUseToken(grcftwc.ClientID, grToken, false)
-			w.Write(cordicFailedResponse)
+			sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+			sim.write(w, cordicFailedResponse)
 			return
 		}
 
+		sim.step("passenger_id", "found", map[string]interface{}{"passenger_id": passengerID})
+
 		// get initial message
 		message := ""
 		if grcftwc.UseDatabaseMessage == 1 {
@@ -79,42 +138,42 @@ func CordicHandler() http.Handler {
 			message = strings.TrimSpace(req.FormValue(grcftwc.MessageParameter))
 		}
 
+		// the message of the booking language (when the config has one) replaces the message, the passenger
+		// identifier is not a telephone so only the booking locale gives the language
+		language, languageMessage := sim.messageLanguage(grcftwc, req.FormValue(localeParameter), "")
+		if language != "" {
+			message = languageMessage
+		}
+
+		// A/B test message variants of the language (when set up) replace the message, the variant sent (or the
+		// position of the multi message) is recorded with the message events and tracked short links
+		variant, variantMessage, variantChosen := messageVariant(grcftwc, language)
+		if variantChosen {
+			message = variantMessage
+		}
+
 		// check message is not empty
 		if message == "" {
 			log.Printf("no message sent in request or found in database for clientID: %d\n", grcftwc.ClientID)
+			sim.addMessageEvent(grcftwc.ClientID, passengerID, cordicChannel, database.ReasonNoMessage, "", 0)
 			// update stats
-			database.UpdateStatsCanUseToken(grcftwc.ClientID, grToken, false)
-			w.Write(cordicFailedResponse)
+			sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+			sim.write(w, cordicFailedResponse)
 			return
 		}
 
-		// check for multi message
-		if grcftwc.MultiMessageEnabled == 1 {
-			// split message by the separator
-			if message == "" {
-				log.Printf("no message found for clientID: %d\n", grcftwc.ClientID)
-				message = ""
-			}
-			sep := ""
-			if message != "" {
-				sep = strings.Trim(grcftwc.MultiMessageSeparator, " ")
-				if sep == "" {
-					log.Printf("no separator specified for multi message for clientID: %d\n", grcftwc.ClientID)
-					message = ""
-					sep = ""
-				}
-			}
-			if message != "" && sep != "" {
-				ms := strings.Split(message, sep)
-				r := rand.Intn(len(ms))
-				message = ms[r]
-			}
-			if message == "" {
-				log.Printf("no message found for multi message after randomising found message array for clientID: %d\n", grcftwc.ClientID)
-				message = ""
-			}
+		// choose one of the messages of a multi message
+		if grcftwc.MultiMessageEnabled == 1 && !variantChosen {
+			message, variant = multiMessage(grcftwc, message, language)
 		}
 
+		// fill in message template placeholders e.g. {first_name}
+		// review link is replaced by a tracked short link (when configured)
+		values := messageTemplateValues(req, grcftwc)
+		shortLinkID := sim.trackReviewLink(message, values, grcftwc.ClientID, variant)
+		message = utils.FillMessageTemplate(message, values)
+		sim.step("message", "built", map[string]interface{}{"message": message, "variant": variant})
+
 		// check whether should ignore dispatcher checks (used for testing on front end)
 		ignoreDispatcherChecks := strings.TrimSpace(req.FormValue("ignore_dispatcher_checks"))
 
@@ -124,25 +183,28 @@ func CordicHandler() http.Handler {
 			bookingCreationTime := strings.TrimSpace(req.FormValue(cordicBookingCreationTimeParameter))
 			if bookingCreationTime == "" {
 				log.Printf("no booking creation time parameter sent in request for clientID: %d\n", grcftwc.ClientID)
+				sim.step("dispatcher_check", "failed", map[string]interface{}{"missing_parameter": cordicBookingCreationTimeParameter})
 				// update stats
-				database.UpdateStatsCanUseToken(grcftwc.ClientID, grToken, false)
-				w.Write(cordicFailedResponse)
+				sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+				sim.write(w, cordicFailedResponse)
 				return
 			}
 			bookedForTime := strings.TrimSpace(req.FormValue(cordicBookedForTimeParameter))
 			if bookedForTime == "" {
 				log.Printf("no booked for time parameter sent in request for clientID: %d\n", grcftwc.ClientID)
+				sim.step("dispatcher_check", "failed", map[string]interface{}{"missing_parameter": cordicBookedForTimeParameter})
 				// update stats
-				database.UpdateStatsCanUseToken(grcftwc.ClientID, grToken, false)
-				w.Write(cordicFailedResponse)
+				sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+				sim.write(w, cordicFailedResponse)
 				return
 			}
 			pickedUpTime := strings.TrimSpace(req.FormValue(cordicPickedUpTimeParameter))
 			if pickedUpTime == "" {
 				log.Printf("no picked up time parameter sent in request for clientID: %d\n", grcftwc.ClientID)
+				sim.step("dispatcher_check", "failed", map[string]interface{}{"missing_parameter": cordicPickedUpTimeParameter})
 				// update stats
-				database.UpdateStatsCanUseToken(grcftwc.ClientID, grToken, false)
-				w.Write(cordicFailedResponse)
+				sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+				sim.write(w, cordicFailedResponse)
 				return
 			}
 
@@ -154,23 +216,29 @@ func CordicHandler() http.Handler {
 				dispatcherCheckPassed = utils.CheckDiffTimeRFC3339(bookedForTime, pickedUpTime, strconv.Itoa(int(grcftwc.PreBookingPickupToContactMinutes)))
 			}
 
+			sim.step("dispatcher_check", passedResult(dispatcherCheckPassed), map[string]interface{}{"booking_for_now": bookingForNow})
 			if !dispatcherCheckPassed {
 				// log.Printf("failed dispatcher test for clientID: %d, tripID: %s\n", clientID, tripID)
+				sim.addMessageEvent(grcftwc.ClientID, passengerID, cordicChannel, database.ReasonDispatcherCheckFailed, "", 0)
 				// update stats
-				database.UpdateStatsCanUseToken(grcftwc.ClientID, grToken, false)
-				w.Write(cordicFailedResponse)
+				sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+				sim.write(w, cordicFailedResponse)
 				return
 			}
+		} else {
+			sim.step("dispatcher_check", "ignored", nil)
 		}
 
 		lastSent, sentCount, stop, found := database.LastSentFromTelephoneAndClient(passengerID, grcftwc.ClientID)
 		// check whether should ignore passenger ID checks (used for testing on front end)
 		ignorePassengerIDChecks := strings.TrimSpace(req.FormValue(cordicIgnorePassengerIDChecksParameter))
+		sim.step("last_sent", checkedResult(ignorePassengerIDChecks != "1"), lastSentDetail(lastSent, sentCount, stop, found))
 		if ignorePassengerIDChecks != "1" {
 			// check if stop set (do not send)
 			if stop {
 				// log.Printf("stop on passenger ID: %s for clientID: %d\n", passengerID, clientID)
-				w.Write(cordicFailedResponse)
+				sim.addMessageEvent(grcftwc.ClientID, passengerID, cordicChannel, database.ReasonStopped, "", 0)
+				sim.write(w, cordicFailedResponse)
 				return
 			}
 			// check found record
@@ -178,30 +246,43 @@ func CordicHandler() http.Handler {
 				// check last sent greater than min send frequency
 				if lastSent.After(time.Now().AddDate(0, 0, int(-grcftwc.MinSendFrequency))) {
 					// log.Printf("Last sent too recent for passenger ID: %s for clientID: %d\n", passengerID, clientID)
+					sim.addMessageEvent(grcftwc.ClientID, passengerID, cordicChannel, database.ReasonTooRecent, "", 0)
 					// update stats
-					database.UpdateStatsCanUseToken(grcftwc.ClientID, grToken, false)
-					w.Write(cordicFailedResponse)
+					sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+					sim.write(w, cordicFailedResponse)
 					return
 				}
 				// check sent count
 				if int(sentCount) > int(grcftwc.MaxSendCount) {
 					// log.Printf("Reached maximum number of sends for passenger ID: %s for clientID: %d\n", passengerID, clientID)
+					sim.addMessageEvent(grcftwc.ClientID, passengerID, cordicChannel, database.ReasonMaxCount, "", 0)
 					// update stats
-					database.UpdateStatsCanUseToken(grcftwc.ClientID, grToken, false)
-					w.Write(cordicFailedResponse)
+					sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+					sim.write(w, cordicFailedResponse)
 					return
 				}
 			}
+			// check the review requests to the passenger across clients (fatigue policies), the passenger ID is
+			// recorded like a telephone
+			if sim.fatigueCapped(grcftwc, passengerID) {
+				sim.addMessageEvent(grcftwc.ClientID, passengerID, cordicChannel, database.ReasonFatigueCap, "", 0)
+				// update stats
+				sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+				sim.write(w, cordicFailedResponse)
+				return
+			}
 		}
 
 		// success
 		// update last sent in database
 		// log.Printf("updating last sent using passenger id for telephone: %s\n", passengerID)
-		database.UpdateLastSent(passengerID, grcftwc.ClientID, sentCount+1)
+		sim.updateLastSent(passengerID, grcftwc.ClientID, sentCount+1)
+		sim.setShortLinkMessageEvent(shortLinkID, sim.addMessageEventWithVariant(grcftwc.ClientID, passengerID, cordicChannel, database.ReasonSent, variant, "", 0))
+		bookingProcessed(w)
 		// update stats
-		database.UpdateStatsCanUseToken(grcftwc.ClientID, grToken, true)
+		sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, true)
 		// return the message to send
-		w.Write([]byte(`{"message":"` + message + `"}`))
+		sim.write(w, cordicMessageResponse(message))
 	}
 
 	return http.HandlerFunc(fn)
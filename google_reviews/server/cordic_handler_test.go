@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -548,3 +549,14 @@ func TestCordicHandlerFailsPickupTime(t *testing.T) {
 		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
 	}
 }
+
+func TestCordicMessageResponse(t *testing.T) {
+	var resp map[string]string
+	message := `Thanks Jane "J" O\'Neil","injected":"1`
+	if err := json.Unmarshal(cordicMessageResponse(message), &resp); err != nil {
+		t.Fatalf("response is not JSON: %s, err: %v", cordicMessageResponse(message), err)
+	}
+	if len(resp) != 1 || resp["message"] != message {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
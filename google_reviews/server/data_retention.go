@@ -0,0 +1,36 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"google_reviews/config"
+	"google_reviews/database"
+)
+
+// hashStoredTelephonesBatchSize - number of telephones stored before the telephone hash that are hashed at a time
+const hashStoredTelephonesBatchSize = 500
+
+// DataRetention - hash the telephones stored before the telephone hash with the telephone hash key, then apply
+// the data retention of the clients (see database.ApplyRetention) straight away and every period
+func DataRetention(period time.Duration) {
+	total := 0
+	for {
+		n := database.HashStoredTelephones(hashStoredTelephonesBatchSize)
+		total += n
+		if n == 0 {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("%d stored telephones hashed\n", total)
+	}
+
+	for {
+		if r := database.ApplyRetention(config.Conf.DataRetentionDays); r.Total() > 0 {
+			log.Printf("data retention applied, deleted %d last sents, %d email last sents and %d send laters,"+
+				" pseudonymised %d message events\n", r.LastSents, r.EmailLastSents, r.SendLaters, r.MessageEvents)
+		}
+		time.Sleep(period)
+	}
+}
@@ -28,8 +28,9 @@ type BookingInfo struct {
 	ContactDate string `json:"contact_date"`
 }
 
-// RetrieveBooking - get booking details from the dispatcher
-func RetrieveBooking(dispatcherURL string, dispatcherAppKey string, dispatcherSecretKey string, tripID string) string {
+// RetrieveBooking - get booking details from the dispatcher, the TLS certificate is not verified when
+// insecureSkipVerify is set (the config opts out)
+func RetrieveBooking(dispatcherURL string, dispatcherAppKey string, dispatcherSecretKey string, tripID string, insecureSkipVerify bool) string {
 	// add correct API call to dispatcherURL
 	dURL := dispatcherURL
 	if !strings.HasSuffix(dURL, "/") {
@@ -37,7 +38,7 @@ func RetrieveBooking(dispatcherURL string, dispatcherAppKey string, dispatcherSe
 	}
 	dURL += "bookings/index/" + tripID
 
-	resp := client.Send(dURL, "GET", dispatcherAppKey, dispatcherSecretKey, "", nil, nil, false, "", "")
+	resp := client.Send(dURL, "GET", dispatcherAppKey, dispatcherSecretKey, "", nil, nil, false, "", "", insecureSkipVerify)
 	// fmt.Println("resp: ", resp)
 
 	return resp
@@ -79,7 +80,7 @@ func CheckBooking(dispatcherResp string, tripID string, isBookingForNowDiffMinut
 // return true passed criteria else false
 func BookingOk(dispatcherURL string, dispatcherAppKey string, dispatcherSecretKey string, tripID string,
 	isBookingForNowDiffMinutes int, bookingNowPickupToContactMinutes int, preBookingPickupToContactMinutes int,
-	clientID uint64) bool {
+	clientID uint64, insecureSkipVerify bool) bool {
 	// add correct API call to dispatcherURL
 	dURL := dispatcherURL
 	if !strings.HasSuffix(dURL, "/") {
@@ -87,7 +88,7 @@ func BookingOk(dispatcherURL string, dispatcherAppKey string, dispatcherSecretKe
 	}
 	dURL += "bookings/index/" + tripID
 
-	resp := RetrieveBooking(dispatcherURL, dispatcherAppKey, dispatcherSecretKey, tripID)
+	resp := RetrieveBooking(dispatcherURL, dispatcherAppKey, dispatcherSecretKey, tripID, insecureSkipVerify)
 	// fmt.Println("resp: ", resp)
 
 	return CheckBooking(resp, tripID, isBookingForNowDiffMinutes, bookingNowPickupToContactMinutes, preBookingPickupToContactMinutes, clientID)
@@ -28,7 +28,7 @@ func TestBookingResponse(t *testing.T) {
 }
 
 func TestRetrieveBooking(t *testing.T) {
-	resp := RetrieveBooking(testIcabbiURL, testIcabbiAppKey, testIcabbiSecretKey, testIcabbiTripID)
+	resp := RetrieveBooking(testIcabbiURL, testIcabbiAppKey, testIcabbiSecretKey, testIcabbiTripID, false)
 	fmt.Println("resp: ", resp)
 }
 
@@ -81,11 +81,11 @@ func TestCheckBookingErrorUnmarshalling(t *testing.T) {
 }
 
 func TestBookingOkNow(t *testing.T) {
-	ok := BookingOk(testIcabbiURL, testIcabbiAppKey, testIcabbiSecretKey, testIcabbiTripID, 10, 10, 3, 1)
+	ok := BookingOk(testIcabbiURL, testIcabbiAppKey, testIcabbiSecretKey, testIcabbiTripID, 10, 10, 3, 1, false)
 	fmt.Println("ok: ", ok)
 }
 
 func TestBookingOkNowNoShow(t *testing.T) {
-	ok := BookingOk(testIcabbiURL, testIcabbiAppKey, testIcabbiSecretKey, testIcabbiNoShowTripID, 10, 10, 3, 1)
+	ok := BookingOk(testIcabbiURL, testIcabbiAppKey, testIcabbiSecretKey, testIcabbiNoShowTripID, 10, 10, 3, 1, false)
 	fmt.Println("ok: ", ok)
 }
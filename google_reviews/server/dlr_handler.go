@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"google_reviews/config"
+	"google_reviews/database"
+	"google_reviews/sender"
+)
+
+// messageMediaDeliveryStatuses - the Message Media delivery report statuses recorded (the final statuses), the others
+// (e.g. enroute, submitted) are acknowledged and ignored
+var messageMediaDeliveryStatuses = map[string]string{
+	"delivered":     database.DeliveryStatusDelivered,
+	"expired":       database.DeliveryStatusUndelivered,
+	"undeliverable": database.DeliveryStatusUndelivered,
+	"rejected":      database.DeliveryStatusFailed,
+	"failed":        database.DeliveryStatusFailed,
+}
+
+// reviewMasterSMSGatewayDeliveryStatuses - the Review Master SMS Gateway delivery report statuses recorded, the others
+// (e.g. queued, sent) are acknowledged and ignored
+var reviewMasterSMSGatewayDeliveryStatuses = map[string]string{
+	"delivered":   database.DeliveryStatusDelivered,
+	"undelivered": database.DeliveryStatusUndelivered,
+	"failed":      database.DeliveryStatusFailed,
+}
+
+// messageMediaDeliveryReport - delivery report received from Message Media
+// (see: https://support.messagemedia.com/hc/en-us/articles/4413627066383-Webhooks)
+// e.g. {"delivery_report_id":"...","message_id":"13d1a0ba-be11-401d-b4e8-7f27d8ccce99","status":"delivered","error_code":null}
+type messageMediaDeliveryReport struct {
+	DeliveryReportID string          `json:"delivery_report_id"`
+	MessageID        string          `json:"message_id"`
+	Status           string          `json:"status"`
+	ErrorCode        json.RawMessage `json:"error_code"`
+}
+
+// reviewMasterSMSGatewayDeliveryReport - delivery report received from the Review Master SMS Gateway, the id is the
+// id returned when sent e.g. {"id":76,"status":"failed","error":"Destination out of service"}
+type reviewMasterSMSGatewayDeliveryReport struct {
+	ID     json.Number `json:"id"`
+	Status string      `json:"status"`
+	Error  string      `json:"error"`
+}
+
+// recordDeliveryStatus - record the delivery status of the message sent via the channel, the message not being found
+// (e.g. sent before the message IDs were recorded) is logged and ignored
+func recordDeliveryStatus(channel string, messageID string, status string, detail string) {
+	clientID := database.UpdateDeliveryStatus(channel, messageID, status)
+	if clientID == 0 {
+		log.Printf("%s delivery report message ID: %s not found, status: %s\n", channel, messageID, status)
+		return
+	}
+	deliveryStatusTotal.Inc(channel, status)
+	if status != database.DeliveryStatusDelivered {
+		log.Printf("%s message ID: %s for clientID: %d %s, error: %s\n", channel, messageID, clientID, status, detail)
+	}
+}
+
+// MessageMediaDLRHandler - handle the Message Media delivery reports (the callback URL sent with each message), the
+// delivered, undelivered and failed statuses are recorded against the message event of the message sent.
+// For security require the DLR token (added to the callback URL) that should be sent and checked.
+// e.g. https://example.com/dlr/messagemedia?dlr_token=...
+func MessageMediaDLRHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+
+		dlrToken := req.URL.Query().Get(sender.MessageMediaDLRTokenParameter)
+		if config.Conf.MessageMediaDLRToken == "" ||
+			subtle.ConstantTimeCompare([]byte(dlrToken), []byte(config.Conf.MessageMediaDLRToken)) != 1 {
+			log.Printf("Error, Message Media delivery report token is incorrect\n")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(replyFailedResponse)
+			return
+		}
+		var dr messageMediaDeliveryReport
+		if err := json.NewDecoder(req.Body).Decode(&dr); err != nil {
+			log.Printf("Error decoding Message Media delivery report, error: %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(replyFailedResponse)
+			return
+		}
+		if status, ok := messageMediaDeliveryStatuses[strings.ToLower(strings.TrimSpace(dr.Status))]; ok {
+			recordDeliveryStatus(sender.MessageMedia, strings.TrimSpace(dr.MessageID), status, string(dr.ErrorCode))
+		}
+		w.Write(replySuccessResponse)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// ReviewMasterSMSGatewayDLRHandler - handle the delivery reports of the Review Master SMS Gateway, the delivered,
+// undelivered and failed statuses are recorded against the message event of the message sent.
+// For security require the Review Master SMS Gateway api token that should be sent and checked
+func ReviewMasterSMSGatewayDLRHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+
+		apiToken := req.Header.Get("api-token")
+		if len(apiToken) < 1 || apiToken != config.Conf.ReviewMasterSMSGatewayApiToken {
+			log.Printf("Error, delivery report api-token is incorrect\n")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(replyFailedResponse)
+			return
+		}
+		var dr reviewMasterSMSGatewayDeliveryReport
+		if err := json.NewDecoder(req.Body).Decode(&dr); err != nil {
+			log.Printf("Error decoding Review Master SMS Gateway delivery report, error: %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(replyFailedResponse)
+			return
+		}
+		if status, ok := reviewMasterSMSGatewayDeliveryStatuses[strings.ToLower(strings.TrimSpace(dr.Status))]; ok {
+			recordDeliveryStatus(sender.ReviewMasterSMSGateway, dr.ID.String(), status, dr.Error)
+		}
+		w.Write(replySuccessResponse)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google_reviews/config"
+	"google_reviews/database"
+)
+
+// messageEventDeliveryStatus - the delivery status of the message event
+func messageEventDeliveryStatus(t *testing.T, id int) string {
+	var status string
+	if err := database.Db.QueryRow("SELECT delivery_status FROM google_reviews_message_events WHERE id = ?", id).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestMessageMediaDLRHandler(t *testing.T) {
+	prepareTestDatabase()
+	config.Conf.MessageMediaDLRToken = "dlr123"
+	defer func() { config.Conf.MessageMediaDLRToken = "" }()
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		code   int
+		status string
+	}{
+		{"enroute is ignored", "dlr123", `{"message_id":"13d1a0ba-be11-401d-b4e8-7f27d8ccce99","status":"enroute"}`, http.StatusOK, ""},
+		{"incorrect token", "wrong", `{"message_id":"13d1a0ba-be11-401d-b4e8-7f27d8ccce99","status":"delivered"}`, http.StatusUnauthorized, ""},
+		{"invalid payload", "dlr123", `{"message_id":`, http.StatusBadRequest, ""},
+		{"expired", "dlr123", `{"message_id":"13d1a0ba-be11-401d-b4e8-7f27d8ccce99","status":"expired","error_code":null}`, http.StatusOK, database.DeliveryStatusUndelivered},
+		{"unknown message", "dlr123", `{"message_id":"unknown","status":"delivered"}`, http.StatusOK, database.DeliveryStatusUndelivered},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", "/dlr/messagemedia?dlr_token="+tt.token, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		MessageMediaDLRHandler().ServeHTTP(rr, req)
+		if rr.Code != tt.code {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.code)
+		}
+		if status := messageEventDeliveryStatus(t, 6); status != tt.status {
+			t.Errorf("%s: unexpected delivery status: %s want: %s", tt.name, status, tt.status)
+		}
+	}
+}
+
+func TestReviewMasterSMSGatewayDLRHandler(t *testing.T) {
+	prepareTestDatabase()
+	config.Conf.ReviewMasterSMSGatewayApiToken = "abc123"
+
+	tests := []struct {
+		name     string
+		apiToken string
+		body     string
+		code     int
+		status   string
+	}{
+		{"incorrect api token", "wrong", `{"id":76,"status":"delivered"}`, http.StatusUnauthorized, ""},
+		{"sent is ignored", "abc123", `{"id":76,"status":"sent"}`, http.StatusOK, ""},
+		{"delivered", "abc123", `{"id":76,"status":"delivered"}`, http.StatusOK, database.DeliveryStatusDelivered},
+		{"id as a string", "abc123", `{"id":"76","status":"failed","error":"Destination out of service"}`, http.StatusOK, database.DeliveryStatusFailed},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", "/dlr/rmsg", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("api-token", tt.apiToken)
+		rr := httptest.NewRecorder()
+		ReviewMasterSMSGatewayDLRHandler().ServeHTTP(rr, req)
+		if rr.Code != tt.code {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.code)
+		}
+		if status := messageEventDeliveryStatus(t, 7); status != tt.status {
+			t.Errorf("%s: unexpected delivery status: %s want: %s", tt.name, status, tt.status)
+		}
+	}
+}
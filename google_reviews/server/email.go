@@ -0,0 +1,116 @@
+package server
+
+import (
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google_reviews/config"
+	"google_reviews/database"
+	"google_reviews/email"
+)
+
+// emailService - the email service used to send the emails (replaced in tests)
+var emailService = email.New
+
+// emailAddress - the email address to email when there is no telephone and the config has email enabled, empty
+// when none or not a valid email address
+func emailAddress(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, address string) string {
+	if !grcftwc.EmailEnabled {
+		return ""
+	}
+	return email.ValidAddress(address)
+}
+
+// updateEmailLastSent - update the email last sent (not updated when simulating)
+func (sim *simulation) updateEmailLastSent(address string, clientID uint64, sentCount uint) {
+	if sim == nil {
+		database.UpdateEmailLastSent(address, clientID, sentCount)
+	}
+}
+
+// sendEmail - email the message to the address using the email template and subject of the config, the message
+// events are recorded with the email channel. When simulating the email is added to the trace instead of being sent.
+//
+// NOTE: Emails are sent straight away (the send delay of the config is not used).
+func (sim *simulation) sendEmail(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, grToken string, address string,
+	message string, values map[string]string, variant string, shortLinkID uint64, sentCount uint) bool {
+	unsubscribeLink := email.UnsubscribeLink(grcftwc.ClientID, address)
+	body, text := email.Render(grcftwc.EmailTemplate, message, values, unsubscribeLink)
+	e := email.Email{
+		FromAddress:     config.Conf.EmailFromAddress,
+		FromName:        config.Conf.EmailFromName,
+		To:              address,
+		Subject:         email.Subject(grcftwc.EmailSubject, values),
+		HTML:            body,
+		Text:            text,
+		UnsubscribeLink: unsubscribeLink,
+	}
+	if sim != nil {
+		sim.step("send", "not_sent", map[string]interface{}{"provider": email.Channel, "to": e.To, "subject": e.Subject, "html": e.HTML, "text": e.Text})
+		sim.addMessageEventWithVariant(grcftwc.ClientID, address, email.Channel, database.ReasonSent, variant, "", 0)
+		return true
+	}
+
+	s := emailService()
+	if s == nil {
+		log.Printf("no email service set up to email clientID: %d\n", grcftwc.ClientID)
+		sim.addMessageEvent(grcftwc.ClientID, address, email.Channel, database.ReasonProviderError, "no email service", 0)
+		sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+		return false
+	}
+	start := time.Now()
+	messageID, err := s.Send(e)
+	latency := time.Since(start)
+	if err != nil {
+		log.Printf("Error emailing clientID: %d via %s, err: %v\n", grcftwc.ClientID, s.Name(), err)
+		sim.addMessageEvent(grcftwc.ClientID, address, email.Channel, database.ReasonProviderError, err.Error(), latency)
+		sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+		return false
+	}
+	sim.updateEmailLastSent(address, grcftwc.ClientID, sentCount+1)
+	sim.setShortLinkMessageEvent(shortLinkID, sim.addMessageEventWithVariant(grcftwc.ClientID, address, email.Channel, database.ReasonSent, variant, messageID, latency))
+	sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, true)
+	return true
+}
+
+// EmailUnsubscribeHandler - stop the client emailing the email address, the link is signed (see email.UnsubscribeLink).
+// A GET shows a page to confirm (so the link is not followed by link scanners), the email address is unsubscribed
+// when POSTed (also the one click unsubscribe of the List-Unsubscribe-Post header).
+func EmailUnsubscribeHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		clientID, _ := strconv.ParseUint(q.Get("c"), 10, 64)
+		address := email.ValidAddress(q.Get("e"))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if !email.VerifyUnsubscribe(clientID, address, q.Get("s")) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(unsubscribePage("This unsubscribe link is not valid.", false))
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			w.Write(unsubscribePage("Unsubscribe "+address+"?", true))
+		case http.MethodPost:
+			database.StopSendingEmail(address, clientID)
+			database.AddOptOutMessageEvent(clientID, address, email.Channel)
+			w.Write(unsubscribePage(address+" has been unsubscribed, you will not be emailed again.", false))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// unsubscribePage - simple page with the text, with a button to confirm (POST to the same URL) when confirm is set
+func unsubscribePage(text string, confirm bool) []byte {
+	form := ""
+	if confirm {
+		form = "<form method=\"post\"><button type=\"submit\">Unsubscribe</button></form>"
+	}
+	return []byte("<!DOCTYPE html><html><head><meta name=\"viewport\" content=\"width=device-width, initial-scale=1\"><title>Unsubscribe</title></head>" +
+		"<body style=\"font-family: Arial, Helvetica, sans-serif;\"><p>" + html.EscapeString(text) + "</p>" + form + "</body></html>")
+}
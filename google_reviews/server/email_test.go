@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google_reviews/config"
+	"google_reviews/database"
+	"google_reviews/email"
+)
+
+const testEmailToken = "mail3Fh8Wq1Zn6Tb9Kc4Vx7Dp2Ls5Jr0"
+
+// testEmailService - email service recording the emails sent
+type testEmailService struct {
+	sent []email.Email
+	err  error
+}
+
+func (s *testEmailService) Name() string {
+	return "test"
+}
+
+func (s *testEmailService) Send(e email.Email) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	s.sent = append(s.sent, e)
+	return "<test-message-id@example.com>", nil
+}
+
+// useTestEmailService - replace the email service until the returned func is called
+func useTestEmailService(s *testEmailService) func() {
+	emailService = func() email.Service { return s }
+	return func() { emailService = email.New }
+}
+
+// emailRequest - post the booking to the google reviews handler
+func emailRequest(t *testing.T, form url.Values) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/googlereviews", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	GoogleReviewsHandler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGoogleReviewsHandlerEmail(t *testing.T) {
+	prepareTestDatabase()
+	s := &testEmailService{}
+	defer useTestEmailService(s)()
+
+	form := url.Values{"gr_token": {testEmailToken}, "t": {""}, "email": {"New.Passenger@Example.com"}, "first_name": {"Jane"}}
+	if rr := emailRequest(t, form); rr.Body.String() != `{"success":"1"}` {
+		t.Fatalf("unexpected response: %s", rr.Body.String())
+	}
+	if len(s.sent) != 1 {
+		t.Fatalf("expected 1 email sent got: %d", len(s.sent))
+	}
+	e := s.sent[0]
+	if e.To != "new.passenger@example.com" || e.Subject != "How was your journey Jane?" ||
+		!strings.Contains(e.HTML, "Thanks Jane for travelling with us") || !strings.Contains(e.Text, "https://g.page/r/taxico/review") {
+		t.Errorf("unexpected email: %+v", e)
+	}
+	if _, sentCount, _, found := database.LastSentFromEmailAndClient("new.passenger@example.com", 12); !found || sentCount != 1 {
+		t.Errorf("unexpected email last sent, sent count: %d, found: %t", sentCount, found)
+	}
+
+	// stopped (unsubscribed) and too recent are not emailed
+	for _, address := range []string{"john@example.com", "recent@example.com", "new.passenger@example.com"} {
+		form.Set("email", address)
+		emailRequest(t, form)
+	}
+	if len(s.sent) != 1 {
+		t.Errorf("expected no more emails sent got: %d", len(s.sent)-1)
+	}
+
+	// an invalid email address is not emailed
+	form.Set("email", "not an email")
+	if rr := emailRequest(t, form); rr.Body.String() != string(failedResponse) {
+		t.Errorf("unexpected response: %s", rr.Body.String())
+	}
+
+	// not sent by the email service
+	s.err = errors.New("connection refused")
+	form.Set("email", "jane@example.com")
+	if rr := emailRequest(t, form); rr.Body.String() != string(failedResponse) {
+		t.Errorf("unexpected response: %s", rr.Body.String())
+	}
+}
+
+func TestEmailUnsubscribeHandler(t *testing.T) {
+	prepareTestDatabase()
+	config.Conf.EmailUnsubscribeBaseURL = "https://reviews.example.com"
+	config.Conf.EmailUnsubscribeSecret = "test-unsubscribe-secret"
+	defer func() {
+		config.Conf.EmailUnsubscribeBaseURL = ""
+		config.Conf.EmailUnsubscribeSecret = ""
+	}()
+	link, err := url.Parse(email.UnsubscribeLink(12, "jane@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsubscribe := func(method string, rawQuery string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, email.UnsubscribePath+"?"+rawQuery, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		EmailUnsubscribeHandler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// confirmed before unsubscribing
+	if rr := unsubscribe("GET", link.RawQuery); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "<form method=\"post\">") {
+		t.Fatalf("unexpected response: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, _, stop, _ := database.LastSentFromEmailAndClient("jane@example.com", 12); stop {
+		t.Fatal("unsubscribed before confirming")
+	}
+	if rr := unsubscribe("POST", link.RawQuery); rr.Code != http.StatusOK {
+		t.Fatalf("unexpected response: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, _, stop, _ := database.LastSentFromEmailAndClient("jane@example.com", 12); !stop {
+		t.Fatal("expected the email address to be unsubscribed")
+	}
+
+	// changed link
+	q := link.Query()
+	q.Set("e", "john@example.com")
+	if rr := unsubscribe("POST", q.Encode()); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request got: %d", rr.Code)
+	}
+}
@@ -0,0 +1,17 @@
+package server
+
+import (
+	"google_reviews/database"
+)
+
+// fatigueCapped - check whether a review request to the telephone would exceed a fatigue policy (the review requests
+// to a passenger across clients, see database.FatigueCapped)
+func (sim *simulation) fatigueCapped(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, telephone string) bool {
+	policy, capped := database.FatigueCapped(telephone, grcftwc.FatiguePolicies)
+	if !capped {
+		sim.step("fatigue", "not_capped", nil)
+		return false
+	}
+	sim.step("fatigue", "capped", map[string]interface{}{"partner_id": policy.PartnerID, "max_requests": policy.MaxRequests, "days": policy.Days})
+	return true
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google_reviews/database"
+	"google_reviews/hook"
+)
+
+// hookPath - generic dispatcher webhook, the config token is in the path e.g. /hook/<token> (/hook/<token>/simulate
+// to simulate the request)
+const hookPath = "/hook/"
+
+// hookChannel - channel recorded in message events when the passenger ID is used (the message is returned to the
+// dispatcher which sends it)
+const hookChannel = "HOOK"
+
+// hookMobileAppBookingSource - booking source of mobile app bookings (see BookingSourceMobileAppState)
+const hookMobileAppBookingSource = "MobileApp"
+
+// hookSuccessResponse - response when the message has been sent (or will be sent later)
+var hookSuccessResponse = []byte(`{"success":"1"}`)
+
+// HookHandler - generic dispatcher webhook handler, the booking fields are extracted from the payload (JSON or form)
+// using the mapping of the config (see the hook package)
+func HookHandler() http.Handler {
+	return hookHandler(false)
+}
+
+// HookSimulateHandler - simulate a request to the generic dispatcher webhook handler returning a trace of each step
+// (see simulation), nothing is sent or written to the database
+func HookSimulateHandler() http.Handler {
+	return hookHandler(true)
+}
+
+// hookRouter - route /hook/<token> and /hook/<token>/simulate
+func hookRouter(handler http.Handler, simulateHandler http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, simulatePath) {
+			simulateHandler.ServeHTTP(w, req)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// hookToken - the config token from the path e.g. /hook/<token> or /hook/<token>/simulate
+func hookToken(p string) string {
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(p, hookPath), simulatePath))
+}
+
+// companyAllowed - check the company is one of the configured companies (a list of company IDs), when no companies
+// are configured all companies are allowed
+func companyAllowed(companies string, company string) bool {
+	configured := false
+	for _, c := range strings.Split(companies, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil {
+			continue
+		}
+		configured = true
+		if strconv.Itoa(id) == strings.TrimSpace(company) {
+			return true
+		}
+	}
+	return !configured
+}
+
+// bookingSourceAllowed - check the booking source against the booking source mobile app state of the config
+// (-1 ignore, 0 not mobile app bookings, 1 mobile app bookings)
+func bookingSourceAllowed(bookingSourceMobileAppState int, bookingSource string) bool {
+	mobileApp := strings.EqualFold(bookingSource, hookMobileAppBookingSource)
+	switch bookingSourceMobileAppState {
+	case 0:
+		return !mobileApp
+	case 1:
+		return mobileApp
+	}
+	return true
+}
+
+// hookMessageResponse - response returning the message to the dispatcher (when the passenger ID is used)
+func hookMessageResponse(message string) []byte {
+	resp, err := json.Marshal(map[string]string{"success": "1", "message": message})
+	if err != nil {
+		log.Printf("Error marshalling hook message response, err: %v\n", err)
+		return failedResponse
+	}
+	return resp
+}
+
+// hookHandler - generic dispatcher webhook handler, simulating the request when simulate is set (see sendReviewRequest)
+func hookHandler(simulate bool) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		sim := newSimulation(simulate)
+		// the body is read first so the signature of a signed request can be verified and the fields extracted
+		body := readBody(req)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+
+		// the form is parsed for form payloads and the query parameters (e.g. ignore_telephone_checks)
+		if err := req.ParseForm(); err != nil {
+			log.Printf("ParseForm() err: %v\n", err)
+			sim.write(w, failedResponse)
+			return
+		}
+
+		grToken := hookToken(req.URL.Path)
+		// verify the request (allowed IPs and signature) when set up for the config
+		if !sim.verifyRequest(req, body, "/hook", grToken) {
+			sim.writeStatus(w, http.StatusUnauthorized, failedResponse)
+			return
+		}
+
+		// extract the booking fields using the mapping of the config
+		clientID, hookMapping := database.HookMappingFromToken(grToken)
+		if clientID == 0 || hookMapping == "" {
+			log.Printf("no hook mapping found for token: %s\n", grToken)
+			sim.step("hook_mapping", "not_found", nil)
+			sim.updateStatsCanUseToken(0, grToken, false)
+			sim.write(w, failedResponse)
+			return
+		}
+		mapping, err := hook.ParseMapping(hookMapping)
+		if err != nil {
+			log.Printf("Error parsing hook mapping for clientID: %d, err: %v\n", clientID, err)
+			sim.step("hook_mapping", "invalid", map[string]interface{}{"error": err.Error()})
+			sim.write(w, failedResponse)
+			return
+		}
+		fields, err := hook.Extract(mapping, body, req.Form, hook.IsJSON(req.Header.Get("Content-Type"), body))
+		if err != nil {
+			log.Printf("Error extracting hook fields for clientID: %d, err: %v\n", clientID, err)
+			sim.step("hook_fields", "invalid", map[string]interface{}{"error": err.Error()})
+			sim.write(w, failedResponse)
+			return
+		}
+		sim.step("hook_fields", "extracted", fields)
+
+		// a repeated delivery of a booking (dispatcher retries) returns the original response without processing it again
+		bw, claimed := sim.claimBooking(w, clientID, fields[hook.FieldBookingID], failedResponse)
+		if !claimed {
+			return
+		}
+		defer bw.complete()
+		w = bw
+
+		// check whether should ignore the time and daily sent count checks (used for testing on front end)
+		ignoreTimeAndSentCountCheck := strings.TrimSpace(req.FormValue("ignore_time_and_sent_count_checks")) == "1"
+		grcftwc, found := sim.reviewConfig(w, grToken, ignoreTimeAndSentCountCheck, func(string) string {
+			return fields[hook.FieldTelephone]
+		}, failedResponse)
+		if !found {
+			return
+		}
+
+		// check the company and booking source
+		if !companyAllowed(grcftwc.Companies, fields[hook.FieldCompany]) {
+			sim.step("company", "not_allowed", map[string]interface{}{"company": fields[hook.FieldCompany], "companies": grcftwc.Companies})
+			// update stats
+			sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+			sim.write(w, failedResponse)
+			return
+		}
+		if !bookingSourceAllowed(grcftwc.BookingSourceMobileAppState, fields[hook.FieldBookingSource]) {
+			sim.step("booking_source", "not_allowed", map[string]interface{}{"booking_source": fields[hook.FieldBookingSource],
+				"booking_source_mobile_app_state": grcftwc.BookingSourceMobileAppState})
+			// update stats
+			sim.updateStatsCanUseToken(grcftwc.ClientID, grToken, false)
+			sim.write(w, failedResponse)
+			return
+		}
+
+		rr := reviewRequest{
+			grToken:                     grToken,
+			grcftwc:                     grcftwc,
+			fields:                      fields,
+			ignoreTimeAndSentCountCheck: ignoreTimeAndSentCountCheck,
+			ignoreTelephoneChecks:       strings.TrimSpace(req.FormValue("ignore_telephone_checks")) == "1",
+			ignoreDispatcherChecks:      strings.TrimSpace(req.FormValue("ignore_dispatcher_checks")) == "1",
+			successResponse:             hookSuccessResponse,
+			skippedResponse:             failedResponse,
+			failedResponse:              failedResponse,
+		}
+		// dispatcher checks using the booking times (when mapped), the pick up has to be soon after the booked for time
+		_, createdMapped := mapping[hook.FieldBookingCreated]
+		_, bookedForMapped := mapping[hook.FieldBookedFor]
+		_, pickedUpMapped := mapping[hook.FieldPickedUp]
+		if createdMapped && bookedForMapped && pickedUpMapped {
+			rr.dispatcherCheck = func() bool {
+				return sim.bookingTimesCheck(grcftwc, fields)
+			}
+		}
+		sim.sendReviewRequest(w, req, rr)
+	}
+
+	return http.HandlerFunc(fn)
+}
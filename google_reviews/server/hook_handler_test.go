@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google_reviews/database"
+)
+
+const testHookToken = "OYBpBsZ9OhR-nbsupMQU_hCTJuaabtZ1gsfsfp"
+
+// hookRequest - send the JSON payload to the hook handler
+func hookRequest(t *testing.T, handler http.Handler, p string, payload string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", p, strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHookSimulateHandler(t *testing.T) {
+	prepareTestDatabase()
+
+	payload := `{"id":"H1","passenger":{"id":"P1","phone":"07123456789","first_name":"Jane"}}`
+	rr := hookRequest(t, hookRouter(HookHandler(), HookSimulateHandler()), hookPath+testHookToken+simulatePath+
+		"?ignore_time_and_sent_count_checks=1&ignore_telephone_checks=1", payload)
+	var sim simulation
+	if err := json.Unmarshal(rr.Body.Bytes(), &sim); err != nil || !sim.Simulate {
+		t.Fatalf("handler returned unexpected body: %s, err: %v", rr.Body.String(), err)
+	}
+	s, ok := simulationStep(sim, "hook_fields")
+	if !ok || s.Result != "extracted" {
+		t.Fatalf("expected the hook fields to be extracted: %+v", sim.Steps)
+	}
+	if s, ok := simulationStep(sim, "telephone"); !ok || s.Result != "normalised" {
+		t.Errorf("expected the mapped telephone to be used: %+v", sim.Steps)
+	}
+	if s, ok := simulationStep(sim, "dispatcher_check"); !ok || s.Result != "not_enabled" {
+		t.Errorf("expected the dispatcher check not enabled without mapped times: %+v", sim.Steps)
+	}
+}
+
+func TestHookHandlerInvalid(t *testing.T) {
+	prepareTestDatabase()
+
+	tests := []struct {
+		path    string
+		payload string
+	}{
+		// unknown token
+		{hookPath + "unknown", `{"passenger":{"phone":"07123456789"}}`},
+		// invalid JSON
+		{hookPath + testHookToken, `{"passenger":`},
+		// no telephone or passenger ID
+		{hookPath + testHookToken, `{"id":"H2"}`},
+	}
+	for _, tt := range tests {
+		rr := hookRequest(t, HookHandler(), tt.path, tt.payload)
+		if rr.Body.String() != string(failedResponse) {
+			t.Errorf("path: %s, payload: %s, expected failed response got: %s", tt.path, tt.payload, rr.Body.String())
+		}
+	}
+}
+
+func TestHookHandlerProcessedBooking(t *testing.T) {
+	prepareTestDatabase()
+	database.EnableProcessedBookings(time.Hour, time.Minute)
+	defer database.EnableProcessedBookings(0, 0)
+
+	// booking 1001 of client 12 has already been processed, the original response is returned
+	rr := hookRequest(t, HookHandler(), hookPath+testHookToken, `{"id":1001,"passenger":{"phone":"07123456789"}}`)
+	if rr.Body.String() != `{"success":"1"}` {
+		t.Errorf("expected the original response got: %s", rr.Body.String())
+	}
+}
+
+func TestHookHelpers(t *testing.T) {
+	if hookToken(hookPath+"abc"+simulatePath) != "abc" || hookToken(hookPath+"abc") != "abc" {
+		t.Error("unexpected hook token")
+	}
+	if !companyAllowed("", "3") || !companyAllowed("1, 3", "3") || companyAllowed("1,2", "3") {
+		t.Error("unexpected company allowed")
+	}
+	if !bookingSourceAllowed(-1, "MobileApp") || bookingSourceAllowed(0, "mobileapp") || !bookingSourceAllowed(1, "MobileApp") ||
+		bookingSourceAllowed(1, "Phone") {
+		t.Error("unexpected booking source allowed")
+	}
+}
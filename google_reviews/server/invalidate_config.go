@@ -0,0 +1,31 @@
+package server
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"google_reviews/database"
+)
+
+// InvalidateConfigHandler - remove a config token from the config cache, called by google_reviews_ui when a config
+// is updated, the token is optional and all the configs are removed when it isn't given.
+// For security require the log token (the same as used for checking the logs).
+// e.g. /invalidateconfig?log_token=<log token>&token=<config token>
+func InvalidateConfigHandler(logTk string) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		logToken := strings.TrimSpace(req.URL.Query().Get("log_token"))
+		if logToken == "" || subtle.ConstantTimeCompare([]byte(logToken), []byte(logTk)) != 1 {
+			log.Printf("Error, log_token %s is incorrect for invalidating the config cache\n", logToken)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		token := req.URL.Query().Get("token")
+		database.InvalidateConfig(token)
+		log.Printf("config cache invalidated for token: %q\n", token)
+		w.Write([]byte("OK"))
+	}
+
+	return http.HandlerFunc(fn)
+}
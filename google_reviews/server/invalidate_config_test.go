@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"google_reviews/database"
+)
+
+func TestInvalidateConfigHandler(t *testing.T) {
+	prepareTestDatabase()
+	database.EnableConfigCache(time.Minute, time.Minute)
+	defer database.EnableConfigCache(0, 0)
+
+	token := "QxrH0iJc3wv/lj/YKVppNYRad7tN0Z3x"
+	if grcftwc := database.ConfigFromTokenWithChecks(token, true); grcftwc.ClientID == 0 {
+		t.Fatal("token ", token, " not found")
+	}
+	if _, err := database.Db.Exec("UPDATE google_reviews_configs SET enabled = 0 WHERE token = ?", token); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		logToken string
+		status   int
+	}{
+		{"rubbishToken", http.StatusUnauthorized},
+		{testLogToken, http.StatusOK},
+	} {
+		req, err := http.NewRequest("GET", "/invalidateconfig?log_token="+url.QueryEscape(tc.logToken)+"&token="+url.QueryEscape(token), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		InvalidateConfigHandler(testLogToken).ServeHTTP(rr, req)
+		if status := rr.Code; status != tc.status {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, tc.status)
+		}
+		// the config is cached until invalidated
+		grcftwc := database.ConfigFromTokenWithChecks(token, true)
+		if tc.status != http.StatusOK && grcftwc.ClientID == 0 {
+			t.Fatal("config should still be cached")
+		}
+		if tc.status == http.StatusOK && grcftwc.ClientID != 0 {
+			t.Fatal("config should have been invalidated")
+		}
+	}
+}
@@ -1,15 +1,16 @@
 package server
 
 import (
-	"bufio"
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"google_reviews/logging"
 )
 
 // ErrorResult - represents an error result.
@@ -51,80 +52,61 @@ func CheckLogHandler(logFileName, logTk string) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-// CheckLog - check log file
+// sendErrorRegex - free text send error lines logged before structured logging
+var sendErrorRegex = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} Error sending message for clientID: .*`)
+
+// CheckLog - check the log file and its rotated backups for send errors in the last hours back
 func CheckLog(logFileName string, hoursBack int) []ErrorResult {
 	var errorResult []ErrorResult
 	type errFreqValue struct {
 		Freq     int
 		LastTime time.Time
 	}
-	var sendErrFreq = make(map[string]errFreqValue)
-	// fmt.Fprintln(w, "Logs")
-	// fmt.Fprintf(w, "logFileName: %s\n", logFileName)
-	f, err := os.Open(logFileName) // For read access.
-	if err != nil {
-		log.Printf("Error, opening log file: %s to view logs with error: %v\n", logFileName, err)
-		return errorResult
+	var sendErrFreq = make(map[int]errFreqValue)
+	addSendError := func(clientID int, t time.Time) {
+		v := sendErrFreq[clientID]
+		v.Freq++
+		if t.After(v.LastTime) {
+			v.LastTime = t
+		}
+		sendErrFreq[clientID] = v
 	}
-	defer f.Close()
 
 	// check time after
 	checkAfter := time.Now().Add(-time.Duration(hoursBack) * time.Hour)
-	scanner := bufio.NewScanner(f)
-	re, _ := regexp.Compile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} Error sending message for clientID: .*`)
-	for scanner.Scan() {
-		txt := scanner.Text()
+	err := logging.ScanLogFiles(logFileName, checkAfter, func(txt string) {
+		if r, ok := logging.ParseRecord(txt); ok {
+			if r.Event == logging.EventSendError && r.Time.After(checkAfter) {
+				addSendError(int(r.ClientID), r.Time)
+			}
+			return
+		}
 		// log format starts with time
 		// get required message relating to failure to send message
-		// m, err := regexp.MatchString("^\\d{4}/\\d{2}/\\d{2} \\d{2}:\\d{2}:\\d{2} Error sending message for clientID: .*", txt)
-		// if err != nil {
-		// 	// log.Println(err)
-		// 	continue
-		// }
-		m := re.MatchString(txt)
-		if m {
-			// check date
-			ta := strings.Split(txt, " ")
-			t, err := time.Parse("2006/01/02 15:04:05", ta[0]+" "+ta[1])
-			if err != nil {
-				continue
-			}
-			// fmt.Println(t)
-			if t.After(checkAfter) {
-				// fmt.Println(ta[7])
-				c := strings.Split(txt, "clientID:")
-				// fmt.Println(c[1])
-				ci := strings.Split(strings.Trim(c[1], " "), " ")
-				// fmt.Println(ci[0])
-				clientID := ci[0]
-				// v, _ := sendErrFreq[clientID]
-				v := sendErrFreq[clientID]
-				sendErrFreq[clientID] = errFreqValue{Freq: v.Freq + 1, LastTime: t}
-			}
+		if !sendErrorRegex.MatchString(txt) {
+			return
 		}
-	}
-
-	// for cid, v := range sendErrFreq {
-	// 	l := fmt.Sprintf("{\"client_id\":\"%s\",\"frequency\":\"%d\",\"last_error\":\"%s\"},", cid, v.Freq, v.LastTime.Format("2006/01/02 15:04:05"))
-	// 	returnTxt = returnTxt + l
-	// }
-	// if len(returnTxt) > 0 {
-	// 	returnTxt = "[" + strings.TrimSuffix(returnTxt, ",") + "]"
-	// }
-	for cid, v := range sendErrFreq {
-		var e ErrorResult
-		i, err := strconv.Atoi(cid)
+		// check date
+		ta := strings.Split(txt, " ")
+		t, err := time.ParseInLocation("2006/01/02 15:04:05", ta[0]+" "+ta[1], time.Local)
+		if err != nil || !t.After(checkAfter) {
+			return
+		}
+		c := strings.Split(txt, "clientID:")
+		ci := strings.Split(strings.Trim(c[1], " "), " ")
+		clientID, err := strconv.Atoi(ci[0])
 		if err != nil {
-			continue
+			return
 		}
-		e.ClientID = i
-		e.Frequency = v.Freq
-		e.LastError = v.LastTime
-		errorResult = append(errorResult, e)
+		addSendError(clientID, t)
+	})
+	if err != nil {
+		log.Printf("Error, reading log file: %s to check logs with error: %v\n", logFileName, err)
 	}
 
-	if err = scanner.Err(); err != nil {
-		log.Printf("Error, reading log file: %s to check logs with error: %v\n", logFileName, err)
+	for cid, v := range sendErrFreq {
+		errorResult = append(errorResult, ErrorResult{ClientID: cid, Frequency: v.Freq, LastError: v.LastTime})
 	}
+	sort.Slice(errorResult, func(i, j int) bool { return errorResult[i].ClientID < errorResult[j].ClientID })
 	return errorResult
 }
@@ -5,7 +5,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCheckLogHandler1(t *testing.T) {
@@ -71,3 +75,40 @@ func TestCheckLog1(t *testing.T) {
 	fmt.Println(logs)
 	// server.CheckLog("../google_reviews.log", 240)
 }
+
+func TestCheckLogStructured(t *testing.T) {
+	dir := t.TempDir()
+	logFileName := filepath.Join(dir, "google_reviews.log")
+	now := time.Now()
+	record := func(tm time.Time, event string, clientID int) string {
+		return fmt.Sprintf(`{"time":"%s","level":"ERROR","msg":"Error sending message","request_id":"abc","event":"%s","client_id":%d,"reason":"provider_error"}`,
+			tm.Format(time.RFC3339Nano), event, clientID)
+	}
+	// rotated backup with a send error written before structured logging
+	backup := filepath.Join(dir, "google_reviews-2021-09-01T10-00-00.000.log")
+	legacy := now.Add(-3*time.Hour).Format("2006/01/02 15:04:05") + " Error sending message for clientID: 7 to https://example.com with params: map[], response from send server: x"
+	if err := os.WriteFile(backup, []byte(legacy+"\n"+record(now.Add(-2*time.Hour), "send_error", 3)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(backup, now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	lines := []string{
+		record(now.Add(-48*time.Hour), "send_error", 3), // too old
+		record(now.Add(-time.Hour), "request", 3),       // not a send error
+		record(now.Add(-time.Hour), "send_error", 3),
+		"not a log record",
+	}
+	if err := os.WriteFile(logFileName, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := CheckLog(logFileName, 24)
+	if len(logs) != 2 {
+		t.Fatalf("expected errors for 2 clients got: %+v", logs)
+	}
+	if logs[0].ClientID != 3 || logs[0].Frequency != 2 || logs[0].LastError.Unix() != now.Add(-time.Hour).Unix() {
+		t.Errorf("unexpected structured send errors: %+v", logs[0])
+	}
+	if logs[1].ClientID != 7 || logs[1].Frequency != 1 {
+		t.Errorf("unexpected legacy send errors: %+v", logs[1])
+	}
+}
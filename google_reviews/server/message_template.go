@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"google_reviews/database"
+	"google_reviews/utils"
+)
+
+// messageTemplatePlaceholders - placeholders that can be passed as request parameters using the placeholder name e.g. first_name=Jane
+var messageTemplatePlaceholders = []string{
+	utils.PlaceholderFirstName,
+	utils.PlaceholderDriverName,
+	utils.PlaceholderPickupTime,
+	utils.PlaceholderCompany,
+	utils.PlaceholderReviewLink,
+	utils.PlaceholderOptOutLink,
+}
+
+// messageTemplateValues - get the message template placeholder values from the request parameters,
+// the review and opt out links set in the config take precedence over the request
+func messageTemplateValues(req *http.Request, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) map[string]string {
+	values := make(map[string]string, len(messageTemplatePlaceholders))
+	for _, p := range messageTemplatePlaceholders {
+		values[p] = strings.TrimSpace(req.FormValue(p))
+	}
+	if grcftwc.ReviewLink != "" {
+		values[utils.PlaceholderReviewLink] = grcftwc.ReviewLink
+	}
+	if grcftwc.OptOutLink != "" {
+		values[utils.PlaceholderOptOutLink] = grcftwc.OptOutLink
+	}
+	return values
+}
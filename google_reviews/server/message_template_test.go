@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google_reviews/database"
+	"google_reviews/utils"
+)
+
+func TestMessageTemplateValues(t *testing.T) {
+	form := url.Values{}
+	form.Add("first_name", " Jane ")
+	form.Add("review_link", "https://example.com/review")
+	form.Add("opt_out_link", "https://example.com/stop")
+	req, err := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	grcftwc := database.GoogleReviewsConfigFromTokenWithChecks{ReviewLink: "https://g.page/r/test/review"}
+	values := messageTemplateValues(req, grcftwc)
+	if values[utils.PlaceholderFirstName] != "Jane" {
+		t.Errorf("first_name = %q expected %q", values[utils.PlaceholderFirstName], "Jane")
+	}
+	if values[utils.PlaceholderReviewLink] != "https://g.page/r/test/review" {
+		t.Errorf("review_link = %q expected config review link", values[utils.PlaceholderReviewLink])
+	}
+	if values[utils.PlaceholderOptOutLink] != "https://example.com/stop" {
+		t.Errorf("opt_out_link = %q expected request opt out link", values[utils.PlaceholderOptOutLink])
+	}
+	if values[utils.PlaceholderDriverName] != "" {
+		t.Errorf("driver_name = %q expected empty", values[utils.PlaceholderDriverName])
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"google_reviews/database"
+	"google_reviews/utils"
+)
+
+// localeParameter - parameter of the booking locale (e.g. nl-BE) sent by the dispatcher, see messageLanguage
+const localeParameter = "locale"
+
+// messageLanguage - the language of the booking when the config has a message for it, the language of the booking
+// locale when the dispatcher sends one else of the country of the telephone. Returns the language and its message
+// which replaces the config message, an empty language when the config message is used.
+func (sim *simulation) messageLanguage(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, locale string, telephone string) (string, string) {
+	messages := grcftwc.Messages
+	if len(messages) == 0 {
+		return "", ""
+	}
+	language, source := utils.LanguageFromLocale(locale), "locale"
+	if language == "" {
+		language, source = utils.LanguageFromTelephone(telephone), "telephone"
+	}
+	message, found := messages[language]
+	if language == "" || !found {
+		sim.step("language", "default", map[string]interface{}{"locale": locale, "language": language})
+		return "", ""
+	}
+	sim.step("language", language, map[string]interface{}{"locale": locale, "source": source})
+	return language, message
+}
+
+// messageVariant - choose a message variant (weighted) of the language (empty for the config message) when A/B testing
+// of messages is set up for the config, returns the variant name, the variant message and whether a variant was chosen
+func messageVariant(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, language string) (string, string, bool) {
+	var mvs []database.MessageVariant
+	for _, mv := range grcftwc.MessageVariants {
+		if mv.Language == language {
+			mvs = append(mvs, mv)
+		}
+	}
+	weights := make([]uint, len(mvs))
+	for i, mv := range mvs {
+		weights[i] = mv.Weight
+	}
+	i := utils.WeightedChoice(weights)
+	if i < 0 {
+		return "", "", false
+	}
+	return mvs[i].Name, mvs[i].Message, true
+}
+
+// multiMessage - choose one of the messages (split by the multi message separator of the config) at random,
+// returns the message and its position as the variant (see multiMessageVariant)
+func multiMessage(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, message string, language string) (string, string) {
+	sep := strings.TrimSpace(grcftwc.MultiMessageSeparator)
+	if message == "" || sep == "" {
+		log.Printf("no message or separator for multi message for clientID: %d\n", grcftwc.ClientID)
+		return "", ""
+	}
+	ms := strings.Split(message, sep)
+	r := rand.Intn(len(ms))
+	return ms[r], multiMessageVariant(language, r+1)
+}
+
+// multiMessageVariant - the variant recorded for the position of a multi message, prefixed by the language of the
+// message (when not the config message) e.g. nl:2
+func multiMessageVariant(language string, position int) string {
+	if language == "" {
+		return strconv.Itoa(position)
+	}
+	return language + ":" + strconv.Itoa(position)
+}
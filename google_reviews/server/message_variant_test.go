@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+
+	"google_reviews/database"
+)
+
+// configWithMessages - the config with its messages by language and message variants loaded (see
+// database.ConfigFromTokenWithChecks)
+func configWithMessages(configID uint64) database.GoogleReviewsConfigFromTokenWithChecks {
+	return database.GoogleReviewsConfigFromTokenWithChecks{ConfigID: configID,
+		Messages: database.ConfigMessages(configID), MessageVariants: database.MessageVariants(configID)}
+}
+
+func TestMessageVariant(t *testing.T) {
+	prepareTestDatabase()
+	counts := make(map[string]int)
+	for n := 0; n < 100; n++ {
+		variant, message, chosen := messageVariant(configWithMessages(12), "")
+		if !chosen || message == "" {
+			t.Fatalf("message variant should have been chosen got variant: %s, message: %s", variant, message)
+		}
+		counts[variant]++
+	}
+	// the disabled variant and the variants of other languages should never be chosen
+	if counts["short"] == 0 || counts["friendly"] == 0 || counts["old"] != 0 || counts["nl_friendly"] != 0 {
+		t.Fatalf("unexpected message variant allocation: %+v", counts)
+	}
+	if _, _, chosen := messageVariant(configWithMessages(1), ""); chosen {
+		t.Fatal("no message variant should be chosen when none are set up")
+	}
+}
+
+func TestMessageVariantLanguage(t *testing.T) {
+	prepareTestDatabase()
+	grcftwc := configWithMessages(12)
+	if variant, _, chosen := messageVariant(grcftwc, "nl"); !chosen || variant != "nl_friendly" {
+		t.Fatalf("the message variant of the language should have been chosen got variant: %s", variant)
+	}
+	if _, _, chosen := messageVariant(grcftwc, "fr"); chosen {
+		t.Fatal("no message variant should be chosen when none are set up for the language")
+	}
+}
+
+func TestMessageLanguage(t *testing.T) {
+	prepareTestDatabase()
+	var sim *simulation
+	grcftwc := configWithMessages(12)
+	tests := []struct {
+		locale, telephone, language string
+	}{
+		{"nl-BE", "447123456789", "nl"},
+		{"fr_FR", "", "fr"},
+		{"", "31612345678", "nl"},
+		{"", "33612345678", "fr"},
+		// the booking locale is used before the telephone, there is no German message so the config message is used
+		{"de-DE", "31612345678", ""},
+		{"", "447123456789", ""},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		language, message := sim.messageLanguage(grcftwc, test.locale, test.telephone)
+		if language != test.language || (language != "" && message == "") || (language == "" && message != "") {
+			t.Errorf("locale: %q, telephone: %q expected language %q got %q with message %q", test.locale, test.telephone, test.language, language, message)
+		}
+	}
+	if language, _ := sim.messageLanguage(configWithMessages(1), "nl", ""); language != "" {
+		t.Fatalf("the config message should be used when the config has no messages by language got: %s", language)
+	}
+}
+
+func TestMultiMessageLanguage(t *testing.T) {
+	grcftwc := database.GoogleReviewsConfigFromTokenWithChecks{MultiMessageSeparator: "SSSSS"}
+	message, variant := multiMessage(grcftwc, "Beoordeel onsSSSSSBeoordeel ons alstublieft", "nl")
+	if (message != "Beoordeel ons" || variant != "nl:1") && (message != "Beoordeel ons alstublieft" || variant != "nl:2") {
+		t.Fatalf("unexpected multi message: %s, variant: %s", message, variant)
+	}
+	if _, variant := multiMessage(grcftwc, "Please review usSSSSSPlease review us now", ""); variant != "1" && variant != "2" {
+		t.Fatalf("the variant of the config message should not have a language got: %s", variant)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+
+	"google_reviews/logging"
+	"google_reviews/metrics"
+)
+
+var (
+	// requestsTotal - requests by handler and outcome
+	requestsTotal = metrics.NewCounterVec("google_reviews_requests_total",
+		"Requests by handler and outcome (success, failed or error).", "handler", "outcome")
+	// requestDuration - time taken to handle a request
+	requestDuration = metrics.NewHistogramVec("google_reviews_request_duration_seconds",
+		"Time taken to handle a request.", metrics.DefaultBuckets, "handler")
+	// verificationFailuresTotal - requests rejected by the request verification by handler and reason
+	verificationFailuresTotal = metrics.NewCounterVec("google_reviews_verification_failures_total",
+		"Requests rejected by the request verification by handler and reason (ip_not_allowed, invalid_signature or expired_signature).",
+		"handler", "reason")
+	// deliveryStatusTotal - delivery statuses received from the message services by channel and status
+	deliveryStatusTotal = metrics.NewCounterVec("google_reviews_delivery_status_total",
+		"Delivery statuses received from the message services by channel and status (delivered, undelivered or failed).",
+		"channel", "status")
+	// sendsDeferredTotal - messages not sent because of a transient error deferred to the send later worker by channel
+	sendsDeferredTotal = metrics.NewCounterVec("google_reviews_sends_deferred_total",
+		"Messages not sent because of a transient error (e.g. the message service timed out) deferred to the send later worker by channel.",
+		"channel")
+	// sendsPacedTotal - messages of the configs with pacing enabled deferred to later in the window, or not sent as the
+	// daily send count is already allocated, by outcome
+	sendsPacedTotal = metrics.NewCounterVec("google_reviews_sends_paced_total",
+		"Messages of the configs with pacing enabled by outcome (deferred to later in the window or allocated when the daily send count is already allocated).",
+		"outcome")
+)
+
+// instrument - count the requests of the handler by outcome and observe their duration, and log them with a request ID
+func instrument(handler string, h http.Handler) http.Handler {
+	return logging.Handler(handler, metrics.InstrumentHandler(requestsTotal, requestDuration, handler, failedResponse, h))
+}
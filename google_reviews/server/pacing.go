@@ -0,0 +1,46 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"google_reviews/database"
+	"google_reviews/utils"
+)
+
+// sendDelay - minutes the message is deferred by (0 to send now), the send delay of the config or, when pacing is
+// enabled, the delay spreading the daily send count across the window if longer (see utils.PacingDelay). The messages
+// deferred are sent by the send later worker. Returns false when pacing and the daily send count is already allocated
+// to the messages sent and deferred today.
+func (sim *simulation) sendDelay(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, ignoreTimeAndSentCountCheck bool) (int, bool) {
+	sendDelay := 0
+	if grcftwc.SendDelayEnabled && grcftwc.SendDelay > 0 {
+		sendDelay = int(grcftwc.SendDelay)
+	}
+	if !grcftwc.PacingEnabled || ignoreTimeAndSentCountCheck {
+		return sendDelay, true
+	}
+
+	allocated := database.DailySentCount(grcftwc.ClientID) + database.DailySendLaterCount(grcftwc.ClientID)
+	delay, ok := utils.PacingDelay(grcftwc.Start, grcftwc.End, grcftwc.TimeZone, grcftwc.Days(), grcftwc.MaxDailySendCount, allocated, time.Now())
+	pacedDelay := int(math.Ceil(delay.Minutes()))
+	detail := map[string]interface{}{"allocated": allocated, "max_daily_send_count": grcftwc.MaxDailySendCount, "send_after_minutes": pacedDelay}
+	result := "not_deferred"
+	switch {
+	case !ok:
+		result = "allocated"
+	case pacedDelay > 0:
+		result = "deferred"
+	}
+	sim.step("pacing", result, detail)
+	if sim == nil && result != "not_deferred" {
+		sendsPacedTotal.Inc(result)
+	}
+	if !ok {
+		return 0, false
+	}
+	if pacedDelay > sendDelay {
+		sendDelay = pacedDelay
+	}
+	return sendDelay, true
+}
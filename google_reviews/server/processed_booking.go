@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"time"
+
+	"google_reviews/database"
+)
+
+// bookingResponse - records the response written for a claimed booking so it can be returned for repeated
+// deliveries of the booking (see claimBooking)
+type bookingResponse struct {
+	http.ResponseWriter
+	clientID  uint64
+	bookingID string
+	store     bool
+	processed bool
+	body      bytes.Buffer
+}
+
+func (b *bookingResponse) Write(p []byte) (int, error) {
+	if b.store {
+		b.body.Write(p)
+	}
+	return b.ResponseWriter.Write(p)
+}
+
+// complete - store the response of the claimed booking when processed (see bookingProcessed), otherwise the claim is
+// released so a repeated delivery is processed again (e.g. rejected outside the config times)
+func (b *bookingResponse) complete() {
+	if !b.store {
+		return
+	}
+	if b.processed {
+		database.CompleteBooking(b.clientID, b.bookingID, b.body.String())
+		return
+	}
+	database.ReleaseBooking(b.clientID, b.bookingID)
+}
+
+// bookingProcessed - the booking of the response (when claimed) has been processed, the message was sent or deferred,
+// so its response is returned for repeated deliveries of the booking
+func bookingProcessed(w http.ResponseWriter) {
+	if b, ok := w.(*bookingResponse); ok {
+		b.processed = true
+	}
+}
+
+// claimBooking - claim the booking for processing, when the booking has already been processed (dispatcher retries)
+// the original response (or the duplicate response if still being processed) is written and false returned.
+// The returned writer records the response, complete should be called when the request has been handled and only
+// the response of a processed booking is kept (see bookingProcessed).
+// When simulating the booking is only checked (not claimed).
+func (sim *simulation) claimBooking(w http.ResponseWriter, clientID uint64, bookingID string, duplicateResponse []byte) (*bookingResponse, bool) {
+	b := &bookingResponse{ResponseWriter: w, clientID: clientID, bookingID: bookingID}
+	if clientID == 0 || bookingID == "" {
+		return b, true
+	}
+	var claimed bool
+	var response string
+	if sim == nil {
+		claimed, response = database.ClaimBooking(clientID, bookingID)
+	} else {
+		var found bool
+		response, found = database.ProcessedBooking(clientID, bookingID)
+		claimed = !found
+	}
+	if claimed {
+		sim.step("booking", "new", map[string]interface{}{"booking_id": bookingID})
+		b.store = sim == nil
+		return b, true
+	}
+	log.Printf("booking: %s for clientID: %d has already been processed, returning the original response\n", bookingID, clientID)
+	sim.step("booking", "duplicate", map[string]interface{}{"booking_id": bookingID, "response": response})
+	if response == "" {
+		sim.write(w, duplicateResponse)
+	} else {
+		sim.write(w, []byte(response))
+	}
+	return b, false
+}
+
+// PurgeProcessedBookings - delete the expired processed bookings every period
+func PurgeProcessedBookings(period time.Duration) {
+	for range time.Tick(period) {
+		if n := database.PurgeProcessedBookings(); n > 0 {
+			log.Printf("%d expired processed bookings deleted\n", n)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"google_reviews/database"
+)
+
+func TestGoogleReviewsHandlerProcessedBooking(t *testing.T) {
+	prepareTestDatabase()
+	database.EnableProcessedBookings(time.Hour, time.Minute)
+	defer database.EnableProcessedBookings(0, 0)
+
+	// booking 1001 of client 12 has already been processed, the original response is returned
+	body := "t=07123456789&b=1001" +
+		"&gr_token=" + url.QueryEscape("OYBpBsZ9OhR-nbsupMQU_hCTJuaabtZ1gsfsfp")
+	req, err := http.NewRequest("POST", "/googlereviews", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	GoogleReviewsHandler().ServeHTTP(rr, req)
+	if rr.Body.String() != `{"success":"1"}` {
+		t.Errorf("expected the original response got: %s", rr.Body.String())
+	}
+
+	// simulating shows the booking has already been processed
+	sim := simulate(t, GoogleReviewsSimulateHandler(), body)
+	if s, ok := simulationStep(sim, "booking"); !ok || s.Result != "duplicate" || sim.Response != `{"success":"1"}` {
+		t.Errorf("expected a duplicate booking: %+v, response: %s", sim.Steps, sim.Response)
+	}
+	if _, ok := simulationStep(sim, "config"); ok {
+		t.Errorf("a duplicate booking should not be checked: %+v", sim.Steps)
+	}
+}
+
+func TestGoogleReviewsHandlerBookingNotProcessed(t *testing.T) {
+	prepareTestDatabase()
+	database.EnableProcessedBookings(time.Hour, time.Minute)
+	defer database.EnableProcessedBookings(0, 0)
+
+	// no message sent (telephone not valid) so the booking is released and a repeated delivery processed again
+	body := "t=123&b=1006" +
+		"&gr_token=" + url.QueryEscape("OYBpBsZ9OhR-nbsupMQU_hCTJuaabtZ1gsfsfp")
+	req, err := http.NewRequest("POST", "/googlereviews", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	GoogleReviewsHandler().ServeHTTP(rr, req)
+	if rr.Body.String() != string(failedResponse) {
+		t.Errorf("expected the failed response got: %s", rr.Body.String())
+	}
+	if _, found := database.ProcessedBooking(12, "1006"); found {
+		t.Error("a booking not processed should not be kept")
+	}
+}
+
+func TestCordicHandlerProcessedBooking(t *testing.T) {
+	prepareTestDatabase()
+	database.EnableProcessedBookings(time.Hour, time.Minute)
+	defer database.EnableProcessedBookings(0, 0)
+
+	// a new booking is claimed and its response returned for a repeated delivery
+	body := "gr_token=" + url.QueryEscape("qey-FMF9Wun-dAJQ6Ri1wBv1hh7DsjcH7QRM7WMDv9MUeEdgNFwgW4pYxedTjfV8") +
+		"&passenger_id=abcdefghijklmno&booking_id=C2001"
+	var responses []string
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("POST", "/cordic", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		CordicHandler().ServeHTTP(rr, req)
+		responses = append(responses, rr.Body.String())
+	}
+	if responses[0] == "" || responses[0] != responses[1] {
+		t.Errorf("expected the original response for the repeated delivery: %v", responses)
+	}
+}
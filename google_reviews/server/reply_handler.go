@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google_reviews/config"
+	"google_reviews/database"
+	"google_reviews/sender"
+	"google_reviews/utils"
+)
+
+// reply request parameters
+const replyGoogleReviewTokenParameter = "gr_token"
+const replyTelephoneParameter = "telephone"
+const replyMessageParameter = "message"
+
+var replySuccessResponse = []byte(`{"success":"1"}`)
+var replyFailedResponse = []byte(`{"success":"0"}`)
+
+// reviewMasterSMSGatewayReply - reply received by the Review Master SMS Gateway
+// e.g. {"queue_id":"81","telephone":"+447123456789","message":"STOP"}
+type reviewMasterSMSGatewayReply struct {
+	QueueID   string `json:"queue_id"`
+	Telephone string `json:"telephone"`
+	Message   string `json:"message"`
+}
+
+// messageMediaReply - reply (inbound message) received by Message Media
+// (see: https://support.messagemedia.com/hc/en-us/articles/4413627066383-Webhooks)
+// e.g. {"message_id":"...","reply_id":"...","content":"STOP","source_number":"+447123456789","destination_number":"+447700900123"}
+type messageMediaReply struct {
+	MessageID         string `json:"message_id"`
+	ReplyID           string `json:"reply_id"`
+	Content           string `json:"content"`
+	SourceNumber      string `json:"source_number"`
+	DestinationNumber string `json:"destination_number"`
+}
+
+// ReviewMasterSMSGatewayReplyHandler - handle replies received by the Review Master SMS Gateway
+// The client is the one that last sent a message to the telephone via the Review Master SMS Gateway (see
+// database.LastSentClientID). The queue ID of the reply is the client ID of the queue the message was sent to (see
+// sender.reviewMasterSMSGatewaySender), or the master queue when shared by the clients, so the client of the queue
+// is preferred and only used on its own when no message was found. If the master queue is used and no message was
+// found the client cannot be determined so stop is set for all clients (global stop).
+// For security require the Review Master SMS Gateway api token that should be sent and checked
+func ReviewMasterSMSGatewayReplyHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+
+		apiToken := req.Header.Get("api-token")
+		if len(apiToken) < 1 || apiToken != config.Conf.ReviewMasterSMSGatewayApiToken {
+			log.Printf("Error, reply api-token %s is incorrect\n", apiToken)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(replyFailedResponse)
+			return
+		}
+		var reply reviewMasterSMSGatewayReply
+		if err := json.NewDecoder(req.Body).Decode(&reply); err != nil {
+			log.Printf("Error decoding Review Master SMS Gateway reply, error: %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(replyFailedResponse)
+			return
+		}
+		var queueClientID uint64
+		queueID, err := strconv.ParseUint(strings.TrimSpace(reply.QueueID), 10, 64)
+		if err == nil && queueID != uint64(database.ReviewMasterSMSMasterQueue) {
+			queueClientID = queueID
+		}
+		clientID := queueClientID
+		if telephone := utils.TelephoneParse(strings.TrimSpace(reply.Telephone), ""); telephone != "" {
+			if sentClientID := database.LastSentClientID(telephone, sender.ReviewMasterSMSGateway, queueClientID); sentClientID != 0 {
+				clientID = sentClientID
+			}
+		}
+		country := ""
+		if clientID != 0 {
+			country = database.ClientCountry(clientID)
+		}
+		processReply(reply.Telephone, reply.Message, clientID, country, "Review Master SMS Gateway")
+		w.Write(replySuccessResponse)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// MessageMediaReplyHandler - handle replies (inbound messages) received by Message Media
+// The Message Media webhook is configured with the google reviews token which is used to find the client
+// e.g. https://example.com/reply/messagemedia?gr_token=...
+func MessageMediaReplyHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+
+		clientID, country := database.ClientIDAndCountryFromToken(strings.TrimSpace(req.URL.Query().Get(replyGoogleReviewTokenParameter)))
+		if clientID == 0 {
+			log.Printf("Error, Message Media reply token is incorrect\n")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(replyFailedResponse)
+			return
+		}
+		var reply messageMediaReply
+		if err := json.NewDecoder(req.Body).Decode(&reply); err != nil {
+			log.Printf("Error decoding Message Media reply for clientID: %d, error: %v\n", clientID, err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(replyFailedResponse)
+			return
+		}
+		processReply(reply.SourceNumber, reply.Content, clientID, country, "Message Media")
+		w.Write(replySuccessResponse)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// SendSMSReplyHandler - handle replies received by the own SMS gateway (send_sms)
+// Sent as form parameters gr_token, telephone and message
+func SendSMSReplyHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+
+		if err := req.ParseForm(); err != nil {
+			log.Printf("ParseForm() err: %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(replyFailedResponse)
+			return
+		}
+		clientID, country := database.ClientIDAndCountryFromToken(strings.TrimSpace(req.FormValue(replyGoogleReviewTokenParameter)))
+		if clientID == 0 {
+			log.Printf("Error, send SMS reply token is incorrect\n")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(replyFailedResponse)
+			return
+		}
+		processReply(req.FormValue(replyTelephoneParameter), req.FormValue(replyMessageParameter), clientID, country, "own SMS gateway")
+		w.Write(replySuccessResponse)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// processReply - set stop for the telephone if the reply is an opt out.
+// A global opt out (e.g. STOP ALL) or an unknown client (clientID 0) sets stop for all clients.
+func processReply(tel string, message string, clientID uint64, country string, service string) {
+	optOut, global := utils.OptOut(message)
+	if !optOut {
+		return
+	}
+	telephone := utils.TelephoneParse(strings.TrimSpace(tel), country)
+	if telephone == "" {
+		log.Printf("no telephone found for opt out reply via %s (sent telephone: %s) for clientID: %d\n", service, utils.MaskTelephone(tel), clientID)
+		return
+	}
+	// attribute the opt out to the message (variant) last sent to the telephone
+	database.AddOptOutMessageEvent(clientID, telephone, service)
+	if global || clientID == 0 {
+		log.Printf("opt out reply via %s, stop sending for all clients for telephone: %s\n", service, utils.MaskTelephone(telephone))
+		database.StopSendingAllClients(telephone)
+		return
+	}
+	log.Printf("opt out reply via %s, stop sending for clientID: %d for telephone: %s\n", service, clientID, utils.MaskTelephone(telephone))
+	database.StopSending(telephone, clientID)
+}
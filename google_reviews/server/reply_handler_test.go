@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google_reviews/database"
+)
+
+func TestMessageMediaReplyHandlerStop(t *testing.T) {
+	prepareTestDatabase()
+	body := []byte(`{"message_id":"877c19ef-fa2e-4cec-827a-e1df9b5509f7","reply_id":"a175e797-2b54-468b-9850-41a3eab32f74","content":"Stop","source_number":"+447123456789","destination_number":"+447700900123"}`)
+	req, err := http.NewRequest("POST", "/reply/messagemedia?gr_token="+url.QueryEscape("QxrH0iJc3wv/lj/YKVppNYRad7tN0Z3x"), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.Handler(MessageMediaReplyHandler())
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	_, _, stop, _ := database.LastSentFromTelephoneAndClient("447123456789", 1)
+	if !stop {
+		t.Error("stop should have been set for telephone 447123456789")
+	}
+}
+
+func TestMessageMediaReplyHandlerNotStop(t *testing.T) {
+	prepareTestDatabase()
+	body := []byte(`{"content":"Thanks, great driver","source_number":"+447123456789"}`)
+	req, err := http.NewRequest("POST", "/reply/messagemedia?gr_token="+url.QueryEscape("QxrH0iJc3wv/lj/YKVppNYRad7tN0Z3x"), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.Handler(MessageMediaReplyHandler())
+	handler.ServeHTTP(rr, req)
+
+	_, _, stop, _ := database.LastSentFromTelephoneAndClient("447123456789", 1)
+	if stop {
+		t.Error("stop should not have been set for telephone 447123456789")
+	}
+}
+
+func TestMessageMediaReplyHandlerWrongToken(t *testing.T) {
+	prepareTestDatabase()
+	body := []byte(`{"content":"STOP","source_number":"+447123456789"}`)
+	req, err := http.NewRequest("POST", "/reply/messagemedia?gr_token=rubbishToken", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.Handler(MessageMediaReplyHandler())
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestSendSMSReplyHandlerGlobalStop(t *testing.T) {
+	prepareTestDatabase()
+	form := url.Values{}
+	form.Add("gr_token", "QxrH0iJc3wv/lj/YKVppNYRad7tN0Z3x")
+	form.Add("telephone", "07123456785")
+	form.Add("message", "STOP ALL")
+	req, err := http.NewRequest("POST", "/reply/sms", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	handler := http.Handler(SendSMSReplyHandler())
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !database.GlobalStop("447123456785") {
+		t.Error("global stop should have been set for telephone 447123456785")
+	}
+	// another client that has never sent to the telephone should also be stopped
+	_, _, stop, _ := database.LastSentFromTelephoneAndClient("447123456785", 2)
+	if !stop {
+		t.Error("stop should have been set for telephone 447123456785 for all clients")
+	}
+}
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google_reviews/barred"
+	"google_reviews/database"
+	"google_reviews/email"
+	"google_reviews/hook"
+	"google_reviews/logging"
+	"google_reviews/sender"
+	"google_reviews/utils"
+
+	"github.com/dongri/phonenumber"
+)
+
+// reviewRequest - a review request from a dispatcher webhook (see sendReviewRequest), the booking fields are mapped
+// from the request using the names of the hook package fields e.g. telephone, email, message
+type reviewRequest struct {
+	grToken string
+	grcftwc database.GoogleReviewsConfigFromTokenWithChecks
+	fields  map[string]string
+	// telephoneParameter and emailParameter - the request parameters of the telephone and email (added to the trace)
+	telephoneParameter string
+	emailParameter     string
+	// sender - the message service, the message service of the config (with its SMS fallback) when nil
+	sender sender.MessageSender
+	// params - the request parameters sent on to the message service (the message replaced when there is one), when
+	// set a review request without a message is still sent as the message may be one of the parameters
+	params    url.Values
+	apiKey    string
+	apiSecret string
+	// checks ignored (used for testing on front end)
+	ignoreTimeAndSentCountCheck bool
+	ignoreTelephoneChecks       bool
+	ignoreDispatcherChecks      bool
+	// dispatcherCheck - check the booking (adding the dispatcher_check step to the trace), nil when not enabled
+	dispatcherCheck func() bool
+	// successResponse - response when sent (or will be sent later), skippedResponse when not sent because of the last
+	// sent checks (stopped, too recent, maximum count or fatigue policy) and failedResponse when not sent otherwise
+	successResponse []byte
+	skippedResponse []byte
+	failedResponse  []byte
+	// providerResponse - respond with the response of the message service when sent now (the success response when
+	// sent, it replaces the success response of the config)
+	providerResponse bool
+}
+
+// formFields - the booking fields (see reviewRequest) from the request parameters of the config, the message template
+// placeholders are sent using the placeholder name e.g. first_name=Jane
+func formFields(req *http.Request, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) map[string]string {
+	fields := map[string]string{
+		hook.FieldTelephone: strings.TrimSpace(req.FormValue(grcftwc.TelephoneParameter)),
+		hook.FieldBookingID: strings.TrimSpace(req.FormValue(grcftwc.BookingIdParameter)),
+		hook.FieldMessage:   strings.TrimSpace(req.FormValue(grcftwc.MessageParameter)),
+		hook.FieldLocale:    req.FormValue(localeParameter),
+	}
+	if grcftwc.EmailParameter != "" {
+		fields[hook.FieldEmail] = req.FormValue(grcftwc.EmailParameter)
+	}
+	for _, p := range messageTemplatePlaceholders {
+		fields[p] = strings.TrimSpace(req.FormValue(p))
+	}
+	return fields
+}
+
+// fieldTemplateValues - get the message template placeholder values from the booking fields,
+// the review and opt out links set in the config take precedence (see messageTemplateValues)
+func fieldTemplateValues(fields map[string]string, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) map[string]string {
+	values := make(map[string]string, len(messageTemplatePlaceholders))
+	for _, p := range messageTemplatePlaceholders {
+		values[p] = fields[p]
+	}
+	if grcftwc.ReviewLink != "" {
+		values[utils.PlaceholderReviewLink] = grcftwc.ReviewLink
+	}
+	if grcftwc.OptOutLink != "" {
+		values[utils.PlaceholderOptOutLink] = grcftwc.OptOutLink
+	}
+	return values
+}
+
+// reviewConfig - the config of the token with the checks (see ConfigFromTokenWithChecks), when rejected why it is not
+// sent is recorded (if the token is found) and the failed response written. The telephone returns the telephone sent
+// in the telephone parameter of the rejected config.
+func (sim *simulation) reviewConfig(w http.ResponseWriter, grToken string, ignoreTimeAndSentCountCheck bool,
+	telephone func(telephoneParameter string) string, failed []byte) (database.GoogleReviewsConfigFromTokenWithChecks, bool) {
+	grcftwc := database.ConfigFromTokenWithChecks(grToken, ignoreTimeAndSentCountCheck)
+	if grcftwc.ClientID == 0 {
+		sim.step("config", "rejected", nil)
+		// record why not sent (outside hours or maximum daily send count) if the token is found
+		if clientID, country, telephoneParameter, reason := database.RejectedConfigFromToken(grToken); clientID != 0 {
+			sim.addMessageEvent(clientID, utils.TelephoneParse(telephone(telephoneParameter), country), "", reason, "", 0)
+		}
+		// update stats
+		sim.updateStatsCanUseToken(0, grToken, false)
+		sim.write(w, failed)
+		return grcftwc, false
+	}
+	sim.step("config", "found", configDetail(grcftwc, ignoreTimeAndSentCountCheck))
+	return grcftwc, true
+}
+
+// notSent - record why the review request is not sent, update the stats (a stopped telephone is not counted) and
+// write the response
+func (sim *simulation) notSent(w http.ResponseWriter, rr reviewRequest, identifier string, channel string, reason string, resp []byte) {
+	sim.addMessageEvent(rr.grcftwc.ClientID, identifier, channel, reason, "", 0)
+	if reason != database.ReasonStopped {
+		sim.updateStatsCanUseToken(rr.grcftwc.ClientID, rr.grToken, false)
+	}
+	sim.write(w, resp)
+}
+
+// bookingTimesCheck - check the pick up is soon after the booked for time (the booking for now or pre booking pickup
+// to contact minutes of the config) using the booking times of the fields, adding the dispatcher_check step to the
+// trace. The check fails when a booking time is missing.
+func (sim *simulation) bookingTimesCheck(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, fields map[string]string) bool {
+	for _, f := range []string{hook.FieldBookingCreated, hook.FieldBookedFor, hook.FieldPickedUp} {
+		if fields[f] == "" {
+			log.Printf("no %s sent in request for clientID: %d\n", f, grcftwc.ClientID)
+			sim.step("dispatcher_check", "failed", map[string]interface{}{"missing_field": f})
+			return false
+		}
+	}
+	bookingCreated, bookedFor, pickedUp := fields[hook.FieldBookingCreated], fields[hook.FieldBookedFor], fields[hook.FieldPickedUp]
+	bookingForNow := utils.CheckDiffTimeRFC3339(bookingCreated, bookedFor, strconv.Itoa(int(grcftwc.IsBookingForNowDiffMinutes)))
+	pickupToContactMinutes := grcftwc.PreBookingPickupToContactMinutes
+	if bookingForNow {
+		pickupToContactMinutes = grcftwc.BookingNowPickupToContactMinutes
+	}
+	dispatcherCheckPassed := utils.CheckDiffTimeRFC3339(bookedFor, pickedUp, strconv.Itoa(int(pickupToContactMinutes)))
+	sim.step("dispatcher_check", passedResult(dispatcherCheckPassed), map[string]interface{}{"booking_for_now": bookingForNow})
+	return dispatcherCheckPassed
+}
+
+// dispatcherBookingCheck - check the booking (trip) with the dispatcher (see BookingOk), adding the dispatcher_check
+// step to the trace
+func (sim *simulation) dispatcherBookingCheck(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, tripID string) bool {
+	dispatcherCheckPassed := BookingOk(grcftwc.DispatcherURL, grcftwc.AppKey, grcftwc.SecretKey, tripID,
+		int(grcftwc.IsBookingForNowDiffMinutes), int(grcftwc.BookingNowPickupToContactMinutes), int(grcftwc.PreBookingPickupToContactMinutes),
+		grcftwc.ClientID, grcftwc.TLSSkipVerify)
+	sim.step("dispatcher_check", passedResult(dispatcherCheckPassed), map[string]interface{}{"trip_id": tripID})
+	return dispatcherCheckPassed
+}
+
+// sendReviewRequest - the pipeline of a review request shared by the dispatcher webhooks once the config is found:
+// the telephone (or email address or passenger ID), barred, last sent and fatigue checks, the message, the dispatcher
+// check and pacing, then the message is sent (or sent later) and the response written
+func (sim *simulation) sendReviewRequest(w http.ResponseWriter, req *http.Request, rr reviewRequest) {
+	grcftwc := rr.grcftwc
+
+	// the message is sent to the telephone using the message service, without a telephone the email address is
+	// emailed (when the config has email enabled) otherwise the passenger ID is used (treated like a telephone) and
+	// the message is returned to the dispatcher to send
+	telephone := utils.TelephoneParse(rr.fields[hook.FieldTelephone], grcftwc.Country)
+	address := ""
+	if telephone == "" {
+		address = emailAddress(grcftwc, rr.fields[hook.FieldEmail])
+	}
+	passengerID := rr.fields[hook.FieldPassengerID]
+	s, fallback := rr.sender, sender.MessageSender(nil)
+	if s == nil {
+		s = sender.ForConfig(grcftwc)
+		// SMS fallback when sending via WhatsApp (nil when none)
+		fallback = sender.FallbackForConfig(grcftwc)
+	}
+	channel := s.Name()
+	identifier := telephone
+	switch {
+	case telephone == "" && address != "":
+		channel = email.Channel
+		identifier = address
+	case telephone == "" && passengerID != "":
+		channel = hookChannel
+		identifier = passengerID
+	}
+	sim.step("provider", channel, fallbackDetail(fallback))
+	if identifier == "" {
+		sim.step("telephone", "not_found", map[string]interface{}{"parameter": rr.telephoneParameter, "sent": rr.fields[hook.FieldTelephone]})
+		log.Printf("no telephone found (sent telephone: %s) for clientID: %d\n", utils.MaskTelephone(rr.fields[hook.FieldTelephone]), grcftwc.ClientID)
+		sim.notSent(w, rr, "", channel, database.ReasonNoTelephone, rr.failedResponse)
+		return
+	}
+
+	// Some SIMs are configured not to send international numbers and when the telephone is
+	// configured to E.164 format with the local country code this is determined to be international
+	// so the SMS is not sent.
+	// Therefore have to replace the country code to make it a national number this is normally with a 0.
+	telephoneSendSMS := telephone
+	switch {
+	case telephone != "":
+		sim.step("telephone", "normalised", map[string]interface{}{"parameter": rr.telephoneParameter, "sent": rr.fields[hook.FieldTelephone], "telephone": telephone})
+		// check barred telephone prefixes and full numbers (for all clients and the client)
+		if barred.Load().Barred(telephone, grcftwc.ClientID) {
+			sim.step("barred", "barred", nil)
+			sim.notSent(w, rr, telephone, channel, database.ReasonBarred, rr.failedResponse)
+			return
+		}
+		if grcftwc.ReplaceTelephoneCountryCode {
+			countryForTelephone := phonenumber.GetISO3166ByNumber(telephone, false)
+			telephoneSendSMS = strings.Replace(telephone, countryForTelephone.CountryCode, grcftwc.ReplaceTelephoneCountryCodeWith, 1)
+		}
+		sim.step("barred", "not_barred", map[string]interface{}{"send_telephone": telephoneSendSMS})
+	case address != "":
+		sim.step("email", "found", map[string]interface{}{"parameter": rr.emailParameter, "email": address})
+	default:
+		sim.step("passenger_id", "found", map[string]interface{}{"passenger_id": passengerID})
+	}
+
+	lastSent, sentCount, stop, found := database.LastSentFromTelephoneAndClient(identifier, grcftwc.ClientID)
+	if address != "" {
+		lastSent, sentCount, stop, found = database.LastSentFromEmailAndClient(address, grcftwc.ClientID)
+	}
+	sim.step("last_sent", checkedResult(!rr.ignoreTelephoneChecks), lastSentDetail(lastSent, sentCount, stop, found))
+	if !rr.ignoreTelephoneChecks {
+		reason := ""
+		switch {
+		case stop:
+			reason = database.ReasonStopped
+		case found && lastSent.After(time.Now().AddDate(0, 0, int(-grcftwc.MinSendFrequency))):
+			reason = database.ReasonTooRecent
+		case found && int(sentCount) > int(grcftwc.MaxSendCount):
+			reason = database.ReasonMaxCount
+		case telephone != "" && sim.fatigueCapped(grcftwc, telephone):
+			// review requests to the telephone across clients (fatigue policies)
+			reason = database.ReasonFatigueCap
+		}
+		if reason != "" {
+			sim.notSent(w, rr, identifier, channel, reason, rr.skippedResponse)
+			return
+		}
+	}
+
+	// get initial message
+	message := rr.fields[hook.FieldMessage]
+	if grcftwc.UseDatabaseMessage == 1 {
+		message = grcftwc.Message
+	}
+
+	// the message of the booking language (when the config has one) replaces the message
+	language, languageMessage := sim.messageLanguage(grcftwc, rr.fields[hook.FieldLocale], telephone)
+	if language != "" {
+		message = languageMessage
+	}
+
+	// A/B test message variants of the language (when set up) replace the message, the variant sent (or the
+	// position of the multi message) is recorded with the message events and tracked short links
+	variant, variantMessage, variantChosen := messageVariant(grcftwc, language)
+	if variantChosen {
+		message = variantMessage
+	} else if grcftwc.MultiMessageEnabled == 1 {
+		message, variant = multiMessage(grcftwc, message, language)
+	}
+
+	// check message is not empty (unless it may be one of the request parameters sent on)
+	if message == "" && (rr.params == nil || telephone == "") {
+		log.Printf("no message sent in request or found in database for clientID: %d\n", grcftwc.ClientID)
+		sim.notSent(w, rr, identifier, channel, database.ReasonNoMessage, rr.failedResponse)
+		return
+	}
+
+	// fill in message template placeholders e.g. {first_name}
+	// review link is replaced by a tracked short link (when configured)
+	values := fieldTemplateValues(rr.fields, grcftwc)
+	shortLinkID := sim.trackReviewLink(message, values, grcftwc.ClientID, variant)
+	message = utils.FillMessageTemplate(message, values)
+	sim.step("message", "built", map[string]interface{}{"message": message, "variant": variant})
+
+	switch {
+	case rr.ignoreDispatcherChecks:
+		sim.step("dispatcher_check", "ignored", nil)
+	case rr.dispatcherCheck == nil:
+		sim.step("dispatcher_check", "not_enabled", nil)
+	case !rr.dispatcherCheck():
+		sim.notSent(w, rr, identifier, channel, database.ReasonDispatcherCheckFailed, rr.failedResponse)
+		return
+	}
+
+	// email address, emailed straight away (see sendEmail)
+	if address != "" {
+		if !sim.sendEmail(grcftwc, rr.grToken, address, message, values, variant, shortLinkID, sentCount) {
+			sim.write(w, rr.failedResponse)
+			return
+		}
+		bookingProcessed(w)
+		sim.write(w, rr.successResponse)
+		return
+	}
+
+	// passenger ID, return the message to the dispatcher to send
+	if telephone == "" {
+		sim.updateLastSent(passengerID, grcftwc.ClientID, sentCount+1)
+		sim.setShortLinkMessageEvent(shortLinkID, sim.addMessageEventWithVariant(grcftwc.ClientID, passengerID, channel, database.ReasonSent, variant, "", 0))
+		bookingProcessed(w)
+		// update stats
+		sim.updateStatsCanUseToken(grcftwc.ClientID, rr.grToken, true)
+		sim.write(w, hookMessageResponse(message))
+		return
+	}
+
+	// send the message with the request parameters (when sent on) replacing the original message
+	// NOTE: In some cases the message may be passed straight through without knowing what it is or what the message parameter is.
+	if rr.params != nil && message != "" {
+		rr.params.Set(grcftwc.MessageParameter, message)
+	}
+
+	// build the request to send the message via the message service (see sender package)
+	// NOTE: Message Media uses the api_key and api_secret parameters in the request for basic authentication.
+	m := sender.MessageFromConfig(grcftwc, telephone, telephoneSendSMS, message, rr.params)
+	m.ApiKey = rr.apiKey
+	m.ApiSecret = rr.apiSecret
+	m.TemplateValues = values
+	if rr.providerResponse {
+		m.SuccessResponse = string(rr.successResponse)
+	}
+	sendRequest := s.BuildRequest(m)
+
+	// send delay of the config or pacing (spreading the daily send count across the window)
+	sendDelay, allowed := sim.sendDelay(grcftwc, rr.ignoreTimeAndSentCountCheck)
+	if !allowed {
+		sim.notSent(w, rr, telephone, channel, database.ReasonMaxDailyCount, rr.failedResponse)
+		return
+	}
+
+	resp := rr.successResponse
+	switch {
+	case sendDelay > 0:
+		// store request in database
+		sim.sendLater(s, m, sendRequest, sendDelay)
+		sim.holdFallback(s, fallback, m)
+		sim.setShortLinkMessageEvent(shortLinkID, sim.addMessageEventWithVariant(grcftwc.ClientID, telephone, channel, database.ReasonDeferred, variant, "", 0))
+		bookingProcessed(w)
+		// update stats (request only, sent is counted by the send later worker when sent)
+		sim.updateStatsCanUseToken(grcftwc.ClientID, rr.grToken, false)
+	case sim != nil:
+		// simulating, the request that would be sent is added to the trace instead of being sent
+		sim.request("send", s, sendRequest, nil)
+		sim.holdFallback(s, fallback, m)
+		sim.addMessageEventWithVariant(grcftwc.ClientID, telephone, channel, database.ReasonSent, variant, "", 0)
+	default:
+		// send now
+		// sent via the SMS fallback when not sent via WhatsApp (e.g. the telephone is not on WhatsApp)
+		s, sendRequest, providerResp, latency, sendErr := sender.SendWithFallback(s, fallback, m, sendRequest)
+		channel = s.Name()
+		interpretedResp, sent := s.InterpretResponse(m, providerResp)
+		if rr.providerResponse {
+			resp = []byte(interpretedResp)
+		}
+		if sent {
+			sim.updateLastSent(telephone, grcftwc.ClientID, sentCount+1)
+			messageEventID := sim.addMessageEventWithVariant(grcftwc.ClientID, telephone, channel, database.ReasonSent, variant, providerResp, latency)
+			bookingProcessed(w)
+			sim.setShortLinkMessageEvent(shortLinkID, messageEventID)
+			// record the provider message ID to match the delivery status callbacks (e.g. Twilio)
+			sender.RecordMessageID(s, messageEventID, providerResp)
+			// hold the SMS fallback whilst the WhatsApp message is delivered
+			sim.holdFallback(s, fallback, m)
+			// update stats
+			sim.updateStatsCanUseToken(grcftwc.ClientID, rr.grToken, true)
+		} else if sendErr != nil {
+			// the message service is unavailable, sent by the send later worker (the sent is counted when sent)
+			sim.setShortLinkMessageEvent(shortLinkID, sim.deferFailedSend(req.Context(), s, m, sendRequest, variant, providerResp, latency, sendErr))
+			bookingProcessed(w)
+			sim.updateStatsCanUseToken(grcftwc.ClientID, rr.grToken, false)
+			resp = rr.successResponse
+		} else {
+			if !rr.providerResponse {
+				resp = rr.failedResponse
+			}
+			logging.FromContext(req.Context()).Error("Error sending message", logging.FieldEvent, logging.EventSendError,
+				logging.FieldClientID, grcftwc.ClientID, logging.FieldReason, database.ReasonProviderError,
+				"url", sendRequest.URL, "telephone", utils.MaskTelephone(telephone), "response", string(resp))
+			sim.addMessageEvent(grcftwc.ClientID, telephone, channel, database.ReasonProviderError, providerResp, latency)
+			// update stats
+			sim.updateStatsCanUseToken(grcftwc.ClientID, rr.grToken, false)
+		}
+	}
+	sim.write(w, resp)
+}
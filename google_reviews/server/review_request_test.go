@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google_reviews/database"
+	"google_reviews/hook"
+	"google_reviews/utils"
+)
+
+func TestFormFields(t *testing.T) {
+	form := url.Values{}
+	form.Add("t", " 07123456789 ")
+	form.Add("m", "Please review us {first_name}")
+	form.Add("trip", "1001")
+	form.Add("first_name", " Jane ")
+	form.Add("locale", "nl-BE")
+	req, err := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	grcftwc := database.GoogleReviewsConfigFromTokenWithChecks{TelephoneParameter: "t", MessageParameter: "m", BookingIdParameter: "trip",
+		ReviewLink: "https://g.page/r/test/review"}
+	fields := formFields(req, grcftwc)
+	if fields[hook.FieldTelephone] != "07123456789" || fields[hook.FieldMessage] != "Please review us {first_name}" ||
+		fields[hook.FieldBookingID] != "1001" || fields[hook.FieldLocale] != "nl-BE" {
+		t.Errorf("unexpected fields: %+v", fields)
+	}
+	if _, ok := fields[hook.FieldEmail]; ok {
+		t.Errorf("email field without an email parameter: %+v", fields)
+	}
+	values := fieldTemplateValues(fields, grcftwc)
+	if values[utils.PlaceholderFirstName] != "Jane" || values[utils.PlaceholderReviewLink] != "https://g.page/r/test/review" {
+		t.Errorf("unexpected template values: %+v", values)
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"google_reviews/config"
+	"google_reviews/email"
+	"google_reviews/metrics"
 )
 
 // log viewer variables
@@ -73,21 +75,51 @@ func Server(logFilename string) {
 	// username and password to access website / view logs
 	websiteUser = config.Conf.WebsiteUser
 	websitePassword = config.Conf.WebsitePassword
+	// request verification of the dispatcher webhooks
+	signatureReplayWindow = time.Duration(config.Conf.SignatureReplayWindow) * time.Second
+	verificationAlertPeriod = time.Duration(config.Conf.VerificationAlertPeriod) * time.Second
 
 	mux := http.NewServeMux()
 	// mux.Handle("/googlereviews", googleReviewsHandler{})
 	// mux.Handle("/googlereviews", http.HandlerFunc(GoogleReviewsHandler))
 	// mux.HandleFunc("/googlereviews", GoogleReviewsHandler)
-	mux.Handle("/googlereviews", GoogleReviewsHandler())
+	mux.Handle("/googlereviews", instrument("/googlereviews", GoogleReviewsHandler()))
+	// simulate (dry run) requests returning a trace of each step, nothing is sent or written to the database (the log
+	// token is required, see SimulateHandler)
+	mux.Handle("/googlereviews"+simulatePath, instrument("/googlereviews"+simulatePath, SimulateHandler(config.Conf.LogToken, GoogleReviewsSimulateHandler())))
 	// mux.HandleFunc("/googlereviews", func(w http.ResponseWriter, req *http.Request) {
 	// 	w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
 	// 	w.Write([]byte("This is the response from server.\n"))
 	// })
 	mux.HandleFunc("/logs", basicAuth(logviewer, "Please enter your username and password"))
 	mux.Handle("/checklogs", CheckLogHandler(logFileName, config.Conf.LogToken))
+	mux.Handle("/invalidateconfig", InvalidateConfigHandler(config.Conf.LogToken))
+	// barred telephones, reloaded when changed from google_reviews_ui and fetched by send_sms
+	mux.Handle("/reloadbarred", ReloadBarredHandler(config.Conf.LogToken))
+	mux.Handle("/barred", BarredHandler(config.Conf.BarredToken))
 	mux.Handle("/rmsgpair", ReviewMasterSMSGatewayPairingHandler(config.Conf.ReviewMasterSMSGatewayPairingToken))
-	mux.Handle("/cordic", CordicHandler())
-	mux.Handle("/cab9", Cab9Handler())
+	mux.Handle("/cordic", instrument("/cordic", CordicHandler()))
+	mux.Handle("/cordic"+simulatePath, instrument("/cordic"+simulatePath, SimulateHandler(config.Conf.LogToken, CordicSimulateHandler())))
+	mux.Handle("/cab9", instrument("/cab9", Cab9Handler()))
+	mux.Handle("/cab9"+simulatePath, instrument("/cab9"+simulatePath, SimulateHandler(config.Conf.LogToken, Cab9SimulateHandler())))
+	// generic dispatcher webhook, the config token is in the path (/hook/<token> or /hook/<token>/simulate)
+	mux.Handle(hookPath, hookRouter(instrument("/hook", HookHandler()), instrument("/hook"+simulatePath, SimulateHandler(config.Conf.LogToken, HookSimulateHandler()))))
+	// replies from passengers (opt out)
+	mux.Handle("/reply/rmsg", instrument("/reply/rmsg", ReviewMasterSMSGatewayReplyHandler()))
+	mux.Handle("/reply/messagemedia", instrument("/reply/messagemedia", MessageMediaReplyHandler()))
+	mux.Handle("/reply/sms", instrument("/reply/sms", SendSMSReplyHandler()))
+	// delivery status callbacks
+	mux.Handle("/twilio/status", instrument("/twilio/status", TwilioStatusHandler()))
+	mux.Handle("/dlr/messagemedia", instrument("/dlr/messagemedia", MessageMediaDLRHandler()))
+	mux.Handle("/dlr/rmsg", instrument("/dlr/rmsg", ReviewMasterSMSGatewayDLRHandler()))
+	// WhatsApp webhook (message statuses and replies)
+	mux.Handle("/whatsapp/webhook", instrument("/whatsapp/webhook", WhatsAppWebhookHandler()))
+	// email unsubscribe links
+	mux.Handle(email.UnsubscribePath, instrument(email.UnsubscribePath, EmailUnsubscribeHandler()))
+	// tracked short review links
+	mux.Handle(shortLinkPath, instrument(shortLinkPath, ShortLinkHandler()))
+	// metrics (Prometheus)
+	mux.Handle("/metrics", metrics.Handler(config.Conf.MetricsToken, config.Conf.MetricsAllowedIPs))
 
 	cfg := &tls.Config{
 		MinVersion:               tls.VersionTLS12,
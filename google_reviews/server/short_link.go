@@ -0,0 +1,60 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"google_reviews/config"
+	"google_reviews/database"
+	"google_reviews/utils"
+)
+
+// shortLinkPath - path of the short link redirect endpoint e.g. /r/aB3dE5gH
+const shortLinkPath = "/r/"
+
+// shortLinkCodeAttempts - number of codes to try when adding a short link (in case a code is already used)
+const shortLinkCodeAttempts = 3
+
+// trackReviewLink - replace the review link placeholder value with a tracked short link when the message uses
+// the review link and short links are configured, returns the short link id (0 if the review link is not tracked)
+func trackReviewLink(message string, values map[string]string, clientID uint64, variant string) uint64 {
+	reviewLink := values[utils.PlaceholderReviewLink]
+	if config.Conf.ShortLinkBaseURL == "" || reviewLink == "" || !strings.Contains(message, "{"+utils.PlaceholderReviewLink) {
+		return 0
+	}
+	for i := 0; i < shortLinkCodeAttempts; i++ {
+		code := utils.NewShortLinkCode()
+		if code == "" {
+			continue
+		}
+		if id := database.AddShortLink(code, clientID, variant, reviewLink); id != 0 {
+			values[utils.PlaceholderReviewLink] = strings.TrimSuffix(config.Conf.ShortLinkBaseURL, "/") + shortLinkPath + code
+			return id
+		}
+	}
+	log.Printf("unable to add a short link for clientID: %d, sending the review link as is\n", clientID)
+	return 0
+}
+
+// ShortLinkHandler - record a click on a tracked short link and redirect to the review link
+func ShortLinkHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+
+		code := strings.TrimPrefix(req.URL.Path, shortLinkPath)
+		if !utils.ValidShortLinkCode(code) {
+			http.NotFound(w, req)
+			return
+		}
+		sl, found := database.ShortLinkFromCode(code)
+		if !found {
+			http.NotFound(w, req)
+			return
+		}
+		database.AddShortLinkClick(sl, req.UserAgent())
+		http.Redirect(w, req, sl.URL, http.StatusFound)
+	}
+
+	return http.HandlerFunc(fn)
+}
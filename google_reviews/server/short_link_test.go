@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google_reviews/config"
+	"google_reviews/database"
+	"google_reviews/utils"
+)
+
+func TestShortLinkHandler(t *testing.T) {
+	prepareTestDatabase()
+	req, err := http.NewRequest("GET", "/r/aB3dE5gH", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	rr := httptest.NewRecorder()
+	handler := http.Handler(ShortLinkHandler())
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://g.page/r/test/review" {
+		t.Errorf("handler redirected to: %s want https://g.page/r/test/review", location)
+	}
+}
+
+func TestShortLinkHandlerNotFound(t *testing.T) {
+	prepareTestDatabase()
+	for _, path := range []string{"/r/zzzzzzzz", "/r/", "/r/aB3dE5gH/x"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.Handler(ShortLinkHandler())
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v", path, status, http.StatusNotFound)
+		}
+	}
+}
+
+func TestTrackReviewLink(t *testing.T) {
+	prepareTestDatabase()
+	baseURL := config.Conf.ShortLinkBaseURL
+	defer func() { config.Conf.ShortLinkBaseURL = baseURL }()
+
+	// not configured
+	config.Conf.ShortLinkBaseURL = ""
+	values := map[string]string{utils.PlaceholderReviewLink: "https://g.page/r/test/review"}
+	if id := trackReviewLink("Review us {review_link}", values, 1, ""); id != 0 {
+		t.Fatal("review link should not be tracked when short links are not configured")
+	}
+
+	config.Conf.ShortLinkBaseURL = "https://reviews.example.com/"
+	// message does not use the review link
+	if id := trackReviewLink("Thank you", values, 1, ""); id != 0 {
+		t.Fatal("review link should not be tracked when not in the message")
+	}
+	id := trackReviewLink("Review us {review_link}", values, 1, "1")
+	if id == 0 {
+		t.Fatal("review link should be tracked")
+	}
+	code := values[utils.PlaceholderReviewLink][len("https://reviews.example.com/r/"):]
+	sl, found := database.ShortLinkFromCode(code)
+	if !found || sl.ID != id || sl.URL != "https://g.page/r/test/review" || sl.Variant != "1" {
+		t.Fatalf("unexpected short link: %+v for review link: %s", sl, values[utils.PlaceholderReviewLink])
+	}
+}
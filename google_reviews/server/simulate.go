@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"google_reviews/database"
+	"google_reviews/logging"
+	"google_reviews/sender"
+)
+
+// simulatePath - appended to the dispatcher webhook paths to simulate a request e.g. /googlereviews/simulate
+const simulatePath = "/simulate"
+
+// failedSendRetryMinutes - how long before a message not sent because of a transient error is sent by the send later
+// worker (see deferFailedSend)
+const failedSendRetryMinutes = 5
+
+// simulation - trace of a simulated (dry run) request, the full pipeline is run but nothing is sent or written
+// to the database. The handlers use a nil simulation for a real request, the methods then write to the database
+// and send as normal.
+type simulation struct {
+	Simulate bool   `json:"simulate"`
+	Outcome  string `json:"outcome"`
+	Response string `json:"response"`
+	Steps    []step `json:"steps"`
+}
+
+// step - a step of the pipeline and its result
+type step struct {
+	Step   string      `json:"step"`
+	Result string      `json:"result"`
+	Detail interface{} `json:"detail,omitempty"`
+}
+
+// newSimulation - simulation when simulating a request otherwise nil
+func newSimulation(simulate bool) *simulation {
+	if !simulate {
+		return nil
+	}
+	return &simulation{Simulate: true}
+}
+
+// step - add a step to the trace
+func (sim *simulation) step(name string, result string, detail interface{}) {
+	if sim == nil {
+		return
+	}
+	sim.Steps = append(sim.Steps, step{Step: name, Result: result, Detail: detail})
+}
+
+// write - write the response, when simulating the trace is written instead
+func (sim *simulation) write(w http.ResponseWriter, resp []byte) {
+	sim.writeStatus(w, http.StatusOK, resp)
+}
+
+// writeStatus - write the response with the status code, when simulating the trace is written instead
+func (sim *simulation) writeStatus(w http.ResponseWriter, statusCode int, resp []byte) {
+	if sim == nil {
+		w.WriteHeader(statusCode)
+		w.Write(resp)
+		return
+	}
+	sim.Response = string(resp)
+	if sim.Outcome == "" {
+		sim.Outcome = "not_sent"
+	}
+	trace, err := json.Marshal(sim)
+	if err != nil {
+		log.Printf("Error marshalling simulation trace, err: %v\n", err)
+		w.WriteHeader(statusCode)
+		w.Write(resp)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(trace)
+}
+
+// SimulateHandler - require the log token (sent by the google_reviews_ui send test) to simulate a request, the trace
+// shows the last sent of the telephone and the request that would be sent to the provider
+// e.g. /googlereviews/simulate?log_token=<log token>
+func SimulateHandler(logTk string, handler http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		logToken := strings.TrimSpace(req.URL.Query().Get("log_token"))
+		if logToken == "" || subtle.ConstantTimeCompare([]byte(logToken), []byte(logTk)) != 1 {
+			log.Printf("Error, log_token is incorrect for simulating a request to %s\n", req.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// addMessageEvent - add a message event (the reason is the outcome when simulating)
+func (sim *simulation) addMessageEvent(clientID uint64, telephone string, channel string, reason string, providerResponse string, latency time.Duration) uint64 {
+	return sim.addMessageEventWithVariant(clientID, telephone, channel, reason, "", providerResponse, latency)
+}
+
+// addMessageEventWithVariant - add a message event with the variant sent (the reason is the outcome when simulating)
+func (sim *simulation) addMessageEventWithVariant(clientID uint64, telephone string, channel string, reason string, variant string, providerResponse string, latency time.Duration) uint64 {
+	if sim == nil {
+		return database.AddMessageEventWithVariant(clientID, telephone, channel, reason, variant, providerResponse, latency)
+	}
+	sim.Outcome = reason
+	sim.step("message_event", reason, map[string]interface{}{"client_id": clientID, "telephone": telephone, "channel": channel, "variant": variant})
+	return 0
+}
+
+// updateStatsCanUseToken - update the stats (not updated when simulating)
+func (sim *simulation) updateStatsCanUseToken(clientID uint64, token string, sent bool) {
+	if sim == nil {
+		database.UpdateStatsCanUseToken(clientID, token, sent)
+	}
+}
+
+// updateLastSent - update the last sent (not updated when simulating)
+func (sim *simulation) updateLastSent(telephone string, clientID uint64, sentCount uint) {
+	if sim == nil {
+		database.UpdateLastSent(telephone, clientID, sentCount)
+	}
+}
+
+// setShortLinkMessageEvent - link the short link to the message event (not linked when simulating)
+func (sim *simulation) setShortLinkMessageEvent(shortLinkID uint64, messageEventID uint64) {
+	if sim == nil {
+		database.SetShortLinkMessageEvent(shortLinkID, messageEventID)
+	}
+}
+
+// trackReviewLink - replace the review link with a tracked short link (see trackReviewLink), when simulating
+// no short link is added so the review link is left as is
+func (sim *simulation) trackReviewLink(message string, values map[string]string, clientID uint64, variant string) uint64 {
+	if sim == nil {
+		return trackReviewLink(message, values, clientID, variant)
+	}
+	return 0
+}
+
+// sendLater - store the request to be sent later (the request is traced when simulating)
+func (sim *simulation) sendLater(s sender.MessageSender, m sender.Message, r sender.Request, sendAfterMinutes int) {
+	if sim == nil {
+		s.SendLater(m, r, sendAfterMinutes)
+		return
+	}
+	sim.request("send_later", s, r, map[string]interface{}{"send_after_minutes": sendAfterMinutes})
+}
+
+// deferFailedSend - defer the message not sent because of a transient error (e.g. the message service timed out or its
+// circuit breaker is open, see sender.MessageSender) so it is sent by the send later worker rather than lost, returns
+// the message event of the deferred message
+func (sim *simulation) deferFailedSend(ctx context.Context, s sender.MessageSender, m sender.Message, r sender.Request,
+	variant string, providerResp string, latency time.Duration, sendErr error) uint64 {
+	logging.FromContext(ctx).Warn("Message not sent, deferred to be sent later", logging.FieldClientID, m.ClientID,
+		"channel", s.Name(), "url", r.URL, "error", sendErr.Error())
+	sim.sendLater(s, m, r, failedSendRetryMinutes)
+	if sim == nil {
+		sendsDeferredTotal.Inc(s.Name())
+	}
+	return sim.addMessageEventWithVariant(m.ClientID, m.Telephone, s.Name(), database.ReasonDeferred, variant, providerResp, latency)
+}
+
+// holdFallback - hold the fallback whilst the message sent via s is delivered (see sender.HoldFallback), the fallback
+// request is traced when simulating
+func (sim *simulation) holdFallback(s sender.MessageSender, fallback sender.MessageSender, m sender.Message) {
+	if sim == nil {
+		sender.HoldFallback(s, fallback, m)
+		return
+	}
+	if fallback != nil && s != fallback {
+		sim.request("fallback", fallback, fallback.BuildRequest(m), nil)
+	}
+}
+
+// request - add the request that would be sent to the trace
+func (sim *simulation) request(name string, s sender.MessageSender, r sender.Request, detail map[string]interface{}) {
+	if sim == nil {
+		return
+	}
+	p, err := sender.Preview(r)
+	if err != nil {
+		sim.step(name, "error", err.Error())
+		return
+	}
+	if detail == nil {
+		detail = make(map[string]interface{})
+	}
+	detail["provider"] = s.Name()
+	detail["request"] = p
+	sim.step(name, "not_sent", detail)
+}
+
+// configDetail - config details added to the trace
+func configDetail(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, ignoreTimeAndSentCountCheck bool) map[string]interface{} {
+	return map[string]interface{}{
+		"client_id":                         grcftwc.ClientID,
+		"dispatcher_type":                   grcftwc.DispatcherType,
+		"ignore_time_and_sent_count_checks": ignoreTimeAndSentCountCheck,
+	}
+}
+
+// fallbackDetail - fallback message service added to the trace (nil when there is no fallback)
+func fallbackDetail(fallback sender.MessageSender) map[string]interface{} {
+	if fallback == nil {
+		return nil
+	}
+	return map[string]interface{}{"fallback": fallback.Name()}
+}
+
+// lastSentDetail - last sent details added to the trace
+func lastSentDetail(lastSent time.Time, sentCount uint, stop bool, found bool) map[string]interface{} {
+	detail := map[string]interface{}{"found": found}
+	if found {
+		detail["last_sent"] = lastSent
+		detail["sent_count"] = sentCount
+		detail["stop"] = stop
+	}
+	return detail
+}
+
+// checkedResult - result of a check that can be ignored
+func checkedResult(checked bool) string {
+	if checked {
+		return "checked"
+	}
+	return "ignored"
+}
+
+// passedResult - result of a check
+func passedResult(passed bool) string {
+	if passed {
+		return "passed"
+	}
+	return "failed"
+}
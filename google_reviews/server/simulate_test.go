@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"google_reviews/config"
+	"google_reviews/database"
+)
+
+// simulate - send the request to the simulate handler returning the trace
+func simulate(t *testing.T, handler http.Handler, body string) simulation {
+	req, err := http.NewRequest("POST", "/simulate", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var sim simulation
+	if err := json.Unmarshal(rr.Body.Bytes(), &sim); err != nil || !sim.Simulate {
+		t.Fatalf("handler returned unexpected body: %s, err: %v", rr.Body.String(), err)
+	}
+	return sim
+}
+
+// simulationStep - the last step in the trace with the name
+func simulationStep(sim simulation, name string) (step, bool) {
+	for i := len(sim.Steps) - 1; i >= 0; i-- {
+		if sim.Steps[i].Step == name {
+			return sim.Steps[i], true
+		}
+	}
+	return step{}, false
+}
+
+func TestGoogleReviewsSimulateHandler(t *testing.T) {
+	prepareTestDatabase()
+	sim := simulate(t, GoogleReviewsSimulateHandler(),
+		"t="+testTelephone+
+			"&message="+url.QueryEscape("Test Message 1 from Review Master SMS Gateway")+
+			"&gr_token="+url.QueryEscape("OYBpBsZ9OhR-nbsupMQU_hCTJuaabtZ1gsfsfp"))
+	if sim.Outcome != database.ReasonSent {
+		t.Fatalf("expected outcome %s got %s, trace: %+v", database.ReasonSent, sim.Outcome, sim.Steps)
+	}
+	for _, name := range []string{"config", "provider", "telephone", "barred", "last_sent", "message", "dispatcher_check", "send"} {
+		if _, ok := simulationStep(sim, name); !ok {
+			t.Errorf("trace missing the %s step: %+v", name, sim.Steps)
+		}
+	}
+	s, _ := simulationStep(sim, "send")
+	detail, _ := s.Detail.(map[string]interface{})
+	request, _ := detail["request"].(map[string]interface{})
+	if s.Result != "not_sent" || request["url"] != config.Conf.ReviewMasterSMSGatewayURL {
+		t.Errorf("unexpected send step: %+v", s)
+	}
+	if headers, _ := request["headers"].(map[string]interface{}); headers["Api-Token"] != "[redacted]" {
+		t.Errorf("expected the api token to be redacted: %+v", headers)
+	}
+
+	// nothing written to the database (the telephone has not been sent to before)
+	c, _ := simulationStep(sim, "config")
+	clientID, _ := c.Detail.(map[string]interface{})["client_id"].(float64)
+	tel, _ := simulationStep(sim, "telephone")
+	telephone, _ := tel.Detail.(map[string]interface{})["telephone"].(string)
+	if _, _, _, found := database.LastSentFromTelephoneAndClient(telephone, uint64(clientID)); found {
+		t.Errorf("last sent updated when simulating for telephone: %s, clientID: %v", telephone, clientID)
+	}
+}
+
+func TestGoogleReviewsSimulateHandlerRedactsSecrets(t *testing.T) {
+	prepareTestDatabase()
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/simulate", strings.NewReader(
+		"t="+testTelephone+
+			"&message="+url.QueryEscape("Message 1")+
+			"&gr_token="+url.QueryEscape("OYBpBsZ9OhR-nbsupMQU_hCTJuaabtZ1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	GoogleReviewsSimulateHandler().ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "api.icabbi.com") {
+		t.Fatalf("expected the iCabbi App request in the trace: %s", rr.Body.String())
+	}
+	// the iCabbi app and secret keys of the config
+	for _, secret := range []string{"a678975e61280c82f77d7d4804091f68cad12345", "d89010ad22426ab3d52b26d2ad869c650be14567"} {
+		if strings.Contains(rr.Body.String(), secret) {
+			t.Errorf("secret %s in the simulate response: %s", secret, rr.Body.String())
+		}
+	}
+}
+
+func TestGoogleReviewsSimulateHandlerIncorrectToken(t *testing.T) {
+	prepareTestDatabase()
+	sim := simulate(t, GoogleReviewsSimulateHandler(), "t="+testTelephone+"&gr_token=incorrect")
+	if s, ok := simulationStep(sim, "config"); !ok || s.Result != "rejected" {
+		t.Errorf("expected the config to be rejected: %+v", sim.Steps)
+	}
+	if sim.Outcome != "not_sent" || sim.Response != string(failedResponse) {
+		t.Errorf("unexpected outcome: %s, response: %s", sim.Outcome, sim.Response)
+	}
+}
+
+func TestCordicSimulateHandler(t *testing.T) {
+	prepareTestDatabase()
+	sim := simulate(t, CordicSimulateHandler(),
+		"gr_token="+url.QueryEscape("qey-FMF9Wun-dAJQ6Ri1wBv1hh7DsjcH7QRM7WMDv9MUeEdgNFwgW4pYxedTjfV8")+
+			"&passenger_id=abcdefghijklmno"+
+			"&booking_creation_time="+url.QueryEscape(time.Now().Add(time.Minute*-30).Format(time.RFC3339))+
+			"&booked_for_time="+url.QueryEscape(time.Now().Add(time.Minute*-25).Format(time.RFC3339)))
+	if s, ok := simulationStep(sim, "dispatcher_check"); !ok || s.Result != "failed" {
+		t.Errorf("expected the dispatcher check to fail without the picked up time: %+v", sim.Steps)
+	}
+	if sim.Response != string(cordicFailedResponse) {
+		t.Errorf("unexpected response: %s", sim.Response)
+	}
+}
+
+func TestSimulateHandler(t *testing.T) {
+	prepareTestDatabase()
+	for _, tc := range []struct {
+		logToken string
+		status   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"rubbishToken", http.StatusUnauthorized},
+		{testLogToken, http.StatusOK},
+	} {
+		req, err := http.NewRequest("POST", "/googlereviews/simulate?log_token="+url.QueryEscape(tc.logToken),
+			strings.NewReader("t="+testTelephone+"&gr_token=incorrect"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		SimulateHandler(testLogToken, GoogleReviewsSimulateHandler()).ServeHTTP(rr, req)
+		if status := rr.Code; status != tc.status {
+			t.Errorf("handler returned wrong status code for log token %q: got %v want %v", tc.logToken, status, tc.status)
+		}
+	}
+}
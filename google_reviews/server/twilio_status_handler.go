@@ -0,0 +1,111 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"google_reviews/config"
+	"google_reviews/database"
+	"google_reviews/sender"
+)
+
+// twilioSignatureHeader - header with the signature of a Twilio request
+// (see: https://www.twilio.com/docs/usage/security#validating-requests)
+const twilioSignatureHeader = "X-Twilio-Signature"
+
+// twilioDeliveryStatuses - the Twilio message statuses recorded (the final statuses), the others
+// (e.g. queued, sending, sent) are acknowledged and ignored
+var twilioDeliveryStatuses = map[string]string{
+	"delivered":   database.DeliveryStatusDelivered,
+	"undelivered": database.DeliveryStatusUndelivered,
+	"failed":      database.DeliveryStatusFailed,
+}
+
+// twilioSignature - the base64 HMAC-SHA1 (keyed with the auth token) of the URL followed by each of the POST
+// parameters sorted by name with the name and value appended
+func twilioSignature(authToken string, callbackURL string, form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(callbackURL)
+	for _, k := range keys {
+		for _, v := range form[k] {
+			b.WriteString(k)
+			b.WriteString(v)
+		}
+	}
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validTwilioAccountSID - check the account SID is AC followed by 32 hex characters
+func validTwilioAccountSID(accountSID string) bool {
+	if len(accountSID) != 34 || !strings.HasPrefix(accountSID, "AC") {
+		return false
+	}
+	for _, c := range accountSID[2:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// twilioRequestVerified - verify the signature of the request with the auth tokens of the configs sending via
+// the Twilio account, the URL signed is the configured status callback URL (the URL requested when not configured)
+func twilioRequestVerified(req *http.Request) bool {
+	accountSID := req.PostForm.Get("AccountSid")
+	signature := req.Header.Get(twilioSignatureHeader)
+	if signature == "" || !validTwilioAccountSID(accountSID) {
+		return false
+	}
+	callbackURL := config.Conf.TwilioStatusCallbackURL
+	if callbackURL == "" {
+		callbackURL = "https://" + req.Host + req.URL.RequestURI()
+	}
+	for _, authToken := range database.AlternateMessageServiceSecrets(sender.Twilio, "/Accounts/"+accountSID+"/") {
+		if hmac.Equal([]byte(signature), []byte(twilioSignature(authToken, callbackURL, req.PostForm))) {
+			return true
+		}
+	}
+	return false
+}
+
+// TwilioStatusHandler - handle the Twilio message status callbacks (the StatusCallback sent with each message),
+// the delivered, undelivered and failed statuses are recorded against the message event of the message sent
+// e.g. MessageSid=SM...&MessageStatus=delivered&AccountSid=AC...&ErrorCode=30003
+func TwilioStatusHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+
+		if err := req.ParseForm(); err != nil {
+			log.Printf("Error parsing Twilio status callback, err: %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(replyFailedResponse)
+			return
+		}
+		if !twilioRequestVerified(req) {
+			log.Printf("Error, Twilio status callback signature is incorrect for account SID: %s\n", req.PostForm.Get("AccountSid"))
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(replyFailedResponse)
+			return
+		}
+		if status, ok := twilioDeliveryStatuses[strings.TrimSpace(req.PostForm.Get("MessageStatus"))]; ok {
+			recordDeliveryStatus(sender.Twilio, strings.TrimSpace(req.PostForm.Get("MessageSid")), status, req.PostForm.Get("ErrorCode"))
+		}
+		w.Write(replySuccessResponse)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google_reviews/config"
+	"google_reviews/database"
+)
+
+const testTwilioAccountSID = "AC0123456789abcdef0123456789abcdef"
+
+// twilioStatusRequest - post the status callback signed with the auth token (unsigned when empty)
+func twilioStatusRequest(t *testing.T, form url.Values, authToken string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/twilio/status", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if authToken != "" {
+		req.Header.Set(twilioSignatureHeader, twilioSignature(authToken, config.Conf.TwilioStatusCallbackURL, form))
+	}
+	rr := httptest.NewRecorder()
+	TwilioStatusHandler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestTwilioStatusHandler(t *testing.T) {
+	prepareTestDatabase()
+	config.Conf.TwilioStatusCallbackURL = "https://reviews.example.com/twilio/status"
+	defer func() { config.Conf.TwilioStatusCallbackURL = "" }()
+
+	form := url.Values{"AccountSid": {testTwilioAccountSID}, "MessageSid": {"SM0123456789abcdef0123456789abcdef"},
+		"MessageStatus": {"undelivered"}, "ErrorCode": {"30003"}}
+	if rr := twilioStatusRequest(t, form, "test-twilio-auth-token"); rr.Code != http.StatusOK || rr.Body.String() != string(replySuccessResponse) {
+		t.Fatalf("unexpected response: %d %s", rr.Code, rr.Body.String())
+	}
+	var status string
+	if err := database.Db.QueryRow("SELECT delivery_status FROM google_reviews_message_events WHERE id = 4").Scan(&status); err != nil ||
+		status != database.DeliveryStatusUndelivered {
+		t.Fatalf("unexpected delivery status: %s, err: %v", status, err)
+	}
+
+	// incorrect or missing signature
+	for _, authToken := range []string{"incorrect", ""} {
+		if rr := twilioStatusRequest(t, form, authToken); rr.Code != http.StatusForbidden {
+			t.Errorf("auth token: %s, expected forbidden got: %d", authToken, rr.Code)
+		}
+	}
+}
+
+func TestTwilioSignature(t *testing.T) {
+	// example from the Twilio documentation
+	example := url.Values{"CallSid": {"CA1234567890ABCDE"}, "Caller": {"+12349013030"}, "Digits": {"1234"},
+		"From": {"+12349013030"}, "To": {"+18005551212"}}
+	if s := twilioSignature("12345", "https://mycompany.com/myapp.php?foo=1&bar=2", example); s != "0/KCTR6DLpKmkAf8muzZqo1nDgQ=" {
+		t.Errorf("unexpected signature of the Twilio example: %s", s)
+	}
+	form := url.Values{"To": {"+447123456789"}, "AccountSid": {testTwilioAccountSID}, "MessageStatus": {"sent"}}
+	signature := twilioSignature("token", "https://reviews.example.com/twilio/status", form)
+	// the parameters are signed sorted by name
+	reordered := url.Values{"MessageStatus": {"sent"}, "To": {"+447123456789"}, "AccountSid": {testTwilioAccountSID}}
+	if signature == "" || signature != twilioSignature("token", "https://reviews.example.com/twilio/status", reordered) {
+		t.Errorf("unexpected signature: %s", signature)
+	}
+	if signature == twilioSignature("token", "https://reviews.example.com/twilio/status?x=1", form) {
+		t.Error("expected the URL to be signed")
+	}
+	if !validTwilioAccountSID(testTwilioAccountSID) || validTwilioAccountSID("AC012") || validTwilioAccountSID("XX0123456789abcdef0123456789abcdef") {
+		t.Error("unexpected account SID validation")
+	}
+}
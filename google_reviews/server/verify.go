@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google_reviews/database"
+	"google_reviews/logging"
+)
+
+// signed request headers, the signature is the hex HMAC-SHA256 (keyed with the signing secret of the config) of the
+// timestamp (unix seconds), a full stop and the request body (the query string for a request without a body)
+// e.g. X-GR-Signature: hex(HMAC-SHA256(secret, "1700000000.gr_token=...&t=07123456789"))
+const (
+	signatureHeader = "X-GR-Signature"
+	timestampHeader = "X-GR-Timestamp"
+)
+
+// maxBodySize - maximum size of a request body read to verify the signature (as ParseForm)
+const maxBodySize = 10 << 20
+
+var (
+	// signatureReplayWindow - signed requests are rejected when the timestamp is further from now than the window
+	signatureReplayWindow = 5 * time.Minute
+	// verificationAlertPeriod - failed verifications are alerted at most once per client and reason every period
+	verificationAlertPeriod = 15 * time.Minute
+)
+
+// verificationAlerts - when failed verifications were last alerted and the number since, keyed by client and reason
+var verificationAlerts = struct {
+	sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+}{
+	last:       make(map[string]time.Time),
+	suppressed: make(map[string]int),
+}
+
+// readBody - read the request body so the signature can be verified, the body is replaced so the form can still be
+// parsed
+func readBody(req *http.Request) []byte {
+	if req.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize))
+	req.Body.Close()
+	if err != nil {
+		logging.FromContext(req.Context()).Error("Error reading request body to verify the request", "err", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body
+}
+
+// requestSignature - the hex HMAC-SHA256 of the timestamp and payload keyed with the secret
+func requestSignature(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkSignature - check the signature and timestamp headers of the request, returns the reason if it fails
+func checkSignature(req *http.Request, body []byte, secret string, now time.Time) string {
+	timestamp := strings.TrimSpace(req.Header.Get(timestampHeader))
+	signature := strings.ToLower(strings.TrimSpace(req.Header.Get(signatureHeader)))
+	if timestamp == "" || signature == "" {
+		return database.ReasonInvalidSignature
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return database.ReasonInvalidSignature
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > signatureReplayWindow || d < -signatureReplayWindow {
+		return database.ReasonExpiredSignature
+	}
+	payload := body
+	if len(payload) == 0 {
+		payload = []byte(req.URL.RawQuery)
+	}
+	if !hmac.Equal([]byte(signature), []byte(requestSignature(secret, timestamp, payload))) {
+		return database.ReasonInvalidSignature
+	}
+	return ""
+}
+
+// remoteIPAllowed - check whether the remote address is one of the allowed IPs or CIDRs e.g. 10.0.0.5 or 10.0.0.0/24
+func remoteIPAllowed(remoteAddr string, allowedIPs []string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, a := range allowedIPs {
+		if strings.Contains(a, "/") {
+			if _, ipNet, err := net.ParseCIDR(a); err == nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if allowed := net.ParseIP(a); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// alertVerificationFailed - log a verification failed alert for the client and reason, the alerts are rate limited
+// (see verificationAlertPeriod) with the number of failures since the last alert included
+func alertVerificationFailed(req *http.Request, handler string, clientID uint64, reason string) {
+	key := strconv.FormatUint(clientID, 10) + ":" + reason
+	now := time.Now()
+	verificationAlerts.Lock()
+	if last, ok := verificationAlerts.last[key]; ok && now.Sub(last) < verificationAlertPeriod {
+		verificationAlerts.suppressed[key]++
+		verificationAlerts.Unlock()
+		return
+	}
+	failures := verificationAlerts.suppressed[key] + 1
+	verificationAlerts.last[key] = now
+	delete(verificationAlerts.suppressed, key)
+	verificationAlerts.Unlock()
+
+	logging.FromContext(req.Context()).Error("Alert, request verification failed", logging.FieldEvent, logging.EventVerificationFailed,
+		logging.FieldClientID, clientID, logging.FieldReason, reason, "handler", handler, "remote_addr", req.RemoteAddr,
+		"failures", failures)
+}
+
+// verifyRequest - verify the request when the config of the token has allowed IPs (the request has to be from one of
+// them) or a signing secret (the request has to be signed, see checkSignature). A failed verification is recorded as
+// a message event with the reason, counted and alerted, when simulating the failure is only added to the trace (the
+// simulated request is still rejected). Returns false when the request should be rejected.
+func (sim *simulation) verifyRequest(req *http.Request, body []byte, handler string, grToken string) bool {
+	clientID, signingSecret, allowedIPs := database.RequestVerificationFromToken(grToken)
+	if clientID == 0 || (signingSecret == "" && len(allowedIPs) == 0) {
+		return true
+	}
+	reason := ""
+	if len(allowedIPs) > 0 && !remoteIPAllowed(req.RemoteAddr, allowedIPs) {
+		reason = database.ReasonIPNotAllowed
+	} else if signingSecret != "" {
+		reason = checkSignature(req, body, signingSecret, time.Now())
+	}
+	if reason == "" {
+		sim.step("verification", "verified", map[string]interface{}{"signed": signingSecret != "", "allowed_ips": len(allowedIPs) > 0})
+		return true
+	}
+	if sim != nil {
+		// the failure is rejected but not recorded (nothing is written to the database when simulating)
+		sim.step("verification", reason, map[string]interface{}{"signed": signingSecret != "", "allowed_ips": len(allowedIPs) > 0})
+		return false
+	}
+	verificationFailuresTotal.Inc(handler, reason)
+	database.AddMessageEvent(clientID, "", "", reason, "", 0)
+	alertVerificationFailed(req, handler, clientID, reason)
+	return false
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google_reviews/database"
+)
+
+// signedToken - token of the config with a signing secret and allowed IPs
+const signedToken = "sgnd7Kq2Wv9Xc4Lm8Np3Rt6Yb1Hd5Jf0"
+
+func TestCheckSignature(t *testing.T) {
+	now := time.Now()
+	body := []byte("gr_token=abc&t=07123456789")
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      []byte
+		query     string
+		want      string
+	}{
+		{"signed", timestamp, requestSignature("secret", timestamp, body), body, "", ""},
+		{"upper case signature", timestamp, strings.ToUpper(requestSignature("secret", timestamp, body)), body, "", ""},
+		{"signed query", timestamp, requestSignature("secret", timestamp, []byte("gr_token=abc")), nil, "gr_token=abc", ""},
+		{"no signature", timestamp, "", body, "", database.ReasonInvalidSignature},
+		{"no timestamp", "", requestSignature("secret", "", body), body, "", database.ReasonInvalidSignature},
+		{"wrong secret", timestamp, requestSignature("wrong", timestamp, body), body, "", database.ReasonInvalidSignature},
+		{"body changed", timestamp, requestSignature("secret", timestamp, body), []byte("gr_token=abc&t=07000000000"), "", database.ReasonInvalidSignature},
+		{"timestamp changed", strconv.FormatInt(now.Unix()-1, 10), requestSignature("secret", timestamp, body), body, "", database.ReasonInvalidSignature},
+		{"replayed", strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10),
+			requestSignature("secret", strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10), body), body, "", database.ReasonExpiredSignature},
+		{"future", strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10),
+			requestSignature("secret", strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10), body), body, "", database.ReasonExpiredSignature},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/googlereviews?"+tt.query, nil)
+		req.Header.Set(timestampHeader, tt.timestamp)
+		req.Header.Set(signatureHeader, tt.signature)
+		if got := checkSignature(req, tt.body, "secret", now); got != tt.want {
+			t.Errorf("%s: checkSignature() = %q want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemoteIPAllowed(t *testing.T) {
+	allowed := []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"}
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"10.1.2.3:1234", true},
+		{"192.0.2.1:443", true},
+		{"192.0.2.2:443", false},
+		{"[2001:db8::1]:443", true},
+		{"[2001:db9::1]:443", false},
+		{"not an ip", false},
+	}
+	for _, tt := range tests {
+		if got := remoteIPAllowed(tt.remoteAddr, allowed); got != tt.want {
+			t.Errorf("remoteIPAllowed(%s) = %v want %v", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
+
+// signedRequest - request to the handler for the config with a signing secret, signed with the secret when set
+func signedRequest(t *testing.T, path string, remoteAddr string, secret string, timestamp time.Time) *http.Request {
+	body := "gr_token=" + url.QueryEscape(signedToken) + "&t=07123456789"
+	req, err := http.NewRequest("POST", path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = remoteAddr
+	if secret != "" {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		req.Header.Set(timestampHeader, ts)
+		req.Header.Set(signatureHeader, requestSignature(secret, ts, []byte(body)))
+	}
+	return req
+}
+
+func TestGoogleReviewsHandlerVerification(t *testing.T) {
+	prepareTestDatabase()
+	tests := []struct {
+		name       string
+		remoteAddr string
+		secret     string
+		timestamp  time.Time
+		reason     string
+	}{
+		{"signed", "192.0.2.1:1234", "test-signing-secret", time.Now(), ""},
+		{"not signed", "192.0.2.1:1234", "", time.Now(), database.ReasonInvalidSignature},
+		{"wrong secret", "10.1.2.3:1234", "wrong-secret", time.Now(), database.ReasonInvalidSignature},
+		{"replayed", "10.1.2.3:1234", "test-signing-secret", time.Now().Add(-time.Hour), database.ReasonExpiredSignature},
+		{"not allowed IP", "203.0.113.5:1234", "test-signing-secret", time.Now(), database.ReasonIPNotAllowed},
+	}
+	for _, tt := range tests {
+		before := verificationFailuresTotal.Value("/googlereviews", tt.reason)
+		rr := httptest.NewRecorder()
+		GoogleReviewsHandler().ServeHTTP(rr, signedRequest(t, "/googlereviews", tt.remoteAddr, tt.secret, tt.timestamp))
+		if tt.reason == "" {
+			if rr.Code != http.StatusOK {
+				t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, rr.Code, http.StatusOK)
+			}
+			continue
+		}
+		if rr.Code != http.StatusUnauthorized || rr.Body.String() != string(failedResponse) {
+			t.Errorf("%s: expected the request to be rejected got: %v %s", tt.name, rr.Code, rr.Body.String())
+		}
+		if after := verificationFailuresTotal.Value("/googlereviews", tt.reason); after != before+1 {
+			t.Errorf("%s: expected the %s verification failure to be counted", tt.name, tt.reason)
+		}
+	}
+}
+
+func TestCab9HandlerVerification(t *testing.T) {
+	prepareTestDatabase()
+	rr := httptest.NewRecorder()
+	Cab9Handler().ServeHTTP(rr, signedRequest(t, "/cab9", "192.0.2.1:1234", "", time.Now()))
+	if rr.Code != http.StatusUnauthorized || rr.Body.String() != string(cab9FailedResponse) {
+		t.Errorf("expected the request that is not signed to be rejected got: %v %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestGoogleReviewsSimulateHandlerVerification(t *testing.T) {
+	prepareTestDatabase()
+	// the simulated request is rejected as the request would be, the failure is shown in the trace
+	req := httptest.NewRequest("POST", "/googlereviews/simulate", strings.NewReader("gr_token="+url.QueryEscape(signedToken)+"&t=07123456789"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	GoogleReviewsSimulateHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the request that is not signed to be rejected got: %v %s", rr.Code, rr.Body.String())
+	}
+	var sim simulation
+	if err := json.Unmarshal(rr.Body.Bytes(), &sim); err != nil {
+		t.Fatalf("unexpected trace: %s, err: %v", rr.Body.String(), err)
+	}
+	if s, ok := simulationStep(sim, "verification"); !ok || s.Result != database.ReasonInvalidSignature {
+		t.Errorf("expected the verification to fail: %+v", sim.Steps)
+	}
+	if _, ok := simulationStep(sim, "config"); ok {
+		t.Errorf("expected the simulation to stop after the verification: %+v", sim.Steps)
+	}
+}
+
+func TestAlertVerificationFailed(t *testing.T) {
+	req := httptest.NewRequest("POST", "/googlereviews", nil)
+	alertVerificationFailed(req, "/googlereviews", 999, database.ReasonInvalidSignature)
+	alertVerificationFailed(req, "/googlereviews", 999, database.ReasonInvalidSignature)
+	alertVerificationFailed(req, "/googlereviews", 999, database.ReasonIPNotAllowed)
+	verificationAlerts.Lock()
+	defer verificationAlerts.Unlock()
+	if n := verificationAlerts.suppressed["999:"+database.ReasonInvalidSignature]; n != 1 {
+		t.Errorf("expected 1 suppressed alert got %d", n)
+	}
+	if _, ok := verificationAlerts.last["999:"+database.ReasonIPNotAllowed]; !ok {
+		t.Error("expected an alert for another reason")
+	}
+}
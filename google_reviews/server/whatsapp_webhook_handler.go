@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"google_reviews/config"
+	"google_reviews/database"
+	"google_reviews/sender"
+)
+
+// whatsAppSignatureHeader - header with the signature of a WhatsApp webhook notification
+// (see: https://developers.facebook.com/docs/graph-api/webhooks/getting-started#event-notifications)
+const whatsAppSignatureHeader = "X-Hub-Signature-256"
+
+// whatsAppDeliveryStatuses - the WhatsApp message statuses recorded (read is taken as delivered), the others
+// (e.g. sent) are acknowledged and ignored
+var whatsAppDeliveryStatuses = map[string]string{
+	"delivered": database.DeliveryStatusDelivered,
+	"read":      database.DeliveryStatusDelivered,
+	"failed":    database.DeliveryStatusFailed,
+}
+
+// whatsAppNotification - WhatsApp webhook notification of message statuses and received messages (replies)
+// e.g. {"object":"whatsapp_business_account","entry":[{"changes":[{"field":"messages","value":{...}}]}]}
+type whatsAppNotification struct {
+	Object string `json:"object"`
+	Entry  []struct {
+		Changes []struct {
+			Field string              `json:"field"`
+			Value whatsAppChangeValue `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+type whatsAppChangeValue struct {
+	Metadata struct {
+		PhoneNumberID string `json:"phone_number_id"`
+	} `json:"metadata"`
+	Statuses []whatsAppStatus         `json:"statuses"`
+	Messages []whatsAppInboundMessage `json:"messages"`
+}
+
+// whatsAppStatus - status of a message sent, the callback data is the fallback reference sent with the message
+// e.g. {"id":"wamid...","status":"failed","recipient_id":"447123456789","biz_opaque_callback_data":"...","errors":[{"code":131026}]}
+type whatsAppStatus struct {
+	ID                    string `json:"id"`
+	Status                string `json:"status"`
+	RecipientID           string `json:"recipient_id"`
+	BizOpaqueCallbackData string `json:"biz_opaque_callback_data"`
+	Errors                []struct {
+		Code  int    `json:"code"`
+		Title string `json:"title"`
+	} `json:"errors"`
+}
+
+// whatsAppInboundMessage - message received (a reply), a text message or a template quick reply button
+// e.g. {"from":"447123456789","type":"text","text":{"body":"STOP"}}
+type whatsAppInboundMessage struct {
+	From string `json:"from"`
+	Type string `json:"type"`
+	Text struct {
+		Body string `json:"body"`
+	} `json:"text"`
+	Button struct {
+		Text string `json:"text"`
+	} `json:"button"`
+}
+
+// text - the text of the message received (the button text of a quick reply)
+func (m whatsAppInboundMessage) text() string {
+	if m.Type == "button" {
+		return m.Button.Text
+	}
+	return m.Text.Body
+}
+
+// whatsAppSignature - the hex HMAC-SHA256 (keyed with the app secret) of the body prefixed with sha256=
+func whatsAppSignature(appSecret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WhatsAppWebhookHandler - handle the WhatsApp webhook, the verification request when subscribing (GET) and the
+// notifications (POST) signed with the app secret. The delivered (read) and failed statuses are recorded against the
+// message event of the message sent, the held SMS fallback is deleted when delivered or released (sent) when failed.
+// Replies are checked for an opt out, the client is found from the phone number the reply was sent to.
+func WhatsAppWebhookHandler() http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+
+		if req.Method == http.MethodGet {
+			query := req.URL.Query()
+			if query.Get("hub.mode") != "subscribe" || config.Conf.WhatsAppVerifyToken == "" ||
+				query.Get("hub.verify_token") != config.Conf.WhatsAppVerifyToken {
+				log.Printf("Error, WhatsApp webhook verify token is incorrect\n")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write(replyFailedResponse)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(query.Get("hub.challenge")))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		body := readBody(req)
+		signature := req.Header.Get(whatsAppSignatureHeader)
+		if config.Conf.WhatsAppAppSecret == "" || signature == "" ||
+			!hmac.Equal([]byte(signature), []byte(whatsAppSignature(config.Conf.WhatsAppAppSecret, body))) {
+			log.Printf("Error, WhatsApp webhook signature is incorrect\n")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(replyFailedResponse)
+			return
+		}
+		var notification whatsAppNotification
+		if err := json.Unmarshal(body, &notification); err != nil {
+			log.Printf("Error decoding WhatsApp webhook notification, error: %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(replyFailedResponse)
+			return
+		}
+		for _, entry := range notification.Entry {
+			for _, change := range entry.Changes {
+				for _, st := range change.Value.Statuses {
+					processWhatsAppStatus(st)
+				}
+				if len(change.Value.Messages) == 0 {
+					continue
+				}
+				clientID, country := database.ClientIDAndCountryFromWhatsAppPhoneNumberID(change.Value.Metadata.PhoneNumberID)
+				for _, m := range change.Value.Messages {
+					processReply("+"+m.From, m.text(), clientID, country, sender.WhatsApp)
+				}
+			}
+		}
+		w.Write(replySuccessResponse)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// processWhatsAppStatus - record the delivery status of the message sent and delete (delivered) or release (failed)
+// the held SMS fallback, a failed message is resent by SMS without being counted again
+func processWhatsAppStatus(st whatsAppStatus) {
+	status, ok := whatsAppDeliveryStatuses[st.Status]
+	if !ok {
+		return
+	}
+	if status == database.DeliveryStatusDelivered {
+		database.DeleteFallback(st.BizOpaqueCallbackData)
+	} else if database.ReleaseFallback(st.BizOpaqueCallbackData, true) {
+		log.Printf("WhatsApp message ID: %s failed, sending the SMS fallback\n", st.ID)
+	}
+	clientID := database.UpdateDeliveryStatus(sender.WhatsApp, st.ID, status)
+	if clientID == 0 {
+		log.Printf("WhatsApp status message ID: %s not found, status: %s\n", st.ID, st.Status)
+		return
+	}
+	deliveryStatusTotal.Inc(sender.WhatsApp, status)
+	if status != database.DeliveryStatusDelivered {
+		code := 0
+		if len(st.Errors) > 0 {
+			code = st.Errors[0].Code
+		}
+		log.Printf("WhatsApp message ID: %s for clientID: %d %s, error code: %d\n", st.ID, clientID, status, code)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google_reviews/config"
+	"google_reviews/database"
+)
+
+// whatsAppWebhookRequest - post the notification signed with the app secret (unsigned when empty)
+func whatsAppWebhookRequest(t *testing.T, body string, appSecret string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/whatsapp/webhook", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if appSecret != "" {
+		req.Header.Set(whatsAppSignatureHeader, whatsAppSignature(appSecret, []byte(body)))
+	}
+	rr := httptest.NewRecorder()
+	WhatsAppWebhookHandler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestWhatsAppWebhookVerification(t *testing.T) {
+	config.Conf.WhatsAppVerifyToken = "verify-token"
+	defer func() { config.Conf.WhatsAppVerifyToken = "" }()
+
+	for verifyToken, expected := range map[string]int{"verify-token": http.StatusOK, "incorrect": http.StatusForbidden, "": http.StatusForbidden} {
+		query := url.Values{"hub.mode": {"subscribe"}, "hub.verify_token": {verifyToken}, "hub.challenge": {"1158201444"}}
+		req, err := http.NewRequest("GET", "/whatsapp/webhook?"+query.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		WhatsAppWebhookHandler().ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("verify token: %s, expected %d got: %d", verifyToken, expected, rr.Code)
+		}
+		if expected == http.StatusOK && rr.Body.String() != "1158201444" {
+			t.Errorf("expected the challenge got: %s", rr.Body.String())
+		}
+	}
+}
+
+func TestWhatsAppWebhookHandler(t *testing.T) {
+	prepareTestDatabase()
+	config.Conf.WhatsAppAppSecret = "test-whatsapp-app-secret"
+	defer func() { config.Conf.WhatsAppAppSecret = "" }()
+
+	telephone := "447123456785"
+	database.AddSendLater(telephone, 12, 0, "https://localhost/send", "POST", "", "", nil,
+		url.Values{"To": {"+" + telephone}, "Body": {"testing"}}, nil, false, false, true, "Twilio", false, "OK", 20, "ref1", false)
+
+	body := `{"object":"whatsapp_business_account","entry":[{"changes":[{"field":"messages","value":{` +
+		`"metadata":{"phone_number_id":"109876543210987"},"statuses":[{"id":"wamid.HBgMNDQ3MTIzNDU2Nzg5FQIAERgSQjA",` +
+		`"status":"failed","recipient_id":"` + telephone + `","biz_opaque_callback_data":"ref1","errors":[{"code":131026}]}]}}]}]}`
+	if rr := whatsAppWebhookRequest(t, body, "test-whatsapp-app-secret"); rr.Code != http.StatusOK || rr.Body.String() != string(replySuccessResponse) {
+		t.Fatalf("unexpected response: %d %s", rr.Code, rr.Body.String())
+	}
+	var status string
+	if err := database.Db.QueryRow("SELECT delivery_status FROM google_reviews_message_events WHERE id = 5").Scan(&status); err != nil ||
+		status != database.DeliveryStatusFailed {
+		t.Fatalf("unexpected delivery status: %s, err: %v", status, err)
+	}
+	var held, resend bool
+	if err := database.Db.QueryRow("SELECT held, resend FROM google_reviews_send_laters WHERE fallback_ref = ?", "ref1").Scan(&held, &resend); err != nil ||
+		held || !resend {
+		t.Fatalf("expected the fallback released for resending, held: %t, resend: %t, err: %v", held, resend, err)
+	}
+
+	// incorrect or missing signature
+	for _, appSecret := range []string{"incorrect", ""} {
+		if rr := whatsAppWebhookRequest(t, body, appSecret); rr.Code != http.StatusForbidden {
+			t.Errorf("app secret: %s, expected forbidden got: %d", appSecret, rr.Code)
+		}
+	}
+	if rr := whatsAppWebhookRequest(t, "{", "test-whatsapp-app-secret"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request got: %d", rr.Code)
+	}
+
+	// opt out reply, the client is found from the phone number ID
+	database.UpdateLastSent(telephone, 12, 1)
+	body = `{"object":"whatsapp_business_account","entry":[{"changes":[{"field":"messages","value":{` +
+		`"metadata":{"phone_number_id":"109876543210987"},"messages":[{"from":"` + telephone + `","type":"button","button":{"text":"STOP"}}]}}]}]}`
+	if rr := whatsAppWebhookRequest(t, body, "test-whatsapp-app-secret"); rr.Code != http.StatusOK {
+		t.Fatalf("unexpected response: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, _, stop, _ := database.LastSentFromTelephoneAndClient(telephone, 12); !stop {
+		t.Errorf("stop should have been set for telephone %s", telephone)
+	}
+}
+
+func TestWhatsAppSignature(t *testing.T) {
+	body := []byte(`{"object":"whatsapp_business_account"}`)
+	signature := whatsAppSignature("secret", body)
+	if !strings.HasPrefix(signature, "sha256=") || len(signature) != len("sha256=")+64 {
+		t.Fatalf("unexpected signature: %s", signature)
+	}
+	if signature == whatsAppSignature("other", body) || signature == whatsAppSignature("secret", []byte(`{}`)) {
+		t.Error("expected the signature to depend on the app secret and body")
+	}
+	if m := (whatsAppInboundMessage{Type: "text", Text: struct {
+		Body string `json:"body"`
+	}{Body: "STOP"}}); m.text() != "STOP" {
+		t.Errorf("unexpected text: %s", m.text())
+	}
+}
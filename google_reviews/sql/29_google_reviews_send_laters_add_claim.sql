@@ -0,0 +1,8 @@
+--
+-- NOTE: This should only be run if updating an older database to add claiming of send laters by the send later worker
+--
+ALTER TABLE `google_reviews`.`google_reviews_send_laters`
+ADD COLUMN `claimed_by` VARCHAR(255) NOT NULL DEFAULT '' AFTER `max_daily_send_count`,
+ADD COLUMN `claimed_until` DATETIME DEFAULT NULL AFTER `claimed_by`,
+ADD COLUMN `attempts` INT(10) NOT NULL DEFAULT 0 AFTER `claimed_until`,
+ADD INDEX `send_after_claimed_until` (`send_after`, `claimed_until`);
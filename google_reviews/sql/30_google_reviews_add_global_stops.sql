@@ -0,0 +1,19 @@
+--
+-- NOTE: This should only be run if updating an older database to add global stops (telephone opted out for all clients)
+--
+
+--
+-- Table structure for table `google_reviews_global_stops`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_global_stops`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_global_stops` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `telephone` VARCHAR(15) NOT NULL,
+  `created` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `telephone` (`telephone`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
@@ -0,0 +1,25 @@
+--
+-- NOTE: This should only be run if updating an older database to add message events (audit of why a message was or wasn't sent)
+--
+
+--
+-- Table structure for table `google_reviews_message_events`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_message_events`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_message_events` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `client_id` bigint(20) unsigned NOT NULL,
+  `telephone_hash` CHAR(64) NOT NULL DEFAULT '',
+  `channel` VARCHAR(50) NOT NULL DEFAULT '',
+  `reason` VARCHAR(30) NOT NULL,
+  `provider_response` VARCHAR(1024) NOT NULL DEFAULT '',
+  `latency_ms` INT unsigned NOT NULL DEFAULT 0,
+  `created` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `telephone_hash_created` (`telephone_hash`, `created`),
+  KEY `client_id_created` (`client_id`, `created`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
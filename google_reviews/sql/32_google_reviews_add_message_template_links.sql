@@ -0,0 +1,6 @@
+--
+-- NOTE: This should only be run if updating an older database to add the review and opt out links used by the message template placeholders
+--
+ALTER TABLE `google_reviews`.`google_reviews_configs`
+ADD COLUMN `review_link` VARCHAR(255) NOT NULL DEFAULT '' AFTER `email_address`,
+ADD COLUMN `opt_out_link` VARCHAR(255) NOT NULL DEFAULT '' AFTER `review_link`;
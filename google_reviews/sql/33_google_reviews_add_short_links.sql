@@ -0,0 +1,42 @@
+--
+-- NOTE: This should only be run if updating an older database to add tracked short review links and their clicks
+--
+
+--
+-- Table structure for table `google_reviews_short_links`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_short_links`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_short_links` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `code` VARCHAR(16) NOT NULL,
+  `client_id` bigint(20) unsigned NOT NULL,
+  `message_event_id` bigint(20) unsigned NOT NULL DEFAULT 0,
+  `variant` VARCHAR(50) NOT NULL DEFAULT '',
+  `url` VARCHAR(255) NOT NULL,
+  `created` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `code` (`code`),
+  KEY `client_id_created` (`client_id`, `created`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `google_reviews_short_link_clicks`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_short_link_clicks`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_short_link_clicks` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `short_link_id` bigint(20) unsigned NOT NULL,
+  `message_event_id` bigint(20) unsigned NOT NULL DEFAULT 0,
+  `user_agent` VARCHAR(255) NOT NULL DEFAULT '',
+  `created` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `short_link_id` (`short_link_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
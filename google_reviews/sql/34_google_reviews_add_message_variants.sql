@@ -0,0 +1,28 @@
+--
+-- NOTE: This should only be run if updating an older database to add A/B testing of message variants
+--
+
+--
+-- Table structure for table `google_reviews_message_variants`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_message_variants`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_message_variants` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `google_reviews_config_id` bigint(20) unsigned NOT NULL,
+  `enabled` TINYINT(1) NOT NULL DEFAULT 1,
+  `name` VARCHAR(50) NOT NULL,
+  `message` VARCHAR(2000) NOT NULL,
+  `weight` INT unsigned NOT NULL DEFAULT 1,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `google_reviews_config_id_name` (`google_reviews_config_id`, `name`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Record the message variant sent with each message event
+--
+ALTER TABLE `google_reviews`.`google_reviews_message_events`
+ADD COLUMN `variant` VARCHAR(50) NOT NULL DEFAULT '' AFTER `channel`;
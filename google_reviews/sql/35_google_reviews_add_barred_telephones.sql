@@ -0,0 +1,24 @@
+--
+-- NOTE: This should only be run if updating an older database to add barred telephones (prefixes and full numbers)
+-- managed from google_reviews_ui, a client_id of 0 bars the telephone for all clients
+--
+
+--
+-- Table structure for table `google_reviews_barred_telephones`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_barred_telephones`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_barred_telephones` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `client_id` bigint(20) unsigned NOT NULL DEFAULT 0,
+  `telephone` VARCHAR(20) NOT NULL,
+  `full_number` TINYINT(1) NOT NULL DEFAULT 0,
+  `reason` VARCHAR(255) NOT NULL,
+  `created_by` VARCHAR(100) NOT NULL,
+  `created` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `client_id_telephone_full_number` (`client_id`, `telephone`, `full_number`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
@@ -0,0 +1,24 @@
+--
+-- NOTE: This should only be run if updating an older database to add the processed bookings, a repeated delivery of
+-- a booking (keyed by client and booking ID) returns the original response rather than being processed again
+--
+
+--
+-- Table structure for table `google_reviews_processed_bookings`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_processed_bookings`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_processed_bookings` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `client_id` bigint(20) unsigned NOT NULL,
+  `booking_id` VARCHAR(100) NOT NULL,
+  `response` TEXT NULL,
+  `created` DATETIME NOT NULL,
+  `expires` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `client_id_booking_id` (`client_id`, `booking_id`),
+  KEY `expires` (`expires`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
@@ -0,0 +1,8 @@
+--
+-- NOTE: This should only be run if updating an older database to add request verification of the dispatcher webhooks,
+-- requests are signed (HMAC-SHA256 of the timestamp and body) when the config has a signing secret and are only
+-- accepted from the allowed IPs (comma separated IPs or CIDRs) when set
+--
+ALTER TABLE `google_reviews`.`google_reviews_configs`
+ADD COLUMN `signing_secret` VARCHAR(100) NOT NULL DEFAULT '' AFTER `booking_id_parameter`,
+ADD COLUMN `allowed_ips` VARCHAR(1000) NOT NULL DEFAULT '' AFTER `signing_secret`;
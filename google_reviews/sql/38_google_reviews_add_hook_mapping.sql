@@ -0,0 +1,7 @@
+--
+-- NOTE: This should only be run if updating an older database to add the generic dispatcher webhook (/hook/<token>),
+-- the mapping is a JSON object of field to JSONPath like expression used to extract the booking fields from the
+-- payload e.g. {"telephone":"$.passenger.phone","booking_id":"$.id"} (NULL when the webhook is not used)
+--
+ALTER TABLE `google_reviews`.`google_reviews_configs`
+ADD COLUMN `hook_mapping` TEXT NULL AFTER `allowed_ips`;
@@ -0,0 +1,13 @@
+--
+-- NOTE: This should only be run if updating an older database to add the alternate message service sender (e.g. the
+-- Twilio alphanumeric sender ID or messaging service SID) and the delivery status of the messages sent, the provider
+-- message ID of a message sent is recorded so the delivery status callbacks (e.g. /twilio/status) can be matched
+--
+ALTER TABLE `google_reviews`.`google_reviews_configs`
+ADD COLUMN `alternate_message_service_sender` VARCHAR(255) NOT NULL DEFAULT '' AFTER `alternate_message_service_secret1`;
+
+ALTER TABLE `google_reviews`.`google_reviews_message_events`
+ADD COLUMN `provider_message_id` VARCHAR(64) NOT NULL DEFAULT '' AFTER `provider_response`,
+ADD COLUMN `delivery_status` VARCHAR(20) NOT NULL DEFAULT '' AFTER `provider_message_id`,
+ADD COLUMN `delivery_status_updated` DATETIME NULL AFTER `delivery_status`,
+ADD KEY `channel_provider_message_id` (`channel`, `provider_message_id`);
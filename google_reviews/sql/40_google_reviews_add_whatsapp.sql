@@ -0,0 +1,23 @@
+--
+-- NOTE: This should only be run if updating an older database to add the WhatsApp channel (WhatsApp Cloud API), the
+-- message channel is the client's preferred channel (SMS or WhatsApp). When WhatsApp is preferred with SMS fallback
+-- the SMS is held as a send later (fallback_ref) whilst the WhatsApp message is delivered, it is released by the
+-- WhatsApp webhook (/whatsapp/webhook) when the WhatsApp message fails and deleted when delivered
+--
+ALTER TABLE `google_reviews`.`google_reviews_configs`
+ADD COLUMN `message_channel` VARCHAR(20) NOT NULL DEFAULT 'SMS' AFTER `alternate_message_service_sender`,
+ADD COLUMN `whatsapp_phone_number_id` VARCHAR(64) NOT NULL DEFAULT '' AFTER `message_channel`,
+ADD COLUMN `whatsapp_access_token` VARCHAR(512) NOT NULL DEFAULT '' AFTER `whatsapp_phone_number_id`,
+ADD COLUMN `whatsapp_template_name` VARCHAR(512) NOT NULL DEFAULT '' AFTER `whatsapp_access_token`,
+ADD COLUMN `whatsapp_template_language` VARCHAR(20) NOT NULL DEFAULT 'en' AFTER `whatsapp_template_name`,
+ADD COLUMN `whatsapp_template_parameters` VARCHAR(1000) NOT NULL DEFAULT '' AFTER `whatsapp_template_language`,
+ADD COLUMN `whatsapp_sms_fallback` TINYINT(1) NOT NULL DEFAULT 1 AFTER `whatsapp_template_parameters`,
+ADD KEY `whatsapp_phone_number_id` (`whatsapp_phone_number_id`);
+
+ALTER TABLE `google_reviews`.`google_reviews_send_laters`
+ADD COLUMN `fallback_ref` VARCHAR(64) NOT NULL DEFAULT '' AFTER `max_daily_send_count`,
+ADD COLUMN `held` TINYINT(1) NOT NULL DEFAULT 0 AFTER `fallback_ref`,
+ADD COLUMN `resend` TINYINT(1) NOT NULL DEFAULT 0 AFTER `held`,
+DROP INDEX `client_id_telephone`,
+ADD UNIQUE KEY `client_id_telephone` (`client_id`, `telephone`, `fallback_ref`),
+ADD KEY `fallback_ref` (`fallback_ref`);
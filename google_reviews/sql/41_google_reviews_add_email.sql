@@ -0,0 +1,31 @@
+--
+-- NOTE: This should only be run if updating an older database to add the email channel, when enabled a booking
+-- without a mobile telephone is emailed instead when it has a valid email address (email_parameter). The email last
+-- sents are kept per email address so the send frequency and maximum send count apply as for telephones, stop is set
+-- when unsubscribed (/email/unsubscribe). An empty email_template uses the default template.
+--
+ALTER TABLE `google_reviews`.`google_reviews_configs`
+ADD COLUMN `email_enabled` TINYINT(1) NOT NULL DEFAULT 0 AFTER `whatsapp_sms_fallback`,
+ADD COLUMN `email_parameter` VARCHAR(100) NOT NULL DEFAULT 'email' AFTER `email_enabled`,
+ADD COLUMN `email_subject` VARCHAR(255) NOT NULL DEFAULT '' AFTER `email_parameter`,
+ADD COLUMN `email_template` TEXT NULL AFTER `email_subject`;
+
+--
+-- Table structure for table `google_reviews_email_last_sents`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_email_last_sents`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_email_last_sents` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `email` VARCHAR(254) NOT NULL,
+  `client_id` bigint(20) unsigned NOT NULL,
+  `last_sent` DATETIME NOT NULL,
+  `last_sent_date` DATE NOT NULL,
+  `sent_count` int(10) unsigned NOT NULL DEFAULT 0,
+  `stop` TINYINT(1) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `client_id_email` (`client_id`, `email`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
@@ -0,0 +1,26 @@
+--
+-- NOTE: This should only be run if updating an older database to store the telephones of the last sents and global
+-- stops as a keyed hash (HMAC-SHA-256 with the telephone_hash_key of the config) rather than the telephone itself,
+-- and to add the data retention days of a client (0 uses the default data retention days of the config).
+--
+-- The existing rows keep their telephone until hashed, google_reviews hashes them (and rehashes the message events
+-- recorded before the key was set) when started with a telephone hash key, after which the telephone is NULL. The
+-- telephone hash key must be set in google_reviews, google_reviews_autocab and google_reviews_ui before this is run.
+--
+ALTER TABLE `google_reviews`.`clients`
+ADD COLUMN `retention_days` INT(10) UNSIGNED NOT NULL DEFAULT 0 AFTER `country`;
+
+ALTER TABLE `google_reviews`.`google_reviews_last_sents`
+MODIFY COLUMN `telephone` VARCHAR(15) NULL,
+ADD COLUMN `telephone_hash` CHAR(64) NULL AFTER `telephone`,
+ADD UNIQUE KEY `client_id_telephone_hash` (`client_id`, `telephone_hash`),
+ADD KEY `telephone_hash` (`telephone_hash`),
+ADD KEY `telephone` (`telephone`);
+
+ALTER TABLE `google_reviews`.`google_reviews_global_stops`
+MODIFY COLUMN `telephone` VARCHAR(15) NULL,
+ADD COLUMN `telephone_hash` CHAR(64) NULL AFTER `telephone`,
+ADD UNIQUE KEY `telephone_hash` (`telephone_hash`);
+
+ALTER TABLE `google_reviews`.`google_reviews_send_laters`
+ADD KEY `send_after` (`send_after`);
@@ -0,0 +1,8 @@
+--
+-- NOTE: This should only be run if updating an older database to add the delivered and failed counts of the messages
+-- sent to the stats (from the delivery status callbacks e.g. /dlr/messagemedia and /dlr/rmsg), counted on the day the
+-- message was sent. The failed count includes the undelivered messages.
+--
+ALTER TABLE `google_reviews`.`google_reviews_stats`
+ADD COLUMN `delivered_count` INT(10) UNSIGNED NOT NULL DEFAULT 0 AFTER `requested_count`,
+ADD COLUMN `failed_count` INT(10) UNSIGNED NOT NULL DEFAULT 0 AFTER `delivered_count`;
@@ -0,0 +1,12 @@
+--
+-- NOTE: This should only be run if updating an older database to add the TLS verification opt out of a config. The
+-- TLS certificates of the send URLs are verified unless the config opts out (e.g. a dispatcher or SMS gateway with a
+-- self signed certificate), the opt out is stored with the send laters so they are sent the same way. The send
+-- laters also hold the messages that could not be sent because the message service was unavailable, which are
+-- retried by the send later worker.
+--
+ALTER TABLE `google_reviews`.`google_reviews_configs`
+ADD COLUMN `tls_skip_verify` TINYINT(1) NOT NULL DEFAULT 0 AFTER `http_get`;
+
+ALTER TABLE `google_reviews`.`google_reviews_send_laters`
+ADD COLUMN `tls_skip_verify` TINYINT(1) NOT NULL DEFAULT 0 AFTER `max_daily_send_count`;
@@ -0,0 +1,7 @@
+--
+-- NOTE: This should only be run if updating an older database to add the pacing of a config. When pacing is enabled
+-- the max daily send count is spread across the config times of the day, the messages over the rate are stored as
+-- send laters to be sent later in the day.
+--
+ALTER TABLE `google_reviews`.`google_reviews_configs`
+ADD COLUMN `pacing_enabled` TINYINT(1) NOT NULL DEFAULT 0 AFTER `max_daily_send_count`;
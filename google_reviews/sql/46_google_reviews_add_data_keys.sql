@@ -0,0 +1,34 @@
+--
+-- NOTE: This should only be run if updating an older database to store the secrets of the configs and send laters
+-- (app key, secret key and alternate message service secret1) encrypted. The secrets are encrypted (AES-256-GCM) with
+-- a data key, the data keys are stored wrapped by the key in the secrets_key_file of the config, the active data key
+-- is used to encrypt. The secret columns are widened for the encrypted secrets.
+--
+-- The existing secrets are stored unencrypted until encrypted with $ ./google_reviews encryptsecrets, which must be
+-- run after the secrets key file is set in google_reviews, google_reviews_autocab and google_reviews_ui.
+--
+
+--
+-- Table structure for table `google_reviews_data_keys`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_data_keys`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_data_keys` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `wrapped_key` VARBINARY(255) NOT NULL,
+  `active` TINYINT(1) NOT NULL DEFAULT 0,
+  `created` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+ALTER TABLE `google_reviews`.`google_reviews_configs`
+MODIFY COLUMN `app_key` VARCHAR(512) NOT NULL DEFAULT '',
+MODIFY COLUMN `secret_key` VARCHAR(512) NOT NULL DEFAULT '',
+MODIFY COLUMN `alternate_message_service_secret1` VARCHAR(512) NOT NULL DEFAULT '';
+
+ALTER TABLE `google_reviews`.`google_reviews_send_laters`
+MODIFY COLUMN `app_key` VARCHAR(512) NOT NULL,
+MODIFY COLUMN `secret_key` VARCHAR(512) NOT NULL;
@@ -0,0 +1,28 @@
+--
+-- NOTE: This should only be run if updating an older database to add messages by language to the configs. The message
+-- of the booking language (the booking locale when the dispatcher sends one, else the country of the telephone)
+-- replaces the config message, the config message is sent when there is no message for the language.
+--
+
+--
+-- Table structure for table `google_reviews_config_messages`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_config_messages`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_config_messages` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `google_reviews_config_id` bigint(20) unsigned NOT NULL,
+  `language` VARCHAR(10) NOT NULL,
+  `message` VARCHAR(2000) NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `google_reviews_config_id_language` (`google_reviews_config_id`, `language`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Message variants by language (empty for the variants of the config message)
+--
+ALTER TABLE `google_reviews`.`google_reviews_message_variants`
+ADD COLUMN `language` VARCHAR(10) NOT NULL DEFAULT '' AFTER `name`;
@@ -0,0 +1,27 @@
+--
+-- NOTE: This should only be run if updating an older database to add the fatigue policies, limiting the review
+-- requests to a passenger across clients: at most max_requests clients send (or defer) a review request to a telephone
+-- in days days. The policy of partner ID 0 is for all clients (counting the review requests of all clients), the
+-- policy of a partner is for the clients of the partner (counting the review requests of the partner's clients), both
+-- are checked. The review requests are counted from the message events so days should not exceed the data retention
+-- days.
+--
+
+--
+-- Table structure for table `google_reviews_fatigue_policies`
+--
+
+DROP TABLE IF EXISTS `google_reviews`.`google_reviews_fatigue_policies`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `google_reviews`.`google_reviews_fatigue_policies` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `partner_id` BIGINT(20) NOT NULL,
+  `max_requests` INT unsigned NOT NULL,
+  `days` INT unsigned NOT NULL,
+  `updated_by` VARCHAR(100) NOT NULL DEFAULT '',
+  `updated` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `partner_id` (`partner_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
@@ -0,0 +1,16 @@
+--
+-- NOTE: This should only be run if updating an older database to store the headers and params of the send laters, the
+-- WhatsApp access token and the signing secret of the configs encrypted (see 46_google_reviews_add_data_keys.sql).
+-- The headers and params of the send laters carry secrets (e.g. the iCabbi app and secret keys, the Veezu auth token
+-- and the WhatsApp access token). The columns are widened for the encrypted values.
+--
+-- The existing values are stored unencrypted until encrypted with $ ./google_reviews encryptsecrets.
+--
+
+ALTER TABLE `google_reviews`.`google_reviews_configs`
+MODIFY COLUMN `whatsapp_access_token` VARCHAR(1024) NOT NULL DEFAULT '',
+MODIFY COLUMN `signing_secret` VARCHAR(512) NOT NULL DEFAULT '';
+
+ALTER TABLE `google_reviews`.`google_reviews_send_laters`
+MODIFY COLUMN `http_headers` BLOB,
+MODIFY COLUMN `http_params` TEXT;
@@ -0,0 +1,40 @@
+package utils
+
+import "testing"
+
+func TestLanguageFromLocale(t *testing.T) {
+	tests := map[string]string{
+		"nl":      "nl",
+		"nl-BE":   "nl",
+		"fr_BE":   "fr",
+		" NL ":    "nl",
+		"fil-PH":  "fil",
+		"":        "",
+		"n":       "",
+		"english": "",
+		"12-NL":   "",
+	}
+	for locale, want := range tests {
+		if got := LanguageFromLocale(locale); got != want {
+			t.Errorf("LanguageFromLocale(%q) = %q, want %q", locale, got, want)
+		}
+	}
+}
+
+func TestLanguageFromTelephone(t *testing.T) {
+	tests := map[string]string{
+		"31612345678":   "nl",
+		"+31612345678":  "nl",
+		"447123456789":  "en",
+		"32470123456":   "nl",
+		"33612345678":   "fr",
+		"4915112345678": "de",
+		"":              "",
+		"999":           "",
+	}
+	for telephone, want := range tests {
+		if got := LanguageFromTelephone(telephone); got != want {
+			t.Errorf("LanguageFromTelephone(%q) = %q, want %q", telephone, got, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// message template placeholders, filled from request parameters or dispatcher booking data
+const (
+	PlaceholderFirstName  = "first_name"
+	PlaceholderDriverName = "driver_name"
+	PlaceholderPickupTime = "pickup_time"
+	PlaceholderCompany    = "company"
+	PlaceholderReviewLink = "review_link"
+	PlaceholderOptOutLink = "opt_out_link"
+)
+
+// placeholderDefaults - safe default used when a placeholder has no value, a default can also be
+// given in the message e.g. {first_name|there}
+var placeholderDefaults = map[string]string{
+	PlaceholderFirstName:  "",
+	PlaceholderDriverName: "your driver",
+	PlaceholderPickupTime: "",
+	PlaceholderCompany:    "us",
+	PlaceholderReviewLink: "",
+	PlaceholderOptOutLink: "",
+}
+
+// placeholderRegexp - matches {name} and {name|default}
+var placeholderRegexp = regexp.MustCompile(`\{([a-z_]+)(\|[^{}]*)?\}`)
+
+// spaceBeforePunctuationRegexp - space left before punctuation when a placeholder is empty e.g. "Hi {first_name},"
+var spaceBeforePunctuationRegexp = regexp.MustCompile(` +([,.!?;:])`)
+
+// IsPlaceholder - check whether the name is a known message template placeholder
+func IsPlaceholder(name string) bool {
+	_, ok := placeholderDefaults[name]
+	return ok
+}
+
+// FillMessageTemplate - replace the placeholders in the message with the values (keyed by placeholder name),
+// falling back to the default in the message or the safe default, unknown placeholders are left as is
+func FillMessageTemplate(message string, values map[string]string) string {
+	if !strings.Contains(message, "{") {
+		return message
+	}
+	empty := false
+	filled := placeholderRegexp.ReplaceAllStringFunc(message, func(p string) string {
+		m := placeholderRegexp.FindStringSubmatch(p)
+		def, ok := placeholderDefaults[m[1]]
+		if !ok {
+			return p
+		}
+		if v := strings.TrimSpace(values[m[1]]); v != "" {
+			return v
+		}
+		if m[2] != "" {
+			def = strings.TrimPrefix(m[2], "|")
+		}
+		if def == "" {
+			empty = true
+		}
+		return def
+	})
+	if empty {
+		// tidy up the gaps left by empty placeholders
+		filled = strings.Join(strings.FieldsFunc(filled, func(r rune) bool { return r == ' ' }), " ")
+		filled = spaceBeforePunctuationRegexp.ReplaceAllString(filled, "$1")
+		filled = strings.TrimSpace(filled)
+	}
+	return filled
+}
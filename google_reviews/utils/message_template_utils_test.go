@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestFillMessageTemplate(t *testing.T) {
+	values := map[string]string{
+		PlaceholderFirstName:  "Jane",
+		PlaceholderDriverName: "Bob",
+		PlaceholderPickupTime: "14:30",
+		PlaceholderCompany:    "ABC Cars",
+		PlaceholderReviewLink: "https://g.page/abc/review",
+	}
+	tests := []struct {
+		message  string
+		values   map[string]string
+		expected string
+	}{
+		{"Thank you for travelling with us", values, "Thank you for travelling with us"},
+		{"Hi {first_name}, how was {driver_name}? {review_link}", values, "Hi Jane, how was Bob? https://g.page/abc/review"},
+		{"Your {pickup_time} trip with {company}", values, "Your 14:30 trip with ABC Cars"},
+		{"Hi {first_name}, how was {driver_name}? Thanks from {company}", nil, "Hi, how was your driver? Thanks from us"},
+		{"Hi {first_name|there}, thanks", nil, "Hi there, thanks"},
+		{"Hi {first_name|there}, thanks", values, "Hi Jane, thanks"},
+		{"Review {review_link} reply STOP {opt_out_link}", nil, "Review reply STOP"},
+		{"Hi {name}, {first_name}", values, "Hi {name}, Jane"},
+		{"Hi {first_name}", map[string]string{PlaceholderFirstName: " "}, "Hi"},
+	}
+	for _, tt := range tests {
+		result := FillMessageTemplate(tt.message, tt.values)
+		if result != tt.expected {
+			t.Errorf("FillMessageTemplate(%q) = %q expected %q", tt.message, result, tt.expected)
+		}
+	}
+}
+
+func TestIsPlaceholder(t *testing.T) {
+	if !IsPlaceholder(PlaceholderReviewLink) {
+		t.Errorf("IsPlaceholder(%q) = false expected true", PlaceholderReviewLink)
+	}
+	if IsPlaceholder("name") {
+		t.Errorf("IsPlaceholder(%q) = true expected false", "name")
+	}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// optOutKeywords - keywords that when replied (first word of message) mean do not send any more messages
+// includes localised variants for the countries the clients operate in
+var optOutKeywords = map[string]bool{
+	"STOP":        true,
+	"STOPP":       true, // German, Swedish, Norwegian
+	"UNSUBSCRIBE": true,
+	"OPTOUT":      true,
+	"END":         true,
+	"CANCEL":      true,
+	"QUIT":        true,
+	"AFMELDEN":    true, // Dutch
+	"ABMELDEN":    true, // German
+	"ARRET":       true, // French
+	"ARRÊT":       true, // French
+	"DESINSCRIRE": true, // French
+	"BAJA":        true, // Spanish
+	"PARAR":       true, // Spanish, Portuguese
+	"BASTA":       true, // Italian
+	"AVSLUTA":     true, // Swedish
+	"AFMELD":      true, // Danish
+	"STOPPEN":     true, // Dutch, German
+}
+
+// globalOptOutWords - second word (or suffix) that makes the opt out apply to all clients e.g. STOP ALL
+var globalOptOutWords = map[string]bool{
+	"ALL":   true,
+	"ALLE":  true, // Dutch, German, Danish, Norwegian
+	"TOUT":  true, // French
+	"TODO":  true, // Spanish, Portuguese
+	"TUTTO": true, // Italian
+}
+
+// OptOut - check a reply message for an opt out keyword, returns whether it is an opt out
+// and whether it is a global opt out (for all clients) e.g. STOP ALL or STOPALL
+func OptOut(message string) (bool, bool) {
+	words := strings.FieldsFunc(strings.ToUpper(message), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return false, false
+	}
+	// allow for OPT OUT
+	if words[0] == "OPT" && len(words) > 1 && words[1] == "OUT" {
+		words = append([]string{"OPTOUT"}, words[2:]...)
+	}
+	if optOutKeywords[words[0]] {
+		return true, len(words) > 1 && globalOptOutWords[words[1]]
+	}
+	// allow for no space between keyword and global word e.g. STOPALL
+	for global := range globalOptOutWords {
+		if strings.HasSuffix(words[0], global) && optOutKeywords[strings.TrimSuffix(words[0], global)] {
+			return true, true
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestOptOut(t *testing.T) {
+	tests := []struct {
+		message string
+		optOut  bool
+		global  bool
+	}{
+		{"STOP", true, false},
+		{" stop ", true, false},
+		{"Stop.", true, false},
+		{"STOP please", true, false},
+		{"unsubscribe", true, false},
+		{"opt out", true, false},
+		{"Afmelden", true, false},
+		{"arrêt", true, false},
+		{"STOP ALL", true, true},
+		{"stopall", true, true},
+		{"Stop alle", true, true},
+		{"thanks great driver", false, false},
+		{"please don't stop", false, false},
+		{"", false, false},
+		{"STOPPER", false, false},
+	}
+	for _, tt := range tests {
+		optOut, global := OptOut(tt.message)
+		if optOut != tt.optOut || global != tt.global {
+			t.Errorf("OptOut(%q) = %t, %t expected %t, %t", tt.message, optOut, global, tt.optOut, tt.global)
+		}
+	}
+}
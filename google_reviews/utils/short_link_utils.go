@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"crypto/rand"
+	"log"
+	"math/big"
+	"strings"
+)
+
+// ShortLinkCodeLength - length of a short link code
+const ShortLinkCodeLength = 8
+
+// shortLinkCodeChars - characters used in a short link code (avoids characters that need encoding in a URL)
+const shortLinkCodeChars = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewShortLinkCode - generate a random short link code (empty string if the random number generator fails)
+func NewShortLinkCode() string {
+	code := make([]byte, ShortLinkCodeLength)
+	max := big.NewInt(int64(len(shortLinkCodeChars)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			log.Printf("Error generating short link code, error: %v\n", err)
+			return ""
+		}
+		code[i] = shortLinkCodeChars[n.Int64()]
+	}
+	return string(code)
+}
+
+// ValidShortLinkCode - check the code could be a short link code
+func ValidShortLinkCode(code string) bool {
+	if len(code) != ShortLinkCodeLength {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if strings.IndexByte(shortLinkCodeChars, code[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
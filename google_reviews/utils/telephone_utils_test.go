@@ -211,3 +211,44 @@ func TestTelephoneParse7(t *testing.T) {
 		t.Error("Error foreign phone numbers should return an empty string")
 	}
 }
+
+func TestHashTelephone(t *testing.T) {
+	h := HashTelephone("447123456789")
+	if len(h) != 64 || h != HashTelephone("447123456789") {
+		t.Errorf("Error hashing telephone got %s", h)
+	}
+	if h == HashTelephone("447123456788") {
+		t.Error("Error different telephones have the same hash")
+	}
+	if HashTelephone("") != "" {
+		t.Error("Error empty telephone should have an empty hash")
+	}
+}
+
+func TestHashTelephoneWithKey(t *testing.T) {
+	unkeyed := UnkeyedHashTelephone("447123456789")
+	SetTelephoneHashKey("test-telephone-hash-key")
+	defer SetTelephoneHashKey("")
+	h := HashTelephone("447123456789")
+	if len(h) != 64 || h == unkeyed || h != HashTelephone("447123456789") {
+		t.Errorf("Error hashing telephone with a key got %s", h)
+	}
+	SetTelephoneHashKey("another-telephone-hash-key")
+	if h == HashTelephone("447123456789") {
+		t.Error("Error different keys should have different hashes")
+	}
+}
+
+func TestMaskTelephone(t *testing.T) {
+	tests := map[string]string{
+		"447123456789":  "*********789",
+		"+447123456789": "**********789",
+		"1234":          "****",
+		"":              "",
+	}
+	for telephone, expected := range tests {
+		if got := MaskTelephone(telephone); got != expected {
+			t.Errorf("Error masking telephone %q expected %q got %q", telephone, expected, got)
+		}
+	}
+}
@@ -39,28 +39,65 @@ func CheckTime(start string, end string, timeZone string) bool {
 	}
 
 	now := time.Now().In(loc)
-	// fmt.Printf("now: %v\n", now)
-	nowDate := fmt.Sprintf("%d/%02d/%02d", now.Year(), now.Month(), now.Day())
-	// fmt.Printf("nowDate: %s\n", nowDate)
-
-	startTime, err := time.ParseInLocation("2006/01/02 15:04", nowDate+" "+start, loc)
+	startTime, endTime, err := Window(start, end, now)
 	if err != nil {
 		log.Println(err)
 		return false
 	}
-	// fmt.Printf("startTime: %v\n", startTime)
+	if now.Before(startTime) || now.After(endTime) {
+		return false
+	}
+	return true
+}
 
-	endTime, err := time.ParseInLocation("2006/01/02 15:04", nowDate+" "+end, loc)
+// CheckWindow - check the time is between start and end and the window is on an enabled day (days from Sunday), a
+// window wrapping past midnight is on the day it starts
+func CheckWindow(start string, end string, days [7]bool, timeZone string) bool {
+	if !CheckTime(start, end, timeZone) {
+		return false
+	}
+	loc, err := time.LoadLocation(timeZone)
 	if err != nil {
 		log.Println(err)
 		return false
 	}
-	// fmt.Printf("endTime: %v\n", endTime)
-
-	if now.Before(startTime) || now.After(endTime) {
+	startTime, _, err := Window(start, end, time.Now().In(loc))
+	if err != nil {
+		log.Println(err)
 		return false
 	}
-	return true
+	return days[startTime.Weekday()]
+}
+
+// clockTime - the hh:mm time on the day of t (in the location of t)
+func clockTime(hhmm string, t time.Time) (time.Time, error) {
+	date := fmt.Sprintf("%d/%02d/%02d", t.Year(), t.Month(), t.Day())
+	return time.ParseInLocation("2006/01/02 15:04", date+" "+strings.TrimSpace(hhmm), t.Location())
+}
+
+// Window - the start to end (hh:mm) window that now is in, or the next window when now is not in one (in the location
+// of now). A window with the end before the start wraps past midnight (e.g. 20:00 to 02:00).
+func Window(start string, end string, now time.Time) (time.Time, time.Time, error) {
+	startTime, err := clockTime(start, now)
+	if err != nil {
+		return startTime, startTime, err
+	}
+	endTime, err := clockTime(end, now)
+	if err != nil {
+		return startTime, endTime, err
+	}
+	if endTime.Before(startTime) {
+		if now.After(endTime) {
+			endTime = endTime.AddDate(0, 0, 1)
+		} else {
+			// after midnight in the window started the day before
+			startTime = startTime.AddDate(0, 0, -1)
+		}
+	}
+	if now.After(endTime) {
+		startTime, endTime = startTime.AddDate(0, 0, 1), endTime.AddDate(0, 0, 1)
+	}
+	return startTime, endTime, nil
 }
 
 // DiffTimeRFC3339 - get difference between from and to and whether correct format
@@ -127,3 +164,54 @@ func CheckWeekday(sunday bool, monday bool, tuesday bool, wednesday bool, thursd
 	}
 	return false
 }
+
+// NextWindow - the start to end (hh:mm) window on an enabled day (days from Sunday) that from is in, or the next one
+// (in the location of from). Returns false when the times are not valid or no day is enabled.
+func NextWindow(start string, end string, days [7]bool, from time.Time) (time.Time, time.Time, bool) {
+	startTime, endTime, err := Window(start, end, from)
+	if err != nil {
+		log.Println(err)
+		return startTime, endTime, false
+	}
+	for i := 0; i < len(days) && !days[startTime.Weekday()]; i++ {
+		startTime, endTime = startTime.AddDate(0, 0, 1), endTime.AddDate(0, 0, 1)
+	}
+	return startTime, endTime, days[startTime.Weekday()]
+}
+
+// PacingDelay - delay before a message can be sent so the daily send count is spread across the start to end window
+// (in the time zone) rather than used up as soon as the window opens. The window is split into a slot for each message
+// of the daily send count, allocated being the messages sent or already deferred today so the message takes the next
+// slot (sent straight away when the slot has passed). A window wrapping past midnight is paced from its start the day
+// before, when outside the window or the window is not on an enabled day (days from Sunday) the message is deferred
+// to the next window on an enabled day. Returns false when the daily send count is already allocated.
+func PacingDelay(start string, end string, timeZone string, days [7]bool, maxDailySendCount uint, allocated uint, now time.Time) (time.Duration, bool) {
+	if allocated >= maxDailySendCount {
+		return 0, false
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		log.Println(err)
+		return 0, true
+	}
+
+	now = now.In(loc)
+	startTime, endTime, ok := NextWindow(start, end, days, now)
+	if !ok {
+		return 0, true
+	}
+	window := endTime.Sub(startTime)
+	if window <= 0 {
+		return 0, true
+	}
+	// the daily send count of a later day is not allocated yet
+	if startTime.After(now) && startTime.YearDay() != now.YearDay() {
+		allocated = 0
+	}
+
+	slot := startTime.Add(window / time.Duration(maxDailySendCount) * time.Duration(allocated))
+	if !slot.After(now) {
+		return 0, true
+	}
+	return slot.Sub(now), true
+}
@@ -26,7 +26,9 @@ type Company struct {
 }
 
 type ArchiveBooking struct {
+	BookingID       int64  `json:"bookingId"`
 	TelephoneNumber string `json:"telephoneNumber"`
+	CustomerEmail   string `json:"customerEmail"`
 	ArchiveReason   string `json:"archiveReason"`
 	BookedAtTime    string `json:"bookedAtTime"`
 	PickupDueTime   string `json:"pickupDueTime"`
@@ -34,6 +36,7 @@ type ArchiveBooking struct {
 	PickedUpAtTime string `json:"pickedUpAtTime"`
 	Company        Company
 	BookingSource  string `json:"bookingSource"`
+	Name           string `json:"name"`
 }
 
 const BookingSourceMobileApp = "MobileApp"
@@ -60,7 +63,8 @@ func GetArchiveBookingFromResponse(jsonResponse string) []ArchiveBooking {
 }
 
 // GetAuthorisationTokenFromServer - get the autorisation token from the Autocab server
-func GetAuthorisationTokenFromServer(serverURL, username, password string) string {
+// insecureSkipVerify - the TLS certificate of the server is not verified (the config opted out of TLS verification)
+func GetAuthorisationTokenFromServer(serverURL, username, password string, insecureSkipVerify bool) string {
 	params := url.Values{}
 	params.Add("username", username)
 	params.Add("password", password)
@@ -71,7 +75,7 @@ func GetAuthorisationTokenFromServer(serverURL, username, password string) strin
 	}
 	apiURL += "api/thirdparty/v1/authenticate"
 
-	resp := client.Send(apiURL, "POST", nil, params, nil)
+	resp := client.Send(apiURL, "POST", nil, params, nil, insecureSkipVerify)
 	log.Println("resp: ", resp)
 	token := GetTokenFromAuthorisationResponse(resp)
 	log.Println("token: ", token)
@@ -79,7 +83,7 @@ func GetAuthorisationTokenFromServer(serverURL, username, password string) strin
 }
 
 // GetArchiveBookingsFromServer - get the archive bookings from the Autocab server
-func GetArchiveBookingsFromServer(serverURL, token string, from, to time.Time) []ArchiveBooking {
+func GetArchiveBookingsFromServer(serverURL, token string, from, to time.Time, insecureSkipVerify bool) []ArchiveBooking {
 	headers := map[string]string{"Authentication-Token": token}
 
 	params := url.Values{}
@@ -94,7 +98,7 @@ func GetArchiveBookingsFromServer(serverURL, token string, from, to time.Time) [
 	apiURL += "api/thirdparty/v1/archivedbookings"
 
 	log.Printf("request URL: %s, parameters: %+v\n", apiURL, params)
-	resp := client.Send(apiURL, "POST", headers, params, nil)
+	resp := client.Send(apiURL, "POST", headers, params, nil, insecureSkipVerify)
 	log.Println("resp: ", resp)
 	archiveBookings := GetArchiveBookingFromResponse(resp)
 	log.Printf("archiveBookings: %+v\n", archiveBookings)
@@ -45,7 +45,7 @@ func TestGetTokenFromAuthorisationResponseFailed(t *testing.T) {
 
 // to live test server
 func TestGetAuthorisationTokenFromServerLive(t *testing.T) {
-	authorisationToken := GetAuthorisationTokenFromServer(testAutocabServerURL, testAutocabUsername, testAutocabPassword)
+	authorisationToken := GetAuthorisationTokenFromServer(testAutocabServerURL, testAutocabUsername, testAutocabPassword, false)
 	if authorisationToken == "" {
 		t.Fatal("Error getting authorisation token")
 	}
@@ -60,6 +60,9 @@ func TestArchiveBookingsSuccessAllCancelled(t *testing.T) {
 		t.Fatalf("Error decoding archive bookings, error: %+v\n", err)
 	}
 	fmt.Printf("archive bookings: %+v\n", archiveBookings)
+	if len(archiveBookings) == 0 || archiveBookings[0].BookingID != 583 {
+		t.Fatalf("Error decoding the booking ID, archive bookings: %+v", archiveBookings)
+	}
 }
 
 func TestArchiveBookingsNoEntries(t *testing.T) {
@@ -102,7 +105,7 @@ func TestGetArchiveBookingFromResponseNoEntries(t *testing.T) {
 
 // to live test server
 func TestGetArchiveBookingsFromServerLive(t *testing.T) {
-	authorisationToken := GetAuthorisationTokenFromServer(testAutocabServerURL, testAutocabUsername, testAutocabPassword)
+	authorisationToken := GetAuthorisationTokenFromServer(testAutocabServerURL, testAutocabUsername, testAutocabPassword, false)
 	if authorisationToken == "" {
 		t.Fatal("Error getting authorisation token")
 	}
@@ -115,6 +118,6 @@ func TestGetArchiveBookingsFromServerLive(t *testing.T) {
 	}
 	to := time.Now().In(loc)
 	from := to.Add(-time.Hour * 24)
-	archiveBookings := GetArchiveBookingsFromServer(testAutocabServerURL, authorisationToken, from, to)
+	archiveBookings := GetArchiveBookingsFromServer(testAutocabServerURL, authorisationToken, from, to, false)
 	fmt.Printf("archive bookings: %+v\n", archiveBookings)
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"google_reviews_autocab/autocab_api"
 	"google_reviews_autocab/client"
+	"google_reviews_autocab/utils"
 	"log"
 	"strings"
 	"time"
@@ -26,7 +27,9 @@ type ArchivedBooking struct {
 }
 
 type Booking struct {
+	ID              int64  `json:"id"`
 	TelephoneNumber string `json:"telephoneNumber"`
+	CustomerEmail   string `json:"customerEmail"`
 	BookedAtTime    string `json:"bookedAtTime"`
 	PickupDueTime   string `json:"pickupDueTime"`
 	BookingSource   string `json:"bookingSource"`
@@ -61,7 +64,8 @@ func GetBookingFromResponse(jsonResponse string) []Booking {
 }
 
 // GetBookingsFromServer - get the bookings from the Autocab server
-func GetBookingsFromServer(serverURL, key string, from, to time.Time) []Booking {
+// insecureSkipVerify - the TLS certificate of the server is not verified (the config opted out of TLS verification)
+func GetBookingsFromServer(serverURL, key string, from, to time.Time, insecureSkipVerify bool) []Booking {
 	headers := map[string]string{
 		"Content-Type":              "application/json",
 		"Accept":                    "application/json",
@@ -81,7 +85,7 @@ func GetBookingsFromServer(serverURL, key string, from, to time.Time) []Booking
 	apiURL += "booking/v1/search"
 
 	log.Printf("request URL: %s, json body: %s\n", apiURL, body)
-	resp := client.Send(apiURL, "POST", headers, nil, body)
+	resp := client.Send(apiURL, "POST", headers, nil, body, insecureSkipVerify)
 	log.Println("resp: ", resp)
 	bookings := GetBookingFromResponse(resp)
 	log.Printf("bookings: %+v\n", bookings)
@@ -95,7 +99,9 @@ func TranslateBookingsToArchiveBookings(bookings []Booking) []autocab_api.Archiv
 	for _, booking := range bookings {
 		// log.Printf("booking: %+v\n", booking)
 		var archiveBooking autocab_api.ArchiveBooking
+		archiveBooking.BookingID = booking.ID
 		archiveBooking.TelephoneNumber = booking.TelephoneNumber
+		archiveBooking.CustomerEmail = booking.CustomerEmail
 		archiveBooking.ArchiveReason = booking.ArchivedBooking.Reason
 		archiveBooking.BookedAtTime = booking.BookedAtTime
 		archiveBooking.PickupDueTime = booking.PickupDueTime
@@ -156,7 +162,7 @@ func SendSMS(serverURL, key, telephone, message, senderName string) bool {
 	// log.Printf("request URL: %s, headers: %X, json body: %X\n", apiURL, httpHeaders, body)
 
 	// log.Printf("request URL: %s, headers: %s, json body: %s\n", apiURL, headers, body)
-	resp := client.Send(apiURL, "POST", headers, nil, body)
+	resp := client.Send(apiURL, "POST", headers, nil, body, false)
 	// log.Println("resp: ", resp)
 
 	telephoneSent := false
@@ -174,9 +180,9 @@ func SendSMS(serverURL, key, telephone, message, senderName string) bool {
 		}
 	}
 	if !telephoneSent {
-		log.Printf("Error sending SMS via Autocab Send SMS to telephone: %s, message: %s, response: %s", telephone, message, resp)
+		log.Printf("Error sending SMS via Autocab Send SMS to telephone: %s, message: %s, response: %s", utils.MaskTelephone(telephone), message, resp)
 	} else {
-		log.Printf("Sent SMS via Autocab Send SMS to telephone: %s, message: %s, response: %s", telephone, message, resp)
+		log.Printf("Sent SMS via Autocab Send SMS to telephone: %s, message: %s, response: %s", utils.MaskTelephone(telephone), message, resp)
 	}
 
 	return telephoneSent
@@ -26,7 +26,9 @@ type ArchivedBooking struct {
 }
 
 type Booking struct {
+	ID              int64  `json:"id"`
 	TelephoneNumber string `json:"telephoneNumber"`
+	CustomerEmail   string `json:"customerEmail"`
 	BookedAtTime    string `json:"bookedAtTime"`
 	PickupDueTime   string `json:"pickupDueTime"`
 	BookingSource   string `json:"bookingSource"`
@@ -56,7 +58,8 @@ func GetBookingFromResponse(jsonResponse string) ([]Booking, string) {
 }
 
 // GetBookingsFromServer - get the bookings from the Autocab server
-func GetBookingsFromServer(serverURL, key string, from, to time.Time) []Booking {
+// insecureSkipVerify - the TLS certificate of the server is not verified (the config opted out of TLS verification)
+func GetBookingsFromServer(serverURL, key string, from, to time.Time, insecureSkipVerify bool) []Booking {
 	headers := map[string]string{
 		"Content-Type":              "application/json",
 		"Accept":                    "application/json",
@@ -80,7 +83,7 @@ func GetBookingsFromServer(serverURL, key string, from, to time.Time) []Booking
 	bookings := make([]Booking, 0)
 	for ok := true; ok; ok = getMoreBookings {
 		log.Printf("request URL: %s, json body: %s\n", apiURL, body)
-		resp := client.Send(apiURL, "POST", headers, nil, body)
+		resp := client.Send(apiURL, "POST", headers, nil, body, insecureSkipVerify)
 		log.Println("resp: ", resp)
 		bkings, continuationToken := GetBookingFromResponse(resp)
 		bookings = append(bookings, bkings...)
@@ -106,7 +109,9 @@ func TranslateBookingsToArchiveBookings(bookings []Booking) []autocab_api.Archiv
 	for _, booking := range bookings {
 		// log.Printf("booking: %+v\n", booking)
 		var archiveBooking autocab_api.ArchiveBooking
+		archiveBooking.BookingID = booking.ID
 		archiveBooking.TelephoneNumber = booking.TelephoneNumber
+		archiveBooking.CustomerEmail = booking.CustomerEmail
 		archiveBooking.ArchiveReason = booking.ArchivedBooking.Reason
 		archiveBooking.BookedAtTime = booking.BookedAtTime
 		archiveBooking.PickupDueTime = booking.PickupDueTime
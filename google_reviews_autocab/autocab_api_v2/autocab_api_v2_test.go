@@ -27,7 +27,7 @@ func TestGetBookingsFromServerLive(t *testing.T) {
 	// from := to.Add(-time.Hour * 24)
 	from := to.Add(-time.Hour * 24 * 30)
 	// from := to.Add(-time.Hour * 24 * 2)
-	bookings := GetBookingsFromServer(testAutocabServerURL, testAutocabKey, from, to)
+	bookings := GetBookingsFromServer(testAutocabServerURL, testAutocabKey, from, to, false)
 	fmt.Printf("bookings: %+v\nnumber of bookings: %d\n", bookings, len(bookings))
 }
 
@@ -45,4 +45,7 @@ func TestTranslateBookingsToArchiveBookings(t *testing.T) {
 
 	ab := TranslateBookingsToArchiveBookings(bookings)
 	fmt.Printf("archive bookings: %+v\n", ab)
+	if len(ab) != len(bookings) || ab[0].BookingID != 14855 {
+		t.Fatalf("Error translating the booking ID, archive bookings: %+v", ab)
+	}
 }
@@ -1,6 +1,7 @@
 package barred
 
 import (
+	"errors"
 	"log"
 	"testing"
 )
@@ -30,3 +31,58 @@ func TestCheckBarred2(t *testing.T) {
 		t.Fatal("Error telephone should be barred")
 	}
 }
+
+func TestList(t *testing.T) {
+	l := NewList([]Entry{
+		{Telephone: "447418"},
+		{Telephone: "447123456700", FullNumber: true},
+		{ClientID: 12, Telephone: "447999"},
+		{ClientID: 12, Telephone: "447123456701", FullNumber: true},
+		{Telephone: ""},
+	})
+	if l.Len() != 4 {
+		t.Fatalf("expected 4 barred telephones got %d", l.Len())
+	}
+	tests := []struct {
+		telephone string
+		clientID  uint64
+		barred    bool
+	}{
+		{"447418456789", 0, true},
+		{"447418456789", 12, true},
+		{"447123456700", 1, true},
+		{"4471234567001", 1, false}, // full number only barred when it matches exactly
+		{"447999123456", 12, true},
+		{"447999123456", 13, false},
+		{"447123456701", 12, true},
+		{"447123456701", 13, false},
+		{"447123456789", 12, false},
+		{"44741", 0, false},
+	}
+	for _, tt := range tests {
+		if b := l.Barred(tt.telephone, tt.clientID); b != tt.barred {
+			t.Errorf("telephone: %s, clientID: %d barred: %v want %v", tt.telephone, tt.clientID, b, tt.barred)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	Store(nil)
+	if Load().Barred("447418456789", 0) {
+		t.Fatal("Error nothing should be barred before the list is loaded")
+	}
+	bars, _ := ReadBarredFile("../config/barred_telephone_prefixes.txt")
+	if err := Reload(func() ([]Entry, error) { return FileEntries(bars), nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !Load().Barred("447418456789", 0) {
+		t.Fatal("Error telephone should be barred")
+	}
+	// the current list is kept when the entries cannot be loaded
+	if err := Reload(func() ([]Entry, error) { return nil, errors.New("database unavailable") }); err == nil {
+		t.Fatal("expected the reload to fail")
+	}
+	if !Load().Barred("447418456789", 0) {
+		t.Fatal("Error telephone should still be barred")
+	}
+}
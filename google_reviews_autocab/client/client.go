@@ -7,12 +7,19 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 )
 
 // Clients and Transports are safe for concurrent use by multiple goroutines and for efficiency
 // should only be created once and re-used.
-var httpClient *http.Client
+// The TLS certificates are verified other than for the configs opting out (see insecureHTTPClient).
+// NOTE: keep in line with google_reviews
+var (
+	httpClient         *http.Client
+	insecureHTTPClient *http.Client
+	createClients      sync.Once
+)
 
 const (
 	maxIdleConnections    int           = 20
@@ -22,21 +29,35 @@ const (
 )
 
 // Reuse the connection
-func createHTTPClient() *http.Client {
+func createHTTPClient(insecureSkipVerify bool) *http.Client {
 	tr := &http.Transport{
 		MaxIdleConnsPerHost:   maxIdleConnections,
 		IdleConnTimeout:       idleConnTimeout,
 		ExpectContinueTimeout: expectContinueTimeout,
-		// Added for sending to servers with invalid certificate
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	if insecureSkipVerify {
+		// for sending to servers with an invalid certificate (configs opting out of TLS verification)
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
 	client := &http.Client{Transport: tr, Timeout: timeout}
 
 	return client
 }
 
-// Send - send HTTP request
-func Send(sendURL string, method string, headers map[string]string, params url.Values, jsonBody []byte) string {
+// getHTTPClient - the client verifying the TLS certificates unless skipped
+func getHTTPClient(insecureSkipVerify bool) *http.Client {
+	createClients.Do(func() {
+		httpClient = createHTTPClient(false)
+		insecureHTTPClient = createHTTPClient(true)
+	})
+	if insecureSkipVerify {
+		return insecureHTTPClient
+	}
+	return httpClient
+}
+
+// Send - send HTTP request, the TLS certificate is not verified when insecureSkipVerify is set
+func Send(sendURL string, method string, headers map[string]string, params url.Values, jsonBody []byte, insecureSkipVerify bool) string {
 	baseURL, err := url.Parse(sendURL)
 	if err != nil {
 		log.Println(err)
@@ -69,11 +90,7 @@ func Send(sendURL string, method string, headers map[string]string, params url.V
 		}
 	}
 
-	if httpClient == nil {
-		httpClient = createHTTPClient()
-	}
-
-	resp, err := httpClient.Do(req)
+	resp, err := getHTTPClient(insecureSkipVerify).Do(req)
 	if resp != nil {
 		// close the connection to reuse it
 		defer resp.Body.Close()
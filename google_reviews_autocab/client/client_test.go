@@ -76,7 +76,7 @@ func TestSendAuthorisationTestServer(t *testing.T) {
 	params.Add("password", testAutocabPassword)
 
 	testURL := testServer.URL
-	resp := Send(testURL, "POST", nil, params, nil)
+	resp := Send(testURL, "POST", nil, params, nil, false)
 	fmt.Println("resp: ", resp)
 	if resp == "" {
 		t.Fatal("Error authorising user")
@@ -94,7 +94,7 @@ func TestSendAuthorisationWrongCredentialsTestServer(t *testing.T) {
 	params.Add("password", testAutocabPassword)
 
 	testURL := testServer.URL
-	resp := Send(testURL, "POST", nil, params, nil)
+	resp := Send(testURL, "POST", nil, params, nil, false)
 	fmt.Println("resp: ", resp)
 	if resp != "" {
 		t.Fatal("Error authorising user should have failed")
@@ -116,7 +116,7 @@ func TestSendArchiveBookingsTestServer(t *testing.T) {
 	params1.Add("ArchiveReasons", "Completed")
 
 	testURL := testServer.URL
-	resp := Send(testURL, "POST", headers, params1, nil)
+	resp := Send(testURL, "POST", headers, params1, nil, false)
 	fmt.Println("resp: ", resp)
 	if resp == "" {
 		t.Fatal("Error gettting archive bookings")
@@ -130,7 +130,7 @@ func TestSendAuthorisationLive(t *testing.T) {
 	params.Add("username", testAutocabUsername)
 	params.Add("password", testAutocabPassword)
 
-	resp := Send(testAutocabServerURL+"api/thirdparty/v1/authenticate", "POST", nil, params, nil)
+	resp := Send(testAutocabServerURL+"api/thirdparty/v1/authenticate", "POST", nil, params, nil, false)
 	fmt.Println("resp: ", resp)
 	if resp == "" {
 		t.Fatal("Error authorising user")
@@ -144,7 +144,7 @@ func TestSendAuthorisationWrongCredentialsLive(t *testing.T) {
 	params.Add("username", "rubbish")
 	params.Add("password", testAutocabPassword)
 
-	resp := Send(testAutocabServerURL+"api/thirdparty/v1/authenticate", "POST", nil, params, nil)
+	resp := Send(testAutocabServerURL+"api/thirdparty/v1/authenticate", "POST", nil, params, nil, false)
 	fmt.Println("resp: ", resp)
 	if resp != "" {
 		t.Fatal("Error authorising user should have failed")
@@ -159,7 +159,7 @@ func TestSendArchiveBookingsLive(t *testing.T) {
 	params.Add("username", testAutocabUsername)
 	params.Add("password", testAutocabPassword)
 
-	resp := Send(testAutocabServerURL+"api/thirdparty/v1/authenticate", "POST", nil, params, nil)
+	resp := Send(testAutocabServerURL+"api/thirdparty/v1/authenticate", "POST", nil, params, nil, false)
 	fmt.Println("resp: ", resp)
 	if resp == "" {
 		t.Fatal("Error authorising user")
@@ -195,7 +195,7 @@ func TestSendArchiveBookingsLive(t *testing.T) {
 	params1.Add("to", to)
 	// params1.Add("ArchiveReasons", "Completed")
 
-	resp1 := Send(testAutocabServerURL+"api/thirdparty/v1/archivedbookings", "POST", headers, params1, nil)
+	resp1 := Send(testAutocabServerURL+"api/thirdparty/v1/archivedbookings", "POST", headers, params1, nil, false)
 	fmt.Println("resp: ", resp1)
 }
 
@@ -214,6 +214,6 @@ func TestSendReviewMasterSMSGateway(t *testing.T) {
 		"Api-Token":    testReviewMasterSMSGatewayApiToken,
 	}
 
-	resp := Send(testReviewMasterSMSGatewayURL, "POST", headers, nil, body)
+	resp := Send(testReviewMasterSMSGatewayURL, "POST", headers, nil, body, false)
 	fmt.Println("resp: ", resp)
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"log"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -34,6 +35,38 @@ type Config struct {
 	AutocabSendSMSSenderName string
 
 	BarredTelephonePrefixFile string
+	BarredReloadPeriod        int
+
+	ProcessedBookingTTL   int
+	ProcessedBookingLease int
+
+	ShortLinkBaseURL string
+
+	MetricsPort       string
+	MetricsToken      string
+	MetricsAllowedIPs []string
+
+	TwilioStatusCallbackURL string
+
+	WhatsAppGraphURL string
+
+	EmailService            string
+	SMTPHost                string
+	SMTPPort                int
+	SMTPUsername            string
+	SMTPPassword            string
+	SendGridAPIKey          string
+	SendGridURL             string
+	EmailFromAddress        string
+	EmailFromName           string
+	EmailUnsubscribeBaseURL string
+	EmailUnsubscribeSecret  string
+
+	TelephoneHashKey string
+
+	GatewaySendInterval int
+
+	SecretsKeyFile string
 }
 
 // ReadProperties - read the properties file
@@ -71,6 +104,71 @@ func ReadProperties() {
 	Conf.AutocabSendSMSSenderName = viper.GetString("autocab_send_sms_sender_name")
 
 	Conf.BarredTelephonePrefixFile = viper.Get("barred_telephone_prefix_file").(string)
+	// barred telephones (managed from google_reviews_ui) are reloaded from the database every period
+	viper.SetDefault("barred_reload_period", 60) // seconds
+	Conf.BarredReloadPeriod = viper.GetInt("barred_reload_period")
+
+	// processed bookings, a booking (same client and booking ID) returned again by a later (overlapping) poll within
+	// the ttl is not processed again (0 disables), a booking is claimed whilst being processed for the lease so a
+	// booking not completed is processed again once the lease expires
+	viper.SetDefault("processed_booking_ttl", 48)    // hours
+	viper.SetDefault("processed_booking_lease", 120) // seconds
+	Conf.ProcessedBookingTTL = viper.GetInt("processed_booking_ttl")
+	Conf.ProcessedBookingLease = viper.GetInt("processed_booking_lease")
+
+	// tracked short review links e.g. https://reviews.example.com (empty to send the review link as is),
+	// the short links are redirected by the google reviews server
+	Conf.ShortLinkBaseURL = viper.GetString("short_link_base_url")
+
+	// metrics (/metrics) listener port (empty to not listen), the metrics are available with the metrics token
+	// or from the allowed IPs (comma separated IPs or CIDRs)
+	Conf.MetricsPort = viper.GetString("metrics_port")
+	Conf.MetricsToken = viper.GetString("metrics_token")
+	for _, ip := range strings.Split(viper.GetString("metrics_allowed_ips"), ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			Conf.MetricsAllowedIPs = append(Conf.MetricsAllowedIPs, ip)
+		}
+	}
+
+	// Twilio delivery status callback sent with each message (the public URL of /twilio/status of the google reviews
+	// server e.g. https://reviews.example.com/twilio/status, empty for no callbacks)
+	Conf.TwilioStatusCallbackURL = viper.GetString("twilio_status_callback_url")
+
+	// WhatsApp Cloud API, the messages are sent to <graph url>/<phone number ID>/messages (the webhook is handled by
+	// the google reviews server)
+	viper.SetDefault("whatsapp_graph_url", "https://graph.facebook.com/v19.0")
+	Conf.WhatsAppGraphURL = viper.GetString("whatsapp_graph_url")
+
+	// email channel (configs with email enabled email bookings without a mobile telephone), the email service is
+	// smtp or sendgrid (empty for no emails). The unsubscribe link is handled by the google reviews server, the
+	// unsubscribe base URL and secret have to be the same as the google reviews server.
+	Conf.EmailService = strings.ToLower(viper.GetString("email_service"))
+	Conf.SMTPHost = viper.GetString("smtp_host")
+	viper.SetDefault("smtp_port", 587)
+	Conf.SMTPPort = viper.GetInt("smtp_port")
+	Conf.SMTPUsername = viper.GetString("smtp_username")
+	Conf.SMTPPassword = viper.GetString("smtp_password")
+	Conf.SendGridAPIKey = viper.GetString("sendgrid_api_key")
+	viper.SetDefault("sendgrid_url", "https://api.sendgrid.com/v3/mail/send")
+	Conf.SendGridURL = viper.GetString("sendgrid_url")
+	Conf.EmailFromAddress = viper.GetString("email_from_address")
+	Conf.EmailFromName = viper.GetString("email_from_name")
+	Conf.EmailUnsubscribeBaseURL = viper.GetString("email_unsubscribe_base_url")
+	Conf.EmailUnsubscribeSecret = viper.GetString("email_unsubscribe_secret")
+
+	// data protection, the telephones are stored and looked up as a keyed hash (HMAC-SHA-256) with the telephone hash
+	// key, it has to be the same key as google_reviews (which pseudonymises the stored telephones)
+	Conf.TelephoneHashKey = viper.GetString("telephone_hash_key")
+
+	// own SMS gateway is sent a request at most once each gateway send interval so the SIMs are not sent a burst of
+	// messages by the poller (0 disables)
+	viper.SetDefault("gateway_send_interval", 0) // milliseconds
+	Conf.GatewaySendInterval = viper.GetInt("gateway_send_interval")
+
+	// secrets of the configs and send laters (e.g. dispatcher app key and secret key) are stored encrypted with a data
+	// key wrapped by the key in the secrets key file, it has to be the same key file as google_reviews (which encrypts
+	// the stored secrets and rotates the data key)
+	Conf.SecretsKeyFile = viper.GetString("secrets_key_file")
 }
 
 // UpdateProperties - update properties file
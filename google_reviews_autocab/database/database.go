@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"time"
 
+	"google_reviews_autocab/barred"
 	"google_reviews_autocab/utils"
 	// mysql driver
 	_ "github.com/go-sql-driver/mysql"
@@ -18,17 +19,39 @@ var (
 	ReviewMasterSMSMasterQueue = 0
 )
 
+// message event reasons, recorded in the message events table for why a message was or wasn't sent
+const (
+	ReasonSent                  = "sent"
+	ReasonDeferred              = "deferred"
+	ReasonBarred                = "barred"
+	ReasonOutsideHours          = "outside_hours"
+	ReasonTooRecent             = "too_recent"
+	ReasonMaxCount              = "max_count"
+	ReasonMaxDailyCount         = "max_daily_count"
+	ReasonStopped               = "stopped"
+	ReasonNoTelephone           = "no_telephone"
+	ReasonNoMessage             = "no_message"
+	ReasonDispatcherCheckFailed = "dispatcher_check_failed"
+	ReasonProviderError         = "provider_error"
+	ReasonFatigueCap            = "fatigue_cap"
+)
+
+// maxProviderResponseLength - maximum length of the provider response stored in a message event
+const maxProviderResponseLength = 1024
+
 // GoogleReviewsConfig - represents a google reviews config from the token with some checks
 type GoogleReviewsConfigFromTokenWithChecks struct {
 	MinSendFrequency                     uint
 	MaxSendCount                         uint
 	MaxDailySendCount                    uint
+	PacingEnabled                        bool
 	TelephoneParameter                   string
 	SendFromIcabbiApp                    bool
 	AppKey                               string
 	SecretKey                            string
 	SendURL                              string
 	HttpGet                              bool
+	TLSSkipVerify                        bool
 	SendSuccessResponse                  string
 	Start                                string
 	End                                  string
@@ -41,6 +64,7 @@ type GoogleReviewsConfigFromTokenWithChecks struct {
 	Saturday                             bool
 	TimeZone                             string
 	ClientID                             uint64
+	ConfigID                             uint64
 	Country                              string
 	MultiMessageEnabled                  uint
 	MessageParameter                     string
@@ -63,9 +87,28 @@ type GoogleReviewsConfigFromTokenWithChecks struct {
 	AlternateMessageServiceEnabled       bool
 	AlternateMessageService              string
 	AlternateMessageServiceSecret1       string
+	AlternateMessageServiceSender        string
+	MessageChannel                       string
+	WhatsAppPhoneNumberID                string
+	WhatsAppAccessToken                  string
+	WhatsAppTemplateName                 string
+	WhatsAppTemplateLanguage             string
+	WhatsAppTemplateParameters           string
+	WhatsAppSMSFallback                  bool
+	EmailEnabled                         bool
+	EmailParameter                       string
+	EmailSubject                         string
+	EmailTemplate                        string
 	Companies                            string
 	BookingSourceMobileAppState          int
 	DispatcherType                       string
+	ReviewLink                           string
+	OptOutLink                           string
+}
+
+// Days - the enabled weekdays from Sunday (see utils.CheckWindow)
+func (grcftwc GoogleReviewsConfigFromTokenWithChecks) Days() [7]bool {
+	return [7]bool{grcftwc.Sunday, grcftwc.Monday, grcftwc.Tuesday, grcftwc.Wednesday, grcftwc.Thursday, grcftwc.Friday, grcftwc.Saturday}
 }
 
 // OpenDB - open database connection
@@ -100,11 +143,11 @@ func OpenDB(database string, host string, port string, username string, password
 // ConfigFromTokenWithChecks - get the config from the token with some checks
 // ignoreTimeAndSentCountCheck - ignores the time and daily sent count checks (used for testing on front end)
 func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) GoogleReviewsConfigFromTokenWithChecks {
-	qry := "SELECT config.min_send_frequency, config.max_send_count, config.max_daily_send_count, config.telephone_parameter," +
+	qry := "SELECT config.min_send_frequency, config.max_send_count, config.max_daily_send_count, config.pacing_enabled, config.telephone_parameter," +
 		" config.send_from_icabbi_app, config.app_key, config.secret_key," +
-		" config.send_url, config.http_get, config.send_success_response, times.start, times.end," +
+		" config.send_url, config.http_get, config.tls_skip_verify, config.send_success_response, times.start, times.end," +
 		" times.sunday, times.monday, times.tuesday, times.wednesday, times.thursday, times.friday, times.saturday," +
-		" config.time_zone, client.id, client.country," +
+		" config.time_zone, client.id, config.id, client.country," +
 		" config.multi_message_enabled, config.message_parameter, config.multi_message_separator," +
 		" config.use_database_message, config.message," +
 		" config.send_delay_enabled, config.send_delay," +
@@ -114,8 +157,11 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 		" config.review_master_sms_gateway_enabled," +
 		" config.review_master_sms_gateway_use_master_queue," +
 		" config.review_master_sms_gateway_pair_code," +
-		" config.alternate_message_service_enabled, config.alternate_message_service, config.alternate_message_service_secret1," +
-		" config.companies, config.booking_source_mobile_app_state, config.dispatcher_type" +
+		" config.alternate_message_service_enabled, config.alternate_message_service, config.alternate_message_service_secret1, config.alternate_message_service_sender," +
+		" config.message_channel, config.whatsapp_phone_number_id, config.whatsapp_access_token, config.whatsapp_template_name," +
+		" config.whatsapp_template_language, config.whatsapp_template_parameters, config.whatsapp_sms_fallback," +
+		" config.email_enabled, config.email_parameter, config.email_subject, IFNULL(config.email_template, '')," +
+		" config.companies, config.booking_source_mobile_app_state, config.dispatcher_type, config.review_link, config.opt_out_link" +
 		" FROM google_reviews_config_times AS times" +
 		" JOIN google_reviews_configs AS config ON config.id = times.google_reviews_config_id" +
 		" JOIN clients AS client ON client.id = config.client_id" +
@@ -131,11 +177,11 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 	}
 	defer rows.Close()
 	for rows.Next() {
-		if err1 := rows.Scan(&grcftwc.MinSendFrequency, &grcftwc.MaxSendCount, &grcftwc.MaxDailySendCount,
+		if err1 := rows.Scan(&grcftwc.MinSendFrequency, &grcftwc.MaxSendCount, &grcftwc.MaxDailySendCount, &grcftwc.PacingEnabled,
 			&grcftwc.TelephoneParameter, &grcftwc.SendFromIcabbiApp, &grcftwc.AppKey, &grcftwc.SecretKey,
-			&grcftwc.SendURL, &grcftwc.HttpGet, &grcftwc.SendSuccessResponse, &grcftwc.Start, &grcftwc.End,
+			&grcftwc.SendURL, &grcftwc.HttpGet, &grcftwc.TLSSkipVerify, &grcftwc.SendSuccessResponse, &grcftwc.Start, &grcftwc.End,
 			&grcftwc.Sunday, &grcftwc.Monday, &grcftwc.Tuesday, &grcftwc.Wednesday, &grcftwc.Thursday, &grcftwc.Friday,
-			&grcftwc.Saturday, &grcftwc.TimeZone, &grcftwc.ClientID, &grcftwc.Country,
+			&grcftwc.Saturday, &grcftwc.TimeZone, &grcftwc.ClientID, &grcftwc.ConfigID, &grcftwc.Country,
 			&grcftwc.MultiMessageEnabled, &grcftwc.MessageParameter, &grcftwc.MultiMessageSeparator,
 			&grcftwc.UseDatabaseMessage, &grcftwc.Message,
 			&grcftwc.SendDelayEnabled, &grcftwc.SendDelay,
@@ -145,8 +191,11 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 			&grcftwc.ReplaceTelephoneCountryCode, &grcftwc.ReplaceTelephoneCountryCodeWith,
 			&grcftwc.ReviewMasterSMSGatewayEnabled, &grcftwc.ReviewMasterSMSGatewayUseMasterQueue,
 			&grcftwc.ReviewMasterSMSGatewayPairCode,
-			&grcftwc.AlternateMessageServiceEnabled, &grcftwc.AlternateMessageService, &grcftwc.AlternateMessageServiceSecret1,
-			&grcftwc.Companies, &grcftwc.BookingSourceMobileAppState, &grcftwc.DispatcherType); err1 != nil {
+			&grcftwc.AlternateMessageServiceEnabled, &grcftwc.AlternateMessageService, &grcftwc.AlternateMessageServiceSecret1, &grcftwc.AlternateMessageServiceSender,
+			&grcftwc.MessageChannel, &grcftwc.WhatsAppPhoneNumberID, &grcftwc.WhatsAppAccessToken, &grcftwc.WhatsAppTemplateName,
+			&grcftwc.WhatsAppTemplateLanguage, &grcftwc.WhatsAppTemplateParameters, &grcftwc.WhatsAppSMSFallback,
+			&grcftwc.EmailEnabled, &grcftwc.EmailParameter, &grcftwc.EmailSubject, &grcftwc.EmailTemplate,
+			&grcftwc.Companies, &grcftwc.BookingSourceMobileAppState, &grcftwc.DispatcherType, &grcftwc.ReviewLink, &grcftwc.OptOutLink); err1 != nil {
 			log.Println("Error retrieving token", token, "from database whilst reading returned results. Error: ", err1)
 			return grcftwc
 		}
@@ -154,10 +203,10 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 			log.Printf("token %s not found", token)
 			continue
 		}
+		decryptConfigSecrets(&grcftwc)
 		if !ignoreTimeAndSentCountCheck {
 			// check within start and end time and weekday
-			if !(utils.CheckTime(grcftwc.Start, grcftwc.End, grcftwc.TimeZone) &&
-				utils.CheckWeekday(grcftwc.Sunday, grcftwc.Monday, grcftwc.Tuesday, grcftwc.Wednesday, grcftwc.Thursday, grcftwc.Friday, grcftwc.Saturday, grcftwc.TimeZone)) {
+			if !utils.CheckWindow(grcftwc.Start, grcftwc.End, grcftwc.Days(), grcftwc.TimeZone) {
 				// log.Printf("token %s not found between times %s and %s for time zone %s for clientID: %d", token, start, end, timeZone, clientID)
 				grcftwc.MinSendFrequency = 0
 				grcftwc.MaxSendCount = 0
@@ -167,8 +216,11 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 				grcftwc.SecretKey = ""
 				grcftwc.SendURL = ""
 				grcftwc.HttpGet = false
+				grcftwc.TLSSkipVerify = false
+				grcftwc.PacingEnabled = false
 				grcftwc.SendSuccessResponse = ""
 				grcftwc.ClientID = 0
+				grcftwc.ConfigID = 0
 				grcftwc.Country = ""
 				grcftwc.MultiMessageEnabled = 0
 				grcftwc.MessageParameter = ""
@@ -191,9 +243,23 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 				grcftwc.AlternateMessageServiceEnabled = false
 				grcftwc.AlternateMessageService = ""
 				grcftwc.AlternateMessageServiceSecret1 = ""
+				grcftwc.AlternateMessageServiceSender = ""
+				grcftwc.MessageChannel = ""
+				grcftwc.WhatsAppPhoneNumberID = ""
+				grcftwc.WhatsAppAccessToken = ""
+				grcftwc.WhatsAppTemplateName = ""
+				grcftwc.WhatsAppTemplateLanguage = ""
+				grcftwc.WhatsAppTemplateParameters = ""
+				grcftwc.WhatsAppSMSFallback = false
+				grcftwc.EmailEnabled = false
+				grcftwc.EmailParameter = ""
+				grcftwc.EmailSubject = ""
+				grcftwc.EmailTemplate = ""
 				grcftwc.Companies = ""
 				grcftwc.BookingSourceMobileAppState = -1
 				grcftwc.DispatcherType = ""
+				grcftwc.ReviewLink = ""
+				grcftwc.OptOutLink = ""
 				continue
 			}
 			// check whether sent daily allowance
@@ -208,8 +274,11 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 				grcftwc.SecretKey = ""
 				grcftwc.SendURL = ""
 				grcftwc.HttpGet = false
+				grcftwc.TLSSkipVerify = false
+				grcftwc.PacingEnabled = false
 				grcftwc.SendSuccessResponse = ""
 				grcftwc.ClientID = 0
+				grcftwc.ConfigID = 0
 				grcftwc.Country = ""
 				grcftwc.MultiMessageEnabled = 0
 				grcftwc.MessageParameter = ""
@@ -232,9 +301,23 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 				grcftwc.AlternateMessageServiceEnabled = false
 				grcftwc.AlternateMessageService = ""
 				grcftwc.AlternateMessageServiceSecret1 = ""
+				grcftwc.AlternateMessageServiceSender = ""
+				grcftwc.MessageChannel = ""
+				grcftwc.WhatsAppPhoneNumberID = ""
+				grcftwc.WhatsAppAccessToken = ""
+				grcftwc.WhatsAppTemplateName = ""
+				grcftwc.WhatsAppTemplateLanguage = ""
+				grcftwc.WhatsAppTemplateParameters = ""
+				grcftwc.WhatsAppSMSFallback = false
+				grcftwc.EmailEnabled = false
+				grcftwc.EmailParameter = ""
+				grcftwc.EmailSubject = ""
+				grcftwc.EmailTemplate = ""
 				grcftwc.Companies = ""
 				grcftwc.BookingSourceMobileAppState = -1
 				grcftwc.DispatcherType = ""
+				grcftwc.ReviewLink = ""
+				grcftwc.OptOutLink = ""
 				break
 			}
 		}
@@ -246,11 +329,11 @@ func ConfigFromTokenWithChecks(token string, ignoreTimeAndSentCountCheck bool) G
 // GetAutocabConfigsWithChecks - get list of Autocab configs with some checks, these are then used to make request to dispatchers (polling)
 // ignoreTimeAndSentCountCheck - ignores the time and daily sent count checks (used for testing on front end)
 func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleReviewsConfigFromTokenWithChecks {
-	qry := "SELECT config.min_send_frequency, config.max_send_count, config.max_daily_send_count, config.telephone_parameter," +
+	qry := "SELECT config.min_send_frequency, config.max_send_count, config.max_daily_send_count, config.pacing_enabled, config.telephone_parameter," +
 		" config.send_from_icabbi_app, config.app_key, config.secret_key," +
-		" config.send_url, config.http_get, config.send_success_response, times.start, times.end," +
+		" config.send_url, config.http_get, config.tls_skip_verify, config.send_success_response, times.start, times.end," +
 		" times.sunday, times.monday, times.tuesday, times.wednesday, times.thursday, times.friday, times.saturday," +
-		" config.time_zone, client.id, client.country," +
+		" config.time_zone, client.id, config.id, client.country," +
 		" config.multi_message_enabled, config.message_parameter, config.multi_message_separator," +
 		" config.use_database_message, config.message," +
 		" config.send_delay_enabled, config.send_delay," +
@@ -260,8 +343,11 @@ func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleRevie
 		" config.review_master_sms_gateway_enabled," +
 		" config.review_master_sms_gateway_use_master_queue," +
 		" config.review_master_sms_gateway_pair_code," +
-		" config.alternate_message_service_enabled, config.alternate_message_service, config.alternate_message_service_secret1," +
-		" config.companies, config.booking_source_mobile_app_state, config.dispatcher_type" +
+		" config.alternate_message_service_enabled, config.alternate_message_service, config.alternate_message_service_secret1, config.alternate_message_service_sender," +
+		" config.message_channel, config.whatsapp_phone_number_id, config.whatsapp_access_token, config.whatsapp_template_name," +
+		" config.whatsapp_template_language, config.whatsapp_template_parameters, config.whatsapp_sms_fallback," +
+		" config.email_enabled, config.email_parameter, config.email_subject, IFNULL(config.email_template, '')," +
+		" config.companies, config.booking_source_mobile_app_state, config.dispatcher_type, config.review_link, config.opt_out_link" +
 		" FROM google_reviews_config_times AS times" +
 		" JOIN google_reviews_configs AS config ON config.id = times.google_reviews_config_id" +
 		" JOIN clients AS client ON client.id = config.client_id" +
@@ -280,11 +366,11 @@ func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleRevie
 	defer rows.Close()
 	for rows.Next() {
 		var grcftwc GoogleReviewsConfigFromTokenWithChecks
-		if err1 := rows.Scan(&grcftwc.MinSendFrequency, &grcftwc.MaxSendCount, &grcftwc.MaxDailySendCount,
+		if err1 := rows.Scan(&grcftwc.MinSendFrequency, &grcftwc.MaxSendCount, &grcftwc.MaxDailySendCount, &grcftwc.PacingEnabled,
 			&grcftwc.TelephoneParameter, &grcftwc.SendFromIcabbiApp, &grcftwc.AppKey, &grcftwc.SecretKey,
-			&grcftwc.SendURL, &grcftwc.HttpGet, &grcftwc.SendSuccessResponse, &grcftwc.Start, &grcftwc.End,
+			&grcftwc.SendURL, &grcftwc.HttpGet, &grcftwc.TLSSkipVerify, &grcftwc.SendSuccessResponse, &grcftwc.Start, &grcftwc.End,
 			&grcftwc.Sunday, &grcftwc.Monday, &grcftwc.Tuesday, &grcftwc.Wednesday, &grcftwc.Thursday, &grcftwc.Friday,
-			&grcftwc.Saturday, &grcftwc.TimeZone, &grcftwc.ClientID, &grcftwc.Country,
+			&grcftwc.Saturday, &grcftwc.TimeZone, &grcftwc.ClientID, &grcftwc.ConfigID, &grcftwc.Country,
 			&grcftwc.MultiMessageEnabled, &grcftwc.MessageParameter, &grcftwc.MultiMessageSeparator,
 			&grcftwc.UseDatabaseMessage, &grcftwc.Message,
 			&grcftwc.SendDelayEnabled, &grcftwc.SendDelay,
@@ -294,8 +380,11 @@ func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleRevie
 			&grcftwc.ReplaceTelephoneCountryCode, &grcftwc.ReplaceTelephoneCountryCodeWith,
 			&grcftwc.ReviewMasterSMSGatewayEnabled, &grcftwc.ReviewMasterSMSGatewayUseMasterQueue,
 			&grcftwc.ReviewMasterSMSGatewayPairCode,
-			&grcftwc.AlternateMessageServiceEnabled, &grcftwc.AlternateMessageService, &grcftwc.AlternateMessageServiceSecret1,
-			&grcftwc.Companies, &grcftwc.BookingSourceMobileAppState, &grcftwc.DispatcherType); err1 != nil {
+			&grcftwc.AlternateMessageServiceEnabled, &grcftwc.AlternateMessageService, &grcftwc.AlternateMessageServiceSecret1, &grcftwc.AlternateMessageServiceSender,
+			&grcftwc.MessageChannel, &grcftwc.WhatsAppPhoneNumberID, &grcftwc.WhatsAppAccessToken, &grcftwc.WhatsAppTemplateName,
+			&grcftwc.WhatsAppTemplateLanguage, &grcftwc.WhatsAppTemplateParameters, &grcftwc.WhatsAppSMSFallback,
+			&grcftwc.EmailEnabled, &grcftwc.EmailParameter, &grcftwc.EmailSubject, &grcftwc.EmailTemplate,
+			&grcftwc.Companies, &grcftwc.BookingSourceMobileAppState, &grcftwc.DispatcherType, &grcftwc.ReviewLink, &grcftwc.OptOutLink); err1 != nil {
 			log.Println("Error retrieving configs for Autocab from database whilst reading returned results. Error: ", err1)
 			return grcftwcs
 		}
@@ -304,8 +393,7 @@ func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleRevie
 		}
 		if !ignoreTimeAndSentCountCheck {
 			// check within start and end time and weekday
-			if !(utils.CheckTime(grcftwc.Start, grcftwc.End, grcftwc.TimeZone) &&
-				utils.CheckWeekday(grcftwc.Sunday, grcftwc.Monday, grcftwc.Tuesday, grcftwc.Wednesday, grcftwc.Thursday, grcftwc.Friday, grcftwc.Saturday, grcftwc.TimeZone)) {
+			if !utils.CheckWindow(grcftwc.Start, grcftwc.End, grcftwc.Days(), grcftwc.TimeZone) {
 				// log.Printf("Autocab config not found between times %s and %s for time zone %s for clientID: %d", start, end, timeZone, clientID)
 				continue
 			}
@@ -316,18 +404,24 @@ func GetAutocabConfigsWithChecks(ignoreTimeAndSentCountCheck bool) []GoogleRevie
 				continue
 			}
 		}
+		decryptConfigSecrets(&grcftwc)
 		grcftwcs = append(grcftwcs, grcftwc)
 	}
 	return grcftwcs
 }
 
 // LastSentFromTelephoneAndClient - get the last sent from telephone and client
+// The last sent is looked up by the telephone hash, or the telephone itself when not yet hashed (see hashLastSents).
+// When both are stored (sent to again before hashed) the hashed last sent is used, stopped if either is stopped.
+// NOTE: keep in line with google_reviews
 func LastSentFromTelephoneAndClient(telephone string, clientID uint64) (time.Time, uint, bool, bool) {
-	qry := "SELECT last_sent, sent_count, stop FROM google_reviews_last_sents WHERE telephone = ? AND client_id = ?"
-	rows, err := Db.Query(qry, telephone, clientID)
+	qry := "SELECT last_sent, sent_count, stop FROM google_reviews_last_sents" +
+		" WHERE client_id = ? AND (telephone_hash = ? OR (telephone_hash IS NULL AND telephone = ?))" +
+		" ORDER BY telephone_hash IS NULL"
+	rows, err := Db.Query(qry, clientID, utils.HashTelephone(telephone), telephone)
 	if err != nil {
 		log.Println(err)
-		return time.Now().AddDate(-3, 0, 0), 0, false, false
+		return time.Now().AddDate(-3, 0, 0), 0, GlobalStop(telephone), false
 	}
 	defer rows.Close()
 	rows.Next()
@@ -339,42 +433,108 @@ func LastSentFromTelephoneAndClient(telephone string, clientID uint64) (time.Tim
 	if err1 := rows.Scan(&lastSent, &sentCount, &stop); err1 != nil {
 		// fmt.Println("err1: ", err1)
 		// time 3 years earlier which will be before the minimum send frequency
-		return time.Now().AddDate(-3, 0, 0), 0, false, false
+		return time.Now().AddDate(-3, 0, 0), 0, GlobalStop(telephone), false
+	}
+	for rows.Next() {
+		var (
+			l time.Time
+			c uint
+			s bool
+		)
+		if err1 := rows.Scan(&l, &c, &s); err1 == nil && s {
+			stop = true
+		}
+	}
+	// telephone opted out for all clients
+	if !stop {
+		stop = GlobalStop(telephone)
 	}
 	return lastSent, sentCount, stop, true
 }
 
 // UpdateLastSent - update the last sent
 // If this has been requested then the stop (sending) will always be false
+// Only the telephone hash is stored (see utils.HashTelephone), a last sent stored before the telephone hash is
+// replaced when hashed by google_reviews (see HashStoredTelephones)
+// NOTE: keep in line with google_reviews
 func UpdateLastSent(telephone string, clientID uint64, sentCount uint) {
 	qry := "INSERT INTO google_reviews_last_sents" +
-		" (telephone, client_id, last_sent, last_sent_date, sent_count, stop)" +
+		" (telephone_hash, client_id, last_sent, last_sent_date, sent_count, stop)" +
 		" VALUES (?, ?, NOW(), CURDATE(), ?, FALSE)" +
 		" ON DUPLICATE KEY UPDATE" +
 		" last_sent = NOW()," +
 		" last_sent_date = CURDATE()," +
 		" sent_count = ?"
-	_, err := Db.Exec(qry, telephone, clientID, sentCount, sentCount)
+	_, err := Db.Exec(qry, utils.HashTelephone(telephone), clientID, sentCount, sentCount)
 	if err != nil {
 		log.Println(err)
 	}
 }
 
+// hashLastSents - hash the telephone of the last sents of the client stored before the telephone hash, the telephone
+// is removed. A last sent already added with the telephone hash (see UpdateLastSent) replaces the one stored before,
+// keeping its stop.
+// NOTE: keep in line with google_reviews
+func hashLastSents(telephone string, clientID uint64) {
+	telephoneHash := utils.HashTelephone(telephone)
+	qry := "UPDATE google_reviews_last_sents AS hashed" +
+		" JOIN google_reviews_last_sents AS legacy ON legacy.client_id = hashed.client_id" +
+		" SET hashed.stop = TRUE" +
+		" WHERE hashed.telephone_hash = ? AND legacy.telephone = ? AND legacy.telephone_hash IS NULL AND legacy.stop = TRUE" +
+		" AND legacy.client_id = ?"
+	if _, err := Db.Exec(qry, telephoneHash, telephone, clientID); err != nil {
+		log.Printf("Error hashing the last sents of telephone: %s, err: %v\n", utils.MaskTelephone(telephone), err)
+		return
+	}
+	qry = "DELETE legacy FROM google_reviews_last_sents AS legacy" +
+		" JOIN google_reviews_last_sents AS hashed ON hashed.client_id = legacy.client_id" +
+		" WHERE hashed.telephone_hash = ? AND legacy.telephone = ? AND legacy.telephone_hash IS NULL" +
+		" AND legacy.client_id = ?"
+	if _, err := Db.Exec(qry, telephoneHash, telephone, clientID); err != nil {
+		log.Printf("Error hashing the last sents of telephone: %s, err: %v\n", utils.MaskTelephone(telephone), err)
+		return
+	}
+	qry = "UPDATE google_reviews_last_sents" +
+		" SET telephone_hash = ?, telephone = NULL" +
+		" WHERE telephone = ? AND telephone_hash IS NULL AND client_id = ?"
+	if _, err := Db.Exec(qry, telephoneHash, telephone, clientID); err != nil {
+		log.Printf("Error hashing the last sents of telephone: %s, err: %v\n", utils.MaskTelephone(telephone), err)
+	}
+}
+
 // StopSending - stop sending messages
 func StopSending(telephone string, clientID uint64) {
-	qry := "UPDATE google_reviews_last_sents" +
-		" SET stop = TRUE" +
-		" WHERE telephone = ? and client_id = ?"
-	_, err := Db.Exec(qry, telephone, clientID)
+	hashLastSents(telephone, clientID)
+	// a stop before any message is sent by the client (e.g. sent by another service) is kept, the last sent date is
+	// left at its default so the stop is not counted as sent today (see DailySentCount)
+	qry := "INSERT INTO google_reviews_last_sents" +
+		" (telephone_hash, client_id, last_sent, sent_count, stop)" +
+		" VALUES (?, ?, NOW(), 0, TRUE)" +
+		" ON DUPLICATE KEY UPDATE stop = TRUE"
+	_, err := Db.Exec(qry, utils.HashTelephone(telephone), clientID)
 	if err != nil {
 		log.Println(err)
 	}
 }
 
+// GlobalStop - check whether telephone has opted out for all clients (global stop)
+func GlobalStop(telephone string) bool {
+	qry := "SELECT COUNT(id) FROM google_reviews_global_stops" +
+		" WHERE telephone_hash = ? OR (telephone_hash IS NULL AND telephone = ?)"
+	var count int
+	if err := Db.QueryRow(qry, utils.HashTelephone(telephone), telephone).Scan(&count); err != nil {
+		log.Println(err)
+		return false
+	}
+	return count > 0
+}
+
 // DailySentCount - daily sent count - used for throttling, prevent too many SMS being sent
 func DailySentCount(clientID uint64) uint {
-	qry := "SELECT COUNT(id) FROM google_reviews_last_sents WHERE last_sent_date = CURDATE() AND client_id = ?"
-	row := Db.QueryRow(qry, clientID)
+	// emails are counted with the SMS (an unsubscribed email address not yet emailed has a sent count of 0)
+	qry := "SELECT (SELECT COUNT(id) FROM google_reviews_last_sents WHERE last_sent_date = CURDATE() AND client_id = ?)" +
+		" + (SELECT COUNT(id) FROM google_reviews_email_last_sents WHERE last_sent_date = CURDATE() AND client_id = ? AND sent_count > 0)"
+	row := Db.QueryRow(qry, clientID, clientID)
 	var count uint
 	err := row.Scan(&count)
 	switch {
@@ -389,6 +549,21 @@ func DailySentCount(clientID uint64) uint {
 	}
 }
 
+// DailySendLaterCount - get the count of the send laters for the client due to be sent today, the messages deferred
+// (e.g. by pacing or the send delay) that will be counted by the daily sent count when sent. Held fallbacks and
+// resends are not counted as the message has already been counted.
+// NOTE: keep in line with google_reviews
+func DailySendLaterCount(clientID uint64) uint {
+	qry := "SELECT COUNT(id) FROM google_reviews_send_laters" +
+		" WHERE client_id = ? AND held = 0 AND resend = 0 AND send_after < CURDATE() + INTERVAL 1 DAY"
+	var count uint
+	if err := Db.QueryRow(qry, clientID).Scan(&count); err != nil {
+		log.Println(err)
+		return 0
+	}
+	return count
+}
+
 // SetReviewMasterSMSGatewayMasterQueueID - set the Review Master SMS Gateway Master Queue ID
 func SetReviewMasterSMSGatewayMasterQueueID() {
 	qry := "SELECT MAX(id) FROM review_master_sms_gateway_master_queues"
@@ -401,11 +576,13 @@ func SetReviewMasterSMSGatewayMasterQueueID() {
 }
 
 // AddSendLater - add send later for messages that are delayed (send later)
+// fallbackRef - set for a fallback (e.g. SMS when sent via WhatsApp) which is held until released by google_reviews
+// tlsSkipVerify - the TLS certificate is not verified when sent (the config opted out of TLS verification)
 func AddSendLater(telephone string, clientID uint64, sendAfterMinutes int,
 	sendURL string, method string, appKey string, secretKey string, headers map[string]string,
 	params url.Values, body []byte, sendFromIcabbiApp bool, reviewMasterSMSGatewayEnabled bool,
 	alternateMessageServiceEnabled bool, alternateMessageService string, sendFromOwnSMSGatewayEnabled bool,
-	sendSuccessResponse string, maxDailySendCount uint) {
+	sendSuccessResponse string, maxDailySendCount uint, fallbackRef string, tlsSkipVerify bool) {
 
 	// serialize headers
 	h := new(bytes.Buffer)
@@ -414,18 +591,21 @@ func AddSendLater(telephone string, clientID uint64, sendAfterMinutes int,
 	if err != nil {
 		h = nil
 	}
-	httpHeaders := h.Bytes()
-
-	// serialize params
-	httpParams := params.Encode()
+	// secrets are stored encrypted, the headers and params are encrypted as they carry secrets too (e.g. the
+	// iCabbi app and secret keys in the params, the Veezu auth token or WhatsApp access token in the headers)
+	// NOTE: keep in line with google_reviews, the send laters are sent by google_reviews
+	httpHeaders := []byte(encryptSecret(string(h.Bytes())))
+	httpParams := encryptSecret(params.Encode())
+	appKey = encryptSecret(appKey)
+	secretKey = encryptSecret(secretKey)
 
 	qry := "INSERT INTO google_reviews_send_laters" +
 		" (telephone, send_after, send_url, http_method, app_key, secret_key," +
 		" http_headers, http_params, http_body, send_from_icabbi_app," +
 		" review_master_sms_gateway_enabled, alternate_message_service_enabled," +
 		" alternate_message_service, send_from_own_sms_gateway_enabled," +
-		" send_success_response, max_daily_send_count, client_id)" +
-		" VALUES (?, DATE_ADD(NOW(), INTERVAL ? MINUTE), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)" +
+		" send_success_response, max_daily_send_count, tls_skip_verify, client_id, fallback_ref, held)" +
+		" VALUES (?, DATE_ADD(NOW(), INTERVAL ? MINUTE), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)" +
 		" ON DUPLICATE KEY UPDATE" +
 		" send_after = DATE_ADD(NOW(), INTERVAL ? MINUTE)," +
 		" send_url = ?," +
@@ -441,15 +621,19 @@ func AddSendLater(telephone string, clientID uint64, sendAfterMinutes int,
 		" alternate_message_service = ?," +
 		" send_from_own_sms_gateway_enabled = ?," +
 		" send_success_response	= ?," +
-		" max_daily_send_count = ?"
+		" max_daily_send_count = ?," +
+		" tls_skip_verify = ?," +
+		" claimed_by = ''," +
+		" claimed_until = NULL," +
+		" attempts = 0"
 	_, err = Db.Exec(qry, telephone, sendAfterMinutes, sendURL, method, appKey, secretKey,
 		httpHeaders, httpParams, body, sendFromIcabbiApp, reviewMasterSMSGatewayEnabled,
 		alternateMessageServiceEnabled, alternateMessageService, sendFromOwnSMSGatewayEnabled,
-		sendSuccessResponse, maxDailySendCount, clientID,
+		sendSuccessResponse, maxDailySendCount, tlsSkipVerify, clientID, fallbackRef, fallbackRef != "",
 		sendAfterMinutes, sendURL, method, appKey, secretKey, httpHeaders, httpParams,
 		body, sendFromIcabbiApp, reviewMasterSMSGatewayEnabled,
 		alternateMessageServiceEnabled, alternateMessageService, sendFromOwnSMSGatewayEnabled,
-		sendSuccessResponse, maxDailySendCount)
+		sendSuccessResponse, maxDailySendCount, tlsSkipVerify)
 	if err != nil {
 		log.Println(err)
 	}
@@ -471,3 +655,157 @@ func UpdateStatsWithCounts(clientID uint64, sentCount int, requestedCount int) {
 		log.Println(err)
 	}
 }
+
+// AddMessageEvent - record why a message was or wasn't sent for a telephone (stored hashed)
+// The channel is the message service used (if known), the provider response and latency are from sending the message.
+// Returns the message event id (0 if not recorded).
+func AddMessageEvent(clientID uint64, telephone string, channel string, reason string, providerResponse string, latency time.Duration) uint64 {
+	return AddMessageEventWithVariant(clientID, telephone, channel, reason, "", providerResponse, latency)
+}
+
+// AddMessageEventWithVariant - record a message event with the message variant sent (see AddMessageEvent)
+func AddMessageEventWithVariant(clientID uint64, telephone string, channel string, reason string, variant string, providerResponse string, latency time.Duration) uint64 {
+	if r := []rune(providerResponse); len(r) > maxProviderResponseLength {
+		providerResponse = string(r[:maxProviderResponseLength])
+	}
+	recordMessageEventMetrics(channel, reason, latency)
+	qry := "INSERT INTO google_reviews_message_events" +
+		" (client_id, telephone_hash, channel, variant, reason, provider_response, latency_ms, created)" +
+		" VALUES (?, ?, ?, ?, ?, ?, ?, NOW())"
+	res, err := Db.Exec(qry, clientID, utils.HashTelephone(telephone), channel, variant, reason, providerResponse, latency.Milliseconds())
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return uint64(id)
+}
+
+// BarredTelephones - get the barred telephone prefixes and full numbers (a client ID of 0 is barred for all clients)
+func BarredTelephones() ([]barred.Entry, error) {
+	qry := "SELECT client_id, telephone, full_number" +
+		" FROM google_reviews_barred_telephones" +
+		" ORDER BY id"
+	var entries []barred.Entry
+	rows, err := Db.Query(qry)
+	if err != nil {
+		log.Println("Error retrieving barred telephones from database. Error: ", err)
+		return entries, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e barred.Entry
+		if err := rows.Scan(&e.ClientID, &e.Telephone, &e.FullNumber); err != nil {
+			log.Println("Error retrieving barred telephones from database whilst reading returned results. Error: ", err)
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// MessageVariant - represents a named message variant of a config used for A/B testing messages
+type MessageVariant struct {
+	ID       uint64
+	Name     string
+	Language string // language of the variant, empty for a variant of the config message (see ConfigMessages)
+	Message  string
+	Weight   uint
+}
+
+// MessageVariants - get the enabled message variants for a config
+func MessageVariants(configID uint64) []MessageVariant {
+	qry := "SELECT id, name, language, message, weight" +
+		" FROM google_reviews_message_variants" +
+		" WHERE google_reviews_config_id = ?" +
+		" AND enabled = 1" +
+		" ORDER BY id"
+	var messageVariants []MessageVariant
+	rows, err := Db.Query(qry, configID)
+	if err != nil {
+		log.Println("Error retrieving message variants for config", configID, "from database. Error: ", err)
+		return messageVariants
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mv MessageVariant
+		if err := rows.Scan(&mv.ID, &mv.Name, &mv.Language, &mv.Message, &mv.Weight); err != nil {
+			log.Println("Error retrieving message variants for config", configID, "from database whilst reading returned results. Error: ", err)
+			return messageVariants
+		}
+		messageVariants = append(messageVariants, mv)
+	}
+	return messageVariants
+}
+
+// ConfigMessages - get the messages by language of a config, the message of the booking language replaces the config
+// message
+func ConfigMessages(configID uint64) map[string]string {
+	qry := "SELECT language, message" +
+		" FROM google_reviews_config_messages" +
+		" WHERE google_reviews_config_id = ?"
+	messages := make(map[string]string)
+	rows, err := Db.Query(qry, configID)
+	if err != nil {
+		log.Println("Error retrieving messages by language for config", configID, "from database. Error: ", err)
+		return messages
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var language, message string
+		if err := rows.Scan(&language, &message); err != nil {
+			log.Println("Error retrieving messages by language for config", configID, "from database whilst reading returned results. Error: ", err)
+			return messages
+		}
+		messages[language] = message
+	}
+	return messages
+}
+
+// AddShortLink - add a short link code for the client that redirects to the url, the variant is the message variant sent
+// Returns the short link id (0 if not added e.g. the code already exists).
+func AddShortLink(code string, clientID uint64, variant string, url string) uint64 {
+	qry := "INSERT INTO google_reviews_short_links" +
+		" (code, client_id, variant, url, created)" +
+		" VALUES (?, ?, ?, ?, NOW())"
+	res, err := Db.Exec(qry, code, clientID, variant, url)
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return uint64(id)
+}
+
+// SetMessageEventProviderMessageID - record the message ID returned by the message service against the message
+// event of the message sent so the delivery status callbacks can be matched
+// NOTE: keep in line with google_reviews
+func SetMessageEventProviderMessageID(messageEventID uint64, providerMessageID string) {
+	if messageEventID == 0 || providerMessageID == "" {
+		return
+	}
+	qry := "UPDATE google_reviews_message_events SET provider_message_id = ? WHERE id = ?"
+	if _, err := Db.Exec(qry, providerMessageID, messageEventID); err != nil {
+		log.Printf("Error setting provider message ID: %s of message event ID: %d, err: %v\n", providerMessageID, messageEventID, err)
+	}
+}
+
+// SetShortLinkMessageEvent - set the message event for a short link once the message has been sent (or deferred)
+func SetShortLinkMessageEvent(shortLinkID uint64, messageEventID uint64) {
+	if shortLinkID == 0 || messageEventID == 0 {
+		return
+	}
+	qry := "UPDATE google_reviews_short_links SET message_event_id = ? WHERE id = ?"
+	_, err := Db.Exec(qry, messageEventID, shortLinkID)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -1,12 +1,17 @@
 package database
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"google_reviews_autocab/autocab_api_v1"
+	"google_reviews_autocab/secrets"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -282,10 +287,13 @@ func TestStopSendingNoRecord(t *testing.T) {
 	StopSending(telephone, clientID)
 	lastSent, sentCount, stop, found := LastSentFromTelephoneAndClient(telephone, clientID)
 	fmt.Println("lastSent: ", lastSent, " sentCount: ", sentCount, " stop: ", stop, " found: ", found)
-	// check found is false
-	if found {
+	// a stop is kept before any message is sent
+	if !found || !stop || sentCount != 0 {
 		t.Fatal("stop should be set to true")
 	}
+	if count := DailySentCount(clientID); count != 1 {
+		t.Fatal("a stop should not be counted as sent today, daily sent count: ", count)
+	}
 }
 
 func TestDailySentCount(t *testing.T) {
@@ -297,6 +305,65 @@ func TestDailySentCount(t *testing.T) {
 	}
 }
 
+func TestDailySendLaterCount(t *testing.T) {
+	prepareTestDatabase()
+	var clientID uint64 = 2
+	if _, err := Db.Exec("DELETE FROM google_reviews_send_laters WHERE client_id = ?", clientID); err != nil {
+		t.Fatal(err)
+	}
+	AddSendLater("447000000002", clientID, 5, "https://example.com/send", "POST", "", "",
+		nil, url.Values{}, nil, false, false, false, "", false, "", 20, "", false)
+	// a held fallback is not counted
+	AddSendLater("447000000003", clientID, 5, "https://example.com/send", "POST", "", "",
+		nil, url.Values{}, nil, false, false, false, "", false, "", 20, "fallback-ref", false)
+	if count := DailySendLaterCount(clientID); count != 1 {
+		t.Fatalf("daily send later count should be 1 got: %d", count)
+	}
+}
+
+func TestAddSendLaterEncryptsSecrets(t *testing.T) {
+	prepareTestDatabase()
+	keyFile := filepath.Join(t.TempDir(), "secrets.key")
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyProvider, err := secrets.NewFileKeyProvider(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.SetKeyProvider(keyProvider)
+	defer secrets.SetKeyProvider(nil)
+	if _, err := Db.Exec("DELETE FROM google_reviews_data_keys"); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadDataKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	var clientID uint64 = 2
+	AddSendLater("447000000004", clientID, 5, "https://example.com/send", "GET", "app-key", "secret-key",
+		map[string]string{"auth_token": "auth-token"}, url.Values{"secret_key": {"secret-key"}}, nil, true, false, false, "",
+		false, "", 20, "", false)
+	var appKey, secretKey, headers, params string
+	if err := Db.QueryRow("SELECT app_key, secret_key, http_headers, http_params FROM google_reviews_send_laters"+
+		" WHERE client_id = ? AND telephone = ?", clientID, "447000000004").Scan(&appKey, &secretKey, &headers, &params); err != nil {
+		t.Fatal(err)
+	}
+	if !secrets.IsEncrypted(appKey) || !secrets.IsEncrypted(secretKey) {
+		t.Fatalf("expected the secrets stored encrypted got: %s %s", appKey, secretKey)
+	}
+	if decryptSecret(appKey) != "app-key" || decryptSecret(secretKey) != "secret-key" {
+		t.Fatal("expected the stored secrets to decrypt")
+	}
+	// the headers and params carry secrets too
+	if !secrets.IsEncrypted(headers) || !secrets.IsEncrypted(params) || strings.Contains(params, "secret-key") {
+		t.Fatalf("expected the headers and params stored encrypted got: %s %s", headers, params)
+	}
+	if decryptSecret(params) != "secret_key=secret-key" {
+		t.Fatal("expected the stored params to decrypt")
+	}
+}
+
 func TestAddSendLater1(t *testing.T) {
 	prepareTestDatabase()
 	var clientID uint64 = 1
@@ -321,7 +388,7 @@ func TestAddSendLater1(t *testing.T) {
 
 	AddSendLater(telephone, clientID, 5,
 		"https://api.messagemedia.com/v1/messages", "POST", "12348GYxCGv5abcES7GF", "2HGFUd9i987Gv6018D1234cNhHDRONH",
-		headers, params1, body, true, false, false, "", false, "success=1", 20)
+		headers, params1, body, true, false, false, "", false, "success=1", 20, "", false)
 }
 
 func TestAddSendLater2(t *testing.T) {
@@ -344,7 +411,7 @@ func TestAddSendLater2(t *testing.T) {
 
 	AddSendLater(telephone, clientID, 5,
 		"https://autocab-api.azure-api.net/sms/v1/send", "POST", "", "",
-		headers, nil, body, false, false, true, "AUTOCAB_V1", false, "ok", 20)
+		headers, nil, body, false, false, true, "AUTOCAB_V1", false, "ok", 20, "", false)
 }
 
 func TestUpdateStatsWithCountsSent(t *testing.T) {
@@ -424,3 +491,58 @@ func TestUpdateStatsWithCountsNotSentClientID0(t *testing.T) {
 		t.Fatal("Error there should be no results for stats for clientID", clientID, "from database. Error: ", err)
 	}
 }
+
+func TestBarredTelephones(t *testing.T) {
+	prepareTestDatabase()
+	entries, err := BarredTelephones()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ClientID != 0 || entries[0].Telephone != "447418" || entries[0].FullNumber ||
+		entries[1].ClientID != 12 || entries[1].Telephone != "447123456701" || !entries[1].FullNumber {
+		t.Fatalf("unexpected barred telephones: %+v", entries)
+	}
+}
+
+func TestClaimBooking(t *testing.T) {
+	prepareTestDatabase()
+	// disabled
+	if claimed, _ := ClaimBooking(12, "1001"); !claimed {
+		t.Fatal("booking should be processed when the processed bookings are disabled")
+	}
+	EnableProcessedBookings(time.Hour, time.Minute)
+	defer EnableProcessedBookings(0, 0)
+
+	// already processed (by a previous poll)
+	if claimed, outcome := ClaimBooking(12, "1001"); claimed || outcome != "sent" {
+		t.Fatalf("booking should have already been processed, claimed: %v, outcome: %s", claimed, outcome)
+	}
+	// expired
+	if claimed, _ := ClaimBooking(12, "1002"); !claimed {
+		t.Fatal("expired booking should be processed again")
+	}
+	// new booking
+	if claimed, _ := ClaimBooking(12, "1003"); !claimed {
+		t.Fatal("new booking should be processed")
+	}
+	CompleteBooking(12, "1003", "not_sent")
+	if claimed, outcome := ClaimBooking(12, "1003"); claimed || outcome != "not_sent" {
+		t.Fatalf("booking should not be processed again, claimed: %v, outcome: %s", claimed, outcome)
+	}
+	// the claim of a booking not completed (e.g. the process stopped) expires after the lease
+	EnableProcessedBookings(time.Hour, time.Second)
+	if claimed, _ := ClaimBooking(12, "1004"); !claimed {
+		t.Fatal("new booking should be processed")
+	}
+	time.Sleep(2 * time.Second)
+	if claimed, _ := ClaimBooking(12, "1004"); !claimed {
+		t.Fatal("booking should be processed again once the claim has expired")
+	}
+}
+
+func TestPurgeProcessedBookings(t *testing.T) {
+	prepareTestDatabase()
+	if n := PurgeProcessedBookings(); n != 1 {
+		t.Fatalf("expected 1 expired processed booking to be deleted got %d", n)
+	}
+}
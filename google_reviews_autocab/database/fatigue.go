@@ -0,0 +1,90 @@
+package database
+
+import (
+	"log"
+
+	"google_reviews_autocab/utils"
+)
+
+// FatiguePolicy - limit of the review requests to a passenger across clients, at most MaxRequests review requests are
+// sent (or deferred) to the telephone in Days days. The policy of partner ID 0 counts the review requests of all
+// clients, the policy of a partner counts the review requests of the partner's clients.
+// NOTE: keep in line with google_reviews
+type FatiguePolicy struct {
+	PartnerID   uint64
+	MaxRequests uint
+	Days        uint
+}
+
+// FatiguePolicies - get the fatigue policies for all clients and for the partner of the client
+// NOTE: keep in line with google_reviews
+func FatiguePolicies(clientID uint64) []FatiguePolicy {
+	qry := "SELECT partner_id, max_requests, days" +
+		" FROM google_reviews_fatigue_policies" +
+		" WHERE partner_id = 0" +
+		" OR partner_id = (SELECT partner_id FROM clients WHERE id = ?)" +
+		" ORDER BY partner_id"
+	var policies []FatiguePolicy
+	rows, err := Db.Query(qry, clientID)
+	if err != nil {
+		log.Println("Error retrieving fatigue policies for client", clientID, "from database. Error: ", err)
+		return policies
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p FatiguePolicy
+		if err := rows.Scan(&p.PartnerID, &p.MaxRequests, &p.Days); err != nil {
+			log.Println("Error retrieving fatigue policies for client", clientID, "from database whilst reading returned results. Error: ", err)
+			return policies
+		}
+		policies = append(policies, p)
+	}
+	return policies
+}
+
+// FatigueRequests - the number of review requests sent or deferred to the telephone by the clients (of the partner, 0
+// for all clients) in the last days. A deferred review request is counted once, it is not counted again when sent.
+// NOTE: keep in line with google_reviews
+func FatigueRequests(telephone string, partnerID uint64, days uint) (uint, error) {
+	qry := "SELECT COUNT(*) FROM google_reviews_message_events AS event" +
+		" WHERE event.telephone_hash = ?" +
+		" AND event.created > NOW() - INTERVAL ? DAY" +
+		" AND (event.reason = ? OR (event.reason = ? AND NOT EXISTS (" +
+		" SELECT 1 FROM google_reviews_message_events AS sent" +
+		" WHERE sent.telephone_hash = event.telephone_hash AND sent.client_id = event.client_id" +
+		" AND sent.reason = ? AND sent.created >= event.created)))"
+	args := []interface{}{utils.HashTelephone(telephone), days, ReasonSent, ReasonDeferred, ReasonSent}
+	if partnerID != 0 {
+		qry += " AND event.client_id IN (SELECT id FROM clients WHERE partner_id = ?)"
+		args = append(args, partnerID)
+	}
+	var requests uint
+	if err := Db.QueryRow(qry, args...).Scan(&requests); err != nil {
+		log.Println("Error counting review requests for fatigue policy of partner", partnerID, "from database. Error: ", err)
+		return 0, err
+	}
+	return requests, nil
+}
+
+// FatigueCapped - check whether a review request to the telephone by the client would exceed a fatigue policy, returns
+// the policy exceeded. A policy without max requests or days is not checked and the review request is allowed when
+// the review requests cannot be counted.
+// NOTE: keep in line with google_reviews
+func FatigueCapped(telephone string, clientID uint64) (FatiguePolicy, bool) {
+	if telephone == "" {
+		return FatiguePolicy{}, false
+	}
+	for _, p := range FatiguePolicies(clientID) {
+		if p.MaxRequests == 0 || p.Days == 0 {
+			continue
+		}
+		requests, err := FatigueRequests(telephone, p.PartnerID, p.Days)
+		if err != nil {
+			continue
+		}
+		if requests >= p.MaxRequests {
+			return p, true
+		}
+	}
+	return FatiguePolicy{}, false
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"testing"
+)
+
+// NOTE: keep in line with google_reviews
+func TestFatigueCapped(t *testing.T) {
+	prepareTestDatabase()
+	const telephone = "447700900123"
+	if _, capped := FatigueCapped(telephone, 1); capped {
+		t.Fatal("review request should not be capped without a fatigue policy for the client")
+	}
+	// at most 2 review requests in 7 days for all clients, at most 1 in 7 days for the clients of partner 2
+	if _, err := Db.Exec("INSERT INTO google_reviews_fatigue_policies (partner_id, max_requests, days) VALUES (0, 2, 7), (2, 1, 7)"); err != nil {
+		t.Fatal("error adding fatigue policies, err: ", err)
+	}
+	if policies := FatiguePolicies(3); len(policies) != 2 || policies[0].PartnerID != 0 || policies[1].PartnerID != 2 {
+		t.Fatalf("unexpected fatigue policies for client 3: %+v", policies)
+	}
+	if policies := FatiguePolicies(1); len(policies) != 1 || policies[0].PartnerID != 0 {
+		t.Fatalf("unexpected fatigue policies for client 1: %+v", policies)
+	}
+
+	// client 1 (partner 1) sent, the other events are not review requests
+	AddMessageEvent(1, telephone, "HTTP", ReasonSent, "OK", 0)
+	AddMessageEvent(2, telephone, "HTTP", ReasonTooRecent, "", 0)
+	if _, capped := FatigueCapped(telephone, 2); capped {
+		t.Fatal("review request should not be capped after one review request")
+	}
+	if _, capped := FatigueCapped(telephone, 3); capped {
+		t.Fatal("review request should not be capped by the partner policy after a review request by another partner")
+	}
+	// client 3 (partner 2) deferred
+	AddMessageEvent(3, telephone, "HTTP", ReasonDeferred, "", 0)
+	if policy, capped := FatigueCapped(telephone, 4); !capped || policy.PartnerID != 2 {
+		t.Fatalf("review request should be capped by the partner policy got: %+v", policy)
+	}
+	if policy, capped := FatigueCapped(telephone, 2); !capped || policy.PartnerID != 0 {
+		t.Fatalf("review request should be capped by the global policy got: %+v", policy)
+	}
+	// the deferred review request sent is counted once
+	AddMessageEvent(3, telephone, "HTTP", ReasonSent, "OK", 0)
+	if requests, err := FatigueRequests(telephone, 0, 7); err != nil || requests != 2 {
+		t.Fatalf("expected 2 review requests got: %d, err: %v", requests, err)
+	}
+	// each review request is counted, not each client
+	AddMessageEvent(1, telephone, "HTTP", ReasonSent, "OK", 0)
+	if requests, err := FatigueRequests(telephone, 0, 7); err != nil || requests != 3 {
+		t.Fatalf("expected 3 review requests got: %d, err: %v", requests, err)
+	}
+	const otherTelephone = "447700900456"
+	if _, capped := FatigueCapped(otherTelephone, 2); capped {
+		t.Fatal("review request to another telephone should not be capped")
+	}
+	AddMessageEvent(1, otherTelephone, "HTTP", ReasonSent, "OK", 0)
+	AddMessageEvent(1, otherTelephone, "HTTP", ReasonSent, "OK", 0)
+	if policy, capped := FatigueCapped(otherTelephone, 1); !capped || policy.PartnerID != 0 {
+		t.Fatalf("review requests by one client should be capped by the global policy got: %+v", policy)
+	}
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"time"
+
+	"google_reviews_autocab/metrics"
+)
+
+var (
+	// messageEventsTotal - message events by channel and reason (why a message was or wasn't sent)
+	messageEventsTotal = metrics.NewCounterVec("google_reviews_autocab_message_events_total",
+		"Message events by message service and reason.", "channel", "reason")
+	// providerSendDuration - time taken by the message service to send a message
+	providerSendDuration = metrics.NewHistogramVec("google_reviews_autocab_provider_send_duration_seconds",
+		"Time taken by the message service to send a message.", metrics.DefaultBuckets, "channel", "reason")
+)
+
+// RegisterMetrics - register the database connection pool stats metrics, call once the database is open
+func RegisterMetrics() {
+	metrics.RegisterDBStats("google_reviews_autocab", Db)
+}
+
+// recordMessageEventMetrics - count the message event and observe the send latency when the message was sent
+func recordMessageEventMetrics(channel string, reason string, latency time.Duration) {
+	messageEventsTotal.Inc(channel, reason)
+	if reason == ReasonSent || reason == ReasonProviderError {
+		providerSendDuration.ObserveDuration(latency, channel, reason)
+	}
+}
@@ -0,0 +1,111 @@
+package email
+
+// email - the email channel, bookings without a mobile telephone are emailed (configs with email enabled) via SMTP or
+// SendGrid using an HTML template with a signed unsubscribe link
+// NOTE: keep in line with the email package in google_reviews and google_reviews_autocab
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google_reviews_autocab/config"
+)
+
+// Channel - channel of the emails (recorded as the channel in message events)
+const Channel = "Email"
+
+// UnsubscribePath - path of the unsubscribe link (see UnsubscribeLink)
+const UnsubscribePath = "/email/unsubscribe"
+
+// maxAddressLength - maximum length of an email address (the length of the email last sents email column)
+const maxAddressLength = 254
+
+// Email - email to send, the HTML and text are alternatives of the same content
+type Email struct {
+	FromAddress     string
+	FromName        string
+	To              string
+	Subject         string
+	HTML            string
+	Text            string
+	UnsubscribeLink string
+}
+
+// Service - email service used to send the emails (see New)
+type Service interface {
+	// Name - name of the email service
+	Name() string
+	// Send - send the email returning the provider response
+	Send(e Email) (string, error)
+}
+
+// New - the email service set up in the config (smtp or sendgrid), nil when no email service is set up
+func New() Service {
+	switch config.Conf.EmailService {
+	case "smtp":
+		if config.Conf.SMTPHost == "" {
+			log.Println("Email service smtp has no smtp host")
+			return nil
+		}
+		return NewSMTPService(config.Conf.SMTPHost, config.Conf.SMTPPort, config.Conf.SMTPUsername, config.Conf.SMTPPassword)
+	case "sendgrid":
+		if config.Conf.SendGridAPIKey == "" {
+			log.Println("Email service sendgrid has no api key")
+			return nil
+		}
+		return NewSendGridService(config.Conf.SendGridAPIKey, config.Conf.SendGridURL)
+	case "":
+		return nil
+	default:
+		log.Printf("Unknown email service: %s\n", config.Conf.EmailService)
+		return nil
+	}
+}
+
+// ValidAddress - the email address normalised (lower case), empty when not a valid email address
+func ValidAddress(address string) string {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if address == "" || len(address) > maxAddressLength {
+		return ""
+	}
+	a, err := mail.ParseAddress(address)
+	// only a bare address is accepted e.g. not "Jane <jane@example.com>"
+	if err != nil || a.Address != address || !strings.Contains(address[strings.LastIndex(address, "@"):], ".") {
+		return ""
+	}
+	return address
+}
+
+// unsubscribeSignature - signature of the client and email address signed with the unsubscribe secret
+func unsubscribeSignature(clientID uint64, address string) string {
+	mac := hmac.New(sha256.New, []byte(config.Conf.EmailUnsubscribeSecret))
+	mac.Write([]byte(strconv.FormatUint(clientID, 10) + ":" + address))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// UnsubscribeLink - signed link to stop the client emailing the address, empty when no unsubscribe base URL or
+// secret is set up
+func UnsubscribeLink(clientID uint64, address string) string {
+	if config.Conf.EmailUnsubscribeBaseURL == "" || config.Conf.EmailUnsubscribeSecret == "" {
+		return ""
+	}
+	q := url.Values{}
+	q.Set("c", strconv.FormatUint(clientID, 10))
+	q.Set("e", address)
+	q.Set("s", unsubscribeSignature(clientID, address))
+	return strings.TrimRight(config.Conf.EmailUnsubscribeBaseURL, "/") + UnsubscribePath + "?" + q.Encode()
+}
+
+// VerifyUnsubscribe - check the signature of the unsubscribe link
+func VerifyUnsubscribe(clientID uint64, address string, signature string) bool {
+	if config.Conf.EmailUnsubscribeSecret == "" || clientID == 0 || address == "" {
+		return false
+	}
+	return hmac.Equal([]byte(unsubscribeSignature(clientID, address)), []byte(signature))
+}
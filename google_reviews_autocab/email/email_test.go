@@ -0,0 +1,173 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google_reviews_autocab/config"
+	"google_reviews_autocab/utils"
+)
+
+func TestValidAddress(t *testing.T) {
+	tests := map[string]string{
+		"jane@example.com":          "jane@example.com",
+		" Jane.Doe@Example.co.uk ":  "jane.doe@example.co.uk",
+		"":                          "",
+		"jane":                      "",
+		"jane@localhost":            "",
+		"Jane <jane@example.com>":   "",
+		"jane@example.com, x@y.com": "",
+		strings.Repeat("a", 250) + "@example.com": "",
+	}
+	for address, expected := range tests {
+		if got := ValidAddress(address); got != expected {
+			t.Errorf("address: %q, expected: %q got: %q", address, expected, got)
+		}
+	}
+}
+
+func TestUnsubscribeLink(t *testing.T) {
+	if link := UnsubscribeLink(1, "jane@example.com"); link != "" {
+		t.Errorf("expected no unsubscribe link when not set up got: %s", link)
+	}
+	config.Conf.EmailUnsubscribeBaseURL = "https://reviews.example.com/"
+	config.Conf.EmailUnsubscribeSecret = "test-unsubscribe-secret"
+	defer func() {
+		config.Conf.EmailUnsubscribeBaseURL = ""
+		config.Conf.EmailUnsubscribeSecret = ""
+	}()
+
+	link := UnsubscribeLink(1, "jane+taxi@example.com")
+	u, err := url.Parse(link)
+	if err != nil || u.Host != "reviews.example.com" || u.Path != UnsubscribePath {
+		t.Fatalf("unexpected unsubscribe link: %s, err: %v", link, err)
+	}
+	q := u.Query()
+	if q.Get("c") != "1" || q.Get("e") != "jane+taxi@example.com" {
+		t.Fatalf("unexpected unsubscribe link parameters: %v", q)
+	}
+	if !VerifyUnsubscribe(1, q.Get("e"), q.Get("s")) {
+		t.Error("expected the unsubscribe link to verify")
+	}
+	if VerifyUnsubscribe(2, q.Get("e"), q.Get("s")) || VerifyUnsubscribe(1, "john@example.com", q.Get("s")) ||
+		VerifyUnsubscribe(1, q.Get("e"), "") {
+		t.Error("expected a changed unsubscribe link not to verify")
+	}
+}
+
+func TestRender(t *testing.T) {
+	values := map[string]string{utils.PlaceholderFirstName: "<Jane>", utils.PlaceholderReviewLink: "https://g.page/r/abc?x=1&y=2"}
+	body, text := Render("", "Hi Jane,\nplease review us", values, "https://reviews.example.com/email/unsubscribe?c=1")
+	for _, s := range []string{"<p>Hi Jane,<br>please review us</p>", `href="https://g.page/r/abc?x=1&amp;y=2"`,
+		`href="https://reviews.example.com/email/unsubscribe?c=1"`} {
+		if !strings.Contains(body, s) {
+			t.Errorf("expected the HTML to contain: %s, HTML: %s", s, body)
+		}
+	}
+	if text != "Hi Jane,\nplease review us\n\nUnsubscribe: https://reviews.example.com/email/unsubscribe?c=1" {
+		t.Errorf("unexpected text: %q", text)
+	}
+
+	// the values are escaped in the config template
+	body, text = Render("<h1>Hello {first_name}</h1>{message}", "Thanks", values, "")
+	if body != "<h1>Hello &lt;Jane&gt;</h1>Thanks" || text != "Thanks" {
+		t.Errorf("unexpected HTML: %s, text: %s", body, text)
+	}
+
+	if s := Subject("", map[string]string{utils.PlaceholderCompany: "Taxi Co"}); s != "How was your journey with Taxi Co?" {
+		t.Errorf("unexpected default subject: %s", s)
+	}
+	if s := Subject("Thanks {first_name}", values); s != "Thanks <Jane>" {
+		t.Errorf("unexpected subject: %s", s)
+	}
+}
+
+func TestSMTPService(t *testing.T) {
+	s := NewSMTPService("smtp.example.com", 587, "user", "password")
+	var (
+		sentAddr string
+		sentFrom string
+		sentTo   []string
+		sentMsg  string
+	)
+	s.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sentAddr, sentFrom, sentTo, sentMsg = addr, from, to, string(msg)
+		return nil
+	}
+	e := Email{FromAddress: "reviews@taxi.example.com", FromName: "Taxi Co", To: "jane@example.com", Subject: "How was your journey?",
+		HTML: "<p>Hi</p>", Text: "Hi", UnsubscribeLink: "https://reviews.example.com/email/unsubscribe?c=1"}
+	messageID, err := s.Send(e)
+	if err != nil || !strings.HasSuffix(messageID, "@taxi.example.com>") {
+		t.Fatalf("unexpected message ID: %s, err: %v", messageID, err)
+	}
+	if sentAddr != "smtp.example.com:587" || sentFrom != e.FromAddress || len(sentTo) != 1 || sentTo[0] != e.To {
+		t.Errorf("unexpected smtp addr: %s, from: %s, to: %v", sentAddr, sentFrom, sentTo)
+	}
+	for _, h := range []string{`From: "Taxi Co" <reviews@taxi.example.com>`, "To: jane@example.com",
+		"List-Unsubscribe: <https://reviews.example.com/email/unsubscribe?c=1>", "Message-ID: " + messageID,
+		"Content-Type: multipart/alternative", "Content-Type: text/plain", "Content-Type: text/html", "<p>Hi</p>"} {
+		if !strings.Contains(sentMsg, h) {
+			t.Errorf("expected the message to contain: %s, message: %s", h, sentMsg)
+		}
+	}
+}
+
+func TestSendGridService(t *testing.T) {
+	var sgm sendGridMail
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-sendgrid-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors":[{"message":"The provided authorization grant is invalid"}]}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&sgm)
+		w.Header().Set("X-Message-Id", "sg-message-id")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	e := Email{FromAddress: "reviews@taxi.example.com", FromName: "Taxi Co", To: "jane@example.com", Subject: "How was your journey?",
+		HTML: "<p>Hi</p>", Text: "Hi", UnsubscribeLink: "https://reviews.example.com/email/unsubscribe?c=1"}
+	messageID, err := NewSendGridService("test-sendgrid-key", ts.URL).Send(e)
+	if err != nil || messageID != "sg-message-id" {
+		t.Fatalf("unexpected message ID: %s, err: %v", messageID, err)
+	}
+	if len(sgm.Personalizations) != 1 || sgm.Personalizations[0].To[0].Email != e.To || sgm.From.Name != "Taxi Co" ||
+		len(sgm.Content) != 2 || sgm.Content[0].Type != "text/plain" || sgm.Content[1].Value != "<p>Hi</p>" ||
+		sgm.Headers["List-Unsubscribe"] != "<"+e.UnsubscribeLink+">" {
+		t.Errorf("unexpected sendgrid mail: %+v", sgm)
+	}
+
+	if _, err := NewSendGridService("incorrect", ts.URL).Send(e); err == nil {
+		t.Error("expected an error when not accepted")
+	}
+}
+
+func TestNew(t *testing.T) {
+	defer func() {
+		config.Conf.EmailService = ""
+		config.Conf.SMTPHost = ""
+		config.Conf.SendGridAPIKey = ""
+	}()
+	if s := New(); s != nil {
+		t.Errorf("expected no email service got: %s", s.Name())
+	}
+	config.Conf.EmailService = "smtp"
+	if s := New(); s != nil {
+		t.Errorf("expected no email service without a host got: %s", s.Name())
+	}
+	config.Conf.SMTPHost = "smtp.example.com"
+	if s := New(); s == nil || s.Name() != "smtp" {
+		t.Error("expected the smtp email service")
+	}
+	config.Conf.EmailService = "sendgrid"
+	config.Conf.SendGridAPIKey = "test-sendgrid-key"
+	if s := New(); s == nil || s.Name() != "sendgrid" {
+		t.Error("expected the sendgrid email service")
+	}
+}
@@ -0,0 +1,84 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SendGridService - send the emails via the SendGrid v3 mail send API
+// (see: https://www.twilio.com/docs/sendgrid/api-reference/mail-send/mail-send)
+type SendGridService struct {
+	apiKey string
+	url    string
+	client *http.Client
+}
+
+// NewSendGridService - SendGrid email service using the api key and mail send URL
+func NewSendGridService(apiKey string, url string) *SendGridService {
+	return &SendGridService{apiKey: apiKey, url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Name - name of the email service
+func (s *SendGridService) Name() string {
+	return "sendgrid"
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridMail struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+// Send - send the email returning the SendGrid message ID (X-Message-Id)
+func (s *SendGridService) Send(e Email) (string, error) {
+	sgm := sendGridMail{
+		Personalizations: []sendGridPersonalization{{To: []sendGridAddress{{Email: e.To}}}},
+		From:             sendGridAddress{Email: e.FromAddress, Name: e.FromName},
+		Subject:          e.Subject,
+		// the text content has to be before the HTML content
+		Content: []sendGridContent{{Type: "text/plain", Value: e.Text}, {Type: "text/html", Value: e.HTML}},
+	}
+	if e.UnsubscribeLink != "" {
+		sgm.Headers = map[string]string{"List-Unsubscribe": "<" + e.UnsubscribeLink + ">", "List-Unsubscribe-Post": "List-Unsubscribe=One-Click"}
+	}
+	body, err := json.Marshal(sgm)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling sendgrid email: %w", err)
+	}
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("error creating sendgrid request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending email via sendgrid: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return string(respBody), fmt.Errorf("sendgrid returned status code: %d, body: %s", resp.StatusCode, respBody)
+	}
+	return resp.Header.Get("X-Message-Id"), nil
+}
@@ -9,8 +9,13 @@
 // 		config/config.properties file
 // 		config/barred_telephone_prefixes.txt file (name configured in config.properties)
 //
+// Secrets of the configs and send laters are stored encrypted when secrets_key_file is set, the same key file as
+// google_reviews (see secrets).
+//
 // The barred telephone prefixes file is read on program startup so any changes to this file
-// will require a restart.
+// will require a restart. Barred telephone prefixes and full numbers, for all clients or a client,
+// are managed from google_reviews_ui (stored in the database) and are reloaded every
+// barred_reload_period seconds without a restart.
 // The barred telephone prefixes must include the country prefix.
 //
 
@@ -27,7 +32,10 @@ import (
 	"google_reviews_autocab/barred"
 	"google_reviews_autocab/config"
 	"google_reviews_autocab/database"
+	"google_reviews_autocab/logging"
+	"google_reviews_autocab/metrics"
 	"google_reviews_autocab/process"
+	"google_reviews_autocab/secrets"
 	"google_reviews_autocab/utils"
 )
 
@@ -55,16 +63,24 @@ func main() {
 		}
 		defer f.Close()
 		// log.SetOutput(f)
-		log.SetOutput(&lumberjack.Logger{
+		// structured (JSON) log records
+		logging.Setup(&lumberjack.Logger{
 			Filename:   logFilename,
 			MaxSize:    20, // megabytes
 			MaxBackups: 10,
 			MaxAge:     2, //days
 		})
+	} else {
+		logging.Setup(os.Stderr)
 	}
 
 	// read config file
 	config.ReadProperties()
+	// telephones are stored and looked up hashed with the telephone hash key
+	if config.Conf.TelephoneHashKey == "" {
+		log.Fatal("Error, no telephone hash key is set (telephone_hash_key), telephones can not be hashed without a key")
+	}
+	utils.SetTelephoneHashKey(config.Conf.TelephoneHashKey)
 	// log.Printf("poll period: %d\n", conf.PollPeriod)
 	// log.Printf("last poll time: %v+\n", conf.LastPollTime)
 
@@ -76,8 +92,37 @@ func main() {
 		process.Bars = bars
 	}
 
+	// secrets are stored encrypted with the data keys wrapped by the key in the secrets key file
+	if config.Conf.SecretsKeyFile != "" {
+		keyProvider, err := secrets.NewFileKeyProvider(config.Conf.SecretsKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		secrets.SetKeyProvider(keyProvider)
+	} else {
+		log.Println("Warning, no secrets key file is set, secrets are stored unencrypted")
+	}
+
 	// database
 	database.OpenDB(config.Conf.DbName, config.Conf.DbAddress, config.Conf.DbPort, config.Conf.DbUsername, config.Conf.DbPassword)
+	if err := database.LoadDataKeys(); err != nil {
+		log.Fatal(err)
+	}
+	database.RegisterMetrics()
+
+	// metrics listener
+	if config.Conf.MetricsPort != "" {
+		go func() {
+			log.Printf("metrics listener stopped: %v\n", metrics.ListenAndServe(":"+config.Conf.MetricsPort, config.Conf.MetricsToken, config.Conf.MetricsAllowedIPs))
+		}()
+	}
+
+	// barred telephones from the barred file and the database, reloaded every period
+	process.WatchBarred(time.Duration(config.Conf.BarredReloadPeriod) * time.Second)
+
+	// processed bookings, bookings returned by overlapping polls are not processed again
+	database.EnableProcessedBookings(time.Duration(config.Conf.ProcessedBookingTTL)*time.Hour,
+		time.Duration(config.Conf.ProcessedBookingLease)*time.Second)
 
 	// set the Review Master SMS Gateway master queue ID
 	database.SetReviewMasterSMSGatewayMasterQueueID()
@@ -103,6 +148,11 @@ func main() {
 		// get and process archive bookings
 		process.PollAutocab(lastPollTime, startPollTime)
 
+		// delete the expired processed bookings
+		if n := database.PurgeProcessedBookings(); n > 0 {
+			log.Printf("%d expired processed bookings deleted\n", n)
+		}
+
 		// store last poll time to config properties
 		config.UpdateProperties(startPollTime)
 
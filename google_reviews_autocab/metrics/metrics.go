@@ -0,0 +1,388 @@
+package metrics
+
+// metrics - counters, histograms and gauges exported in the Prometheus text format from a /metrics endpoint
+// NOTE: keep in line with the metrics package in google_reviews, google_reviews_autocab and send_sms
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets - default histogram buckets in seconds, suitable for request and send latencies
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector - a metric that can write itself in the Prometheus text format
+type collector interface {
+	write(w io.Writer)
+}
+
+// registry - the metrics to export in the order registered
+var registry = struct {
+	sync.Mutex
+	collectors []collector
+}{}
+
+// register - add a metric to the registry
+func register(c collector) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.collectors = append(registry.collectors, c)
+}
+
+// labelKey - key of the label values (the label values cannot contain the separator)
+func labelKey(labels []string, labelValues []string) (string, bool) {
+	if len(labels) != len(labelValues) {
+		return "", false
+	}
+	return strings.Join(labelValues, "\xff"), true
+}
+
+// formatLabels - format the labels e.g. {handler="/cordic",outcome="success"}
+func formatLabels(labels []string, key string, extra ...string) string {
+	var pairs []string
+	if len(labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, labels[i]+"=\""+escapeLabelValue(v)+"\"")
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"=\""+escapeLabelValue(extra[i+1])+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabelValue - escape a label value (backslash, double quote and new line)
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// formatValue - format a sample value
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeHeader - write the help and type of a metric
+func writeHeader(w io.Writer, name string, help string, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.ReplaceAll(help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// sortedKeys - sorted keys of the label values so the output is stable
+func sortedKeys(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec - counter partitioned by labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec - create and register a counter partitioned by the labels
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc - increment the counter for the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add - add to the counter for the label values (negative values are ignored)
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key, ok := labelKey(c.labels, labelValues)
+	if !ok {
+		log.Printf("Error, metric %s expects labels %v got values %v\n", c.name, c.labels, labelValues)
+		return
+	}
+	if v < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// Value - value of the counter for the label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key, _ := labelKey(c.labels, labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	for _, k := range sortedKeys(keys) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, k), formatValue(c.values[k]))
+	}
+}
+
+// histogram - observations of a histogram for a set of label values
+type histogram struct {
+	counts []uint64 // cumulative count for each bucket
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec - histogram partitioned by labels
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+}
+
+// NewHistogramVec - create and register a histogram with the (upper bound) buckets partitioned by the labels
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: b, values: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+// Observe - add an observation for the label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key, ok := labelKey(h.labels, labelValues)
+	if !ok {
+		log.Printf("Error, metric %s expects labels %v got values %v\n", h.name, h.labels, labelValues)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, found := h.values[key]
+	if !found {
+		hv = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, upperBound := range h.buckets {
+		if v <= upperBound {
+			hv.counts[i]++
+		}
+	}
+	hv.sum += v
+	hv.count++
+}
+
+// ObserveDuration - add an observation in seconds for the label values
+func (h *HistogramVec) ObserveDuration(d time.Duration, labelValues ...string) {
+	h.Observe(d.Seconds(), labelValues...)
+}
+
+// Count - number of observations for the label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	key, _ := labelKey(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hv, found := h.values[key]; found {
+		return hv.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	for _, k := range sortedKeys(keys) {
+		hv := h.values[k]
+		for i, upperBound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, k, "le", formatValue(upperBound)), hv.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, k, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, k), formatValue(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, k), hv.count)
+	}
+}
+
+// funcMetric - gauge or counter with the value read when the metrics are exported
+type funcMetric struct {
+	name string
+	help string
+	typ  string
+	fn   func() float64
+}
+
+// NewGaugeFunc - create and register a gauge with the value read when the metrics are exported
+func NewGaugeFunc(name string, help string, fn func() float64) {
+	register(&funcMetric{name: name, help: help, typ: "gauge", fn: fn})
+}
+
+// NewCounterFunc - create and register a counter with the value read when the metrics are exported
+func NewCounterFunc(name string, help string, fn func() float64) {
+	register(&funcMetric{name: name, help: help, typ: "counter", fn: fn})
+}
+
+func (f *funcMetric) write(w io.Writer) {
+	writeHeader(w, f.name, f.help, f.typ)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatValue(f.fn()))
+}
+
+// RegisterDBStats - register the database connection pool stats with the metric names prefixed
+func RegisterDBStats(prefix string, db *sql.DB) {
+	NewGaugeFunc(prefix+"_db_max_open_connections", "Maximum number of open connections to the database.",
+		func() float64 { return float64(db.Stats().MaxOpenConnections) })
+	NewGaugeFunc(prefix+"_db_open_connections", "Number of established connections to the database, both in use and idle.",
+		func() float64 { return float64(db.Stats().OpenConnections) })
+	NewGaugeFunc(prefix+"_db_in_use_connections", "Number of connections to the database currently in use.",
+		func() float64 { return float64(db.Stats().InUse) })
+	NewGaugeFunc(prefix+"_db_idle_connections", "Number of idle connections to the database.",
+		func() float64 { return float64(db.Stats().Idle) })
+	NewCounterFunc(prefix+"_db_wait_count_total", "Total number of connections waited for.",
+		func() float64 { return float64(db.Stats().WaitCount) })
+	NewCounterFunc(prefix+"_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.",
+		func() float64 { return db.Stats().WaitDuration.Seconds() })
+}
+
+// WriteMetrics - write all the registered metrics in the Prometheus text format
+func WriteMetrics(w io.Writer) {
+	registry.Lock()
+	collectors := append([]collector(nil), registry.collectors...)
+	registry.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// allowedIP - check whether the remote address is one of the allowed IPs or CIDRs e.g. 10.0.0.5 or 10.0.0.0/24
+func allowedIP(remoteAddr string, allowedIPs []string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, a := range allowedIPs {
+		a = strings.TrimSpace(a)
+		if strings.Contains(a, "/") {
+			if _, ipNet, err := net.ParseCIDR(a); err == nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if allowed := net.ParseIP(a); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler - metrics handler, for security either the token (metrics_token parameter or bearer authorization header)
+// is required or the request has to be from one of the allowed IPs or CIDRs, with neither set the metrics are not available
+func Handler(token string, allowedIPs []string) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		t := req.URL.Query().Get("metrics_token")
+		if t == "" {
+			t = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		}
+		tokenOK := token != "" && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(t)), []byte(token)) == 1
+		if !tokenOK && !allowedIP(req.RemoteAddr, allowedIPs) {
+			log.Printf("Error, metrics requested from %s without the metrics token or from an allowed IP\n", req.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// ListenAndServe - serve the metrics on their own listener (for programs without a server) e.g. :9102
+func ListenAndServe(addr string, token string, allowedIPs []string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(token, allowedIPs))
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  10 * time.Second,
+	}
+	return srv.ListenAndServe()
+}
+
+// responseRecorder - records the status code and the start of the body of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if len(r.body) < 64 {
+		r.body = append(r.body, b...)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// InstrumentHandler - count the requests of the handler by outcome and observe their duration, the outcome is
+// error for an error status code, failed for the failed response or success otherwise
+func InstrumentHandler(requests *CounterVec, duration *HistogramVec, handler string, failedResponse []byte, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rr := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rr, req)
+		outcome := "success"
+		switch {
+		case rr.status >= http.StatusBadRequest:
+			outcome = "error"
+		case len(failedResponse) > 0 && bytes.HasPrefix(rr.body, failedResponse):
+			outcome = "failed"
+		}
+		requests.Inc(handler, outcome)
+		duration.ObserveDuration(time.Since(start), handler)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -1,10 +1,9 @@
 package process
 
 import (
-	"encoding/json"
 	"log"
+	"log/slog"
 	"math/rand"
-	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,18 +15,20 @@ import (
 	"google_reviews_autocab/autocab_api_v1"
 	"google_reviews_autocab/autocab_api_v2"
 	"google_reviews_autocab/barred"
-	"google_reviews_autocab/client"
 	"google_reviews_autocab/config"
 	"google_reviews_autocab/database"
+	"google_reviews_autocab/email"
+	"google_reviews_autocab/logging"
+	"google_reviews_autocab/sender"
 	"google_reviews_autocab/utils"
 )
 
-// global mutex to prevent code which sends SMS running in parallel because the SMS gateway
-// is unable to handle the number of requests generated by this code at once.
-var sendSmsMutex sync.Mutex
-
+// Bars - barred telephone prefixes read from the barred file (barred for all clients), see loadBarred
 var Bars []string
 
+// eventPoll - structured log record event of each poll
+const eventPoll = "poll"
+
 // PollAutocab - poll Autocab
 //
 //	func PollAutocab(db *sql.DB, config config.Config, lastPollTime, startPollTime time.Time) {
@@ -38,7 +39,7 @@ var Bars []string
 //		// iterate over configs
 //		for _, grcftwc := range grcftwcs {
 //			// get autorisation token
-//			authorisationToken := autocab_api.GetAuthorisationTokenFromServer(grcftwc.DispatcherURL, grcftwc.AppKey, grcftwc.SecretKey)
+//			authorisationToken := autocab_api.GetAuthorisationTokenFromServer(grcftwc.DispatcherURL, grcftwc.AppKey, grcftwc.SecretKey, grcftwc.TLSSkipVerify)
 //			if authorisationToken == "" {
 //				log.Printf("Error getting Autocab authorisation token for ClientID %d\n", grcftwc.ClientID)
 //				continue
@@ -59,6 +60,11 @@ var Bars []string
 //		wg.Wait()
 //	}
 func PollAutocab(lastPollTime, startPollTime time.Time) {
+	start := time.Now()
+	defer func() { pollDuration.ObserveDuration(time.Since(start)) }()
+	// each poll is logged with its own request ID
+	logger := slog.Default().With(logging.FieldRequestID, logging.NewRequestID())
+	logger.Info("poll", logging.FieldEvent, eventPoll, "last_poll_time", lastPollTime, "start_poll_time", startPollTime)
 	// get the Autocab configs
 	grcftwcs := database.GetAutocabConfigsWithChecks(false)
 	// WaitGroup used to synchronise goroutines so can wait for all to be complete
@@ -68,7 +74,7 @@ func PollAutocab(lastPollTime, startPollTime time.Time) {
 		// increment the WaitGroup counter
 		wg.Add(1)
 		// run in goroutine
-		go processConfig(lastPollTime, startPollTime, grcftwc, &wg)
+		go processConfig(logger.With(logging.FieldClientID, grcftwc.ClientID), lastPollTime, startPollTime, grcftwc, &wg)
 	}
 	// wait fro all goroutines to complete
 	wg.Wait()
@@ -79,7 +85,7 @@ func PollAutocab(lastPollTime, startPollTime time.Time) {
 // 	// decrement the counter when goroutine completes
 // 	defer wg.Done()
 // 	// get autorisation token
-// 	authorisationToken := autocab_api.GetAuthorisationTokenFromServer(grcftwc.DispatcherURL, grcftwc.AppKey, grcftwc.SecretKey)
+// 	authorisationToken := autocab_api.GetAuthorisationTokenFromServer(grcftwc.DispatcherURL, grcftwc.AppKey, grcftwc.SecretKey, grcftwc.TLSSkipVerify)
 // 	if authorisationToken == "" {
 // 		log.Printf("Error getting Autocab authorisation token for ClientID: %d\n", grcftwc.ClientID)
 // 		return
@@ -89,7 +95,7 @@ func PollAutocab(lastPollTime, startPollTime time.Time) {
 // 	startPoll := utils.ConvertToTimeZone(startPollTime, grcftwc.TimeZone)
 // 	// get archived bookings
 // 	// archiveBookings := autocab_api.GetArchiveBookingsFromServer(grcftwc.DispatcherURL, authorisationToken, lastPollTime, startPollTime)
-// 	archiveBookings := autocab_api.GetArchiveBookingsFromServer(grcftwc.DispatcherURL, authorisationToken, lastPoll, startPoll)
+// 	archiveBookings := autocab_api.GetArchiveBookingsFromServer(grcftwc.DispatcherURL, authorisationToken, lastPoll, startPoll, grcftwc.TLSSkipVerify)
 // 	// check whether sent daily allowance
 // 	sentCount := database.DailySentCount(db, grcftwc.ClientID)
 // 	for _, archiveBooking := range archiveBookings {
@@ -106,15 +112,15 @@ func PollAutocab(lastPollTime, startPollTime time.Time) {
 // }
 
 // processConfig - process each google config
-func processConfig(lastPollTime, startPollTime time.Time, grcftwc database.GoogleReviewsConfigFromTokenWithChecks, wg *sync.WaitGroup) {
+func processConfig(logger *slog.Logger, lastPollTime, startPollTime time.Time, grcftwc database.GoogleReviewsConfigFromTokenWithChecks, wg *sync.WaitGroup) {
 	// decrement the counter when goroutine completes
 	defer wg.Done()
 	authorisationToken := ""
 	if grcftwc.DispatcherType == "AUTOCAB" {
 		// get autorisation token
-		authorisationToken = autocab_api.GetAuthorisationTokenFromServer(grcftwc.DispatcherURL, grcftwc.AppKey, grcftwc.SecretKey)
+		authorisationToken = autocab_api.GetAuthorisationTokenFromServer(grcftwc.DispatcherURL, grcftwc.AppKey, grcftwc.SecretKey, grcftwc.TLSSkipVerify)
 		if authorisationToken == "" {
-			log.Printf("Error getting Autocab authorisation token for ClientID: %d\n", grcftwc.ClientID)
+			logger.Error("Error getting Autocab authorisation token", logging.FieldReason, "authorisation_error")
 			return
 		}
 	}
@@ -124,21 +130,42 @@ func processConfig(lastPollTime, startPollTime time.Time, grcftwc database.Googl
 	// get archived bookings
 	var archiveBookings []autocab_api.ArchiveBooking
 	if grcftwc.DispatcherType == "AUTOCAB" {
-		archiveBookings = autocab_api.GetArchiveBookingsFromServer(grcftwc.DispatcherURL, authorisationToken, lastPoll, startPoll)
+		archiveBookings = autocab_api.GetArchiveBookingsFromServer(grcftwc.DispatcherURL, authorisationToken, lastPoll, startPoll, grcftwc.TLSSkipVerify)
 	} else if grcftwc.DispatcherType == "AUTOCAB_V1" {
-		bookings := autocab_api_v1.GetBookingsFromServer(grcftwc.DispatcherURL, grcftwc.AppKey, lastPoll, startPoll)
+		bookings := autocab_api_v1.GetBookingsFromServer(grcftwc.DispatcherURL, grcftwc.AppKey, lastPoll, startPoll, grcftwc.TLSSkipVerify)
 		archiveBookings = autocab_api_v1.TranslateBookingsToArchiveBookings(bookings)
 	} else if grcftwc.DispatcherType == "AUTOCAB_V2" {
-		bookings := autocab_api_v2.GetBookingsFromServer(grcftwc.DispatcherURL, grcftwc.AppKey, lastPoll, startPoll)
+		bookings := autocab_api_v2.GetBookingsFromServer(grcftwc.DispatcherURL, grcftwc.AppKey, lastPoll, startPoll, grcftwc.TLSSkipVerify)
 		archiveBookings = autocab_api_v2.TranslateBookingsToArchiveBookings(bookings)
 	}
 	// check whether sent daily allowance
 	sentCount := database.DailySentCount(grcftwc.ClientID)
 	var sendLaterCount uint
 	numberSent := 0
+	duplicates := 0
 	for _, archiveBooking := range archiveBookings {
 		// log.Printf("archiveBooking: %+v\n", archiveBooking)
-		sent, sendLater := processArchiveBooking(archiveBooking, grcftwc)
+		// bookings already processed by a previous (overlapping) poll are skipped and not counted again in the stats
+		bookingID := ""
+		if archiveBooking.BookingID != 0 {
+			bookingID = strconv.FormatInt(archiveBooking.BookingID, 10)
+		}
+		if claimed, outcome := database.ClaimBooking(grcftwc.ClientID, bookingID); !claimed {
+			log.Printf("booking: %s for clientID: %d has already been processed (%s)\n", bookingID, grcftwc.ClientID, outcome)
+			bookingsProcessedTotal.Inc(grcftwc.DispatcherType, "duplicate")
+			duplicates += 1
+			continue
+		}
+		sent, sendLater := processArchiveBooking(logger, archiveBooking, grcftwc)
+		outcome := "not_sent"
+		switch {
+		case sent:
+			outcome = "sent"
+		case sendLater:
+			outcome = "send_later"
+		}
+		bookingsProcessedTotal.Inc(grcftwc.DispatcherType, outcome)
+		database.CompleteBooking(grcftwc.ClientID, bookingID, outcome)
 		if sent || sendLater {
 			if sent {
 				sentCount += 1
@@ -153,8 +180,9 @@ func processConfig(lastPollTime, startPollTime time.Time, grcftwc database.Googl
 			}
 		}
 	}
-	// update stats (ignore send later, as these are counted when sent later)
-	database.UpdateStatsWithCounts(grcftwc.ClientID, numberSent, len(archiveBookings))
+	// update stats (ignore send later, as these are counted when sent later,
+	// and duplicates, as these were counted when first processed)
+	database.UpdateStatsWithCounts(grcftwc.ClientID, numberSent, len(archiveBookings)-duplicates)
 }
 
 // NOTE: processing in goroutines causes issues with what is in the database so not done.
@@ -164,7 +192,7 @@ func processConfig(lastPollTime, startPollTime time.Time, grcftwc database.Googl
 // 	defer wg.Done()
 // 	// check whether to send SMS
 // 	sendSMS, telephone, message, sentCount := CheckBooking(db, archiveBooking, grcftwc)
-// 	log.Printf("sendSMS: %t, telephone: %s, message: %s\n", sendSMS, telephone, message)
+// 	log.Printf("sendSMS: %t, telephone: %s\n", sendSMS, utils.MaskTelephone(telephone))
 // 	if sendSMS {
 // 		// TODO: send SMS code request to Autocab
 // 		// send SMS to own server
@@ -188,7 +216,7 @@ func processConfig(lastPollTime, startPollTime time.Time, grcftwc database.Googl
 // func processArchiveBooking(db *sql.DB, config config.Config, archiveBooking autocab_api.ArchiveBooking, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) bool {
 // 	// check whether to send SMS
 // 	sendSMS, telephone, telephoneSendSMS, message, sentCount := CheckBooking(db, archiveBooking, grcftwc)
-// 	log.Printf("sendSMS: %t, telephone: %s, message: %s\n", sendSMS, telephone, message)
+// 	log.Printf("sendSMS: %t, telephone: %s\n", sendSMS, utils.MaskTelephone(telephone))
 // 	if sendSMS {
 // 		// TODO: send SMS code request to Autocab
 // 		// send SMS to own server
@@ -214,140 +242,107 @@ func processConfig(lastPollTime, startPollTime time.Time, grcftwc database.Googl
 // return two booleans:
 //   - first indicates if the booking has been sent a message (true) else false
 //   - second indicates if the booking will be sent a message later (true) else false
-func processArchiveBooking(archiveBooking autocab_api.ArchiveBooking, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) (bool, bool) {
+func processArchiveBooking(logger *slog.Logger, archiveBooking autocab_api.ArchiveBooking, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) (bool, bool) {
 	// check whether to send SMS
-	sendSMS, telephone, telephoneSendSMS, message, sentCount := CheckBooking(archiveBooking, grcftwc)
-	log.Printf("sendSMS: %t, telephone: %s, message: %s\n", sendSMS, telephone, message)
-	var resp string
+	sendSMS, telephone, telephoneSendSMS, message, sentCount, variant, address := checkBooking(archiveBooking, grcftwc)
+	log.Printf("sendSMS: %t, telephone: %s\n", sendSMS, utils.MaskTelephone(telephone))
 	if sendSMS {
-		if grcftwc.SendDelayEnabled && grcftwc.SendDelay > 0 {
-			// send later
-			var params url.Values
-			var body []byte
-			var headers map[string]string
-			sendMessageURL := config.Conf.SendSmsURL
-			sendFromOwnSMSGateway := false
-			alternateMessageServiceEnabled := false
-			alternateMessageService := ""
-			if grcftwc.ReviewMasterSMSGatewayEnabled {
-				// send to Review Master SMS Gateway
-				sendMessageURL = config.Conf.ReviewMasterSMSGatewayURL
-				params = nil
-				reviewMasterSMSGatewayQueueID := grcftwc.ClientID
-				if grcftwc.ReviewMasterSMSGatewayUseMasterQueue {
-					reviewMasterSMSGatewayQueueID = uint64(database.ReviewMasterSMSMasterQueue)
-				}
-				body, _ = json.Marshal(map[string]string{
-					"queue_id":  strconv.FormatUint(reviewMasterSMSGatewayQueueID, 10),
-					"telephone": "+" + telephone,
-					"message":   message,
-				})
-				headers = map[string]string{
-					"Content-Type": "application/json",
-					"Accept":       "application/json",
-					"Api-Token":    config.Conf.ReviewMasterSMSGatewayApiToken,
-				}
-			} else if grcftwc.AlternateMessageServiceEnabled && grcftwc.AlternateMessageService == "AUTOCAB_V1" {
-				// send to Autocab V1 Send SMS API
-				alternateMessageServiceEnabled = true
-				alternateMessageService = grcftwc.AlternateMessageService
-
-				apiURL := grcftwc.SendURL
-				if !strings.HasSuffix(apiURL, "/") {
-					apiURL += "/"
-				}
-				apiURL += "sms/v1/send"
-				sendMessageURL = apiURL
-
-				params = nil
-
-				key := strings.TrimSpace(grcftwc.AlternateMessageServiceSecret1)
-
-				headers = map[string]string{
-					"Content-Type":              "application/json",
-					"Accept":                    "application/json",
-					"Ocp-Apim-Subscription-Key": key,
-				}
-
-				body, _ = json.Marshal(autocab_api_v1.SendSMSRequest{
-					SenderName:      config.Conf.AutocabSendSMSSenderName,
-					RecipientTelnos: []string{telephone},
-					Message:         message,
-				})
-			} else {
-				// send SMS to own server
-				sendFromOwnSMSGateway = true
-				params.Add(config.Conf.SendSmsTokenParameter, config.Conf.SendSmsToken)
-				params.Add(config.Conf.SendSmsTelephoneParameter, telephone)
-				params.Add(config.Conf.SendSmsMessageParameter, message)
-				body = nil
-				headers = nil
-			}
-			// store request in database
-			database.AddSendLater(telephone, grcftwc.ClientID, int(grcftwc.SendDelay),
-				sendMessageURL, "POST", "", "",
-				headers, params, body, false,
-				grcftwc.ReviewMasterSMSGatewayEnabled,
-				alternateMessageServiceEnabled, alternateMessageService,
-				sendFromOwnSMSGateway, grcftwc.SendSuccessResponse, grcftwc.MaxDailySendCount)
+		// replace the review link with a tracked short link (when configured)
+		var shortLinkID uint64
+		values := messageTemplateValues(archiveBooking, grcftwc)
+		message, shortLinkID = trackReviewLink(message, values, grcftwc, variant)
+		// no telephone, email the booking (sent straight away, see sendEmail)
+		if address != "" {
+			return sendEmail(logger, grcftwc, address, message, values, variant, shortLinkID, sentCount), false
+		}
+		s := sender.ForConfig(grcftwc)
+		// SMS fallback when WhatsApp is the preferred channel (nil if none)
+		fallback := sender.FallbackForConfig(grcftwc)
+		m := sender.MessageFromConfig(grcftwc, telephone, telephoneSendSMS, message)
+		m.TemplateValues = values
+		sendRequest := s.BuildRequest(m)
+		// send delay of the config or pacing (spreading the daily send count across the window)
+		delay, allowed := sendDelay(grcftwc)
+		if !allowed {
+			database.AddMessageEvent(grcftwc.ClientID, telephone, s.Name(), database.ReasonMaxDailyCount, "", 0)
+			return false, false
+		}
+		if delay > 0 {
+			// send later, store request in database
+			s.SendLater(m, sendRequest, delay)
+			sender.HoldFallback(s, fallback, m)
+			database.SetShortLinkMessageEvent(shortLinkID, database.AddMessageEventWithVariant(grcftwc.ClientID, telephone, s.Name(), database.ReasonDeferred, variant, "", 0))
 			return false, true
-		} else {
-			// send now
-			var expectedSuccessResponse string
-			// TODO: send SMS code request to Autocab
-			if grcftwc.ReviewMasterSMSGatewayEnabled {
-				// send to Review Master SMS Gateway
-				expectedSuccessResponse = grcftwc.SendSuccessResponse
-				resp = SendReviewMasterSMSGateway(telephoneSendSMS, message, grcftwc)
-			} else if grcftwc.AlternateMessageServiceEnabled && grcftwc.AlternateMessageService == "AUTOCAB_V1" {
-				// send to Autocab V1 Send SMS API
-				expectedSuccessResponse = grcftwc.SendSuccessResponse
-				success := autocab_api_v1.SendSMS(strings.TrimSpace(grcftwc.SendURL), strings.TrimSpace(grcftwc.AlternateMessageServiceSecret1),
-					telephone, message, config.Conf.AutocabSendSMSSenderName)
-				if success {
-					resp = grcftwc.SendSuccessResponse
-				}
-			} else {
-				// send SMS to own server
-				expectedSuccessResponse = config.Conf.SendSmsSuccessResponse
-				resp = SendSMSServer(telephoneSendSMS, message, grcftwc)
-			}
-			log.Printf("send sms for telephone: %s resp: %s\n", telephoneSendSMS, resp)
+		}
+		// send now, via the fallback when not sent via WhatsApp
+		// TODO: send SMS code request to Autocab
+		s, _, providerResp, latency := sender.SendWithFallback(s, fallback, m, sendRequest)
+		resp, sent := s.InterpretResponse(m, providerResp)
+		log.Printf("send sms for telephone: %s resp: %s\n", utils.MaskTelephone(telephoneSendSMS), resp)
 
-			if resp != expectedSuccessResponse {
-				log.Printf("Error sending SMS message, got response '%s' expected '%s' for telephone: %s, message: %s\n", resp, expectedSuccessResponse, telephone, message)
-				return false, false
-			}
-			// update last sent in database
-			database.UpdateLastSent(telephone, grcftwc.ClientID, sentCount+1)
-			return true, false
+		if !sent {
+			logger.Error("Error sending SMS message", logging.FieldEvent, logging.EventSendError, logging.FieldReason, database.ReasonProviderError,
+				"response", resp, "telephone", utils.MaskTelephone(telephone))
+			database.AddMessageEvent(grcftwc.ClientID, telephone, s.Name(), database.ReasonProviderError, providerResp, latency)
+			return false, false
 		}
+		// update last sent in database
+		database.UpdateLastSent(telephone, grcftwc.ClientID, sentCount+1)
+		messageEventID := database.AddMessageEventWithVariant(grcftwc.ClientID, telephone, s.Name(), database.ReasonSent, variant, providerResp, latency)
+		database.SetShortLinkMessageEvent(shortLinkID, messageEventID)
+		// record the provider message ID to match the delivery status callbacks (e.g. Twilio)
+		sender.RecordMessageID(s, messageEventID, providerResp)
+		// hold the SMS fallback whilst the WhatsApp message is delivered
+		sender.HoldFallback(s, fallback, m)
+		return true, false
 	}
 	return false, false
 }
 
 // CheckBooking - check booking returning whether successful and telephone number and message to send via SMS and the sent count
 func CheckBooking(archiveBooking autocab_api.ArchiveBooking, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) (bool, string, string, string, uint) {
+	bookingCheck, telephone, telephoneSendSMS, message, sentCount, _, _ := checkBooking(archiveBooking, grcftwc)
+	return bookingCheck, telephone, telephoneSendSMS, message, sentCount
+}
+
+// checkBooking - check booking (see CheckBooking) also returning the message variant chosen and the email address
+// when the booking is emailed (no telephone and the config has email enabled, the telephone is then empty)
+func checkBooking(archiveBooking autocab_api.ArchiveBooking, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) (bool, string, string, string, uint, string, string) {
 	tel := archiveBooking.TelephoneNumber
 	// log.Printf("t param: %s\n", tel)
 	// telephone := phonenumber.Parse(tel, grcftwc.Country)
 	telephone := utils.TelephoneParse(tel, grcftwc.Country)
 	// log.Printf("telephone: %s\n", telephone)
-	if telephone == "" {
-		log.Printf("no telephone found (sent telephone parameter: %s) for clientID: %d\n", tel, grcftwc.ClientID)
-		return false, "", "", "", 0
+	// message service (channel) recorded in the message events
+	channel := sender.ForConfig(grcftwc).Name()
+	// without a telephone the booking is emailed when it has an email address (and the config has email enabled)
+	address := ""
+	if telephone == "" && grcftwc.EmailEnabled {
+		address = email.ValidAddress(archiveBooking.CustomerEmail)
+	}
+	if telephone == "" && address == "" {
+		log.Printf("no telephone found (sent telephone parameter: %s) for clientID: %d\n", utils.MaskTelephone(tel), grcftwc.ClientID)
+		database.AddMessageEvent(grcftwc.ClientID, "", channel, database.ReasonNoTelephone, "", 0)
+		return false, "", "", "", 0, "", ""
+	}
+	// the telephone or email address recorded in the message events
+	identifier := telephone
+	if address != "" {
+		identifier = address
+		channel = email.Channel
 	}
-	// check barred telephone prefixes
-	if barred.CheckBarred(telephone, Bars) {
-		log.Printf("telephone number is barred (sent telephone parameter: %s) for clientID: %d\n", tel, grcftwc.ClientID)
-		return false, "", "", "", 0
+	// check barred telephone prefixes and full numbers (for all clients and the client)
+	if telephone != "" && barred.Load().Barred(telephone, grcftwc.ClientID) {
+		log.Printf("telephone number is barred (sent telephone parameter: %s) for clientID: %d\n", utils.MaskTelephone(tel), grcftwc.ClientID)
+		database.AddMessageEvent(grcftwc.ClientID, telephone, channel, database.ReasonBarred, "", 0)
+		return false, "", "", "", 0, "", ""
 	}
 	// Some SIMs are configured not to send international numbers and when the telephone is
 	// configured to E.164 format with the local country code this is determined to be international
 	// so the SMS is not sent.
 	// Therefore have to replace the country code to make it a national number this is normally with a 0.
 	telephoneSendSMS := telephone
-	if grcftwc.ReplaceTelephoneCountryCode {
+	if telephone != "" && grcftwc.ReplaceTelephoneCountryCode {
 		countryForTelephone := phonenumber.GetISO3166ByNumber(telephone, true)
 		// log.Println(countryForTelephone.CountryCode)
 		telephoneSendSMS = strings.Replace(telephone, countryForTelephone.CountryCode, grcftwc.ReplaceTelephoneCountryCodeWith, 1)
@@ -360,11 +355,11 @@ func CheckBooking(archiveBooking autocab_api.ArchiveBooking, grcftwc database.Go
 	// 1 - mobile app
 	if grcftwc.BookingSourceMobileAppState == 0 && strings.EqualFold(archiveBooking.BookingSource, autocab_api.BookingSourceMobileApp) {
 		// log.Printf("booking is source mobile app but configuration is for NOT mobile app bookings for telephone: %s\n", telephone)
-		return false, "", "", "", 0
+		return false, "", "", "", 0, "", ""
 	}
 	if grcftwc.BookingSourceMobileAppState == 1 && !strings.EqualFold(archiveBooking.BookingSource, autocab_api.BookingSourceMobileApp) {
 		// log.Printf("booking is NOT source mobile app but configuration is for mobile app bookings for telephone: %s\n", telephone)
-		return false, "", "", "", 0
+		return false, "", "", "", 0, "", ""
 	}
 
 	// companies config is set to a list of acceptable company ID's
@@ -387,33 +382,57 @@ func CheckBooking(archiveBooking autocab_api.ArchiveBooking, grcftwc database.Go
 	}
 	if !foundCompany && !companyFailsAllAtoi {
 		// log.Printf("Company %s NOT found for telephone: %s\n", telephone)
-		return false, "", "", "", 0
+		return false, "", "", "", 0, "", ""
 	}
 
 	lastSent, sentCount, stop, found := database.LastSentFromTelephoneAndClient(telephone, grcftwc.ClientID)
+	if address != "" {
+		lastSent, sentCount, stop, found = database.LastSentFromEmailAndClient(address, grcftwc.ClientID)
+	}
 	// check if stop set (do not send)
 	if stop {
 		// log.Printf("stop on telephone: %s\n", telephone)
-		return false, "", "", "", 0
+		database.AddMessageEvent(grcftwc.ClientID, identifier, channel, database.ReasonStopped, "", 0)
+		return false, "", "", "", 0, "", ""
 	}
 	// check found record
 	if found {
 		// check last sent greater than min send frequency
 		if lastSent.After(time.Now().AddDate(0, 0, int(-grcftwc.MinSendFrequency))) {
 			// log.Printf("Last sent too recent for telephone: %s\n", telephone)
-			return false, "", "", "", 0
+			database.AddMessageEvent(grcftwc.ClientID, identifier, channel, database.ReasonTooRecent, "", 0)
+			return false, "", "", "", 0, "", ""
 		}
 		// check sent count
 		if int(sentCount) > int(grcftwc.MaxSendCount) {
 			// log.Printf("Reached maximum number of sends for telephone: %s\n", telephone)
-			return false, "", "", "", 0
+			database.AddMessageEvent(grcftwc.ClientID, identifier, channel, database.ReasonMaxCount, "", 0)
+			return false, "", "", "", 0, "", ""
 		}
 	}
+	// check the review requests to the telephone across clients (fatigue policies)
+	if policy, capped := database.FatigueCapped(telephone, grcftwc.ClientID); capped {
+		log.Printf("fatigue policy of partner ID: %d (%d review requests in %d days) reached for clientID: %d\n",
+			policy.PartnerID, policy.MaxRequests, policy.Days, grcftwc.ClientID)
+		database.AddMessageEvent(grcftwc.ClientID, identifier, channel, database.ReasonFatigueCap, "", 0)
+		return false, "", "", "", 0, "", ""
+	}
 
 	// get initial message (will use database message always)
 	message := grcftwc.Message
+	// the message of the booking language (when the config has one) replaces the message
+	language, languageMessage := messageLanguage(grcftwc, telephone)
+	if language != "" {
+		message = languageMessage
+	}
+	// A/B test message variants of the language (when set up) replace the message, the variant sent (or the
+	// position of the multi message) is recorded with the message events and tracked short links
+	variant, variantMessage, variantChosen := messageVariant(grcftwc, language)
+	if variantChosen {
+		message = variantMessage
+	}
 	// check for multi message
-	if grcftwc.MultiMessageEnabled == 1 {
+	if grcftwc.MultiMessageEnabled == 1 && !variantChosen {
 		// split message by the separator
 		if message == "" {
 			log.Printf("no message found for clientID: %d\n", grcftwc.ClientID)
@@ -432,6 +451,7 @@ func CheckBooking(archiveBooking autocab_api.ArchiveBooking, grcftwc database.Go
 			ms := strings.Split(message, sep)
 			r := rand.Intn(len(ms))
 			message = ms[r]
+			variant = multiMessageVariant(language, r+1)
 		}
 		if message == "" {
 			log.Printf("no message found for multi message after randomising found message array for clientID: %d\n", grcftwc.ClientID)
@@ -439,8 +459,11 @@ func CheckBooking(archiveBooking autocab_api.ArchiveBooking, grcftwc database.Go
 		}
 	}
 	if message == "" {
-		return false, "", "", "", 0
+		database.AddMessageEvent(grcftwc.ClientID, identifier, channel, database.ReasonNoMessage, "", 0)
+		return false, "", "", "", 0, "", ""
 	}
+	// fill in message template placeholders e.g. {first_name}
+	message = utils.FillMessageTemplate(message, messageTemplateValues(archiveBooking, grcftwc))
 
 	// see whether should do dispatcher checks
 	bookingCheck := true
@@ -458,47 +481,24 @@ func CheckBooking(archiveBooking autocab_api.ArchiveBooking, grcftwc database.Go
 		}
 	}
 
-	return bookingCheck, telephone, telephoneSendSMS, message, sentCount
+	if !bookingCheck {
+		database.AddMessageEvent(grcftwc.ClientID, identifier, channel, database.ReasonDispatcherCheckFailed, "", 0)
+	}
+
+	return bookingCheck, telephone, telephoneSendSMS, message, sentCount, variant, address
 }
 
 // SendReviewMasterSMSGateway - send via review master SMS gateway
 // return a string representing the response from the request
 func SendReviewMasterSMSGateway(telephone string, message string, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) string {
-	// send to Review Master SMS Gateway
-	reviewMasterSMSGatewayQueueID := grcftwc.ClientID
-	if grcftwc.ReviewMasterSMSGatewayUseMasterQueue {
-		reviewMasterSMSGatewayQueueID = uint64(database.ReviewMasterSMSMasterQueue)
+	s := sender.Get(sender.ReviewMasterSMSGateway)
+	m := sender.MessageFromConfig(grcftwc, telephone, telephone, message)
+	resp, sent := s.InterpretResponse(m, s.Send(s.BuildRequest(m)))
+	if !sent {
+		slog.Error("Error sending message", logging.FieldEvent, logging.EventSendError, logging.FieldClientID, grcftwc.ClientID,
+			logging.FieldReason, database.ReasonProviderError, "url", config.Conf.ReviewMasterSMSGatewayURL,
+			"telephone", utils.MaskTelephone(telephone), "response", resp)
 	}
-	jsonBody, _ := json.Marshal(map[string]string{
-		"queue_id":  strconv.FormatUint(reviewMasterSMSGatewayQueueID, 10),
-		"telephone": "+" + telephone,
-		"message":   message,
-	})
-	headers := map[string]string{
-		"Content-Type": "application/json",
-		"Accept":       "application/json",
-		"Api-Token":    config.Conf.ReviewMasterSMSGatewayApiToken,
-	}
-	resp := client.Send(config.Conf.ReviewMasterSMSGatewayURL, "POST", headers, nil, jsonBody)
-
-	// check response to make sure it has been sent successfully:
-	// example possible responses:
-	// 		{"id":76} - success with message id
-	// 		{"error":"unauthorized"} - unsuccessful unauthorized
-	// 		{"errors":{"message":["can't be blank"]}} - unsuccessful errors
-	// 		{"errors":{"telephone":["can't be blank"]}} - unsuccessful errors
-	// 		{"errors":{"detail":"Bad Request"}} - unsuccessful errors
-	var rJson map[string]interface{}
-	if errJson := json.Unmarshal([]byte(resp), &rJson); errJson != nil {
-		log.Printf("Error unmarshalling JSON from sending message from Review Master SMS Gateway APP to: %s, sending message: %s for client ID: %d to telephone: %s, response from send server: %+v, error: %+v", config.Conf.ReviewMasterSMSGatewayURL, message, grcftwc.ClientID, telephone, resp, errJson)
-	}
-	if _, ok := rJson["id"]; !ok {
-		log.Printf("Error sending message: %s for clientID: %d to %s with params: %+v, response from send server: %+v", message, grcftwc.ClientID, config.Conf.ReviewMasterSMSGatewayURL, telephone, resp)
-	} else {
-		// set response to expected configured response which can be anything
-		resp = grcftwc.SendSuccessResponse
-	}
-
 	return resp
 }
 
@@ -518,13 +518,6 @@ func SendReviewMasterSMSGateway(telephone string, message string, grcftwc databa
 // return a string representing the response from the request
 // NOTE: This uses the send SMS mutex to prevent sending too many requests to the SMS gateway which caused it to drop requests.
 func SendSMSServer(telephone string, message string, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) string {
-	// send SMS to own server
-	params := url.Values{}
-	params.Add(config.Conf.SendSmsTokenParameter, config.Conf.SendSmsToken)
-	params.Add(config.Conf.SendSmsTelephoneParameter, telephone)
-	params.Add(config.Conf.SendSmsMessageParameter, message)
-	sendSmsMutex.Lock()
-	resp := client.Send(config.Conf.SendSmsURL, "POST", nil, params, nil)
-	sendSmsMutex.Unlock()
-	return resp
+	s := sender.Get(sender.OwnSMSGateway)
+	return s.Send(s.BuildRequest(sender.MessageFromConfig(grcftwc, telephone, telephone, message)))
 }
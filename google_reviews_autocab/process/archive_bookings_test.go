@@ -299,3 +299,30 @@ func TestSendSMSServer1(t *testing.T) {
 	// fmt.Printf("grcftwc: %+v\n", grcftwc)
 	fmt.Println(SendSMSServer("+4471234567890", "testing", grcftwc))
 }
+
+func TestCheckBookingEmail(t *testing.T) {
+	prepareTestDatabase()
+	company := autocab_api.Company{ID: 1, Name: "Driverspay Demo"}
+	archiveBooking := autocab_api.ArchiveBooking{TelephoneNumber: "", CustomerEmail: "Jane@Example.com", ArchiveReason: "Completed", BookedAtTime: "2020-06-29T10:54:43.9659947+01:00", PickupDueTime: "2020-06-29T10:54:43.9359892+01:00", PickedUpAtTime: "2020-06-29T10:55:33.7987942+01:00", Company: company, BookingSource: "Operator"}
+	grcftwc := database.GoogleReviewsConfigFromTokenWithChecks{MinSendFrequency: 21, MaxSendCount: 10, MaxDailySendCount: 20, TelephoneParameter: "t", SendSuccessResponse: "returnSendSms=success", TimeZone: "Europe/London", ClientID: 11, Country: "GB", UseDatabaseMessage: 1, Message: "Hope you enjoyed your journey", BookingSourceMobileAppState: -1}
+
+	// email not enabled
+	if ok, _, _, _, _, _, address := checkBooking(archiveBooking, grcftwc); ok || address != "" {
+		t.Fatalf("expected no telephone got ok: %t, email: %s", ok, address)
+	}
+	grcftwc.EmailEnabled = true
+	ok, telephone, _, message, sentCount, _, address := checkBooking(archiveBooking, grcftwc)
+	if !ok || telephone != "" || address != "jane@example.com" || message != "Hope you enjoyed your journey" || sentCount != 0 {
+		t.Fatalf("unexpected check ok: %t, telephone: %s, email: %s, message: %s, sent count: %d", ok, telephone, address, message, sentCount)
+	}
+	// too recent once emailed
+	database.UpdateEmailLastSent(address, grcftwc.ClientID, 1)
+	if ok, _, _, _, _, _, _ := checkBooking(archiveBooking, grcftwc); ok {
+		t.Fatal("expected the email to be too recent")
+	}
+	// the telephone is used when there is one
+	archiveBooking.TelephoneNumber = "07715527297"
+	if ok, telephone, _, _, _, _, address := checkBooking(archiveBooking, grcftwc); !ok || telephone != "447715527297" || address != "" {
+		t.Fatalf("unexpected check ok: %t, telephone: %s, email: %s", ok, telephone, address)
+	}
+}
@@ -0,0 +1,24 @@
+package process
+
+import (
+	"time"
+
+	"google_reviews_autocab/barred"
+	"google_reviews_autocab/database"
+)
+
+// loadBarred - the barred telephones from the barred file (barred for all clients) and the database
+func loadBarred() ([]barred.Entry, error) {
+	entries, err := database.BarredTelephones()
+	if err != nil {
+		return nil, err
+	}
+	return append(barred.FileEntries(Bars), entries...), nil
+}
+
+// WatchBarred - load the barred telephones and reload them every period
+func WatchBarred(period time.Duration) {
+	// the barred file is used until the barred telephones are loaded from the database
+	barred.Store(barred.NewList(barred.FileEntries(Bars)))
+	barred.Watch(period, loadBarred)
+}
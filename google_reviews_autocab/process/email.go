@@ -0,0 +1,52 @@
+package process
+
+import (
+	"log/slog"
+	"time"
+
+	"google_reviews_autocab/config"
+	"google_reviews_autocab/database"
+	"google_reviews_autocab/email"
+	"google_reviews_autocab/logging"
+)
+
+// emailService - the email service used to send the emails (replaced in tests)
+var emailService = email.New
+
+// sendEmail - email the message to the address using the email template and subject of the config, the message
+// events are recorded with the email channel, returns whether sent
+//
+// NOTE: Emails are sent straight away (the send delay of the config is not used).
+// NOTE: keep in line with google_reviews
+func sendEmail(logger *slog.Logger, grcftwc database.GoogleReviewsConfigFromTokenWithChecks, address string, message string,
+	values map[string]string, variant string, shortLinkID uint64, sentCount uint) bool {
+	s := emailService()
+	if s == nil {
+		logger.Error("No email service set up", logging.FieldEvent, logging.EventSendError, logging.FieldReason, database.ReasonProviderError)
+		database.AddMessageEvent(grcftwc.ClientID, address, email.Channel, database.ReasonProviderError, "no email service", 0)
+		return false
+	}
+	unsubscribeLink := email.UnsubscribeLink(grcftwc.ClientID, address)
+	body, text := email.Render(grcftwc.EmailTemplate, message, values, unsubscribeLink)
+	e := email.Email{
+		FromAddress:     config.Conf.EmailFromAddress,
+		FromName:        config.Conf.EmailFromName,
+		To:              address,
+		Subject:         email.Subject(grcftwc.EmailSubject, values),
+		HTML:            body,
+		Text:            text,
+		UnsubscribeLink: unsubscribeLink,
+	}
+	start := time.Now()
+	messageID, err := s.Send(e)
+	latency := time.Since(start)
+	if err != nil {
+		logger.Error("Error sending email", logging.FieldEvent, logging.EventSendError, logging.FieldReason, database.ReasonProviderError,
+			"email_service", s.Name(), "error", err)
+		database.AddMessageEvent(grcftwc.ClientID, address, email.Channel, database.ReasonProviderError, err.Error(), latency)
+		return false
+	}
+	database.UpdateEmailLastSent(address, grcftwc.ClientID, sentCount+1)
+	database.SetShortLinkMessageEvent(shortLinkID, database.AddMessageEventWithVariant(grcftwc.ClientID, address, email.Channel, database.ReasonSent, variant, messageID, latency))
+	return true
+}
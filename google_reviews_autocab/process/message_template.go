@@ -0,0 +1,40 @@
+package process
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"google_reviews_autocab/autocab_api"
+	"google_reviews_autocab/database"
+	"google_reviews_autocab/utils"
+)
+
+// pickupTimeFormat - format of the {pickup_time} message placeholder
+const pickupTimeFormat = "15:04"
+
+// messageTemplateValues - get the message template placeholder values from the archive booking and config
+func messageTemplateValues(archiveBooking autocab_api.ArchiveBooking, grcftwc database.GoogleReviewsConfigFromTokenWithChecks) map[string]string {
+	values := map[string]string{
+		utils.PlaceholderCompany:    strings.TrimSpace(archiveBooking.Company.Name),
+		utils.PlaceholderReviewLink: grcftwc.ReviewLink,
+		utils.PlaceholderOptOutLink: grcftwc.OptOutLink,
+	}
+	if names := strings.Fields(archiveBooking.Name); len(names) > 0 {
+		values[utils.PlaceholderFirstName] = names[0]
+	}
+	// use the actual pickup time if there is one else the due time
+	pickupTime := archiveBooking.PickedUpAtTime
+	if pickupTime == "" {
+		pickupTime = archiveBooking.PickupDueTime
+	}
+	if pickupTime != "" {
+		tm, err := time.Parse(time.RFC3339, pickupTime)
+		if err != nil {
+			log.Printf("Error parsing pickup time: %s for clientID: %d, error: %v\n", pickupTime, grcftwc.ClientID, err)
+		} else {
+			values[utils.PlaceholderPickupTime] = utils.ConvertToTimeZone(tm, grcftwc.TimeZone).Format(pickupTimeFormat)
+		}
+	}
+	return values
+}
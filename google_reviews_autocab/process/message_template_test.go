@@ -0,0 +1,32 @@
+package process
+
+import (
+	"testing"
+
+	"google_reviews_autocab/autocab_api"
+	"google_reviews_autocab/database"
+	"google_reviews_autocab/utils"
+)
+
+func TestMessageTemplateValues(t *testing.T) {
+	company := autocab_api.Company{ID: 1, Name: "ABC Cars"}
+	archiveBooking := autocab_api.ArchiveBooking{TelephoneNumber: "07715527297", ArchiveReason: "Completed", BookedAtTime: "2020-06-29T10:54:43.9659947+01:00", PickupDueTime: "2020-06-29T10:54:43.9359892+01:00", PickedUpAtTime: "2020-06-29T10:55:33.7987942+01:00", Company: company, BookingSource: "Operator", Name: "Jane Smith"}
+	grcftwc := database.GoogleReviewsConfigFromTokenWithChecks{TimeZone: "Europe/London", ReviewLink: "https://g.page/r/test/review"}
+	values := messageTemplateValues(archiveBooking, grcftwc)
+	expected := map[string]string{
+		utils.PlaceholderFirstName:  "Jane",
+		utils.PlaceholderPickupTime: "10:55",
+		utils.PlaceholderCompany:    "ABC Cars",
+		utils.PlaceholderReviewLink: "https://g.page/r/test/review",
+		utils.PlaceholderOptOutLink: "",
+	}
+	for k, v := range expected {
+		if values[k] != v {
+			t.Errorf("%s = %q expected %q", k, values[k], v)
+		}
+	}
+	message := utils.FillMessageTemplate("Hi {first_name}, thanks for your {pickup_time} trip with {company}. {review_link}", values)
+	if message != "Hi Jane, thanks for your 10:55 trip with ABC Cars. https://g.page/r/test/review" {
+		t.Errorf("unexpected message: %s", message)
+	}
+}
@@ -0,0 +1,53 @@
+package process
+
+import (
+	"strconv"
+
+	"google_reviews_autocab/database"
+	"google_reviews_autocab/utils"
+)
+
+// messageLanguage - the language of the booking when the config has a message for it, the language of the country of
+// the telephone (the autocab bookings have no locale). Returns the language and its message which replaces the config
+// message, an empty language when the config message is used.
+func messageLanguage(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, telephone string) (string, string) {
+	messages := database.ConfigMessages(grcftwc.ConfigID)
+	if len(messages) == 0 {
+		return "", ""
+	}
+	language := utils.LanguageFromTelephone(telephone)
+	message, found := messages[language]
+	if language == "" || !found {
+		return "", ""
+	}
+	return language, message
+}
+
+// messageVariant - choose a message variant (weighted) of the language (empty for the config message) when A/B testing
+// of messages is set up for the config, returns the variant name, the variant message and whether a variant was chosen
+func messageVariant(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, language string) (string, string, bool) {
+	var mvs []database.MessageVariant
+	for _, mv := range database.MessageVariants(grcftwc.ConfigID) {
+		if mv.Language == language {
+			mvs = append(mvs, mv)
+		}
+	}
+	weights := make([]uint, len(mvs))
+	for i, mv := range mvs {
+		weights[i] = mv.Weight
+	}
+	i := utils.WeightedChoice(weights)
+	if i < 0 {
+		return "", "", false
+	}
+	return mvs[i].Name, mvs[i].Message, true
+}
+
+// multiMessageVariant - the variant recorded for the position of a multi message, prefixed by the language of the
+// message (when not the config message) e.g. nl:2
+func multiMessageVariant(language string, position int) string {
+	if language == "" {
+		return strconv.Itoa(position)
+	}
+	return language + ":" + strconv.Itoa(position)
+}
@@ -0,0 +1,22 @@
+package process
+
+import (
+	"google_reviews_autocab/metrics"
+)
+
+// poll durations are longer than requests as every config is polled
+var pollDurationBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	// pollDuration - time taken to poll the dispatchers of all the Autocab configs
+	pollDuration = metrics.NewHistogramVec("google_reviews_autocab_poll_duration_seconds",
+		"Time taken to poll the dispatchers of all the Autocab configs.", pollDurationBuckets)
+	// bookingsProcessedTotal - bookings processed by dispatcher type and outcome
+	bookingsProcessedTotal = metrics.NewCounterVec("google_reviews_autocab_bookings_processed_total",
+		"Bookings processed by dispatcher type and outcome (sent, send_later, not_sent or duplicate).", "dispatcher_type", "outcome")
+	// sendsPacedTotal - messages of the configs with pacing enabled deferred to later in the window, or not sent as the
+	// daily send count is already allocated, by outcome
+	sendsPacedTotal = metrics.NewCounterVec("google_reviews_autocab_sends_paced_total",
+		"Messages of the configs with pacing enabled by outcome (deferred to later in the window or allocated when the daily send count is already allocated).",
+		"outcome")
+)
@@ -0,0 +1,39 @@
+package process
+
+import (
+	"math"
+	"time"
+
+	"google_reviews_autocab/database"
+	"google_reviews_autocab/utils"
+)
+
+// sendDelay - minutes the message is deferred by (0 to send now), the send delay of the config or, when pacing is
+// enabled, the delay spreading the daily send count across the window if longer (see utils.PacingDelay). The messages
+// deferred are sent by the google reviews send later worker. Returns false when pacing and the daily send count is
+// already allocated to the messages sent and deferred today.
+// NOTE: keep in line with google_reviews
+func sendDelay(grcftwc database.GoogleReviewsConfigFromTokenWithChecks) (int, bool) {
+	sendDelay := 0
+	if grcftwc.SendDelayEnabled && grcftwc.SendDelay > 0 {
+		sendDelay = int(grcftwc.SendDelay)
+	}
+	if !grcftwc.PacingEnabled {
+		return sendDelay, true
+	}
+
+	allocated := database.DailySentCount(grcftwc.ClientID) + database.DailySendLaterCount(grcftwc.ClientID)
+	delay, ok := utils.PacingDelay(grcftwc.Start, grcftwc.End, grcftwc.TimeZone, grcftwc.Days(), grcftwc.MaxDailySendCount, allocated, time.Now())
+	if !ok {
+		sendsPacedTotal.Inc("allocated")
+		return 0, false
+	}
+	pacedDelay := int(math.Ceil(delay.Minutes()))
+	if pacedDelay > 0 {
+		sendsPacedTotal.Inc("deferred")
+	}
+	if pacedDelay > sendDelay {
+		sendDelay = pacedDelay
+	}
+	return sendDelay, true
+}
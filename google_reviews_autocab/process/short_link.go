@@ -0,0 +1,40 @@
+package process
+
+import (
+	"log"
+	"strings"
+
+	"google_reviews_autocab/config"
+	"google_reviews_autocab/database"
+	"google_reviews_autocab/utils"
+)
+
+// shortLinkPath - path of the short link redirect endpoint on the google reviews server e.g. /r/aB3dE5gH
+const shortLinkPath = "/r/"
+
+// shortLinkCodeAttempts - number of codes to try when adding a short link (in case a code is already used)
+const shortLinkCodeAttempts = 3
+
+// trackReviewLink - replace the config review link in the message with a tracked short link when short links are configured,
+// returns the message and the short link id (0 if the review link is not tracked). The review link of the message
+// template values (when given) is also replaced e.g. for the WhatsApp template parameters.
+func trackReviewLink(message string, values map[string]string, grcftwc database.GoogleReviewsConfigFromTokenWithChecks, variant string) (string, uint64) {
+	if config.Conf.ShortLinkBaseURL == "" || grcftwc.ReviewLink == "" || !strings.Contains(message, grcftwc.ReviewLink) {
+		return message, 0
+	}
+	for i := 0; i < shortLinkCodeAttempts; i++ {
+		code := utils.NewShortLinkCode()
+		if code == "" {
+			continue
+		}
+		if id := database.AddShortLink(code, grcftwc.ClientID, variant, grcftwc.ReviewLink); id != 0 {
+			shortLink := strings.TrimSuffix(config.Conf.ShortLinkBaseURL, "/") + shortLinkPath + code
+			if values != nil {
+				values[utils.PlaceholderReviewLink] = shortLink
+			}
+			return strings.Replace(message, grcftwc.ReviewLink, shortLink, 1), id
+		}
+	}
+	log.Printf("unable to add a short link for clientID: %d, sending the review link as is\n", grcftwc.ClientID)
+	return message, 0
+}
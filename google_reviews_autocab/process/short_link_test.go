@@ -0,0 +1,39 @@
+package process
+
+import (
+	"strings"
+	"testing"
+
+	"google_reviews_autocab/config"
+	"google_reviews_autocab/database"
+	"google_reviews_autocab/utils"
+)
+
+func TestTrackReviewLink(t *testing.T) {
+	prepareTestDatabase()
+	baseURL := config.Conf.ShortLinkBaseURL
+	defer func() { config.Conf.ShortLinkBaseURL = baseURL }()
+
+	grcftwc := database.GoogleReviewsConfigFromTokenWithChecks{ClientID: 1, ReviewLink: "https://g.page/r/test/review"}
+	message := "Please review us https://g.page/r/test/review"
+
+	// not configured
+	config.Conf.ShortLinkBaseURL = ""
+	if m, id := trackReviewLink(message, nil, grcftwc, ""); m != message || id != 0 {
+		t.Fatalf("review link should not be tracked when short links are not configured, got message: %s", m)
+	}
+
+	config.Conf.ShortLinkBaseURL = "https://reviews.example.com"
+	// message does not use the review link
+	if m, id := trackReviewLink("Thank you", nil, grcftwc, ""); m != "Thank you" || id != 0 {
+		t.Fatalf("review link should not be tracked when not in the message, got message: %s", m)
+	}
+	values := map[string]string{utils.PlaceholderReviewLink: grcftwc.ReviewLink}
+	m, id := trackReviewLink(message, values, grcftwc, "")
+	if id == 0 || !strings.HasPrefix(m, "Please review us https://reviews.example.com/r/") || strings.Contains(m, grcftwc.ReviewLink) {
+		t.Fatalf("review link should be tracked, got message: %s", m)
+	}
+	if m != "Please review us "+values[utils.PlaceholderReviewLink] {
+		t.Fatalf("review link template value should be the short link, got: %s", values[utils.PlaceholderReviewLink])
+	}
+}
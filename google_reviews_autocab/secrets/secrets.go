@@ -0,0 +1,271 @@
+// Package secrets - envelope encryption of the secrets stored in the database (e.g. the dispatcher app key and secret
+// key and the alternate message service secret1 of the configs).
+//
+// The secrets are encrypted (AES-256-GCM) with a data key, the data keys are stored in the database
+// (google_reviews_data_keys) wrapped (encrypted) by the key encryption key of a KeyProvider, a local key file (see
+// FileKeyProvider) or a KMS. An encrypted secret is stored as enc:v1:<data key ID>:<base64 nonce and ciphertext>, a
+// secret without the prefix is plaintext (stored before the secrets were encrypted) and is returned as is.
+//
+// Without a key provider the secrets are not encrypted. The secrets are encrypted (encryptsecrets) and the data key
+// rotated (rotatesecretskey) by google_reviews.
+//
+// NOTE: keep in line with google_reviews
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// prefix - prefix of an encrypted secret, followed by the data key ID and the base64 nonce and ciphertext
+const prefix = "enc:v1:"
+
+// keySize - size of the data keys and key encryption keys (AES-256)
+const keySize = 32
+
+var (
+	// ErrNoDataKey - there is no active data key to encrypt with (see SetDataKeys)
+	ErrNoDataKey = errors.New("no active data key")
+	// ErrUnknownDataKey - the secret was encrypted with a data key that is not loaded (e.g. added by another server)
+	ErrUnknownDataKey = errors.New("unknown data key")
+	// ErrNoKeyProvider - the secret is encrypted but there is no key provider to decrypt it
+	ErrNoKeyProvider = errors.New("no key provider")
+)
+
+// KeyProvider - wraps (encrypts) and unwraps the data keys with a key encryption key which is not stored in the
+// database, e.g. a local key file or a KMS
+type KeyProvider interface {
+	// Wrap - encrypt the data key
+	Wrap(dataKey []byte) ([]byte, error)
+	// Unwrap - decrypt the wrapped data key
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// FileKeyProvider - key encryption key read from a local key file
+type FileKeyProvider struct {
+	kek []byte
+}
+
+// NewFileKeyProvider - read the key encryption key from the key file, 32 bytes base64 or hex encoded e.g. created with
+// $ openssl rand -base64 32 > secrets.key
+func NewFileKeyProvider(keyFile string) (*FileKeyProvider, error) {
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	s := strings.TrimSpace(string(b))
+	kek, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(kek) != keySize {
+		kek, err = hex.DecodeString(s)
+	}
+	if err != nil || len(kek) != keySize {
+		return nil, fmt.Errorf("key file %s must contain a %d byte key base64 or hex encoded", keyFile, keySize)
+	}
+	return &FileKeyProvider{kek: kek}, nil
+}
+
+// Wrap - encrypt the data key with the key encryption key
+func (p *FileKeyProvider) Wrap(dataKey []byte) ([]byte, error) {
+	return seal(p.kek, dataKey)
+}
+
+// Unwrap - decrypt the wrapped data key with the key encryption key
+func (p *FileKeyProvider) Unwrap(wrapped []byte) ([]byte, error) {
+	return open(p.kek, wrapped)
+}
+
+// keyring - the key provider and the unwrapped data keys by ID, secrets are encrypted with the active data key
+var keyring = struct {
+	sync.RWMutex
+	provider KeyProvider
+	keys     map[uint64][]byte
+	activeID uint64
+}{keys: make(map[uint64][]byte)}
+
+// SetKeyProvider - set the key provider wrapping the data keys, the secrets are encrypted when set (nil disables)
+func SetKeyProvider(p KeyProvider) {
+	keyring.Lock()
+	defer keyring.Unlock()
+	keyring.provider = p
+}
+
+// GetKeyProvider - the key provider wrapping the data keys (nil when the secrets are not encrypted)
+func GetKeyProvider() KeyProvider {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	return keyring.provider
+}
+
+// Enabled - whether the secrets are encrypted (there is a key provider)
+func Enabled() bool {
+	return GetKeyProvider() != nil
+}
+
+// SetDataKeys - unwrap the wrapped data keys by ID with the key provider and use them to decrypt, the secrets are
+// encrypted with the data key of activeID (0 for none)
+func SetDataKeys(wrapped map[uint64][]byte, activeID uint64) error {
+	keyring.Lock()
+	defer keyring.Unlock()
+	if keyring.provider == nil {
+		return ErrNoKeyProvider
+	}
+	keys := make(map[uint64][]byte, len(wrapped))
+	for id, w := range wrapped {
+		k, err := keyring.provider.Unwrap(w)
+		if err != nil {
+			return fmt.Errorf("unwrapping data key %d: %w", id, err)
+		}
+		keys[id] = k
+	}
+	if _, ok := keys[activeID]; !ok {
+		activeID = 0
+	}
+	keyring.keys = keys
+	keyring.activeID = activeID
+	return nil
+}
+
+// NewDataKey - create a data key returning it wrapped by the key provider
+func NewDataKey() ([]byte, error) {
+	p := GetKeyProvider()
+	if p == nil {
+		return nil, ErrNoKeyProvider
+	}
+	k := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return nil, err
+	}
+	return p.Wrap(k)
+}
+
+// IsEncrypted - whether the stored secret is encrypted
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// EncryptedWithActiveKey - whether the stored secret is encrypted with the active data key (empty secrets are not
+// encrypted so are considered encrypted), secrets that are not are re-encrypted when migrating or rotating the data key
+func EncryptedWithActiveKey(value string) bool {
+	if value == "" {
+		return true
+	}
+	if !IsEncrypted(value) {
+		return false
+	}
+	keyring.RLock()
+	activeID := keyring.activeID
+	keyring.RUnlock()
+	return activeID != 0 && strings.HasPrefix(value, prefix+strconv.FormatUint(activeID, 10)+":")
+}
+
+// Rewrap - unwrap the wrapped data key with the key provider and wrap it with the new key provider, used to rotate
+// the key encryption key
+func Rewrap(wrapped []byte, newProvider KeyProvider) ([]byte, error) {
+	p := GetKeyProvider()
+	if p == nil {
+		return nil, ErrNoKeyProvider
+	}
+	k, err := p.Unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return newProvider.Wrap(k)
+}
+
+// Encrypt - encrypt the secret with the active data key, the secret is returned as is when the secrets are not
+// encrypted (no key provider) or it is empty
+func Encrypt(secret string) (string, error) {
+	if secret == "" || !Enabled() {
+		return secret, nil
+	}
+	keyring.RLock()
+	id := keyring.activeID
+	k := keyring.keys[id]
+	keyring.RUnlock()
+	if id == 0 {
+		return "", ErrNoDataKey
+	}
+	sealed, err := seal(k, []byte(secret))
+	if err != nil {
+		return "", err
+	}
+	return prefix + strconv.FormatUint(id, 10) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt - decrypt the stored secret, a plaintext secret (without the prefix) is returned as is
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("malformed encrypted secret")
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted secret data key ID: %w", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted secret: %w", err)
+	}
+	keyring.RLock()
+	provider := keyring.provider
+	k, ok := keyring.keys[id]
+	keyring.RUnlock()
+	if provider == nil {
+		return "", ErrNoKeyProvider
+	}
+	if !ok {
+		return "", fmt.Errorf("data key %d: %w", id, ErrUnknownDataKey)
+	}
+	secret, err := open(k, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// seal - encrypt with AES-GCM returning the nonce followed by the ciphertext
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open - decrypt the nonce followed by the ciphertext (see seal)
+func open(key []byte, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// newGCM - AES-GCM with the key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
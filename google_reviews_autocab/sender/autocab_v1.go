@@ -0,0 +1,75 @@
+package sender
+
+import (
+	"encoding/json"
+	"log"
+
+	"google_reviews_autocab/autocab_api_v1"
+	"google_reviews_autocab/config"
+	"google_reviews_autocab/utils"
+)
+
+const autocabV1 = "AUTOCAB_V1"
+
+func init() {
+	Register(autocabV1, autocabV1Sender{})
+}
+
+// autocabV1Sender - send the message via the Autocab V1 Send SMS API.
+//
+// NOTE: The send URL is the Autocab API URL and the alternate message service secret1 is used in the
+// header as the subscription key.
+type autocabV1Sender struct{}
+
+// Name - name of the message service (recorded as the channel in message events)
+func (autocabV1Sender) Name() string {
+	return autocabV1
+}
+
+// BuildRequest - build the HTTP request to send the message
+func (autocabV1Sender) BuildRequest(m Message) Request {
+	body, _ := json.Marshal(autocab_api_v1.SendSMSRequest{
+		SenderName:      config.Conf.AutocabSendSMSSenderName,
+		RecipientTelnos: []string{m.Telephone},
+		Message:         m.Message,
+	})
+	return Request{
+		URL:    appendPath(m.SendURL, "sms/v1/send"),
+		Method: "POST",
+		Headers: map[string]string{
+			"Content-Type":              "application/json",
+			"Accept":                    "application/json",
+			"Ocp-Apim-Subscription-Key": m.Secret1,
+		},
+		Body:          body,
+		TLSSkipVerify: m.TLSSkipVerify,
+	}
+}
+
+// Send - send the request
+func (autocabV1Sender) Send(r Request) string {
+	return send(r)
+}
+
+// InterpretResponse - check the response to make sure it has been sent successfully
+// For a successfully sent SMS (probably via SIMs since received SMS shows a telephone number) the response
+// appears to be empty, so the documented response is only checked if there is one, for failed may get the reason e.g.:
+//
+//	{ "statusCode": 401, "message": "Access denied due to invalid subscription key..." }
+func (autocabV1Sender) InterpretResponse(m Message, resp string) (string, bool) {
+	if len(resp) == 0 {
+		return m.SuccessResponse, true
+	}
+	for _, t := range autocab_api_v1.GetSendSMSResponse(resp).SentRecipients {
+		if t == m.Telephone {
+			return m.SuccessResponse, true
+		}
+	}
+	log.Printf("Error sending SMS via Autocab Send SMS to telephone: %s, message: %s, response: %s", utils.MaskTelephone(m.Telephone), m.Message, resp)
+	return resp, false
+}
+
+// SendLater - store the request to be sent later
+func (autocabV1Sender) SendLater(m Message, r Request, sendAfterMinutes int) {
+	addSendLater(m, r, sendAfterMinutes, m.SuccessResponse, false, true, autocabV1, false)
+}
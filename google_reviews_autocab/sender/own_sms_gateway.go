@@ -0,0 +1,57 @@
+package sender
+
+import (
+	"net/url"
+	"sync"
+
+	"google_reviews_autocab/config"
+)
+
+func init() {
+	Register(OwnSMSGateway, ownSMSGatewaySender{})
+}
+
+// global mutex to prevent code which sends SMS running in parallel because the SMS gateway
+// is unable to handle the number of requests generated by this code at once.
+var sendSmsMutex sync.Mutex
+
+// ownSMSGatewaySender - send the message via own SMS gateway (send_sms) using the configured parameters
+type ownSMSGatewaySender struct{}
+
+// Name - name of the message service (recorded as the channel in message events)
+func (ownSMSGatewaySender) Name() string {
+	return OwnSMSGateway
+}
+
+// BuildRequest - build the HTTP request to send the message
+func (ownSMSGatewaySender) BuildRequest(m Message) Request {
+	params := url.Values{}
+	params.Add(config.Conf.SendSmsTokenParameter, config.Conf.SendSmsToken)
+	params.Add(config.Conf.SendSmsTelephoneParameter, m.SendTelephone)
+	params.Add(config.Conf.SendSmsMessageParameter, m.Message)
+	return Request{
+		URL:    config.Conf.SendSmsURL,
+		Method: "POST",
+		Params: params,
+	}
+}
+
+// Send - send the request
+// NOTE: This uses the send SMS mutex to prevent sending too many requests to the SMS gateway which caused it to drop requests,
+// the requests are also paced so the SIMs are not sent a burst of messages (see paceGateway).
+func (ownSMSGatewaySender) Send(r Request) string {
+	sendSmsMutex.Lock()
+	defer sendSmsMutex.Unlock()
+	paceGateway(r.URL)
+	return send(r)
+}
+
+// InterpretResponse - the response must be the configured own SMS gateway success response
+func (ownSMSGatewaySender) InterpretResponse(m Message, resp string) (string, bool) {
+	return resp, resp == config.Conf.SendSmsSuccessResponse
+}
+
+// SendLater - store the request to be sent later, expecting the own SMS gateway success response when sent
+func (ownSMSGatewaySender) SendLater(m Message, r Request, sendAfterMinutes int) {
+	addSendLater(m, r, sendAfterMinutes, config.Conf.SendSmsSuccessResponse, false, false, "", true)
+}
@@ -0,0 +1,93 @@
+package sender
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"google_reviews_autocab/config"
+	"google_reviews_autocab/database"
+)
+
+func init() {
+	Register(ReviewMasterSMSGateway, reviewMasterSMSGatewaySender{})
+}
+
+// reviewMasterSMSGatewaySender - send the message via the Review Master SMS Gateway
+type reviewMasterSMSGatewaySender struct{}
+
+// Name - name of the message service (recorded as the channel in message events)
+func (reviewMasterSMSGatewaySender) Name() string {
+	return ReviewMasterSMSGateway
+}
+
+// BuildRequest - build the HTTP request to send the message with json:
+//
+//	{"queue_id": "81", "message": "Hello world", "telephone": "+441234567890"}
+//
+//	queue_id: this is the same as the client id, unless set to master queue, which is sent in the pairing process
+//	telephone: the telephone (should be in E.164 format prepended with a + sign)
+//	message: the message
+func (reviewMasterSMSGatewaySender) BuildRequest(m Message) Request {
+	queueID := m.ClientID
+	if m.ReviewMasterSMSGatewayUseMasterQueue {
+		queueID = uint64(database.ReviewMasterSMSMasterQueue)
+	}
+	body, _ := json.Marshal(map[string]string{
+		"queue_id":  strconv.FormatUint(queueID, 10),
+		"telephone": "+" + m.SendTelephone,
+		"message":   m.Message,
+	})
+	return Request{
+		URL:    config.Conf.ReviewMasterSMSGatewayURL,
+		Method: "POST",
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"Accept":       "application/json",
+			"Api-Token":    config.Conf.ReviewMasterSMSGatewayApiToken,
+		},
+		Body: body,
+	}
+}
+
+// Send - send the request
+func (reviewMasterSMSGatewaySender) Send(r Request) string {
+	return send(r)
+}
+
+// InterpretResponse - check the response to make sure it has been sent successfully:
+// example possible responses:
+//
+//	{"id":76} - success with message id
+//	{"error":"unauthorized"} - unsuccessful unauthorized
+//	{"errors":{"message":["can't be blank"]}} - unsuccessful errors
+//	{"errors":{"telephone":["can't be blank"]}} - unsuccessful errors
+//	{"errors":{"detail":"Bad Request"}} - unsuccessful errors
+func (reviewMasterSMSGatewaySender) InterpretResponse(m Message, resp string) (string, bool) {
+	var rJson map[string]interface{}
+	if errJson := json.Unmarshal([]byte(resp), &rJson); errJson != nil {
+		log.Printf("Error unmarshalling JSON from sending message from Review Master SMS Gateway APP for clientID: %d, response from send server: %+v, error: %+v", m.ClientID, resp, errJson)
+	}
+	if _, ok := rJson["id"]; !ok {
+		return resp, false
+	}
+	// set response to expected configured response which can be anything
+	return m.SuccessResponse, true
+}
+
+// reviewMasterSMSGatewayResponse - response when sent e.g. {"id":76}
+type reviewMasterSMSGatewayResponse struct {
+	ID json.Number `json:"id"`
+}
+
+// MessageID - the message id from the response, used to match the delivery reports
+func (reviewMasterSMSGatewaySender) MessageID(resp string) string {
+	var r reviewMasterSMSGatewayResponse
+	json.Unmarshal([]byte(resp), &r)
+	return r.ID.String()
+}
+
+// SendLater - store the request to be sent later
+func (reviewMasterSMSGatewaySender) SendLater(m Message, r Request, sendAfterMinutes int) {
+	addSendLater(m, r, sendAfterMinutes, m.SuccessResponse, true, false, "", false)
+}
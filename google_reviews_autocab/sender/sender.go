@@ -0,0 +1,285 @@
+// Package sender - sends messages via the configured message service.
+// Each message service implements MessageSender and is registered by name, the name being the
+// alternate message service as stored in the config. To add a new message service implement
+// MessageSender and register it in init.
+//
+// NOTE: This mirrors the google_reviews sender package, send laters stored here are sent by the
+// google_reviews send later worker so the requests must match.
+package sender
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google_reviews_autocab/client"
+	"google_reviews_autocab/config"
+	"google_reviews_autocab/database"
+)
+
+// registry keys for the message services that are not set by the alternate message service
+const (
+	OwnSMSGateway          = "OWN_SMS_GATEWAY"
+	ReviewMasterSMSGateway = "REVIEW_MASTER_SMS_GATEWAY"
+)
+
+// Message - message to send and the details needed to send it
+type Message struct {
+	ClientID uint64
+	// Telephone - telephone in E.164 format without the + (used for last sent and send later)
+	Telephone string
+	// SendTelephone - telephone to send to, which may have the country code replaced
+	SendTelephone string
+	Message       string
+	SendURL       string
+	// Secret1 - alternate message service secret1 from the config (e.g. Autocab subscription key)
+	Secret1 string
+	// Sender - alternate message service sender from the config (e.g. Twilio alphanumeric sender or messaging service SID)
+	Sender                               string
+	ReviewMasterSMSGatewayUseMasterQueue bool
+	// SuccessResponse - configured response expected when sent successfully
+	SuccessResponse   string
+	MaxDailySendCount uint
+	// TLSSkipVerify - the TLS certificate of the send URL is not verified (the config opted out of TLS verification)
+	TLSSkipVerify bool
+	// TemplateValues - message template placeholder values, used to fill the WhatsApp template parameters
+	TemplateValues map[string]string
+	// WhatsApp Cloud API phone number, access token and template from the config (see whatsAppSender)
+	WhatsAppPhoneNumberID      string
+	WhatsAppAccessToken        string
+	WhatsAppTemplateName       string
+	WhatsAppTemplateLanguage   string
+	WhatsAppTemplateParameters string
+	// FallbackRef - reference of the fallback held whilst the message is delivered via WhatsApp (see HoldFallback)
+	FallbackRef string
+	// holdFallback - the send later is stored as a held fallback (see HoldFallback)
+	holdFallback bool
+}
+
+// Request - HTTP request to send a message
+type Request struct {
+	URL     string
+	Method  string
+	Headers map[string]string
+	Params  url.Values
+	Body    []byte
+	// TLSSkipVerify - the TLS certificate is not verified (see Message)
+	TLSSkipVerify bool
+}
+
+// MessageSender - message service used to send messages
+type MessageSender interface {
+	// Name - name of the message service (recorded as the channel in message events)
+	Name() string
+	// BuildRequest - build the HTTP request to send the message
+	BuildRequest(m Message) Request
+	// Send - send the request returning the response from the message service
+	Send(r Request) string
+	// InterpretResponse - check the response from the message service returning the response
+	// and whether the message was sent successfully
+	InterpretResponse(m Message, resp string) (string, bool)
+	// SendLater - store the request to be sent later by the send later worker
+	SendLater(m Message, r Request, sendAfterMinutes int)
+}
+
+// MessageIDSender - message service returning a message ID when sent, used to match its delivery status callbacks
+type MessageIDSender interface {
+	// MessageID - the message ID from the response of the message service (empty if none)
+	MessageID(resp string) string
+}
+
+// FallbackSender - message service whose messages can have a fallback (e.g. WhatsApp with SMS fallback), the
+// fallback is held whilst the message is delivered (see HoldFallback)
+type FallbackSender interface {
+	// FallbackRef - the reference of the held fallback sent with the request (empty if none)
+	FallbackRef(r Request) string
+}
+
+var senders = map[string]MessageSender{}
+
+// Register - register a message sender by name (alternate message service)
+func Register(name string, s MessageSender) {
+	senders[name] = s
+}
+
+// Get - get a registered message sender by name, returns nil if not found
+func Get(name string) MessageSender {
+	return senders[name]
+}
+
+// ForConfig - get the message sender for the config, WhatsApp when it is the preferred channel
+func ForConfig(grcftwc database.GoogleReviewsConfigFromTokenWithChecks) MessageSender {
+	if prefersWhatsApp(grcftwc) {
+		return senders[WhatsApp]
+	}
+	return find(grcftwc)
+}
+
+// FallbackForConfig - get the message sender used when a message is not sent via WhatsApp (e.g. the telephone is not
+// on WhatsApp), the SMS message service of the config. Returns nil when WhatsApp is not the preferred channel or SMS
+// fallback is not enabled.
+func FallbackForConfig(grcftwc database.GoogleReviewsConfigFromTokenWithChecks) MessageSender {
+	if !prefersWhatsApp(grcftwc) || !grcftwc.WhatsAppSMSFallback {
+		return nil
+	}
+	return find(grcftwc)
+}
+
+// prefersWhatsApp - whether WhatsApp is the preferred channel of the config (and the phone number is set up)
+func prefersWhatsApp(grcftwc database.GoogleReviewsConfigFromTokenWithChecks) bool {
+	return grcftwc.MessageChannel == WhatsApp && grcftwc.WhatsAppPhoneNumberID != "" && grcftwc.WhatsAppTemplateName != ""
+}
+
+// find - find the SMS message sender for the config, in order of precedence:
+// Review Master SMS Gateway, alternate message service then own SMS gateway
+func find(grcftwc database.GoogleReviewsConfigFromTokenWithChecks) MessageSender {
+	if grcftwc.ReviewMasterSMSGatewayEnabled {
+		return senders[ReviewMasterSMSGateway]
+	}
+	if grcftwc.AlternateMessageServiceEnabled {
+		if s, ok := senders[grcftwc.AlternateMessageService]; ok {
+			return s
+		}
+	}
+	return senders[OwnSMSGateway]
+}
+
+// MessageFromConfig - create the message to send from the config
+func MessageFromConfig(grcftwc database.GoogleReviewsConfigFromTokenWithChecks, telephone string, sendTelephone string,
+	message string) Message {
+	return Message{
+		ClientID:                             grcftwc.ClientID,
+		Telephone:                            telephone,
+		SendTelephone:                        sendTelephone,
+		Message:                              message,
+		SendURL:                              strings.TrimSpace(grcftwc.SendURL),
+		Secret1:                              strings.TrimSpace(grcftwc.AlternateMessageServiceSecret1),
+		Sender:                               strings.TrimSpace(grcftwc.AlternateMessageServiceSender),
+		ReviewMasterSMSGatewayUseMasterQueue: grcftwc.ReviewMasterSMSGatewayUseMasterQueue,
+		SuccessResponse:                      grcftwc.SendSuccessResponse,
+		MaxDailySendCount:                    grcftwc.MaxDailySendCount,
+		TLSSkipVerify:                        grcftwc.TLSSkipVerify,
+		WhatsAppPhoneNumberID:                grcftwc.WhatsAppPhoneNumberID,
+		WhatsAppAccessToken:                  strings.TrimSpace(grcftwc.WhatsAppAccessToken),
+		WhatsAppTemplateName:                 grcftwc.WhatsAppTemplateName,
+		WhatsAppTemplateLanguage:             grcftwc.WhatsAppTemplateLanguage,
+		WhatsAppTemplateParameters:           grcftwc.WhatsAppTemplateParameters,
+		FallbackRef:                          fallbackRef(grcftwc),
+	}
+}
+
+// fallbackRef - new reference for the fallback of the message when the config has one (see FallbackForConfig)
+func fallbackRef(grcftwc database.GoogleReviewsConfigFromTokenWithChecks) string {
+	if FallbackForConfig(grcftwc) == nil {
+		return ""
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error creating fallback ref for clientID: %d, err: %v\n", grcftwc.ClientID, err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// RecordMessageID - record the message ID from the response against the message event of the message sent when the
+// message service returns one (see MessageIDSender)
+func RecordMessageID(s MessageSender, messageEventID uint64, resp string) {
+	if ms, ok := s.(MessageIDSender); ok && messageEventID != 0 {
+		database.SetMessageEventProviderMessageID(messageEventID, ms.MessageID(resp))
+	}
+}
+
+// HoldFallback - store the message via the fallback as a held send later whilst the message sent via s is delivered,
+// it is released or deleted by the google reviews server (see the WhatsApp webhook). Nothing is held when there is no
+// fallback or the message was sent via the fallback.
+func HoldFallback(s MessageSender, fallback MessageSender, m Message) {
+	if fallback == nil || s == fallback || m.FallbackRef == "" {
+		return
+	}
+	m.holdFallback = true
+	fallback.SendLater(m, fallback.BuildRequest(m), 0)
+}
+
+// SendWithFallback - send the request, when it is not sent (e.g. the telephone is not on WhatsApp) and there is a
+// fallback the failure is recorded and the message is sent via the fallback instead. Returns the message sender used,
+// the request sent, the response and the latency of the last send.
+func SendWithFallback(s MessageSender, fallback MessageSender, m Message, r Request) (MessageSender, Request, string, time.Duration) {
+	sendStart := time.Now()
+	providerResp := s.Send(r)
+	latency := time.Since(sendStart)
+	if fallback == nil || s == fallback {
+		return s, r, providerResp, latency
+	}
+	if _, sent := s.InterpretResponse(m, providerResp); sent {
+		return s, r, providerResp, latency
+	}
+	log.Printf("not sent via %s for clientID: %d, sending via %s\n", s.Name(), m.ClientID, fallback.Name())
+	database.AddMessageEvent(m.ClientID, m.Telephone, s.Name(), database.ReasonProviderError, providerResp, latency)
+	r = fallback.BuildRequest(m)
+	sendStart = time.Now()
+	providerResp = fallback.Send(r)
+	return fallback, r, providerResp, time.Since(sendStart)
+}
+
+// send - send the request
+func send(r Request) string {
+	return client.Send(r.URL, r.Method, r.Headers, r.Params, r.Body, r.TLSSkipVerify)
+}
+
+// the time the next request can be sent to each gateway (see paceGateway)
+var (
+	gatewaysMu  sync.Mutex
+	gatewayNext = make(map[string]time.Time)
+)
+
+// paceGateway - wait until the next request can be sent to the gateway (the host of the URL) so an SMS gateway
+// sending from SIMs is not sent a burst of requests, the requests to a gateway are spaced by the gateway send
+// interval (0 disables). The wait is not capped as in google_reviews, the bookings are sent by the batch process
+// rather than a webhook handler.
+// NOTE: keep in line with google_reviews
+func paceGateway(gatewayURL string) {
+	interval := time.Duration(config.Conf.GatewaySendInterval) * time.Millisecond
+	if interval <= 0 {
+		return
+	}
+	gateway := gatewayURL
+	if u, err := url.Parse(gatewayURL); err == nil && u.Host != "" {
+		gateway = u.Host
+	}
+	gatewaysMu.Lock()
+	now := time.Now()
+	next := gatewayNext[gateway]
+	if next.Before(now) {
+		next = now
+	}
+	gatewayNext[gateway] = next.Add(interval)
+	gatewaysMu.Unlock()
+	time.Sleep(next.Sub(now))
+}
+
+// addSendLater - store the request to be sent later with the flags used to find the sender when sent
+func addSendLater(m Message, r Request, sendAfterMinutes int, successResponse string, reviewMasterSMSGatewayEnabled bool,
+	alternateMessageServiceEnabled bool, alternateMessageService string, sendFromOwnSMSGatewayEnabled bool) {
+	ref := ""
+	if m.holdFallback {
+		ref = m.FallbackRef
+	}
+	database.AddSendLater(m.Telephone, m.ClientID, sendAfterMinutes,
+		r.URL, r.Method, "", "",
+		r.Headers, r.Params, r.Body, false,
+		reviewMasterSMSGatewayEnabled, alternateMessageServiceEnabled,
+		alternateMessageService, sendFromOwnSMSGatewayEnabled,
+		successResponse, m.MaxDailySendCount, ref, r.TLSSkipVerify)
+}
+
+// appendPath - append path to URL adding a / if needed
+func appendPath(u string, path string) string {
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	return u + path
+}
@@ -0,0 +1,234 @@
+package sender
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"google_reviews_autocab/config"
+	"google_reviews_autocab/database"
+)
+
+// testServer - local HTTP server standing in for a message service, records the last request received
+func testServer(status int, response string, last *http.Request, lastBody *[]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*lastBody, _ = ioutil.ReadAll(req.Body)
+		*last = *req
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+}
+
+func TestForConfig(t *testing.T) {
+	tests := []struct {
+		grcftwc  database.GoogleReviewsConfigFromTokenWithChecks
+		expected MessageSender
+	}{
+		{database.GoogleReviewsConfigFromTokenWithChecks{}, ownSMSGatewaySender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{ReviewMasterSMSGatewayEnabled: true, AlternateMessageServiceEnabled: true, AlternateMessageService: "AUTOCAB_V1"}, reviewMasterSMSGatewaySender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{AlternateMessageServiceEnabled: true, AlternateMessageService: "AUTOCAB_V1"}, autocabV1Sender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{AlternateMessageServiceEnabled: false, AlternateMessageService: "AUTOCAB_V1"}, ownSMSGatewaySender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{AlternateMessageServiceEnabled: true, AlternateMessageService: "Twilio"}, twilioSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{AlternateMessageServiceEnabled: true, AlternateMessageService: "Unknown"}, ownSMSGatewaySender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{MessageChannel: WhatsApp, WhatsAppPhoneNumberID: "1098", WhatsAppTemplateName: "review_request"}, whatsAppSender{}},
+		{database.GoogleReviewsConfigFromTokenWithChecks{MessageChannel: WhatsApp, WhatsAppTemplateName: "review_request"}, ownSMSGatewaySender{}},
+	}
+	for i, tt := range tests {
+		if s := ForConfig(tt.grcftwc); s != tt.expected {
+			t.Errorf("test %d: expected sender %T got %T", i, tt.expected, s)
+		}
+	}
+}
+
+func TestFallbackForConfig(t *testing.T) {
+	grcftwc := database.GoogleReviewsConfigFromTokenWithChecks{MessageChannel: WhatsApp, WhatsAppPhoneNumberID: "1098",
+		WhatsAppTemplateName: "review_request", WhatsAppSMSFallback: true, AlternateMessageServiceEnabled: true, AlternateMessageService: "Twilio"}
+	if s := FallbackForConfig(grcftwc); s != (twilioSender{}) {
+		t.Errorf("expected Twilio fallback got %T", s)
+	}
+	if m := MessageFromConfig(grcftwc, "447123456789", "447123456789", "testing"); len(m.FallbackRef) != 32 {
+		t.Errorf("expected a fallback ref got %s", m.FallbackRef)
+	}
+	grcftwc.WhatsAppSMSFallback = false
+	if s := FallbackForConfig(grcftwc); s != nil {
+		t.Errorf("expected no fallback got %T", s)
+	}
+}
+
+func TestOwnSMSGatewaySender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusOK, "OK", &req, &body)
+	defer ts.Close()
+	config.Conf.SendSmsURL = ts.URL
+	config.Conf.SendSmsToken = "abc123"
+	config.Conf.SendSmsTokenParameter = "token"
+	config.Conf.SendSmsTelephoneParameter = "telephone"
+	config.Conf.SendSmsMessageParameter = "message"
+	config.Conf.SendSmsSuccessResponse = "OK"
+
+	s := Get(OwnSMSGateway)
+	m := Message{Telephone: "447123456789", SendTelephone: "07123456789", Message: "testing", SuccessResponse: "success"}
+	resp, sent := s.InterpretResponse(m, s.Send(s.BuildRequest(m)))
+	if !sent || resp != "OK" {
+		t.Fatalf("expected sent with response OK got %t %s", sent, resp)
+	}
+	form, _ := url.ParseQuery(string(body))
+	if form.Get("token") != "abc123" || form.Get("telephone") != "07123456789" || form.Get("message") != "testing" {
+		t.Errorf("unexpected own SMS gateway parameters %v", form)
+	}
+	if _, sent := s.InterpretResponse(m, "FAILED"); sent {
+		t.Error("FAILED response should not be sent")
+	}
+}
+
+func TestReviewMasterSMSGatewaySender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusCreated, `{"id":76}`, &req, &body)
+	defer ts.Close()
+	config.Conf.ReviewMasterSMSGatewayURL = ts.URL
+	config.Conf.ReviewMasterSMSGatewayApiToken = "abc123"
+
+	s := Get(ReviewMasterSMSGateway)
+	m := Message{ClientID: 81, SendTelephone: "447123456789", Message: "testing", SuccessResponse: "OK"}
+	resp, sent := s.InterpretResponse(m, s.Send(s.BuildRequest(m)))
+	if !sent || resp != "OK" {
+		t.Fatalf("expected sent with response OK got %t %s", sent, resp)
+	}
+	if req.Header.Get("Api-Token") != "abc123" {
+		t.Errorf("expected api token header got %v", req.Header)
+	}
+	var rJson map[string]string
+	json.Unmarshal(body, &rJson)
+	if rJson["queue_id"] != "81" || rJson["telephone"] != "+447123456789" || rJson["message"] != "testing" {
+		t.Errorf("unexpected body %s", body)
+	}
+	if _, sent := s.InterpretResponse(m, `{"error":"unauthorized"}`); sent {
+		t.Error("unauthorized should not be sent")
+	}
+	if id := s.(MessageIDSender).MessageID(`{"id":76}`); id != "76" {
+		t.Errorf("unexpected message ID %s", id)
+	}
+	if id := s.(MessageIDSender).MessageID(`{"error":"unauthorized"}`); id != "" {
+		t.Errorf("unexpected message ID %s for an error", id)
+	}
+}
+
+func TestTwilioSender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusCreated, `{"sid":"SM0123456789abcdef0123456789abcdef","status":"queued","error_code":null,"error_message":null}`, &req, &body)
+	defer ts.Close()
+	config.Conf.TwilioStatusCallbackURL = "https://reviews.example.com/twilio/status"
+	defer func() { config.Conf.TwilioStatusCallbackURL = "" }()
+
+	s := Get(Twilio)
+	m := Message{SendURL: ts.URL + "/2010-04-01/Accounts/AC0123456789abcdef0123456789abcdef/Messages.json", Secret1: "token",
+		Sender: "TaxiCo", SendTelephone: "447123456789", Message: "testing", SuccessResponse: "OK"}
+	providerResp := s.Send(s.BuildRequest(m))
+	resp, sent := s.InterpretResponse(m, providerResp)
+	if !sent || resp != "OK" {
+		t.Fatalf("expected sent with response OK got %t %s", sent, resp)
+	}
+	if user, pass, ok := req.BasicAuth(); !ok || user != "AC0123456789abcdef0123456789abcdef" || pass != "token" {
+		t.Error("expected basic authentication with the account SID and auth token")
+	}
+	form, _ := url.ParseQuery(string(body))
+	if form.Get("To") != "+447123456789" || form.Get("Body") != "testing" || form.Get("From") != "TaxiCo" ||
+		form.Get("StatusCallback") != "https://reviews.example.com/twilio/status" {
+		t.Errorf("unexpected Twilio parameters %v", form)
+	}
+	if id := s.(MessageIDSender).MessageID(providerResp); id != "SM0123456789abcdef0123456789abcdef" {
+		t.Errorf("unexpected message ID %s", id)
+	}
+	if _, sent := s.InterpretResponse(m, `{"code":20003,"message":"Authenticate","status":401}`); sent {
+		t.Error("unauthorized should not be sent")
+	}
+}
+
+func TestWhatsAppSender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusOK, `{"messaging_product":"whatsapp","contacts":[{"input":"447123456789","wa_id":"447123456789"}],"messages":[{"id":"wamid.HBgM0123"}]}`, &req, &body)
+	defer ts.Close()
+	graphURL := config.Conf.WhatsAppGraphURL
+	config.Conf.WhatsAppGraphURL = ts.URL + "/v19.0"
+	defer func() { config.Conf.WhatsAppGraphURL = graphURL }()
+
+	s := Get(WhatsApp)
+	m := Message{SendTelephone: "447123456789", Message: "testing", SuccessResponse: "OK",
+		TemplateValues:        map[string]string{"first_name": "Jane", "review_link": "https://g.page/r/review"},
+		WhatsAppPhoneNumberID: "109876543210987", WhatsAppAccessToken: "token", WhatsAppTemplateName: "review_request",
+		WhatsAppTemplateParameters: "{first_name},{review_link}", FallbackRef: "ref1"}
+	r := s.BuildRequest(m)
+	providerResp := s.Send(r)
+	if resp, sent := s.InterpretResponse(m, providerResp); !sent || resp != "OK" {
+		t.Fatalf("expected sent with response OK got %t %s", sent, resp)
+	}
+	if req.URL.Path != "/v19.0/109876543210987/messages" || req.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("unexpected request %s %s", req.URL.Path, req.Header.Get("Authorization"))
+	}
+	var wmr whatsAppMessageRequest
+	if err := json.Unmarshal(body, &wmr); err != nil {
+		t.Fatal(err)
+	}
+	if wmr.Template.Language.Code != "en" || wmr.BizOpaqueCallbackData != "ref1" || len(wmr.Template.Components) != 1 ||
+		len(wmr.Template.Components[0].Parameters) != 2 || wmr.Template.Components[0].Parameters[1].Text != "https://g.page/r/review" {
+		t.Fatalf("unexpected WhatsApp request %s", body)
+	}
+	if id := s.(MessageIDSender).MessageID(providerResp); id != "wamid.HBgM0123" {
+		t.Errorf("unexpected message ID %s", id)
+	}
+	if ref := s.(FallbackSender).FallbackRef(r); ref != "ref1" {
+		t.Errorf("unexpected fallback ref %s", ref)
+	}
+	if _, sent := s.InterpretResponse(m, `{"error":{"message":"(#131030) Recipient phone number not in allowed list","code":131030}}`); sent {
+		t.Error("error response should not be sent")
+	}
+}
+
+func TestAutocabV1Sender(t *testing.T) {
+	var req http.Request
+	var body []byte
+	ts := testServer(http.StatusOK, "", &req, &body)
+	defer ts.Close()
+	config.Conf.AutocabSendSMSSenderName = "Reviews"
+
+	s := Get("AUTOCAB_V1")
+	m := Message{SendURL: ts.URL, Secret1: "key", Telephone: "447123456789", SendTelephone: "07123456789", Message: "testing", SuccessResponse: "OK"}
+	resp, sent := s.InterpretResponse(m, s.Send(s.BuildRequest(m)))
+	if !sent || resp != "OK" {
+		t.Fatalf("expected sent with response OK got %t %s", sent, resp)
+	}
+	if req.URL.Path != "/sms/v1/send" || req.Header.Get("Ocp-Apim-Subscription-Key") != "key" {
+		t.Errorf("unexpected request path %s headers %v", req.URL.Path, req.Header)
+	}
+	if string(body) != `{"senderName":"Reviews","recipientTelnos":["447123456789"],"message":"testing"}` {
+		t.Errorf("unexpected body %s", body)
+	}
+	if _, sent := s.InterpretResponse(m, `{"sentRecipients":["447123456789"],"unsentRecipients":[]}`); !sent {
+		t.Error("telephone in sent recipients should be sent")
+	}
+	if _, sent := s.InterpretResponse(m, `{ "statusCode": 401, "message": "Access denied" }`); sent {
+		t.Error("access denied should not be sent")
+	}
+}
+
+func TestPaceGateway(t *testing.T) {
+	config.Conf.GatewaySendInterval = 50
+	defer func() { config.Conf.GatewaySendInterval = 0 }()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		paceGateway("https://gateway.example.com/send")
+	}
+	// the second and third requests wait for the interval
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("requests to the gateway should be spaced by the interval, took %v", elapsed)
+	}
+}
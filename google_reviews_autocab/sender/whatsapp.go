@@ -0,0 +1,178 @@
+package sender
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"google_reviews_autocab/config"
+	"google_reviews_autocab/utils"
+)
+
+// WhatsApp - registry key and channel of the WhatsApp message service (the WhatsApp Cloud API)
+const WhatsApp = "WhatsApp"
+
+// whatsAppMessagePlaceholder - template parameter replaced by the whole message (as sent by SMS)
+const whatsAppMessagePlaceholder = "{message}"
+
+func init() {
+	Register(WhatsApp, whatsAppSender{})
+}
+
+// whatsAppSender - send the message as a pre-approved template message via the WhatsApp Cloud API (the status
+// notifications are handled by the google reviews server, see google_reviews/server/whatsapp_webhook_handler.go)
+// NOTE: keep in line with google_reviews
+// (see: https://developers.facebook.com/docs/whatsapp/cloud-api/guides/send-message-templates)
+//
+// NOTE: The message is sent to <WhatsApp graph URL>/<phone number ID>/messages with the access token of the config.
+// The template parameters are a comma separated list of the body parameters in order, each filled in as a message
+// template e.g. {first_name},{review_link} or {message} for the whole message (the default). The fallback reference
+// is sent as the callback data so it is returned with the status notifications (see the WhatsApp webhook of the
+// google reviews server).
+type whatsAppSender struct{}
+
+// Name - name of the message service (recorded as the channel in message events)
+func (whatsAppSender) Name() string {
+	return WhatsApp
+}
+
+type whatsAppParameter struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type whatsAppComponent struct {
+	Type       string              `json:"type"`
+	Parameters []whatsAppParameter `json:"parameters"`
+}
+
+type whatsAppLanguage struct {
+	Code string `json:"code"`
+}
+
+type whatsAppTemplate struct {
+	Name       string              `json:"name"`
+	Language   whatsAppLanguage    `json:"language"`
+	Components []whatsAppComponent `json:"components,omitempty"`
+}
+
+type whatsAppMessageRequest struct {
+	MessagingProduct      string           `json:"messaging_product"`
+	RecipientType         string           `json:"recipient_type"`
+	To                    string           `json:"to"`
+	Type                  string           `json:"type"`
+	Template              whatsAppTemplate `json:"template"`
+	BizOpaqueCallbackData string           `json:"biz_opaque_callback_data,omitempty"`
+}
+
+type whatsAppMessageResponse struct {
+	Messages []struct {
+		ID string `json:"id"`
+	} `json:"messages"`
+	Error *struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+	} `json:"error"`
+}
+
+// whatsAppTemplateParameters - the template body parameters filled in from the message and template values
+func whatsAppTemplateParameters(m Message) []whatsAppParameter {
+	templateParameters := m.WhatsAppTemplateParameters
+	if strings.TrimSpace(templateParameters) == "" {
+		templateParameters = whatsAppMessagePlaceholder
+	}
+	var parameters []whatsAppParameter
+	for _, p := range strings.Split(templateParameters, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		text := utils.FillMessageTemplate(strings.ReplaceAll(p, whatsAppMessagePlaceholder, m.Message), m.TemplateValues)
+		// a parameter cannot be empty
+		if strings.TrimSpace(text) == "" {
+			text = "-"
+		}
+		parameters = append(parameters, whatsAppParameter{Type: "text", Text: text})
+	}
+	return parameters
+}
+
+// BuildRequest - build the HTTP request to send the template message
+func (whatsAppSender) BuildRequest(m Message) Request {
+	language := m.WhatsAppTemplateLanguage
+	if language == "" {
+		language = "en"
+	}
+	wmr := whatsAppMessageRequest{
+		MessagingProduct: "whatsapp",
+		RecipientType:    "individual",
+		To:               m.SendTelephone,
+		Type:             "template",
+		Template: whatsAppTemplate{
+			Name:     m.WhatsAppTemplateName,
+			Language: whatsAppLanguage{Code: language},
+		},
+		BizOpaqueCallbackData: m.FallbackRef,
+	}
+	if parameters := whatsAppTemplateParameters(m); len(parameters) > 0 {
+		wmr.Template.Components = []whatsAppComponent{{Type: "body", Parameters: parameters}}
+	}
+	body, err := json.Marshal(wmr)
+	if err != nil {
+		log.Printf("Error marshalling WhatsApp message for clientID: %d, err: %v\n", m.ClientID, err)
+	}
+	return Request{
+		URL:    appendPath(config.Conf.WhatsAppGraphURL, m.WhatsAppPhoneNumberID+"/messages"),
+		Method: "POST",
+		Headers: map[string]string{
+			"Authorization": "Bearer " + m.WhatsAppAccessToken,
+			"Content-Type":  "application/json",
+		},
+		Body: body,
+	}
+}
+
+// Send - send the request
+func (whatsAppSender) Send(r Request) string {
+	return send(r)
+}
+
+// InterpretResponse - check the response to make sure the message has been accepted by WhatsApp
+// example successful response:
+// {"messaging_product":"whatsapp","contacts":[{"input":"447123456789","wa_id":"447123456789"}],"messages":[{"id":"wamid.HBgM..."}]}
+// example failed response:
+// {"error":{"message":"(#131030) Recipient phone number not in allowed list","type":"OAuthException","code":131030,...}}
+func (whatsAppSender) InterpretResponse(m Message, resp string) (string, bool) {
+	var wmr whatsAppMessageResponse
+	if err := json.Unmarshal([]byte(resp), &wmr); err != nil {
+		log.Printf("Error unmarshalling WhatsApp response for clientID: %d, response: %s, err: %v\n", m.ClientID, resp, err)
+		return resp, false
+	}
+	if wmr.Error != nil || len(wmr.Messages) == 0 || wmr.Messages[0].ID == "" {
+		return resp, false
+	}
+	// set response to expected configured response which can be anything
+	return m.SuccessResponse, true
+}
+
+// MessageID - the WhatsApp message ID from the response, used to match the status notifications
+func (whatsAppSender) MessageID(resp string) string {
+	var wmr whatsAppMessageResponse
+	json.Unmarshal([]byte(resp), &wmr)
+	if len(wmr.Messages) == 0 {
+		return ""
+	}
+	return wmr.Messages[0].ID
+}
+
+// FallbackRef - the reference of the held fallback sent as the callback data of the request
+func (whatsAppSender) FallbackRef(r Request) string {
+	var wmr whatsAppMessageRequest
+	json.Unmarshal(r.Body, &wmr)
+	return wmr.BizOpaqueCallbackData
+}
+
+// SendLater - store the request to be sent later
+func (whatsAppSender) SendLater(m Message, r Request, sendAfterMinutes int) {
+	addSendLater(m, r, sendAfterMinutes, m.SuccessResponse, false, true, WhatsApp, false)
+}
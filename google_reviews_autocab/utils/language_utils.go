@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/dongri/phonenumber"
+)
+
+// countryLanguages - the language (ISO 639-1) of the messages sent to the telephones of a country (ISO 3166-1 alpha-2),
+// for a country with more than one language the most spoken is used (the booking locale should be sent for the others)
+// NOTE: keep in line with google_reviews
+var countryLanguages = map[string]string{
+	"AE": "ar", "AR": "es", "AT": "de", "AU": "en", "BE": "nl", "BG": "bg", "BR": "pt", "CA": "en", "CH": "de",
+	"CL": "es", "CO": "es", "CY": "el", "CZ": "cs", "DE": "de", "DK": "da", "EE": "et", "EG": "ar", "ES": "es",
+	"FI": "fi", "FR": "fr", "GB": "en", "GG": "en", "GI": "en", "GR": "el", "HR": "hr", "HU": "hu", "IE": "en",
+	"IM": "en", "IN": "en", "IS": "is", "IT": "it", "JE": "en", "JP": "ja", "LT": "lt", "LU": "fr", "LV": "lv",
+	"MA": "ar", "MT": "mt", "MX": "es", "NL": "nl", "NO": "no", "NZ": "en", "PL": "pl", "PT": "pt", "RO": "ro",
+	"RS": "sr", "SA": "ar", "SE": "sv", "SG": "en", "SI": "sl", "SK": "sk", "TR": "tr", "UA": "uk", "US": "en",
+	"ZA": "en",
+}
+
+// LanguageFromLocale - the language (ISO 639 lower case) of a locale e.g. nl-BE, nl_NL or NL gives nl, returns an
+// empty string when the locale does not start with a language
+func LanguageFromLocale(locale string) string {
+	l := strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(l, "-_"); i >= 0 {
+		l = l[:i]
+	}
+	if len(l) < 2 || len(l) > 3 {
+		return ""
+	}
+	for _, c := range l {
+		if c < 'a' || c > 'z' {
+			return ""
+		}
+	}
+	return l
+}
+
+// LanguageFromTelephone - the language of the country of the telephone in E.164 format without the + (see
+// TelephoneParse), returns an empty string when the country is not found or its language is not known
+func LanguageFromTelephone(telephone string) string {
+	tel := strings.TrimPrefix(strings.TrimSpace(telephone), "+")
+	if tel == "" {
+		return ""
+	}
+	country := phonenumber.GetISO3166ByNumber(tel, true)
+	return countryLanguages[country.Alpha2]
+}
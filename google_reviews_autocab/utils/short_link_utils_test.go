@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestNewShortLinkCode(t *testing.T) {
+	codes := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code := NewShortLinkCode()
+		if !ValidShortLinkCode(code) {
+			t.Fatalf("NewShortLinkCode() = %q is not a valid short link code", code)
+		}
+		if codes[code] {
+			t.Fatalf("NewShortLinkCode() = %q generated twice", code)
+		}
+		codes[code] = true
+	}
+}
+
+func TestValidShortLinkCode(t *testing.T) {
+	tests := []struct {
+		code  string
+		valid bool
+	}{
+		{"aB3dE5gH", true},
+		{"aB3dE5g", false},
+		{"aB3dE5gHi", false},
+		{"aB3dE5g/", false},
+		{"aB3dE5gl", false}, // l is not used (looks like 1)
+		{"", false},
+	}
+	for _, tt := range tests {
+		if valid := ValidShortLinkCode(tt.code); valid != tt.valid {
+			t.Errorf("ValidShortLinkCode(%q) = %t expected %t", tt.code, valid, tt.valid)
+		}
+	}
+}
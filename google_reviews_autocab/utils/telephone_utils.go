@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"strings"
 
 	"github.com/dongri/phonenumber"
@@ -25,3 +28,45 @@ func TelephoneParse(telephone string, country string) string {
 
 	return t
 }
+
+// telephoneHashKey - key of the telephone hashes (see SetTelephoneHashKey)
+var telephoneHashKey []byte
+
+// SetTelephoneHashKey - set the key the telephones are hashed with (HMAC-SHA-256), it has to be set before any
+// telephone is hashed (the service refuses to start without the telephone hash key)
+// NOTE: the telephones stored hashed can no longer be found when the key is changed
+func SetTelephoneHashKey(key string) {
+	telephoneHashKey = []byte(key)
+}
+
+// HashTelephone - keyed hash (HMAC-SHA-256 hex, see SetTelephoneHashKey) of the telephone in E.164 format without
+// the +, used to record and look up the telephone without storing the number itself (empty string if no telephone)
+func HashTelephone(telephone string) string {
+	if telephone == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, telephoneHashKey)
+	mac.Write([]byte(telephone))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// UnkeyedHashTelephone - SHA-256 hash (hex) of the telephone, the hash recorded before the telephone hash key was
+// set, only used by the one-off migration of the message events recorded before the key was set (it can be reversed
+// by hashing every number so it must not be used to record telephones)
+func UnkeyedHashTelephone(telephone string) string {
+	if telephone == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(telephone))
+	return hex.EncodeToString(h[:])
+}
+
+// MaskTelephone - the telephone masked for logging, only the last 3 characters are shown e.g. *********789
+// (a telephone of 4 or fewer characters is masked completely)
+func MaskTelephone(telephone string) string {
+	r := []rune(telephone)
+	if len(r) <= 4 {
+		return strings.Repeat("*", len(r))
+	}
+	return strings.Repeat("*", len(r)-3) + string(r[len(r)-3:])
+}
@@ -61,3 +61,83 @@ func TestConvertToTimeZone_1(t *testing.T) {
 	c3 := ConvertToTimeZone(time.Now(), "America/Caracas")
 	fmt.Println(c3)
 }
+
+func TestPacingDelay(t *testing.T) {
+	allDays := [7]bool{true, true, true, true, true, true, true}
+	loc, _ := time.LoadLocation("Europe/London")
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, loc)
+	tests := []struct {
+		allocated uint
+		delay     time.Duration
+		ok        bool
+	}{
+		// 10 a day between 08:00 and 18:00 is a slot an hour, 2 hours into the window
+		{0, 0, true},
+		{2, 0, true},
+		{3, time.Hour, true},
+		{9, 7 * time.Hour, true},
+		{10, 0, false},
+	}
+	for _, test := range tests {
+		delay, ok := PacingDelay("08:00", "18:00", "Europe/London", allDays, 10, test.allocated, now)
+		if delay != test.delay || ok != test.ok {
+			t.Errorf("allocated %d: got %v %t, want %v %t", test.allocated, delay, ok, test.delay, test.ok)
+		}
+	}
+	// overnight window from 20:00 to 02:00, 6 a day is a slot an hour
+	if delay, ok := PacingDelay("20:00", "02:00", "Europe/London", allDays, 6, 0, now); delay != 10*time.Hour || !ok {
+		t.Errorf("before the overnight window: got %v %t, want 10h true", delay, ok)
+	}
+	late := time.Date(2021, 6, 1, 23, 0, 0, 0, loc)
+	if delay, ok := PacingDelay("20:00", "02:00", "Europe/London", allDays, 6, 4, late); delay != time.Hour || !ok {
+		t.Errorf("in the overnight window: got %v %t, want 1h true", delay, ok)
+	}
+	early := time.Date(2021, 6, 2, 1, 0, 0, 0, loc)
+	if delay, ok := PacingDelay("20:00", "02:00", "Europe/London", allDays, 6, 5, early); delay != 0 || !ok {
+		t.Errorf("after midnight in the overnight window: got %v %t, want 0 true", delay, ok)
+	}
+	// Tuesday is not enabled so deferred to the start of the window on Wednesday, nothing allocated yet
+	days := allDays
+	days[time.Tuesday] = false
+	if delay, ok := PacingDelay("08:00", "18:00", "Europe/London", days, 10, 3, now); delay != 22*time.Hour || !ok {
+		t.Errorf("day not enabled: got %v %t, want 22h true", delay, ok)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	loc, _ := time.LoadLocation("Europe/London")
+	tests := []struct {
+		start, end string
+		now        time.Time
+		from, to   time.Time
+	}{
+		{"08:00", "18:00", time.Date(2021, 6, 1, 10, 0, 0, 0, loc), time.Date(2021, 6, 1, 8, 0, 0, 0, loc), time.Date(2021, 6, 1, 18, 0, 0, 0, loc)},
+		{"08:00", "18:00", time.Date(2021, 6, 1, 19, 0, 0, 0, loc), time.Date(2021, 6, 2, 8, 0, 0, 0, loc), time.Date(2021, 6, 2, 18, 0, 0, 0, loc)},
+		{"20:00", "02:00", time.Date(2021, 6, 1, 23, 0, 0, 0, loc), time.Date(2021, 6, 1, 20, 0, 0, 0, loc), time.Date(2021, 6, 2, 2, 0, 0, 0, loc)},
+		{"20:00", "02:00", time.Date(2021, 6, 2, 1, 0, 0, 0, loc), time.Date(2021, 6, 1, 20, 0, 0, 0, loc), time.Date(2021, 6, 2, 2, 0, 0, 0, loc)},
+		{"20:00", "02:00", time.Date(2021, 6, 2, 10, 0, 0, 0, loc), time.Date(2021, 6, 2, 20, 0, 0, 0, loc), time.Date(2021, 6, 3, 2, 0, 0, 0, loc)},
+	}
+	for _, test := range tests {
+		from, to, err := Window(test.start, test.end, test.now)
+		if err != nil || !from.Equal(test.from) || !to.Equal(test.to) {
+			t.Errorf("%s to %s at %v: got %v to %v (%v), want %v to %v", test.start, test.end, test.now, from, to, err, test.from, test.to)
+		}
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	loc, _ := time.LoadLocation("Europe/London")
+	// Tuesday after the window, Wednesday is not enabled so the next window is on Thursday
+	days := [7]bool{true, true, true, false, true, true, true}
+	from := time.Date(2021, 6, 1, 19, 0, 0, 0, loc)
+	start, end, ok := NextWindow("08:00", "18:00", days, from)
+	if !ok || !start.Equal(time.Date(2021, 6, 3, 8, 0, 0, 0, loc)) || !end.Equal(time.Date(2021, 6, 3, 18, 0, 0, 0, loc)) {
+		t.Errorf("got %v to %v %t, want Thursday 08:00 to 18:00", start, end, ok)
+	}
+	if _, _, ok := NextWindow("08:00", "18:00", [7]bool{}, from); ok {
+		t.Error("no window should be found when no day is enabled")
+	}
+	if _, _, ok := NextWindow("8am", "18:00", days, from); ok {
+		t.Error("no window should be found when the times are not valid")
+	}
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"math/rand"
+)
+
+// WeightedChoice - choose an index at random where each index is chosen in proportion to its weight,
+// returns -1 if there is nothing to choose from (no weights or all the weights are 0)
+func WeightedChoice(weights []uint) int {
+	total := 0
+	for _, w := range weights {
+		total += int(w)
+	}
+	if total == 0 {
+		return -1
+	}
+	r := rand.Intn(total)
+	for i, w := range weights {
+		if r < int(w) {
+			return i
+		}
+		r -= int(w)
+	}
+	return -1
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestWeightedChoice(t *testing.T) {
+	if i := WeightedChoice(nil); i != -1 {
+		t.Errorf("WeightedChoice(nil) = %d expected -1", i)
+	}
+	if i := WeightedChoice([]uint{0, 0}); i != -1 {
+		t.Errorf("WeightedChoice([0 0]) = %d expected -1", i)
+	}
+	if i := WeightedChoice([]uint{0, 5, 0}); i != 1 {
+		t.Errorf("WeightedChoice([0 5 0]) = %d expected 1", i)
+	}
+	// allocation should roughly follow the weights
+	counts := make([]int, 2)
+	for n := 0; n < 10000; n++ {
+		counts[WeightedChoice([]uint{1, 3})]++
+	}
+	if counts[0] < 2000 || counts[0] > 3000 {
+		t.Errorf("WeightedChoice([1 3]) chose index 0 %d times out of 10000 expected about 2500", counts[0])
+	}
+}
@@ -60,6 +60,30 @@ func Send(sendURL string, method string, params url.Values) string {
 		req.Header.Set("Content-Type", "text/plain")
 	}
 
+	return do(req)
+}
+
+// SendJSON - send HTTP POST request with the JSON payload, the params are sent in the query string
+func SendJSON(sendURL string, params url.Values, payload []byte) string {
+	baseURL, err := url.Parse(sendURL)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	if params != nil {
+		baseURL.RawQuery = params.Encode()
+	}
+	req, err := http.NewRequest("POST", baseURL.String(), bytes.NewReader(payload))
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(req)
+}
+
+// do - send the request returning the response body
+func do(req *http.Request) string {
 	if httpClient == nil {
 		httpClient = createHTTPClient()
 	}
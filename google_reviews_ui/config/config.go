@@ -3,6 +3,8 @@ package config
 import (
 	"encoding/json"
 	"log"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -42,6 +44,15 @@ type Config struct {
 	LogServers []LogServer
 
 	GoogleMyBusinessDirectory string
+
+	BarredGlobalPartnerIDs []int
+
+	FatigueGlobalPartnerIDs []int
+
+	TelephoneHashKey         string
+	DataProtectionPartnerIDs []int
+
+	SecretsKeyFile string
 }
 
 // User - user
@@ -123,4 +134,40 @@ func ReadProperties() {
 	Conf.LogServers = logServers
 
 	Conf.GoogleMyBusinessDirectory = viper.GetString("google_my_business_directory")
+
+	// partners whose users can manage the telephones barred for all clients (comma separated partner IDs),
+	// users of other partners can only manage the barred telephones of their clients
+	Conf.BarredGlobalPartnerIDs = partnerIDs("barred_global_partner_ids", "barred global")
+
+	// partners whose users can manage the fatigue policy for all clients (comma separated partner IDs), users of other
+	// partners can only manage the fatigue policy of their clients
+	Conf.FatigueGlobalPartnerIDs = partnerIDs("fatigue_global_partner_ids", "fatigue global")
+
+	// data protection, the telephones are looked up as a keyed hash with the telephone hash key (the same key as
+	// google_reviews), users of the data protection partners (comma separated partner IDs) can find and erase the
+	// records of a telephone for all clients (subject access and erasure requests)
+	Conf.TelephoneHashKey = viper.GetString("telephone_hash_key")
+	Conf.DataProtectionPartnerIDs = partnerIDs("data_protection_partner_ids", "data protection")
+
+	// secrets of the configs (e.g. dispatcher app key and secret key) are stored encrypted with a data key wrapped by
+	// the key in the secrets key file, it has to be the same key file as google_reviews. The secrets are write only,
+	// they are not returned to the front end.
+	Conf.SecretsKeyFile = viper.GetString("secrets_key_file")
+}
+
+// partnerIDs - the comma separated partner IDs of the property
+func partnerIDs(key string, name string) []int {
+	var ids []int
+	for _, id := range strings.Split(viper.GetString(key), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		partnerID, err := strconv.Atoi(id)
+		if err != nil {
+			log.Printf("Error, %s partner ID %s is not a number\n", name, id)
+			continue
+		}
+		ids = append(ids, partnerID)
+	}
+	return ids
 }
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxBarredTelephoneLength - maximum length of a barred telephone prefix (or full number)
+const maxBarredTelephoneLength = 20
+
+// BarredTelephone - represents a barred telephone prefix, or a full number when full number, for all clients
+// (a client id of 0) or a client. The reason and who added it are recorded.
+type BarredTelephone struct {
+	ID         uint64    `json:"id"`          // id
+	ClientID   uint64    `json:"client_id"`   // client id (0 for all clients)
+	Telephone  string    `json:"telephone"`   // telephone prefix (or full number) including the country code e.g. 447418
+	FullNumber bool      `json:"full_number"` // only bar the telephone when it matches the full number
+	Reason     string    `json:"reason"`      // why the telephone is barred
+	CreatedBy  string    `json:"created_by"`  // user that added the barred telephone
+	Created    time.Time `json:"created"`     // created
+}
+
+// normaliseBarredTelephone - the telephone in E.164 format without the + e.g. +44 7418 => 447418
+func normaliseBarredTelephone(telephone string) (string, error) {
+	tel := strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, telephone)
+	tel = strings.TrimPrefix(tel, "00")
+	if tel == "" {
+		return "", errors.New("telephone is required")
+	}
+	if strings.HasPrefix(tel, "0") {
+		return "", errors.New("telephone must include the country code e.g. 447418 not 07418")
+	}
+	if len(tel) > maxBarredTelephoneLength {
+		return "", errors.New("telephone is too long (maximum 20 digits)")
+	}
+	return tel, nil
+}
+
+// clientForPartner - check the client is for the partner
+func clientForPartner(clientID uint64, partnerID int) bool {
+	const qry = "SELECT COUNT(id) FROM clients WHERE id = ? AND partner_id = ?"
+	count := 0
+	if err := Db.QueryRow(qry, clientID, partnerID).Scan(&count); err != nil {
+		log.Printf("Error checking client ID: %d for partner ID: %d, err: %v\n", clientID, partnerID, err)
+		return false
+	}
+	return count > 0
+}
+
+// BarredTelephones - get the barred telephones for all clients and for the clients of the partner
+func BarredTelephones(partnerID int) ([]BarredTelephone, error) {
+	const qry = "SELECT b.id, b.client_id, b.telephone, b.full_number, b.reason, b.created_by, b.created" +
+		" FROM google_reviews_barred_telephones AS b" +
+		" LEFT JOIN clients AS c ON c.id = b.client_id" +
+		" WHERE b.client_id = 0 OR c.partner_id = ?" +
+		" ORDER BY b.client_id, b.telephone"
+	barredTelephones := make([]BarredTelephone, 0)
+	rows, err := Db.Query(qry, partnerID)
+	if err != nil {
+		log.Println(err)
+		return barredTelephones, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bt BarredTelephone
+		if err := rows.Scan(&bt.ID, &bt.ClientID, &bt.Telephone, &bt.FullNumber, &bt.Reason, &bt.CreatedBy, &bt.Created); err != nil {
+			log.Printf("Error getting barred telephones: %v\n", err)
+			return barredTelephones, err
+		}
+		barredTelephones = append(barredTelephones, bt)
+	}
+	return barredTelephones, nil
+}
+
+// AddBarredTelephone - add a barred telephone for a client of the partner, or for all clients when the partner can
+// manage the global barred telephones, returns the id of the barred telephone
+func AddBarredTelephone(barredTelephone BarredTelephone, partnerID int, manageGlobal bool) (uint64, error) {
+	const qry = "INSERT INTO google_reviews_barred_telephones" +
+		" (client_id, telephone, full_number, reason, created_by)" +
+		" VALUES (?, ?, ?, ?, ?)"
+
+	tel, err := normaliseBarredTelephone(barredTelephone.Telephone)
+	if err != nil {
+		return 0, err
+	}
+	reason := strings.TrimSpace(barredTelephone.Reason)
+	if reason == "" {
+		return 0, errors.New("reason is required")
+	}
+	if len(reason) > 255 {
+		return 0, errors.New("reason is too long (maximum 255 characters)")
+	}
+	if barredTelephone.ClientID == 0 && !manageGlobal {
+		return 0, errors.New("not allowed to bar telephones for all clients")
+	}
+	if barredTelephone.ClientID != 0 && !clientForPartner(barredTelephone.ClientID, partnerID) {
+		return 0, errors.New("Client cannot be found")
+	}
+
+	res, err := Db.Exec(qry, barredTelephone.ClientID, tel, barredTelephone.FullNumber, reason, barredTelephone.CreatedBy)
+	if err != nil {
+		log.Printf("Error adding barred telephone: %s for client ID: %d, err: %v\n", tel, barredTelephone.ClientID, err)
+		return 0, errors.New("unable to add the barred telephone (it may already be barred)")
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return uint64(id), nil
+}
+
+// DeleteBarredTelephone - delete a barred telephone of a client of the partner, or for all clients when the partner
+// can manage the global barred telephones
+func DeleteBarredTelephone(id int, partnerID int, manageGlobal bool) error {
+	const selectQry = "SELECT client_id FROM google_reviews_barred_telephones WHERE id = ?"
+	const deleteQry = "DELETE FROM google_reviews_barred_telephones WHERE id = ?"
+
+	var clientID uint64
+	if err := Db.QueryRow(selectQry, id).Scan(&clientID); err != nil {
+		log.Printf("Error getting barred telephone ID: %d, err: %v\n", id, err)
+		return errors.New("Barred telephone cannot be found")
+	}
+	if clientID == 0 && !manageGlobal {
+		return errors.New("not allowed to remove barred telephones for all clients")
+	}
+	if clientID != 0 && !clientForPartner(clientID, partnerID) {
+		return errors.New("Barred telephone cannot be found")
+	}
+	if _, err := Db.Exec(deleteQry, id); err != nil {
+		log.Printf("delete failed: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestNormaliseBarredTelephone(t *testing.T) {
+	tests := []struct {
+		telephone string
+		want      string
+		valid     bool
+	}{
+		{"+44 7418", "447418", true},
+		{"0044 7123 456789", "447123456789", true},
+		{"07418", "", false},
+		{"", "", false},
+		{"+441234567890123456789", "", false},
+	}
+	for _, tt := range tests {
+		tel, err := normaliseBarredTelephone(tt.telephone)
+		if (err == nil) != tt.valid || tel != tt.want {
+			t.Errorf("telephone: %s normalised: %s, err: %v want: %s", tt.telephone, tel, err, tt.want)
+		}
+	}
+}
+
+func TestBarredTelephones(t *testing.T) {
+	prepareTestDatabase()
+	// all clients and the clients of partner 1 (client 3 is partner 2)
+	bts, err := BarredTelephones(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bts) != 2 || bts[0].ClientID != 0 || bts[0].Telephone != "447418" || bts[0].Reason != "Premium rate numbers" ||
+		bts[1].ClientID != 1 || !bts[1].FullNumber || bts[1].CreatedBy != "test@testing.com" {
+		t.Fatalf("unexpected barred telephones: %+v", bts)
+	}
+}
+
+func TestAddBarredTelephone(t *testing.T) {
+	prepareTestDatabase()
+	bt := BarredTelephone{ClientID: 2, Telephone: "+44 7123 456702", FullNumber: true, Reason: "Asked not to be contacted", CreatedBy: "test@testing.com"}
+	id, err := AddBarredTelephone(bt, 1, false)
+	if err != nil || id == 0 {
+		t.Fatalf("Error adding barred telephone, id: %d, err: %v", id, err)
+	}
+	bts, _ := BarredTelephones(1)
+	if len(bts) != 3 || bts[2].ID != id || bts[2].Telephone != "447123456702" || bts[2].CreatedBy != "test@testing.com" {
+		t.Fatalf("unexpected barred telephones: %+v", bts)
+	}
+
+	// already barred
+	if _, err := AddBarredTelephone(bt, 1, false); err == nil {
+		t.Error("expected an error adding a barred telephone twice")
+	}
+	// client of another partner
+	bt.ClientID = 3
+	if _, err := AddBarredTelephone(bt, 1, false); err == nil {
+		t.Error("expected an error adding a barred telephone for a client of another partner")
+	}
+	// all clients
+	bt.ClientID = 0
+	if _, err := AddBarredTelephone(bt, 1, false); err == nil {
+		t.Error("expected an error adding a barred telephone for all clients without managing the global barred telephones")
+	}
+	if _, err := AddBarredTelephone(bt, 1, true); err != nil {
+		t.Errorf("Error adding barred telephone for all clients, err: %v", err)
+	}
+	// no reason
+	bt.ClientID, bt.Reason = 2, " "
+	if _, err := AddBarredTelephone(bt, 1, false); err == nil {
+		t.Error("expected an error adding a barred telephone without a reason")
+	}
+}
+
+func TestDeleteBarredTelephone(t *testing.T) {
+	prepareTestDatabase()
+	// client of another partner
+	if err := DeleteBarredTelephone(3, 1, true); err == nil {
+		t.Error("expected an error deleting a barred telephone for a client of another partner")
+	}
+	// all clients
+	if err := DeleteBarredTelephone(1, 1, false); err == nil {
+		t.Error("expected an error deleting a barred telephone for all clients without managing the global barred telephones")
+	}
+	if err := DeleteBarredTelephone(2, 1, false); err != nil {
+		t.Fatalf("Error deleting barred telephone, err: %v", err)
+	}
+	if bts, _ := BarredTelephones(1); len(bts) != 1 || bts[0].ID != 1 {
+		t.Fatalf("unexpected barred telephones: %+v", bts)
+	}
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// languageRegexp - an ISO 639 language code e.g. nl
+var languageRegexp = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// ConfigMessage - represents the message of a config for a language, the message of the booking language (the booking
+// locale when the dispatcher sends one, else the country of the telephone) replaces the config message.
+type ConfigMessage struct {
+	Language string `json:"language"` // language e.g. nl
+	Message  string `json:"message"`  // message (split by the multi message separator when multi message is enabled)
+}
+
+// ConfigMessages - represents the messages by language of a config.
+type ConfigMessages struct {
+	GoogleReviewsConfigID uint64          `json:"google_reviews_config_id"` // google reviews config id
+	Messages              []ConfigMessage `json:"messages"`                 // messages
+}
+
+// MessagePreview - represents a message that can be sent for a language filled in with sample values.
+type MessagePreview struct {
+	Language string `json:"language"` // language of the message, empty for the config message
+	Variant  string `json:"variant"`  // variant name (or position of the multi message) recorded when sent
+	Message  string `json:"message"`  // message filled in with sample values
+}
+
+// normaliseLanguage - the language code in lower case without spaces
+func normaliseLanguage(language string) string {
+	return strings.ToLower(strings.TrimSpace(language))
+}
+
+// validateLanguage - check the language is an ISO 639 language code e.g. nl
+func validateLanguage(language string) error {
+	if !languageRegexp.MatchString(language) {
+		return fmt.Errorf("language %s must be a 2 or 3 letter ISO 639 code e.g. nl", language)
+	}
+	return nil
+}
+
+// GetConfigMessages - get the messages by language of a config
+func GetConfigMessages(configID int, partnerID int) (ConfigMessages, error) {
+	const qry = "SELECT language, message" +
+		" FROM google_reviews_config_messages" +
+		" WHERE google_reviews_config_id = ?" +
+		" ORDER BY language"
+	cms := ConfigMessages{GoogleReviewsConfigID: uint64(configID)}
+	if _, err := configClientID(configID, partnerID); err != nil {
+		return cms, err
+	}
+	rows, err := Db.Query(qry, configID)
+	if err != nil {
+		log.Println(err)
+		return cms, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cm ConfigMessage
+		if err := rows.Scan(&cm.Language, &cm.Message); err != nil {
+			log.Printf("Error getting config messages: %v\n", err)
+			return cms, err
+		}
+		cms.Messages = append(cms.Messages, cm)
+	}
+	return cms, nil
+}
+
+// validateConfigMessages - check the messages have unique valid languages and valid messages
+func validateConfigMessages(messages []ConfigMessage) error {
+	languages := make(map[string]bool, len(messages))
+	for _, cm := range messages {
+		language := normaliseLanguage(cm.Language)
+		if err := validateLanguage(language); err != nil {
+			return err
+		}
+		if languages[language] {
+			return fmt.Errorf("language %s has more than one message", language)
+		}
+		languages[language] = true
+		if strings.TrimSpace(cm.Message) == "" {
+			return fmt.Errorf("language %s has no message", language)
+		}
+		if err := validateMessageTemplate(cm.Message); err != nil {
+			return fmt.Errorf("language %s: %v", language, err)
+		}
+	}
+	return nil
+}
+
+// UpdateConfigMessages - replace the messages by language of a config
+func UpdateConfigMessages(configMessages ConfigMessages, partnerID int) error {
+	const deleteQry = "DELETE FROM google_reviews_config_messages WHERE google_reviews_config_id = ?"
+	const insertQry = "INSERT INTO google_reviews_config_messages" +
+		" (google_reviews_config_id, language, message)" +
+		" VALUES (?, ?, ?)"
+
+	configID := configMessages.GoogleReviewsConfigID
+	if _, err := configClientID(int(configID), partnerID); err != nil {
+		return err
+	}
+	if err := validateConfigMessages(configMessages.Messages); err != nil {
+		return err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if _, execErr := tx.Exec(deleteQry, configID); execErr != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("update failed: %v, unable to rollback: %v\n", execErr, rollbackErr)
+			return execErr
+		}
+		log.Printf("update failed: %v", execErr)
+		return execErr
+	}
+	for _, cm := range configMessages.Messages {
+		_, execErr := tx.Exec(insertQry, configID, normaliseLanguage(cm.Language), strings.TrimSpace(cm.Message))
+		if execErr != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Printf("update failed: %v, unable to rollback: %v\n", execErr, rollbackErr)
+				return execErr
+			}
+			log.Printf("update failed: %v", execErr)
+			return execErr
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// PreviewMessages - the messages that can be sent for a language (the config message when the config has no message
+// for the language) filled in with sample values: the enabled message variants of the language when A/B testing, else
+// each message of a multi message, else the message
+// NOTE: keep in line with the message selection in google_reviews
+func PreviewMessages(configID int, language string, partnerID int) ([]MessagePreview, error) {
+	const configQry = "SELECT message, multi_message_enabled, multi_message_separator, review_link, opt_out_link" +
+		" FROM google_reviews_configs" +
+		" WHERE id = ?"
+	const messageQry = "SELECT message" +
+		" FROM google_reviews_config_messages" +
+		" WHERE google_reviews_config_id = ? AND language = ?"
+	const variantsQry = "SELECT name, message" +
+		" FROM google_reviews_message_variants" +
+		" WHERE google_reviews_config_id = ? AND language = ? AND enabled = 1" +
+		" ORDER BY id"
+
+	if _, err := configClientID(configID, partnerID); err != nil {
+		return nil, err
+	}
+	var message, separator, reviewLink, optOutLink string
+	var multiMessageEnabled bool
+	if err := Db.QueryRow(configQry, configID).Scan(&message, &multiMessageEnabled, &separator, &reviewLink, &optOutLink); err != nil {
+		log.Printf("Error getting config ID: %d to preview messages, err: %v\n", configID, err)
+		return nil, err
+	}
+	values := messagePreviewValues(reviewLink, optOutLink)
+
+	language = normaliseLanguage(language)
+	if language != "" {
+		err := Db.QueryRow(messageQry, configID, language).Scan(&message)
+		if errors.Is(err, sql.ErrNoRows) {
+			language = ""
+		} else if err != nil {
+			log.Printf("Error getting the %s message of config ID: %d, err: %v\n", language, configID, err)
+			return nil, err
+		}
+	}
+
+	var previews []MessagePreview
+	rows, err := Db.Query(variantsQry, configID, language)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, variantMessage string
+		if err := rows.Scan(&name, &variantMessage); err != nil {
+			log.Printf("Error getting message variants to preview: %v\n", err)
+			return nil, err
+		}
+		previews = append(previews, MessagePreview{Language: language, Variant: name, Message: previewMessageTemplate(variantMessage, values)})
+	}
+	if len(previews) > 0 {
+		return previews, nil
+	}
+
+	sep := strings.TrimSpace(separator)
+	if !multiMessageEnabled || sep == "" {
+		return []MessagePreview{{Language: language, Message: previewMessageTemplate(message, values)}}, nil
+	}
+	for i, m := range strings.Split(message, sep) {
+		variant := strconv.Itoa(i + 1)
+		if language != "" {
+			variant = language + ":" + variant
+		}
+		previews = append(previews, MessagePreview{Language: language, Variant: variant, Message: previewMessageTemplate(m, values)})
+	}
+	return previews, nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestValidateConfigMessages(t *testing.T) {
+	valid := []ConfigMessage{{Language: "nl", Message: "Beoordeel ons {review_link}"}, {Language: "FR", Message: "Merci {first_name|}"}}
+	if err := validateConfigMessages(valid); err != nil {
+		t.Fatal("unexpected error for valid config messages, err: ", err)
+	}
+	invalid := [][]ConfigMessage{
+		append(valid, ConfigMessage{Language: "fr ", Message: "Again"}),
+		{{Language: "", Message: "No language"}},
+		{{Language: "nl-NL", Message: "Region"}},
+		{{Language: "de", Message: " "}},
+		{{Language: "de", Message: "Hallo {name}"}},
+	}
+	for _, messages := range invalid {
+		if err := validateConfigMessages(messages); err == nil {
+			t.Errorf("expected error for config messages: %+v", messages)
+		}
+	}
+}
+
+func TestUpdateConfigMessages(t *testing.T) {
+	prepareTestDatabase()
+	// config 4 is for partner 2
+	if _, err := GetConfigMessages(4, 1); err == nil {
+		t.Fatal("expected error for a config of another partner")
+	}
+	cms, err := GetConfigMessages(4, 2)
+	if err != nil {
+		t.Fatal("error getting config messages, err: ", err)
+	}
+	if len(cms.Messages) != 1 || cms.Messages[0].Language != "fr" {
+		t.Fatalf("unexpected config messages: %+v", cms)
+	}
+	cms.Messages = []ConfigMessage{{Language: " NL", Message: "Beoordeel uw rit {review_link} "}, {Language: "de", Message: "Bewerten Sie uns {review_link}"}}
+	if err := UpdateConfigMessages(cms, 2); err != nil {
+		t.Fatal("error updating config messages, err: ", err)
+	}
+	if cms, _ = GetConfigMessages(4, 2); len(cms.Messages) != 2 || cms.Messages[0].Language != "de" ||
+		cms.Messages[1].Language != "nl" || cms.Messages[1].Message != "Beoordeel uw rit {review_link}" {
+		t.Fatalf("unexpected config messages after update: %+v", cms)
+	}
+}
+
+func TestPreviewMessages(t *testing.T) {
+	prepareTestDatabase()
+	if _, err := PreviewMessages(4, "fr", 1); err == nil {
+		t.Fatal("expected error for a config of another partner")
+	}
+	previews, err := PreviewMessages(4, "fr", 2)
+	if err != nil {
+		t.Fatal("error previewing messages, err: ", err)
+	}
+	if len(previews) != 1 || previews[0].Language != "fr" || previews[0].Variant != "" ||
+		previews[0].Message != "Bonjour Alex, merci de noter votre trajet https://g.page/r/example/review" {
+		t.Fatalf("unexpected message previews for fr: %+v", previews)
+	}
+	// there is no Dutch message so the config message variants are previewed
+	previews, err = PreviewMessages(4, "nl", 2)
+	if err != nil {
+		t.Fatal("error previewing messages, err: ", err)
+	}
+	if len(previews) != 2 || previews[0].Language != "" || previews[0].Variant != "short" || previews[1].Variant != "friendly" {
+		t.Fatalf("unexpected message previews for nl: %+v", previews)
+	}
+}
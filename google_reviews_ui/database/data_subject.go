@@ -0,0 +1,208 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// DataSubject - represents the records of a telephone for all clients (subject access request)
+type DataSubject struct {
+	LastSents        []DataSubjectLastSent  `json:"last_sents"`        // last sents (stops are kept as a hash on erasure)
+	GlobalStop       bool                   `json:"global_stop"`       // opted out for all clients
+	SendLaters       []DataSubjectSendLater `json:"send_laters"`       // messages waiting to be sent
+	BarredTelephones []BarredTelephone      `json:"barred_telephones"` // barred full numbers (removed via /auth/barred)
+	MessageEvents    []MessageEvent         `json:"message_events"`    // message events
+}
+
+// DataSubjectLastSent - represents the last sent of a telephone for a client
+type DataSubjectLastSent struct {
+	ClientID   uint64    `json:"client_id"`   // client id
+	ClientName string    `json:"client_name"` // client name
+	LastSent   time.Time `json:"last_sent"`   // last sent
+	SentCount  uint64    `json:"sent_count"`  // sent count
+	Stop       bool      `json:"stop"`        // opted out for the client
+}
+
+// DataSubjectSendLater - represents a message of a telephone waiting to be sent for a client
+type DataSubjectSendLater struct {
+	ID         uint64    `json:"id"`          // id
+	ClientID   uint64    `json:"client_id"`   // client id
+	ClientName string    `json:"client_name"` // client name
+	SendAfter  time.Time `json:"send_after"`  // send after
+}
+
+// DataSubjectErasure - represents the number of records of a telephone deleted or pseudonymised (erasure request)
+type DataSubjectErasure struct {
+	LastSentsDeleted           int64 `json:"last_sents_deleted"`           // last sents deleted
+	StopsPseudonymised         int64 `json:"stops_pseudonymised"`          // stops kept as a hash so the opt out is kept
+	GlobalStopsPseudonymised   int64 `json:"global_stops_pseudonymised"`   // global stops kept as a hash
+	SendLatersDeleted          int64 `json:"send_laters_deleted"`          // send laters deleted
+	MessageEventsPseudonymised int64 `json:"message_events_pseudonymised"` // message events without the telephone hash
+}
+
+// FindDataSubject - get the records of the telephone (international format e.g. +447123456789) for all clients, the
+// last sents and global stop stored before the telephone hash are also found
+func FindDataSubject(telephone string) (DataSubject, error) {
+	var ds DataSubject
+	tel := normaliseTelephone(telephone)
+	if tel == "" {
+		return ds, errors.New("telephone is required")
+	}
+	telephoneHash := hashTelephone(telephone)
+
+	const lastSentsQry = "SELECT l.client_id, IFNULL(c.name, ''), l.last_sent, l.sent_count, l.stop" +
+		" FROM google_reviews_last_sents AS l" +
+		" LEFT JOIN clients AS c ON c.id = l.client_id" +
+		" WHERE l.telephone_hash = ? OR (l.telephone_hash IS NULL AND l.telephone = ?)" +
+		" ORDER BY l.client_id"
+	rows, err := Db.Query(lastSentsQry, telephoneHash, tel)
+	if err != nil {
+		log.Println(err)
+		return ds, err
+	}
+	for rows.Next() {
+		var l DataSubjectLastSent
+		if err := rows.Scan(&l.ClientID, &l.ClientName, &l.LastSent, &l.SentCount, &l.Stop); err != nil {
+			log.Printf("Error getting the last sents of the data subject: %v\n", err)
+			continue
+		}
+		ds.LastSents = append(ds.LastSents, l)
+	}
+	rows.Close()
+
+	const globalStopQry = "SELECT COUNT(*) FROM google_reviews_global_stops" +
+		" WHERE telephone_hash = ? OR (telephone_hash IS NULL AND telephone = ?)"
+	count := 0
+	if err := Db.QueryRow(globalStopQry, telephoneHash, tel).Scan(&count); err != nil {
+		log.Println(err)
+		return ds, err
+	}
+	ds.GlobalStop = count > 0
+
+	const sendLatersQry = "SELECT s.id, s.client_id, IFNULL(c.name, ''), s.send_after" +
+		" FROM google_reviews_send_laters AS s" +
+		" LEFT JOIN clients AS c ON c.id = s.client_id" +
+		" WHERE s.telephone = ?" +
+		" ORDER BY s.send_after"
+	rows, err = Db.Query(sendLatersQry, tel)
+	if err != nil {
+		log.Println(err)
+		return ds, err
+	}
+	for rows.Next() {
+		var s DataSubjectSendLater
+		if err := rows.Scan(&s.ID, &s.ClientID, &s.ClientName, &s.SendAfter); err != nil {
+			log.Printf("Error getting the send laters of the data subject: %v\n", err)
+			continue
+		}
+		ds.SendLaters = append(ds.SendLaters, s)
+	}
+	rows.Close()
+
+	const barredQry = "SELECT id, client_id, telephone, full_number, reason, created_by, created" +
+		" FROM google_reviews_barred_telephones" +
+		" WHERE full_number = 1 AND telephone = ?" +
+		" ORDER BY client_id"
+	rows, err = Db.Query(barredQry, tel)
+	if err != nil {
+		log.Println(err)
+		return ds, err
+	}
+	for rows.Next() {
+		var bt BarredTelephone
+		if err := rows.Scan(&bt.ID, &bt.ClientID, &bt.Telephone, &bt.FullNumber, &bt.Reason, &bt.CreatedBy, &bt.Created); err != nil {
+			log.Printf("Error getting the barred telephones of the data subject: %v\n", err)
+			continue
+		}
+		ds.BarredTelephones = append(ds.BarredTelephones, bt)
+	}
+	rows.Close()
+
+	// the message events not yet rehashed with the telephone hash key are also found
+	const messageEventsQry = "SELECT e.id, e.client_id, IFNULL(c.name, ''), e.channel, e.reason, e.provider_response," +
+		" e.latency_ms, e.created" +
+		" FROM google_reviews_message_events AS e" +
+		" LEFT JOIN clients AS c ON c.id = e.client_id" +
+		" WHERE e.telephone_hash IN (?, ?)" +
+		" ORDER BY e.created DESC, e.id DESC"
+	rows, err = Db.Query(messageEventsQry, telephoneHash, unkeyedHashTelephone(telephone))
+	if err != nil {
+		log.Println(err)
+		return ds, err
+	}
+	for rows.Next() {
+		var e MessageEvent
+		if err := rows.Scan(&e.ID, &e.ClientID, &e.ClientName, &e.Channel, &e.Reason, &e.ProviderResponse, &e.LatencyMs, &e.Created); err != nil {
+			log.Printf("Error getting the message events of the data subject: %v\n", err)
+			continue
+		}
+		ds.MessageEvents = append(ds.MessageEvents, e)
+	}
+	rows.Close()
+	return ds, nil
+}
+
+// EraseDataSubject - erase the records of the telephone (international format e.g. +447123456789) for all clients.
+// The last sents (other than stops) and send laters are deleted, the stops and global stop are kept as the telephone
+// hash only so the opt out is kept, and the message events are pseudonymised (the telephone hash and provider
+// response are removed so the counts are kept). Barred full numbers are not changed, they are removed via /auth/barred.
+func EraseDataSubject(telephone string) (DataSubjectErasure, error) {
+	var e DataSubjectErasure
+	tel := normaliseTelephone(telephone)
+	if tel == "" {
+		return e, errors.New("telephone is required")
+	}
+	telephoneHash := hashTelephone(telephone)
+
+	tx, err := Db.Begin()
+	if err != nil {
+		log.Println(err)
+		return e, err
+	}
+
+	type erasure struct {
+		qry   string
+		args  []interface{}
+		count *int64
+	}
+	// a stop stored before the telephone hash that is also stored hashed (not updated) is deleted
+	erasures := []erasure{
+		{"DELETE FROM google_reviews_last_sents" +
+			" WHERE stop = 0 AND (telephone_hash = ? OR (telephone_hash IS NULL AND telephone = ?))",
+			[]interface{}{telephoneHash, tel}, &e.LastSentsDeleted},
+		{"UPDATE IGNORE google_reviews_last_sents SET telephone_hash = ?, telephone = NULL" +
+			" WHERE telephone_hash IS NULL AND telephone = ?",
+			[]interface{}{telephoneHash, tel}, &e.StopsPseudonymised},
+		{"DELETE FROM google_reviews_last_sents WHERE telephone_hash IS NULL AND telephone = ?",
+			[]interface{}{tel}, nil},
+		{"UPDATE IGNORE google_reviews_global_stops SET telephone_hash = ?, telephone = NULL" +
+			" WHERE telephone_hash IS NULL AND telephone = ?",
+			[]interface{}{telephoneHash, tel}, &e.GlobalStopsPseudonymised},
+		{"DELETE FROM google_reviews_global_stops WHERE telephone_hash IS NULL AND telephone = ?",
+			[]interface{}{tel}, nil},
+		{"DELETE FROM google_reviews_send_laters WHERE telephone = ?",
+			[]interface{}{tel}, &e.SendLatersDeleted},
+		{"UPDATE google_reviews_message_events SET telephone_hash = '', provider_response = ''" +
+			" WHERE telephone_hash IN (?, ?)",
+			[]interface{}{telephoneHash, unkeyedHashTelephone(telephone)}, &e.MessageEventsPseudonymised},
+	}
+	for _, er := range erasures {
+		res, execErr := tx.Exec(er.qry, er.args...)
+		if execErr != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Printf("erase failed: %v, unable to rollback: %v\n", execErr, rollbackErr)
+			}
+			return DataSubjectErasure{}, execErr
+		}
+		if er.count != nil {
+			*er.count, _ = res.RowsAffected()
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		return DataSubjectErasure{}, err
+	}
+	return e, nil
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestFindDataSubject(t *testing.T) {
+	prepareTestDatabase()
+	// the message events of client 3 (partner 2) are also found
+	ds, err := FindDataSubject("+44 7123 456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds.LastSents) != 1 || ds.LastSents[0].ClientID != 1 || ds.LastSents[0].Stop || ds.GlobalStop ||
+		len(ds.SendLaters) != 0 || len(ds.MessageEvents) != 3 {
+		t.Fatalf("unexpected data subject: %+v", ds)
+	}
+	if _, err := FindDataSubject(""); err == nil {
+		t.Fatal("expected error for empty telephone")
+	}
+}
+
+func TestEraseDataSubject(t *testing.T) {
+	prepareTestDatabase()
+	erasure, err := EraseDataSubject("+447123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erasure.LastSentsDeleted != 1 || erasure.StopsPseudonymised != 0 || erasure.MessageEventsPseudonymised != 3 {
+		t.Fatalf("unexpected erasure: %+v", erasure)
+	}
+	ds, err := FindDataSubject("+447123456789")
+	if err != nil || len(ds.LastSents) != 0 || len(ds.MessageEvents) != 0 {
+		t.Fatalf("expected no records after erasure got: %+v, err: %v", ds, err)
+	}
+
+	// the stop is kept as the telephone hash so the opt out is kept
+	erasure, err = EraseDataSubject("+447123456788")
+	if err != nil || erasure.LastSentsDeleted != 0 || erasure.StopsPseudonymised != 1 {
+		t.Fatalf("unexpected erasure: %+v, err: %v", erasure, err)
+	}
+	ds, err = FindDataSubject("+447123456788")
+	if err != nil || len(ds.LastSents) != 1 || !ds.LastSents[0].Stop {
+		t.Fatalf("expected the stop to be kept got: %+v, err: %v", ds, err)
+	}
+}
+
+func TestMaskTelephone(t *testing.T) {
+	tests := []struct {
+		telephone string
+		want      string
+	}{
+		{"447123456789", "*********789"},
+		{"4471", "****"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := MaskTelephone(tt.telephone); got != tt.want {
+			t.Errorf("telephone: %s masked: %s want: %s", tt.telephone, got, tt.want)
+		}
+	}
+}
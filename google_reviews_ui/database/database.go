@@ -1,11 +1,15 @@
 package database
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	// mysql driver
 	_ "github.com/go-sql-driver/mysql"
@@ -15,12 +19,13 @@ var Db *sql.DB
 
 // Client - represents a client.
 type Client struct {
-	ID        uint64 `json:"id"`         // id
-	Enabled   bool   `json:"enabled"`    // enabled
-	Name      string `json:"name"`       // name
-	Note      string `json:"note"`       // note
-	Country   string `json:"country"`    // country
-	PartnerID uint64 `json:"partner_id"` // partner id
+	ID            uint64 `json:"id"`                              // id
+	Enabled       bool   `json:"enabled"`                         // enabled
+	Name          string `json:"name"`                            // name
+	Note          string `json:"note"`                            // note
+	Country       string `json:"country"`                         // country
+	RetentionDays uint   `json:"retention_days,string,omitempty"` // data retention days (0 uses the default)
+	PartnerID     uint64 `json:"partner_id"`                      // partner id
 }
 
 // GoogleReviewsConfig - represents a google reviews config
@@ -30,13 +35,17 @@ type GoogleReviewsConfig struct {
 	MinSendFrequency                              uint   `json:"min_send_frequency,string,omitempty"`                    // min send frequency
 	MaxSendCount                                  uint   `json:"max_send_count,string,omitempty"`                        // max send count
 	MaxDailySendCount                             uint   `json:"max_daily_send_count,string,omitempty"`                  // max daily send count
+	PacingEnabled                                 bool   `json:"pacing_enabled"`                                         // spread the max daily send count across the config times
 	Token                                         string `json:"token"`                                                  // token
 	TelephoneParameter                            string `json:"telephone_parameter"`                                    // telephone parameter
 	SendFromIcabbiApp                             bool   `json:"send_from_icabbi_app"`                                   // send_from_icabbi_app
 	AppKey                                        string `json:"app_key"`                                                // app_key url
 	SecretKey                                     string `json:"secret_key"`                                             // secret_key url
+	AppKeySet                                     bool   `json:"app_key_set"`                                            // app_key is set (write only, not returned)
+	SecretKeySet                                  bool   `json:"secret_key_set"`                                         // secret_key is set (write only, not returned)
 	SendURL                                       string `json:"send_url"`                                               // send url
 	HttpGet                                       bool   `json:"http_get"`                                               // http get
+	TLSSkipVerify                                 bool   `json:"tls_skip_verify"`                                        // do not verify the TLS certificate of the send URL and dispatcher URL
 	SendSuccessResponse                           string `json:"send_success_response"`                                  // send success response
 	TimeZone                                      string `json:"time_zone"`                                              // time_zone
 	MultiMessageEnabled                           bool   `json:"multi_message_enabled"`                                  // multi message enabled
@@ -61,6 +70,20 @@ type GoogleReviewsConfig struct {
 	AlternateMessageServiceEnabled                bool   `json:"alternate_message_service_enabled"`                      // alternate message service enabled
 	AlternateMessageService                       string `json:"alternate_message_service"`                              // alternate message service
 	AlternateMessageServiceSecret1                string `json:"alternate_message_service_secret1"`                      // alternate message service
+	AlternateMessageServiceSecret1Set             bool   `json:"alternate_message_service_secret1_set"`                  // alternate message service secret1 is set (write only, not returned)
+	AlternateMessageServiceSender                 string `json:"alternate_message_service_sender"`                       // alternate message service sender (e.g. Twilio alphanumeric sender or messaging service SID)
+	MessageChannel                                string `json:"message_channel"`                                        // preferred message channel (SMS or WhatsApp)
+	WhatsAppPhoneNumberID                         string `json:"whatsapp_phone_number_id"`                               // WhatsApp Cloud API phone number ID
+	WhatsAppAccessToken                           string `json:"whatsapp_access_token"`                                  // WhatsApp Cloud API access token
+	WhatsAppAccessTokenSet                        bool   `json:"whatsapp_access_token_set"`                              // WhatsApp Cloud API access token is set (write only, not returned)
+	WhatsAppTemplateName                          string `json:"whatsapp_template_name"`                                 // WhatsApp approved template name
+	WhatsAppTemplateLanguage                      string `json:"whatsapp_template_language"`                             // WhatsApp template language code (e.g. en_GB)
+	WhatsAppTemplateParameters                    string `json:"whatsapp_template_parameters"`                           // WhatsApp template body parameters (comma separated e.g. {first_name},{review_link})
+	WhatsAppSMSFallback                           bool   `json:"whatsapp_sms_fallback"`                                  // send by SMS when not delivered via WhatsApp
+	EmailEnabled                                  bool   `json:"email_enabled"`                                          // email bookings without a mobile telephone
+	EmailParameter                                string `json:"email_parameter"`                                        // email address parameter
+	EmailSubject                                  string `json:"email_subject"`                                          // email subject (empty for the default subject)
+	EmailTemplate                                 string `json:"email_template"`                                         // email HTML template (empty for the default template)
 	Companies                                     string `json:"companies"`                                              // companies
 	BookingSourceMobileAppState                   int    `json:"booking_source_mobile_app_state"`                        // booking source mobile app state
 	AIResponsesEnabled                            bool   `json:"ai_responses_enabled"`                                   // AI responses enabled
@@ -83,6 +106,8 @@ type GoogleReviewsConfig struct {
 	GoogleMyBusinessFiveStarRatingReply           string `json:"google_my_business_five_star_rating_reply"`              // Google My Business five star rating reply
 	GoogleMyBusinessReportEnabled                 bool   `json:"google_my_business_report_enabled"`                      // Google My Business report enabled
 	EmailAddress                                  string `json:"email_address"`                                          // Email address used for reporting
+	ReviewLink                                    string `json:"review_link"`                                            // review link used for the {review_link} message placeholder
+	OptOutLink                                    string `json:"opt_out_link"`                                           // opt out link used for the {opt_out_link} message placeholder
 	ClientID                                      uint64 `json:"client_id"`                                              // client id
 }
 
@@ -110,18 +135,23 @@ type SimpleConfig struct {
 	ClientName                                              string `json:"client_name"`                                                                  // client name
 	ClientNote                                              string `json:"client_note"`                                                                  // client note
 	ClientCountry                                           string `json:"client_country"`                                                               // client country
+	ClientRetentionDays                                     uint   `json:"client_retention_days,string,omitempty"`                                       // client data retention days (0 uses the default)
 	GoogleReviewsConfigID                                   uint64 `json:"google_reviews_config_id"`                                                     // google reviews config id
 	GoogleReviewsConfigEnabled                              bool   `json:"google_reviews_config_enabled"`                                                // google reviews config enabled
 	GoogleReviewsConfigMinSendFrequency                     uint   `json:"google_reviews_config_min_send_frequency,string,omitempty"`                    // google reviews config min send frequency
 	GoogleReviewsConfigMaxSendCount                         uint   `json:"google_reviews_config_max_send_count,string,omitempty"`                        // google reviews config max send count
 	GoogleReviewsConfigMaxDailySendCount                    uint   `json:"google_reviews_config_max_daily_send_count,string,omitempty"`                  // google reviews config max daily send count
+	GoogleReviewsConfigPacingEnabled                        bool   `json:"google_reviews_config_pacing_enabled"`                                         // google reviews config pacing enabled
 	GoogleReviewsConfigToken                                string `json:"google_reviews_config_token"`                                                  // google reviews config token
 	GoogleReviewsConfigTelephoneParameter                   string `json:"google_reviews_config_telephone_parameter"`                                    // google reviews config telephone parameter
 	GoogleReviewsConfigSendFromIcabbiApp                    bool   `json:"google_reviews_config_send_from_icabbi_app"`                                   // google_reviews_config_send_from_icabbi_app
 	GoogleReviewsConfigAppKey                               string `json:"google_reviews_config_app_key"`                                                // google_reviews_config_app_key url
 	GoogleReviewsConfigSecretKey                            string `json:"google_reviews_config_secret_key"`                                             // google_reviews_config_secret_key url
+	GoogleReviewsConfigAppKeySet                            bool   `json:"google_reviews_config_app_key_set"`                                            // google_reviews_config_app_key is set (write only, not returned)
+	GoogleReviewsConfigSecretKeySet                         bool   `json:"google_reviews_config_secret_key_set"`                                         // google_reviews_config_secret_key is set (write only, not returned)
 	GoogleReviewsConfigSendURL                              string `json:"google_reviews_config_send_url"`                                               // google reviews config send url
 	GoogleReviewsConfigHttpGet                              bool   `json:"google_reviews_config_http_get"`                                               // google reviews config http get
+	GoogleReviewsConfigTLSSkipVerify                        bool   `json:"google_reviews_config_tls_skip_verify"`                                        // google reviews config TLS skip verify
 	GoogleReviewsConfigSendSuccessResponse                  string `json:"google_reviews_config_send_success_response"`                                  // google reviews config send success response
 	GoogleReviewsConfigTimeZone                             string `json:"google_reviews_config_time_zone"`                                              // google reviews config time zone
 	GoogleReviewsConfigMultiMessageEnabled                  bool   `json:"google_reviews_config_multi_message_enabled"`                                  // google reviews config multi message enabled
@@ -146,6 +176,20 @@ type SimpleConfig struct {
 	GoogleReviewsConfigAlternateMessageServiceEnabled       bool   `json:"google_reviews_config_alternate_message_service_enabled"`                      // google reviews config alternate message service enabled
 	GoogleReviewsConfigAlternateMessageService              string `json:"google_reviews_config_alternate_message_service"`                              // google reviews config alternate message service
 	GoogleReviewsConfigAlternateMessageServiceSecret1       string `json:"google_reviews_config_alternate_message_service_secret1"`                      // google reviews config alternate message service secret1
+	GoogleReviewsConfigAlternateMessageServiceSecret1Set    bool   `json:"google_reviews_config_alternate_message_service_secret1_set"`                  // google reviews config alternate message service secret1 is set (write only, not returned)
+	GoogleReviewsConfigAlternateMessageServiceSender        string `json:"google_reviews_config_alternate_message_service_sender"`                       // google reviews config alternate message service sender
+	GoogleReviewsConfigMessageChannel                       string `json:"google_reviews_config_message_channel"`                                        // google reviews config message channel
+	GoogleReviewsConfigWhatsAppPhoneNumberID                string `json:"google_reviews_config_whatsapp_phone_number_id"`                               // google reviews config WhatsApp phone number ID
+	GoogleReviewsConfigWhatsAppAccessToken                  string `json:"google_reviews_config_whatsapp_access_token"`                                  // google reviews config WhatsApp access token
+	GoogleReviewsConfigWhatsAppAccessTokenSet               bool   `json:"google_reviews_config_whatsapp_access_token_set"`                              // google reviews config WhatsApp access token is set (write only, not returned)
+	GoogleReviewsConfigWhatsAppTemplateName                 string `json:"google_reviews_config_whatsapp_template_name"`                                 // google reviews config WhatsApp template name
+	GoogleReviewsConfigWhatsAppTemplateLanguage             string `json:"google_reviews_config_whatsapp_template_language"`                             // google reviews config WhatsApp template language
+	GoogleReviewsConfigWhatsAppTemplateParameters           string `json:"google_reviews_config_whatsapp_template_parameters"`                           // google reviews config WhatsApp template parameters
+	GoogleReviewsConfigWhatsAppSMSFallback                  bool   `json:"google_reviews_config_whatsapp_sms_fallback"`                                  // google reviews config WhatsApp SMS fallback
+	GoogleReviewsConfigEmailEnabled                         bool   `json:"google_reviews_config_email_enabled"`                                          // google reviews config email enabled
+	GoogleReviewsConfigEmailParameter                       string `json:"google_reviews_config_email_parameter"`                                        // google reviews config email parameter
+	GoogleReviewsConfigEmailSubject                         string `json:"google_reviews_config_email_subject"`                                          // google reviews config email subject
+	GoogleReviewsConfigEmailTemplate                        string `json:"google_reviews_config_email_template"`                                         // google reviews config email template
 	GoogleReviewsConfigCompanies                            string `json:"google_reviews_config_companies"`                                              // google reviews config review companies
 	GoogleReviewsConfigBookingSourceMobileAppState          int    `json:"google_reviews_config_booking_source_mobile_app_state"`                        // google reviews config review booking source mobile app state
 	GoogleReviewsConfigAIResponsesEnabled                   bool   `json:"google_reviews_config_ai_responses_enabled"`                                   // google reviews config AI responses enabled
@@ -168,6 +212,8 @@ type SimpleConfig struct {
 	GoogleMyBusinessFiveStarRatingReply                     string `json:"google_my_business_five_star_rating_reply"`                                    // Google My Business five star rating reply
 	GoogleMyBusinessReportEnabled                           bool   `json:"google_my_business_report_enabled"`                                            // Google My Business report enabled
 	EmailAddress                                            string `json:"email_address"`                                                                // Email address used for reporting
+	GoogleReviewsConfigReviewLink                           string `json:"google_reviews_config_review_link"`                                            // google reviews config review link used for the {review_link} message placeholder
+	GoogleReviewsConfigOptOutLink                           string `json:"google_reviews_config_opt_out_link"`                                           // google reviews config opt out link used for the {opt_out_link} message placeholder
 	GoogleReviewsConfigTimeID                               uint64 `json:"google_reviews_config_time_id"`                                                // google reviews config time id
 	GoogleReviewsConfigTimeEnabled                          bool   `json:"google_reviews_config_time_enabled"`                                           // google reviews config time enabled
 	GoogleReviewsConfigTimeStart                            string `json:"google_reviews_config_time_start"`                                             // google reviews config time start
@@ -214,15 +260,48 @@ type StatsNewResult struct {
 	ClientName  string `json:"client_name"`  // client name
 	Sent        uint64 `json:"sent"`         // sent
 	Requested   uint64 `json:"requested"`    // requested
+	Delivered   uint64 `json:"delivered"`    // delivered (from the delivery reports)
+	Failed      uint64 `json:"failed"`       // failed or undelivered (from the delivery reports)
 	GroupPeriod string `json:"group_period"` // group period
 }
 
+// ClickStatsResult - represents a tracked short link click through statistic result (per client and message variant).
+type ClickStatsResult struct {
+	ClientID         uint64  `json:"client_id"`          // client id
+	ClientName       string  `json:"client_name"`        // client name
+	Variant          string  `json:"variant"`            // message variant (empty if not a multi message)
+	Sent             uint64  `json:"sent"`               // messages sent with a short link
+	Clicked          uint64  `json:"clicked"`            // messages where the short link was clicked
+	Clicks           uint64  `json:"clicks"`             // total clicks
+	ClickThroughRate float64 `json:"click_through_rate"` // click through rate (clicked / sent)
+}
+
 // NothingSentResult - represents a check for no messages sent result.
 type NothingSentResult struct {
 	ClientID   uint64 `json:"client_id"`   // client id
 	ClientName string `json:"client_name"` // client name
 }
 
+// MessageEventsRequest - represents a message events request (why was or wasn't a message sent).
+type MessageEventsRequest struct {
+	Telephone string `json:"telephone"` // telephone in international format e.g. +447123456789
+	ClientID  int    `json:"client_id"` // client id (0 for all clients)
+	StartDay  string `json:"start_day"` // start day
+	EndDay    string `json:"end_day"`   // end day
+}
+
+// MessageEvent - represents a message event (why a message was or wasn't sent).
+type MessageEvent struct {
+	ID               uint64    `json:"id"`                // id
+	ClientID         uint64    `json:"client_id"`         // client id
+	ClientName       string    `json:"client_name"`       // client name
+	Channel          string    `json:"channel"`           // channel (message service)
+	Reason           string    `json:"reason"`            // reason code e.g. sent, barred, too_recent
+	ProviderResponse string    `json:"provider_response"` // provider response
+	LatencyMs        uint64    `json:"latency_ms"`        // latency sending to the provider in milliseconds
+	Created          time.Time `json:"created"`           // created
+}
+
 // User - represents a user of the frontend
 type User struct {
 	ID       uint64 `json:"id"`       // id
@@ -303,11 +382,11 @@ func GetSimpleClient(clientID int, partnerID int) (SimpleConfig, error) {
 	// var err error
 	var s SimpleConfig
 
-	const qry = "SELECT client.id, client.enabled, client.name, client.note, client.country," +
+	const qry = "SELECT client.id, client.enabled, client.name, client.note, client.country, client.retention_days," +
 		" config.id, config.enabled, config.min_send_frequency, config.max_send_count," +
-		" config.max_daily_send_count, config.token, config.telephone_parameter," +
+		" config.max_daily_send_count, config.pacing_enabled, config.token, config.telephone_parameter," +
 		" config.send_from_icabbi_app, config.app_key, config.secret_key," +
-		" config.send_url, config.http_get, config.send_success_response, config.time_zone," +
+		" config.send_url, config.http_get, config.tls_skip_verify, config.send_success_response, config.time_zone," +
 		" config.multi_message_enabled, config.message_parameter, config.multi_message_separator," +
 		" config.use_database_message, config.message," +
 		" config.send_delay_enabled, config.send_delay," +
@@ -318,7 +397,10 @@ func GetSimpleClient(clientID int, partnerID int) (SimpleConfig, error) {
 		" config.review_master_sms_gateway_enabled," +
 		" config.review_master_sms_gateway_use_master_queue," +
 		" config.review_master_sms_gateway_pair_code," +
-		" config.alternate_message_service_enabled, config.alternate_message_service, config.alternate_message_service_secret1," +
+		" config.alternate_message_service_enabled, config.alternate_message_service, config.alternate_message_service_secret1, config.alternate_message_service_sender," +
+		" config.message_channel, config.whatsapp_phone_number_id, config.whatsapp_access_token, config.whatsapp_template_name," +
+		" config.whatsapp_template_language, config.whatsapp_template_parameters, config.whatsapp_sms_fallback," +
+		" config.email_enabled, config.email_parameter, config.email_subject, IFNULL(config.email_template, '')," +
 		" config.companies, config.booking_source_mobile_app_state," +
 		" IFNULL(config.ai_responses_enabled, 0), IFNULL(config.contact_method, '')," +
 		" IFNULL(config.monthly_review_analysis_enabled, 0)," +
@@ -339,6 +421,7 @@ func GetSimpleClient(clientID int, partnerID int) (SimpleConfig, error) {
 		" config.google_my_business_five_star_rating_reply," +
 		" config.google_my_business_report_enabled," +
 		" config.email_address," +
+		" config.review_link, config.opt_out_link," +
 		" times.id, times.enabled, times.start, times.end," +
 		" times.sunday, times.monday, times.tuesday, times.wednesday, times.thursday, times.friday, times.saturday" +
 		" FROM google_reviews_config_times AS times" +
@@ -353,11 +436,11 @@ func GetSimpleClient(clientID int, partnerID int) (SimpleConfig, error) {
 	defer rows.Close()
 	// assume only one (therefore retrieving the first one)
 	if rows.Next() {
-		if err := rows.Scan(&s.ClientID, &s.ClientEnabled, &s.ClientName, &s.ClientNote, &s.ClientCountry,
+		if err := rows.Scan(&s.ClientID, &s.ClientEnabled, &s.ClientName, &s.ClientNote, &s.ClientCountry, &s.ClientRetentionDays,
 			&s.GoogleReviewsConfigID, &s.GoogleReviewsConfigEnabled, &s.GoogleReviewsConfigMinSendFrequency, &s.GoogleReviewsConfigMaxSendCount,
-			&s.GoogleReviewsConfigMaxDailySendCount, &s.GoogleReviewsConfigToken, &s.GoogleReviewsConfigTelephoneParameter,
+			&s.GoogleReviewsConfigMaxDailySendCount, &s.GoogleReviewsConfigPacingEnabled, &s.GoogleReviewsConfigToken, &s.GoogleReviewsConfigTelephoneParameter,
 			&s.GoogleReviewsConfigSendFromIcabbiApp, &s.GoogleReviewsConfigAppKey, &s.GoogleReviewsConfigSecretKey,
-			&s.GoogleReviewsConfigSendURL, &s.GoogleReviewsConfigHttpGet, &s.GoogleReviewsConfigSendSuccessResponse, &s.GoogleReviewsConfigTimeZone,
+			&s.GoogleReviewsConfigSendURL, &s.GoogleReviewsConfigHttpGet, &s.GoogleReviewsConfigTLSSkipVerify, &s.GoogleReviewsConfigSendSuccessResponse, &s.GoogleReviewsConfigTimeZone,
 			&s.GoogleReviewsConfigMultiMessageEnabled, &s.GoogleReviewsConfigMessageParameter, &s.GoogleReviewsConfigMultiMessageSeparator,
 			&s.GoogleReviewsConfigUseDatabaseMessage, &s.GoogleReviewsConfigMessage,
 			&s.GoogleReviewsConfigSendDelayEnabled, &s.GoogleReviewsConfigSendDelay,
@@ -368,7 +451,11 @@ func GetSimpleClient(clientID int, partnerID int) (SimpleConfig, error) {
 			&s.GoogleReviewsConfigReviewMasterSMSGatewayEnabled,
 			&s.GoogleReviewsConfigReviewMasterSMSGatewayUseMasterQueue,
 			&s.GoogleReviewsConfigReviewMasterSMSGatewayPairCode,
-			&s.GoogleReviewsConfigAlternateMessageServiceEnabled, &s.GoogleReviewsConfigAlternateMessageService, &s.GoogleReviewsConfigAlternateMessageServiceSecret1,
+			&s.GoogleReviewsConfigAlternateMessageServiceEnabled, &s.GoogleReviewsConfigAlternateMessageService, &s.GoogleReviewsConfigAlternateMessageServiceSecret1, &s.GoogleReviewsConfigAlternateMessageServiceSender,
+			&s.GoogleReviewsConfigMessageChannel, &s.GoogleReviewsConfigWhatsAppPhoneNumberID, &s.GoogleReviewsConfigWhatsAppAccessToken,
+			&s.GoogleReviewsConfigWhatsAppTemplateName, &s.GoogleReviewsConfigWhatsAppTemplateLanguage,
+			&s.GoogleReviewsConfigWhatsAppTemplateParameters, &s.GoogleReviewsConfigWhatsAppSMSFallback,
+			&s.GoogleReviewsConfigEmailEnabled, &s.GoogleReviewsConfigEmailParameter, &s.GoogleReviewsConfigEmailSubject, &s.GoogleReviewsConfigEmailTemplate,
 			&s.GoogleReviewsConfigCompanies, &s.GoogleReviewsConfigBookingSourceMobileAppState,
 			&s.GoogleReviewsConfigAIResponsesEnabled, &s.GoogleReviewsConfigContactMethod,
 			&s.GoogleReviewsConfigMonthlyReviewAnalysisEnabled,
@@ -389,22 +476,28 @@ func GetSimpleClient(clientID int, partnerID int) (SimpleConfig, error) {
 			&s.GoogleMyBusinessFiveStarRatingReply,
 			&s.GoogleMyBusinessReportEnabled,
 			&s.EmailAddress,
+			&s.GoogleReviewsConfigReviewLink, &s.GoogleReviewsConfigOptOutLink,
 			&s.GoogleReviewsConfigTimeID, &s.GoogleReviewsConfigTimeEnabled, &s.GoogleReviewsConfigTimeStart, &s.GoogleReviewsConfigTimeEnd,
 			&s.GoogleReviewsConfigTimeSunday, &s.GoogleReviewsConfigTimeMonday, &s.GoogleReviewsConfigTimeTuesday, &s.GoogleReviewsConfigTimeWednesday, &s.GoogleReviewsConfigTimeThursday, &s.GoogleReviewsConfigTimeFriday, &s.GoogleReviewsConfigTimeSaturday); err != nil {
 			log.Printf("Error getting configs for client: %v\n", err)
 		}
+		// secrets are write only, only whether they are set is returned
+		s.GoogleReviewsConfigAppKeySet = writeOnlySecret(&s.GoogleReviewsConfigAppKey)
+		s.GoogleReviewsConfigSecretKeySet = writeOnlySecret(&s.GoogleReviewsConfigSecretKey)
+		s.GoogleReviewsConfigAlternateMessageServiceSecret1Set = writeOnlySecret(&s.GoogleReviewsConfigAlternateMessageServiceSecret1)
+		s.GoogleReviewsConfigWhatsAppAccessTokenSet = writeOnlySecret(&s.GoogleReviewsConfigWhatsAppAccessToken)
 	}
 	return s, nil
 }
 
 // UpdateSimpleClient - update a client and config and time, simple assume only 1 config and time
 func UpdateSimpleClient(simpleConfig SimpleConfig) error {
-	const clientQry = "UPDATE clients SET enabled = ?, name = ?, note = ?, country = ? WHERE id = ?"
+	const clientQry = "UPDATE clients SET enabled = ?, name = ?, note = ?, country = ?, retention_days = ? WHERE id = ?"
 	const googleReviewsConfigQry = "UPDATE google_reviews_configs SET enabled = ?," +
 		" min_send_frequency = ?, max_send_count = ?," +
-		" max_daily_send_count = ?, token = ?, telephone_parameter = ?," +
-		" send_from_icabbi_app = ?, app_key = ?, secret_key = ?," +
-		" send_url = ?, http_get = ?, send_success_response = ?, time_zone = ?," +
+		" max_daily_send_count = ?, pacing_enabled = ?, token = ?, telephone_parameter = ?," +
+		" send_from_icabbi_app = ?, app_key = IF(? = '', app_key, ?), secret_key = IF(? = '', secret_key, ?)," +
+		" send_url = ?, http_get = ?, tls_skip_verify = ?, send_success_response = ?, time_zone = ?," +
 		" multi_message_enabled = ?, message_parameter = ?, multi_message_separator = ?," +
 		" use_database_message = ?, message = ?," +
 		" send_delay_enabled = ?, send_delay = ?," +
@@ -415,7 +508,12 @@ func UpdateSimpleClient(simpleConfig SimpleConfig) error {
 		" review_master_sms_gateway_enabled = ?," +
 		" review_master_sms_gateway_use_master_queue = ?," +
 		" review_master_sms_gateway_pair_code = ?," +
-		" alternate_message_service_enabled = ?, alternate_message_service = ?, alternate_message_service_secret1 = ?," +
+		" alternate_message_service_enabled = ?, alternate_message_service = ?," +
+		" alternate_message_service_secret1 = IF(? = '', alternate_message_service_secret1, ?), alternate_message_service_sender = ?," +
+		" message_channel = ?, whatsapp_phone_number_id = ?," +
+		" whatsapp_access_token = IF(? = '', whatsapp_access_token, ?), whatsapp_template_name = ?," +
+		" whatsapp_template_language = ?, whatsapp_template_parameters = ?, whatsapp_sms_fallback = ?," +
+		" email_enabled = ?, email_parameter = ?, email_subject = ?, email_template = NULLIF(?, '')," +
 		" companies = ?, booking_source_mobile_app_state = ?," +
 		" ai_responses_enabled = ?," +
 		" contact_method = NULLIF(?, '')," + // Use NULLIF to convert empty string to NULL
@@ -436,13 +534,27 @@ func UpdateSimpleClient(simpleConfig SimpleConfig) error {
 		" google_my_business_reply_to_five_star_rating = ?," +
 		" google_my_business_five_star_rating_reply = ?," +
 		" google_my_business_report_enabled = ?," +
-		" email_address = ?" +
+		" email_address = ?," +
+		" review_link = ?, opt_out_link = ?" +
 		" WHERE id = ?"
 	const googleReviewsConfigTimeQry = "UPDATE google_reviews_config_times SET enabled = ?," +
 		" start = ?, end = ?," +
 		" sunday = ?, monday = ?, tuesday = ?, wednesday = ?, thursday = ?, friday = ?, saturday = ?" +
 		" WHERE id = ?"
 
+	if err := validateMessageTemplate(simpleConfig.GoogleReviewsConfigMessage); err != nil {
+		return err
+	}
+	if err := validateMessageTemplate(simpleConfig.GoogleReviewsConfigEmailSubject); err != nil {
+		return err
+	}
+
+	// secrets are stored encrypted, an empty secret keeps the stored secret (write only)
+	appKey := encryptSecret(strings.TrimSpace(simpleConfig.GoogleReviewsConfigAppKey))
+	secretKey := encryptSecret(strings.TrimSpace(simpleConfig.GoogleReviewsConfigSecretKey))
+	alternateMessageServiceSecret1 := encryptSecret(strings.TrimSpace(simpleConfig.GoogleReviewsConfigAlternateMessageServiceSecret1))
+	whatsAppAccessToken := encryptSecret(strings.TrimSpace(simpleConfig.GoogleReviewsConfigWhatsAppAccessToken))
+
 	tx, err := Db.Begin()
 	if err != nil {
 		log.Println(err)
@@ -450,7 +562,8 @@ func UpdateSimpleClient(simpleConfig SimpleConfig) error {
 	}
 
 	_, execErr := tx.Exec(clientQry, simpleConfig.ClientEnabled, strings.TrimSpace(simpleConfig.ClientName),
-		strings.TrimSpace(simpleConfig.ClientNote), strings.TrimSpace(simpleConfig.ClientCountry), simpleConfig.ClientID)
+		strings.TrimSpace(simpleConfig.ClientNote), strings.TrimSpace(simpleConfig.ClientCountry), simpleConfig.ClientRetentionDays,
+		simpleConfig.ClientID)
 	if execErr != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			log.Printf("update failed: %v, unable to rollback: %v\n", execErr, rollbackErr)
@@ -462,10 +575,10 @@ func UpdateSimpleClient(simpleConfig SimpleConfig) error {
 
 	_, execErr = tx.Exec(googleReviewsConfigQry, simpleConfig.GoogleReviewsConfigEnabled,
 		simpleConfig.GoogleReviewsConfigMinSendFrequency, simpleConfig.GoogleReviewsConfigMaxSendCount,
-		simpleConfig.GoogleReviewsConfigMaxDailySendCount, strings.TrimSpace(simpleConfig.GoogleReviewsConfigToken),
+		simpleConfig.GoogleReviewsConfigMaxDailySendCount, simpleConfig.GoogleReviewsConfigPacingEnabled, strings.TrimSpace(simpleConfig.GoogleReviewsConfigToken),
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigTelephoneParameter), simpleConfig.GoogleReviewsConfigSendFromIcabbiApp,
-		strings.TrimSpace(simpleConfig.GoogleReviewsConfigAppKey), strings.TrimSpace(simpleConfig.GoogleReviewsConfigSecretKey),
-		strings.TrimSpace(simpleConfig.GoogleReviewsConfigSendURL), simpleConfig.GoogleReviewsConfigHttpGet,
+		appKey, appKey, secretKey, secretKey,
+		strings.TrimSpace(simpleConfig.GoogleReviewsConfigSendURL), simpleConfig.GoogleReviewsConfigHttpGet, simpleConfig.GoogleReviewsConfigTLSSkipVerify,
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigSendSuccessResponse), strings.TrimSpace(simpleConfig.GoogleReviewsConfigTimeZone),
 		simpleConfig.GoogleReviewsConfigMultiMessageEnabled, strings.TrimSpace(simpleConfig.GoogleReviewsConfigMessageParameter),
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigMultiMessageSeparator),
@@ -481,7 +594,14 @@ func UpdateSimpleClient(simpleConfig SimpleConfig) error {
 		simpleConfig.GoogleReviewsConfigReviewMasterSMSGatewayUseMasterQueue,
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigReviewMasterSMSGatewayPairCode),
 		simpleConfig.GoogleReviewsConfigAlternateMessageServiceEnabled, simpleConfig.GoogleReviewsConfigAlternateMessageService,
-		strings.TrimSpace(simpleConfig.GoogleReviewsConfigAlternateMessageServiceSecret1),
+		alternateMessageServiceSecret1, alternateMessageServiceSecret1,
+		strings.TrimSpace(simpleConfig.GoogleReviewsConfigAlternateMessageServiceSender),
+		messageChannel(simpleConfig.GoogleReviewsConfigMessageChannel), strings.TrimSpace(simpleConfig.GoogleReviewsConfigWhatsAppPhoneNumberID),
+		whatsAppAccessToken, whatsAppAccessToken, strings.TrimSpace(simpleConfig.GoogleReviewsConfigWhatsAppTemplateName),
+		strings.TrimSpace(simpleConfig.GoogleReviewsConfigWhatsAppTemplateLanguage), strings.TrimSpace(simpleConfig.GoogleReviewsConfigWhatsAppTemplateParameters),
+		simpleConfig.GoogleReviewsConfigWhatsAppSMSFallback,
+		simpleConfig.GoogleReviewsConfigEmailEnabled, emailParameter(simpleConfig.GoogleReviewsConfigEmailParameter),
+		strings.TrimSpace(simpleConfig.GoogleReviewsConfigEmailSubject), strings.TrimSpace(simpleConfig.GoogleReviewsConfigEmailTemplate),
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigCompanies), simpleConfig.GoogleReviewsConfigBookingSourceMobileAppState,
 		simpleConfig.GoogleReviewsConfigAIResponsesEnabled,
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigContactMethod),
@@ -503,6 +623,7 @@ func UpdateSimpleClient(simpleConfig SimpleConfig) error {
 		strings.TrimSpace(simpleConfig.GoogleMyBusinessFiveStarRatingReply),
 		simpleConfig.GoogleMyBusinessReportEnabled,
 		strings.TrimSpace(simpleConfig.EmailAddress),
+		strings.TrimSpace(simpleConfig.GoogleReviewsConfigReviewLink), strings.TrimSpace(simpleConfig.GoogleReviewsConfigOptOutLink),
 		simpleConfig.GoogleReviewsConfigID)
 	if execErr != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -541,12 +662,12 @@ func CreateSimpleClient(simpleConfig SimpleConfig, partnerID int) error {
 	// var err error
 
 	const clientIDQry = "SELECT MAX(ID) + 1 FROM clients"
-	const clientQry = "INSERT INTO clients (id, enabled, name, note, country, partner_id) VALUES (?, ?, ?, ?, ?, ?)"
+	const clientQry = "INSERT INTO clients (id, enabled, name, note, country, retention_days, partner_id) VALUES (?, ?, ?, ?, ?, ?, ?)"
 	const googleReviewsConfigQry = "INSERT INTO google_reviews_configs (enabled," +
 		" min_send_frequency, max_send_count," +
-		" max_daily_send_count, token, telephone_parameter," +
+		" max_daily_send_count, pacing_enabled, token, telephone_parameter," +
 		" send_from_icabbi_app, app_key, secret_key," +
-		" send_url, http_get, send_success_response, time_zone," +
+		" send_url, http_get, tls_skip_verify, send_success_response, time_zone," +
 		" multi_message_enabled, message_parameter, multi_message_separator," +
 		" use_database_message, message," +
 		" send_delay_enabled, send_delay," +
@@ -557,7 +678,10 @@ func CreateSimpleClient(simpleConfig SimpleConfig, partnerID int) error {
 		" review_master_sms_gateway_enabled," +
 		" review_master_sms_gateway_use_master_queue," +
 		" review_master_sms_gateway_pair_code," +
-		" alternate_message_service_enabled, alternate_message_service, alternate_message_service_secret1," +
+		" alternate_message_service_enabled, alternate_message_service, alternate_message_service_secret1, alternate_message_service_sender," +
+		" message_channel, whatsapp_phone_number_id, whatsapp_access_token, whatsapp_template_name," +
+		" whatsapp_template_language, whatsapp_template_parameters, whatsapp_sms_fallback," +
+		" email_enabled, email_parameter, email_subject, email_template," +
 		" companies, booking_source_mobile_app_state," +
 		" ai_responses_enabled, contact_method, monthly_review_analysis_enabled," +
 		" google_my_business_review_reply_enabled," +
@@ -577,12 +701,20 @@ func CreateSimpleClient(simpleConfig SimpleConfig, partnerID int) error {
 		" google_my_business_five_star_rating_reply," +
 		" google_my_business_report_enabled," +
 		" email_address," +
+		" review_link, opt_out_link," +
 		" client_id)" +
-		" VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		" VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 	const googleReviewsConfigTimeQry = "INSERT INTO google_reviews_config_times (enabled," +
 		" start, end, sunday, monday, tuesday, wednesday, thursday, friday, saturday, google_reviews_config_id)" +
 		" VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 
+	if err := validateMessageTemplate(simpleConfig.GoogleReviewsConfigMessage); err != nil {
+		return err
+	}
+	if err := validateMessageTemplate(simpleConfig.GoogleReviewsConfigEmailSubject); err != nil {
+		return err
+	}
+
 	tx, err := Db.Begin()
 	if err != nil {
 		log.Println(err)
@@ -625,7 +757,7 @@ func CreateSimpleClient(simpleConfig SimpleConfig, partnerID int) error {
 	if !clientExists {
 		_, execErr := tx.Exec(clientQry, simpleConfig.ClientID, simpleConfig.ClientEnabled,
 			strings.TrimSpace(simpleConfig.ClientName), strings.TrimSpace(simpleConfig.ClientNote),
-			strings.TrimSpace(simpleConfig.ClientCountry), partnerID)
+			strings.TrimSpace(simpleConfig.ClientCountry), simpleConfig.ClientRetentionDays, partnerID)
 		if execErr != nil {
 			if rollbackErr := tx.Rollback(); rollbackErr != nil {
 				log.Printf("create failed: %v, unable to rollback: %v\n", execErr, rollbackErr)
@@ -639,10 +771,10 @@ func CreateSimpleClient(simpleConfig SimpleConfig, partnerID int) error {
 	// Now create the Google Reviews Config
 	res, execErr := tx.Exec(googleReviewsConfigQry, simpleConfig.GoogleReviewsConfigEnabled,
 		simpleConfig.GoogleReviewsConfigMinSendFrequency, simpleConfig.GoogleReviewsConfigMaxSendCount,
-		simpleConfig.GoogleReviewsConfigMaxDailySendCount, strings.TrimSpace(simpleConfig.GoogleReviewsConfigToken),
+		simpleConfig.GoogleReviewsConfigMaxDailySendCount, simpleConfig.GoogleReviewsConfigPacingEnabled, strings.TrimSpace(simpleConfig.GoogleReviewsConfigToken),
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigTelephoneParameter), simpleConfig.GoogleReviewsConfigSendFromIcabbiApp,
-		strings.TrimSpace(simpleConfig.GoogleReviewsConfigAppKey), strings.TrimSpace(simpleConfig.GoogleReviewsConfigSecretKey),
-		strings.TrimSpace(simpleConfig.GoogleReviewsConfigSendURL), simpleConfig.GoogleReviewsConfigHttpGet,
+		encryptSecret(strings.TrimSpace(simpleConfig.GoogleReviewsConfigAppKey)), encryptSecret(strings.TrimSpace(simpleConfig.GoogleReviewsConfigSecretKey)),
+		strings.TrimSpace(simpleConfig.GoogleReviewsConfigSendURL), simpleConfig.GoogleReviewsConfigHttpGet, simpleConfig.GoogleReviewsConfigTLSSkipVerify,
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigSendSuccessResponse), strings.TrimSpace(simpleConfig.GoogleReviewsConfigTimeZone),
 		simpleConfig.GoogleReviewsConfigMultiMessageEnabled, strings.TrimSpace(simpleConfig.GoogleReviewsConfigMessageParameter),
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigMultiMessageSeparator),
@@ -658,7 +790,14 @@ func CreateSimpleClient(simpleConfig SimpleConfig, partnerID int) error {
 		simpleConfig.GoogleReviewsConfigReviewMasterSMSGatewayUseMasterQueue,
 		simpleConfig.GoogleReviewsConfigReviewMasterSMSGatewayPairCode,
 		simpleConfig.GoogleReviewsConfigAlternateMessageServiceEnabled, simpleConfig.GoogleReviewsConfigAlternateMessageService,
-		strings.TrimSpace(simpleConfig.GoogleReviewsConfigAlternateMessageServiceSecret1),
+		encryptSecret(strings.TrimSpace(simpleConfig.GoogleReviewsConfigAlternateMessageServiceSecret1)),
+		strings.TrimSpace(simpleConfig.GoogleReviewsConfigAlternateMessageServiceSender),
+		messageChannel(simpleConfig.GoogleReviewsConfigMessageChannel), strings.TrimSpace(simpleConfig.GoogleReviewsConfigWhatsAppPhoneNumberID),
+		encryptSecret(strings.TrimSpace(simpleConfig.GoogleReviewsConfigWhatsAppAccessToken)), strings.TrimSpace(simpleConfig.GoogleReviewsConfigWhatsAppTemplateName),
+		strings.TrimSpace(simpleConfig.GoogleReviewsConfigWhatsAppTemplateLanguage), strings.TrimSpace(simpleConfig.GoogleReviewsConfigWhatsAppTemplateParameters),
+		simpleConfig.GoogleReviewsConfigWhatsAppSMSFallback,
+		simpleConfig.GoogleReviewsConfigEmailEnabled, emailParameter(simpleConfig.GoogleReviewsConfigEmailParameter),
+		strings.TrimSpace(simpleConfig.GoogleReviewsConfigEmailSubject), strings.TrimSpace(simpleConfig.GoogleReviewsConfigEmailTemplate),
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigCompanies), simpleConfig.GoogleReviewsConfigBookingSourceMobileAppState,
 		simpleConfig.GoogleReviewsConfigAIResponsesEnabled,
 		strings.TrimSpace(simpleConfig.GoogleReviewsConfigContactMethod), simpleConfig.GoogleReviewsConfigMonthlyReviewAnalysisEnabled,
@@ -672,6 +811,7 @@ func CreateSimpleClient(simpleConfig SimpleConfig, partnerID int) error {
 		simpleConfig.GoogleMyBusinessReplyToFourStarRating, strings.TrimSpace(simpleConfig.GoogleMyBusinessFourStarRatingReply),
 		simpleConfig.GoogleMyBusinessReplyToFiveStarRating, strings.TrimSpace(simpleConfig.GoogleMyBusinessFiveStarRatingReply),
 		simpleConfig.GoogleMyBusinessReportEnabled, strings.TrimSpace(simpleConfig.EmailAddress),
+		strings.TrimSpace(simpleConfig.GoogleReviewsConfigReviewLink), strings.TrimSpace(simpleConfig.GoogleReviewsConfigOptOutLink),
 		simpleConfig.ClientID)
 	if execErr != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -721,15 +861,15 @@ func CreateSimpleClient(simpleConfig SimpleConfig, partnerID int) error {
 func GetClient(clientID int, partnerID int) (ClientConfig, error) {
 	var c ClientConfig
 
-	const clientQry = "SELECT id, enabled, name, note, country, partner_id" +
+	const clientQry = "SELECT id, enabled, name, note, country, retention_days, partner_id" +
 		" FROM clients" +
 		" WHERE id = ? AND partner_id = ?"
 
 	const configQry = "SELECT" +
 		" id, enabled, min_send_frequency, max_send_count," +
-		" max_daily_send_count, token, telephone_parameter," +
+		" max_daily_send_count, pacing_enabled, token, telephone_parameter," +
 		" send_from_icabbi_app, app_key, secret_key," +
-		" send_url, http_get, send_success_response, time_zone," +
+		" send_url, http_get, tls_skip_verify, send_success_response, time_zone," +
 		" multi_message_enabled, message_parameter, multi_message_separator," +
 		" use_database_message, message," +
 		" send_delay_enabled, send_delay," +
@@ -740,7 +880,10 @@ func GetClient(clientID int, partnerID int) (ClientConfig, error) {
 		" review_master_sms_gateway_enabled," +
 		" review_master_sms_gateway_use_master_queue," +
 		" review_master_sms_gateway_pair_code," +
-		" alternate_message_service_enabled, alternate_message_service, alternate_message_service_secret1," +
+		" alternate_message_service_enabled, alternate_message_service, alternate_message_service_secret1, alternate_message_service_sender," +
+		" message_channel, whatsapp_phone_number_id, whatsapp_access_token, whatsapp_template_name," +
+		" whatsapp_template_language, whatsapp_template_parameters, whatsapp_sms_fallback," +
+		" email_enabled, email_parameter, email_subject, IFNULL(email_template, '') as email_template," +
 		" companies, booking_source_mobile_app_state," +
 		" IFNULL(ai_responses_enabled, 0) as ai_responses_enabled, IFNULL(contact_method, '') as contact_method," +
 		" IFNULL(monthly_review_analysis_enabled, 0) as monthly_review_analysis_enabled," +
@@ -761,6 +904,7 @@ func GetClient(clientID int, partnerID int) (ClientConfig, error) {
 		" google_my_business_five_star_rating_reply," +
 		" google_my_business_report_enabled," +
 		" email_address," +
+		" review_link, opt_out_link," +
 		" client_id" +
 		" FROM  google_reviews_configs" +
 		" WHERE client_id = ?"
@@ -778,6 +922,7 @@ func GetClient(clientID int, partnerID int) (ClientConfig, error) {
 		&client.Name,
 		&client.Note,
 		&client.Country,
+		&client.RetentionDays,
 		&client.PartnerID)
 	if err != nil {
 		log.Println(err)
@@ -795,9 +940,9 @@ func GetClient(clientID int, partnerID int) (ClientConfig, error) {
 	for configRows.Next() {
 		grc := GoogleReviewsConfig{}
 		conf := Config{}
-		err = configRows.Scan(&grc.ID, &grc.Enabled, &grc.MinSendFrequency, &grc.MaxSendCount, &grc.MaxDailySendCount,
+		err = configRows.Scan(&grc.ID, &grc.Enabled, &grc.MinSendFrequency, &grc.MaxSendCount, &grc.MaxDailySendCount, &grc.PacingEnabled,
 			&grc.Token, &grc.TelephoneParameter, &grc.SendFromIcabbiApp, &grc.AppKey, &grc.SecretKey, &grc.SendURL,
-			&grc.HttpGet, &grc.SendSuccessResponse, &grc.TimeZone, &grc.MultiMessageEnabled, &grc.MessageParameter,
+			&grc.HttpGet, &grc.TLSSkipVerify, &grc.SendSuccessResponse, &grc.TimeZone, &grc.MultiMessageEnabled, &grc.MessageParameter,
 			&grc.MultiMessageSeparator, &grc.UseDatabaseMessage, &grc.Message,
 			&grc.SendDelayEnabled, &grc.SendDelay, &grc.DispatcherChecksEnabled,
 			&grc.DispatcherType, &grc.DispatcherURL, &grc.BookingIdParameter, &grc.IsBookingForNowDiffMinutes,
@@ -805,7 +950,10 @@ func GetClient(clientID int, partnerID int) (ClientConfig, error) {
 			&grc.ReplaceTelephoneCountryCode, &grc.ReplaceTelephoneCountryCodeWith,
 			&grc.ReviewMasterSMSGatewayEnabled, &grc.ReviewMasterSMSGatewayUseMasterQueue,
 			&grc.ReviewMasterSMSGatewayPairCode,
-			&grc.AlternateMessageServiceEnabled, &grc.AlternateMessageService, &grc.AlternateMessageServiceSecret1,
+			&grc.AlternateMessageServiceEnabled, &grc.AlternateMessageService, &grc.AlternateMessageServiceSecret1, &grc.AlternateMessageServiceSender,
+			&grc.MessageChannel, &grc.WhatsAppPhoneNumberID, &grc.WhatsAppAccessToken, &grc.WhatsAppTemplateName,
+			&grc.WhatsAppTemplateLanguage, &grc.WhatsAppTemplateParameters, &grc.WhatsAppSMSFallback,
+			&grc.EmailEnabled, &grc.EmailParameter, &grc.EmailSubject, &grc.EmailTemplate,
 			&grc.Companies, &grc.BookingSourceMobileAppState,
 			&grc.AIResponsesEnabled,
 			&grc.ContactMethod,
@@ -826,10 +974,15 @@ func GetClient(clientID int, partnerID int) (ClientConfig, error) {
 			&grc.GoogleMyBusinessReplyToFiveStarRating,
 			&grc.GoogleMyBusinessFiveStarRatingReply,
 			&grc.GoogleMyBusinessReportEnabled,
-			&grc.EmailAddress, &grc.ClientID)
+			&grc.EmailAddress, &grc.ReviewLink, &grc.OptOutLink, &grc.ClientID)
 		if err != nil {
 			return c, err
 		}
+		// secrets are write only, only whether they are set is returned
+		grc.AppKeySet = writeOnlySecret(&grc.AppKey)
+		grc.SecretKeySet = writeOnlySecret(&grc.SecretKey)
+		grc.AlternateMessageServiceSecret1Set = writeOnlySecret(&grc.AlternateMessageServiceSecret1)
+		grc.WhatsAppAccessTokenSet = writeOnlySecret(&grc.WhatsAppAccessToken)
 
 		// times
 		timeRows, err := Db.Query(timeQry, grc.ID)
@@ -860,13 +1013,13 @@ func GetClient(clientID int, partnerID int) (ClientConfig, error) {
 // UpdateClient - update a client and configs and times
 func UpdateClient(clientConfig ClientConfig, partnerID int) error {
 
-	const clientQry = "UPDATE clients SET enabled = ?, name = ?, note = ?, country = ?" +
+	const clientQry = "UPDATE clients SET enabled = ?, name = ?, note = ?, country = ?, retention_days = ?" +
 		" WHERE id = ? AND partner_id = ?"
 	const googleReviewsConfigQry = "UPDATE google_reviews_configs SET enabled = ?," +
 		" min_send_frequency = ?, max_send_count = ?," +
-		" max_daily_send_count = ?, token = ?, telephone_parameter = ?," +
-		" send_from_icabbi_app = ?, app_key = ?, secret_key = ?," +
-		" send_url = ?, http_get = ?, send_success_response = ?, time_zone = ?," +
+		" max_daily_send_count = ?, pacing_enabled = ?, token = ?, telephone_parameter = ?," +
+		" send_from_icabbi_app = ?, app_key = IF(? = '', app_key, ?), secret_key = IF(? = '', secret_key, ?)," +
+		" send_url = ?, http_get = ?, tls_skip_verify = ?, send_success_response = ?, time_zone = ?," +
 		" multi_message_enabled = ?, message_parameter = ?, multi_message_separator = ?," +
 		" use_database_message = ?, message = ?," +
 		" send_delay_enabled = ?, send_delay = ?," +
@@ -877,7 +1030,12 @@ func UpdateClient(clientConfig ClientConfig, partnerID int) error {
 		" review_master_sms_gateway_enabled = ?," +
 		" review_master_sms_gateway_use_master_queue = ?," +
 		" review_master_sms_gateway_pair_code = ?," +
-		" alternate_message_service_enabled = ?, alternate_message_service = ?, alternate_message_service_secret1 = ?," +
+		" alternate_message_service_enabled = ?, alternate_message_service = ?," +
+		" alternate_message_service_secret1 = IF(? = '', alternate_message_service_secret1, ?), alternate_message_service_sender = ?," +
+		" message_channel = ?, whatsapp_phone_number_id = ?," +
+		" whatsapp_access_token = IF(? = '', whatsapp_access_token, ?), whatsapp_template_name = ?," +
+		" whatsapp_template_language = ?, whatsapp_template_parameters = ?, whatsapp_sms_fallback = ?," +
+		" email_enabled = ?, email_parameter = ?, email_subject = ?, email_template = NULLIF(?, '')," +
 		" companies = ?, booking_source_mobile_app_state = ?," +
 		" ai_responses_enabled = ?," +
 		" contact_method = NULLIF(?, '')," + // Use NULLIF to convert empty string to NULL
@@ -898,13 +1056,23 @@ func UpdateClient(clientConfig ClientConfig, partnerID int) error {
 		" google_my_business_reply_to_five_star_rating = ?," +
 		" google_my_business_five_star_rating_reply = ?," +
 		" google_my_business_report_enabled = ?," +
-		" email_address = ?" +
+		" email_address = ?," +
+		" review_link = ?, opt_out_link = ?" +
 		" WHERE id = ?"
 	const googleReviewsConfigTimeQry = "UPDATE google_reviews_config_times SET enabled = ?," +
 		" start = ?, end = ?," +
 		" sunday = ?, monday = ?, tuesday = ?, wednesday = ?, thursday = ?, friday = ?, saturday = ?" +
 		" WHERE id = ?"
 
+	for _, config := range clientConfig.Configs {
+		if err := validateMessageTemplate(config.GoogleReviewsConfig.Message); err != nil {
+			return err
+		}
+		if err := validateMessageTemplate(config.GoogleReviewsConfig.EmailSubject); err != nil {
+			return err
+		}
+	}
+
 	tx, err := Db.Begin()
 	if err != nil {
 		log.Println(err)
@@ -912,7 +1080,7 @@ func UpdateClient(clientConfig ClientConfig, partnerID int) error {
 	}
 
 	_, execErr := tx.Exec(clientQry, clientConfig.Client.Enabled, clientConfig.Client.Name,
-		clientConfig.Client.Note, clientConfig.Client.Country, clientConfig.Client.ID, partnerID)
+		clientConfig.Client.Note, clientConfig.Client.Country, clientConfig.Client.RetentionDays, clientConfig.Client.ID, partnerID)
 	if execErr != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			log.Printf("update failed: %v, unable to rollback: %v\n", execErr, rollbackErr)
@@ -929,12 +1097,18 @@ func UpdateClient(clientConfig ClientConfig, partnerID int) error {
 			config.GoogleReviewsConfig.AIResponsesEnabled,
 			config.GoogleReviewsConfig.ContactMethod)
 
+		// secrets are stored encrypted, an empty secret keeps the stored secret (write only)
+		appKey := encryptSecret(strings.TrimSpace(config.GoogleReviewsConfig.AppKey))
+		secretKey := encryptSecret(strings.TrimSpace(config.GoogleReviewsConfig.SecretKey))
+		alternateMessageServiceSecret1 := encryptSecret(strings.TrimSpace(config.GoogleReviewsConfig.AlternateMessageServiceSecret1))
+		whatsAppAccessToken := encryptSecret(strings.TrimSpace(config.GoogleReviewsConfig.WhatsAppAccessToken))
+
 		_, execErr = tx.Exec(googleReviewsConfigQry, config.GoogleReviewsConfig.Enabled,
 			config.GoogleReviewsConfig.MinSendFrequency, config.GoogleReviewsConfig.MaxSendCount,
-			config.GoogleReviewsConfig.MaxDailySendCount, strings.TrimSpace(config.GoogleReviewsConfig.Token),
+			config.GoogleReviewsConfig.MaxDailySendCount, config.GoogleReviewsConfig.PacingEnabled, strings.TrimSpace(config.GoogleReviewsConfig.Token),
 			strings.TrimSpace(config.GoogleReviewsConfig.TelephoneParameter), config.GoogleReviewsConfig.SendFromIcabbiApp,
-			strings.TrimSpace(config.GoogleReviewsConfig.AppKey), strings.TrimSpace(config.GoogleReviewsConfig.SecretKey),
-			strings.TrimSpace(config.GoogleReviewsConfig.SendURL), config.GoogleReviewsConfig.HttpGet,
+			appKey, appKey, secretKey, secretKey,
+			strings.TrimSpace(config.GoogleReviewsConfig.SendURL), config.GoogleReviewsConfig.HttpGet, config.GoogleReviewsConfig.TLSSkipVerify,
 			strings.TrimSpace(config.GoogleReviewsConfig.SendSuccessResponse), strings.TrimSpace(config.GoogleReviewsConfig.TimeZone),
 			config.GoogleReviewsConfig.MultiMessageEnabled, strings.TrimSpace(config.GoogleReviewsConfig.MessageParameter),
 			strings.TrimSpace(config.GoogleReviewsConfig.MultiMessageSeparator),
@@ -950,7 +1124,14 @@ func UpdateClient(clientConfig ClientConfig, partnerID int) error {
 			config.GoogleReviewsConfig.ReviewMasterSMSGatewayUseMasterQueue,
 			strings.TrimSpace(config.GoogleReviewsConfig.ReviewMasterSMSGatewayPairCode),
 			config.GoogleReviewsConfig.AlternateMessageServiceEnabled, strings.TrimSpace(config.GoogleReviewsConfig.AlternateMessageService),
-			strings.TrimSpace(config.GoogleReviewsConfig.AlternateMessageServiceSecret1),
+			alternateMessageServiceSecret1, alternateMessageServiceSecret1,
+			strings.TrimSpace(config.GoogleReviewsConfig.AlternateMessageServiceSender),
+			messageChannel(config.GoogleReviewsConfig.MessageChannel), strings.TrimSpace(config.GoogleReviewsConfig.WhatsAppPhoneNumberID),
+			whatsAppAccessToken, whatsAppAccessToken, strings.TrimSpace(config.GoogleReviewsConfig.WhatsAppTemplateName),
+			strings.TrimSpace(config.GoogleReviewsConfig.WhatsAppTemplateLanguage), strings.TrimSpace(config.GoogleReviewsConfig.WhatsAppTemplateParameters),
+			config.GoogleReviewsConfig.WhatsAppSMSFallback,
+			config.GoogleReviewsConfig.EmailEnabled, emailParameter(config.GoogleReviewsConfig.EmailParameter),
+			strings.TrimSpace(config.GoogleReviewsConfig.EmailSubject), strings.TrimSpace(config.GoogleReviewsConfig.EmailTemplate),
 			strings.TrimSpace(config.GoogleReviewsConfig.Companies), config.GoogleReviewsConfig.BookingSourceMobileAppState,
 			config.GoogleReviewsConfig.AIResponsesEnabled,
 			strings.TrimSpace(config.GoogleReviewsConfig.ContactMethod),
@@ -965,6 +1146,7 @@ func UpdateClient(clientConfig ClientConfig, partnerID int) error {
 			config.GoogleReviewsConfig.GoogleMyBusinessReplyToFourStarRating, strings.TrimSpace(config.GoogleReviewsConfig.GoogleMyBusinessFourStarRatingReply),
 			config.GoogleReviewsConfig.GoogleMyBusinessReplyToFiveStarRating, strings.TrimSpace(config.GoogleReviewsConfig.GoogleMyBusinessFiveStarRatingReply),
 			config.GoogleReviewsConfig.GoogleMyBusinessReportEnabled, strings.TrimSpace(config.GoogleReviewsConfig.EmailAddress),
+			strings.TrimSpace(config.GoogleReviewsConfig.ReviewLink), strings.TrimSpace(config.GoogleReviewsConfig.OptOutLink),
 			config.GoogleReviewsConfig.ID)
 		if execErr != nil {
 			if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -1004,12 +1186,12 @@ func CreateClient(clientConfig ClientConfig, partnerID int) error {
 	// var err error
 
 	const clientIDQry = "SELECT MAX(ID) + 1 FROM clients"
-	const clientQry = "INSERT INTO clients (id, enabled, name, note, country, partner_id) VALUES (?, ?, ?, ?, ?, ?)"
+	const clientQry = "INSERT INTO clients (id, enabled, name, note, country, retention_days, partner_id) VALUES (?, ?, ?, ?, ?, ?, ?)"
 	const googleReviewsConfigQry = "INSERT INTO google_reviews_configs (enabled," +
 		" min_send_frequency, max_send_count," +
-		" max_daily_send_count, token, telephone_parameter," +
+		" max_daily_send_count, pacing_enabled, token, telephone_parameter," +
 		" send_from_icabbi_app, app_key, secret_key," +
-		" send_url, http_get, send_success_response, time_zone," +
+		" send_url, http_get, tls_skip_verify, send_success_response, time_zone," +
 		" multi_message_enabled, message_parameter, multi_message_separator," +
 		" use_database_message, message," +
 		" send_delay_enabled, send_delay," +
@@ -1020,7 +1202,10 @@ func CreateClient(clientConfig ClientConfig, partnerID int) error {
 		" review_master_sms_gateway_enabled," +
 		" review_master_sms_gateway_use_master_queue," +
 		" review_master_sms_gateway_pair_code, " +
-		" alternate_message_service_enabled, alternate_message_service, alternate_message_service_secret1, " +
+		" alternate_message_service_enabled, alternate_message_service, alternate_message_service_secret1, alternate_message_service_sender, " +
+		" message_channel, whatsapp_phone_number_id, whatsapp_access_token, whatsapp_template_name, " +
+		" whatsapp_template_language, whatsapp_template_parameters, whatsapp_sms_fallback, " +
+		" email_enabled, email_parameter, email_subject, email_template, " +
 		" companies, booking_source_mobile_app_state, " +
 		" ai_responses_enabled, contact_method, monthly_review_analysis_enabled, " +
 		" google_my_business_review_reply_enabled," +
@@ -1040,12 +1225,22 @@ func CreateClient(clientConfig ClientConfig, partnerID int) error {
 		" google_my_business_five_star_rating_reply," +
 		" google_my_business_report_enabled," +
 		" email_address," +
+		" review_link, opt_out_link," +
 		" client_id)" +
-		" VALUES(?,?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		" VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 	const googleReviewsConfigTimeQry = "INSERT INTO google_reviews_config_times (enabled," +
 		" start, end, sunday, monday, tuesday, wednesday, thursday, friday, saturday, google_reviews_config_id)" +
 		" VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 
+	for _, config := range clientConfig.Configs {
+		if err := validateMessageTemplate(config.GoogleReviewsConfig.Message); err != nil {
+			return err
+		}
+		if err := validateMessageTemplate(config.GoogleReviewsConfig.EmailSubject); err != nil {
+			return err
+		}
+	}
+
 	tx, err := Db.Begin()
 	if err != nil {
 		log.Println(err)
@@ -1071,7 +1266,8 @@ func CreateClient(clientConfig ClientConfig, partnerID int) error {
 	}
 
 	_, execErr := tx.Exec(clientQry, clientID, clientConfig.Client.Enabled, strings.TrimSpace(clientConfig.Client.Name),
-		strings.TrimSpace(clientConfig.Client.Note), strings.TrimSpace(clientConfig.Client.Country), partnerID)
+		strings.TrimSpace(clientConfig.Client.Note), strings.TrimSpace(clientConfig.Client.Country), clientConfig.Client.RetentionDays,
+		partnerID)
 	if execErr != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
 			log.Printf("create failed: %v, unable to rollback: %v\n", execErr, rollbackErr)
@@ -1084,10 +1280,10 @@ func CreateClient(clientConfig ClientConfig, partnerID int) error {
 	for _, config := range clientConfig.Configs {
 		res, execErr := tx.Exec(googleReviewsConfigQry, config.GoogleReviewsConfig.Enabled,
 			config.GoogleReviewsConfig.MinSendFrequency, config.GoogleReviewsConfig.MaxSendCount,
-			config.GoogleReviewsConfig.MaxDailySendCount, strings.TrimSpace(config.GoogleReviewsConfig.Token),
+			config.GoogleReviewsConfig.MaxDailySendCount, config.GoogleReviewsConfig.PacingEnabled, strings.TrimSpace(config.GoogleReviewsConfig.Token),
 			strings.TrimSpace(config.GoogleReviewsConfig.TelephoneParameter), config.GoogleReviewsConfig.SendFromIcabbiApp,
-			strings.TrimSpace(config.GoogleReviewsConfig.AppKey), strings.TrimSpace(config.GoogleReviewsConfig.SecretKey),
-			strings.TrimSpace(config.GoogleReviewsConfig.SendURL), config.GoogleReviewsConfig.HttpGet,
+			encryptSecret(strings.TrimSpace(config.GoogleReviewsConfig.AppKey)), encryptSecret(strings.TrimSpace(config.GoogleReviewsConfig.SecretKey)),
+			strings.TrimSpace(config.GoogleReviewsConfig.SendURL), config.GoogleReviewsConfig.HttpGet, config.GoogleReviewsConfig.TLSSkipVerify,
 			strings.TrimSpace(config.GoogleReviewsConfig.SendSuccessResponse), strings.TrimSpace(config.GoogleReviewsConfig.TimeZone),
 			config.GoogleReviewsConfig.MultiMessageEnabled, strings.TrimSpace(config.GoogleReviewsConfig.MessageParameter),
 			strings.TrimSpace(config.GoogleReviewsConfig.MultiMessageSeparator),
@@ -1103,7 +1299,14 @@ func CreateClient(clientConfig ClientConfig, partnerID int) error {
 			config.GoogleReviewsConfig.ReviewMasterSMSGatewayUseMasterQueue,
 			strings.TrimSpace(config.GoogleReviewsConfig.ReviewMasterSMSGatewayPairCode),
 			config.GoogleReviewsConfig.AlternateMessageServiceEnabled, strings.TrimSpace(config.GoogleReviewsConfig.AlternateMessageService),
-			strings.TrimSpace(config.GoogleReviewsConfig.AlternateMessageServiceSecret1),
+			encryptSecret(strings.TrimSpace(config.GoogleReviewsConfig.AlternateMessageServiceSecret1)),
+			strings.TrimSpace(config.GoogleReviewsConfig.AlternateMessageServiceSender),
+			messageChannel(config.GoogleReviewsConfig.MessageChannel), strings.TrimSpace(config.GoogleReviewsConfig.WhatsAppPhoneNumberID),
+			encryptSecret(strings.TrimSpace(config.GoogleReviewsConfig.WhatsAppAccessToken)), strings.TrimSpace(config.GoogleReviewsConfig.WhatsAppTemplateName),
+			strings.TrimSpace(config.GoogleReviewsConfig.WhatsAppTemplateLanguage), strings.TrimSpace(config.GoogleReviewsConfig.WhatsAppTemplateParameters),
+			config.GoogleReviewsConfig.WhatsAppSMSFallback,
+			config.GoogleReviewsConfig.EmailEnabled, emailParameter(config.GoogleReviewsConfig.EmailParameter),
+			strings.TrimSpace(config.GoogleReviewsConfig.EmailSubject), strings.TrimSpace(config.GoogleReviewsConfig.EmailTemplate),
 			strings.TrimSpace(config.GoogleReviewsConfig.Companies), config.GoogleReviewsConfig.BookingSourceMobileAppState,
 			config.GoogleReviewsConfig.AIResponsesEnabled,
 			strings.TrimSpace(config.GoogleReviewsConfig.ContactMethod),
@@ -1118,6 +1321,7 @@ func CreateClient(clientConfig ClientConfig, partnerID int) error {
 			config.GoogleReviewsConfig.GoogleMyBusinessReplyToFourStarRating, strings.TrimSpace(config.GoogleReviewsConfig.GoogleMyBusinessFourStarRatingReply),
 			config.GoogleReviewsConfig.GoogleMyBusinessReplyToFiveStarRating, strings.TrimSpace(config.GoogleReviewsConfig.GoogleMyBusinessFiveStarRatingReply),
 			config.GoogleReviewsConfig.GoogleMyBusinessReportEnabled, strings.TrimSpace(config.GoogleReviewsConfig.EmailAddress),
+			strings.TrimSpace(config.GoogleReviewsConfig.ReviewLink), strings.TrimSpace(config.GoogleReviewsConfig.OptOutLink),
 			clientID)
 		if execErr != nil {
 			if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -1167,9 +1371,9 @@ func CreateGRConfig(googleReviewsConfig GoogleReviewsConfig) error {
 
 	const googleReviewsConfigQry = "INSERT INTO google_reviews_configs (enabled," +
 		" min_send_frequency, max_send_count," +
-		" max_daily_send_count, token, telephone_parameter," +
+		" max_daily_send_count, pacing_enabled, token, telephone_parameter," +
 		" send_from_icabbi_app, app_key, secret_key," +
-		" send_url, http_get, send_success_response, time_zone," +
+		" send_url, http_get, tls_skip_verify, send_success_response, time_zone," +
 		" multi_message_enabled, message_parameter, multi_message_separator," +
 		" use_database_message, message," +
 		" send_delay_enabled, send_delay," +
@@ -1180,7 +1384,10 @@ func CreateGRConfig(googleReviewsConfig GoogleReviewsConfig) error {
 		" review_master_sms_gateway_enabled," +
 		" review_master_sms_gateway_use_master_queue," +
 		" review_master_sms_gateway_pair_code, " +
-		" alternate_message_service_enabled, alternate_message_service, alternate_message_service_secret1, " +
+		" alternate_message_service_enabled, alternate_message_service, alternate_message_service_secret1, alternate_message_service_sender, " +
+		" message_channel, whatsapp_phone_number_id, whatsapp_access_token, whatsapp_template_name, " +
+		" whatsapp_template_language, whatsapp_template_parameters, whatsapp_sms_fallback, " +
+		" email_enabled, email_parameter, email_subject, email_template, " +
 		" companies, booking_source_mobile_app_state, " +
 		" ai_responses_enabled, contact_method, monthly_review_analysis_enabled, " +
 		" google_my_business_review_reply_enabled," +
@@ -1200,8 +1407,16 @@ func CreateGRConfig(googleReviewsConfig GoogleReviewsConfig) error {
 		" google_my_business_five_star_rating_reply," +
 		" google_my_business_report_enabled," +
 		" email_address," +
+		" review_link, opt_out_link," +
 		" client_id)" +
-		" VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		" VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	if err := validateMessageTemplate(googleReviewsConfig.Message); err != nil {
+		return err
+	}
+	if err := validateMessageTemplate(googleReviewsConfig.EmailSubject); err != nil {
+		return err
+	}
 
 	tx, err := Db.Begin()
 	if err != nil {
@@ -1211,10 +1426,10 @@ func CreateGRConfig(googleReviewsConfig GoogleReviewsConfig) error {
 
 	_, execErr := tx.Exec(googleReviewsConfigQry, googleReviewsConfig.Enabled,
 		googleReviewsConfig.MinSendFrequency, googleReviewsConfig.MaxSendCount,
-		googleReviewsConfig.MaxDailySendCount, strings.TrimSpace(googleReviewsConfig.Token),
+		googleReviewsConfig.MaxDailySendCount, googleReviewsConfig.PacingEnabled, strings.TrimSpace(googleReviewsConfig.Token),
 		strings.TrimSpace(googleReviewsConfig.TelephoneParameter), googleReviewsConfig.SendFromIcabbiApp,
-		strings.TrimSpace(googleReviewsConfig.AppKey), strings.TrimSpace(googleReviewsConfig.SecretKey),
-		strings.TrimSpace(googleReviewsConfig.SendURL), googleReviewsConfig.HttpGet,
+		encryptSecret(strings.TrimSpace(googleReviewsConfig.AppKey)), encryptSecret(strings.TrimSpace(googleReviewsConfig.SecretKey)),
+		strings.TrimSpace(googleReviewsConfig.SendURL), googleReviewsConfig.HttpGet, googleReviewsConfig.TLSSkipVerify,
 		strings.TrimSpace(googleReviewsConfig.SendSuccessResponse), strings.TrimSpace(googleReviewsConfig.TimeZone),
 		googleReviewsConfig.MultiMessageEnabled, strings.TrimSpace(googleReviewsConfig.MessageParameter),
 		strings.TrimSpace(googleReviewsConfig.MultiMessageSeparator),
@@ -1230,7 +1445,14 @@ func CreateGRConfig(googleReviewsConfig GoogleReviewsConfig) error {
 		googleReviewsConfig.ReviewMasterSMSGatewayUseMasterQueue,
 		strings.TrimSpace(googleReviewsConfig.ReviewMasterSMSGatewayPairCode),
 		googleReviewsConfig.AlternateMessageServiceEnabled, strings.TrimSpace(googleReviewsConfig.AlternateMessageService),
-		strings.TrimSpace(googleReviewsConfig.AlternateMessageServiceSecret1),
+		encryptSecret(strings.TrimSpace(googleReviewsConfig.AlternateMessageServiceSecret1)),
+		strings.TrimSpace(googleReviewsConfig.AlternateMessageServiceSender),
+		messageChannel(googleReviewsConfig.MessageChannel), strings.TrimSpace(googleReviewsConfig.WhatsAppPhoneNumberID),
+		encryptSecret(strings.TrimSpace(googleReviewsConfig.WhatsAppAccessToken)), strings.TrimSpace(googleReviewsConfig.WhatsAppTemplateName),
+		strings.TrimSpace(googleReviewsConfig.WhatsAppTemplateLanguage), strings.TrimSpace(googleReviewsConfig.WhatsAppTemplateParameters),
+		googleReviewsConfig.WhatsAppSMSFallback,
+		googleReviewsConfig.EmailEnabled, emailParameter(googleReviewsConfig.EmailParameter),
+		strings.TrimSpace(googleReviewsConfig.EmailSubject), strings.TrimSpace(googleReviewsConfig.EmailTemplate),
 		strings.TrimSpace(googleReviewsConfig.Companies), googleReviewsConfig.BookingSourceMobileAppState,
 		googleReviewsConfig.AIResponsesEnabled,
 		strings.TrimSpace(googleReviewsConfig.ContactMethod),
@@ -1245,6 +1467,7 @@ func CreateGRConfig(googleReviewsConfig GoogleReviewsConfig) error {
 		googleReviewsConfig.GoogleMyBusinessReplyToFourStarRating, strings.TrimSpace(googleReviewsConfig.GoogleMyBusinessFourStarRatingReply),
 		googleReviewsConfig.GoogleMyBusinessReplyToFiveStarRating, strings.TrimSpace(googleReviewsConfig.GoogleMyBusinessFiveStarRatingReply),
 		googleReviewsConfig.GoogleMyBusinessReportEnabled, strings.TrimSpace(googleReviewsConfig.EmailAddress),
+		strings.TrimSpace(googleReviewsConfig.ReviewLink), strings.TrimSpace(googleReviewsConfig.OptOutLink),
 		googleReviewsConfig.ClientID)
 	if execErr != nil {
 		if rollbackErr := tx.Rollback(); rollbackErr != nil {
@@ -1373,10 +1596,11 @@ func Stats(statsRequest StatsRequest, partnerID int) ([]StatsResult, error) {
 
 // StatsNew - get some stats using the stats table
 func StatsNew(statsRequest StatsRequest, partnerID int) ([]StatsNewResult, error) {
-	var outerQryStart = "SELECT a.client_id, a.client_name, a.sent, a.requested, a.group_period FROM ("
+	var outerQryStart = "SELECT a.client_id, a.client_name, a.sent, a.requested, a.delivered, a.failed, a.group_period FROM ("
 	var outerQryEnd = ") AS a WHERE a.partner_id = ?"
 	var qry = "SELECT g.client_id AS client_id, c.name AS client_name, c.partner_id AS partner_id," +
-		" sum(g.sent_count) AS sent,  sum(g.requested_count) AS requested, "
+		" sum(g.sent_count) AS sent,  sum(g.requested_count) AS requested," +
+		" sum(g.delivered_count) AS delivered, sum(g.failed_count) AS failed, "
 	const qryEnd = " AS group_period" +
 		" FROM google_reviews_stats AS g" +
 		" LEFT JOIN clients AS c ON g.client_id = c.id" +
@@ -1400,7 +1624,7 @@ func StatsNew(statsRequest StatsRequest, partnerID int) ([]StatsNewResult, error
 	var statsResult []StatsNewResult
 	for rows.Next() {
 		var s StatsNewResult
-		if err := rows.Scan(&s.ClientID, &s.ClientName, &s.Sent, &s.Requested, &s.GroupPeriod); err != nil {
+		if err := rows.Scan(&s.ClientID, &s.ClientName, &s.Sent, &s.Requested, &s.Delivered, &s.Failed, &s.GroupPeriod); err != nil {
 			log.Printf("Error getting stats results: %v\n", err)
 		}
 		statsResult = append(statsResult, s)
@@ -1408,6 +1632,39 @@ func StatsNew(statsRequest StatsRequest, partnerID int) ([]StatsNewResult, error
 	return statsResult, nil
 }
 
+// ClickStats - get the tracked short link click through rates per client and message variant
+// Only short links for messages that were sent (have a message event) are counted.
+func ClickStats(statsRequest StatsRequest, partnerID int) ([]ClickStatsResult, error) {
+	const qry = "SELECT l.client_id, c.name, l.variant," +
+		" COUNT(DISTINCT l.id) AS sent, COUNT(DISTINCT k.short_link_id) AS clicked, COUNT(k.id) AS clicks" +
+		" FROM google_reviews_short_links AS l" +
+		" JOIN clients AS c ON c.id = l.client_id" +
+		" LEFT JOIN google_reviews_short_link_clicks AS k ON k.short_link_id = l.id" +
+		" WHERE l.message_event_id > 0" +
+		" AND c.partner_id = ?" +
+		" AND l.created BETWEEN ? AND ?" +
+		" GROUP BY l.client_id, c.name, l.variant" +
+		" ORDER BY l.client_id, l.variant"
+	rows, err := Db.Query(qry, partnerID, statsRequest.StartDay, statsRequest.EndDay)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+	var clickStatsResult []ClickStatsResult
+	for rows.Next() {
+		var s ClickStatsResult
+		if err := rows.Scan(&s.ClientID, &s.ClientName, &s.Variant, &s.Sent, &s.Clicked, &s.Clicks); err != nil {
+			log.Printf("Error getting click stats results: %v\n", err)
+		}
+		if s.Sent > 0 {
+			s.ClickThroughRate = float64(s.Clicked) / float64(s.Sent)
+		}
+		clickStatsResult = append(clickStatsResult, s)
+	}
+	return clickStatsResult, nil
+}
+
 // // CheckNothingSent - check to see if no messages have been sent for companies in a set time period
 // func CheckNothingSent(db *sql.DB, hoursBack int, partnerID int) ([]NothingSentResult, error) {
 // 	var qry = "SELECT c.id AS client_id, c.name AS client_name" +
@@ -1469,6 +1726,19 @@ func CheckNothingSent(daysBack int, partnerID int) ([]NothingSentResult, error)
 	return nothingSentResults, nil
 }
 
+// ConfigTokenForPartner - check the config token is for one of the partner's clients
+func ConfigTokenForPartner(token string, partnerID int) bool {
+	const qry = "SELECT COUNT(*) FROM google_reviews_configs AS config" +
+		" JOIN clients AS c ON c.id = config.client_id" +
+		" WHERE config.token = ? AND c.partner_id = ?"
+	var count int
+	if err := Db.QueryRow(qry, token, partnerID).Scan(&count); err != nil {
+		log.Printf("Error checking config token for partner ID: %d, err: %v\n", partnerID, err)
+		return false
+	}
+	return count > 0
+}
+
 // ListAllUsers - get all the users for a specific partner
 func ListAllUsers(partnerID int) ([]UserClientList, error) {
 	var err error
@@ -1771,3 +2041,97 @@ func DeleteUser(userID int, partnerID int) error {
 	}
 	return nil
 }
+
+// telephoneHashKey - key of the telephone hashes (see SetTelephoneHashKey)
+var telephoneHashKey []byte
+
+// SetTelephoneHashKey - set the key the telephones are hashed with (HMAC-SHA-256), it has to be the telephone hash key
+// of google_reviews (the UI refuses to start without it)
+// NOTE: keep in line with google_reviews/utils/telephone_utils.go
+func SetTelephoneHashKey(key string) {
+	telephoneHashKey = []byte(key)
+}
+
+// normaliseTelephone - the telephone as stored (E.164 format without the +), the telephone should be in
+// international format e.g. +447123456789 or 00447123456789
+func normaliseTelephone(telephone string) string {
+	tel := strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, telephone)
+	return strings.TrimPrefix(tel, "00")
+}
+
+// hashTelephone - hash the telephone as stored in the last sents and message events (HMAC-SHA-256 hex with the
+// telephone hash key of the telephone in E.164 format without the +, see normaliseTelephone)
+func hashTelephone(telephone string) string {
+	tel := normaliseTelephone(telephone)
+	if tel == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, telephoneHashKey)
+	mac.Write([]byte(tel))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// unkeyedHashTelephone - SHA-256 hash (hex) of the telephone, the hash of the message events recorded before the
+// telephone hash key was set (see google_reviews HashStoredTelephones)
+func unkeyedHashTelephone(telephone string) string {
+	tel := normaliseTelephone(telephone)
+	if tel == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(tel))
+	return hex.EncodeToString(h[:])
+}
+
+// MaskTelephone - the telephone masked for logging, only the last 3 characters are shown e.g. *********789
+// (a telephone of 4 or fewer characters is masked completely)
+// NOTE: keep in line with google_reviews/utils/telephone_utils.go
+func MaskTelephone(telephone string) string {
+	r := []rune(telephone)
+	if len(r) <= 4 {
+		return strings.Repeat("*", len(r))
+	}
+	return strings.Repeat("*", len(r)-3) + string(r[len(r)-3:])
+}
+
+// MessageEvents - get the message events for a telephone (and optionally a client) for a specific partner,
+// used to find out why a message was or wasn't sent
+func MessageEvents(messageEventsRequest MessageEventsRequest, partnerID int) ([]MessageEvent, error) {
+	telephoneHash := hashTelephone(messageEventsRequest.Telephone)
+	if telephoneHash == "" {
+		return nil, errors.New("telephone is required")
+	}
+	// the message events not yet rehashed with the telephone hash key are also found
+	qry := "SELECT e.id, e.client_id, c.name, e.channel, e.reason, e.provider_response, e.latency_ms, e.created" +
+		" FROM google_reviews_message_events AS e" +
+		" JOIN clients AS c ON c.id = e.client_id" +
+		" WHERE e.telephone_hash IN (?, ?)" +
+		" AND c.partner_id = ?" +
+		" AND e.created BETWEEN ? AND ?"
+	args := []interface{}{telephoneHash, unkeyedHashTelephone(messageEventsRequest.Telephone), partnerID,
+		messageEventsRequest.StartDay, messageEventsRequest.EndDay}
+	if messageEventsRequest.ClientID != 0 {
+		qry += " AND e.client_id = ?"
+		args = append(args, messageEventsRequest.ClientID)
+	}
+	qry += " ORDER BY e.created DESC, e.id DESC"
+	rows, err := Db.Query(qry, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+	var messageEvents []MessageEvent
+	for rows.Next() {
+		var e MessageEvent
+		if err := rows.Scan(&e.ID, &e.ClientID, &e.ClientName, &e.Channel, &e.Reason, &e.ProviderResponse, &e.LatencyMs, &e.Created); err != nil {
+			log.Printf("Error getting message events: %v\n", err)
+		}
+		messageEvents = append(messageEvents, e)
+	}
+	return messageEvents, nil
+}
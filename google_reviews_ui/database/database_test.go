@@ -1,13 +1,19 @@
 package database
 
 import (
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	testfixtures "gopkg.in/testfixtures.v2"
+
+	"google_reviews_ui/secrets"
 )
 
 var fixtures *testfixtures.Context
@@ -133,6 +139,8 @@ func TestUpdateSimpleClient(t *testing.T) {
 	simpleConfig.GoogleMyBusinessFiveStarRatingReply = "Reply for star rating 5"
 	simpleConfig.GoogleMyBusinessReportEnabled = false
 	simpleConfig.EmailAddress = "test1@test.com"
+	simpleConfig.GoogleReviewsConfigReviewLink = "https://g.page/r/test/review"
+	simpleConfig.GoogleReviewsConfigOptOutLink = "https://example.com/stop"
 	simpleConfig.GoogleReviewsConfigTimeID = 1
 	simpleConfig.GoogleReviewsConfigTimeEnabled = true
 	simpleConfig.GoogleReviewsConfigTimeStart = "09:00"
@@ -160,6 +168,71 @@ func TestUpdateSimpleClient(t *testing.T) {
 	if s.EmailAddress != "test1@test.com" {
 		t.Fatal("error updating simple config for client, has not changed")
 	}
+	if s.GoogleReviewsConfigReviewLink != "https://g.page/r/test/review" || s.GoogleReviewsConfigOptOutLink != "https://example.com/stop" {
+		t.Fatal("error updating simple config for client, links have not changed")
+	}
+}
+
+func TestUpdateSimpleClientWriteOnlySecrets(t *testing.T) {
+	prepareTestDatabase()
+	keyFile := filepath.Join(t.TempDir(), "secrets.key")
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyProvider, err := secrets.NewFileKeyProvider(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.SetKeyProvider(keyProvider)
+	defer secrets.SetKeyProvider(nil)
+
+	simpleConfig, err := GetSimpleClient(1, 1)
+	if err != nil {
+		t.Fatal("error fetching simple config for client, err: ", err)
+	}
+	simpleConfig.GoogleReviewsConfigAppKey = "app-key"
+	simpleConfig.GoogleReviewsConfigSecretKey = "secret-key"
+	simpleConfig.GoogleReviewsConfigWhatsAppAccessToken = "whatsapp-access-token"
+	if err := UpdateSimpleClient(simpleConfig); err != nil {
+		t.Fatal("error updating simple config for client, err: ", err)
+	}
+	storedSecrets := func() (string, string, string) {
+		var appKey, secretKey, whatsAppAccessToken string
+		if err := Db.QueryRow("SELECT app_key, secret_key, whatsapp_access_token FROM google_reviews_configs WHERE id = ?",
+			simpleConfig.GoogleReviewsConfigID).Scan(&appKey, &secretKey, &whatsAppAccessToken); err != nil {
+			t.Fatal(err)
+		}
+		return appKey, secretKey, whatsAppAccessToken
+	}
+	appKey, secretKey, whatsAppAccessToken := storedSecrets()
+	if !secrets.IsEncrypted(appKey) || !secrets.IsEncrypted(secretKey) || !secrets.IsEncrypted(whatsAppAccessToken) {
+		t.Fatalf("expected the secrets stored encrypted, got: %s %s %s", appKey, secretKey, whatsAppAccessToken)
+	}
+	if decrypted, err := secrets.Decrypt(appKey); err != nil || decrypted != "app-key" {
+		t.Fatalf("expected app-key, got: %s, err: %v", decrypted, err)
+	}
+
+	// the secrets are not returned, an empty secret keeps the stored secret
+	simpleConfig, err = GetSimpleClient(1, 1)
+	if err != nil {
+		t.Fatal("error fetching simple config for client, err: ", err)
+	}
+	if simpleConfig.GoogleReviewsConfigAppKey != "" || simpleConfig.GoogleReviewsConfigSecretKey != "" ||
+		simpleConfig.GoogleReviewsConfigWhatsAppAccessToken != "" || !simpleConfig.GoogleReviewsConfigAppKeySet ||
+		!simpleConfig.GoogleReviewsConfigSecretKeySet || !simpleConfig.GoogleReviewsConfigWhatsAppAccessTokenSet {
+		t.Fatalf("expected the secrets set and not returned, got: %+v", simpleConfig)
+	}
+	simpleConfig.GoogleReviewsConfigAppKey = "new-app-key"
+	if err := UpdateSimpleClient(simpleConfig); err != nil {
+		t.Fatal("error updating simple config for client, err: ", err)
+	}
+	newAppKey, newSecretKey, newWhatsAppAccessToken := storedSecrets()
+	if decrypted, _ := secrets.Decrypt(newAppKey); decrypted != "new-app-key" {
+		t.Fatalf("expected new-app-key, got: %s", decrypted)
+	}
+	if newSecretKey != secretKey || newWhatsAppAccessToken != whatsAppAccessToken {
+		t.Fatal("expected the stored secret key and WhatsApp access token kept")
+	}
 }
 
 func TestCreateSimpleClient(t *testing.T) {
@@ -669,6 +742,16 @@ func TestStatsNew1(t *testing.T) {
 		t.Fatal("error getting stats, err: ", err)
 	}
 	fmt.Printf("stats: %+v\n", s)
+	// the delivered and failed counts (from the delivery reports) of 40 days ago
+	found := false
+	for _, r := range s {
+		if r.Sent == 20 && r.Requested == 40 {
+			found = r.Delivered == 18 && r.Failed == 2
+		}
+	}
+	if !found {
+		t.Fatalf("expected the delivered and failed counts got: %+v", s)
+	}
 }
 
 func TestStatsNew2(t *testing.T) {
@@ -736,6 +819,19 @@ func TestCheckNothingSent1(t *testing.T) {
 	fmt.Printf("stats: %+v\n", s)
 }
 
+func TestConfigTokenForPartner(t *testing.T) {
+	prepareTestDatabase()
+	if !ConfigTokenForPartner("QxrH0iJc3wv/lj/YKVppNYRad7tN0Z3x", 1) {
+		t.Fatal("config token should be for partner 1")
+	}
+	if ConfigTokenForPartner("QxrH0iJc3wv/lj/YKVppNYRad7tN0Z3x", 2) {
+		t.Fatal("config token should not be for partner 2")
+	}
+	if ConfigTokenForPartner("incorrect", 1) {
+		t.Fatal("incorrect config token should not be found")
+	}
+}
+
 func TestListAllUsers(t *testing.T) {
 	prepareTestDatabase()
 	userClients, err := ListAllUsers(1)
@@ -871,3 +967,145 @@ func TestDeleteUserWrongPartner(t *testing.T) {
 		t.Fatalf("error: %v\n", err)
 	}
 }
+
+func TestMessageEvents(t *testing.T) {
+	prepareTestDatabase()
+	var messageEventsRequest MessageEventsRequest
+	messageEventsRequest.Telephone = "+44 7123 456789"
+	messageEventsRequest.StartDay = time.Now().Add(-time.Hour * 24 * 7).Format("2006-01-02")
+	messageEventsRequest.EndDay = time.Now().Add(time.Hour * 24).Format("2006-01-02")
+	events, err := MessageEvents(messageEventsRequest, 1)
+	if err != nil {
+		t.Fatal("error getting message events, err: ", err)
+	}
+	// client 3 is for partner 2 so should not be returned
+	if len(events) != 2 || events[0].Reason != "too_recent" || events[1].Reason != "sent" {
+		t.Fatalf("unexpected message events: %+v", events)
+	}
+	messageEventsRequest.ClientID = 2
+	events, err = MessageEvents(messageEventsRequest, 1)
+	if err != nil || len(events) != 0 {
+		t.Fatalf("expected no message events for client 2 got: %+v, err: %v", events, err)
+	}
+	messageEventsRequest.Telephone = ""
+	if _, err := MessageEvents(messageEventsRequest, 1); err == nil {
+		t.Fatal("expected error for empty telephone")
+	}
+}
+
+func TestClickStats(t *testing.T) {
+	prepareTestDatabase()
+	var statsRequest StatsRequest
+	statsRequest.StartDay = time.Now().Add(-time.Hour * 24 * 7).Format("2006-01-02")
+	statsRequest.EndDay = time.Now().Format("2006-01-02") + " 23:59:59"
+	stats, err := ClickStats(statsRequest, 1)
+	if err != nil {
+		t.Fatal("error getting click stats, err: ", err)
+	}
+	// client 3 is for partner 2 and short links not sent should not be counted
+	if len(stats) != 2 {
+		t.Fatalf("unexpected click stats: %+v", stats)
+	}
+	if stats[0].ClientID != 1 || stats[0].Variant != "1" || stats[0].Sent != 1 || stats[0].Clicked != 1 || stats[0].Clicks != 2 || stats[0].ClickThroughRate != 1 {
+		t.Fatalf("unexpected click stats for variant 1: %+v", stats[0])
+	}
+	if stats[1].ClientID != 1 || stats[1].Variant != "2" || stats[1].Sent != 1 || stats[1].Clicked != 0 || stats[1].ClickThroughRate != 0 {
+		t.Fatalf("unexpected click stats for variant 2: %+v", stats[1])
+	}
+}
+
+func TestHashTelephone(t *testing.T) {
+	SetTelephoneHashKey("test-telephone-hash-key")
+	defer SetTelephoneHashKey("")
+	// should match the keyed hash used by google_reviews (HMAC-SHA-256 of the E.164 telephone without the +)
+	h := hashTelephone("+447123456789")
+	if h != "0a800f4e9ae5a0d93615f799fc031626471643c7fa77957cc7ad38e920996f04" {
+		t.Fatalf("unexpected telephone hash: %s", h)
+	}
+	if h != hashTelephone("00447123456789") || h != hashTelephone("+44 7123 456789") {
+		t.Fatal("telephone in different international formats should have the same hash")
+	}
+	if hashTelephone("") != "" {
+		t.Fatal("empty telephone should have an empty hash")
+	}
+}
+
+func TestUnkeyedHashTelephone(t *testing.T) {
+	// should match the hash recorded by google_reviews before the telephone hash key was set (SHA-256 of the E.164
+	// telephone without the +)
+	h := unkeyedHashTelephone("+447123456789")
+	if h != "390fa2f26ecf6ff60e151d2011b1a091840784758531031970a261ca1f3736a9" {
+		t.Fatalf("unexpected unkeyed telephone hash: %s", h)
+	}
+	if h != unkeyedHashTelephone("00447123456789") || unkeyedHashTelephone("") != "" {
+		t.Fatal("unexpected unkeyed telephone hash")
+	}
+}
+
+func TestVariantResults(t *testing.T) {
+	prepareTestDatabase()
+	var variantResultsRequest VariantResultsRequest
+	variantResultsRequest.GoogleReviewsConfigID = 4
+	variantResultsRequest.StartDay = time.Now().Add(-time.Hour * 24 * 7).Format("2006-01-02")
+	variantResultsRequest.EndDay = time.Now().Format("2006-01-02") + " 23:59:59"
+	// config 4 is for partner 2
+	if _, err := VariantResults(variantResultsRequest, 1); err == nil {
+		t.Fatal("expected error for a config of another partner")
+	}
+	results, err := VariantResults(variantResultsRequest, 2)
+	if err != nil {
+		t.Fatal("error getting variant results, err: ", err)
+	}
+	if len(results) != 3 || results[0].Variant != "short" || results[1].Variant != "friendly" || results[2].Variant != "old" {
+		t.Fatalf("unexpected variant results: %+v", results)
+	}
+	if results[0].Sent != 4 || results[0].Clicked != 1 || results[0].OptOuts != 0 || results[0].ClickThroughRate != 0.25 {
+		t.Fatalf("unexpected variant results for short: %+v", results[0])
+	}
+	if results[1].Sent != 4 || results[1].Clicked != 3 || results[1].OptOuts != 1 || results[1].ClickThroughRate != 0.75 || results[1].OptOutRate != 0.25 {
+		t.Fatalf("unexpected variant results for friendly: %+v", results[1])
+	}
+	if results[1].ZScore <= 0 || results[1].Significant {
+		t.Fatalf("expected a higher but not significant click through rate for friendly: %+v", results[1])
+	}
+	if results[2].Sent != 0 || results[2].ZScore != 0 {
+		t.Fatalf("unexpected variant results for old: %+v", results[2])
+	}
+}
+
+func TestClickThroughZScore(t *testing.T) {
+	if z, significant := clickThroughZScore(100, 1000, 150, 1000); z < 3.3 || z > 3.4 || !significant {
+		t.Fatalf("expected significant z score of about 3.38 got: %f", z)
+	}
+	if z, significant := clickThroughZScore(150, 1000, 100, 1000); z > -3.3 || !significant {
+		t.Fatalf("expected significant negative z score got: %f", z)
+	}
+	if _, significant := clickThroughZScore(10, 100, 11, 100); significant {
+		t.Fatal("expected small difference not to be significant")
+	}
+	if z, significant := clickThroughZScore(0, 0, 1, 10); z != 0 || significant {
+		t.Fatal("expected no z score without sends for the control")
+	}
+}
+
+func TestValidateMessageVariants(t *testing.T) {
+	valid := []MessageVariant{{Name: "short", Message: "Please review us {review_link}"}, {Name: "friendly", Message: "Hi {first_name|there}, please review us {review_link}"}}
+	if err := validateMessageVariants(valid); err != nil {
+		t.Fatal("unexpected error for valid variants, err: ", err)
+	}
+	if err := validateMessageVariants(append(valid, MessageVariant{Name: "short", Message: "Again"})); err == nil {
+		t.Fatal("expected error for duplicate variant name")
+	}
+	if err := validateMessageVariants([]MessageVariant{{Name: " ", Message: "Hi"}}); err == nil {
+		t.Fatal("expected error for empty variant name")
+	}
+	if err := validateMessageVariants([]MessageVariant{{Name: "bad", Message: "Hi {unknown}"}}); err == nil {
+		t.Fatal("expected error for unknown placeholder")
+	}
+	if err := validateMessageVariants([]MessageVariant{{Name: "nl_short", Language: " NL ", Message: "Beoordeel ons {review_link}"}}); err != nil {
+		t.Fatal("unexpected error for a valid variant language, err: ", err)
+	}
+	if err := validateMessageVariants([]MessageVariant{{Name: "dutch", Language: "dutch", Message: "Beoordeel ons"}}); err == nil {
+		t.Fatal("expected error for invalid variant language")
+	}
+}
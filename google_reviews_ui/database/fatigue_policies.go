@@ -0,0 +1,104 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// maxFatiguePolicyDays - maximum days of a fatigue policy
+const maxFatiguePolicyDays = 365
+
+// FatiguePolicy - represents a limit of the review requests to a passenger across clients, at most max requests
+// clients send a review request to a telephone in days days. The policy of partner id 0 is for all clients (counting
+// the review requests of all clients), the policy of a partner is for the partner's clients (counting the review
+// requests of the partner's clients), both are checked by google_reviews and google_reviews_autocab.
+type FatiguePolicy struct {
+	PartnerID   int       `json:"partner_id"`   // partner id (0 for all clients)
+	MaxRequests uint      `json:"max_requests"` // maximum clients sending a review request to a telephone in the days
+	Days        uint      `json:"days"`         // days the review requests are counted over
+	UpdatedBy   string    `json:"updated_by"`   // user that last updated the policy
+	Updated     time.Time `json:"updated"`      // updated
+}
+
+// FatiguePolicies - get the fatigue policies for all clients and for the clients of the partner
+func FatiguePolicies(partnerID int) ([]FatiguePolicy, error) {
+	const qry = "SELECT partner_id, max_requests, days, updated_by, updated" +
+		" FROM google_reviews_fatigue_policies" +
+		" WHERE partner_id = 0 OR partner_id = ?" +
+		" ORDER BY partner_id"
+	policies := make([]FatiguePolicy, 0)
+	rows, err := Db.Query(qry, partnerID)
+	if err != nil {
+		log.Println(err)
+		return policies, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p FatiguePolicy
+		if err := rows.Scan(&p.PartnerID, &p.MaxRequests, &p.Days, &p.UpdatedBy, &p.Updated); err != nil {
+			log.Printf("Error getting fatigue policies: %v\n", err)
+			return policies, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// validateFatiguePolicy - check the policy is for all clients when the partner can manage the fatigue policy for all
+// clients or is for the partner, and limits the review requests
+func validateFatiguePolicy(policy FatiguePolicy, partnerID int, manageGlobal bool) error {
+	if policy.PartnerID == 0 && !manageGlobal {
+		return errors.New("not allowed to manage the fatigue policy for all clients")
+	}
+	if policy.PartnerID != 0 && policy.PartnerID != partnerID {
+		return errors.New("not allowed to manage the fatigue policy of another partner")
+	}
+	if policy.MaxRequests == 0 {
+		return errors.New("max requests must be at least 1")
+	}
+	if policy.Days == 0 || policy.Days > maxFatiguePolicyDays {
+		return errors.New("days must be between 1 and 365")
+	}
+	return nil
+}
+
+// SetFatiguePolicy - add or replace the fatigue policy of the partner, or for all clients (partner id 0) when the
+// partner can manage the fatigue policy for all clients
+func SetFatiguePolicy(policy FatiguePolicy, partnerID int, manageGlobal bool) error {
+	const qry = "INSERT INTO google_reviews_fatigue_policies" +
+		" (partner_id, max_requests, days, updated_by, updated)" +
+		" VALUES (?, ?, ?, ?, NOW())" +
+		" ON DUPLICATE KEY UPDATE" +
+		" max_requests = VALUES(max_requests)," +
+		" days = VALUES(days)," +
+		" updated_by = VALUES(updated_by)," +
+		" updated = NOW()"
+
+	if err := validateFatiguePolicy(policy, partnerID, manageGlobal); err != nil {
+		return err
+	}
+	if _, err := Db.Exec(qry, policy.PartnerID, policy.MaxRequests, policy.Days, policy.UpdatedBy); err != nil {
+		log.Printf("Error setting the fatigue policy of partner ID: %d, err: %v\n", policy.PartnerID, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteFatiguePolicy - remove the fatigue policy of the partner, or for all clients (policy partner id 0) when the
+// partner can manage the fatigue policy for all clients
+func DeleteFatiguePolicy(policyPartnerID int, partnerID int, manageGlobal bool) error {
+	const qry = "DELETE FROM google_reviews_fatigue_policies WHERE partner_id = ?"
+
+	if policyPartnerID == 0 && !manageGlobal {
+		return errors.New("not allowed to remove the fatigue policy for all clients")
+	}
+	if policyPartnerID != 0 && policyPartnerID != partnerID {
+		return errors.New("Fatigue policy cannot be found")
+	}
+	if _, err := Db.Exec(qry, policyPartnerID); err != nil {
+		log.Printf("delete failed: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestValidateFatiguePolicy(t *testing.T) {
+	tests := []struct {
+		policy       FatiguePolicy
+		manageGlobal bool
+		valid        bool
+	}{
+		{FatiguePolicy{PartnerID: 1, MaxRequests: 2, Days: 7}, false, true},
+		{FatiguePolicy{PartnerID: 0, MaxRequests: 2, Days: 7}, true, true},
+		{FatiguePolicy{PartnerID: 0, MaxRequests: 2, Days: 7}, false, false},
+		{FatiguePolicy{PartnerID: 2, MaxRequests: 2, Days: 7}, true, false},
+		{FatiguePolicy{PartnerID: 1, MaxRequests: 0, Days: 7}, false, false},
+		{FatiguePolicy{PartnerID: 1, MaxRequests: 2, Days: 0}, false, false},
+		{FatiguePolicy{PartnerID: 1, MaxRequests: 2, Days: 366}, false, false},
+	}
+	for _, tt := range tests {
+		err := validateFatiguePolicy(tt.policy, 1, tt.manageGlobal)
+		if (err == nil) != tt.valid {
+			t.Errorf("policy: %+v manage global: %t err: %v expected valid %t", tt.policy, tt.manageGlobal, err, tt.valid)
+		}
+	}
+}
+
+func TestFatiguePolicies(t *testing.T) {
+	prepareTestDatabase()
+	// all clients and partner 2
+	policies, err := FatiguePolicies(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 || policies[0].PartnerID != 0 || policies[0].MaxRequests != 3 || policies[1].PartnerID != 2 || policies[1].Days != 7 {
+		t.Fatalf("unexpected fatigue policies: %+v", policies)
+	}
+	if err := SetFatiguePolicy(FatiguePolicy{PartnerID: 1, MaxRequests: 2, Days: 14, UpdatedBy: "test@testing.com"}, 1, false); err != nil {
+		t.Fatal("error setting the fatigue policy, err: ", err)
+	}
+	if err := SetFatiguePolicy(FatiguePolicy{PartnerID: 1, MaxRequests: 1, Days: 10, UpdatedBy: "test@testing.com"}, 1, false); err != nil {
+		t.Fatal("error replacing the fatigue policy, err: ", err)
+	}
+	if policies, _ = FatiguePolicies(1); len(policies) != 2 || policies[1].PartnerID != 1 || policies[1].MaxRequests != 1 || policies[1].Days != 10 {
+		t.Fatalf("unexpected fatigue policies after set: %+v", policies)
+	}
+	if err := DeleteFatiguePolicy(0, 1, false); err == nil {
+		t.Fatal("expected error removing the fatigue policy for all clients without manage global")
+	}
+	if err := DeleteFatiguePolicy(2, 1, true); err == nil {
+		t.Fatal("expected error removing the fatigue policy of another partner")
+	}
+	if err := DeleteFatiguePolicy(1, 1, false); err != nil {
+		t.Fatal("error removing the fatigue policy, err: ", err)
+	}
+	if policies, _ = FatiguePolicies(1); len(policies) != 1 || policies[0].PartnerID != 0 {
+		t.Fatalf("unexpected fatigue policies after remove: %+v", policies)
+	}
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"google_reviews_ui/hook"
+)
+
+// HookMapping - represents the mapping of the generic dispatcher webhook (/hook/<token>) of a config, a JSON object
+// of field to JSONPath like expression e.g. {"telephone":"$.passenger.phone","booking_id":"$.id"} (see the hook
+// package), an empty mapping disables the webhook.
+type HookMapping struct {
+	GoogleReviewsConfigID uint64 `json:"google_reviews_config_id"` // google reviews config id
+	Mapping               string `json:"mapping"`                  // field mapping
+}
+
+// normaliseHookMapping - check the mapping can be parsed, returns it compacted (empty when not set)
+func normaliseHookMapping(mapping string) (string, error) {
+	mapping = strings.TrimSpace(mapping)
+	if mapping == "" {
+		return "", nil
+	}
+	if _, err := hook.ParseMapping(mapping); err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(mapping)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// GetHookMapping - get the hook mapping of a config
+func GetHookMapping(configID int, partnerID int) (HookMapping, error) {
+	const qry = "SELECT COALESCE(hook_mapping, '') FROM google_reviews_configs WHERE id = ?"
+	hm := HookMapping{GoogleReviewsConfigID: uint64(configID)}
+	if _, err := configClientID(configID, partnerID); err != nil {
+		return hm, err
+	}
+	if err := Db.QueryRow(qry, configID).Scan(&hm.Mapping); err != nil {
+		log.Printf("Error getting hook mapping of config ID: %d, err: %v\n", configID, err)
+		return hm, err
+	}
+	return hm, nil
+}
+
+// UpdateHookMapping - update the hook mapping of a config once validated, returns the updated hook mapping
+func UpdateHookMapping(hookMapping HookMapping, partnerID int) (HookMapping, error) {
+	const qry = "UPDATE google_reviews_configs SET hook_mapping = NULLIF(?, '') WHERE id = ?"
+	configID := int(hookMapping.GoogleReviewsConfigID)
+	current, err := GetHookMapping(configID, partnerID)
+	if err != nil {
+		return current, err
+	}
+	mapping, err := normaliseHookMapping(hookMapping.Mapping)
+	if err != nil {
+		return current, err
+	}
+	if _, err := Db.Exec(qry, mapping, configID); err != nil {
+		log.Printf("Error updating hook mapping of config ID: %d, err: %v\n", configID, err)
+		return current, err
+	}
+	return HookMapping{GoogleReviewsConfigID: uint64(configID), Mapping: mapping}, nil
+}
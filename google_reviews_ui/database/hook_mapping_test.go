@@ -0,0 +1,47 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestNormaliseHookMapping(t *testing.T) {
+	tests := []struct {
+		mapping string
+		want    string
+		valid   bool
+	}{
+		{"  ", "", true},
+		{`{ "telephone": "$.passenger.phone",  "booking_id": "$.id" }`, `{"telephone":"$.passenger.phone","booking_id":"$.id"}`, true},
+		{`{"booking_id":"$.id"}`, "", false},
+		{`{"telephone":"$.phones[x]"}`, "", false},
+	}
+	for _, tt := range tests {
+		mapping, err := normaliseHookMapping(tt.mapping)
+		if (err == nil) != tt.valid || mapping != tt.want {
+			t.Errorf("mapping: %s normalised: %s, err: %v want: %s", tt.mapping, mapping, err, tt.want)
+		}
+	}
+}
+
+func TestHookMapping(t *testing.T) {
+	prepareTestDatabase()
+	hm, err := UpdateHookMapping(HookMapping{GoogleReviewsConfigID: 1, Mapping: `{"telephone": "$.passenger.phone"}`}, 1)
+	if err != nil || hm.Mapping != `{"telephone":"$.passenger.phone"}` {
+		t.Fatalf("unexpected hook mapping: %+v, err: %v", hm, err)
+	}
+	if hm, err = GetHookMapping(1, 1); err != nil || hm.Mapping != `{"telephone":"$.passenger.phone"}` {
+		t.Fatalf("unexpected stored hook mapping: %+v, err: %v", hm, err)
+	}
+	// an invalid mapping is not stored
+	if _, err := UpdateHookMapping(HookMapping{GoogleReviewsConfigID: 1, Mapping: `{"company":"$.company"}`}, 1); err == nil {
+		t.Error("expected an error updating with a mapping without a telephone or passenger ID")
+	}
+	// an empty mapping disables the webhook
+	if hm, err = UpdateHookMapping(HookMapping{GoogleReviewsConfigID: 1}, 1); err != nil || hm.Mapping != "" {
+		t.Fatalf("unexpected hook mapping: %+v, err: %v", hm, err)
+	}
+	// config of another partner
+	if _, err := GetHookMapping(3, 1); err == nil {
+		t.Error("expected an error getting the hook mapping of a config of another partner")
+	}
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"strings"
+)
+
+// message channels of a config, messages are sent by SMS unless WhatsApp is preferred
+// NOTE: keep in line with google_reviews/sender/whatsapp.go
+const (
+	MessageChannelSMS      = "SMS"
+	MessageChannelWhatsApp = "WhatsApp"
+)
+
+// messageChannel - the message channel to store, SMS when not set or unknown
+func messageChannel(channel string) string {
+	if strings.EqualFold(strings.TrimSpace(channel), MessageChannelWhatsApp) {
+		return MessageChannelWhatsApp
+	}
+	return MessageChannelSMS
+}
+
+// defaultEmailParameter - the email address parameter when none is set
+const defaultEmailParameter = "email"
+
+// emailParameter - the email address parameter to store, the default parameter when not set
+func emailParameter(parameter string) string {
+	if parameter = strings.TrimSpace(parameter); parameter != "" {
+		return parameter
+	}
+	return defaultEmailParameter
+}
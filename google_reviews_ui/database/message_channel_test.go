@@ -0,0 +1,34 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestMessageChannel(t *testing.T) {
+	tests := map[string]string{
+		"":           MessageChannelSMS,
+		"SMS":        MessageChannelSMS,
+		"WhatsApp":   MessageChannelWhatsApp,
+		" whatsapp ": MessageChannelWhatsApp,
+		"Email":      MessageChannelSMS,
+	}
+	for channel, expected := range tests {
+		if c := messageChannel(channel); c != expected {
+			t.Errorf("channel: %q, expected %s got %s", channel, expected, c)
+		}
+	}
+}
+
+func TestEmailParameter(t *testing.T) {
+	tests := map[string]string{
+		"":                 defaultEmailParameter,
+		"  ":               defaultEmailParameter,
+		"email":            "email",
+		" customer_email ": "customer_email",
+	}
+	for parameter, expected := range tests {
+		if p := emailParameter(parameter); p != expected {
+			t.Errorf("parameter: %q, expected %s got %s", parameter, expected, p)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// messageTemplatePlaceholders - placeholders that can be used in a message, a default can be given e.g. {first_name|there}
+// NOTE: keep in line with the placeholders in google_reviews/utils/message_template_utils.go
+var messageTemplatePlaceholders = map[string]bool{
+	"first_name":   true,
+	"driver_name":  true,
+	"pickup_time":  true,
+	"company":      true,
+	"review_link":  true,
+	"opt_out_link": true,
+}
+
+// messageTemplatePlaceholderRegexp - matches {name} and {name|default}
+var messageTemplatePlaceholderRegexp = regexp.MustCompile(`\{([^{}|]*)(\|[^{}]*)?\}`)
+
+// validateMessageTemplate - check the placeholders used in a message are known and the braces are balanced
+func validateMessageTemplate(message string) error {
+	for _, m := range messageTemplatePlaceholderRegexp.FindAllStringSubmatch(message, -1) {
+		if !messageTemplatePlaceholders[m[1]] {
+			return fmt.Errorf("unknown message placeholder {%s}", m[1])
+		}
+	}
+	rest := messageTemplatePlaceholderRegexp.ReplaceAllString(message, "")
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("message has an unclosed or nested placeholder brace")
+	}
+	return nil
+}
+
+// messagePreviewValues - sample values of the placeholders used to preview a message, the links of the config are used
+// when set
+func messagePreviewValues(reviewLink string, optOutLink string) map[string]string {
+	values := map[string]string{
+		"first_name":   "Alex",
+		"driver_name":  "Sam",
+		"pickup_time":  "14:30",
+		"company":      "Example Cars",
+		"review_link":  "https://g.page/r/example/review",
+		"opt_out_link": "https://example.com/opt-out",
+	}
+	if reviewLink != "" {
+		values["review_link"] = reviewLink
+	}
+	if optOutLink != "" {
+		values["opt_out_link"] = optOutLink
+	}
+	return values
+}
+
+// previewMessageTemplate - replace the placeholders in the message with the values, unknown placeholders are left as is
+func previewMessageTemplate(message string, values map[string]string) string {
+	return messageTemplatePlaceholderRegexp.ReplaceAllStringFunc(message, func(p string) string {
+		m := messageTemplatePlaceholderRegexp.FindStringSubmatch(p)
+		if v, ok := values[m[1]]; ok {
+			return v
+		}
+		return p
+	})
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestValidateMessageTemplate(t *testing.T) {
+	tests := []struct {
+		message string
+		valid   bool
+	}{
+		{"Thank you for travelling with us", true},
+		{"Hi {first_name}, how was {driver_name}? {review_link} Reply STOP {opt_out_link}", true},
+		{"Your {pickup_time} trip with {company}", true},
+		{"Hi {first_name|there}, thanks", true},
+		{"Hi {name}, thanks", false},
+		{"Hi {First_Name}, thanks", false},
+		{"Hi {}, thanks", false},
+		{"Hi {first_name, thanks", false},
+		{"Hi first_name}, thanks", false},
+		{"Hi {{first_name}}, thanks", false},
+	}
+	for _, tt := range tests {
+		err := validateMessageTemplate(tt.message)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateMessageTemplate(%q) = %v expected valid %t", tt.message, err, tt.valid)
+		}
+	}
+}
+
+func TestPreviewMessageTemplate(t *testing.T) {
+	values := messagePreviewValues("https://g.page/r/test/review", "")
+	got := previewMessageTemplate("Hi {first_name|there}, review {company} {review_link} {opt_out_link} {unknown}", values)
+	if got != "Hi Alex, review Example Cars https://g.page/r/test/review https://example.com/opt-out {unknown}" {
+		t.Fatalf("unexpected message preview: %s", got)
+	}
+}
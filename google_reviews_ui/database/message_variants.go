@@ -0,0 +1,341 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+)
+
+// variantSignificanceZ - z score for a click through rate difference to be significant (95% two tailed)
+const variantSignificanceZ = 1.96
+
+// MessageVariant - represents a named message variant of a config used for A/B testing messages.
+type MessageVariant struct {
+	ID                    uint64 `json:"id"`                       // id
+	GoogleReviewsConfigID uint64 `json:"google_reviews_config_id"` // google reviews config id
+	Enabled               bool   `json:"enabled"`                  // enabled
+	Name                  string `json:"name"`                     // name recorded with each message sent
+	Language              string `json:"language"`                 // language of the variant, empty for a variant of the config message
+	Message               string `json:"message"`                  // message
+	Weight                uint   `json:"weight"`                   // weight used to allocate the variant
+}
+
+// MessageVariants - represents the message variants of a config.
+type MessageVariants struct {
+	GoogleReviewsConfigID uint64           `json:"google_reviews_config_id"` // google reviews config id
+	Variants              []MessageVariant `json:"variants"`                 // variants
+}
+
+// VariantResultsRequest - represents a message variant results request.
+type VariantResultsRequest struct {
+	GoogleReviewsConfigID int    `json:"google_reviews_config_id"` // google reviews config id
+	StartDay              string `json:"start_day"`                // start day
+	EndDay                string `json:"end_day"`                  // end day
+}
+
+// VariantResult - represents the results of a message variant, the significance is of the click through rate
+// compared with the first variant (the control).
+type VariantResult struct {
+	Variant          string  `json:"variant"`            // variant name (or position of the multi message)
+	Sent             uint64  `json:"sent"`               // messages sent (or to be sent later)
+	Clicked          uint64  `json:"clicked"`            // messages where the tracked short link was clicked
+	OptOuts          uint64  `json:"opt_outs"`           // opt out replies
+	ClickThroughRate float64 `json:"click_through_rate"` // click through rate (clicked / sent)
+	OptOutRate       float64 `json:"opt_out_rate"`       // opt out rate (opt outs / sent)
+	ZScore           float64 `json:"z_score"`            // z score of the click through rate compared with the control
+	Significant      bool    `json:"significant"`        // whether the click through rate difference is significant (95%)
+}
+
+// configClientID - get the client of a config checking the config is for the partner (0 if not found)
+func configClientID(configID int, partnerID int) (uint64, error) {
+	const qry = "SELECT config.client_id FROM google_reviews_configs AS config" +
+		" JOIN clients AS c ON c.id = config.client_id" +
+		" WHERE config.id = ? AND c.partner_id = ?"
+	var clientID uint64
+	if err := Db.QueryRow(qry, configID, partnerID).Scan(&clientID); err != nil {
+		log.Printf("Error getting config ID: %d for partner ID: %d, err: %v\n", configID, partnerID, err)
+		return 0, errors.New("Config cannot be found")
+	}
+	return clientID, nil
+}
+
+// GetMessageVariants - get the message variants of a config
+func GetMessageVariants(configID int, partnerID int) (MessageVariants, error) {
+	const qry = "SELECT id, google_reviews_config_id, enabled, name, language, message, weight" +
+		" FROM google_reviews_message_variants" +
+		" WHERE google_reviews_config_id = ?" +
+		" ORDER BY id"
+	mvs := MessageVariants{GoogleReviewsConfigID: uint64(configID)}
+	if _, err := configClientID(configID, partnerID); err != nil {
+		return mvs, err
+	}
+	rows, err := Db.Query(qry, configID)
+	if err != nil {
+		log.Println(err)
+		return mvs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mv MessageVariant
+		if err := rows.Scan(&mv.ID, &mv.GoogleReviewsConfigID, &mv.Enabled, &mv.Name, &mv.Language, &mv.Message, &mv.Weight); err != nil {
+			log.Printf("Error getting message variants: %v\n", err)
+			return mvs, err
+		}
+		mvs.Variants = append(mvs.Variants, mv)
+	}
+	return mvs, nil
+}
+
+// validateMessageVariants - check the variants have unique names, valid languages and valid messages
+func validateMessageVariants(variants []MessageVariant) error {
+	names := make(map[string]bool, len(variants))
+	for _, mv := range variants {
+		name := strings.TrimSpace(mv.Name)
+		if name == "" {
+			return errors.New("message variant name is required")
+		}
+		if len(name) > 50 {
+			return fmt.Errorf("message variant name %s is too long (maximum 50 characters)", name)
+		}
+		if names[name] {
+			return fmt.Errorf("message variant name %s is used more than once", name)
+		}
+		names[name] = true
+		if language := normaliseLanguage(mv.Language); language != "" {
+			if err := validateLanguage(language); err != nil {
+				return fmt.Errorf("message variant %s: %v", name, err)
+			}
+		}
+		if strings.TrimSpace(mv.Message) == "" {
+			return fmt.Errorf("message variant %s has no message", name)
+		}
+		if err := validateMessageTemplate(mv.Message); err != nil {
+			return fmt.Errorf("message variant %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// UpdateMessageVariants - replace the message variants of a config
+func UpdateMessageVariants(messageVariants MessageVariants, partnerID int) error {
+	const deleteQry = "DELETE FROM google_reviews_message_variants WHERE google_reviews_config_id = ?"
+	const insertQry = "INSERT INTO google_reviews_message_variants" +
+		" (google_reviews_config_id, enabled, name, language, message, weight)" +
+		" VALUES (?, ?, ?, ?, ?, ?)"
+
+	configID := messageVariants.GoogleReviewsConfigID
+	if _, err := configClientID(int(configID), partnerID); err != nil {
+		return err
+	}
+	if err := validateMessageVariants(messageVariants.Variants); err != nil {
+		return err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if _, execErr := tx.Exec(deleteQry, configID); execErr != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Printf("update failed: %v, unable to rollback: %v\n", execErr, rollbackErr)
+			return execErr
+		}
+		log.Printf("update failed: %v", execErr)
+		return execErr
+	}
+	for _, mv := range messageVariants.Variants {
+		_, execErr := tx.Exec(insertQry, configID, mv.Enabled, strings.TrimSpace(mv.Name), normaliseLanguage(mv.Language),
+			strings.TrimSpace(mv.Message), mv.Weight)
+		if execErr != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Printf("update failed: %v, unable to rollback: %v\n", execErr, rollbackErr)
+				return execErr
+			}
+			log.Printf("update failed: %v", execErr)
+			return execErr
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// PromoteMessageVariant - make the message variant the config message (used from the database) and end the A/B test
+// by disabling the message variants and multi message of the config, a variant of a language is made the message of
+// the language and the message variants of the language are disabled
+func PromoteMessageVariant(variantID int, partnerID int) error {
+	const variantQry = "SELECT v.google_reviews_config_id, v.language, v.message" +
+		" FROM google_reviews_message_variants AS v" +
+		" JOIN google_reviews_configs AS config ON config.id = v.google_reviews_config_id" +
+		" JOIN clients AS c ON c.id = config.client_id" +
+		" WHERE v.id = ? AND c.partner_id = ?"
+	const configQry = "UPDATE google_reviews_configs SET message = ?, use_database_message = 1, multi_message_enabled = 0" +
+		" WHERE id = ?"
+	const configMessageQry = "INSERT INTO google_reviews_config_messages (google_reviews_config_id, language, message)" +
+		" VALUES (?, ?, ?)" +
+		" ON DUPLICATE KEY UPDATE message = VALUES(message)"
+	const disableQry = "UPDATE google_reviews_message_variants SET enabled = 0" +
+		" WHERE google_reviews_config_id = ? AND language = ?"
+
+	var configID uint64
+	var language, message string
+	if err := Db.QueryRow(variantQry, variantID, partnerID).Scan(&configID, &language, &message); err != nil {
+		log.Printf("Error getting message variant ID: %d for partner ID: %d, err: %v\n", variantID, partnerID, err)
+		return errors.New("Message variant cannot be found")
+	}
+
+	promote := struct {
+		qry  string
+		args []interface{}
+	}{configQry, []interface{}{message, configID}}
+	if language != "" {
+		promote.qry, promote.args = configMessageQry, []interface{}{configID, language, message}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	for _, e := range []struct {
+		qry  string
+		args []interface{}
+	}{
+		promote,
+		{disableQry, []interface{}{configID, language}},
+	} {
+		if _, execErr := tx.Exec(e.qry, e.args...); execErr != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Printf("update failed: %v, unable to rollback: %v\n", execErr, rollbackErr)
+				return execErr
+			}
+			log.Printf("update failed: %v", execErr)
+			return execErr
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// clickThroughZScore - z score (two proportion z test) of the click through rate of a variant compared with the control,
+// returns whether the difference is significant (95%)
+func clickThroughZScore(controlClicked uint64, controlSent uint64, clicked uint64, sent uint64) (float64, bool) {
+	if controlSent == 0 || sent == 0 {
+		return 0, false
+	}
+	p1 := float64(controlClicked) / float64(controlSent)
+	p2 := float64(clicked) / float64(sent)
+	p := float64(controlClicked+clicked) / float64(controlSent+sent)
+	se := math.Sqrt(p * (1 - p) * (1/float64(controlSent) + 1/float64(sent)))
+	if se == 0 {
+		return 0, false
+	}
+	z := (p2 - p1) / se
+	return z, math.Abs(z) >= variantSignificanceZ
+}
+
+// VariantResults - get the sends, clicks and opt outs per message variant of a config with a significance indicator
+// of the click through rate compared with the first variant (the control), the variants set up for the config are
+// listed first followed by any other variants sent by the client (e.g. the position of a multi message)
+func VariantResults(variantResultsRequest VariantResultsRequest, partnerID int) ([]VariantResult, error) {
+	const eventsQry = "SELECT e.variant," +
+		" SUM(e.reason IN ('sent', 'deferred')) AS sent, SUM(e.reason = 'opted_out') AS opt_outs" +
+		" FROM google_reviews_message_events AS e" +
+		" WHERE e.client_id = ?" +
+		" AND e.variant <> ''" +
+		" AND e.created BETWEEN ? AND ?" +
+		" GROUP BY e.variant"
+	const clicksQry = "SELECT l.variant, COUNT(DISTINCT k.short_link_id) AS clicked" +
+		" FROM google_reviews_short_links AS l" +
+		" JOIN google_reviews_short_link_clicks AS k ON k.short_link_id = l.id" +
+		" WHERE l.client_id = ?" +
+		" AND l.message_event_id > 0" +
+		" AND l.variant <> ''" +
+		" AND l.created BETWEEN ? AND ?" +
+		" GROUP BY l.variant"
+
+	clientID, err := configClientID(variantResultsRequest.GoogleReviewsConfigID, partnerID)
+	if err != nil {
+		return nil, err
+	}
+	mvs, err := GetMessageVariants(variantResultsRequest.GoogleReviewsConfigID, partnerID)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]*VariantResult)
+	var order []string
+	for _, mv := range mvs.Variants {
+		results[mv.Name] = &VariantResult{Variant: mv.Name}
+		order = append(order, mv.Name)
+	}
+	var others []string
+	result := func(variant string) *VariantResult {
+		r, ok := results[variant]
+		if !ok {
+			r = &VariantResult{Variant: variant}
+			results[variant] = r
+			others = append(others, variant)
+		}
+		return r
+	}
+
+	rows, err := Db.Query(eventsQry, clientID, variantResultsRequest.StartDay, variantResultsRequest.EndDay)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var variant string
+		var sent, optOuts uint64
+		if err := rows.Scan(&variant, &sent, &optOuts); err != nil {
+			log.Printf("Error getting variant results: %v\n", err)
+			continue
+		}
+		r := result(variant)
+		r.Sent = sent
+		r.OptOuts = optOuts
+	}
+
+	clickRows, err := Db.Query(clicksQry, clientID, variantResultsRequest.StartDay, variantResultsRequest.EndDay)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer clickRows.Close()
+	for clickRows.Next() {
+		var variant string
+		var clicked uint64
+		if err := clickRows.Scan(&variant, &clicked); err != nil {
+			log.Printf("Error getting variant click results: %v\n", err)
+			continue
+		}
+		result(variant).Clicked = clicked
+	}
+
+	sort.Strings(others)
+	order = append(order, others...)
+	variantResults := make([]VariantResult, 0, len(order))
+	for i, variant := range order {
+		r := results[variant]
+		if r.Sent > 0 {
+			r.ClickThroughRate = float64(r.Clicked) / float64(r.Sent)
+			r.OptOutRate = float64(r.OptOuts) / float64(r.Sent)
+		}
+		if i > 0 {
+			control := results[order[0]]
+			r.ZScore, r.Significant = clickThroughZScore(control.Clicked, control.Sent, r.Clicked, r.Sent)
+		}
+		variantResults = append(variantResults, *r)
+	}
+	return variantResults, nil
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// maxAllowedIPsLength - maximum length of the allowed IPs of a config (comma separated)
+const maxAllowedIPsLength = 1000
+
+// RequestVerification - represents the request verification of a config, the dispatcher requests are signed with
+// the signing secret (HMAC-SHA256 of the timestamp and body, see the google reviews server) when signing is enabled
+// and are only accepted from the allowed IPs (comma separated IPs or CIDRs) when set. The signing secret is stored
+// encrypted and is write only, it is only returned when generated (to set up on the dispatcher).
+type RequestVerification struct {
+	GoogleReviewsConfigID uint64 `json:"google_reviews_config_id"` // google reviews config id
+	SigningEnabled        bool   `json:"signing_enabled"`          // requests have to be signed
+	SigningSecret         string `json:"signing_secret"`           // signing secret (set up on the dispatcher), only returned when generated
+	RegenerateSecret      bool   `json:"regenerate_secret"`        // generate a new signing secret when updating
+	AllowedIPs            string `json:"allowed_ips"`              // allowed IPs or CIDRs e.g. 10.0.0.5, 192.0.2.0/24
+}
+
+// normaliseAllowedIPs - check each of the comma separated allowed IPs is an IP or CIDR, returns them comma separated
+func normaliseAllowedIPs(allowedIPs string) (string, error) {
+	var ips []string
+	for _, ip := range strings.Split(allowedIPs, ",") {
+		ip = strings.TrimSpace(ip)
+		if ip == "" {
+			continue
+		}
+		if strings.Contains(ip, "/") {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return "", fmt.Errorf("allowed IP %s is not a valid CIDR e.g. 192.0.2.0/24", ip)
+			}
+		} else if net.ParseIP(ip) == nil {
+			return "", fmt.Errorf("allowed IP %s is not a valid IP", ip)
+		}
+		ips = append(ips, ip)
+	}
+	normalised := strings.Join(ips, ",")
+	if len(normalised) > maxAllowedIPsLength {
+		return "", errors.New("allowed IPs are too long (maximum 1000 characters)")
+	}
+	return normalised, nil
+}
+
+// newSigningSecret - random signing secret
+func newSigningSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error generating signing secret, err: %v\n", err)
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GetRequestVerification - get the request verification of a config (the signing secret is not returned)
+func GetRequestVerification(configID int, partnerID int) (RequestVerification, error) {
+	const qry = "SELECT signing_secret, allowed_ips FROM google_reviews_configs WHERE id = ?"
+	rv := RequestVerification{GoogleReviewsConfigID: uint64(configID)}
+	if _, err := configClientID(configID, partnerID); err != nil {
+		return rv, err
+	}
+	if err := Db.QueryRow(qry, configID).Scan(&rv.SigningSecret, &rv.AllowedIPs); err != nil {
+		log.Printf("Error getting request verification of config ID: %d, err: %v\n", configID, err)
+		return rv, err
+	}
+	rv.SigningEnabled = writeOnlySecret(&rv.SigningSecret)
+	return rv, nil
+}
+
+// UpdateRequestVerification - update the request verification of a config, a signing secret is generated when
+// signing is enabled without one (or when regenerating it) and removed when signing is disabled, returns the
+// updated request verification with the signing secret when generated
+func UpdateRequestVerification(requestVerification RequestVerification, partnerID int) (RequestVerification, error) {
+	// the stored signing secret is kept when signing stays enabled and it is not regenerated
+	const qry = "UPDATE google_reviews_configs SET signing_secret = IF(?, signing_secret, ?), allowed_ips = ? WHERE id = ?"
+	configID := int(requestVerification.GoogleReviewsConfigID)
+	current, err := GetRequestVerification(configID, partnerID)
+	if err != nil {
+		return current, err
+	}
+	allowedIPs, err := normaliseAllowedIPs(requestVerification.AllowedIPs)
+	if err != nil {
+		return current, err
+	}
+	keep := requestVerification.SigningEnabled && current.SigningEnabled && !requestVerification.RegenerateSecret
+	signingSecret := ""
+	if requestVerification.SigningEnabled && !keep {
+		if signingSecret, err = newSigningSecret(); err != nil {
+			return current, errors.New("unable to generate a signing secret")
+		}
+	}
+	if _, err := Db.Exec(qry, keep, encryptSecret(signingSecret), allowedIPs, configID); err != nil {
+		log.Printf("Error updating request verification of config ID: %d, err: %v\n", configID, err)
+		return current, err
+	}
+	return RequestVerification{GoogleReviewsConfigID: uint64(configID), SigningEnabled: requestVerification.SigningEnabled,
+		SigningSecret: signingSecret, AllowedIPs: allowedIPs}, nil
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"testing"
+
+	"google_reviews_ui/secrets"
+)
+
+func TestNormaliseAllowedIPs(t *testing.T) {
+	tests := []struct {
+		allowedIPs string
+		want       string
+		valid      bool
+	}{
+		{"", "", true},
+		{" 192.0.2.1 , 10.0.0.0/8,, 2001:db8::/32 ", "192.0.2.1,10.0.0.0/8,2001:db8::/32", true},
+		{"192.0.2.256", "", false},
+		{"10.0.0.0/33", "", false},
+		{"example.com", "", false},
+	}
+	for _, tt := range tests {
+		ips, err := normaliseAllowedIPs(tt.allowedIPs)
+		if (err == nil) != tt.valid || ips != tt.want {
+			t.Errorf("allowed IPs: %s normalised: %s, err: %v want: %s", tt.allowedIPs, ips, err, tt.want)
+		}
+	}
+}
+
+func TestGetRequestVerification(t *testing.T) {
+	prepareTestDatabase()
+	rv, err := GetRequestVerification(2, 1)
+	// the signing secret is write only
+	if err != nil || !rv.SigningEnabled || rv.SigningSecret != "" || rv.AllowedIPs != "192.0.2.0/24" {
+		t.Fatalf("unexpected request verification: %+v, err: %v", rv, err)
+	}
+	// config of another partner
+	if _, err := GetRequestVerification(2, 2); err == nil {
+		t.Error("expected an error getting the request verification of a config of another partner")
+	}
+}
+
+func TestUpdateRequestVerification(t *testing.T) {
+	prepareTestDatabase()
+	// enabling signing generates a secret
+	rv, err := UpdateRequestVerification(RequestVerification{GoogleReviewsConfigID: 1, SigningEnabled: true, AllowedIPs: "10.0.0.0/8, 192.0.2.1"}, 1)
+	if err != nil || !rv.SigningEnabled || len(rv.SigningSecret) != 64 || rv.AllowedIPs != "10.0.0.0/8,192.0.2.1" {
+		t.Fatalf("unexpected request verification: %+v, err: %v", rv, err)
+	}
+	secret := rv.SigningSecret
+	storedSecret := func() string {
+		var stored string
+		if err := Db.QueryRow("SELECT signing_secret FROM google_reviews_configs WHERE id = 1").Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		secret, _ := secrets.Decrypt(stored)
+		return secret
+	}
+	if storedSecret() != secret {
+		t.Fatal("expected the generated signing secret stored")
+	}
+	// the secret is kept unless regenerated (and not returned)
+	if rv, err = UpdateRequestVerification(RequestVerification{GoogleReviewsConfigID: 1, SigningEnabled: true}, 1); err != nil || !rv.SigningEnabled || rv.SigningSecret != "" || rv.AllowedIPs != "" {
+		t.Fatalf("unexpected request verification: %+v, err: %v", rv, err)
+	}
+	if storedSecret() != secret {
+		t.Fatal("expected the signing secret kept")
+	}
+	if rv, err = UpdateRequestVerification(RequestVerification{GoogleReviewsConfigID: 1, SigningEnabled: true, RegenerateSecret: true}, 1); err != nil || rv.SigningSecret == secret || rv.SigningSecret == "" {
+		t.Fatalf("expected a new signing secret: %+v, err: %v", rv, err)
+	}
+	// disabling signing removes the secret
+	if rv, err = UpdateRequestVerification(RequestVerification{GoogleReviewsConfigID: 1}, 1); err != nil || rv.SigningEnabled || rv.SigningSecret != "" {
+		t.Fatalf("unexpected request verification: %+v, err: %v", rv, err)
+	}
+	if rv, _ := GetRequestVerification(1, 1); rv.SigningEnabled || rv.SigningSecret != "" {
+		t.Fatalf("unexpected stored request verification: %+v", rv)
+	}
+	// invalid allowed IPs
+	if _, err := UpdateRequestVerification(RequestVerification{GoogleReviewsConfigID: 1, AllowedIPs: "192.0.2.300"}, 1); err == nil {
+		t.Error("expected an error updating with an invalid allowed IP")
+	}
+	// config of another partner
+	if _, err := UpdateRequestVerification(RequestVerification{GoogleReviewsConfigID: 3, SigningEnabled: true}, 1); err == nil {
+		t.Error("expected an error updating the request verification of a config of another partner")
+	}
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"errors"
+	"log"
+
+	"google_reviews_ui/secrets"
+)
+
+// LoadDataKeys - load the data keys (wrapped) from the database into the keyring used to encrypt the secrets,
+// creating the first data key when there is no active data key. Does nothing when the secrets are not encrypted (no
+// key provider).
+// NOTE: keep in line with google_reviews
+func LoadDataKeys() error {
+	if !secrets.Enabled() {
+		return nil
+	}
+	wrapped, activeID, err := queryDataKeys()
+	if err != nil {
+		return err
+	}
+	if activeID == 0 {
+		if err := addDataKey(); err != nil {
+			return err
+		}
+		if wrapped, activeID, err = queryDataKeys(); err != nil {
+			return err
+		}
+	}
+	return secrets.SetDataKeys(wrapped, activeID)
+}
+
+// queryDataKeys - get the wrapped data keys by ID and the ID of the active data key (the latest when there is more
+// than one e.g. added at the same time by two servers, 0 when there is none)
+// NOTE: keep in line with google_reviews
+func queryDataKeys() (map[uint64][]byte, uint64, error) {
+	rows, err := Db.Query("SELECT id, wrapped_key, active FROM google_reviews_data_keys ORDER BY id")
+	if err != nil {
+		log.Printf("Error getting the data keys, err: %v\n", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+	wrapped := make(map[uint64][]byte)
+	var activeID uint64
+	for rows.Next() {
+		var (
+			id         uint64
+			wrappedKey []byte
+			active     bool
+		)
+		if err := rows.Scan(&id, &wrappedKey, &active); err != nil {
+			log.Printf("Error reading the data keys, err: %v\n", err)
+			return nil, 0, err
+		}
+		wrapped[id] = wrappedKey
+		if active {
+			activeID = id
+		}
+	}
+	return wrapped, activeID, rows.Err()
+}
+
+// addDataKey - add a data key wrapped by the key provider as the active data key
+// NOTE: keep in line with google_reviews
+func addDataKey() error {
+	wrappedKey, err := secrets.NewDataKey()
+	if err != nil {
+		log.Printf("Error creating a data key, err: %v\n", err)
+		return err
+	}
+	tx, err := Db.Begin()
+	if err != nil {
+		log.Printf("Error adding a data key, err: %v\n", err)
+		return err
+	}
+	if _, err := tx.Exec("UPDATE google_reviews_data_keys SET active = 0 WHERE active = 1"); err != nil {
+		tx.Rollback()
+		log.Printf("Error deactivating the data keys, err: %v\n", err)
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO google_reviews_data_keys (wrapped_key, active) VALUES (?, 1)", wrappedKey); err != nil {
+		tx.Rollback()
+		log.Printf("Error adding a data key, err: %v\n", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// encryptSecret - encrypt the secret to store it (returned as is when the secrets are not encrypted). When it cannot
+// be encrypted the secret is stored as plaintext, it is encrypted by the next google_reviews encryptsecrets.
+// NOTE: keep in line with google_reviews
+func encryptSecret(secret string) string {
+	value, err := secrets.Encrypt(secret)
+	if errors.Is(err, secrets.ErrNoDataKey) && LoadDataKeys() == nil {
+		value, err = secrets.Encrypt(secret)
+	}
+	if err != nil {
+		log.Printf("Error encrypting a secret, it is stored unencrypted, err: %v\n", err)
+		return secret
+	}
+	return value
+}
+
+// writeOnlySecret - clear the stored secret so it is not returned to the front end, returns whether it is set. The
+// secrets are write only, an empty secret sent by the front end keeps the stored secret.
+func writeOnlySecret(secret *string) bool {
+	set := *secret != ""
+	*secret = ""
+	return set
+}
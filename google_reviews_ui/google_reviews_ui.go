@@ -41,6 +41,51 @@
 //
 //  curl -k -H 'Accept: application/json' -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/stats?start_day=2021-09-01&end_day=2021-09-02&time_grouping=day'
 //
+// to find out why a message was or wasn't sent to a telephone (in international format, + encoded as %2B) use:
+//
+//  curl -k -H 'Accept: application/json' -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/messageevents?telephone=%2B447123456789&start_day=2021-09-01&end_day=2021-09-02'
+//
+// to get the click through rates of the tracked short review links per client and message variant use:
+//
+//  curl -k -H 'Accept: application/json' -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/statsclicks?start_day=2021-09-01&end_day=2021-09-02'
+//
+// to A/B test messages, set up the message variants of a config (the weight sets the share of messages for the variant):
+//
+//  curl -k -X PUT -H 'Content-Type: application/json' -H "Authorization: Bearer <token>" -d '{"google_reviews_config_id":12,"variants":[{"enabled":true,"name":"short","message":"Please review us {review_link}","weight":1},{"enabled":true,"name":"friendly","message":"Hi {first_name}, thanks for travelling with {company}, please review us {review_link}","weight":1}]}' 'https://localhost:8443/auth/variants'
+//
+// to compare the variants (the significance is of the click through rate compared with the first variant) use:
+//
+//  curl -k -H 'Accept: application/json' -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/variantresults?id=12&start_day=2021-09-01&end_day=2021-09-30'
+//
+// and to make the winning variant the config message (disabling the variants) use:
+//
+//  curl -k -X POST -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/variantpromote?id=2'
+//
+// To test a config, the request is simulated by the review server (nothing is sent or written to the database) and
+// a trace of each step is returned (webhook is one of googlereviews, cordic or cab9, the parameters are separated by a newline):
+//
+//  curl -k -X POST -H "Authorization: Bearer <token>" --data-urlencode 'webhook=googlereviews' --data-urlencode $'parameters=gr_token=<config token>\nt=07123456789' 'https://localhost:8443/auth/sendtest'
+//  curl -k -X POST -H "Authorization: Bearer <token>" --data-urlencode 'webhook=hook' --data-urlencode $'parameters=gr_token=<config token>\nignore_telephone_checks=1' --data-urlencode 'payload={"passenger":{"phone":"07123456789"}}' 'https://localhost:8443/auth/sendtest'
+//
+// To list, add and remove barred telephone prefixes (or full numbers) for a client or all clients (client_id 0, only
+// users of the barred_global_partner_ids partners), the review servers reload the barred telephones when changed:
+//
+//  curl -k -H 'Accept: application/json' -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/barred'
+//  curl -k -X POST -H 'Content-Type: application/json' -H "Authorization: Bearer <token>" -d '{"client_id":12,"telephone":"+447123456789","full_number":true,"reason":"Passenger asked not to be contacted"}' 'https://localhost:8443/auth/barred'
+//  curl -k -X DELETE -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/barred?id=2'
+//
+// To verify the dispatcher requests of a config, requests are signed (X-GR-Timestamp header with the unix time and
+// X-GR-Signature header with the hex HMAC-SHA256 of the timestamp, a full stop and the body keyed with the signing
+// secret) when signing is enabled and only accepted from the allowed IPs (IPs or CIDRs) when set, use:
+//
+//  curl -k -H 'Accept: application/json' -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/verification?id=12'
+//  curl -k -X PUT -H 'Content-Type: application/json' -H "Authorization: Bearer <token>" -d '{"google_reviews_config_id":12,"signing_enabled":true,"regenerate_secret":false,"allowed_ips":"192.0.2.0/24, 198.51.100.7"}' 'https://localhost:8443/auth/verification'
+//
+// generic dispatcher webhook mapping of a config (an empty mapping disables the webhook) and testing a mapping:
+//  curl -k -H 'Accept: application/json' -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/hookmapping?id=12'
+//  curl -k -X PUT -H 'Content-Type: application/json' -H "Authorization: Bearer <token>" -d '{"google_reviews_config_id":12,"mapping":"{\"telephone\":\"$.passenger.phone\",\"booking_id\":\"$.id\"}"}' 'https://localhost:8443/auth/hookmapping'
+//  curl -k -X POST -H 'Content-Type: application/json' -H "Authorization: Bearer <token>" -d '{"mapping":"{\"telephone\":\"$.passenger.phone\"}","payload":"{\"passenger\":{\"phone\":\"07123456789\"}}"}' 'https://localhost:8443/auth/hookmappingtest'
+//
 
 package main
 
@@ -53,6 +98,7 @@ import (
 
 	"google_reviews_ui/config"
 	"google_reviews_ui/database"
+	"google_reviews_ui/secrets"
 	"google_reviews_ui/server"
 )
 
@@ -90,9 +136,28 @@ func main() {
 
 	// read config file
 	config.ReadProperties()
+	// telephones are looked up hashed with the telephone hash key
+	if config.Conf.TelephoneHashKey == "" {
+		log.Fatal("Error, no telephone hash key is set (telephone_hash_key), telephones can not be hashed without a key")
+	}
+	database.SetTelephoneHashKey(config.Conf.TelephoneHashKey)
+
+	// secrets are stored encrypted with the data keys wrapped by the key in the secrets key file
+	if config.Conf.SecretsKeyFile != "" {
+		keyProvider, err := secrets.NewFileKeyProvider(config.Conf.SecretsKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		secrets.SetKeyProvider(keyProvider)
+	} else {
+		log.Println("Warning, no secrets key file is set, secrets are stored unencrypted")
+	}
 
 	// database
 	database.OpenDB(config.Conf.DbName, config.Conf.DbAddress, config.Conf.DbPort, config.Conf.DbUsername, config.Conf.DbPassword)
+	if err := database.LoadDataKeys(); err != nil {
+		log.Fatal(err)
+	}
 
 	// run http server
 	server.Server(logFilename)
@@ -0,0 +1,73 @@
+package hook
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseMapping(t *testing.T) {
+	tests := []struct {
+		mapping string
+		valid   bool
+	}{
+		{`{"telephone":"$.passenger.phone"}`, true},
+		{`{"passenger_id":"$['passenger']['id']","booking_id":"$.bookings[0].id"}`, true},
+		{`{"email":"$.passenger.email","booking_id":"$.id"}`, true},
+		{`{"telephone":"$.mobile | $.telephone","company":"company_id"}`, true},
+		{`{"booking_id":"$.id"}`, false},
+		{`{"telephone":"$.phone","unknown":"$.x"}`, false},
+		{`{"telephone":"$."}`, false},
+		{`{"telephone":"$.phones[x]"}`, false},
+		{`{"telephone":"$.phones[0"}`, false},
+		{`{"telephone":""}`, false},
+		{`not json`, false},
+	}
+	for _, tt := range tests {
+		if _, err := ParseMapping(tt.mapping); (err == nil) != tt.valid {
+			t.Errorf("mapping: %s, err: %v, want valid: %v", tt.mapping, err, tt.valid)
+		}
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	m, err := ParseMapping(`{"telephone":"$.passenger.mobile | $.passenger.phone","booking_id":"$.id",` +
+		`"company":"$.company.id","picked_up":"$.times[1]","first_name":"$['passenger']['first name']",` +
+		`"booking_source":"$.source","passenger_id":"$.passenger.missing"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"id":12345678901,"company":{"id":3},"source":true,"times":["2021-09-01T10:00:00Z","2021-09-01T10:05:00Z"],` +
+		`"passenger":{"mobile":"","phone":" 07123456789 ","first name":"Jane"}}`)
+	if !IsJSON("application/json; charset=utf-8", body) || !IsJSON("", body) || IsJSON("application/x-www-form-urlencoded", body) {
+		t.Error("unexpected IsJSON")
+	}
+	values, err := Extract(m, body, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"telephone": "07123456789", "booking_id": "12345678901", "company": "3",
+		"picked_up": "2021-09-01T10:05:00Z", "first_name": "Jane", "booking_source": "true"}
+	if len(values) != len(want) {
+		t.Errorf("unexpected values: %v", values)
+	}
+	for f, v := range want {
+		if values[f] != v {
+			t.Errorf("field %s: %q want %q", f, values[f], v)
+		}
+	}
+	if _, err := Extract(m, []byte(`{"id":`), nil, true); err == nil {
+		t.Error("expected an error extracting from invalid JSON")
+	}
+}
+
+func TestExtractForm(t *testing.T) {
+	m, err := ParseMapping(`{"telephone":"$.passenger.phone","booking_id":"booking_id"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"passenger.phone": {"07123456789"}, "booking_id": {"B1"}}
+	values, err := Extract(m, nil, form, false)
+	if err != nil || values[FieldTelephone] != "07123456789" || values[FieldBookingID] != "B1" {
+		t.Errorf("unexpected values: %v, err: %v", values, err)
+	}
+}
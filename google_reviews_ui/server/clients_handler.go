@@ -19,6 +19,7 @@ import (
 
 	"google_reviews_ui/config"
 	"google_reviews_ui/database"
+	"google_reviews_ui/hook"
 	"google_reviews_ui/shared"
 )
 
@@ -69,6 +70,8 @@ func SimpleUpdateClientHandler(c *gin.Context) {
 			log.Printf("error updating simple config for a client, err: %+v\n", err)
 			errStr = fmt.Sprintf("error updating simple config for a client, error: %+v", err)
 			success = false
+		} else {
+			invalidateConfigCache(c)
 		}
 	}
 	c.JSON(200, gin.H{
@@ -93,6 +96,8 @@ func SimpleCreateClientHandler(c *gin.Context) {
 			log.Printf("error creating simple config for a client, err: %+v\n", err)
 			errStr = fmt.Sprintf("error creating simple config for a client, error: %+v", err)
 			success = false
+		} else {
+			invalidateConfigCache(c)
 		}
 	}
 	c.JSON(200, gin.H{
@@ -145,6 +150,8 @@ func UpdateClientHandler(c *gin.Context) {
 			log.Printf("error updating config for a client, err: %+v\n", err)
 			errStr = fmt.Sprintf("error updating config for a client, error: %+v", err)
 			success = false
+		} else {
+			invalidateConfigCache(c)
 		}
 	}
 	c.JSON(200, gin.H{
@@ -169,6 +176,8 @@ func CreateClientHandler(c *gin.Context) {
 			log.Printf("error creating config for a client, err: %+v\n", err)
 			errStr = fmt.Sprintf("error creating config for a client, error: %+v", err)
 			success = false
+		} else {
+			invalidateConfigCache(c)
 		}
 	}
 	c.JSON(200, gin.H{
@@ -193,6 +202,8 @@ func CreateClientConfigHandler(c *gin.Context) {
 			log.Printf("error creating config for a client, err: %+v\n", err)
 			errStr = fmt.Sprintf("error creating config for a client, error: %+v", err)
 			success = false
+		} else {
+			invalidateConfigCache(c)
 		}
 	}
 	c.JSON(200, gin.H{
@@ -217,6 +228,8 @@ func CreateClientConfigTimeHandler(c *gin.Context) {
 			log.Printf("error creating config time for a client, err: %+v\n", err)
 			errStr = fmt.Sprintf("error creating config time for a client, error: %+v", err)
 			success = false
+		} else {
+			invalidateConfigCache(c)
 		}
 	}
 	c.JSON(200, gin.H{
@@ -409,6 +422,222 @@ func StatsNewHandler(c *gin.Context) {
 	})
 }
 
+// ClickStatsHandler - retrieve the tracked short link click through rates per client and message variant
+// e.g. /auth/statsclicks?start_day=2021-09-01&end_day=2021-09-02 (the end day is inclusive)
+func ClickStatsHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var statsRequest database.StatsRequest
+	statsRequest.StartDay = c.Query("start_day")
+	statsRequest.EndDay = c.Query("end_day") + " 23:59:59"
+	stats, err := database.ClickStats(statsRequest, getPartnerID(c))
+	if err != nil {
+		log.Printf("error retrieving click stats list, err: %+v\n", err)
+		errStr = fmt.Sprintf("error retrieving click stats list, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+		"stats":   stats,
+	})
+}
+
+// MessageEventsHandler - retrieve the message events for a telephone, used to find out why a message was or wasn't sent
+// e.g. /auth/messageevents?telephone=%2B447123456789&start_day=2021-09-01&end_day=2021-09-02&client_id=1
+// (the end day is inclusive and the client id is optional)
+func MessageEventsHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var messageEventsRequest database.MessageEventsRequest
+	messageEventsRequest.Telephone = c.Query("telephone")
+	messageEventsRequest.StartDay = c.Query("start_day")
+	messageEventsRequest.EndDay = c.Query("end_day") + " 23:59:59"
+	if clientID := c.Query("client_id"); clientID != "" {
+		id, err := strconv.Atoi(clientID)
+		if err != nil {
+			log.Printf("error converting client_id %s to an integer, err: %+v\n", clientID, err)
+		}
+		messageEventsRequest.ClientID = id
+	}
+	messageEvents, err := database.MessageEvents(messageEventsRequest, getPartnerID(c))
+	if err != nil {
+		log.Printf("error retrieving message events, err: %+v\n", err)
+		errStr = fmt.Sprintf("error retrieving message events, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+		"events":  messageEvents,
+	})
+}
+
+// GetMessageVariantsHandler - retrieve the message variants of a config used for A/B testing messages
+// e.g. /auth/variants?id=12
+func GetMessageVariantsHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	configID, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		log.Printf("error converting id %s to an integer, err: %+v\n", c.Query("id"), err)
+	}
+	messageVariants, err := database.GetMessageVariants(configID, getPartnerID(c))
+	if err != nil {
+		log.Printf("error retrieving message variants, err: %+v\n", err)
+		errStr = fmt.Sprintf("error retrieving message variants, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success":          success,
+		"err":              errStr,
+		"message_variants": messageVariants,
+	})
+}
+
+// UpdateMessageVariantsHandler - replace the message variants of a config
+func UpdateMessageVariantsHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var messageVariants database.MessageVariants
+	if err := c.ShouldBind(&messageVariants); err != nil {
+		log.Printf("Binding error: %+v\n", err)
+		errStr = fmt.Sprintf("error: %+v", err)
+		success = false
+	} else {
+		err := database.UpdateMessageVariants(messageVariants, getPartnerID(c))
+		if err != nil {
+			log.Printf("error updating message variants, err: %+v\n", err)
+			errStr = fmt.Sprintf("error updating message variants, error: %+v", err)
+			success = false
+		} else {
+			invalidateConfigCache(c)
+		}
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+	})
+}
+
+// PromoteMessageVariantHandler - make a message variant the config message, ending the A/B test
+// e.g. /auth/variantpromote?id=2
+func PromoteMessageVariantHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	variantID, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		log.Printf("error converting id %s to an integer, err: %+v\n", c.Query("id"), err)
+	}
+	err = database.PromoteMessageVariant(variantID, getPartnerID(c))
+	if err != nil {
+		log.Printf("error promoting message variant, err: %+v\n", err)
+		errStr = fmt.Sprintf("error promoting message variant, error: %+v", err)
+		success = false
+	} else {
+		invalidateConfigCache(c)
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+	})
+}
+
+// GetConfigMessagesHandler - retrieve the messages by language of a config
+// e.g. /auth/configmessages?id=12
+func GetConfigMessagesHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	configID, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		log.Printf("error converting id %s to an integer, err: %+v\n", c.Query("id"), err)
+	}
+	configMessages, err := database.GetConfigMessages(configID, getPartnerID(c))
+	if err != nil {
+		log.Printf("error retrieving config messages, err: %+v\n", err)
+		errStr = fmt.Sprintf("error retrieving config messages, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success":         success,
+		"err":             errStr,
+		"config_messages": configMessages,
+	})
+}
+
+// UpdateConfigMessagesHandler - replace the messages by language of a config
+func UpdateConfigMessagesHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var configMessages database.ConfigMessages
+	if err := c.ShouldBind(&configMessages); err != nil {
+		log.Printf("Binding error: %+v\n", err)
+		errStr = fmt.Sprintf("error: %+v", err)
+		success = false
+	} else {
+		err := database.UpdateConfigMessages(configMessages, getPartnerID(c))
+		if err != nil {
+			log.Printf("error updating config messages, err: %+v\n", err)
+			errStr = fmt.Sprintf("error updating config messages, error: %+v", err)
+			success = false
+		} else {
+			invalidateConfigCache(c)
+		}
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+	})
+}
+
+// PreviewMessagesHandler - preview the messages (variants or multi messages) of a config for a language filled in with
+// sample values, e.g. /auth/messagepreview?id=12&language=nl (no language for the config message)
+func PreviewMessagesHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	configID, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		log.Printf("error converting id %s to an integer, err: %+v\n", c.Query("id"), err)
+	}
+	previews, err := database.PreviewMessages(configID, c.Query("language"), getPartnerID(c))
+	if err != nil {
+		log.Printf("error previewing messages, err: %+v\n", err)
+		errStr = fmt.Sprintf("error previewing messages, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success":  success,
+		"err":      errStr,
+		"previews": previews,
+	})
+}
+
+// VariantResultsHandler - retrieve the sends, clicks and opt outs per message variant of a config
+// e.g. /auth/variantresults?id=12&start_day=2021-09-01&end_day=2021-09-02 (the end day is inclusive)
+func VariantResultsHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var variantResultsRequest database.VariantResultsRequest
+	configID, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		log.Printf("error converting id %s to an integer, err: %+v\n", c.Query("id"), err)
+	}
+	variantResultsRequest.GoogleReviewsConfigID = configID
+	variantResultsRequest.StartDay = c.Query("start_day")
+	variantResultsRequest.EndDay = c.Query("end_day") + " 23:59:59"
+	variantResults, err := database.VariantResults(variantResultsRequest, getPartnerID(c))
+	if err != nil {
+		log.Printf("error retrieving message variant results, err: %+v\n", err)
+		errStr = fmt.Sprintf("error retrieving message variant results, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+		"results": variantResults,
+	})
+}
+
 // // CheckNothingSentHandler - check no messages sent for a company for a specific period
 // func CheckNothingSentHandler(c *gin.Context) {
 // 	db := c.MustGet(shared.DatabaseConn).(*sql.DB)
@@ -503,6 +732,386 @@ func GoogleMyBusinessReportHandler(c *gin.Context) {
 	})
 }
 
+// BarredTelephonesHandler - retrieve the telephones barred for all clients and for the partner's clients
+func BarredTelephonesHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	barredTelephones, err := database.BarredTelephones(getPartnerID(c))
+	if err != nil {
+		log.Printf("error retrieving barred telephones, err: %+v\n", err)
+		errStr = fmt.Sprintf("error retrieving barred telephones, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success":           success,
+		"err":               errStr,
+		"barred_telephones": barredTelephones,
+		"manage_global":     manageGlobalBarred(c),
+	})
+}
+
+// AddBarredTelephoneHandler - add a barred telephone prefix (or full number) for a client or all clients (client_id 0),
+// the user adding it is recorded
+func AddBarredTelephoneHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var id uint64
+	var barredTelephone database.BarredTelephone
+	if err := c.ShouldBind(&barredTelephone); err != nil {
+		log.Printf("Binding error: %+v\n", err)
+		errStr = fmt.Sprintf("error: %+v", err)
+		success = false
+	} else {
+		barredTelephone.CreatedBy = getUserName(c)
+		id, err = database.AddBarredTelephone(barredTelephone, getPartnerID(c), manageGlobalBarred(c))
+		if err != nil {
+			log.Printf("error adding barred telephone, err: %+v\n", err)
+			errStr = fmt.Sprintf("error adding barred telephone, error: %+v", err)
+			success = false
+		} else {
+			log.Printf("barred telephone: %s added for client ID: %d by: %s, reason: %s\n",
+				database.MaskTelephone(barredTelephone.Telephone), barredTelephone.ClientID, barredTelephone.CreatedBy, barredTelephone.Reason)
+			reloadBarred(c)
+		}
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+		"id":      id,
+	})
+}
+
+// DeleteBarredTelephoneHandler - remove a barred telephone
+// e.g. /auth/barred?id=2
+func DeleteBarredTelephoneHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		log.Printf("error converting id %s to an integer, err: %+v\n", c.Query("id"), err)
+	}
+	err = database.DeleteBarredTelephone(id, getPartnerID(c), manageGlobalBarred(c))
+	if err != nil {
+		log.Printf("error removing barred telephone, err: %+v\n", err)
+		errStr = fmt.Sprintf("error removing barred telephone, error: %+v", err)
+		success = false
+	} else {
+		log.Printf("barred telephone ID: %d removed by: %s\n", id, getUserName(c))
+		reloadBarred(c)
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+	})
+}
+
+// FatiguePoliciesHandler - retrieve the fatigue policies (review requests to a passenger across clients) for all
+// clients and for the partner's clients
+func FatiguePoliciesHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	policies, err := database.FatiguePolicies(getPartnerID(c))
+	if err != nil {
+		log.Printf("error retrieving fatigue policies, err: %+v\n", err)
+		errStr = fmt.Sprintf("error retrieving fatigue policies, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success":       success,
+		"err":           errStr,
+		"policies":      policies,
+		"partner_id":    getPartnerID(c),
+		"manage_global": manageGlobalFatigue(c),
+	})
+}
+
+// SetFatiguePolicyHandler - add or replace the fatigue policy of the partner or for all clients (partner_id 0), the
+// user updating it is recorded
+func SetFatiguePolicyHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var policy database.FatiguePolicy
+	if err := c.ShouldBind(&policy); err != nil {
+		log.Printf("Binding error: %+v\n", err)
+		errStr = fmt.Sprintf("error: %+v", err)
+		success = false
+	} else {
+		policy.UpdatedBy = getUserName(c)
+		err := database.SetFatiguePolicy(policy, getPartnerID(c), manageGlobalFatigue(c))
+		if err != nil {
+			log.Printf("error setting fatigue policy, err: %+v\n", err)
+			errStr = fmt.Sprintf("error setting fatigue policy, error: %+v", err)
+			success = false
+		} else {
+			log.Printf("fatigue policy of partner ID: %d set to %d review requests in %d days by: %s\n",
+				policy.PartnerID, policy.MaxRequests, policy.Days, policy.UpdatedBy)
+			// the fatigue policies are cached with the configs
+			invalidateConfigCache(c)
+		}
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+	})
+}
+
+// DeleteFatiguePolicyHandler - remove the fatigue policy of the partner or for all clients
+// e.g. /auth/fatigue?partner_id=0
+func DeleteFatiguePolicyHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	policyPartnerID, err := strconv.Atoi(c.Query("partner_id"))
+	if err != nil {
+		log.Printf("error converting partner_id %s to an integer, err: %+v\n", c.Query("partner_id"), err)
+		policyPartnerID = -1
+	}
+	err = database.DeleteFatiguePolicy(policyPartnerID, getPartnerID(c), manageGlobalFatigue(c))
+	if err != nil {
+		log.Printf("error removing fatigue policy, err: %+v\n", err)
+		errStr = fmt.Sprintf("error removing fatigue policy, error: %+v", err)
+		success = false
+	} else {
+		log.Printf("fatigue policy of partner ID: %d removed by: %s\n", policyPartnerID, getUserName(c))
+		// the fatigue policies are cached with the configs
+		invalidateConfigCache(c)
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+	})
+}
+
+// FindDataSubjectHandler - retrieve the records of a telephone for all clients (subject access request), only for
+// users of the data protection partners
+// e.g. /auth/datasubject?telephone=%2B447123456789
+func FindDataSubjectHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var dataSubject database.DataSubject
+	telephone := c.Query("telephone")
+	if !manageDataProtection(c) {
+		errStr = "not authorised to find data subjects"
+		success = false
+	} else {
+		var err error
+		dataSubject, err = database.FindDataSubject(telephone)
+		if err != nil {
+			log.Printf("error finding data subject, err: %+v\n", err)
+			errStr = fmt.Sprintf("error finding data subject, error: %+v", err)
+			success = false
+		} else {
+			log.Printf("data subject telephone: %s found by: %s\n", database.MaskTelephone(telephone), getUserName(c))
+		}
+	}
+	c.JSON(200, gin.H{
+		"success":      success,
+		"err":          errStr,
+		"data_subject": dataSubject,
+	})
+}
+
+// EraseDataSubjectHandler - erase the records of a telephone for all clients (erasure request), only for users of the
+// data protection partners
+// e.g. /auth/datasubject?telephone=%2B447123456789
+func EraseDataSubjectHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var erasure database.DataSubjectErasure
+	telephone := c.Query("telephone")
+	if !manageDataProtection(c) {
+		errStr = "not authorised to erase data subjects"
+		success = false
+	} else {
+		var err error
+		erasure, err = database.EraseDataSubject(telephone)
+		if err != nil {
+			log.Printf("error erasing data subject, err: %+v\n", err)
+			errStr = fmt.Sprintf("error erasing data subject, error: %+v", err)
+			success = false
+		} else {
+			log.Printf("data subject telephone: %s erased by: %s, erasure: %+v\n",
+				database.MaskTelephone(telephone), getUserName(c), erasure)
+		}
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+		"erasure": erasure,
+	})
+}
+
+// GetRequestVerificationHandler - retrieve the request verification (signing secret and allowed IPs) of a config
+// e.g. /auth/verification?id=12
+func GetRequestVerificationHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	configID, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		log.Printf("error converting id %s to an integer, err: %+v\n", c.Query("id"), err)
+	}
+	requestVerification, err := database.GetRequestVerification(configID, getPartnerID(c))
+	if err != nil {
+		log.Printf("error retrieving request verification, err: %+v\n", err)
+		errStr = fmt.Sprintf("error retrieving request verification, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success":              success,
+		"err":                  errStr,
+		"request_verification": requestVerification,
+	})
+}
+
+// UpdateRequestVerificationHandler - update the request verification of a config, returning the signing secret to
+// set up on the dispatcher
+func UpdateRequestVerificationHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var requestVerification database.RequestVerification
+	if err := c.ShouldBind(&requestVerification); err != nil {
+		log.Printf("Binding error: %+v\n", err)
+		errStr = fmt.Sprintf("error: %+v", err)
+		success = false
+	} else {
+		requestVerification, err = database.UpdateRequestVerification(requestVerification, getPartnerID(c))
+		if err != nil {
+			log.Printf("error updating request verification, err: %+v\n", err)
+			errStr = fmt.Sprintf("error updating request verification, error: %+v", err)
+			success = false
+		} else {
+			log.Printf("request verification of config ID: %d updated by: %s, signing enabled: %v, allowed IPs: %s\n",
+				requestVerification.GoogleReviewsConfigID, getUserName(c), requestVerification.SigningEnabled, requestVerification.AllowedIPs)
+			invalidateConfigCache(c)
+		}
+	}
+	c.JSON(200, gin.H{
+		"success":              success,
+		"err":                  errStr,
+		"request_verification": requestVerification,
+	})
+}
+
+// GetHookMappingHandler - retrieve the generic dispatcher webhook (/hook/<token>) mapping of a config
+// e.g. /auth/hookmapping?id=12
+func GetHookMappingHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	configID, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		log.Printf("error converting id %s to an integer, err: %+v\n", c.Query("id"), err)
+	}
+	hookMapping, err := database.GetHookMapping(configID, getPartnerID(c))
+	if err != nil {
+		log.Printf("error retrieving hook mapping, err: %+v\n", err)
+		errStr = fmt.Sprintf("error retrieving hook mapping, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success":      success,
+		"err":          errStr,
+		"hook_mapping": hookMapping,
+		"fields":       hook.Fields,
+	})
+}
+
+// UpdateHookMappingHandler - update the generic dispatcher webhook mapping of a config (an empty mapping disables it)
+func UpdateHookMappingHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var hookMapping database.HookMapping
+	if err := c.ShouldBind(&hookMapping); err != nil {
+		log.Printf("Binding error: %+v\n", err)
+		errStr = fmt.Sprintf("error: %+v", err)
+		success = false
+	} else {
+		hookMapping, err = database.UpdateHookMapping(hookMapping, getPartnerID(c))
+		if err != nil {
+			log.Printf("error updating hook mapping, err: %+v\n", err)
+			errStr = fmt.Sprintf("error updating hook mapping, error: %+v", err)
+			success = false
+		} else {
+			log.Printf("hook mapping of config ID: %d updated by: %s, mapping: %s\n",
+				hookMapping.GoogleReviewsConfigID, getUserName(c), hookMapping.Mapping)
+			invalidateConfigCache(c)
+		}
+	}
+	c.JSON(200, gin.H{
+		"success":      success,
+		"err":          errStr,
+		"hook_mapping": hookMapping,
+	})
+}
+
+// hook mapping test parameters, the mapping and a sample payload from the dispatcher
+type hookMappingTestParameters struct {
+	Mapping string `form:"mapping" json:"mapping" binding:"required"`
+	Payload string `form:"payload" json:"payload" binding:"required"`
+}
+
+// HookMappingTestHandler - extract the fields from a sample (JSON) payload using the mapping so the mapping can be
+// checked before it is saved
+func HookMappingTestHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var fields map[string]string
+	var params hookMappingTestParameters
+	if err := c.ShouldBind(&params); err != nil {
+		log.Printf("Binding error: %+v\n", err)
+		errStr = fmt.Sprintf("error: %+v", err)
+		success = false
+	} else if mapping, err := hook.ParseMapping(params.Mapping); err != nil {
+		errStr = fmt.Sprintf("invalid mapping, error: %+v", err)
+		success = false
+	} else if fields, err = hook.Extract(mapping, []byte(params.Payload), nil, true); err != nil {
+		errStr = fmt.Sprintf("invalid payload, error: %+v", err)
+		success = false
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+		"fields":  fields,
+	})
+}
+
+// manageGlobalBarred - whether the user's partner can manage the telephones barred for all clients
+func manageGlobalBarred(c *gin.Context) bool {
+	partnerID := getPartnerID(c)
+	for _, id := range config.Conf.BarredGlobalPartnerIDs {
+		if id == partnerID {
+			return true
+		}
+	}
+	return false
+}
+
+// manageGlobalFatigue - whether the user's partner can manage the fatigue policy for all clients
+func manageGlobalFatigue(c *gin.Context) bool {
+	partnerID := getPartnerID(c)
+	for _, id := range config.Conf.FatigueGlobalPartnerIDs {
+		if id == partnerID {
+			return true
+		}
+	}
+	return false
+}
+
+// manageDataProtection - whether the user's partner can find and erase the records of a telephone for all clients
+func manageDataProtection(c *gin.Context) bool {
+	partnerID := getPartnerID(c)
+	for _, id := range config.Conf.DataProtectionPartnerIDs {
+		if id == partnerID {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the user name from the JWT claims
+func getUserName(c *gin.Context) string {
+	userName, _ := jwt.ExtractClaims(c)[identityKey].(string)
+	return userName
+}
+
 // Get the partner id from the JWT claims
 func getPartnerID(c *gin.Context) int {
 	// log.Printf("claims: %s\n", jwt.ExtractClaims(c))
@@ -15,6 +15,7 @@ import (
 
 	"google_reviews_ui/client"
 	"google_reviews_ui/config"
+	"google_reviews_ui/database"
 	"google_reviews_ui/shared"
 )
 
@@ -41,13 +42,17 @@ func feHandler(c *gin.Context) {
 	c.HTML(http.StatusOK, "fe/index.tmpl", gin.H{})
 }
 
-// send test parameters
+// send test parameters, the parameters (including the gr_token) are those sent by the dispatcher to the webhook,
+// for the generic dispatcher webhook (hook) the payload is sent as JSON and the parameters in the query string
 type sendTestParameters struct {
-	SendURL    string `form:"sendURL" json:"send_url" binding:"required"`
-	HttpGet    bool   `form:"httpGet" json:"http_get"`
+	Webhook    string `form:"webhook" json:"webhook"`
 	Parameters string `form:"parameters" json:"parameters" binding:"required"`
+	Payload    string `form:"payload" json:"payload"`
 }
 
+// sendTestWebhooks - dispatcher webhooks that can be simulated by the google reviews server
+var sendTestWebhooks = map[string]bool{"googlereviews": true, "cordic": true, "cab9": true, "hook": true}
+
 // ErrorResult - represents an error result.
 type ErrorResult struct {
 	ClientID  int       `json:"client_id"`  // client id
@@ -55,11 +60,13 @@ type ErrorResult struct {
 	LastError time.Time `json:"last_error"` // last error
 }
 
-// send test to get response from remote send SMS service
+// send test - simulate the request on the google reviews server returning the trace of each step
+// (nothing is sent or written to the database)
 func sendTestHandler(c *gin.Context) {
 	success := true
 	var errStr string
 	var resp string
+	var trace json.RawMessage
 	var sendTestParams sendTestParameters
 	if err := c.ShouldBind(&sendTestParams); err != nil {
 		log.Printf("err: %v\n", err)
@@ -76,17 +83,49 @@ func sendTestHandler(c *gin.Context) {
 				params.Add(a[0], a[1])
 			}
 		}
-		httpMethod := "POST"
-		if sendTestParams.HttpGet {
-			httpMethod = "GET"
+		webhook := sendTestParams.Webhook
+		if webhook == "" {
+			webhook = "googlereviews"
+		}
+		logServers, ok := c.MustGet("logServers").([]config.LogServer)
+		switch {
+		case !sendTestWebhooks[webhook]:
+			errStr = fmt.Sprintf("Webhook %s cannot be tested", webhook)
+			success = false
+		case webhook == "hook" && !json.Valid([]byte(sendTestParams.Payload)):
+			errStr = "Payload must be JSON"
+			success = false
+		case !database.ConfigTokenForPartner(params.Get("gr_token"), getPartnerID(c)):
+			errStr = "Config token (gr_token) cannot be found"
+			success = false
+		case !ok || len(logServers) == 0:
+			log.Printf("err: getting log (review) servers from config (and or context) to simulate the request\n")
+			errStr = "Review server cannot be found"
+			success = false
+		case webhook == "hook":
+			// the config token is in the path of the generic dispatcher webhook
+			grToken := params.Get("gr_token")
+			params.Del("gr_token")
+			// the log token is required to simulate a request
+			params.Set("log_token", logServers[0].LogToken)
+			resp = client.SendJSON(logServers[0].URL+"/hook/"+url.PathEscape(grToken)+"/simulate", params, []byte(sendTestParams.Payload))
+		default:
+			resp = client.Send(logServers[0].URL+"/"+webhook+"/simulate?log_token="+url.QueryEscape(logServers[0].LogToken), "POST", params)
+		}
+		if success {
+			if json.Valid([]byte(resp)) {
+				trace = json.RawMessage(resp)
+			} else {
+				errStr = "Unable to simulate the request"
+				success = false
+			}
 		}
-		log.Printf("sendURL: %s, httpMethod: %s, params: %v\n", sendTestParams.SendURL, httpMethod, params)
-		resp = client.Send(sendTestParams.SendURL, httpMethod, params)
 	}
 	c.JSON(200, gin.H{
 		"success":  success,
 		"err":      errStr,
 		"response": resp,
+		"trace":    trace,
 	})
 }
 
@@ -137,6 +176,40 @@ func checkLogHandler(c *gin.Context) {
 	})
 }
 
+// invalidateConfigCache - invalidate the config cache of the (review) servers after a config is updated so the
+// update is used straight away rather than when the cached config expires
+func invalidateConfigCache(c *gin.Context) {
+	logServers, ok := c.MustGet("logServers").([]config.LogServer)
+	if !ok {
+		log.Printf("err: getting log (review) servers from config (and or context) to invalidate the config cache\n")
+		return
+	}
+	params := url.Values{}
+	for _, logServer := range logServers {
+		params.Set("log_token", logServer.LogToken)
+		if resp := client.Send(logServer.URL+"/invalidateconfig", "GET", params); resp != "OK" {
+			log.Printf("err: invalidating the config cache of server: %s, response: %s\n", logServer.URL, resp)
+		}
+	}
+}
+
+// reloadBarred - reload the barred telephones of the (review) servers after a barred telephone is added or removed
+// so the change is used straight away rather than on the next reload
+func reloadBarred(c *gin.Context) {
+	logServers, ok := c.MustGet("logServers").([]config.LogServer)
+	if !ok {
+		log.Printf("err: getting log (review) servers from config (and or context) to reload the barred telephones\n")
+		return
+	}
+	params := url.Values{}
+	for _, logServer := range logServers {
+		params.Set("log_token", logServer.LogToken)
+		if resp := client.Send(logServer.URL+"/reloadbarred", "GET", params); resp != "OK" {
+			log.Printf("err: reloading the barred telephones of server: %s, response: %s\n", logServer.URL, resp)
+		}
+	}
+}
+
 // Server - server
 // The server configuration should return a perfect SSL Labs score when using correct certificates for site
 func Server(logFilename string) {
@@ -249,6 +322,58 @@ func SetupRouter(logFilename string, templatesPattern string) *gin.Engine {
 		// fetch stats from stats table
 		auth.GET("/statsnew", StatsNewHandler)
 
+		// fetch tracked short link click through rates
+		auth.GET("/statsclicks", ClickStatsHandler)
+
+		// fetch message events (why was or wasn't a message sent to a telephone)
+		auth.GET("/messageevents", MessageEventsHandler)
+
+		// fetch message variants of a config (A/B testing messages)
+		auth.GET("/variants", GetMessageVariantsHandler)
+		// update message variants of a config
+		auth.PUT("/variants", UpdateMessageVariantsHandler)
+		// promote a message variant to the config message
+		auth.POST("/variantpromote", PromoteMessageVariantHandler)
+		// fetch sends, clicks and opt outs per message variant of a config
+		auth.GET("/variantresults", VariantResultsHandler)
+		// fetch messages by language of a config
+		auth.GET("/configmessages", GetConfigMessagesHandler)
+		// update messages by language of a config
+		auth.PUT("/configmessages", UpdateConfigMessagesHandler)
+		// preview the messages of a config for a language
+		auth.GET("/messagepreview", PreviewMessagesHandler)
+
+		// fetch barred telephones (for all clients and the partner's clients)
+		auth.GET("/barred", BarredTelephonesHandler)
+		// add a barred telephone
+		auth.POST("/barred", AddBarredTelephoneHandler)
+		// remove a barred telephone
+		auth.DELETE("/barred", DeleteBarredTelephoneHandler)
+
+		// fetch fatigue policies (review requests to a passenger across clients, for all clients and the partner's clients)
+		auth.GET("/fatigue", FatiguePoliciesHandler)
+		// add or replace a fatigue policy
+		auth.PUT("/fatigue", SetFatiguePolicyHandler)
+		// remove a fatigue policy
+		auth.DELETE("/fatigue", DeleteFatiguePolicyHandler)
+
+		// find the records of a telephone for all clients (subject access request, data protection partners only)
+		auth.GET("/datasubject", FindDataSubjectHandler)
+		// erase the records of a telephone for all clients (erasure request, data protection partners only)
+		auth.DELETE("/datasubject", EraseDataSubjectHandler)
+
+		// fetch request verification (signing secret and allowed IPs) of a config
+		auth.GET("/verification", GetRequestVerificationHandler)
+		// update request verification of a config
+		auth.PUT("/verification", UpdateRequestVerificationHandler)
+
+		// fetch the generic dispatcher webhook (/hook/<token>) mapping of a config
+		auth.GET("/hookmapping", GetHookMappingHandler)
+		// update the generic dispatcher webhook mapping of a config
+		auth.PUT("/hookmapping", UpdateHookMappingHandler)
+		// extract the fields from a sample payload using a mapping
+		auth.POST("/hookmappingtest", HookMappingTestHandler)
+
 		// send test
 		auth.POST("/sendtest", sendTestHandler)
 
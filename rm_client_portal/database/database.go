@@ -122,9 +122,22 @@ type StatsNewResult struct {
 	ClientName  string `json:"client_name"`  // client name
 	Sent        uint64 `json:"sent"`         // sent
 	Requested   uint64 `json:"requested"`    // requested
+	Delivered   uint64 `json:"delivered"`    // delivered (from the delivery reports)
+	Failed      uint64 `json:"failed"`       // failed or undelivered (from the delivery reports)
 	GroupPeriod string `json:"group_period"` // group period
 }
 
+// ClickStatsResult - represents a tracked short link click through statistic result (per client and message variant).
+type ClickStatsResult struct {
+	ClientID         uint64  `json:"client_id"`          // client id
+	ClientName       string  `json:"client_name"`        // client name
+	Variant          string  `json:"variant"`            // message variant (empty if not a multi message)
+	Sent             uint64  `json:"sent"`               // messages sent with a short link
+	Clicked          uint64  `json:"clicked"`            // messages where the short link was clicked
+	Clicks           uint64  `json:"clicks"`             // total clicks
+	ClickThroughRate float64 `json:"click_through_rate"` // click through rate (clicked / sent)
+}
+
 func GetUser(email, password string) string {
 	qry := "SELECT u.email" +
 		" FROM users AS u" +
@@ -269,10 +282,11 @@ func GetClientIDsForUserEmail(email string) []uint64 {
 
 // ClientStats - get some stats using the stats table
 func ClientStats(statsRequest StatsRequest, clientID int) ([]StatsNewResult, error) {
-	var outerQryStart = "SELECT a.client_id, a.client_name, a.sent, a.requested, a.group_period FROM ("
+	var outerQryStart = "SELECT a.client_id, a.client_name, a.sent, a.requested, a.delivered, a.failed, a.group_period FROM ("
 	var outerQryEnd = ") AS a WHERE a.client_id = ?"
 	var qry = "SELECT g.client_id AS client_id, c.name AS client_name," +
-		" sum(g.sent_count) AS sent,  sum(g.requested_count) AS requested, "
+		" sum(g.sent_count) AS sent,  sum(g.requested_count) AS requested," +
+		" sum(g.delivered_count) AS delivered, sum(g.failed_count) AS failed, "
 	const qryEnd = " AS group_period" +
 		" FROM google_reviews_stats AS g" +
 		" LEFT JOIN clients AS c ON g.client_id = c.id" +
@@ -297,7 +311,7 @@ func ClientStats(statsRequest StatsRequest, clientID int) ([]StatsNewResult, err
 	var statsResult []StatsNewResult
 	for rows.Next() {
 		var s StatsNewResult
-		if err := rows.Scan(&s.ClientID, &s.ClientName, &s.Sent, &s.Requested, &s.GroupPeriod); err != nil {
+		if err := rows.Scan(&s.ClientID, &s.ClientName, &s.Sent, &s.Requested, &s.Delivered, &s.Failed, &s.GroupPeriod); err != nil {
 			log.Printf("Error getting stats results: %v\n", err)
 		}
 		statsResult = append(statsResult, s)
@@ -305,6 +319,39 @@ func ClientStats(statsRequest StatsRequest, clientID int) ([]StatsNewResult, err
 	return statsResult, nil
 }
 
+// ClientClickStats - get the tracked short link click through rates per message variant for a client
+// Only short links for messages that were sent (have a message event) are counted.
+func ClientClickStats(statsRequest StatsRequest, clientID int) ([]ClickStatsResult, error) {
+	const qry = "SELECT l.client_id, c.name, l.variant," +
+		" COUNT(DISTINCT l.id) AS sent, COUNT(DISTINCT k.short_link_id) AS clicked, COUNT(k.id) AS clicks" +
+		" FROM google_reviews_short_links AS l" +
+		" JOIN clients AS c ON c.id = l.client_id" +
+		" LEFT JOIN google_reviews_short_link_clicks AS k ON k.short_link_id = l.id" +
+		" WHERE l.message_event_id > 0" +
+		" AND l.client_id = ?" +
+		" AND l.created BETWEEN ? AND ?" +
+		" GROUP BY l.client_id, c.name, l.variant" +
+		" ORDER BY l.variant"
+	rows, err := Db.Query(qry, clientID, statsRequest.StartDay, statsRequest.EndDay)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+	var clickStatsResult []ClickStatsResult
+	for rows.Next() {
+		var s ClickStatsResult
+		if err := rows.Scan(&s.ClientID, &s.ClientName, &s.Variant, &s.Sent, &s.Clicked, &s.Clicks); err != nil {
+			log.Printf("Error getting click stats results: %v\n", err)
+		}
+		if s.Sent > 0 {
+			s.ClickThroughRate = float64(s.Clicked) / float64(s.Sent)
+		}
+		clickStatsResult = append(clickStatsResult, s)
+	}
+	return clickStatsResult, nil
+}
+
 // GoogleMyBusinessLocationName - get the config required fields for a reviews reporting from the google my business location name and postal code address
 func ConfigFromGoogleMyBusinessLocationNameAndPostalCode(googleMyBusinessLocationName string, googleMyBusinessPostalCode string) GoogleReviewsConfigAndGoogleMyBusinessLocation {
 	qry := "SELECT config.google_my_business_location_name," +
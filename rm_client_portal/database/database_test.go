@@ -153,6 +153,16 @@ func TestClientStats1(t *testing.T) {
 		t.Fatal("error getting client stats, err: ", err)
 	}
 	fmt.Printf("stats: %+v\n", s)
+	// the delivered and failed counts (from the delivery reports) of 40 days ago
+	found := false
+	for _, r := range s {
+		if r.Sent == 20 && r.Requested == 40 {
+			found = r.Delivered == 18 && r.Failed == 2
+		}
+	}
+	if !found {
+		t.Fatalf("expected the delivered and failed counts got: %+v", s)
+	}
 }
 
 func TestClientStats2(t *testing.T) {
@@ -209,6 +219,21 @@ func TestClientStats4(t *testing.T) {
 	fmt.Printf("stats: %+v\n", s)
 }
 
+func TestClientClickStats(t *testing.T) {
+	prepareTestDatabase()
+
+	var statsRequest StatsRequest
+	statsRequest.StartDay = time.Now().Add(-time.Hour * 24 * 7).Format("2006-01-02")
+	statsRequest.EndDay = time.Now().Format("2006-01-02") + " 23:59:59"
+	s, err := ClientClickStats(statsRequest, 1)
+	if err != nil {
+		t.Fatal("error getting client click stats, err: ", err)
+	}
+	if len(s) != 1 || s[0].Variant != "1" || s[0].Sent != 2 || s[0].Clicked != 1 || s[0].Clicks != 1 || s[0].ClickThroughRate != 0.5 {
+		t.Fatalf("unexpected client click stats: %+v", s)
+	}
+}
+
 func TestConfigFromGoogleMyBusinessLocationNameAndPostalCode(t *testing.T) {
 	prepareTestDatabase()
 	googleMyBusinessLocationName := "City Taxis"
@@ -28,6 +28,10 @@
 // HTTPS: curl -k -H 'Accept: application/json' -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/userstats?start_day=2024-11-01&end_day=2024-11-12&time_grouping=day'
 // HTTP:  curl -H 'Accept: application/json' -H "Authorization: Bearer <token>" 'http://localhost:8443/auth/userstats?start_day=2024-11-01&end_day=2024-11-12&time_grouping=day'
 //
+// the click through rates of the tracked short review links can be got using:
+//
+// HTTPS: curl -k -H 'Accept: application/json' -H "Authorization: Bearer <token>" 'https://localhost:8443/auth/userclickstats?start_day=2024-11-01&end_day=2024-11-12'
+//
 // NOTE: decoding the jwt token can be done using jq (install on MacOS using: brew install jq)
 // Decode the token with jq using:
 // echo <token> | jq -R 'split(".") | .[0],.[1] | @base64d | fromjson'
@@ -53,6 +53,34 @@ func StatsUserHandler(c *gin.Context) {
 	})
 }
 
+// ClickStatsUserHandler - retrieve the tracked short link click through rates per client and message variant for a user
+// (the end day is inclusive)
+func ClickStatsUserHandler(c *gin.Context) {
+	success := true
+	var errStr string
+	var stats []database.ClickStatsResult
+	var statsRequest database.StatsRequest
+	statsRequest.StartDay = c.Query("start_day")
+	statsRequest.EndDay = c.Query("end_day") + " 23:59:59"
+	email := getEmailFromJWT(c)
+	clients := database.GetClientsForUserEmail(email)
+	for _, v := range clients {
+		s, err := database.ClientClickStats(statsRequest, int(v.ID))
+		if err != nil {
+			log.Printf("error retrieving click stats list, err: %+v\n", err)
+			errStr = fmt.Sprintf("error retrieving click stats list, error: %+v", err)
+			success = false
+		} else {
+			stats = append(stats, s...)
+		}
+	}
+	c.JSON(200, gin.H{
+		"success": success,
+		"err":     errStr,
+		"stats":   stats,
+	})
+}
+
 // ReportOnReviewsAndInsights - retrieve reviews and insights from Google
 func ReportOnReviewsAndInsights(c *gin.Context) {
 	email := getEmailFromJWT(c)
@@ -103,6 +103,9 @@ func SetupRouter(logFilename string) *gin.Engine {
 		// fetch user stats from stats table
 		auth.GET("/userstats", StatsUserHandler)
 
+		// fetch user tracked short link click through rates
+		auth.GET("/userclickstats", ClickStatsUserHandler)
+
 		// fetch reviews and insights from Google
 		auth.GET("/reviews", ReportOnReviewsAndInsights)
 
@@ -34,6 +34,8 @@ const UserStatsResponseSchema = z.object({
         client_name: z.string(),
         sent: z.number(),
         requested: z.number(),
+        delivered: z.number(),
+        failed: z.number(),
         group_period: z.string(),
       }),
     )
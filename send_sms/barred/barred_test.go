@@ -2,6 +2,8 @@ package barred
 
 import (
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -30,3 +32,34 @@ func TestCheckBarred2(t *testing.T) {
 		t.Fatal("Error telephone should be barred")
 	}
 }
+
+func TestList(t *testing.T) {
+	bars, _ := ReadBarredFile("../config/barred_telephone_prefixes.txt")
+	l := NewList(append(FileEntries(bars), Entry{Telephone: "447123456700", FullNumber: true}))
+	// the barred file starts with 07... and the fetched telephones include the country code
+	if !l.Barred("447418456789", 0) || !l.Barred("447123456700", 0) {
+		t.Fatal("Error telephone should be barred")
+	}
+	if l.Barred("447123456789", 0) || l.Barred("4471234567001", 0) {
+		t.Fatal("Error telephone should not be barred")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("barred_token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[{"client_id":0,"telephone":"447999","full_number":false}]`))
+	}))
+	defer ts.Close()
+
+	entries, err := Fetch(ts.URL+"/barred", "secret")
+	if err != nil || len(entries) != 1 || entries[0].Telephone != "447999" {
+		t.Fatalf("unexpected barred telephones: %+v, err: %v", entries, err)
+	}
+	if _, err := Fetch(ts.URL+"/barred", "wrong"); err == nil {
+		t.Fatal("expected an error fetching with the wrong token")
+	}
+}
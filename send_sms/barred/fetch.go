@@ -0,0 +1,38 @@
+package barred
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// fetchTimeout - timeout fetching the barred telephones
+const fetchTimeout = 10 * time.Second
+
+// Fetch - fetch the barred telephones for all clients from google_reviews (send_sms has no database)
+// e.g. https://reviews.example.com/barred?barred_token=<barred token>
+func Fetch(barredURL string, barredToken string) ([]Entry, error) {
+	u, err := url.Parse(barredURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("barred_token", barredToken)
+	u.RawQuery = q.Encode()
+	c := http.Client{Timeout: fetchTimeout}
+	resp, err := c.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching the barred telephones: %s", resp.Status)
+	}
+	var entries []Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
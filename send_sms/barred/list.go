@@ -0,0 +1,159 @@
+package barred
+
+// list - barred telephone prefixes and full numbers, for all clients (global) and for a client, held in prefix tries
+// that are swapped atomically when the list is reloaded (from the barred file and the database)
+// NOTE: keep in line with the barred package in google_reviews, google_reviews_autocab and send_sms
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Entry - a barred telephone prefix, or a full number when FullNumber, for a client (0 for all clients)
+type Entry struct {
+	ClientID   uint64 `json:"client_id"`
+	Telephone  string `json:"telephone"`
+	FullNumber bool   `json:"full_number"`
+}
+
+// trie - prefix trie of barred telephones
+type trie struct {
+	children map[byte]*trie
+	prefix   bool // a barred prefix ends at the node
+	full     bool // a barred full number ends at the node
+}
+
+// add - add a barred prefix (or full number) to the trie
+func (t *trie) add(telephone string, fullNumber bool) {
+	n := t
+	for i := 0; i < len(telephone); i++ {
+		if n.children == nil {
+			n.children = make(map[byte]*trie)
+		}
+		child, ok := n.children[telephone[i]]
+		if !ok {
+			child = &trie{}
+			n.children[telephone[i]] = child
+		}
+		n = child
+	}
+	if fullNumber {
+		n.full = true
+	} else {
+		n.prefix = true
+	}
+}
+
+// barred - check if the telephone starts with a barred prefix or is a barred full number
+func (t *trie) barred(telephone string) bool {
+	n := t
+	for i := 0; i < len(telephone); i++ {
+		if n.prefix {
+			return true
+		}
+		if n = n.children[telephone[i]]; n == nil {
+			return false
+		}
+	}
+	return n.prefix || n.full
+}
+
+// List - barred telephones for all clients and for each client
+type List struct {
+	global  *trie
+	clients map[uint64]*trie
+	size    int
+}
+
+// NewList - list of the barred telephones (empty telephones are ignored)
+func NewList(entries []Entry) *List {
+	l := &List{global: &trie{}, clients: make(map[uint64]*trie)}
+	for _, e := range entries {
+		if e.Telephone == "" {
+			continue
+		}
+		t := l.global
+		if e.ClientID != 0 {
+			if t = l.clients[e.ClientID]; t == nil {
+				t = &trie{}
+				l.clients[e.ClientID] = t
+			}
+		}
+		t.add(e.Telephone, e.FullNumber)
+		l.size++
+	}
+	return l
+}
+
+// FileEntries - entries for the prefixes read from the barred file (barred for all clients)
+func FileEntries(prefixes []string) []Entry {
+	entries := make([]Entry, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		entries = append(entries, Entry{Telephone: prefix})
+	}
+	return entries
+}
+
+// Barred - check if the telephone is barred for all clients or for the client
+// NOTE: telephone includes country code i.e. 07123456789 => 447123456789, the barred file does not include the
+// country code but starts with 07... so the telephone is also checked as a national number
+func (l *List) Barred(telephone string, clientID uint64) bool {
+	if l == nil {
+		return false
+	}
+	if l.global.barred(telephone) || l.global.barred(strings.Replace(telephone, "447", "07", 1)) {
+		return true
+	}
+	if t, ok := l.clients[clientID]; ok {
+		return t.barred(telephone)
+	}
+	return false
+}
+
+// Len - number of barred telephones in the list
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	return l.size
+}
+
+// current - the list used by the handlers, swapped when reloaded
+var current atomic.Value
+
+// Store - swap in the list
+func Store(l *List) {
+	current.Store(l)
+}
+
+// Load - the current list (nil, so nothing is barred, until a list is stored)
+func Load() *List {
+	l, _ := current.Load().(*List)
+	return l
+}
+
+// Reload - load the entries and swap in the list, the current list is kept when the entries cannot be loaded
+func Reload(load func() ([]Entry, error)) error {
+	entries, err := load()
+	if err != nil {
+		log.Printf("Error loading barred telephones, keeping the current list of %d, err: %v\n", Load().Len(), err)
+		return err
+	}
+	Store(NewList(entries))
+	return nil
+}
+
+// Watch - load the list and reload it every period in the background (a period of 0 or less only loads the list once)
+func Watch(period time.Duration, load func() ([]Entry, error)) {
+	Reload(load)
+	if period <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(period) {
+			Reload(load)
+		}
+	}()
+}
@@ -50,6 +50,11 @@ type Config struct {
 	Country string
 
 	BarredTelephonePrefixFile string
+	// Barred telephones for all clients managed from google_reviews_ui, fetched from google_reviews every period
+	// e.g. https://reviews.example.com/barred (empty to only use the barred file)
+	BarredURL          string
+	BarredToken        string
+	BarredReloadPeriod time.Duration
 
 	ServerPort string
 	ServerCert string
@@ -73,6 +78,10 @@ type Config struct {
 	RateLimiterUpperLimit    int
 	RateLimiterBucketSpan    int
 	RateLimiterIgnore        []string
+
+	// Metrics (/metrics) are available with the metrics token or from the allowed IPs (IPs or CIDRs)
+	MetricsToken      string
+	MetricsAllowedIPs []string
 }
 
 // ReadProperties - read the properties file
@@ -130,6 +139,10 @@ func ReadProperties() Config {
 	config.TokenParameter = viper.Get("token_parameter").(string)
 	config.Country = viper.Get("country").(string)
 	config.BarredTelephonePrefixFile = viper.Get("barred_telephone_prefix_file").(string)
+	config.BarredURL = viper.GetString("barred_url")
+	config.BarredToken = viper.GetString("barred_token")
+	viper.SetDefault("barred_reload_period", 60) // seconds
+	config.BarredReloadPeriod = viper.GetDuration("barred_reload_period") * time.Second
 	config.ServerPort = viper.Get("server_port").(string)
 	config.ServerCert = viper.Get("server_cert").(string)
 	config.ServerKey = viper.Get("server_key").(string)
@@ -173,5 +186,13 @@ func ReadProperties() Config {
 	rateLimiterIgnore := viper.GetString("rate_limiter_ignore")
 	config.RateLimiterIgnore = strings.Split(rateLimiterIgnore, ",")
 
+	// Metrics
+	config.MetricsToken = viper.GetString("metrics_token")
+	for _, ip := range strings.Split(viper.GetString("metrics_allowed_ips"), ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			config.MetricsAllowedIPs = append(config.MetricsAllowedIPs, ip)
+		}
+	}
+
 	return config
 }
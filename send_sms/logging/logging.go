@@ -0,0 +1,219 @@
+package logging
+
+// logging - structured (JSON) logging with stable field names, the output of the standard log package is also
+// written as structured records so the existing log calls are kept (the message is in the msg field)
+// NOTE: keep in line with the logging package in google_reviews, google_reviews_autocab and send_sms
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stable field names of the structured log records
+const (
+	FieldRequestID = "request_id"
+	FieldClientID  = "client_id"
+	FieldEvent     = "event"
+	FieldReason    = "reason"
+)
+
+// events of the structured log records
+const (
+	EventRequest   = "request"    // a request has been handled
+	EventSendError = "send_error" // the message service failed to send a message
+
+	EventVerificationFailed = "verification_failed" // a dispatcher request failed verification (signature or allowed IPs)
+)
+
+// maxLineLength - maximum length of a log line read when scanning the log files
+const maxLineLength = 1024 * 1024
+
+// requestIDHeader - header used to pass a request ID (generated if not sent)
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID - request IDs sent in the request header that are used
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type contextKey struct{}
+
+// Setup - write structured (JSON) log records to w (e.g. the lumberjack rotated log file)
+func Setup(w io.Writer) {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(w, nil)))
+}
+
+// NewRequestID - new random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error generating request ID: %v\n", err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID - context with the request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// RequestID - request ID from the context (empty if not set)
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextKey{}).(string)
+	return requestID
+}
+
+// FromContext - logger with the request ID from the context
+func FromContext(ctx context.Context) *slog.Logger {
+	if requestID := RequestID(ctx); requestID != "" {
+		return slog.Default().With(FieldRequestID, requestID)
+	}
+	return slog.Default()
+}
+
+// statusRecorder - records the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Handler - add a request ID (from the X-Request-ID header or generated) to the request context and the response
+// header, and log a request record when the request has been handled
+func Handler(handler string, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		requestID := req.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = NewRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		sr := &statusRecorder{ResponseWriter: w}
+		req = req.WithContext(WithRequestID(req.Context(), requestID))
+		h.ServeHTTP(sr, req)
+		FromContext(req.Context()).Info("request", FieldEvent, EventRequest, "handler", handler,
+			"status", sr.status, "duration_ms", time.Since(start).Milliseconds())
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// Record - the stable fields of a structured log record
+type Record struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Msg       string    `json:"msg"`
+	RequestID string    `json:"request_id"`
+	ClientID  uint64    `json:"client_id"`
+	Event     string    `json:"event"`
+	Reason    string    `json:"reason"`
+}
+
+// ParseRecord - parse a structured log record, returns false if the line is not a structured record
+// (e.g. a line from a log written before structured logging)
+func ParseRecord(line string) (Record, bool) {
+	var r Record
+	if !strings.HasPrefix(line, "{") {
+		return r, false
+	}
+	if err := json.Unmarshal([]byte(line), &r); err != nil || r.Time.IsZero() {
+		return r, false
+	}
+	return r, true
+}
+
+// LogFiles - the log file and its rotated (lumberjack) backups e.g. google_reviews-2021-09-01T10-00-00.000.log(.gz)
+// written since the time, oldest first
+func LogFiles(logFileName string, since time.Time) []string {
+	ext := filepath.Ext(logFileName)
+	prefix := strings.TrimSuffix(logFileName, ext) + "-"
+	backups, err := filepath.Glob(prefix + "*" + ext + "*")
+	if err != nil {
+		log.Printf("Error finding the rotated log files for: %s, err: %v\n", logFileName, err)
+	}
+	type logFile struct {
+		name    string
+		modTime time.Time
+	}
+	var files []logFile
+	for _, name := range append(backups, logFileName) {
+		if name != logFileName && !strings.HasSuffix(name, ext) && !strings.HasSuffix(name, ext+".gz") {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		// a backup last written before the time cannot contain any records since the time
+		if name != logFileName && fi.ModTime().Before(since) {
+			continue
+		}
+		files = append(files, logFile{name: name, modTime: fi.ModTime()})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// ScanLogFiles - call fn with each line of the log file and its rotated backups written since the time (oldest first)
+func ScanLogFiles(logFileName string, since time.Time, fn func(line string)) error {
+	var lastErr error
+	for _, name := range LogFiles(logFileName, since) {
+		if err := scanLogFile(name, fn); err != nil {
+			log.Printf("Error, reading log file: %s with error: %v\n", name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// scanLogFile - call fn with each line of the log file (gzip compressed if it ends with .gz)
+func scanLogFile(name string, fn func(line string)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		fn(scanner.Text())
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecord(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	logger.Error("Error sending message", FieldRequestID, "abc123", FieldEvent, EventSendError, FieldClientID, uint64(12), FieldReason, "provider_error")
+	r, ok := ParseRecord(strings.TrimSpace(buf.String()))
+	if !ok {
+		t.Fatalf("unable to parse record: %s", buf.String())
+	}
+	if r.RequestID != "abc123" || r.Event != EventSendError || r.ClientID != 12 || r.Reason != "provider_error" || r.Level != "ERROR" || r.Time.IsZero() {
+		t.Fatalf("unexpected record: %+v", r)
+	}
+	if _, ok := ParseRecord("2021/09/01 10:00:00 Error sending message for clientID: 12 to x"); ok {
+		t.Fatal("free text log line should not be parsed as a record")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	var requestID string
+	h := Handler("/test", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestID = RequestID(req.Context())
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/test", nil))
+	if len(requestID) != 16 || rr.Header().Get("X-Request-ID") != requestID {
+		t.Fatalf("unexpected request ID: %s, header: %s", requestID, rr.Header().Get("X-Request-ID"))
+	}
+	r, ok := ParseRecord(strings.TrimSpace(buf.String()))
+	if !ok || r.Event != EventRequest || r.RequestID != requestID || !strings.Contains(buf.String(), `"status":202`) {
+		t.Fatalf("unexpected request record: %s", buf.String())
+	}
+
+	// request ID sent in the header
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Request-ID", "upstream-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if requestID != "upstream-1" {
+		t.Fatalf("expected the request ID from the header got: %s", requestID)
+	}
+}
+
+func TestScanLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	logFileName := filepath.Join(dir, "google_reviews.log")
+	write := func(name string, content string, modTime time.Time) {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write(logFileName, "current\n", now)
+	write(filepath.Join(dir, "google_reviews-2021-09-02T10-00-00.000.log"), "backup2\n", now.Add(-time.Hour))
+	write(filepath.Join(dir, "google_reviews-2021-09-01T10-00-00.000.log"), "old\n", now.Add(-48*time.Hour))
+	write(filepath.Join(dir, "other.log"), "other\n", now)
+
+	// compressed backup
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("backup1\n"))
+	zw.Close()
+	write(filepath.Join(dir, "google_reviews-2021-09-01T20-00-00.000.log.gz"), gz.String(), now.Add(-2*time.Hour))
+
+	var lines []string
+	if err := ScanLogFiles(logFileName, now.Add(-24*time.Hour), func(line string) { lines = append(lines, line) }); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(lines, ",") != "backup1,backup2,current" {
+		t.Fatalf("unexpected lines: %v", lines)
+	}
+}
@@ -10,7 +10,9 @@
 // 		certs/server.rsa.key - or whatever is configured in the config file
 //
 // The barred telephone prefixes file is read on program startup so any changes to this file
-// will require a restart.
+// will require a restart. Barred telephone prefixes and full numbers for all clients, managed from
+// google_reviews_ui, are fetched from google_reviews (barred_url and barred_token in config.properties)
+// every barred_reload_period seconds without a restart.
 //
 // Useful for token generation, use Elixir iex:
 // iex(1)> length = 64
@@ -33,6 +35,7 @@ import (
 
 	"send_sms/barred"
 	"send_sms/config"
+	"send_sms/logging"
 	"send_sms/rate_limiter"
 	"send_sms/server"
 	"send_sms/shared"
@@ -61,12 +64,15 @@ func main() {
 		}
 		defer f.Close()
 		// log.SetOutput(f)
-		log.SetOutput(&lumberjack.Logger{
+		// structured (JSON) log records
+		logging.Setup(&lumberjack.Logger{
 			Filename:   logFilename,
 			MaxSize:    50, // megabytes
 			MaxBackups: 10,
 			MaxAge:     28, //days
 		})
+	} else {
+		logging.Setup(os.Stderr)
 	}
 
 	// read config file
@@ -75,12 +81,14 @@ func main() {
 	// initialise email for each gateway
 	shared.Initialise(len(config.Gateways))
 
-	// read barred telephone numbers file
+	// read barred telephone numbers file, the barred telephones for all clients managed from google_reviews_ui
+	// are fetched from google_reviews every period
 	bars, _ := barred.ReadBarredFile(config.BarredTelephonePrefixFile)
+	server.WatchBarred(config, bars)
 
 	// rate limiter
 	rateLimiterEnabled, rateLimiterRestrictor := rate_limiter.RateLimiter(config)
 
 	// run http server
-	server.Server(config, bars, rateLimiterEnabled, rateLimiterRestrictor)
+	server.Server(config, rateLimiterEnabled, rateLimiterRestrictor)
 }
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"send_sms/barred"
+	"send_sms/config"
+)
+
+// WatchBarred - use the barred telephones from the barred file and those fetched from google_reviews (when the
+// barred URL is configured), fetching them every period
+func WatchBarred(config config.Config, bars []string) {
+	// the barred file is used until the barred telephones are fetched
+	barred.Store(barred.NewList(barred.FileEntries(bars)))
+	if config.BarredURL == "" {
+		return
+	}
+	barred.Watch(config.BarredReloadPeriod, func() ([]barred.Entry, error) {
+		entries, err := barred.Fetch(config.BarredURL, config.BarredToken)
+		if err != nil {
+			return nil, err
+		}
+		return append(barred.FileEntries(bars), entries...), nil
+	})
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+
+	"send_sms/logging"
+	"send_sms/metrics"
+	"send_sms/shared"
+)
+
+var (
+	// requestsTotal - requests by handler and outcome
+	requestsTotal = metrics.NewCounterVec("send_sms_requests_total",
+		"Requests by handler and outcome (success, failed or error).", "handler", "outcome")
+	// requestDuration - time taken to handle a request
+	requestDuration = metrics.NewHistogramVec("send_sms_request_duration_seconds",
+		"Time taken to handle a request.", metrics.DefaultBuckets, "handler")
+)
+
+// instrument - count the requests of the handler by outcome and observe their duration, and log them with a request ID
+func instrument(handler string, h http.Handler) http.Handler {
+	return logging.Handler(handler, metrics.InstrumentHandler(requestsTotal, requestDuration, handler, shared.FailedResponse, h))
+}
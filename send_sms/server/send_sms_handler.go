@@ -13,6 +13,7 @@ import (
 	"send_sms/barred"
 	"send_sms/config"
 	"send_sms/email"
+	"send_sms/logging"
 	"send_sms/shared"
 	"send_sms/smsgateway"
 
@@ -20,8 +21,14 @@ import (
 	"github.com/dongri/phonenumber"
 )
 
+// reasons logged in the structured log records
+const (
+	reasonRateLimited  = "rate_limited"
+	reasonGatewayError = "gateway_error"
+)
+
 // SendSmsHandler - Send SMS Handler
-func SendSmsHandler(config config.Config, bars []string, rateLimiterEnabled bool, rateLimiterRestrictor restrictor.Restrictor) http.Handler {
+func SendSmsHandler(config config.Config, rateLimiterEnabled bool, rateLimiterRestrictor restrictor.Restrictor) http.Handler {
 	fn := func(w http.ResponseWriter, req *http.Request) {
 		if err := req.ParseForm(); err != nil {
 			// fmt.Printf("ParseForm() err: %+v\n", err)
@@ -53,8 +60,8 @@ func SendSmsHandler(config config.Config, bars []string, rateLimiterEnabled bool
 			w.Write(shared.FailedResponse)
 			return
 		}
-		// check barred telephone prefixes
-		if barred.CheckBarred(telephone, bars) {
+		// check barred telephone prefixes and full numbers (from the barred file and google_reviews)
+		if barred.Load().Barred(telephone, 0) {
 			// log.Printf("telephone number is barred\n")
 			w.Write(shared.FailedResponse)
 			return
@@ -63,7 +70,8 @@ func SendSmsHandler(config config.Config, bars []string, rateLimiterEnabled bool
 		// if rateLimiterEnabled && !stringInSlice(telephone, config.RateLimiterIgnore) && rateLimiterRestrictor.LimitReached(telephone) {
 		reached, _ := rateLimiterRestrictor.LimitReached(telephone)
 		if rateLimiterEnabled && !stringInSlice(telephone, config.RateLimiterIgnore) && reached {
-			log.Printf("telephone number %s has been rate limited.\nrequest: %+v\n", telephone, req)
+			logging.FromContext(req.Context()).Warn("telephone number has been rate limited", logging.FieldClientID, config.Tokens[token],
+				logging.FieldReason, reasonRateLimited, "telephone", telephone)
 			w.Write(shared.FailedResponse)
 			return
 		}
@@ -103,6 +111,11 @@ func SendSmsHandler(config config.Config, bars []string, rateLimiterEnabled bool
 				config.SMTPServer, config.SMTPServerPort, config.EmailPassword, config.EmailFrom, config.EmailTo, config.Gateways[h].EmailSubject, config.Gateways[h].EmailMsg)
 		}
 
+		if !bytes.Equal(resp, shared.SuccessResponse) {
+			logging.FromContext(req.Context()).Error("Error sending message", logging.FieldEvent, logging.EventSendError,
+				logging.FieldClientID, config.Tokens[token], logging.FieldReason, reasonGatewayError, "telephone", telephone)
+		}
+
 		// debugging
 		// log.Printf("SendSmsHandler send for telephone %s resp: %+s\n", telephone, resp)
 
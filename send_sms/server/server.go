@@ -7,15 +7,18 @@ import (
 	"time"
 
 	"send_sms/config"
+	"send_sms/metrics"
 
 	"github.com/EagleChen/restrictor"
 )
 
 // Server - Google reviews server
 // The server configuration should return a perfect SSL Labs score when using correct certificates for site
-func Server(config config.Config, bars []string, rateLimiterEnabled bool, rateLimiterRestrictor restrictor.Restrictor) {
+func Server(config config.Config, rateLimiterEnabled bool, rateLimiterRestrictor restrictor.Restrictor) {
 	mux := http.NewServeMux()
-	mux.Handle("/sendsms", SendSmsHandler(config, bars, rateLimiterEnabled, rateLimiterRestrictor))
+	mux.Handle("/sendsms", instrument("/sendsms", SendSmsHandler(config, rateLimiterEnabled, rateLimiterRestrictor)))
+	// metrics (Prometheus)
+	mux.Handle("/metrics", metrics.Handler(config.MetricsToken, config.MetricsAllowedIPs))
 
 	cfg := &tls.Config{
 		MinVersion:               tls.VersionTLS12,
@@ -2,6 +2,7 @@ package smsgateway
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"send_sms/metrics"
 	"send_sms/shared"
 )
 
@@ -39,10 +41,33 @@ type SendMsgResponse struct {
 	ErrorCode string `json:"error_code"`
 }
 
+var (
+	// gatewaySendsTotal - sends by gateway and outcome
+	gatewaySendsTotal = metrics.NewCounterVec("send_sms_gateway_sends_total",
+		"SMS sent through each gateway by outcome (success or failure).", "gateway", "outcome")
+	// gatewaySendDuration - time taken to send a SMS through each gateway
+	gatewaySendDuration = metrics.NewHistogramVec("send_sms_gateway_send_duration_seconds",
+		"Time taken to send a SMS through each gateway.", metrics.DefaultBuckets, "gateway")
+)
+
 // Send - send SMS through gateway
 // returns byte array for use as HTTP response
 // and a boolean to indicate whether an email alert should be sent because gateway has errors
 func Send(gatewayAddress string, gatewayPort string, gatewayPassword string, gatewaySocketTimeout string, tel string, msg string) ([]byte, bool) {
+	start := time.Now()
+	resp, sendEmail := send(gatewayAddress, gatewayPort, gatewayPassword, gatewaySocketTimeout, tel, msg)
+	gateway := gatewayAddress + ":" + gatewayPort
+	gatewaySendDuration.ObserveDuration(time.Since(start), gateway)
+	outcome := "success"
+	if !bytes.Equal(resp, shared.SuccessResponse) {
+		outcome = "failure"
+	}
+	gatewaySendsTotal.Inc(gateway, outcome)
+	return resp, sendEmail
+}
+
+// send - send SMS through gateway (see Send)
+func send(gatewayAddress string, gatewayPort string, gatewayPassword string, gatewaySocketTimeout string, tel string, msg string) ([]byte, bool) {
 	// debugging
 	// log.Printf("sms_gateway.Send tel: %s, msg: %s\n", tel, msg)
 	socketTimeout, err := strconv.Atoi(gatewaySocketTimeout)